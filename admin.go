@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Rotas administrativas da plataforma (/api/admin)
+// ================================================================
+//
+// Uso interno do operador. Protegidas por ADMIN_IP_ALLOWLIST (opcional) e
+// pelo header X-Admin-Token, comparado com ADMIN_TOKEN. Sem ADMIN_TOKEN
+// configurado, o grupo inteiro responde 503.
+
+func (a *App) mountAdmin(r chi.Router) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(a.ipAllowlist("admin", "ADMIN_IP_ALLOWLIST"))
+		r.Use(requireAdminToken)
+
+		r.Get("/ip-rejections", a.adminIPRejections)
+		r.Put("/orgs/{org_id}/ai-budget", a.adminSetAIBudget)
+		r.Get("/orgs/health", a.adminTenantHealth) // tenant_health.go
+		r.Get("/orgs/{org_id}/health", a.adminOrgHealth)
+		r.Get("/jobs", a.adminListJobs) // jobs.go
+		r.Post("/jobs/{id}/retry", a.adminRetryJob)
+		r.Get("/schema/deployments", a.adminSchemaDeployments) // schema_audit.go
+		r.Get("/schema/changes", a.adminSchemaChanges)
+		r.Get("/provider-usage", a.adminProviderUsage)                         // provider_quota.go
+		r.Post("/wa-instances/{instance}/transfer", a.adminTransferWAInstance) // wa_instance_transfer.go
+		r.Post("/products/purge", a.adminPurgeProducts)                        // product_archive.go
+		a.mountRuntimeDebug(r)                                                 // runtime_debug.go
+		r.Get("/db/queries", a.adminDBQueries)                                 // db_trace.go
+		r.Delete("/db/queries", a.adminResetDBQueries)
+	})
+
+	if every := providerQuotaCheckInterval(); every > 0 {
+		go a.providerQuotaLoop(every)
+	}
+}
+
+func requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := os.Getenv("ADMIN_TOKEN")
+		if secret == "" {
+			render.Error(w, http.StatusServiceUnavailable, "admin api disabled (ADMIN_TOKEN not set)")
+			return
+		}
+		got := headerTrim(r, "X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			render.Error(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /api/admin/ip-rejections?scope=&limit=
+func (a *App) adminIPRejections(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := a.DB.Query(r.Context(), `
+		SELECT id, scope, COALESCE(org_id,0), COALESCE(ip,''), COALESCE(method,''), COALESCE(path,''), created_at
+		  FROM ip_rejections
+		 WHERE ($1 = '' OR scope = $1)
+		 ORDER BY created_at DESC
+		 LIMIT $2`, r.URL.Query().Get("scope"), limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	type rejection struct {
+		ID        int64     `json:"id"`
+		Scope     string    `json:"scope"`
+		OrgID     int64     `json:"org_id,omitempty"`
+		IP        string    `json:"ip"`
+		Method    string    `json:"method"`
+		Path      string    `json:"path"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	out := []rejection{}
+	for rows.Next() {
+		var x rejection
+		if err := rows.Scan(&x.ID, &x.Scope, &x.OrgID, &x.IP, &x.Method, &x.Path, &x.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, x)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
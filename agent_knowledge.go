@@ -0,0 +1,886 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/llm"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Base de conhecimento do agente (RAG)
+// ================================================================
+//
+// A org envia documentos (políticas de troca, prazos, FAQ...) que são
+// divididos em trechos de ~KNOWLEDGE_CHUNK_CHARS caracteres (padrão 1200, com
+// 200 de sobreposição) e indexados com embeddings pelo job knowledge.embed
+// (EMBEDDING_PROVIDER/EMBEDDING_MODEL, llm/embed.go). A cada mensagem do chat
+// os KNOWLEDGE_TOP_K (padrão 4) trechos mais parecidos com a pergunta entram
+// no prompt de sistema.
+//
+// POST   /api/agent/knowledge         (admin) multipart file=.pdf|.txt|.md
+//                                      ou JSON {title, content}
+//                                      ou JSON {title, faq:[{question, answer}]}
+//                                      flow_id opcional (padrão: todos os flows)
+// GET    /api/agent/knowledge         documentos da org e status
+// DELETE /api/agent/knowledge/{id}    (admin)
+// GET    /api/agent/knowledge/search?q=&flow_id=   teste da recuperação
+//
+// Com a extensão pgvector a busca é feita no banco (embedding_vec <=>); sem
+// ela, a similaridade de cosseno é calculada aqui sobre até
+// KNOWLEDGE_SCAN_MAX (padrão 5000) trechos da org. PDFs só com imagem ou com
+// fontes codificadas não têm texto extraível e são recusados com 422.
+
+const (
+	jobKnowledgeEmbed = "knowledge.embed"
+
+	knowledgeStatusProcessing = "processing"
+	knowledgeStatusReady      = "ready"
+	knowledgeStatusFailed     = "failed"
+
+	knowledgeMaxBytes  = 10 << 20
+	knowledgeMaxChunks = 2000
+	knowledgeBatch     = 64
+)
+
+// knowledgeVectorEnabled é definido na montagem (pgvector disponível).
+var knowledgeVectorEnabled bool
+
+type knowledgeDoc struct {
+	ID        int64     `json:"id"`
+	FlowID    *int64    `json:"flow_id"`
+	Title     string    `json:"title"`
+	Kind      string    `json:"kind"`
+	Filename  string    `json:"filename,omitempty"`
+	Status    string    `json:"status"`
+	Chunks    int       `json:"chunks"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type knowledgePassage struct {
+	DocID    int64   `json:"doc_id"`
+	Title    string  `json:"title"`
+	Position int     `json:"position"`
+	Content  string  `json:"content"`
+	Score    float64 `json:"score"`
+}
+
+func (a *App) mountAgentKnowledge(r chi.Router) {
+	a.detectKnowledgeVector(context.Background()) // coluna em migrations/0061
+	registerJob(jobKnowledgeEmbed, jobPolicy{MaxAttempts: 5, Timeout: 5 * time.Minute}, a.runKnowledgeEmbed)
+	admin := a.requireRole(roleAdmin)
+	r.Route("/agent/knowledge", func(r chi.Router) {
+		r.Get("/", a.listKnowledge)
+		r.Get("/search", a.searchKnowledge)
+		r.With(admin).Post("/", a.createKnowledge)
+		r.With(admin).Delete("/{id}", a.deleteKnowledge)
+	})
+}
+
+// detectKnowledgeVector liga knowledgeVectorEnabled se a migração conseguiu
+// criar embedding_vec (pgvector instalado).
+func (a *App) detectKnowledgeVector(ctx context.Context) {
+	err := a.DB.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM information_schema.columns
+                WHERE table_schema='public' AND table_name='agent_knowledge_chunks' AND column_name='embedding_vec')`).Scan(&knowledgeVectorEnabled)
+	if err != nil {
+		log.Printf("knowledge embedding_vec: %v", err)
+	}
+	if !knowledgeVectorEnabled {
+		log.Printf("pgvector unavailable, knowledge search will scan in memory")
+	}
+}
+
+var (
+	knowledgeEmbedderOnce sync.Once
+	knowledgeEmbedderVal  llm.Embedder
+	knowledgeEmbedderErr  error
+)
+
+func knowledgeEmbedder() (llm.Embedder, error) {
+	knowledgeEmbedderOnce.Do(func() {
+		knowledgeEmbedderVal, knowledgeEmbedderErr = llm.EmbedderFromEnv()
+	})
+	return knowledgeEmbedderVal, knowledgeEmbedderErr
+}
+
+func knowledgeEnvInt(env string, def, lo int) int {
+	n, err := strconv.Atoi(getenv(env, strconv.Itoa(def)))
+	if err != nil || n < lo {
+		return def
+	}
+	return n
+}
+
+// ---------------- upload ----------------
+
+type knowledgeFAQ struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// POST /api/agent/knowledge
+func (a *App) createKnowledge(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, knowledgeMaxBytes+(1<<20))
+
+	var (
+		doc    = knowledgeDoc{Status: knowledgeStatusProcessing}
+		chunks []string
+		flowID int64
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid multipart form (max 10MB): "+err.Error())
+			return
+		}
+		file, hdr, err := r.FormFile("file")
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, "file required")
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		doc.Filename = path.Base(hdr.Filename)
+		doc.Title = nonEmpty(strings.TrimSpace(r.FormValue("title")), strings.TrimSuffix(doc.Filename, path.Ext(doc.Filename)))
+		flowID = int64(mustAtoi(r.FormValue("flow_id")))
+		var text string
+		switch ext := strings.ToLower(path.Ext(doc.Filename)); {
+		case ext == ".pdf" || bytes.HasPrefix(data, []byte("%PDF-")):
+			doc.Kind = "pdf"
+			text = pdfText(data)
+			if !readableText(text) {
+				render.Error(w, http.StatusUnprocessableEntity, "could not extract text from PDF (scanned or encoded fonts); upload it as .txt")
+				return
+			}
+		case ext == ".txt" || ext == ".md" || ext == "":
+			if !utf8.Valid(data) {
+				render.Error(w, http.StatusBadRequest, "text file must be UTF-8")
+				return
+			}
+			doc.Kind = "txt"
+			text = string(data)
+		default:
+			render.Error(w, http.StatusBadRequest, "unsupported file type (use .pdf, .txt or .md)")
+			return
+		}
+		chunks = chunkText(text, knowledgeEnvInt("KNOWLEDGE_CHUNK_CHARS", 1200, 200), 200)
+	} else {
+		var in struct {
+			Title   string         `json:"title"`
+			Content string         `json:"content"`
+			FAQ     []knowledgeFAQ `json:"faq"`
+			FlowID  int64          `json:"flow_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+			return
+		}
+		doc.Title, flowID = strings.TrimSpace(in.Title), in.FlowID
+		switch {
+		case len(in.FAQ) > 0:
+			doc.Kind = "faq"
+			// cada pergunta vira um trecho inteiro, para não separar pergunta e resposta
+			for _, f := range in.FAQ {
+				q, ans := strings.TrimSpace(f.Question), strings.TrimSpace(f.Answer)
+				if q == "" || ans == "" {
+					render.Error(w, http.StatusBadRequest, "faq entries need question and answer")
+					return
+				}
+				chunks = append(chunks, "P: "+q+"\nR: "+ans)
+			}
+			doc.Title = nonEmpty(doc.Title, "FAQ")
+		case strings.TrimSpace(in.Content) != "":
+			doc.Kind = "txt"
+			chunks = chunkText(in.Content, knowledgeEnvInt("KNOWLEDGE_CHUNK_CHARS", 1200, 200), 200)
+		default:
+			render.Error(w, http.StatusBadRequest, "content, faq or file required")
+			return
+		}
+		if doc.Title == "" {
+			render.Error(w, http.StatusBadRequest, "title required")
+			return
+		}
+	}
+	if len(chunks) == 0 {
+		render.Error(w, http.StatusBadRequest, "document has no text")
+		return
+	}
+	if len(chunks) > knowledgeMaxChunks {
+		render.Error(w, http.StatusBadRequest, fmt.Sprintf("document too large (max %d chunks)", knowledgeMaxChunks))
+		return
+	}
+	if _, err := knowledgeEmbedder(); err != nil {
+		render.Error(w, http.StatusServiceUnavailable, "embeddings not configured: "+err.Error())
+		return
+	}
+	if flowID > 0 {
+		var ok bool
+		if err := a.DB.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM flows WHERE id=$1 AND org_id=$2)`,
+			flowID, c.OrgID).Scan(&ok); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !ok {
+			render.Error(w, http.StatusBadRequest, "flow not found")
+			return
+		}
+		doc.FlowID = &flowID
+	}
+	doc.Chunks = len(chunks)
+
+	tx, err := a.DB.Begin(r.Context())
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(r.Context())
+	err = tx.QueryRow(r.Context(), `
+INSERT INTO public.agent_knowledge_docs (org_id, flow_id, title, kind, filename, status, chunks, created_by)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), $6, $7, $8) RETURNING id, created_at, updated_at`,
+		c.OrgID, doc.FlowID, doc.Title, doc.Kind, doc.Filename, doc.Status, doc.Chunks, c.UserID).
+		Scan(&doc.ID, &doc.CreatedAt, &doc.UpdatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rows := make([][]any, len(chunks))
+	for i, ch := range chunks {
+		rows[i] = []any{doc.ID, c.OrgID, i, ch}
+	}
+	if _, err := tx.CopyFrom(r.Context(), pgx.Identifier{"public", "agent_knowledge_chunks"},
+		[]string{"doc_id", "org_id", "position", "content"}, pgx.CopyFromRows(rows)); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := a.enqueueJob(r.Context(), c.OrgID, jobKnowledgeEmbed, knowledgeEmbedJob{DocID: doc.ID}, time.Time{}); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Accepted(w, map[string]any{"document": doc})
+}
+
+// GET /api/agent/knowledge
+func (a *App) listKnowledge(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, flow_id, title, kind, COALESCE(filename,''), status, chunks, COALESCE(error,''), created_at, updated_at
+  FROM public.agent_knowledge_docs WHERE org_id=$1 ORDER BY id DESC`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []knowledgeDoc{}
+	for rows.Next() {
+		var d knowledgeDoc
+		if err := rows.Scan(&d.ID, &d.FlowID, &d.Title, &d.Kind, &d.Filename, &d.Status, &d.Chunks, &d.Error,
+			&d.CreatedAt, &d.UpdatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, d)
+	}
+	render.OK(w, map[string]any{"items": out, "vector_search": knowledgeVectorEnabled})
+}
+
+// DELETE /api/agent/knowledge/{id}
+func (a *App) deleteKnowledge(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `DELETE FROM public.agent_knowledge_docs WHERE id=$1 AND org_id=$2`, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "document not found")
+		return
+	}
+	render.NoContent(w)
+}
+
+// GET /api/agent/knowledge/search?q=&flow_id=
+func (a *App) searchKnowledge(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		render.Error(w, http.StatusBadRequest, "q required")
+		return
+	}
+	if f := int64(mustAtoi(r.URL.Query().Get("flow_id"))); f > 0 {
+		flowID = f
+	}
+	if !a.requireAIBudget(w, r, orgID) {
+		return
+	}
+	items, err := a.retrieveKnowledge(r.Context(), orgID, flowID, q)
+	if err != nil {
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"items": items})
+}
+
+// ---------------- indexação ----------------
+
+type knowledgeEmbedJob struct {
+	DocID int64 `json:"doc_id"`
+}
+
+// runKnowledgeEmbed gera os embeddings dos trechos ainda sem vetor, em lotes,
+// e marca o documento como pronto. Repetir o job continua de onde parou.
+func (a *App) runKnowledgeEmbed(ctx context.Context, j job) error {
+	var p knowledgeEmbedJob
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	var orgID int64
+	var flowID *int64
+	err := a.DB.QueryRow(ctx, `SELECT org_id, flow_id FROM public.agent_knowledge_docs WHERE id=$1`, p.DocID).Scan(&orgID, &flowID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil // removido antes da indexação
+	}
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		a.setKnowledgeStatus(p.DocID, knowledgeStatusFailed, err.Error())
+		return permanentJobError(err)
+	}
+	emb, err := knowledgeEmbedder()
+	if err != nil {
+		return fail(err)
+	}
+	if exceeded, _, _ := a.aiBudgetExceeded(ctx, orgID); exceeded {
+		return fail(errors.New("monthly AI budget exceeded"))
+	}
+	var flow int64
+	if flowID != nil {
+		flow = *flowID
+	}
+	for {
+		rows, err := a.DB.Query(ctx, `
+SELECT id, content FROM public.agent_knowledge_chunks
+ WHERE doc_id=$1 AND (embedding IS NULL OR embedding_model IS DISTINCT FROM $2)
+ ORDER BY position LIMIT $3`, p.DocID, emb.Model(), knowledgeBatch)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		var texts []string
+		for rows.Next() {
+			var id int64
+			var t string
+			if err := rows.Scan(&id, &t); err != nil {
+				rows.Close()
+				return err
+			}
+			ids, texts = append(ids, id), append(texts, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		vecs, usage, err := emb.Embed(ctx, texts)
+		a.recordAIUsage(orgID, flow, "knowledge", emb.Model(), usage)
+		if err != nil {
+			if j.Attempts >= j.MaxAttempts {
+				a.setKnowledgeStatus(p.DocID, knowledgeStatusFailed, err.Error())
+			}
+			return err
+		}
+		batch := &pgx.Batch{}
+		for i, id := range ids {
+			if len(vecs[i]) == 0 {
+				return fmt.Errorf("empty embedding for chunk %d", id)
+			}
+			batch.Queue(`UPDATE public.agent_knowledge_chunks SET embedding=$2, embedding_model=$3 WHERE id=$1`,
+				id, vecs[i], emb.Model())
+		}
+		if err := a.DB.SendBatch(ctx, batch).Close(); err != nil {
+			return err
+		}
+	}
+	if knowledgeVectorEnabled {
+		if _, err := a.DB.Exec(ctx, `
+UPDATE public.agent_knowledge_chunks SET embedding_vec = embedding::vector WHERE doc_id=$1`, p.DocID); err != nil {
+			return err
+		}
+	}
+	a.setKnowledgeStatus(p.DocID, knowledgeStatusReady, "")
+	return nil
+}
+
+func (a *App) setKnowledgeStatus(docID int64, status, msg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.DB.Exec(ctx, `
+UPDATE public.agent_knowledge_docs SET status=$2, error=NULLIF($3,''), updated_at=NOW() WHERE id=$1`,
+		docID, status, limitRunes(msg, 500)); err != nil {
+		log.Printf("knowledge doc %d: %v", docID, err)
+	}
+}
+
+// ---------------- recuperação ----------------
+
+// knowledgeContext devolve o bloco de contexto para o prompt de sistema com
+// os trechos mais relevantes para a mensagem; "" sem base de conhecimento
+// ou em caso de falha (o chat segue sem ela).
+func (a *App) knowledgeContext(ctx context.Context, orgID, flowID int64, query string) string {
+	if orgID <= 0 || strings.TrimSpace(query) == "" {
+		return ""
+	}
+	var has bool
+	if err := a.DB.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM public.agent_knowledge_docs
+                WHERE org_id=$1 AND status='ready' AND (flow_id IS NULL OR flow_id=$2))`, orgID, flowID).Scan(&has); err != nil || !has {
+		return ""
+	}
+	items, err := a.retrieveKnowledge(ctx, orgID, flowID, query)
+	if err != nil {
+		log.Printf("knowledge org=%d: %v", orgID, err)
+		return ""
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Base de conhecimento da empresa. Use estes trechos para responder quando forem relevantes; ")
+	b.WriteString("se a resposta não estiver neles, não invente.\n")
+	for _, it := range items {
+		fmt.Fprintf(&b, "\n[%s]\n%s\n", it.Title, it.Content)
+	}
+	return b.String()
+}
+
+// withKnowledge acrescenta ao prompt de sistema da requisição os trechos da
+// base de conhecimento relevantes para a mensagem.
+func (a *App) withKnowledge(ctx context.Context, in *chatReq, orgID, flowID int) {
+	kb := a.knowledgeContext(ctx, int64(orgID), int64(flowID), in.Message)
+	if kb == "" {
+		return
+	}
+	if s := strings.TrimSpace(in.System); s != "" {
+		kb = s + "\n\n" + kb
+	}
+	in.System = kb
+}
+
+// retrieveKnowledge busca os KNOWLEDGE_TOP_K trechos prontos mais parecidos
+// com q (cosseno >= KNOWLEDGE_MIN_SCORE, padrão 0.25).
+func (a *App) retrieveKnowledge(ctx context.Context, orgID, flowID int64, q string) ([]knowledgePassage, error) {
+	emb, err := knowledgeEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	vecs, usage, err := emb.Embed(ctx, []string{q})
+	a.recordAIUsage(orgID, flowID, "knowledge", emb.Model(), usage)
+	if err != nil {
+		return nil, err
+	}
+	qv := vecs[0]
+	topK := knowledgeEnvInt("KNOWLEDGE_TOP_K", 4, 1)
+	minScore, err := strconv.ParseFloat(getenv("KNOWLEDGE_MIN_SCORE", "0.25"), 64)
+	if err != nil {
+		minScore = 0.25
+	}
+
+	const where = `
+  FROM public.agent_knowledge_chunks c
+  JOIN public.agent_knowledge_docs d ON d.id = c.doc_id
+ WHERE c.org_id=$1 AND d.status='ready' AND (d.flow_id IS NULL OR d.flow_id=$2)
+   AND c.embedding_model = $3`
+	out := []knowledgePassage{}
+	if knowledgeVectorEnabled {
+		rows, err := a.DB.Query(ctx, `
+SELECT c.doc_id, d.title, c.position, c.content, (1 - (c.embedding_vec <=> $4::real[]::vector))::float8 AS score`+where+`
+   AND c.embedding_vec IS NOT NULL
+ ORDER BY c.embedding_vec <=> $4::real[]::vector LIMIT $5`, orgID, flowID, emb.Model(), qv, topK)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p knowledgePassage
+			if err := rows.Scan(&p.DocID, &p.Title, &p.Position, &p.Content, &p.Score); err != nil {
+				return nil, err
+			}
+			if p.Score >= minScore {
+				out = append(out, p)
+			}
+		}
+		return out, rows.Err()
+	}
+
+	rows, err := a.DB.Query(ctx, `
+SELECT c.doc_id, d.title, c.position, c.content, c.embedding`+where+`
+   AND c.embedding IS NOT NULL
+ ORDER BY c.id DESC LIMIT $4`, orgID, flowID, emb.Model(), knowledgeEnvInt("KNOWLEDGE_SCAN_MAX", 5000, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p knowledgePassage
+		var v []float32
+		if err := rows.Scan(&p.DocID, &p.Title, &p.Position, &p.Content, &v); err != nil {
+			return nil, err
+		}
+		if p.Score = cosine(qv, v); p.Score >= minScore {
+			out = append(out, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if len(out) > topK {
+		out = out[:topK]
+	}
+	return out, nil
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// ---------------- texto ----------------
+
+// chunkText divide o texto em trechos de até size runas, cortando de
+// preferência em quebra de parágrafo/linha ou espaço, com overlap runas
+// repetidas entre um trecho e o seguinte.
+func chunkText(s string, size, overlap int) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.Join(strings.Fields(l), " ")
+	}
+	s = strings.TrimSpace(strings.Join(lines, "\n"))
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+	r := []rune(s)
+	var out []string
+	for start := 0; start < len(r); {
+		end := min(start+size, len(r))
+		if end < len(r) {
+			end = chunkCut(r, start+size*2/3, end)
+		}
+		if ch := strings.TrimSpace(string(r[start:end])); ch != "" {
+			out = append(out, ch)
+		}
+		if end == len(r) {
+			break
+		}
+		next := end - overlap
+		if next <= start {
+			next = end
+		}
+		// recomeça no início de uma palavra
+		for next < end && !unicode.IsSpace(r[next-1]) {
+			next++
+		}
+		start = next
+	}
+	return out
+}
+
+// chunkCut escolhe onde cortar entre lo e hi: parágrafo, linha, fim de frase
+// ou espaço, nessa ordem; sem nenhum, corta em hi.
+func chunkCut(r []rune, lo, hi int) int {
+	for _, sep := range []func(i int) bool{
+		func(i int) bool { return r[i-1] == '\n' && i >= 2 && r[i-2] == '\n' },
+		func(i int) bool { return r[i-1] == '\n' },
+		func(i int) bool { return unicode.IsSpace(r[i-1]) && i >= 2 && strings.ContainsRune(".!?", r[i-2]) },
+		func(i int) bool { return unicode.IsSpace(r[i-1]) },
+	} {
+		for i := hi; i > lo && i >= 2; i-- {
+			if sep(i) {
+				return i
+			}
+		}
+	}
+	return hi
+}
+
+// readableText recusa extrações vazias ou dominadas por lixo (fontes com
+// codificação própria produzem bytes sem sentido).
+func readableText(s string) bool {
+	var letters, total int
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsPunct(r) {
+			letters++
+		}
+	}
+	return total >= 20 && float64(letters)/float64(total) >= 0.8
+}
+
+// pdfText extrai o texto de um PDF: descompacta os streams (FlateDecode ou
+// sem filtro) e lê os operadores de texto (Tj, TJ, ', "). Cobre PDFs gerados
+// por editores com fontes padrão; não faz OCR nem decodifica fontes CID.
+func pdfText(data []byte) string {
+	var out strings.Builder
+	for rest := data; ; {
+		i := bytes.Index(rest, []byte("stream"))
+		if i < 0 {
+			break
+		}
+		dict := rest[max(0, i-400):i]
+		if d := bytes.LastIndex(dict, []byte("<<")); d >= 0 {
+			dict = dict[d:]
+		}
+		body := rest[i+len("stream"):]
+		if bytes.HasPrefix(body, []byte("\r\n")) {
+			body = body[2:]
+		} else if bytes.HasPrefix(body, []byte("\n")) {
+			body = body[1:]
+		}
+		e := bytes.Index(body, []byte("endstream"))
+		if e < 0 {
+			break
+		}
+		rest = body[e+len("endstream"):]
+		if !bytes.HasPrefix(bytes.TrimSpace(dict), []byte("<<")) || bytes.Contains(dict, []byte("/Image")) ||
+			bytes.Contains(dict, []byte("/FontFile")) || bytes.Contains(dict, []byte("/Length1")) || bytes.Contains(dict, []byte("/XRef")) {
+			continue
+		}
+		content := body[:e]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			content, err = io.ReadAll(io.LimitReader(zr, 20<<20))
+			zr.Close()
+			if err != nil && len(content) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue // outros filtros (DCT, LZW...) não carregam texto legível
+		}
+		pdfContentText(content, &out)
+	}
+	return out.String()
+}
+
+// pdfContentText interpreta um content stream e escreve o texto em out.
+func pdfContentText(b []byte, out *strings.Builder) {
+	var pending strings.Builder
+	newline := func() {
+		if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	inArray := false
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '%':
+			for i < len(b) && b[i] != '\n' && b[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteral(b[i:])
+			pending.WriteString(s)
+			i += n
+		case c == '<' && i+1 < len(b) && b[i+1] == '<':
+			i += 2
+		case c == '<':
+			j := bytes.IndexByte(b[i:], '>')
+			if j < 0 {
+				return
+			}
+			pending.WriteString(pdfHex(b[i+1 : i+j]))
+			i += j + 1
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(b) && (b[j] == '.' || (b[j] >= '0' && b[j] <= '9')) {
+				j++
+			}
+			// deslocamento grande dentro de TJ costuma ser espaço entre palavras
+			if n, err := strconv.ParseFloat(string(b[i:j]), 64); err == nil && inArray && n < -200 {
+				pending.WriteByte(' ')
+			}
+			i = j
+		case c == '\'' || c == '"' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+			j := i + 1
+			for j < len(b) && ((b[j] >= 'A' && b[j] <= 'Z') || (b[j] >= 'a' && b[j] <= 'z') || b[j] == '*') {
+				j++
+			}
+			switch op := string(b[i:j]); op {
+			case "Tj", "TJ":
+				out.WriteString(pending.String())
+			case "'", `"`:
+				newline()
+				out.WriteString(pending.String())
+			case "Td", "TD", "T*", "Tm", "ET":
+				newline()
+			}
+			if c == '\'' || c == '"' {
+				j = i + 1
+			}
+			pending.Reset()
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+// pdfLiteral lê uma string literal "(...)" (com parênteses aninhados e
+// escapes) e devolve o texto (bytes como Latin-1) e quantos bytes consumiu.
+func pdfLiteral(b []byte) (string, int) {
+	var s strings.Builder
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '\\' && i+1 < len(b):
+			i++
+			switch e := b[i]; e {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r', 't', 'b', 'f':
+				s.WriteByte(' ')
+			case '\r', '\n':
+				// continuação de linha
+			default:
+				if e >= '0' && e <= '7' {
+					n, k := 0, 0
+					for ; k < 3 && i+k < len(b) && b[i+k] >= '0' && b[i+k] <= '7'; k++ {
+						n = n*8 + int(b[i+k]-'0')
+					}
+					i += k - 1
+					s.WriteRune(rune(n & 0xff))
+				} else {
+					s.WriteRune(rune(e))
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				s.WriteByte('(')
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return s.String(), i + 1
+			}
+			s.WriteByte(')')
+		default:
+			s.WriteRune(rune(c))
+		}
+	}
+	return s.String(), len(b)
+}
+
+// pdfHex decodifica uma string hexadecimal "<...>" (Latin-1, ou UTF-16BE com
+// BOM).
+func pdfHex(h []byte) string {
+	var raw []byte
+	var hi, n int
+	for _, c := range h {
+		var v int
+		switch {
+		case c >= '0' && c <= '9':
+			v = int(c - '0')
+		case c >= 'a' && c <= 'f':
+			v = int(c-'a') + 10
+		case c >= 'A' && c <= 'F':
+			v = int(c-'A') + 10
+		default:
+			continue
+		}
+		if n%2 == 0 {
+			hi = v
+		} else {
+			raw = append(raw, byte(hi<<4|v))
+		}
+		n++
+	}
+	if n%2 == 1 {
+		raw = append(raw, byte(hi<<4))
+	}
+	var s strings.Builder
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		for i := 2; i+1 < len(raw); i += 2 {
+			s.WriteRune(rune(raw[i])<<8 | rune(raw[i+1]))
+		}
+		return s.String()
+	}
+	for _, c := range raw {
+		s.WriteRune(rune(c))
+	}
+	return s.String()
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/llm"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Personas do agente (biblioteca por org)
+// ================================================================
+//
+// GET    /api/agent/personas              lista as personas da org
+// POST   /api/agent/personas              cria
+// GET    /api/agent/personas/{id}
+// PUT    /api/agent/personas/{id}
+// DELETE /api/agent/personas/{id}         flows/instâncias voltam ao padrão
+// POST   /api/agent/personas/{id}/clone   {"name":"suporte 2","flow_id":3}
+// PUT    /api/agent/persona               {"persona_id":1,"instance_id":""}
+//
+// A persona efetiva de uma conversa é a da instância; sem ela, a do flow;
+// sem nenhuma, os campos de agent_settings do flow (handlers_agent_config.go).
+// Assim um mesmo cliente roda, por exemplo, "vendas" e "suporte" em números
+// diferentes com tons diferentes.
+
+type agentPersona struct {
+	ID                 int64     `json:"id"`
+	OrgID              int64     `json:"org_id"`
+	Name               string    `json:"name"`
+	CommunicationStyle string    `json:"communicationStyle"`
+	Sector             string    `json:"sector"`
+	ProfileType        string    `json:"profileType"`
+	ProfileCustom      string    `json:"profileCustom"`
+	BasePrompt         string    `json:"basePrompt"`
+	LLMProvider        string    `json:"llmProvider"`
+	LLMModel           string    `json:"llmModel"`
+	ClonedFrom         *int64    `json:"clonedFrom,omitempty"`
+	Flows              []int64   `json:"flows"`
+	Instances          []string  `json:"instances"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+var errPersonaNotFound = errors.New("persona not found")
+
+const personaColumns = `p.id, p.org_id, p.name, COALESCE(p.communication_style,''), COALESCE(p.sector,''),
+       COALESCE(p.profile_type,''), COALESCE(p.profile_custom,''), COALESCE(p.base_prompt,''),
+       COALESCE(p.llm_provider,''), COALESCE(p.llm_model,''), p.cloned_from, p.created_at, p.updated_at,
+       COALESCE((SELECT array_agg(s.flow_id ORDER BY s.flow_id) FROM agent_settings s WHERE s.persona_id = p.id), '{}'),
+       COALESCE((SELECT array_agg(i.instance_id ORDER BY i.instance_id) FROM public.wa_instances i
+                  WHERE i.persona_id = p.id AND i.deleted_at IS NULL), '{}')`
+
+func scanPersona(row pgx.Row) (agentPersona, error) {
+	var p agentPersona
+	err := row.Scan(&p.ID, &p.OrgID, &p.Name, &p.CommunicationStyle, &p.Sector,
+		&p.ProfileType, &p.ProfileCustom, &p.BasePrompt,
+		&p.LLMProvider, &p.LLMModel, &p.ClonedFrom, &p.CreatedAt, &p.UpdatedAt,
+		&p.Flows, &p.Instances)
+	return p, err
+}
+
+func (a *App) mountAgentPersonas(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.Route("/agent/personas", func(r chi.Router) {
+		r.Get("/", a.listPersonas)
+		r.Get("/{id}", a.getPersona)
+		r.With(admin).Post("/", a.createPersona)
+		r.With(admin).Put("/{id}", a.updatePersona)
+		r.With(admin).Delete("/{id}", a.deletePersona)
+		r.With(admin).Post("/{id}/clone", a.clonePersona)
+	})
+	r.With(admin).Put("/agent/persona", a.assignPersona)
+}
+
+func (a *App) loadPersona(ctx context.Context, orgID, id int64) (agentPersona, error) {
+	p, err := scanPersona(a.DB.QueryRow(ctx,
+		`SELECT `+personaColumns+` FROM agent_personas p WHERE p.id=$1 AND p.org_id=$2`, id, orgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, errPersonaNotFound
+	}
+	return p, err
+}
+
+// personaForTenant lê o {id} da URL e carrega a persona da org.
+func (a *App) personaForTenant(w http.ResponseWriter, r *http.Request) (agentPersona, bool) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return agentPersona{}, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid persona id")
+		return agentPersona{}, false
+	}
+	p, err := a.loadPersona(r.Context(), orgID, id)
+	if errors.Is(err, errPersonaNotFound) {
+		render.Error(w, http.StatusNotFound, err.Error())
+		return p, false
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return p, false
+	}
+	return p, true
+}
+
+// normalize limpa a entrada e devolve a mensagem de erro de validação.
+func (p *agentPersona) normalize() string {
+	p.Name = limitRunes(strings.TrimSpace(p.Name), 80)
+	p.CommunicationStyle = strings.TrimSpace(p.CommunicationStyle)
+	p.Sector = strings.TrimSpace(p.Sector)
+	p.ProfileType = strings.TrimSpace(p.ProfileType)
+	p.ProfileCustom = strings.TrimSpace(p.ProfileCustom)
+	p.BasePrompt = strings.TrimSpace(p.BasePrompt)
+	p.LLMProvider = strings.ToLower(strings.TrimSpace(p.LLMProvider))
+	p.LLMModel = strings.TrimSpace(p.LLMModel)
+	if p.Name == "" {
+		return "name required"
+	}
+	if p.LLMProvider != "" && !llm.Valid(p.LLMProvider) {
+		return "invalid llmProvider (use " + strings.Join(llm.Providers, ", ") + ")"
+	}
+	return ""
+}
+
+// personaNameTaken diz se já existe outra persona com o nome na org.
+func (a *App) personaNameTaken(ctx context.Context, orgID int64, name string, exceptID int64) (bool, error) {
+	var taken bool
+	err := a.DB.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM agent_personas WHERE org_id=$1 AND lower(name)=lower($2) AND id<>$3)`,
+		orgID, name, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// GET /api/agent/personas
+func (a *App) listPersonas(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(),
+		`SELECT `+personaColumns+` FROM agent_personas p WHERE p.org_id=$1 ORDER BY lower(p.name)`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []agentPersona{}
+	for rows.Next() {
+		p, err := scanPersona(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, p)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// POST /api/agent/personas
+func (a *App) createPersona(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in agentPersona
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if msg := in.normalize(); msg != "" {
+		render.Error(w, http.StatusBadRequest, msg)
+		return
+	}
+	id, status, err := a.insertPersona(r.Context(), orgID, in, nil)
+	if err != nil {
+		render.Error(w, status, err.Error())
+		return
+	}
+	p, err := a.loadPersona(r.Context(), orgID, id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, p)
+}
+
+func (a *App) insertPersona(ctx context.Context, orgID int64, p agentPersona, clonedFrom *int64) (int64, int, error) {
+	taken, err := a.personaNameTaken(ctx, orgID, p.Name, 0)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if taken {
+		return 0, http.StatusConflict, errors.New("persona name already exists")
+	}
+	var id int64
+	err = a.DB.QueryRow(ctx, `
+INSERT INTO agent_personas (org_id, name, communication_style, sector, profile_type, profile_custom, base_prompt,
+                            llm_provider, llm_model, cloned_from)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8,''), NULLIF($9,''), $10)
+RETURNING id`,
+		orgID, p.Name, p.CommunicationStyle, p.Sector, p.ProfileType, p.ProfileCustom, p.BasePrompt,
+		p.LLMProvider, p.LLMModel, clonedFrom).Scan(&id)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	return id, 0, nil
+}
+
+// GET /api/agent/personas/{id}
+func (a *App) getPersona(w http.ResponseWriter, r *http.Request) {
+	if p, ok := a.personaForTenant(w, r); ok {
+		render.OK(w, p)
+	}
+}
+
+// PUT /api/agent/personas/{id}
+func (a *App) updatePersona(w http.ResponseWriter, r *http.Request) {
+	cur, ok := a.personaForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in agentPersona
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if msg := in.normalize(); msg != "" {
+		render.Error(w, http.StatusBadRequest, msg)
+		return
+	}
+	ctx := r.Context()
+	if taken, err := a.personaNameTaken(ctx, cur.OrgID, in.Name, cur.ID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if taken {
+		render.Error(w, http.StatusConflict, "persona name already exists")
+		return
+	}
+	_, err := a.DB.Exec(ctx, `
+UPDATE agent_personas
+   SET name=$3, communication_style=$4, sector=$5, profile_type=$6, profile_custom=$7, base_prompt=$8,
+       llm_provider=NULLIF($9,''), llm_model=NULLIF($10,''), updated_at=NOW()
+ WHERE id=$1 AND org_id=$2`,
+		cur.ID, cur.OrgID, in.Name, in.CommunicationStyle, in.Sector, in.ProfileType, in.ProfileCustom, in.BasePrompt,
+		in.LLMProvider, in.LLMModel)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := a.loadPersona(ctx, cur.OrgID, cur.ID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, p)
+}
+
+// DELETE /api/agent/personas/{id}
+func (a *App) deletePersona(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.personaForTenant(w, r)
+	if !ok {
+		return
+	}
+	// as FKs (ON DELETE SET NULL) desfazem as atribuições
+	if _, err := a.DB.Exec(r.Context(), `DELETE FROM agent_personas WHERE id=$1 AND org_id=$2`, p.ID, p.OrgID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.NoContent(w)
+}
+
+// POST /api/agent/personas/{id}/clone
+//
+// Copia a persona com outro nome (padrão "<nome> (cópia)") e, com flow_id,
+// já atribui a cópia a esse flow da org.
+func (a *App) clonePersona(w http.ResponseWriter, r *http.Request) {
+	src, ok := a.personaForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Name   string `json:"name"`
+		FlowID int64  `json:"flow_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+			return
+		}
+	}
+	ctx := r.Context()
+	if in.FlowID > 0 {
+		if ok, err := a.flowInOrg(ctx, src.OrgID, in.FlowID); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		} else if !ok {
+			render.Error(w, http.StatusNotFound, "flow not found")
+			return
+		}
+	}
+	cp := src
+	cp.Name = nonEmpty(strings.TrimSpace(in.Name), src.Name+" (cópia)")
+	if msg := cp.normalize(); msg != "" {
+		render.Error(w, http.StatusBadRequest, msg)
+		return
+	}
+	id, status, err := a.insertPersona(ctx, src.OrgID, cp, &src.ID)
+	if err != nil {
+		render.Error(w, status, err.Error())
+		return
+	}
+	if in.FlowID > 0 {
+		if err := a.setFlowPersona(ctx, src.OrgID, in.FlowID, &id); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	p, err := a.loadPersona(ctx, src.OrgID, id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, p)
+}
+
+// PUT /api/agent/persona
+//
+// Atribui (ou, com persona_id null, remove) a persona do flow do token ou,
+// com instance_id, de uma instância de WhatsApp da org.
+func (a *App) assignPersona(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		PersonaID  *int64 `json:"persona_id"`
+		InstanceID string `json:"instance_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	if in.PersonaID != nil {
+		if _, err := a.loadPersona(ctx, orgID, *in.PersonaID); errors.Is(err, errPersonaNotFound) {
+			render.Error(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	if instance := strings.TrimSpace(in.InstanceID); instance != "" {
+		tag, err := a.DB.Exec(ctx,
+			`UPDATE public.wa_instances SET persona_id=$3, updated_at=NOW() WHERE instance_id=$1 AND org_id=$2 AND deleted_at IS NULL`,
+			instance, orgID, in.PersonaID)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			render.Error(w, http.StatusNotFound, "instance not found")
+			return
+		}
+		render.OK(w, map[string]any{"instance_id": instance, "persona_id": in.PersonaID})
+		return
+	}
+
+	if err := a.setFlowPersona(ctx, orgID, flowID, in.PersonaID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"flow_id": flowID, "persona_id": in.PersonaID})
+}
+
+func (a *App) flowInOrg(ctx context.Context, orgID, flowID int64) (bool, error) {
+	var ok bool
+	err := a.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM flows WHERE id=$1 AND org_id=$2)`, flowID, orgID).Scan(&ok)
+	return ok, err
+}
+
+func (a *App) setFlowPersona(ctx context.Context, orgID, flowID int64, personaID *int64) error {
+	_, err := a.DB.Exec(ctx, `
+INSERT INTO agent_settings (org_id, flow_id, persona_id, updated_at) VALUES ($1, $2, $3, NOW())
+ON CONFLICT (org_id, flow_id) DO UPDATE SET persona_id=EXCLUDED.persona_id, updated_at=NOW()`,
+		orgID, flowID, personaID)
+	if err == nil {
+		a.recordAgentVersion(ctx, orgID, flowID)
+	}
+	return err
+}
+
+// effectivePersona resolve a persona usada numa conversa: a da instância, a
+// do flow ou, sem atribuição, os campos de agent_settings (ID 0).
+func (a *App) effectivePersona(ctx context.Context, orgID, flowID int64, instanceID string) (agentPersona, error) {
+	var id *int64
+	if instanceID != "" {
+		err := a.DB.QueryRow(ctx,
+			`SELECT persona_id FROM public.wa_instances WHERE instance_id=$1 AND org_id=$2`, instanceID, orgID).Scan(&id)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return agentPersona{}, err
+		}
+	}
+	p := agentPersona{OrgID: orgID}
+	var flowPersona *int64
+	err := a.DB.QueryRow(ctx, `
+SELECT persona_id, COALESCE(name,''), COALESCE(communication_style,''), COALESCE(sector,''),
+       COALESCE(profile_type,''), COALESCE(profile_custom,''), COALESCE(base_prompt,''),
+       COALESCE(llm_provider,''), COALESCE(llm_model,'')
+  FROM agent_settings WHERE org_id=$1 AND flow_id=$2`, orgID, flowID).
+		Scan(&flowPersona, &p.Name, &p.CommunicationStyle, &p.Sector, &p.ProfileType, &p.ProfileCustom, &p.BasePrompt,
+			&p.LLMProvider, &p.LLMModel)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return p, err
+	}
+	if id == nil {
+		id = flowPersona
+	}
+	if id == nil {
+		return p, nil
+	}
+	lib, err := a.loadPersona(ctx, orgID, *id)
+	if errors.Is(err, errPersonaNotFound) {
+		return p, nil
+	}
+	return lib, err
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Prompt de sistema do agente por org
+// ================================================================
+//
+// O prompt de sistema do chat (handlers_chat.go, handlers_chat_ws.go) e o
+// entregue ao Agente em LookupInstance (internal_agent_api.go) saem da
+// persona efetiva (agent_personas.go: instância, flow ou agent_settings):
+// nome, estilo de comunicação, setor e perfil viram a apresentação, e o
+// base_prompt (ou, vazio, defaultAgentPrompt) vem em seguida. O system
+// enviado pelo cliente entra depois, como instrução adicional, e a base de
+// conhecimento por último (agent_knowledge.go).
+//
+// base_prompt e profile_custom aceitam variáveis {{...}}, resolvidas a cada
+// requisição (só as usadas são consultadas):
+//
+//   {{company.nome_fantasia}} {{company.razao_social}} {{company.name}}
+//   {{company.segmento}} {{company.telefone}} {{company.email}}
+//   {{company.endereco}} {{company.numero}} {{company.bairro}}
+//   {{company.cidade}} {{company.uf}} {{company.cep}} {{company.observacoes}}
+//   {{agent.name}} {{agent.sector}} {{agent.communication_style}}
+//   {{catalog_summary}}   categorias e até PROMPT_CATALOG_ITEMS (padrão 20)
+//                         produtos à venda, com preço
+//   {{business_hours}}    janelas da agenda da org (availability_slots)
+//   {{today}} {{now}}     data e hora no fuso de APPOINTMENT_TZ
+//
+// Variável desconhecida vira texto vazio e aparece em unknown na prévia:
+//
+// GET /api/agent/prompt/preview   ?instance_id=   prompt montado e variáveis
+
+const defaultAgentPrompt = `Você atende os clientes de {{company.nome_fantasia}} pelo WhatsApp.
+Responda em português, de forma breve e cordial, e use apenas as informações do catálogo e da empresa; se não souber, diga que vai verificar com a equipe.
+
+Horário de atendimento: {{business_hours}}
+
+Catálogo:
+{{catalog_summary}}`
+
+var promptVarRe = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_.]+)\s*\}\}`)
+
+// promptCompanyFields mapeia {{company.*}} para as colunas de orgs.
+var promptCompanyFields = []string{
+	"name", "razao_social", "nome_fantasia", "segmento", "telefone", "email",
+	"endereco", "numero", "bairro", "cidade", "uf", "cep", "observacoes",
+}
+
+// promptVars resolve as variáveis de um prompt sob demanda, guardando o que
+// já foi consultado.
+type promptVars struct {
+	a       *App
+	ctx     context.Context
+	orgID   int64
+	flowID  int64
+	persona agentPersona
+	company map[string]string
+	cache   map[string]string
+	unknown []string
+}
+
+// agentPromptPreview é a resposta da prévia; unknown lista as variáveis que
+// o prompt usa e que não existem.
+type agentPromptPreview struct {
+	Prompt  string   `json:"prompt"`
+	Unknown []string `json:"unknown"`
+}
+
+func (a *App) mountAgentPrompt(r chi.Router) {
+	r.Get("/agent/prompt/preview", a.previewAgentPrompt)
+}
+
+func (v *promptVars) lookup(name string) (string, bool) {
+	if s, ok := v.cache[name]; ok {
+		return s, true
+	}
+	var s string
+	var err error
+	switch {
+	case strings.HasPrefix(name, "company."):
+		field := strings.TrimPrefix(name, "company.")
+		if !containsString(promptCompanyFields, field) {
+			return "", false
+		}
+		if v.company == nil {
+			v.company, err = v.a.promptCompany(v.ctx, v.orgID)
+		}
+		s = v.company[field]
+		if field == "nome_fantasia" && s == "" {
+			s = v.company["name"]
+		}
+	case name == "agent.name":
+		s = v.persona.Name
+	case name == "agent.sector":
+		s = v.persona.Sector
+	case name == "agent.communication_style":
+		s = v.persona.CommunicationStyle
+	case name == "catalog_summary":
+		s, err = v.a.promptCatalogSummary(v.ctx, v.orgID, v.flowID)
+	case name == "business_hours":
+		s, err = v.a.promptBusinessHours(v.ctx, v.orgID, v.flowID)
+	case name == "today":
+		s = time.Now().In(appointmentLoc()).Format("02/01/2006")
+	case name == "now":
+		s = time.Now().In(appointmentLoc()).Format("02/01/2006 15:04")
+	default:
+		return "", false
+	}
+	if err != nil {
+		log.Printf("agent prompt org=%d {{%s}}: %v", v.orgID, name, err)
+	}
+	v.cache[name] = s
+	return s, true
+}
+
+// render troca as variáveis de s; as desconhecidas somem e ficam em unknown.
+func (v *promptVars) render(s string) string {
+	return promptVarRe.ReplaceAllStringFunc(s, func(m string) string {
+		name := promptVarRe.FindStringSubmatch(m)[1]
+		val, ok := v.lookup(name)
+		if !ok && !containsString(v.unknown, name) {
+			v.unknown = append(v.unknown, name)
+		}
+		return val
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// agentSystemPrompt monta o prompt de sistema da persona efetiva. Devolve
+// também as variáveis desconhecidas.
+func (a *App) agentSystemPrompt(ctx context.Context, orgID, flowID int64, instanceID string) (string, []string, error) {
+	p, err := a.effectivePersona(ctx, orgID, flowID, instanceID)
+	if err != nil {
+		return "", nil, err
+	}
+	v := &promptVars{a: a, ctx: ctx, orgID: orgID, flowID: flowID, persona: p, cache: map[string]string{}}
+
+	var b strings.Builder
+	if p.Name != "" {
+		fmt.Fprintf(&b, "Você é %s", p.Name)
+		if p.Sector != "" {
+			fmt.Fprintf(&b, ", do setor de %s", p.Sector)
+		}
+		b.WriteString(".\n")
+	} else if p.Sector != "" {
+		fmt.Fprintf(&b, "Setor: %s.\n", p.Sector)
+	}
+	if p.CommunicationStyle != "" {
+		fmt.Fprintf(&b, "Estilo de comunicação: %s.\n", p.CommunicationStyle)
+	}
+	switch {
+	case p.ProfileCustom != "":
+		fmt.Fprintf(&b, "Perfil: %s\n", strings.TrimSpace(v.render(p.ProfileCustom)))
+	case p.ProfileType != "":
+		fmt.Fprintf(&b, "Perfil: %s.\n", p.ProfileType)
+	}
+	base := strings.TrimSpace(p.BasePrompt)
+	if base == "" {
+		base = defaultAgentPrompt
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(strings.TrimSpace(v.render(base)))
+	return b.String(), v.unknown, nil
+}
+
+// withAgentPrompt põe o prompt da org antes do system enviado pelo cliente.
+// Sem tenant (ou com erro) o system do cliente segue sozinho, como antes.
+func (a *App) withAgentPrompt(ctx context.Context, in *chatReq, orgID, flowID int) {
+	if orgID <= 0 || flowID <= 0 {
+		return
+	}
+	prompt, _, err := a.agentSystemPrompt(ctx, int64(orgID), int64(flowID), "")
+	if err != nil {
+		log.Printf("agent prompt org=%d flow=%d: %v", orgID, flowID, err)
+		return
+	}
+	if s := strings.TrimSpace(in.System); s != "" {
+		prompt += "\n\n" + s
+	}
+	in.System = prompt
+}
+
+func (a *App) promptCompany(ctx context.Context, orgID int64) (map[string]string, error) {
+	out := map[string]string{}
+	vals := make([]*string, len(promptCompanyFields))
+	dest := make([]any, len(vals))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	err := a.DB.QueryRow(ctx, `SELECT `+strings.Join(promptCompanyFields, ", ")+` FROM orgs WHERE id=$1`, orgID).Scan(dest...)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return out, err
+	}
+	for i, f := range promptCompanyFields {
+		if vals[i] != nil {
+			out[f] = strings.TrimSpace(*vals[i])
+		}
+	}
+	return out, nil
+}
+
+// promptCatalogSummary lista as categorias e os primeiros produtos à venda.
+func (a *App) promptCatalogSummary(ctx context.Context, orgID, flowID int64) (string, error) {
+	limit, err := strconv.Atoi(getenv("PROMPT_CATALOG_ITEMS", "20"))
+	if err != nil || limit < 0 {
+		limit = 20
+	}
+	rows, err := a.DB.Query(ctx, `
+SELECT title, COALESCE(category,''),
+       COALESCE((SELECT price_cents FROM flash_sales WHERE product_id=products.id AND `+flashSaleRunningSQL+`), price_cents)
+  FROM products
+ WHERE org_id=$1 AND flow_id=$2 AND status='active' AND `+productAvailableSQL()+`
+ ORDER BY category NULLS LAST, title`, orgID, flowID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	var total int
+	var lines []string
+	for rows.Next() {
+		var title, category string
+		var price int
+		if err := rows.Scan(&title, &category, &price); err != nil {
+			return "", err
+		}
+		total++
+		counts[nonEmpty(category, "outros")]++
+		if len(lines) < limit {
+			lines = append(lines, fmt.Sprintf("- %s — R$ %.2f", title, float64(price)/100))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if total == 0 {
+		return "nenhum produto à venda no momento", nil
+	}
+	cats := make([]string, 0, len(counts))
+	for c, n := range counts {
+		cats = append(cats, fmt.Sprintf("%s (%d)", c, n))
+	}
+	sort.Strings(cats)
+	out := fmt.Sprintf("%d produtos. Categorias: %s.\n%s", total, strings.Join(cats, ", "), strings.Join(lines, "\n"))
+	if total > len(lines) {
+		out += fmt.Sprintf("\n(e mais %d; consulte o catálogo para os demais)", total-len(lines))
+	}
+	return out, nil
+}
+
+// promptBusinessHours resume as janelas da agenda da org (sem usuário),
+// ex.: "seg 09:00–18:00; sáb 09:00–12:00".
+func (a *App) promptBusinessHours(ctx context.Context, orgID, flowID int64) (string, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT weekday, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI')
+  FROM public.availability_slots
+ WHERE org_id=$1 AND flow_id=$2 AND user_id IS NULL
+ ORDER BY weekday, start_time`, orgID, flowID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var days []string
+	var last = -1
+	for rows.Next() {
+		var wd int
+		var start, end string
+		if err := rows.Scan(&wd, &start, &end); err != nil {
+			return "", err
+		}
+		if wd == last {
+			days[len(days)-1] += ", " + start + "–" + end
+			continue
+		}
+		last = wd
+		days = append(days, weekdayPT[wd]+" "+start+"–"+end)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(days) == 0 {
+		return "não informado", nil
+	}
+	return strings.Join(days, "; "), nil
+}
+
+// GET /api/agent/prompt/preview
+func (a *App) previewAgentPrompt(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	prompt, unknown, err := a.agentSystemPrompt(r.Context(), orgID, flowID, strings.TrimSpace(r.URL.Query().Get("instance_id")))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if unknown == nil {
+		unknown = []string{}
+	}
+	render.OK(w, agentPromptPreview{Prompt: prompt, Unknown: unknown})
+}
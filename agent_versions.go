@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Versões da configuração do agente e análise por versão
+// ================================================================
+//
+// Cada gravação em agent_settings (PUT /api/agent/settings, PUT
+// /api/agent/persona) gera uma versão com o snapshot da linha
+// (migrations/0016). Uma versão vale de created_at até a próxima; a análise
+// mede, dentro dessa janela (recortada por ?from=&to=), leads, pedidos pagos
+// e a latência entre a mensagem do lead e a resposta no WhatsApp.
+//
+// GET /api/agent/versions                  histórico do flow
+// GET /api/analytics/agent-versions?from=&to=&tz=
+
+type agentVersion struct {
+	Version   int             `json:"version"`
+	Snapshot  json.RawMessage `json:"snapshot"`
+	ChangedBy *int64          `json:"changed_by,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (a *App) mountAgentVersions(r chi.Router) {
+	r.Get("/agent/versions", a.listAgentVersions)
+	r.With(csvFromJSON("agent_versions")).Get("/analytics/agent-versions", a.analyticsAgentVersions)
+}
+
+// recordAgentVersion grava a configuração atual do flow como nova versão,
+// a menos que seja igual à última. Falhas só são registradas em log: o
+// histórico não deve impedir a gravação da configuração.
+func (a *App) recordAgentVersion(ctx context.Context, orgID, flowID int64) {
+	var changedBy *int64
+	if c, ok := claimsFromContext(ctx); ok {
+		changedBy = &c.UserID
+	}
+	_, err := a.DB.Exec(ctx, `
+WITH cur AS (
+  SELECT to_jsonb(s) - 'updated_at' AS snap FROM agent_settings s WHERE org_id=$1 AND flow_id=$2
+), last AS (
+  SELECT version, snapshot FROM agent_settings_versions
+   WHERE org_id=$1 AND flow_id=$2 ORDER BY version DESC LIMIT 1
+)
+INSERT INTO agent_settings_versions (org_id, flow_id, version, snapshot, changed_by)
+SELECT $1, $2, COALESCE((SELECT version FROM last), 0) + 1, cur.snap, $3
+  FROM cur
+ WHERE cur.snap IS DISTINCT FROM (SELECT snapshot FROM last)
+ON CONFLICT (org_id, flow_id, version) DO NOTHING`, orgID, flowID, changedBy)
+	if err != nil {
+		log.Printf("agent version org=%d flow=%d: %v", orgID, flowID, err)
+	}
+}
+
+// GET /api/agent/versions
+func (a *App) listAgentVersions(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT version, snapshot, changed_by, created_at FROM agent_settings_versions
+ WHERE org_id=$1 AND flow_id=$2 ORDER BY version DESC LIMIT 200`, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []agentVersion{}
+	for rows.Next() {
+		var v agentVersion
+		if err := rows.Scan(&v.Version, &v.Snapshot, &v.ChangedBy, &v.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, v)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+type agentVersionStats struct {
+	Version        int             `json:"version"`
+	StartsAt       time.Time       `json:"starts_at"`
+	EndsAt         *time.Time      `json:"ends_at"` // nil = versão atual
+	Snapshot       json.RawMessage `json:"snapshot"`
+	Leads          int64           `json:"leads"`
+	PaidOrders     int64           `json:"paid_orders"`
+	RevenueCents   int64           `json:"revenue_cents"`
+	ConversionRate float64         `json:"conversion_rate"` // pedidos pagos / leads
+	Turns          int64           `json:"turns"`           // mensagens do lead que abrem um turno
+	Answered       int64           `json:"answered"`
+	AvgResponseS   *float64        `json:"avg_response_seconds"`
+	P50ResponseS   *float64        `json:"p50_response_seconds"`
+	P90ResponseS   *float64        `json:"p90_response_seconds"`
+}
+
+// GET /api/analytics/agent-versions?from=&to=&tz=
+//
+// Um turno começa na primeira mensagem recebida depois de uma enviada (ou na
+// primeira da conversa); a latência vai até a próxima mensagem enviada.
+func (a *App) analyticsAgentVersions(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+WITH v AS (
+  SELECT version, snapshot, created_at AS starts_at,
+         LEAD(created_at) OVER (ORDER BY version) AS ends_at
+    FROM agent_settings_versions WHERE org_id=$1 AND flow_id=$2
+), win AS (
+  SELECT v.*,
+         GREATEST(starts_at, COALESCE($3::timestamptz, starts_at)) AS lo,
+         LEAST(COALESCE(ends_at, 'infinity'::timestamptz), COALESCE($4::timestamptz, 'infinity'::timestamptz)) AS hi
+    FROM v
+), msgs AS (
+  SELECT conversation_id, direction, created_at,
+         LAG(direction) OVER (PARTITION BY conversation_id ORDER BY created_at, id) AS prev_dir
+    FROM wa_messages
+   WHERE org_id=$1 AND flow_id=$2 AND conversation_id IS NOT NULL
+     AND created_at >= (SELECT MIN(lo) FROM win)
+), turns AS (
+  SELECT m.created_at,
+         (SELECT MIN(o.created_at) FROM wa_messages o
+           WHERE o.conversation_id = m.conversation_id AND o.direction = 'out'
+             AND o.created_at > m.created_at) - m.created_at AS rt
+    FROM msgs m
+   WHERE m.direction = 'in' AND m.prev_dir IS DISTINCT FROM 'in'
+)
+SELECT win.version, win.starts_at, win.ends_at, win.snapshot,
+       (SELECT COUNT(*) FROM leads l
+         WHERE l.org_id=$1 AND l.flow_id=$2 AND l.created_at >= win.lo AND l.created_at < win.hi),
+       o.paid, o.revenue,
+       t.turns, t.answered, t.avg_s, t.p50_s, t.p90_s
+  FROM win
+  CROSS JOIN LATERAL (
+    SELECT COUNT(*) AS paid, COALESCE(SUM(total_cents), 0)::bigint AS revenue
+      FROM orders
+     WHERE org_id=$1 AND flow_id=$2 AND status='paid' AND created_at >= win.lo AND created_at < win.hi
+  ) o
+  CROSS JOIN LATERAL (
+    SELECT COUNT(*) AS turns, COUNT(rt) AS answered,
+           AVG(EXTRACT(EPOCH FROM rt))::float8 AS avg_s,
+           percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM rt)) AS p50_s,
+           percentile_cont(0.9) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM rt)) AS p90_s
+      FROM turns WHERE created_at >= win.lo AND created_at < win.hi
+  ) t
+ WHERE win.lo < win.hi
+ ORDER BY win.version DESC`, orgID, flowID, rg.From, rg.To)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []agentVersionStats{}
+	for rows.Next() {
+		var s agentVersionStats
+		if err := rows.Scan(&s.Version, &s.StartsAt, &s.EndsAt, &s.Snapshot, &s.Leads, &s.PaidOrders, &s.RevenueCents,
+			&s.Turns, &s.Answered, &s.AvgResponseS, &s.P50ResponseS, &s.P90ResponseS); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if s.Leads > 0 {
+			s.ConversionRate = float64(s.PaidOrders) / float64(s.Leads)
+		}
+		s.StartsAt = s.StartsAt.In(rg.Loc)
+		if s.EndsAt != nil {
+			t := s.EndsAt.In(rg.Loc)
+			s.EndsAt = &t
+		}
+		out = append(out, s)
+	}
+	render.OK(w, map[string]any{"items": out, "range": rg.meta()})
+}
@@ -0,0 +1,211 @@
+// Package agentv1 espelha o contrato definido em proto/agent/v1/agent.proto
+// e fornece um cliente tipado para a API interna usada pelo backend do
+// Agente IA. Ao alterar o .proto, mantenha este arquivo sincronizado.
+package agentv1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ServicePath é o prefixo das rotas do serviço (estilo Twirp).
+const ServicePath = "/internal/twirp/agent.v1.AgentService/"
+
+// TokenHeader é o header com o segredo compartilhado entre os serviços.
+const TokenHeader = "X-Internal-Token"
+
+type LookupInstanceRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+type Instance struct {
+	InstanceID string `json:"instance_id"`
+	OrgID      int64  `json:"org_id"`
+	FlowID     int64  `json:"flow_id"`
+	Token      string `json:"token"`
+	WebhookURL string `json:"webhook_url"`
+	// Persona efetiva: a da instância, a do flow ou as configurações do agente.
+	Persona *Persona `json:"persona,omitempty"`
+	// Prompt de sistema montado da persona, com as variáveis já resolvidas.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+}
+
+type Persona struct {
+	ID                 int64  `json:"id"` // 0 = configurações do agente, sem persona da biblioteca
+	Name               string `json:"name"`
+	CommunicationStyle string `json:"communication_style"`
+	Sector             string `json:"sector"`
+	ProfileType        string `json:"profile_type"`
+	ProfileCustom      string `json:"profile_custom"`
+	BasePrompt         string `json:"base_prompt"`
+	LLMProvider        string `json:"llm_provider"`
+	LLMModel           string `json:"llm_model"`
+}
+
+type ListProductsRequest struct {
+	OrgID  int64  `json:"org_id"`
+	FlowID int64  `json:"flow_id"`
+	Query  string `json:"query"`
+	Limit  int32  `json:"limit"`
+}
+
+type ListProductsResponse struct {
+	Products []Product `json:"products"`
+}
+
+type GetProductRequest struct {
+	OrgID  int64 `json:"org_id"`
+	FlowID int64 `json:"flow_id"`
+	ID     int64 `json:"id"`
+}
+
+type Product struct {
+	ID         int64  `json:"id"`
+	OrgID      int64  `json:"org_id"`
+	FlowID     int64  `json:"flow_id"`
+	Title      string `json:"title"`
+	Slug       string `json:"slug"`
+	Status     string `json:"status"`
+	Category   string `json:"category"`
+	ImageURL   string `json:"image_url"`
+	PriceCents int32  `json:"price_cents"`
+	Stock      int32  `json:"stock"`
+}
+
+type SendTextRequest struct {
+	InstanceID string `json:"instance_id"`
+	To         string `json:"to"`
+	Text       string `json:"text"`
+}
+
+type SendTextResponse struct {
+	OK               bool   `json:"ok"`
+	Mock             bool   `json:"mock"`
+	ProviderResponse string `json:"provider_response,omitempty"`
+}
+
+// IntentBuying é a intenção de compra (ReportIntentRequest.Intent).
+const IntentBuying = "buying"
+
+type ReportIntentRequest struct {
+	InstanceID string  `json:"instance_id"`
+	Contact    string  `json:"contact"`    // telefone do contato; dispensável com LeadID
+	Intent     string  `json:"intent"`     // IntentBuying
+	Confidence float32 `json:"confidence"` // 0..1; 0 = não informado (conta como 1)
+	LeadID     int64   `json:"lead_id,omitempty"`
+}
+
+type ReportIntentResponse struct {
+	LeadID int64  `json:"lead_id"`
+	Stage  string `json:"stage"` // etapa depois da regra
+	Moved  bool   `json:"moved"`
+}
+
+// Error é o corpo de erro devolvido pelo servidor (formato Twirp).
+type Error struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Msg }
+
+// Códigos de erro usados pelo servidor.
+const (
+	CodeInvalidArgument = "invalid_argument"
+	CodeUnauthenticated = "unauthenticated"
+	CodeNotFound        = "not_found"
+	CodeUnavailable     = "unavailable"
+	CodeInternal        = "internal"
+)
+
+// HTTPStatus converte um código de erro no status HTTP correspondente.
+func HTTPStatus(code string) int {
+	switch code {
+	case CodeInvalidArgument:
+		return http.StatusBadRequest
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Client chama a API interna da plataforma.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+// NewClient cria um cliente apontando para baseURL (ex.: https://api.exemplo.com).
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) LookupInstance(ctx context.Context, in *LookupInstanceRequest) (*Instance, error) {
+	out := new(Instance)
+	return out, c.call(ctx, "LookupInstance", in, out)
+}
+
+func (c *Client) ListProducts(ctx context.Context, in *ListProductsRequest) (*ListProductsResponse, error) {
+	out := new(ListProductsResponse)
+	return out, c.call(ctx, "ListProducts", in, out)
+}
+
+func (c *Client) GetProduct(ctx context.Context, in *GetProductRequest) (*Product, error) {
+	out := new(Product)
+	return out, c.call(ctx, "GetProduct", in, out)
+}
+
+func (c *Client) SendText(ctx context.Context, in *SendTextRequest) (*SendTextResponse, error) {
+	out := new(SendTextResponse)
+	return out, c.call(ctx, "SendText", in, out)
+}
+
+func (c *Client) ReportIntent(ctx context.Context, in *ReportIntentRequest) (*ReportIntentResponse, error) {
+	out := new(ReportIntentResponse)
+	return out, c.call(ctx, "ReportIntent", in, out)
+}
+
+func (c *Client) call(ctx context.Context, method string, in, out any) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+ServicePath+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set(TokenHeader, c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		var e Error
+		if json.Unmarshal(body, &e) == nil && e.Code != "" {
+			return &e
+		}
+		return fmt.Errorf("agentv1 %s: http %d: %s", method, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
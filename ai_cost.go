@@ -0,0 +1,47 @@
+package main
+
+import "strings"
+
+// Preço aproximado (USD por 1M tokens) dos modelos usados pela plataforma.
+// Serve apenas para estimativas exibidas ao cliente; a fatura real vem do
+// provedor. Modelos desconhecidos usam o preço do gpt-4o (conservador).
+var aiModelPricing = map[string]struct{ In, Out float64 }{
+	"gpt-4o-mini":       {In: 0.15, Out: 0.60},
+	"gpt-4o":            {In: 2.50, Out: 10.00},
+	"gpt-4.1":           {In: 2.00, Out: 8.00},
+	"gpt-4.1-mini":      {In: 0.40, Out: 1.60},
+	"claude-3-5-haiku":  {In: 0.80, Out: 4.00},
+	"claude-3-5-sonnet": {In: 3.00, Out: 15.00},
+	"gemini-1.5-flash":  {In: 0.075, Out: 0.30},
+	"gemini-1.5-pro":    {In: 1.25, Out: 5.00},
+	// embeddings (base de conhecimento do agente)
+	"text-embedding-3-small": {In: 0.02},
+	"text-embedding-3-large": {In: 0.13},
+	"text-embedding-ada-002": {In: 0.10},
+}
+
+// aiModelPrice procura o modelo pelo prefixo mais longo, já que os provedores
+// devolvem versões datadas (gpt-4o-mini-2024-07-18, claude-3-5-haiku-20241022).
+// Modelos locais (ollama/...) não têm custo por token.
+func aiModelPrice(model string) struct{ In, Out float64 } {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if strings.HasPrefix(model, "ollama/") {
+		return struct{ In, Out float64 }{}
+	}
+	best := ""
+	for k := range aiModelPricing {
+		if strings.HasPrefix(model, k) && len(k) > len(best) {
+			best = k
+		}
+	}
+	if best == "" {
+		best = "gpt-4o"
+	}
+	return aiModelPricing[best]
+}
+
+// estimateCostUSD estima o custo de uma chamada a partir dos tokens usados.
+func estimateCostUSD(model string, promptTokens, completionTokens int) float64 {
+	p := aiModelPrice(model)
+	return (float64(promptTokens)*p.In + float64(completionTokens)*p.Out) / 1e6
+}
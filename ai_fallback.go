@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Modo degradado da IA no WhatsApp
+// ================================================================
+//
+// Com AI_FALLBACK_ENABLED=true, AI_FALLBACK_FAILURES (padrão 5) falhas
+// seguidas da IA de uma org — chamadas de LLM (metrics.go) ou encaminhamentos
+// ao Agente com erro de rede/5xx — colocam a org em modo degradado. Enquanto
+// durar:
+//
+//   - as mensagens recebidas não vão ao Agente: ficam em ai_held_messages
+//     (também as que falharam no job agent.forward, em vez de irem para o
+//     dead-letter);
+//   - o contato recebe AI_FALLBACK_MESSAGE, no máximo uma vez a cada
+//     AI_FALLBACK_REPLY_EVERY (padrão 30min);
+//   - a entrada e a saída publicam ai.degraded / ai.recovered
+//     (webhooks_out.go) e mandam e-mail aos admins da org e a
+//     AI_FALLBACK_ALERT_EMAIL, se houver.
+//
+// A cada AI_FALLBACK_PROBE_INTERVAL (padrão 1min) o worker testa a IA
+// reenviando ao Agente a mensagem guardada mais antiga (sem nenhuma, com uma
+// chamada mínima ao LLM). No primeiro sucesso — do teste ou de qualquer
+// chamada de LLM da org — o modo termina e as mensagens guardadas voltam para
+// a fila agent.forward na ordem de chegada.
+//
+// A contagem de falhas é de cada réplica; o estado degradado fica no banco
+// (ai_degraded_orgs), relido a cada AI_FALLBACK_SYNC (padrão 10s), e só a
+// réplica que grava a entrada (ou a saída) notifica.
+//
+// GET  /api/ai/status           estado da org e mensagens guardadas
+// POST /api/ai/status/release   (admin) encerra o modo e libera as mensagens
+
+const aiFallbackDefaultMessage = "Recebemos sua mensagem! Nosso atendimento automático está com instabilidade, " +
+	"mas já avisamos a equipe e em instantes você terá uma resposta."
+
+func aiFallbackEnabled() bool {
+	v, _ := strconv.ParseBool(getenv("AI_FALLBACK_ENABLED", "false"))
+	return v
+}
+
+func aiFallbackFailures() int {
+	n, err := strconv.Atoi(getenv("AI_FALLBACK_FAILURES", "5"))
+	if err != nil || n < 1 {
+		return 5
+	}
+	return n
+}
+
+func aiFallbackDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(getenv(key, ""))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// observeLLM recebe o resultado de cada chamada de LLM (metrics.go);
+// mountAIFallback liga a contagem do modo degradado.
+var observeLLM = func(ctx context.Context, orgID int64, err error) {}
+
+// aiHealth guarda as falhas consecutivas desta réplica e a cópia local de
+// ai_degraded_orgs.
+var aiHealth = struct {
+	sync.Mutex
+	failures map[int64]int
+	degraded map[int64]bool
+}{failures: map[int64]int{}, degraded: map[int64]bool{}}
+
+func (a *App) mountAIFallback(r chi.Router) {
+	observeLLM = a.noteAIResult
+	r.Get("/ai/status", a.aiFallbackStatus)
+	r.With(a.requireRole(roleAdmin)).Post("/ai/status/release", a.releaseAIFallback)
+
+	// roda mesmo desligado: libera o que ficou guardado antes de desligar
+	go a.aiFallbackLoop(aiFallbackDuration("AI_FALLBACK_SYNC", 10*time.Second))
+}
+
+// aiDegraded diz se as mensagens da org devem esperar a IA voltar.
+func aiDegraded(orgID int64) bool {
+	if orgID <= 0 || !aiFallbackEnabled() {
+		return false
+	}
+	aiHealth.Lock()
+	defer aiHealth.Unlock()
+	return aiHealth.degraded[orgID]
+}
+
+// noteAIResult conta o resultado de uma chamada de IA da org. Cancelamento
+// pelo cliente não conta como falha.
+func (a *App) noteAIResult(ctx context.Context, orgID int64, err error) {
+	if orgID <= 0 || !aiFallbackEnabled() || errors.Is(err, context.Canceled) {
+		return
+	}
+	aiHealth.Lock()
+	if err == nil {
+		delete(aiHealth.failures, orgID)
+		recovered := aiHealth.degraded[orgID]
+		aiHealth.degraded[orgID] = false
+		aiHealth.Unlock()
+		if recovered {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				defer cancel()
+				if _, err := a.recoverAI(ctx, orgID, "success"); err != nil {
+					log.Printf("ai fallback org=%d: recover: %v", orgID, err)
+				}
+			}()
+		}
+		return
+	}
+	aiHealth.failures[orgID]++
+	n := aiHealth.failures[orgID]
+	trip := n >= aiFallbackFailures() && !aiHealth.degraded[orgID]
+	if trip {
+		aiHealth.degraded[orgID] = true
+	}
+	aiHealth.Unlock()
+	if trip {
+		a.enterAIDegraded(ctx, orgID, n, err)
+	}
+}
+
+// noteAgentForward conta um encaminhamento de mensagem ao Agente (job
+// agent.forward). Recusa (4xx) não diz nada sobre a IA. Com a org degradada,
+// a mensagem que falhou fica guardada em vez de seguir no backoff da fila
+// (true = guardada; o job termina).
+func (a *App) noteAgentForward(ctx context.Context, orgID int64, f agentForwardJob, err error) bool {
+	var perm permanentErr
+	if errors.As(err, &perm) {
+		return false
+	}
+	a.noteAIResult(ctx, orgID, err)
+	return err != nil && aiDegraded(orgID) && a.holdAgentForward(ctx, orgID, f)
+}
+
+func (a *App) enterAIDegraded(ctx context.Context, orgID int64, failures int, cause error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	tag, err := a.DB.Exec(ctx, `
+INSERT INTO public.ai_degraded_orgs (org_id, failures, last_error, probed_at) VALUES ($1, $2, $3, NOW())
+ON CONFLICT (org_id) DO NOTHING`, orgID, failures, limitRunes(cause.Error(), 500))
+	if err != nil {
+		log.Printf("ai fallback org=%d: %v", orgID, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		return // outra réplica já entrou no modo
+	}
+	log.Printf("ai fallback org=%d: degraded after %d failures: %v", orgID, failures, cause)
+	a.publishEvent(ctx, orgID, eventAIDegraded, map[string]any{
+		"org_id": orgID, "failures": failures, "error": limitRunes(cause.Error(), 200),
+		"since": time.Now().UTC().Format(time.RFC3339),
+	})
+	go a.notifyAIFallback(orgID, "PacLead: atendimento por IA fora do ar",
+		fmt.Sprintf("A IA falhou %d vezes seguidas (último erro: %s).\n\n"+
+			"As mensagens recebidas no WhatsApp estão guardadas e os contatos receberam um aviso de espera. "+
+			"Elas serão reprocessadas quando a IA voltar; até lá, acompanhe as conversas pelo inbox.\n",
+			failures, limitRunes(cause.Error(), 200)))
+}
+
+// recoverAI encerra o modo degradado da org e devolve as mensagens guardadas
+// à fila. Só notifica quem removeu a linha de ai_degraded_orgs.
+func (a *App) recoverAI(ctx context.Context, orgID int64, via string) (int64, error) {
+	aiHealth.Lock()
+	aiHealth.degraded[orgID] = false
+	delete(aiHealth.failures, orgID)
+	aiHealth.Unlock()
+
+	var since time.Time
+	err := a.DB.QueryRow(ctx, `DELETE FROM public.ai_degraded_orgs WHERE org_id=$1 RETURNING since`, orgID).Scan(&since)
+	ended := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+	n, err := a.releaseHeldMessages(ctx, orgID)
+	if !ended {
+		return n, err
+	}
+	log.Printf("ai fallback org=%d: recovered (%s) after %s, %d held message(s) released",
+		orgID, via, time.Since(since).Round(time.Second), n)
+	a.publishEvent(ctx, orgID, eventAIRecovered, map[string]any{
+		"org_id": orgID, "since": since.UTC().Format(time.RFC3339),
+		"duration_seconds": int64(time.Since(since).Seconds()), "released": n, "via": via,
+	})
+	go a.notifyAIFallback(orgID, "PacLead: atendimento por IA normalizado",
+		fmt.Sprintf("A IA voltou a responder depois de %s. %d mensagem(ns) guardada(s) voltaram para o agente.\n",
+			time.Since(since).Round(time.Second), n))
+	return n, err
+}
+
+// ---------------- mensagens guardadas ----------------
+
+// holdAgentForward guarda o encaminhamento para quando a IA voltar e manda o
+// aviso de espera aos contatos. false = evento sem mensagem de contato
+// (status, eco dos nossos envios...) ou erro ao gravar: segue pela fila.
+func (a *App) holdAgentForward(ctx context.Context, orgID int64, f agentForwardJob) bool {
+	contacts := forwardContacts(f.Body)
+	if len(contacts) == 0 {
+		return false
+	}
+	payload, err := json.Marshal(f)
+	if err != nil {
+		return false
+	}
+	if _, err := a.DB.Exec(ctx, `
+INSERT INTO public.ai_held_messages (org_id, instance_id, contacts, payload) VALUES ($1, $2, $3, $4::jsonb)`,
+		orgID, f.Instance, contacts, string(payload)); err != nil {
+		log.Printf("ai fallback org=%d: hold %s: %v", orgID, f.Instance, err)
+		return false
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		a.sendAIFallbackReplies(ctx, f.Instance, contacts)
+	}()
+	return true
+}
+
+// forwardContacts lista os contatos que mandaram mensagem no evento (uazapi
+// ou API oficial).
+func forwardContacts(body []byte) []string {
+	msgs := uazapiMessagesFromWebhook(body)
+	for _, ms := range metaCloudMessagesFromWebhook(body) {
+		msgs = append(msgs, ms...)
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, m := range msgs {
+		if m.FromMe || m.Chat == "" || seen[m.Chat] {
+			continue
+		}
+		seen[m.Chat] = true
+		out = append(out, m.Chat)
+	}
+	return out
+}
+
+// sendAIFallbackReplies manda AI_FALLBACK_MESSAGE a quem ainda não recebeu o
+// aviso dentro de AI_FALLBACK_REPLY_EVERY.
+func (a *App) sendAIFallbackReplies(ctx context.Context, instance string, contacts []string) {
+	text := getenv("AI_FALLBACK_MESSAGE", aiFallbackDefaultMessage)
+	cutoff := time.Now().Add(-aiFallbackDuration("AI_FALLBACK_REPLY_EVERY", 30*time.Minute))
+	var row *waInstanceRow
+	for _, c := range contacts {
+		tag, err := a.DB.Exec(ctx, `
+INSERT INTO public.ai_fallback_replies (instance_id, contact, sent_at) VALUES ($1, $2, NOW())
+ON CONFLICT (instance_id, contact) DO UPDATE SET sent_at = NOW()
+ WHERE public.ai_fallback_replies.sent_at < $3`, instance, c, cutoff)
+		if err != nil {
+			log.Printf("ai fallback %s/%s: %v", instance, c, err)
+			continue
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		if row == nil {
+			r, err := a.fetchWAInstance(ctx, instance)
+			if err != nil {
+				log.Printf("ai fallback %s: %v", instance, err)
+				return
+			}
+			row = &r
+		}
+		if _, _, err := a.sendWAText(ctx, *row, row.Token, c, text); err != nil {
+			log.Printf("ai fallback %s/%s: send: %v", instance, c, err)
+		}
+	}
+}
+
+// releaseHeldMessages devolve as mensagens guardadas da org à fila
+// agent.forward, na ordem de chegada, numa única instrução (marcar e
+// enfileirar juntos).
+func (a *App) releaseHeldMessages(ctx context.Context, orgID int64) (int64, error) {
+	tag, err := a.DB.Exec(ctx, `
+WITH held AS (
+  UPDATE public.ai_held_messages SET released_at = NOW()
+   WHERE id IN (SELECT id FROM public.ai_held_messages
+                 WHERE org_id=$1 AND released_at IS NULL
+                 ORDER BY id FOR UPDATE SKIP LOCKED)
+  RETURNING id, org_id, payload
+)
+INSERT INTO public.jobs (kind, org_id, payload, max_attempts, run_at)
+SELECT $2, org_id, payload, $3, NOW() FROM held ORDER BY id`,
+		orgID, jobAgentForward, jobKinds[jobAgentForward].policy.MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() > 0 {
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ---------------- worker ----------------
+
+func (a *App) aiFallbackLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		if aiFallbackEnabled() {
+			a.syncAIDegraded(ctx)
+			a.probeDegradedOrgs(ctx)
+		}
+		a.releaseStrayHeld(ctx)
+		cancel()
+	}
+}
+
+// syncAIDegraded relê ai_degraded_orgs (entradas e saídas de outras réplicas).
+func (a *App) syncAIDegraded(ctx context.Context) {
+	rows, err := a.DB.Query(ctx, `SELECT org_id FROM public.ai_degraded_orgs`)
+	if err != nil {
+		log.Printf("ai fallback sync: %v", err)
+		return
+	}
+	degraded := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			degraded[id] = true
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return
+	}
+	aiHealth.Lock()
+	aiHealth.degraded = degraded
+	aiHealth.Unlock()
+}
+
+// probeDegradedOrgs reserva as orgs cujo último teste passou de
+// AI_FALLBACK_PROBE_INTERVAL e testa cada uma.
+func (a *App) probeDegradedOrgs(ctx context.Context) {
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.ai_degraded_orgs SET probed_at = NOW()
+ WHERE probed_at IS NULL OR probed_at < $1
+RETURNING org_id`, time.Now().Add(-aiFallbackDuration("AI_FALLBACK_PROBE_INTERVAL", time.Minute)))
+	if err != nil {
+		log.Printf("ai fallback probe: %v", err)
+		return
+	}
+	var orgs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			orgs = append(orgs, id)
+		}
+	}
+	rows.Close()
+	for _, orgID := range orgs {
+		if err := a.probeAI(ctx, orgID); err != nil {
+			log.Printf("ai fallback org=%d: still down: %v", orgID, err)
+		}
+	}
+}
+
+// probeAI testa a IA da org com a mensagem guardada mais antiga ou, sem
+// nenhuma, com uma chamada mínima ao LLM. Sucesso encerra o modo.
+func (a *App) probeAI(ctx context.Context, orgID int64) error {
+	var id int64
+	var payload []byte
+	err := a.DB.QueryRow(ctx, `
+SELECT id, payload FROM public.ai_held_messages
+ WHERE org_id=$1 AND released_at IS NULL ORDER BY id LIMIT 1`, orgID).Scan(&id, &payload)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		err = a.probeLLM(ctx, orgID)
+	case err != nil:
+		return err
+	default:
+		var f agentForwardJob
+		if err = json.Unmarshal(payload, &f); err == nil {
+			pctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			err = a.postAgentForward(pctx, f)
+			cancel()
+		}
+		// entregue, ou recusado de vez pelo Agente (que então respondeu):
+		// não volta para a fila
+		var perm permanentErr
+		if err == nil || errors.As(err, &perm) {
+			_, _ = a.DB.Exec(ctx, `UPDATE public.ai_held_messages SET released_at = NOW() WHERE id=$1`, id)
+			err = nil
+		}
+	}
+	if err != nil {
+		_, _ = a.DB.Exec(ctx, `
+UPDATE public.ai_degraded_orgs SET failures = failures + 1, last_error = $2 WHERE org_id=$1`,
+			orgID, limitRunes(err.Error(), 500))
+		return err
+	}
+	_, err = a.recoverAI(ctx, orgID, "probe")
+	return err
+}
+
+func (a *App) probeLLM(ctx context.Context, orgID int64) error {
+	p, model, err := a.llmFor(ctx, orgID, 0) // ai_llm.go
+	if err != nil {
+		return err
+	}
+	_, err = p.Chat(ctx, openai.ChatCompletionRequest{
+		Model:     model,
+		MaxTokens: 1,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+	})
+	return err
+}
+
+// releaseStrayHeld libera mensagens guardadas de orgs fora do modo
+// degradado: gravadas por uma réplica que ainda não tinha visto a saída,
+// ou com o modo desligado depois.
+func (a *App) releaseStrayHeld(ctx context.Context) {
+	rows, err := a.DB.Query(ctx, `
+SELECT DISTINCT h.org_id FROM public.ai_held_messages h
+ WHERE h.released_at IS NULL
+   AND NOT EXISTS (SELECT 1 FROM public.ai_degraded_orgs d WHERE d.org_id = h.org_id)`)
+	if err != nil {
+		log.Printf("ai fallback release: %v", err)
+		return
+	}
+	var orgs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			orgs = append(orgs, id)
+		}
+	}
+	rows.Close()
+	for _, orgID := range orgs {
+		if n, err := a.releaseHeldMessages(ctx, orgID); err != nil {
+			log.Printf("ai fallback org=%d: release: %v", orgID, err)
+		} else if n > 0 {
+			log.Printf("ai fallback org=%d: %d held message(s) released", orgID, n)
+		}
+	}
+}
+
+// notifyAIFallback avisa por e-mail os admins da org e AI_FALLBACK_ALERT_EMAIL.
+func (a *App) notifyAIFallback(orgID int64, subject, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var to []string
+	if ops := strings.TrimSpace(getenv("AI_FALLBACK_ALERT_EMAIL", "")); ops != "" {
+		to = append(to, ops)
+	}
+	rows, err := a.DB.Query(ctx, `SELECT COALESCE(email,''), role FROM public.users WHERE org_id=$1`, orgID)
+	if err != nil {
+		log.Printf("ai fallback org=%d: notify: %v", orgID, err)
+	} else {
+		for rows.Next() {
+			var email, role string
+			if err := rows.Scan(&email, &role); err == nil && email != "" && roleAtLeast(role, roleAdmin) {
+				to = append(to, email)
+			}
+		}
+		rows.Close()
+	}
+	sender := emailSenderFromEnv()
+	for _, addr := range to {
+		if err := sender.Send(ctx, emailMessage{To: addr, Subject: subject, Text: text}); err != nil {
+			log.Printf("ai fallback org=%d: email %s: %v", orgID, addr, err)
+		}
+	}
+}
+
+// ---------------- rotas ----------------
+
+type aiFallbackState struct {
+	Enabled   bool       `json:"enabled"`
+	Degraded  bool       `json:"degraded"`
+	Since     *time.Time `json:"since,omitempty"`
+	Failures  int        `json:"failures,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	ProbedAt  *time.Time `json:"probed_at,omitempty"`
+	Held      int        `json:"held"`
+	OldestAt  *time.Time `json:"oldest_held_at,omitempty"`
+}
+
+// GET /api/ai/status
+func (a *App) aiFallbackStatus(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _ := tenantOf(r)
+	ctx := r.Context()
+	out := aiFallbackState{Enabled: aiFallbackEnabled()}
+	var since time.Time
+	err := a.DB.QueryRow(ctx, `
+SELECT since, failures, COALESCE(last_error,''), probed_at FROM public.ai_degraded_orgs WHERE org_id=$1`,
+		orgID).Scan(&since, &out.Failures, &out.LastError, &out.ProbedAt)
+	switch {
+	case err == nil:
+		out.Degraded, out.Since = true, &since
+	case !errors.Is(err, pgx.ErrNoRows):
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.DB.QueryRow(ctx, `
+SELECT COUNT(*), MIN(created_at) FROM public.ai_held_messages WHERE org_id=$1 AND released_at IS NULL`,
+		orgID).Scan(&out.Held, &out.OldestAt); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, out)
+}
+
+// POST /api/ai/status/release
+func (a *App) releaseAIFallback(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _ := tenantOf(r)
+	n, err := a.recoverAI(r.Context(), orgID, "manual")
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"released": n})
+}
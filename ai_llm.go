@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/llm"
+)
+
+// ================================================================
+//  Provedor de IA por tenant (chat, visão, campanhas)
+// ================================================================
+//
+// agent_settings.llm_provider/llm_model (ou os da persona do flow) escolhem
+// o provedor e o modelo do org/flow; sem eles valem LLM_PROVIDER (padrão
+// openai) e o modelo padrão do provedor (llm.ConfigFromEnv). LLM_FALLBACK
+// ("anthropic,gemini") lista os provedores tentados, em ordem, quando o
+// principal falha com erro de rede, 429 ou 5xx. Provedores sem credenciais
+// são ignorados no fallback.
+//
+// Com chave própria da org (org_llm_credentials.go) o provedor usa essa
+// chave, inclusive no fallback; a chave da plataforma só entra depois, com
+// LLM_BYOK_PLATFORM_FALLBACK=true.
+
+// llmFor devolve o provedor do tenant e o modelo configurado ("" = padrão
+// do provedor; o de visão quando a mensagem tem imagem).
+func (a *App) llmFor(ctx context.Context, orgID, flowID int64) (llm.Provider, string, error) {
+	var name, model string
+	if orgID > 0 {
+		// a persona atribuída ao flow (agent_personas.go) tem precedência
+		err := a.DB.QueryRow(ctx, `
+SELECT COALESCE(p.llm_provider, s.llm_provider, ''),
+       CASE WHEN p.llm_provider IS NOT NULL THEN COALESCE(p.llm_model,'') ELSE COALESCE(s.llm_model,'') END
+  FROM agent_settings s
+  LEFT JOIN agent_personas p ON p.id = s.persona_id
+ WHERE s.org_id=$1 AND s.flow_id=$2`, orgID, flowID).Scan(&name, &model)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("llm settings org=%d flow=%d: %v", orgID, flowID, err)
+		}
+	}
+	name = strings.ToLower(firstNonEmpty(name, strings.TrimSpace(os.Getenv("LLM_PROVIDER")), llm.OpenAI))
+
+	var chain llm.Chain
+	var platform []string // provedores com chave da org, para o último recurso
+	add := func(provider string) error {
+		cfg, byok := a.orgLLMConfig(ctx, orgID, provider)
+		p, err := llm.New(cfg)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, meterLLM(p, orgID))
+		if byok {
+			platform = append(platform, provider)
+		}
+		return nil
+	}
+	err := add(name)
+	if err != nil {
+		// sem o principal o modelo pedido não se aplica aos demais
+		model = ""
+	}
+	seen := map[string]bool{name: true}
+	for _, fb := range strings.Split(os.Getenv("LLM_FALLBACK"), ",") {
+		fb = strings.ToLower(strings.TrimSpace(fb))
+		if fb == "" || seen[fb] {
+			continue
+		}
+		seen[fb] = true
+		_ = add(fb)
+	}
+	if byokPlatformFallback() {
+		for _, pn := range platform {
+			if p, pErr := llm.New(llm.ConfigFromEnv(pn)); pErr == nil {
+				chain = append(chain, meterLLM(p, orgID))
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return nil, "", err
+	}
+	if err != nil {
+		log.Printf("llm %s unavailable (%v); using %s", name, err, chain.Name())
+	}
+	if len(chain) == 1 {
+		return chain[0], model, nil
+	}
+	return chain, model, nil
+}
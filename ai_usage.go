@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Medição de uso de IA e orçamento mensal por org
+// ================================================================
+//
+// Toda chamada ao provedor (ai_llm.go; no streaming do WebSocket, via
+// include_usage) grava tokens, modelo e custo estimado (ai_cost.go) em
+// ai_usage.
+// Orçamento: ai_budgets.monthly_usd da org ou AI_MONTHLY_BUDGET_USD (padrão
+// 0 = sem limite). Com o mês corrente (UTC) acima do orçamento, chat, visão
+// e campanhas respondem 429 até a virada do mês ou um aumento do limite.
+// Chamadas com a chave própria da org (byok, org_llm_credentials.go) são
+// gravadas mas não contam no orçamento.
+
+// recordAIUsage grava o consumo de uma chamada. Usa contexto próprio para
+// não perder o registro quando o cliente desconecta.
+func (a *App) recordAIUsage(orgID, flowID int64, feature, model string, u openai.Usage) {
+	if u.PromptTokens == 0 && u.CompletionTokens == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := a.DB.Exec(ctx, `
+INSERT INTO public.ai_usage (org_id, flow_id, feature, model, prompt_tokens, completion_tokens, cost_usd, byok)
+VALUES ($1, $2, $3, $4, $5, $6, $7,
+        EXISTS (SELECT 1 FROM public.org_llm_credentials WHERE org_id=$1 AND provider=$8))`,
+		orgID, flowID, feature, model, u.PromptTokens, u.CompletionTokens,
+		estimateCostUSD(model, u.PromptTokens, u.CompletionTokens), llmProviderOfModel(model))
+	if err != nil {
+		log.Printf("ai usage org=%d: %v", orgID, err)
+	}
+}
+
+// aiMonthlyBudget devolve o orçamento da org em USD (0 = sem limite).
+func (a *App) aiMonthlyBudget(ctx context.Context, orgID int64) (float64, error) {
+	var budget float64
+	err := a.DB.QueryRow(ctx, `SELECT monthly_usd::float8 FROM public.ai_budgets WHERE org_id=$1`, orgID).Scan(&budget)
+	if errors.Is(err, pgx.ErrNoRows) {
+		v, _ := strconv.ParseFloat(getenv("AI_MONTHLY_BUDGET_USD", "0"), 64)
+		return v, nil
+	}
+	return budget, err
+}
+
+func monthStartUTC(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (a *App) aiSpentThisMonth(ctx context.Context, orgID int64) (float64, error) {
+	var spent float64
+	err := a.DB.QueryRow(ctx,
+		`SELECT COALESCE(SUM(cost_usd),0)::float8 FROM public.ai_usage WHERE org_id=$1 AND created_at >= $2 AND NOT byok`,
+		orgID, monthStartUTC(time.Now())).Scan(&spent)
+	return spent, err
+}
+
+// aiBudgetExceeded informa se a org já passou do orçamento do mês. Falhas
+// de consulta não bloqueiam o atendimento.
+func (a *App) aiBudgetExceeded(ctx context.Context, orgID int64) (bool, float64, float64) {
+	if orgID <= 0 {
+		return false, 0, 0
+	}
+	budget, err := a.aiMonthlyBudget(ctx, orgID)
+	if err != nil || budget <= 0 {
+		return false, 0, budget
+	}
+	spent, err := a.aiSpentThisMonth(ctx, orgID)
+	if err != nil {
+		log.Printf("ai budget org=%d: %v", orgID, err)
+		return false, 0, budget
+	}
+	return spent >= budget, spent, budget
+}
+
+// requireAIBudget responde 429 quando o orçamento acabou ou a org passou da
+// sua fatia da cota global; devolve false nesse caso.
+func (a *App) requireAIBudget(w http.ResponseWriter, r *http.Request, orgID int64) bool {
+	// fatia da cota global compartilhada (provider_quota.go)
+	if a.aiQuotaShareExceeded(r.Context(), orgID) {
+		w.Header().Set("Retry-After", "60")
+		render.Error(w, http.StatusTooManyRequests, aiQuotaShareMessage())
+		return false
+	}
+	exceeded, spent, budget := a.aiBudgetExceeded(r.Context(), orgID)
+	if !exceeded {
+		return true
+	}
+	next := monthStartUTC(time.Now()).AddDate(0, 1, 0)
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(next).Seconds())))
+	render.Error(w, http.StatusTooManyRequests, aiBudgetMessage(spent, budget))
+	return false
+}
+
+func aiBudgetMessage(spent, budget float64) string {
+	return fmt.Sprintf("monthly AI budget exceeded (US$ %.2f of US$ %.2f)", spent, budget)
+}
+
+// GET /api/analytics/ai-usage?from=&to=&tz=
+func (a *App) analyticsAIUsage(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx := r.Context()
+	rows, err := a.DB.Query(ctx, `
+SELECT to_char(created_at AT TIME ZONE $4, 'YYYY-MM-DD'), feature, model,
+       COUNT(*), SUM(prompt_tokens), SUM(completion_tokens), SUM(cost_usd)::float8
+  FROM public.ai_usage
+ WHERE org_id=$1
+   AND ($2::timestamptz IS NULL OR created_at >= $2) AND ($3::timestamptz IS NULL OR created_at < $3)
+ GROUP BY 1, 2, 3
+ ORDER BY 1, 2, 3`, orgID, rg.From, rg.To, rg.TZ)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	type row struct {
+		Day              string  `json:"day"`
+		Feature          string  `json:"feature"`
+		Model            string  `json:"model"`
+		Calls            int64   `json:"calls"`
+		PromptTokens     int64   `json:"prompt_tokens"`
+		CompletionTokens int64   `json:"completion_tokens"`
+		CostUSD          float64 `json:"cost_usd"`
+	}
+	items := []row{}
+	var total float64
+	for rows.Next() {
+		var x row
+		if err := rows.Scan(&x.Day, &x.Feature, &x.Model, &x.Calls, &x.PromptTokens, &x.CompletionTokens, &x.CostUSD); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		x.CostUSD = roundUSD(x.CostUSD)
+		total += x.CostUSD
+		items = append(items, x)
+	}
+
+	budget, _ := a.aiMonthlyBudget(ctx, orgID)
+	spent, _ := a.aiSpentThisMonth(ctx, orgID)
+	render.OK(w, map[string]any{
+		"items":     items,
+		"total_usd": roundUSD(total),
+		"range":     rg.meta(),
+		"month": map[string]any{
+			"spent_usd":  roundUSD(spent),
+			"budget_usd": budget,
+			"exceeded":   budget > 0 && spent >= budget,
+		},
+	})
+}
+
+// PUT /api/admin/orgs/{org_id}/ai-budget  {"monthly_usd": 50}
+// monthly_usd negativo remove a sobrescrita (volta ao AI_MONTHLY_BUDGET_USD).
+func (a *App) adminSetAIBudget(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "org_id"), 10, 64)
+	if err != nil || orgID <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid org_id")
+		return
+	}
+	var in struct {
+		MonthlyUSD *float64 `json:"monthly_usd"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.MonthlyUSD == nil {
+		render.Error(w, http.StatusBadRequest, "monthly_usd required")
+		return
+	}
+	if *in.MonthlyUSD < 0 {
+		_, err = a.DB.Exec(r.Context(), `DELETE FROM public.ai_budgets WHERE org_id=$1`, orgID)
+	} else {
+		_, err = a.DB.Exec(r.Context(), `
+INSERT INTO public.ai_budgets (org_id, monthly_usd) VALUES ($1, $2)
+ON CONFLICT (org_id) DO UPDATE SET monthly_usd=EXCLUDED.monthly_usd, updated_at=NOW()`, orgID, *in.MonthlyUSD)
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	budget, _ := a.aiMonthlyBudget(r.Context(), orgID)
+	render.OK(w, map[string]any{"org_id": orgID, "budget_usd": budget})
+}
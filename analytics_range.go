@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata" // fusos embutidos: não depende do zoneinfo da imagem
+)
+
+// ================================================================
+//  Período e fuso das análises (?from=&to=&tz=)
+// ================================================================
+//
+// from/to aceitam data (2026-10-01, interpretada no fuso) ou RFC3339. "to"
+// como data inclui o dia inteiro. Sem from/to o período é todo o histórico.
+// tz é um nome IANA (America/Sao_Paulo); padrão ANALYTICS_DEFAULT_TZ ou UTC.
+// Agrupamentos por hora/dia usam o fuso informado.
+
+const analyticsMaxRange = 366 * 24 * time.Hour
+
+type analyticsRange struct {
+	From *time.Time
+	To   *time.Time
+	TZ   string
+	Loc  *time.Location
+}
+
+func parseAnalyticsRange(r *http.Request) (analyticsRange, error) {
+	q := r.URL.Query()
+	rg := analyticsRange{TZ: strings.TrimSpace(q.Get("tz"))}
+	if rg.TZ == "" {
+		rg.TZ = getenv("ANALYTICS_DEFAULT_TZ", "UTC")
+	}
+	loc, err := time.LoadLocation(rg.TZ)
+	if err != nil {
+		return rg, fmt.Errorf("invalid tz %q", rg.TZ)
+	}
+	rg.Loc = loc
+
+	parse := func(name string, endOfDay bool) (*time.Time, error) {
+		v := strings.TrimSpace(q.Get(name))
+		if v == "" {
+			return nil, nil
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return &t, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q (use YYYY-MM-DD or RFC3339)", name, v)
+		}
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return &t, nil
+	}
+	if rg.From, err = parse("from", false); err != nil {
+		return rg, err
+	}
+	if rg.To, err = parse("to", true); err != nil {
+		return rg, err
+	}
+	if rg.From != nil && rg.To != nil {
+		if !rg.From.Before(*rg.To) {
+			return rg, fmt.Errorf("from must be before to")
+		}
+		if rg.To.Sub(*rg.From) > analyticsMaxRange {
+			return rg, fmt.Errorf("range too large (max 366 days)")
+		}
+	}
+	return rg, nil
+}
+
+// meta devolve o período efetivo para eco na resposta.
+func (rg analyticsRange) meta() map[string]any {
+	out := map[string]any{"tz": rg.TZ}
+	if rg.From != nil {
+		out["from"] = rg.From.In(rg.Loc)
+	}
+	if rg.To != nil {
+		out["to"] = rg.To.In(rg.Loc)
+	}
+	return out
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Chaves de API por org (integrações servidor a servidor)
+// ================================================================
+//
+// Alternativa ao JWT pessoal para n8n e afins: o header X-API-Key resolve o
+// tenant (org e flow da chave) em resolveTenant, tanto no grupo autenticado
+// quanto nas rotas legadas. A chave só alcança as rotas cobertas pelos seus
+// escopos (apiKeyRouteScope); o resto responde 403.
+//
+// POST   /api/orgs/api-keys       (admin) {name, scopes[], flow_id?, expires_at?}
+//                                  devolve a chave completa uma única vez
+// GET    /api/orgs/api-keys       chaves da org, sem o segredo
+// DELETE /api/orgs/api-keys/{id}  (admin) revoga
+//
+// Formato: "pak_" + 48 hex. Guardamos só o SHA-256 e o prefixo de exibição.
+
+const (
+	apiKeyPrefix        = "pak_"
+	apiKeyDisplayPrefix = 12
+
+	scopeReadProducts = "read:products"
+	scopeReadLeads    = "read:leads"
+	scopeWriteLeads   = "write:leads"
+	scopeSendWA       = "send:wa"
+)
+
+var apiKeyScopes = []string{scopeReadProducts, scopeReadLeads, scopeWriteLeads, scopeSendWA}
+
+var (
+	errAPIKeyInvalid   = errors.New("invalid api key")
+	errAPIKeyForbidden = errors.New("api key not allowed for this route")
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyRouteScope diz qual escopo a rota exige de uma chave de API; "" =
+// rota fora do alcance das chaves.
+func apiKeyRouteScope(method, path string) string {
+	path = strings.TrimSuffix(path, "/")
+	read := method == http.MethodGet || method == http.MethodHead
+	switch {
+	case path == "/api/products" || strings.HasPrefix(path, "/api/products/"):
+		if read {
+			return scopeReadProducts
+		}
+	case path == "/api/leads" || strings.HasPrefix(path, "/api/leads/"):
+		if read {
+			return scopeReadLeads
+		}
+		return scopeWriteLeads
+	case strings.HasPrefix(path, "/api/wa/instances/"):
+		if strings.Contains(path, "/send/") && method == http.MethodPost {
+			return scopeSendWA
+		}
+		if read && (strings.HasSuffix(path, "/status") || strings.HasSuffix(path, "/window") || strings.HasSuffix(path, "/templates")) {
+			return scopeSendWA
+		}
+	}
+	return ""
+}
+
+func hasScope(scopes []string, want string) bool {
+	for _, s := range scopes {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyTenant resolve o tenant pelo header X-API-Key. ok=false sem header.
+func (a *App) apiKeyTenant(r *http.Request) (tenantCtx, bool, error) {
+	key := headerTrim(r, "X-API-Key")
+	if key == "" {
+		return tenantCtx{}, false, nil
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return tenantCtx{}, false, errAPIKeyInvalid
+	}
+	var (
+		t        tenantCtx
+		lastUsed *time.Time
+	)
+	err := a.DB.QueryRow(r.Context(), `
+SELECT id, org_id, flow_id, scopes, last_used_at
+  FROM public.api_keys
+ WHERE key_hash=$1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		hashAPIKey(key)).Scan(&t.APIKeyID, &t.OrgID, &t.FlowID, &t.Scopes, &lastUsed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return tenantCtx{}, false, errAPIKeyInvalid
+	}
+	if err != nil {
+		return tenantCtx{}, false, err
+	}
+	if !hasScope(t.Scopes, apiKeyRouteScope(r.Method, r.URL.Path)) {
+		return tenantCtx{}, false, errAPIKeyForbidden
+	}
+	// last_used_at com resolução de 1 min, sem segurar a requisição
+	if lastUsed == nil || time.Since(*lastUsed) > time.Minute {
+		go func(id int64) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := a.DB.Exec(ctx, `UPDATE public.api_keys SET last_used_at=NOW() WHERE id=$1`, id); err != nil {
+				log.Printf("api key %d last_used_at: %v", id, err)
+			}
+		}(t.APIKeyID)
+	}
+	t.Source = tenantSourceAPIKey
+	return t, true, nil
+}
+
+func (a *App) mountAPIKeys(r chi.Router) {
+	r.Get("/orgs/api-keys", a.listAPIKeys)
+	r.With(a.requireRole(roleAdmin)).Post("/orgs/api-keys", a.createAPIKey)
+	r.With(a.requireRole(roleAdmin)).Delete("/orgs/api-keys/{id}", a.revokeAPIKey)
+}
+
+type apiKey struct {
+	ID         int64      `json:"id"`
+	FlowID     int64      `json:"flow_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int64     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// POST /api/orgs/api-keys
+func (a *App) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	var in struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		FlowID    int64      `json:"flow_id"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		render.Error(w, http.StatusBadRequest, "name required")
+		return
+	}
+	scopes := []string{}
+	for _, s := range in.Scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !hasScope(apiKeyScopes, s) {
+			render.Error(w, http.StatusBadRequest, "invalid scope "+strconv.Quote(s)+" (use "+strings.Join(apiKeyScopes, ", ")+")")
+			return
+		}
+		if !hasScope(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		render.Error(w, http.StatusBadRequest, "scopes required")
+		return
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		render.Error(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+	if in.FlowID <= 0 {
+		in.FlowID = c.FlowID
+	} else {
+		var ok bool
+		if err := a.DB.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM flows WHERE id=$1 AND org_id=$2)`,
+			in.FlowID, c.OrgID).Scan(&ok); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !ok {
+			render.Error(w, http.StatusBadRequest, "flow not found")
+			return
+		}
+	}
+
+	secret := apiKeyPrefix + secureToken(24)
+	k := apiKey{FlowID: in.FlowID, Name: in.Name, Prefix: secret[:apiKeyDisplayPrefix], Scopes: scopes, CreatedBy: &c.UserID, ExpiresAt: in.ExpiresAt}
+	err := a.DB.QueryRow(r.Context(), `
+INSERT INTO public.api_keys (org_id, flow_id, name, prefix, key_hash, scopes, created_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		c.OrgID, k.FlowID, k.Name, k.Prefix, hashAPIKey(secret), k.Scopes, c.UserID, k.ExpiresAt).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, map[string]any{"api_key": k, "key": secret})
+}
+
+// GET /api/orgs/api-keys
+func (a *App) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, flow_id, name, prefix, scopes, created_by, created_at, last_used_at, expires_at, revoked_at
+  FROM public.api_keys WHERE org_id=$1 ORDER BY revoked_at IS NOT NULL, id DESC`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []apiKey{}
+	for rows.Next() {
+		var k apiKey
+		if err := rows.Scan(&k.ID, &k.FlowID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedBy, &k.CreatedAt,
+			&k.LastUsedAt, &k.ExpiresAt, &k.RevokedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, k)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// DELETE /api/orgs/api-keys/{id}
+func (a *App) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `
+UPDATE public.api_keys SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id=$1 AND org_id=$2`, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "api key not found")
+		return
+	}
+	render.NoContent(w)
+}
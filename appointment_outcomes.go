@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Agenda: faltas, cancelamentos e remarcação
+// ================================================================
+//
+// O resultado de cada agendamento fica em status (done, no_show, canceled)
+// com outcome_at (migrations/0020). Com APPOINTMENT_NO_SHOW_AFTER (ex.: 2h;
+// vazio desliga) o worker de lembretes marca como falta o que continua
+// "booked" depois do fim + tolerância.
+//
+// Cancelamento e falta disparam uma única oferta de remarcação por WhatsApp
+// com os próximos horários livres (APPOINTMENT_REBOOK_OFFER=false desliga).
+// Se o lead marcar de novo pelo agente em até 30 dias, o novo agendamento
+// aponta para o anterior (rebooked_from).
+//
+// GET /api/analytics/appointments?from=&to=&tz=   comparecimento, faltas,
+//                                                  cancelamentos e remarcações
+
+const rebookWindow = 30 * 24 * time.Hour
+
+func rebookOfferEnabled() bool {
+	return strings.ToLower(getenv("APPOINTMENT_REBOOK_OFFER", "true")) != "false"
+}
+
+func appointmentNoShowAfter() time.Duration {
+	d, err := time.ParseDuration(getenv("APPOINTMENT_NO_SHOW_AFTER", ""))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// offerRebooking manda ao lead os próximos horários livres, uma vez por
+// agendamento. Roda em segundo plano.
+func (a *App) offerRebooking(ctx context.Context, ap appointment) {
+	if ap.LeadID == nil || !rebookOfferEnabled() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := a.sendRebookingOffer(ctx, ap); err != nil {
+			log.Printf("appointment %d rebooking offer: %v", ap.ID, err)
+		}
+	}()
+}
+
+func (a *App) sendRebookingOffer(ctx context.Context, ap appointment) error {
+	tag, err := a.DB.Exec(ctx, `
+UPDATE public.appointments SET rebook_offer_sent_at=NOW() WHERE id=$1 AND rebook_offer_sent_at IS NULL`, ap.ID)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	now := time.Now()
+	slots, err := a.freeSlots(ctx, ap.OrgID, ap.FlowID, ap.UserID, now, now.Add(7*24*time.Hour))
+	if err != nil {
+		return err
+	}
+	loc := appointmentLoc()
+	var options []string
+	seen := map[time.Time]bool{}
+	for _, s := range slots {
+		if seen[s.StartsAt] || len(options) == 3 {
+			continue
+		}
+		seen[s.StartsAt] = true
+		lt := s.StartsAt.In(loc)
+		options = append(options, "• "+weekdayPT[lt.Weekday()]+" "+lt.Format("02/01 às 15:04"))
+	}
+
+	var b strings.Builder
+	if ap.Status == appointmentNoShow {
+		b.WriteString("Sentimos sua falta no horário de " + ap.StartsAt.In(loc).Format("02/01 às 15:04") + ".")
+	} else {
+		b.WriteString("Seu horário de " + ap.StartsAt.In(loc).Format("02/01 às 15:04") + " foi cancelado.")
+	}
+	if len(options) > 0 {
+		b.WriteString(" Quer remarcar? Temos estes horários:\n" + strings.Join(options, "\n"))
+		b.WriteString("\n\nÉ só responder com o horário que preferir.")
+	} else {
+		b.WriteString(" Quer remarcar? Responda esta mensagem que encontramos um horário para você.")
+	}
+
+	row, contact, err := a.leadWAContact(ctx, ap.OrgID, ap.FlowID, *ap.LeadID)
+	if err != nil {
+		return err
+	}
+	_, _, err = a.sendWAText(ctx, row, row.Token, contact, b.String())
+	return err
+}
+
+// markNoShows registra como falta os agendamentos vencidos sem resultado.
+func (a *App) markNoShows() {
+	after := appointmentNoShowAfter()
+	if after <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.appointments SET status='no_show', outcome_at=NOW(), updated_at=NOW()
+ WHERE id IN (SELECT id FROM public.appointments
+               WHERE status='booked' AND ends_at < NOW() - $1::interval
+               ORDER BY ends_at LIMIT 200 FOR UPDATE SKIP LOCKED)
+RETURNING `+appointmentColumns, after.String())
+	if err != nil {
+		log.Printf("appointment no-shows: %v", err)
+		return
+	}
+	var marked []appointment
+	for rows.Next() {
+		ap, err := scanAppointment(rows)
+		if err != nil {
+			log.Printf("appointment no-shows: %v", err)
+			continue
+		}
+		marked = append(marked, ap)
+	}
+	rows.Close()
+	for _, ap := range marked {
+		a.offerRebooking(ctx, ap)
+	}
+}
+
+// pendingRebooking devolve o último agendamento cancelado/faltado do lead
+// com oferta de remarcação recente e ainda não remarcado.
+func (a *App) pendingRebooking(ctx context.Context, orgID, flowID, leadID int64) *int64 {
+	var id int64
+	err := a.DB.QueryRow(ctx, `
+SELECT p.id FROM public.appointments p
+ WHERE p.org_id=$1 AND p.flow_id=$2 AND p.lead_id=$3
+   AND p.status IN ('canceled','no_show') AND p.rebook_offer_sent_at > NOW() - $4::interval
+   AND NOT EXISTS (SELECT 1 FROM public.appointments n WHERE n.rebooked_from = p.id)
+ ORDER BY p.rebook_offer_sent_at DESC LIMIT 1`, orgID, flowID, leadID, rebookWindow.String()).Scan(&id)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// ---------------- análise ----------------
+
+type appointmentOutcomeStats struct {
+	Total          int64    `json:"total"`
+	Upcoming       int64    `json:"upcoming"`
+	PendingOutcome int64    `json:"pending_outcome"` // passou e continua "booked"
+	Done           int64    `json:"done"`
+	NoShow         int64    `json:"no_show"`
+	Canceled       int64    `json:"canceled"`
+	AttendanceRate *float64 `json:"attendance_rate"` // done / (done + no_show)
+	NoShowRate     *float64 `json:"no_show_rate"`
+	CancelRate     *float64 `json:"cancel_rate"` // canceled / total
+	RebookOffers   int64    `json:"rebook_offers"`
+	Rebooked       int64    `json:"rebooked"`
+	RebookRate     *float64 `json:"rebook_rate"` // rebooked / rebook_offers
+}
+
+func (s *appointmentOutcomeStats) rates() {
+	ratio := func(n, d int64) *float64 {
+		if d == 0 {
+			return nil
+		}
+		v := float64(n) / float64(d)
+		return &v
+	}
+	s.AttendanceRate = ratio(s.Done, s.Done+s.NoShow)
+	s.NoShowRate = ratio(s.NoShow, s.Done+s.NoShow)
+	s.CancelRate = ratio(s.Canceled, s.Total)
+	s.RebookRate = ratio(s.Rebooked, s.RebookOffers)
+}
+
+// GET /api/analytics/appointments?from=&to=&tz=
+func (a *App) analyticsAppointments(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	const stats = `
+COUNT(*),
+COUNT(*) FILTER (WHERE status='booked' AND starts_at > NOW()),
+COUNT(*) FILTER (WHERE status='booked' AND ends_at <= NOW()),
+COUNT(*) FILTER (WHERE status='done'),
+COUNT(*) FILTER (WHERE status='no_show'),
+COUNT(*) FILTER (WHERE status='canceled'),
+COUNT(*) FILTER (WHERE rebook_offer_sent_at IS NOT NULL),
+COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM public.appointments n WHERE n.rebooked_from = a.id AND n.status <> 'canceled'))`
+	const where = `
+  FROM public.appointments a
+ WHERE org_id=$1 AND flow_id=$2
+   AND ($3::timestamptz IS NULL OR starts_at >= $3) AND ($4::timestamptz IS NULL OR starts_at < $4)`
+	scan := func(sc interface{ Scan(...any) error }, s *appointmentOutcomeStats, extra ...any) error {
+		dst := append(extra, &s.Total, &s.Upcoming, &s.PendingOutcome, &s.Done, &s.NoShow, &s.Canceled, &s.RebookOffers, &s.Rebooked)
+		if err := sc.Scan(dst...); err != nil {
+			return err
+		}
+		s.rates()
+		return nil
+	}
+
+	ctx := r.Context()
+	var total appointmentOutcomeStats
+	if err := scan(a.DB.QueryRow(ctx, `SELECT `+stats+where, orgID, flowID, rg.From, rg.To), &total); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type userStats struct {
+		UserID *int64 `json:"user_id"` // nil = agenda da org
+		appointmentOutcomeStats
+	}
+	byUser := []userStats{}
+	rows, err := a.DB.Query(ctx, `SELECT user_id, `+stats+where+` GROUP BY user_id ORDER BY user_id NULLS FIRST`,
+		orgID, flowID, rg.From, rg.To)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for rows.Next() {
+		var u userStats
+		if err := scan(rows, &u.appointmentOutcomeStats, &u.UserID); err != nil {
+			rows.Close()
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		byUser = append(byUser, u)
+	}
+	rows.Close()
+
+	type dayStats struct {
+		Day string `json:"day"`
+		appointmentOutcomeStats
+	}
+	byDay := []dayStats{}
+	rows, err = a.DB.Query(ctx, fmt.Sprintf(`SELECT to_char(date_trunc('day', starts_at AT TIME ZONE $5), 'YYYY-MM-DD') AS d, %s%s GROUP BY d ORDER BY d`, stats, where),
+		orgID, flowID, rg.From, rg.To, rg.TZ)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for rows.Next() {
+		var d dayStats
+		if err := scan(rows, &d.appointmentOutcomeStats, &d.Day); err != nil {
+			rows.Close()
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		byDay = append(byDay, d)
+	}
+	rows.Close()
+
+	render.OK(w, map[string]any{"totals": total, "by_user": byUser, "by_day": byDay, "range": rg.meta()})
+}
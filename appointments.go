@@ -0,0 +1,954 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Agenda: disponibilidade e agendamentos
+// ================================================================
+//
+// A disponibilidade é uma grade semanal (migrations/0018) da org ou de um
+// usuário: dia da semana, início/fim locais ao fuso da linha e duração do
+// horário. Os horários livres saem da grade menos os agendamentos marcados e
+// os períodos ocupados no Google Agenda (busyIntervals, google_calendar.go).
+// Dois agendamentos da mesma agenda (mesmo user_id, ou ambos da org) não
+// podem se sobrepor.
+//
+// GET  /api/availability
+// PUT  /api/availability                {"slots":[{"weekday":1,"start":"09:00","end":"18:00","slot_minutes":30,"user_id":7,"timezone":"America/Sao_Paulo"}]}
+// GET  /api/appointments/slots          ?from=2026-10-20&days=7&user_id=
+// GET  /api/appointments                ?from=&to=&status=
+// POST /api/appointments                {"title","notes","starts_at","ends_at"|"duration_minutes","user_id","lead_id"}
+// PUT  /api/appointments/{id}           {"starts_at","ends_at","status","notes"}
+// GET  /api/appointments/calendar.ics   exportação iCal (RFC 5545)
+//
+// O agente marca pelo function calling (list_available_slots e
+// book_appointment, chat_tools.go). Lembretes vão por WhatsApp
+// APPOINTMENT_REMINDER_BEFORE (padrão 24h) antes do horário, verificados a
+// cada APPOINTMENT_REMINDER_INTERVAL (padrão 5m; 0 desliga). Faltas e
+// remarcação: appointment_outcomes.go.
+
+const (
+	appointmentBooked   = "booked"
+	appointmentCanceled = "canceled"
+	appointmentDone     = "done"
+	appointmentNoShow   = "no_show"
+
+	maxSlotDays = 31
+)
+
+var errAppointmentConflict = errors.New("time slot already booked")
+
+// appointmentLoc é o fuso padrão da agenda (APPOINTMENT_TZ).
+func appointmentLoc() *time.Location {
+	if loc, err := time.LoadLocation(getenv("APPOINTMENT_TZ", "America/Sao_Paulo")); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+type availabilitySlot struct {
+	ID          int64  `json:"id"`
+	UserID      *int64 `json:"user_id,omitempty"`
+	Weekday     int    `json:"weekday"` // 0 = domingo
+	Start       string `json:"start"`   // "09:00"
+	End         string `json:"end"`
+	SlotMinutes int    `json:"slot_minutes"`
+	Timezone    string `json:"timezone"`
+}
+
+type appointment struct {
+	ID             int64      `json:"id"`
+	OrgID          int64      `json:"org_id"`
+	FlowID         int64      `json:"flow_id"`
+	UserID         *int64     `json:"user_id,omitempty"`
+	LeadID         *int64     `json:"lead_id,omitempty"`
+	Title          string     `json:"title"`
+	Notes          string     `json:"notes,omitempty"`
+	StartsAt       time.Time  `json:"starts_at"`
+	EndsAt         time.Time  `json:"ends_at"`
+	Status         string     `json:"status"`
+	Source         string     `json:"source"`
+	ReminderSentAt *time.Time `json:"reminder_sent_at,omitempty"`
+	// resultado (appointment_outcomes.go)
+	OutcomeAt         *time.Time `json:"outcome_at,omitempty"`
+	CancelReason      string     `json:"cancel_reason,omitempty"`
+	RebookOfferSentAt *time.Time `json:"rebook_offer_sent_at,omitempty"`
+	RebookedFrom      *int64     `json:"rebooked_from,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+const appointmentColumns = `id, org_id, flow_id, user_id, lead_id, title, COALESCE(notes,''), starts_at, ends_at, status, source, reminder_sent_at,
+       outcome_at, COALESCE(cancel_reason,''), rebook_offer_sent_at, rebooked_from, created_at, updated_at`
+
+func scanAppointment(row pgx.Row) (appointment, error) {
+	var ap appointment
+	err := row.Scan(&ap.ID, &ap.OrgID, &ap.FlowID, &ap.UserID, &ap.LeadID, &ap.Title, &ap.Notes,
+		&ap.StartsAt, &ap.EndsAt, &ap.Status, &ap.Source, &ap.ReminderSentAt,
+		&ap.OutcomeAt, &ap.CancelReason, &ap.RebookOfferSentAt, &ap.RebookedFrom, &ap.CreatedAt, &ap.UpdatedAt)
+	return ap, err
+}
+
+// freeSlot é um horário livre oferecido ao lead.
+type freeSlot struct {
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	UserID   *int64    `json:"user_id,omitempty"`
+}
+
+type timeInterval struct{ start, end time.Time }
+
+func (a *App) mountAppointments(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.Get("/availability", a.getAvailability)
+	r.With(admin).Put("/availability", a.putAvailability)
+	r.Route("/appointments", func(r chi.Router) {
+		r.Get("/", a.listAppointments)
+		r.Post("/", a.createAppointment)
+		r.Get("/slots", a.listFreeSlots)
+		r.Get("/calendar.ics", a.exportAppointmentsICal)
+		r.Put("/{id}", a.updateAppointment)
+	})
+	r.With(csvFromJSON("appointments")).Get("/analytics/appointments", a.analyticsAppointments)
+
+	if every := appointmentReminderInterval(); every > 0 {
+		go a.appointmentReminderLoop(every)
+	}
+}
+
+func appointmentReminderInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("APPOINTMENT_REMINDER_INTERVAL", "5m"))
+	if err != nil || d < 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+func appointmentReminderBefore() time.Duration {
+	d, err := time.ParseDuration(getenv("APPOINTMENT_REMINDER_BEFORE", "24h"))
+	if err != nil || d <= 0 {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// ---------------- disponibilidade ----------------
+
+func (a *App) loadAvailability(ctx context.Context, orgID, flowID int64, userID *int64) ([]availabilitySlot, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT id, user_id, weekday, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'), slot_minutes, timezone
+  FROM public.availability_slots
+ WHERE org_id=$1 AND flow_id=$2 AND ($3::bigint IS NULL OR user_id=$3)
+ ORDER BY weekday, start_time, id`, orgID, flowID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []availabilitySlot{}
+	for rows.Next() {
+		var s availabilitySlot
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Weekday, &s.Start, &s.End, &s.SlotMinutes, &s.Timezone); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// GET /api/availability
+func (a *App) getAvailability(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slots, err := a.loadAvailability(r.Context(), orgID, flowID, nil)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"slots": slots})
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// PUT /api/availability — substitui a grade inteira do flow.
+func (a *App) putAvailability(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		Slots []availabilitySlot `json:"slots"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	defTZ := appointmentLoc().String()
+	// todos os campos inválidos de uma vez (details.fields)
+	var fields []render.FieldError
+	invalid := func(i int, field, msg string) {
+		fields = append(fields, render.FieldError{Field: fmt.Sprintf("slots[%d].%s", i, field), Message: msg})
+	}
+	for i := range in.Slots {
+		s := &in.Slots[i]
+		if s.Weekday < 0 || s.Weekday > 6 {
+			invalid(i, "weekday", "must be 0 (sunday) to 6")
+		}
+		start, err := parseClock(s.Start)
+		if err != nil {
+			invalid(i, "start", err.Error())
+		}
+		end, err2 := parseClock(s.End)
+		if err2 != nil {
+			invalid(i, "end", err2.Error())
+		}
+		if err == nil && err2 == nil && start >= end {
+			invalid(i, "start", "must be before end")
+		}
+		if s.SlotMinutes == 0 {
+			s.SlotMinutes = 30
+		}
+		if s.SlotMinutes < 5 || s.SlotMinutes > 480 {
+			invalid(i, "slot_minutes", "must be 5 to 480")
+		}
+		s.Timezone = nonEmpty(strings.TrimSpace(s.Timezone), defTZ)
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			invalid(i, "timezone", fmt.Sprintf("invalid timezone %q", s.Timezone))
+		}
+		if s.UserID != nil {
+			var ok bool
+			if err := a.DB.QueryRow(r.Context(),
+				`SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND org_id=$2)`, *s.UserID, orgID).Scan(&ok); err != nil {
+				render.Error(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !ok {
+				invalid(i, "user_id", "user not found")
+			}
+		}
+	}
+	if len(fields) > 0 {
+		render.Validation(w, fields)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM public.availability_slots WHERE org_id=$1 AND flow_id=$2`, orgID, flowID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, s := range in.Slots {
+		if _, err := tx.Exec(ctx, `
+INSERT INTO public.availability_slots (org_id, flow_id, user_id, weekday, start_time, end_time, slot_minutes, timezone)
+VALUES ($1, $2, $3, $4, $5::time, $6::time, $7, $8)`,
+			orgID, flowID, s.UserID, s.Weekday, s.Start, s.End, s.SlotMinutes, s.Timezone); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slots, err := a.loadAvailability(ctx, orgID, flowID, nil)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"slots": slots})
+}
+
+// ---------------- horários livres ----------------
+
+// scheduleKey identifica a agenda: o usuário ou 0 para a agenda da org.
+func scheduleKey(userID *int64) int64 {
+	if userID == nil {
+		return 0
+	}
+	return *userID
+}
+
+// busyIntervals devolve os períodos ocupados por agenda entre from e to.
+func (a *App) busyIntervals(ctx context.Context, orgID, flowID int64, from, to time.Time) (map[int64][]timeInterval, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT COALESCE(user_id, 0), starts_at, ends_at FROM public.appointments
+ WHERE org_id=$1 AND flow_id=$2 AND status='booked' AND starts_at < $4 AND ends_at > $3`,
+		orgID, flowID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	busy := map[int64][]timeInterval{}
+	for rows.Next() {
+		var key int64
+		var iv timeInterval
+		if err := rows.Scan(&key, &iv.start, &iv.end); err != nil {
+			return nil, err
+		}
+		busy[key] = append(busy[key], iv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for key, ivs := range a.googleBusy(ctx, orgID, flowID, from, to) {
+		busy[key] = append(busy[key], ivs...)
+	}
+	return busy, nil
+}
+
+func overlapsAny(ivs []timeInterval, start, end time.Time) bool {
+	for _, iv := range ivs {
+		if start.Before(iv.end) && iv.start.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeSlots gera os horários livres da grade entre from e to (nunca no
+// passado), em ordem cronológica.
+func (a *App) freeSlots(ctx context.Context, orgID, flowID int64, userID *int64, from, to time.Time) ([]freeSlot, error) {
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+	out := []freeSlot{}
+	if !from.Before(to) {
+		return out, nil
+	}
+	grid, err := a.loadAvailability(ctx, orgID, flowID, userID)
+	if err != nil || len(grid) == 0 {
+		return out, err
+	}
+	busy, err := a.busyIntervals(ctx, orgID, flowID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grid {
+		loc, err := time.LoadLocation(g.Timezone)
+		if err != nil {
+			loc = appointmentLoc()
+		}
+		startOff, err1 := parseClock(g.Start)
+		endOff, err2 := parseClock(g.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		step := time.Duration(g.SlotMinutes) * time.Minute
+		lf := from.In(loc)
+		for day := time.Date(lf.Year(), lf.Month(), lf.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+			if int(day.Weekday()) != g.Weekday {
+				continue
+			}
+			dayEnd := day.Add(endOff)
+			for s := day.Add(startOff); !s.Add(step).After(dayEnd); s = s.Add(step) {
+				e := s.Add(step)
+				if s.Before(from) || e.After(to) || overlapsAny(busy[scheduleKey(g.UserID)], s, e) {
+					continue
+				}
+				out = append(out, freeSlot{StartsAt: s, EndsAt: e, UserID: g.UserID})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartsAt.Before(out[j].StartsAt) })
+	return out, nil
+}
+
+// GET /api/appointments/slots?from=2026-10-20&days=7&user_id=
+func (a *App) listFreeSlots(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	loc := appointmentLoc()
+	from := time.Now().In(loc)
+	if v := strings.TrimSpace(q.Get("from")); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid from (use YYYY-MM-DD)")
+			return
+		}
+	}
+	days, _ := strconv.Atoi(q.Get("days"))
+	if days <= 0 {
+		days = 7
+	}
+	days = min(days, maxSlotDays)
+	var userID *int64
+	if v, err := strconv.ParseInt(q.Get("user_id"), 10, 64); err == nil && v > 0 {
+		userID = &v
+	}
+	to := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, days)
+	slots, err := a.freeSlots(r.Context(), orgID, flowID, userID, from, to)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"items": slots})
+}
+
+// ---------------- agendamentos ----------------
+
+// bookAppointment grava o agendamento se a agenda estiver livre no período.
+// O advisory lock serializa as marcações concorrentes do mesmo flow.
+func (a *App) bookAppointment(ctx context.Context, ap appointment) (appointment, error) {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return ap, err
+	}
+	defer tx.Rollback(ctx)
+	if err := lockAppointments(ctx, tx, ap.OrgID, ap.FlowID); err != nil {
+		return ap, err
+	}
+	if err := checkAppointmentConflict(ctx, tx, ap, 0); err != nil {
+		return ap, err
+	}
+	out, err := scanAppointment(tx.QueryRow(ctx, `
+INSERT INTO public.appointments (org_id, flow_id, user_id, lead_id, title, notes, starts_at, ends_at, source, rebooked_from)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6,''), $7, $8, $9, $10)
+RETURNING `+appointmentColumns,
+		ap.OrgID, ap.FlowID, ap.UserID, ap.LeadID, ap.Title, ap.Notes, ap.StartsAt, ap.EndsAt, nonEmpty(ap.Source, "api"), ap.RebookedFrom))
+	if err != nil {
+		return out, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return out, err
+	}
+	a.pushAppointmentToGoogle(ctx, out)
+	return out, nil
+}
+
+func lockAppointments(ctx context.Context, tx pgx.Tx, orgID, flowID int64) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('appointments:' || $1::text || ':' || $2::text, 0))`, orgID, flowID)
+	return err
+}
+
+// checkAppointmentConflict procura outro agendamento marcado da mesma agenda
+// sobreposto ao período (exceto o próprio, exceptID).
+func checkAppointmentConflict(ctx context.Context, tx pgx.Tx, ap appointment, exceptID int64) error {
+	var conflict bool
+	err := tx.QueryRow(ctx, `
+SELECT EXISTS (
+  SELECT 1 FROM public.appointments
+   WHERE org_id=$1 AND flow_id=$2 AND COALESCE(user_id, 0)=$3 AND status='booked'
+     AND id <> $6 AND starts_at < $5 AND ends_at > $4)`,
+		ap.OrgID, ap.FlowID, scheduleKey(ap.UserID), ap.StartsAt, ap.EndsAt, exceptID).Scan(&conflict)
+	if err != nil {
+		return err
+	}
+	if conflict {
+		return errAppointmentConflict
+	}
+	return nil
+}
+
+func parseAppointmentTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", s, appointmentLoc())
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q (use RFC3339)", s)
+	}
+	return t, nil
+}
+
+// GET /api/appointments?from=&to=&status=
+func (a *App) listAppointments(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT `+appointmentColumns+` FROM public.appointments
+ WHERE org_id=$1 AND flow_id=$2
+   AND ($3::timestamptz IS NULL OR ends_at > $3)
+   AND ($4::timestamptz IS NULL OR starts_at < $4)
+   AND ($5 = '' OR status=$5)
+ ORDER BY starts_at LIMIT 1000`, orgID, flowID, rg.From, rg.To, strings.TrimSpace(r.URL.Query().Get("status")))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []appointment{}
+	for rows.Next() {
+		ap, err := scanAppointment(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, ap)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// POST /api/appointments
+func (a *App) createAppointment(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		Title           string `json:"title"`
+		Notes           string `json:"notes"`
+		StartsAt        string `json:"starts_at"`
+		EndsAt          string `json:"ends_at"`
+		DurationMinutes int    `json:"duration_minutes"`
+		UserID          *int64 `json:"user_id"`
+		LeadID          *int64 `json:"lead_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	ap := appointment{OrgID: orgID, FlowID: flowID, UserID: in.UserID, LeadID: in.LeadID,
+		Title: strings.TrimSpace(in.Title), Notes: strings.TrimSpace(in.Notes)}
+	if ap.StartsAt, err = parseAppointmentTime(in.StartsAt); err != nil {
+		render.Error(w, http.StatusBadRequest, "starts_at: "+err.Error())
+		return
+	}
+	switch {
+	case in.EndsAt != "":
+		if ap.EndsAt, err = parseAppointmentTime(in.EndsAt); err != nil {
+			render.Error(w, http.StatusBadRequest, "ends_at: "+err.Error())
+			return
+		}
+	case in.DurationMinutes > 0:
+		ap.EndsAt = ap.StartsAt.Add(time.Duration(in.DurationMinutes) * time.Minute)
+	default:
+		ap.EndsAt = ap.StartsAt.Add(30 * time.Minute)
+	}
+	if !ap.StartsAt.Before(ap.EndsAt) {
+		render.Error(w, http.StatusBadRequest, "starts_at must be before ends_at")
+		return
+	}
+	if ap.LeadID != nil {
+		lead, err := a.loadLead(r.Context(), orgID, flowID, *ap.LeadID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			render.Error(w, http.StatusBadRequest, "lead not found")
+			return
+		}
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		ap.Title = nonEmpty(ap.Title, "Atendimento: "+nonEmpty(lead.Name, "lead "+strconv.FormatInt(lead.ID, 10)))
+	}
+	if ap.Title == "" {
+		render.Error(w, http.StatusBadRequest, "title or lead_id required")
+		return
+	}
+	out, err := a.bookAppointment(r.Context(), ap)
+	if errors.Is(err, errAppointmentConflict) {
+		render.Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, out)
+}
+
+// PUT /api/appointments/{id} — remarca, cancela ou registra o resultado
+// (done/no_show). Cancelamento e falta oferecem remarcação ao lead, a menos
+// que offer_rebooking seja false.
+func (a *App) updateAppointment(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var in struct {
+		StartsAt       *string `json:"starts_at"`
+		EndsAt         *string `json:"ends_at"`
+		Status         *string `json:"status"`
+		Notes          *string `json:"notes"`
+		CancelReason   *string `json:"cancel_reason"`
+		OfferRebooking *bool   `json:"offer_rebooking"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	if err := lockAppointments(ctx, tx, orgID, flowID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ap, err := scanAppointment(tx.QueryRow(ctx, `
+SELECT `+appointmentColumns+` FROM public.appointments WHERE id=$1 AND org_id=$2 AND flow_id=$3`, id, orgID, flowID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "appointment not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	moved, prevStatus := false, ap.Status
+	if in.StartsAt != nil {
+		t, err := parseAppointmentTime(*in.StartsAt)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, "starts_at: "+err.Error())
+			return
+		}
+		// mantém a duração se só o início mudar
+		ap.EndsAt = t.Add(ap.EndsAt.Sub(ap.StartsAt))
+		ap.StartsAt, moved = t, true
+	}
+	if in.EndsAt != nil {
+		t, err := parseAppointmentTime(*in.EndsAt)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, "ends_at: "+err.Error())
+			return
+		}
+		ap.EndsAt, moved = t, true
+	}
+	if !ap.StartsAt.Before(ap.EndsAt) {
+		render.Error(w, http.StatusBadRequest, "starts_at must be before ends_at")
+		return
+	}
+	if in.Status != nil {
+		switch s := strings.ToLower(strings.TrimSpace(*in.Status)); s {
+		case appointmentBooked, appointmentCanceled:
+			moved = moved || (s == appointmentBooked && ap.Status != appointmentBooked)
+			ap.Status = s
+		case appointmentDone, appointmentNoShow:
+			if ap.StartsAt.After(time.Now()) {
+				render.Error(w, http.StatusBadRequest, "appointment has not started yet")
+				return
+			}
+			ap.Status = s
+		default:
+			render.Error(w, http.StatusBadRequest, "invalid status (use booked, canceled, done or no_show)")
+			return
+		}
+	}
+	if in.Notes != nil {
+		ap.Notes = strings.TrimSpace(*in.Notes)
+	}
+	if in.CancelReason != nil {
+		ap.CancelReason = limitRunes(strings.TrimSpace(*in.CancelReason), 500)
+	}
+	if moved && ap.Status == appointmentBooked {
+		if err := checkAppointmentConflict(ctx, tx, ap, ap.ID); err != nil {
+			if errors.Is(err, errAppointmentConflict) {
+				render.Error(w, http.StatusConflict, err.Error())
+				return
+			}
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	out, err := scanAppointment(tx.QueryRow(ctx, `
+UPDATE public.appointments
+   SET starts_at=$2, ends_at=$3, status=$4, notes=NULLIF($5,''), cancel_reason=NULLIF($7,''), updated_at=NOW(),
+       reminder_sent_at = CASE WHEN $6 THEN NULL ELSE reminder_sent_at END,
+       outcome_at = CASE WHEN $4 = 'booked' THEN NULL WHEN status <> $4 THEN NOW() ELSE outcome_at END
+ WHERE id=$1
+RETURNING `+appointmentColumns, ap.ID, ap.StartsAt, ap.EndsAt, ap.Status, ap.Notes, moved, ap.CancelReason))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.pushAppointmentToGoogle(ctx, out)
+	if out.Status != prevStatus && (out.Status == appointmentCanceled || out.Status == appointmentNoShow) &&
+		(in.OfferRebooking == nil || *in.OfferRebooking) {
+		a.offerRebooking(ctx, out)
+	}
+	render.OK(w, out)
+}
+
+// ---------------- iCal ----------------
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", "")
+
+// GET /api/appointments/calendar.ics?from=&to=
+func (a *App) exportAppointmentsICal(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from := time.Now().AddDate(0, 0, -30)
+	if rg.From != nil {
+		from = *rg.From
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT `+appointmentColumns+` FROM public.appointments
+ WHERE org_id=$1 AND flow_id=$2 AND status <> 'canceled'
+   AND ends_at > $3 AND ($4::timestamptz IS NULL OR starts_at < $4)
+ ORDER BY starts_at LIMIT 5000`, orgID, flowID, from, rg.To)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	line := func(s string) { b.WriteString(s + "\r\n") }
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//PacLead//Agenda//PT-BR")
+	line("CALSCALE:GREGORIAN")
+	now := time.Now().UTC().Format(stamp)
+	for rows.Next() {
+		ap, err := scanAppointment(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:appointment-%d@paclead", ap.ID))
+		line("DTSTAMP:" + now)
+		line("DTSTART:" + ap.StartsAt.UTC().Format(stamp))
+		line("DTEND:" + ap.EndsAt.UTC().Format(stamp))
+		line("SUMMARY:" + icalEscaper.Replace(ap.Title))
+		if ap.Notes != "" {
+			line("DESCRIPTION:" + icalEscaper.Replace(ap.Notes))
+		}
+		line("LAST-MODIFIED:" + ap.UpdatedAt.UTC().Format(stamp))
+		line("STATUS:CONFIRMED")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="agenda.ics"`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// ---------------- lembretes ----------------
+
+func (a *App) appointmentReminderLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		a.sendAppointmentReminders()
+		a.markNoShows()
+	}
+}
+
+// sendAppointmentReminders avisa os leads com horário nas próximas
+// APPOINTMENT_REMINDER_BEFORE. A marcação vem antes do envio: na dúvida, o
+// lembrete deixa de sair em vez de sair duas vezes.
+func (a *App) sendAppointmentReminders() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.appointments SET reminder_sent_at = NOW()
+ WHERE id IN (SELECT id FROM public.appointments
+               WHERE status='booked' AND reminder_sent_at IS NULL AND lead_id IS NOT NULL
+                 AND starts_at > NOW() AND starts_at <= NOW() + $1::interval
+               ORDER BY starts_at LIMIT 50 FOR UPDATE SKIP LOCKED)
+RETURNING `+appointmentColumns, appointmentReminderBefore().String())
+	if err != nil {
+		log.Printf("appointment reminders: %v", err)
+		return
+	}
+	var due []appointment
+	for rows.Next() {
+		ap, err := scanAppointment(rows)
+		if err != nil {
+			log.Printf("appointment reminders: %v", err)
+			continue
+		}
+		due = append(due, ap)
+	}
+	rows.Close()
+
+	loc := appointmentLoc()
+	for _, ap := range due {
+		row, contact, err := a.leadWAContact(ctx, ap.OrgID, ap.FlowID, *ap.LeadID)
+		if err != nil {
+			log.Printf("appointment %d reminder: %v", ap.ID, err)
+			continue
+		}
+		text := fmt.Sprintf("Lembrete: %s em %s às %s. Se precisar remarcar, é só responder esta mensagem.",
+			ap.Title, ap.StartsAt.In(loc).Format("02/01"), ap.StartsAt.In(loc).Format("15:04"))
+		if _, _, err := a.sendWAText(ctx, row, row.Token, contact, text); err != nil {
+			log.Printf("appointment %d reminder: %v", ap.ID, err)
+		}
+	}
+}
+
+// ---------------- ferramentas do agente ----------------
+
+var weekdayPT = [...]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"}
+
+// toolListAvailableSlots: list_available_slots {"date","days"}.
+func (a *App) toolListAvailableSlots(ctx context.Context, orgID, flowID int64, call openai.ToolCall) string {
+	var args struct {
+		Date string `json:"date"`
+		Days int    `json:"days"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return toolJSON(map[string]any{"error": "invalid arguments"})
+	}
+	loc := appointmentLoc()
+	from := time.Now().In(loc)
+	if args.Date != "" {
+		d, err := time.ParseInLocation("2006-01-02", args.Date, loc)
+		if err != nil {
+			return toolJSON(map[string]any{"error": "invalid date (use YYYY-MM-DD)"})
+		}
+		from = d
+	}
+	if args.Days <= 0 || args.Days > 14 {
+		args.Days = 3
+	}
+	to := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, args.Days)
+	slots, err := a.freeSlots(ctx, orgID, flowID, nil, from, to)
+	if err != nil {
+		return toolError(call, err)
+	}
+	type item struct {
+		StartsAt string `json:"starts_at"`
+		Label    string `json:"label"`
+	}
+	items := []item{}
+	seen := map[time.Time]bool{}
+	for _, s := range slots {
+		if seen[s.StartsAt] || len(items) == 20 {
+			continue
+		}
+		seen[s.StartsAt] = true
+		lt := s.StartsAt.In(loc)
+		items = append(items, item{
+			StartsAt: lt.Format(time.RFC3339),
+			Label:    weekdayPT[lt.Weekday()] + " " + lt.Format("02/01 15:04"),
+		})
+	}
+	if len(items) == 0 {
+		return toolJSON(map[string]any{"slots": items, "message": "sem horários livres no período"})
+	}
+	return toolJSON(map[string]any{"slots": items})
+}
+
+// toolBookAppointment: book_appointment {"starts_at","name","phone","notes"}.
+// Só marca num horário livre da grade; o lead é achado pelo telefone ou
+// criado.
+func (a *App) toolBookAppointment(ctx context.Context, orgID, flowID int64, call openai.ToolCall) string {
+	var args struct {
+		StartsAt string `json:"starts_at"`
+		Name     string `json:"name"`
+		Phone    string `json:"phone"`
+		Notes    string `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return toolJSON(map[string]any{"error": "invalid arguments"})
+	}
+	start, err := parseAppointmentTime(args.StartsAt)
+	if err != nil {
+		return toolJSON(map[string]any{"error": err.Error()})
+	}
+	phone := onlyDigits(args.Phone)
+	if phone == "" {
+		return toolJSON(map[string]any{"error": "phone required"})
+	}
+	slots, err := a.freeSlots(ctx, orgID, flowID, nil, start, start.Add(24*time.Hour))
+	if err != nil {
+		return toolError(call, err)
+	}
+	var slot *freeSlot
+	for i := range slots {
+		if slots[i].StartsAt.Equal(start) {
+			slot = &slots[i]
+			break
+		}
+	}
+	if slot == nil {
+		return toolJSON(map[string]any{"error": "horário indisponível; consulte list_available_slots"})
+	}
+
+	leadID, err := a.leadIDByPhone(ctx, orgID, flowID, phone, false)
+	if err == nil && leadID == 0 {
+		leadID, _, err = a.insertLead(ctx, orgID, flowID, strings.TrimSpace(args.Name), phone, "", "novo")
+	}
+	if err != nil {
+		return toolError(call, err)
+	}
+	ap, err := a.bookAppointment(ctx, appointment{
+		OrgID: orgID, FlowID: flowID, UserID: slot.UserID, LeadID: &leadID,
+		RebookedFrom: a.pendingRebooking(ctx, orgID, flowID, leadID),
+		Title:        "Atendimento: " + nonEmpty(strings.TrimSpace(args.Name), phone),
+		Notes:        strings.TrimSpace(args.Notes),
+		StartsAt:     slot.StartsAt, EndsAt: slot.EndsAt, Source: "agent",
+	})
+	if errors.Is(err, errAppointmentConflict) {
+		return toolJSON(map[string]any{"error": "horário acabou de ser ocupado; ofereça outro"})
+	}
+	if err != nil {
+		return toolError(call, err)
+	}
+	lt := ap.StartsAt.In(appointmentLoc())
+	return toolJSON(map[string]any{
+		"appointment_id": ap.ID,
+		"starts_at":      lt.Format(time.RFC3339),
+		"label":          weekdayPT[lt.Weekday()] + " " + lt.Format("02/01 15:04"),
+		"booked":         true,
+	})
+}
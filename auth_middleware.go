@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// requireAuth valida o Bearer JWT e injeta org/flow/usuário no contexto
+// (tenant_context.go). Se o cliente enviar X-Org-ID/X-Flow-ID, eles precisam
+// bater com as claims do token (evita que um usuário leia dados de outro
+// tenant trocando header).
+func (a *App) requireAuth(next http.Handler) http.Handler {
+	return a.resolveTenant(tenantRequireJWT)(next)
+}
+
+// claimsFromContext devolve o tenant da requisição quando ele veio de um JWT
+// validado (requireAuth/requireRole).
+func claimsFromContext(ctx context.Context) (tenantCtx, bool) {
+	t, ok := tenantFrom(ctx)
+	return t, ok && t.Source == tenantSourceJWT
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ================================================================
+//  Antivírus para arquivos enviados (uploads e documentos recebidos)
+// ================================================================
+//
+// AV_SCANNER seleciona o motor:
+//   ""      -> desligado (status "skipped")
+//   clamav  -> clamd via TCP (CLAMD_ADDR, padrão localhost:3310), comando INSTREAM
+//   icap    -> servidor ICAP (ICAP_URL, ex.: icap://av:1344/avscan), RESPMOD
+//
+// Arquivos infectados são movidos para QUARANTINE_DIR (padrão "quarantine",
+// fora do file server de /uploads). Se o scanner falhar, AV_FAIL_OPEN=true
+// aceita o arquivo (status "error"); o padrão é recusar.
+// Resultados em upload_scans (migrations/0051_upload_scans.sql).
+
+const (
+	scanClean    = "clean"
+	scanInfected = "infected"
+	scanSkipped  = "skipped"
+	scanError    = "error"
+)
+
+// scanResult é devolvido nas respostas de upload no campo "scan".
+type scanResult struct {
+	Status    string `json:"status"`
+	Signature string `json:"signature,omitempty"`
+	Engine    string `json:"engine,omitempty"`
+}
+
+// Accepted indica se o arquivo pode seguir no pipeline.
+func (s scanResult) Accepted() bool {
+	switch s.Status {
+	case scanClean, scanSkipped:
+		return true
+	case scanError:
+		return strings.EqualFold(os.Getenv("AV_FAIL_OPEN"), "true")
+	default:
+		return false
+	}
+}
+
+// scanFile analisa o arquivo em path e, se infectado, move-o para a
+// quarentena. O resultado também é gravado em upload_scans.
+func (a *App) scanFile(ctx context.Context, orgID int64, source, path string) scanResult {
+	engine := strings.ToLower(strings.TrimSpace(os.Getenv("AV_SCANNER")))
+	if engine == "" {
+		return scanResult{Status: scanSkipped}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return scanResult{Status: scanError, Engine: engine}
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var res scanResult
+	switch engine {
+	case "clamav", "clamd":
+		res, err = clamdScan(ctx, getenv("CLAMD_ADDR", "localhost:3310"), f)
+	case "icap":
+		res, err = icapScan(ctx, os.Getenv("ICAP_URL"), f)
+	default:
+		err = fmt.Errorf("unknown AV_SCANNER %q", engine)
+	}
+	res.Engine = engine
+	if err != nil {
+		log.Printf("av scan %s: %v", path, err)
+		res.Status = scanError
+	}
+
+	stored := path
+	if res.Status == scanInfected {
+		if q, qerr := quarantineFile(path); qerr != nil {
+			log.Printf("av quarantine %s: %v", path, qerr)
+			_ = os.Remove(path)
+		} else {
+			stored = q
+		}
+	}
+	_, _ = a.DB.Exec(context.Background(), `
+		INSERT INTO upload_scans (org_id, source, path, status, signature, engine)
+		VALUES (NULLIF($1,0), $2, $3, $4, NULLIF($5,''), $6)`,
+		orgID, source, stored, res.Status, res.Signature, engine)
+	return res
+}
+
+func quarantineFile(path string) (string, error) {
+	dir := getenv("QUARANTINE_DIR", "quarantine")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, filepath.Base(path))
+	return dst, os.Rename(path, dst)
+}
+
+// clamdScan envia o conteúdo via INSTREAM (blocos com tamanho big-endian,
+// terminados por bloco de tamanho zero). Resposta: "stream: OK" ou
+// "stream: <assinatura> FOUND".
+func clamdScan(ctx context.Context, addr string, r io.Reader) (scanResult, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return scanResult{}, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return scanResult{}, err
+	}
+	buf := make([]byte, 32<<10)
+	size := make([]byte, 4)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return scanResult{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return scanResult{}, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return scanResult{}, rerr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return scanResult{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && err != io.EOF {
+		return scanResult{}, err
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return scanResult{Status: scanClean}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		sig := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return scanResult{Status: scanInfected, Signature: sig}, nil
+	default:
+		return scanResult{}, fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+}
+
+// icapScan faz um RESPMOD com o arquivo como corpo de uma resposta HTTP
+// encapsulada. 204 = sem alterações (limpo); 200 com X-Infection-Found /
+// X-Violations-Found (ou corpo substituído) = infectado.
+func icapScan(ctx context.Context, rawURL string, r io.Reader) (scanResult, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return scanResult{}, errors.New("ICAP_URL must be icap://host[:port]/service")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host += ":1344"
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return scanResult{}, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return scanResult{}, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+	req := fmt.Sprintf("RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s",
+		u.String(), u.Hostname(), len(resHdr), resHdr)
+	w := bufio.NewWriter(conn)
+	_, _ = w.WriteString(req)
+	_, _ = fmt.Fprintf(w, "%x\r\n", len(body))
+	_, _ = w.Write(body)
+	_, _ = w.WriteString("\r\n0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return scanResult{}, err
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		return scanResult{}, err
+	}
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return scanResult{}, err
+	}
+	parts := strings.SplitN(status, " ", 3)
+	if len(parts) < 2 {
+		return scanResult{}, fmt.Errorf("icap: bad status %q", status)
+	}
+	switch parts[1] {
+	case "204":
+		return scanResult{Status: scanClean}, nil
+	case "200":
+		sig := firstNonEmpty(hdr.Get("X-Infection-Found"), hdr.Get("X-Violations-Found"), hdr.Get("X-Virus-Id"))
+		if sig == "" {
+			sig = "modified by icap"
+		}
+		return scanResult{Status: scanInfected, Signature: strings.TrimSpace(sig)}, nil
+	default:
+		return scanResult{}, fmt.Errorf("icap: status %s", parts[1])
+	}
+}
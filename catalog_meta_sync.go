@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Sincronização do catálogo com o WhatsApp Business (Meta Commerce)
+// ================================================================
+//
+// Para tenants com número oficial (Cloud API), o catálogo local é enviado ao
+// catálogo da Meta via Graph API (items_batch, com allow_upsert). Produtos
+// inativos são removidos do catálogo remoto. Um loop reenvia preço/estoque a
+// cada META_CATALOG_SYNC_INTERVAL (padrão 1h).
+//
+//   META_GRAPH_URL               padrão https://graph.facebook.com/v19.0
+//   META_CATALOG_SYNC_INTERVAL   padrão 1h ("0" desliga o agendamento)
+//   CATALOG_CURRENCY             padrão BRL
+//   STOREFRONT_BASE_URL          origem pública da vitrine (link do produto, obrigatório p/ Meta)
+// Tabela em migrations/0052_meta_catalog_sync.sql.
+
+type metaCatalogConfig struct {
+	OrgID       int64      `json:"org_id"`
+	FlowID      int64      `json:"flow_id"`
+	CatalogID   string     `json:"catalog_id"`
+	AccessToken string     `json:"access_token,omitempty"`
+	Enabled     bool       `json:"enabled"`
+	LastSyncAt  *time.Time `json:"last_sync_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastCount   int        `json:"last_count"`
+}
+
+func (a *App) mountMetaCatalogSync(r chi.Router) {
+	r.Get("/catalog-sync/meta", a.getMetaCatalogConfig)
+	r.Put("/catalog-sync/meta", a.putMetaCatalogConfig)
+	r.Post("/catalog-sync/meta/run", a.runMetaCatalogSync)
+
+	if every := metaSyncInterval(); every > 0 {
+		go a.metaCatalogSyncLoop(every)
+	}
+}
+
+func metaSyncInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("META_CATALOG_SYNC_INTERVAL", "1h"))
+	if err != nil || d < 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// GET /api/catalog-sync/meta — o access_token nunca é devolvido.
+func (a *App) getMetaCatalogConfig(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	cfg, err := a.loadMetaCatalogConfig(r.Context(), orgID, flowID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "not configured")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.AccessToken = ""
+	render.OK(w, cfg)
+}
+
+// PUT /api/catalog-sync/meta {catalog_id, access_token, enabled}
+func (a *App) putMetaCatalogConfig(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	var in struct {
+		CatalogID   string `json:"catalog_id"`
+		AccessToken string `json:"access_token"`
+		Enabled     *bool  `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(in.CatalogID) == "" {
+		render.Error(w, http.StatusBadRequest, "catalog_id required")
+		return
+	}
+	enabled := true
+	if in.Enabled != nil {
+		enabled = *in.Enabled
+	}
+	// access_token vazio mantém o atual (o front não recebe o valor de volta)
+	tag, err := a.DB.Exec(r.Context(), `
+INSERT INTO public.meta_catalog_sync (org_id, flow_id, catalog_id, access_token, enabled)
+SELECT $1::bigint, $2::bigint, $3::text, $4::text, $5::boolean WHERE $4::text <> ''
+ON CONFLICT (org_id, flow_id) DO UPDATE
+SET catalog_id   = EXCLUDED.catalog_id,
+    access_token = EXCLUDED.access_token,
+    enabled      = EXCLUDED.enabled,
+    updated_at   = NOW()`, orgID, flowID, strings.TrimSpace(in.CatalogID), strings.TrimSpace(in.AccessToken), enabled)
+	if err == nil && tag.RowsAffected() == 0 {
+		tag, err = a.DB.Exec(r.Context(), `
+UPDATE public.meta_catalog_sync SET catalog_id=$3, enabled=$4, updated_at=NOW()
+ WHERE org_id=$1 AND flow_id=$2`, orgID, flowID, strings.TrimSpace(in.CatalogID), enabled)
+		if err == nil && tag.RowsAffected() == 0 {
+			render.Error(w, http.StatusBadRequest, "access_token required")
+			return
+		}
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.NoContent(w)
+}
+
+// POST /api/catalog-sync/meta/run — sincroniza agora.
+func (a *App) runMetaCatalogSync(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	cfg, err := a.loadMetaCatalogConfig(r.Context(), orgID, flowID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "not configured")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	n, err := a.syncMetaCatalog(r.Context(), cfg)
+	if err != nil {
+		render.Error(w, http.StatusBadGateway, "sync failed: "+err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"ok": true, "items": n})
+}
+
+func (a *App) loadMetaCatalogConfig(ctx context.Context, orgID, flowID int64) (metaCatalogConfig, error) {
+	c := metaCatalogConfig{OrgID: orgID, FlowID: flowID}
+	err := a.DB.QueryRow(ctx, `
+SELECT catalog_id, access_token, enabled, last_sync_at, COALESCE(last_error,''), last_count
+  FROM public.meta_catalog_sync WHERE org_id=$1 AND flow_id=$2`, orgID, flowID).
+		Scan(&c.CatalogID, &c.AccessToken, &c.Enabled, &c.LastSyncAt, &c.LastError, &c.LastCount)
+	return c, err
+}
+
+// metaCatalogSyncLoop sincroniza todos os tenants habilitados periodicamente.
+func (a *App) metaCatalogSyncLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), every)
+		rows, err := a.DB.Query(ctx, `
+SELECT org_id, flow_id, catalog_id, access_token
+  FROM public.meta_catalog_sync WHERE enabled`)
+		if err != nil {
+			cancel()
+			log.Printf("meta catalog sync: %v", err)
+			continue
+		}
+		var cfgs []metaCatalogConfig
+		for rows.Next() {
+			var c metaCatalogConfig
+			if err := rows.Scan(&c.OrgID, &c.FlowID, &c.CatalogID, &c.AccessToken); err == nil {
+				cfgs = append(cfgs, c)
+			}
+		}
+		rows.Close()
+		for _, c := range cfgs {
+			if _, err := a.syncMetaCatalog(ctx, c); err != nil {
+				log.Printf("meta catalog sync org=%d flow=%d: %v", c.OrgID, c.FlowID, err)
+			}
+		}
+		cancel()
+	}
+}
+
+// syncMetaCatalog envia todos os produtos do tenant e registra o resultado.
+func (a *App) syncMetaCatalog(ctx context.Context, cfg metaCatalogConfig) (int, error) {
+	reqs, err := a.metaCatalogRequests(ctx, cfg.OrgID, cfg.FlowID)
+	if err == nil {
+		for start := 0; start < len(reqs) && err == nil; start += 1000 {
+			end := start + 1000
+			if end > len(reqs) {
+				end = len(reqs)
+			}
+			err = postMetaItemsBatch(ctx, cfg, reqs[start:end])
+		}
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	_, _ = a.DB.Exec(context.Background(), `
+UPDATE public.meta_catalog_sync
+   SET last_sync_at=NOW(), last_error=NULLIF($3,''), last_count=$4
+ WHERE org_id=$1 AND flow_id=$2`, cfg.OrgID, cfg.FlowID, errMsg, len(reqs))
+	return len(reqs), err
+}
+
+// metaCatalogRequests converte os produtos em requisições do items_batch.
+// retailer_id = "<id do produto>", estável entre sincronizações.
+func (a *App) metaCatalogRequests(ctx context.Context, orgID, flowID int64) ([]map[string]any, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT id, title, COALESCE(slug,''), COALESCE(category,''),
+       CASE WHEN deleted_at IS NULL THEN status ELSE 'deleted' END,
+       COALESCE(image_base64,''), price_cents, stock
+  FROM products WHERE org_id=$1 AND flow_id=$2`, orgID, flowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currency := getenv("CATALOG_CURRENCY", "BRL")
+	var out []map[string]any
+	for rows.Next() {
+		var (
+			id                        int64
+			title, slug, cat, st, img string
+			price, stock              int
+		)
+		if err := rows.Scan(&id, &title, &slug, &cat, &st, &img, &price, &stock); err != nil {
+			return nil, err
+		}
+		rid := strconv.FormatInt(id, 10)
+		if st != "active" {
+			out = append(out, map[string]any{"method": "DELETE", "data": map[string]any{"id": rid}})
+			continue
+		}
+		availability := "in stock"
+		if stock <= 0 {
+			availability = "out of stock"
+		}
+		data := map[string]any{
+			"id":           rid,
+			"title":        title,
+			"description":  firstNonEmpty(cat, title),
+			"availability": availability,
+			"condition":    "new",
+			"price":        fmt.Sprintf("%d.%02d %s", price/100, price%100, currency),
+			"inventory":    stock,
+		}
+		// image_base64 pode conter base64 legado; só URLs são aceitas pela Meta
+		if strings.HasPrefix(img, "http://") || strings.HasPrefix(img, "https://") {
+			data["image_link"] = img
+		}
+		if link := productPublicURL(nil, orgID, id, slug); link != "" {
+			data["link"] = link
+		}
+		out = append(out, map[string]any{"method": "UPDATE", "data": data})
+	}
+	return out, rows.Err()
+}
+
+func postMetaItemsBatch(ctx context.Context, cfg metaCatalogConfig, reqs []map[string]any) error {
+	body, err := json.Marshal(map[string]any{
+		"item_type":    "PRODUCT_ITEM",
+		"allow_upsert": true,
+		"requests":     reqs,
+	})
+	if err != nil {
+		return err
+	}
+	base := strings.TrimRight(getenv("META_GRAPH_URL", "https://graph.facebook.com/v19.0"), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/"+cfg.CatalogID+"/items_batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.AccessToken)
+	resp, err := (&http.Client{Timeout: 60 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return fmt.Errorf("graph api %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
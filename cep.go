@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Consulta de CEP (ViaCEP)
+// ================================================================
+//
+// GET /api/cep/{cep} expande um CEP em logradouro/bairro/cidade/UF. Usado pelo
+// formulário da empresa (PUT /api/company completa os campos em branco) e pelo
+// agente de chat (ferramenta lookup_cep e campo cep do update_order_draft).
+// VIACEP_URL troca a base (padrão https://viacep.com.br/ws). Respostas ficam
+// em cache por cepCacheTTL; CEPs inexistentes também, por menos tempo.
+
+const (
+	cepCacheTTL         = 24 * time.Hour
+	cepNotFoundCacheTTL = time.Hour
+)
+
+var (
+	errCEPInvalid  = errors.New("invalid CEP (expected 8 digits)")
+	errCEPNotFound = errors.New("CEP not found")
+)
+
+type cepAddress struct {
+	CEP         string `json:"cep"`
+	Logradouro  string `json:"logradouro"`
+	Complemento string `json:"complemento,omitempty"`
+	Bairro      string `json:"bairro"`
+	Cidade      string `json:"cidade"`
+	UF          string `json:"uf"`
+	IBGE        string `json:"ibge,omitempty"`
+}
+
+type cepCacheEntry struct {
+	addr    cepAddress
+	err     error
+	expires time.Time
+}
+
+var cepCache sync.Map // CEP (só dígitos) -> cepCacheEntry
+
+// normalizeCEP devolve os 8 dígitos do CEP ou errCEPInvalid.
+func normalizeCEP(v string) (string, error) {
+	d := onlyDigits(v)
+	if len(d) != 8 {
+		return "", errCEPInvalid
+	}
+	return d, nil
+}
+
+func formatCEP(d string) string {
+	if len(d) != 8 {
+		return d
+	}
+	return d[:5] + "-" + d[5:]
+}
+
+// lookupCEP consulta o ViaCEP (com cache).
+func lookupCEP(ctx context.Context, raw string) (cepAddress, error) {
+	cep, err := normalizeCEP(raw)
+	if err != nil {
+		return cepAddress{}, err
+	}
+	if v, ok := cepCache.Load(cep); ok {
+		if e := v.(cepCacheEntry); time.Now().Before(e.expires) {
+			return e.addr, e.err
+		}
+	}
+
+	base := strings.TrimRight(getenv("VIACEP_URL", "https://viacep.com.br/ws"), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/"+cep+"/json/", nil)
+	if err != nil {
+		return cepAddress{}, err
+	}
+	resp, err := (&http.Client{Timeout: 8 * time.Second}).Do(req)
+	if err != nil {
+		return cepAddress{}, fmt.Errorf("viacep: %w", err)
+	}
+	defer resp.Body.Close()
+	// o ViaCEP responde 400 para formato inválido e 200 {"erro": true} para
+	// CEP inexistente
+	if resp.StatusCode == http.StatusBadRequest {
+		return cepAddress{}, errCEPInvalid
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cepAddress{}, fmt.Errorf("viacep: status %d", resp.StatusCode)
+	}
+	var out struct {
+		CEP         string `json:"cep"`
+		Logradouro  string `json:"logradouro"`
+		Complemento string `json:"complemento"`
+		Bairro      string `json:"bairro"`
+		Localidade  string `json:"localidade"`
+		UF          string `json:"uf"`
+		IBGE        string `json:"ibge"`
+		Erro        any    `json:"erro"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return cepAddress{}, fmt.Errorf("viacep: %w", err)
+	}
+	if out.Erro != nil && out.Erro != false {
+		cepCache.Store(cep, cepCacheEntry{err: errCEPNotFound, expires: time.Now().Add(cepNotFoundCacheTTL)})
+		return cepAddress{}, errCEPNotFound
+	}
+	addr := cepAddress{
+		CEP:         formatCEP(cep),
+		Logradouro:  out.Logradouro,
+		Complemento: out.Complemento,
+		Bairro:      out.Bairro,
+		Cidade:      out.Localidade,
+		UF:          out.UF,
+		IBGE:        out.IBGE,
+	}
+	cepCache.Store(cep, cepCacheEntry{addr: addr, expires: time.Now().Add(cepCacheTTL)})
+	return addr, nil
+}
+
+// line monta o endereço de entrega em uma linha:
+// "Rua X, 123 - Apto 4 - Bairro, Cidade/UF, CEP 00000-000".
+func (c cepAddress) line(numero, complemento string) string {
+	street := c.Logradouro
+	if street == "" {
+		street = "(logradouro não informado)"
+	}
+	if n := strings.TrimSpace(numero); n != "" {
+		street += ", " + n
+	}
+	if cpl := strings.TrimSpace(complemento); cpl != "" {
+		street += " - " + cpl
+	}
+	if c.Bairro != "" {
+		street += " - " + c.Bairro
+	}
+	return fmt.Sprintf("%s, %s/%s, CEP %s", street, c.Cidade, c.UF, c.CEP)
+}
+
+// cepHTTPStatus traduz o erro de lookupCEP para o status da resposta.
+func cepHTTPStatus(err error) int {
+	switch {
+	case errors.Is(err, errCEPInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errCEPNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// GET /api/cep/{cep}
+func (a *App) getCEP(w http.ResponseWriter, r *http.Request) {
+	addr, err := lookupCEP(r.Context(), chi.URLParam(r, "cep"))
+	if err != nil {
+		render.Error(w, cepHTTPStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	render.OK(w, addr)
+}
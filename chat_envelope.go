@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Envelope da resposta de /api/chat
+// ================================================================
+//
+// legacy (padrão): {"ok":true,"reply":...} e, na conversa com a IA, o mesmo
+// texto repetido em message/text/content/choices[0].message.content, como os
+// primeiros clientes esperavam.
+//
+// compact: {"reply":"...","model":"..."} mais product/order quando houver.
+// Erros continuam no formato de sempre ({"error":...} com status HTTP).
+//
+// Negociação, nesta ordem:
+//   X-Chat-Format: compact|legacy
+//   Accept: application/vnd.paclead.chat.v2+json  (v2 = compact, v1 = legacy)
+//   ?format=compact|legacy
+//   CHAT_RESPONSE_FORMAT (padrão legacy)
+// O formato usado volta no header X-Chat-Format.
+
+const (
+	chatFormatLegacy  = "legacy"
+	chatFormatCompact = "compact"
+)
+
+func chatFormatOf(r *http.Request) string {
+	pick := func(v string) string {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case chatFormatCompact, "v2":
+			return chatFormatCompact
+		case chatFormatLegacy, "v1":
+			return chatFormatLegacy
+		}
+		return ""
+	}
+	if f := pick(r.Header.Get("X-Chat-Format")); f != "" {
+		return f
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/vnd.paclead.chat.v2+json"):
+		return chatFormatCompact
+	case strings.Contains(accept, "application/vnd.paclead.chat.v1+json"):
+		return chatFormatLegacy
+	}
+	if f := pick(r.URL.Query().Get("format")); f != "" {
+		return f
+	}
+	return nonEmpty(pick(getenv("CHAT_RESPONSE_FORMAT", "")), chatFormatLegacy)
+}
+
+// renderChatReply responde o chat no formato negociado. extra (product,
+// order, model...) entra nos dois formatos; aliases repete o texto nos campos
+// antigos da conversa com a IA (só no legacy).
+func renderChatReply(w http.ResponseWriter, r *http.Request, reply string, extra map[string]any, aliases bool) {
+	format := chatFormatOf(r)
+	w.Header().Set("X-Chat-Format", format)
+	out := map[string]any{"reply": reply}
+	for k, v := range extra {
+		out[k] = v
+	}
+	if format == chatFormatLegacy {
+		out["ok"] = true
+		if aliases {
+			out["message"] = reply
+			out["text"] = reply
+			out["content"] = reply
+			out["choices"] = []map[string]any{
+				{"message": map[string]any{"content": reply}},
+			}
+		}
+	}
+	render.OK(w, out)
+}
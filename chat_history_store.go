@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Histórico de conversas (chat_messages)
+// ================================================================
+//
+// Cada turno de /api/chat (HTTP e WebSocket) é gravado por sessão+org+flow.
+// Se o cliente não enviar "history", o backend recarrega os últimos
+// CHAT_HISTORY_LIMIT turnos (padrão 20) da sessão antes de chamar a IA.
+// Tabela em migrations/0053_chat_messages.sql.
+
+type chatMessage struct {
+	ID        int64     `json:"id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Model     string    `json:"model,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func chatHistoryLimit() int {
+	n, err := strconv.Atoi(getenv("CHAT_HISTORY_LIMIT", "20"))
+	if err != nil || n < 0 {
+		return 20
+	}
+	return n
+}
+
+// saveChatTurn grava a mensagem do usuário e a resposta. Sem sessionId não
+// há como reagrupar a conversa, então nada é gravado.
+func (a *App) saveChatTurn(ctx context.Context, sessionID string, orgID, flowID int, userMsg, reply, model string) {
+	if sessionID == "" {
+		return
+	}
+	_, err := a.DB.Exec(ctx, `
+INSERT INTO public.chat_messages (session_id, org_id, flow_id, role, content, model)
+VALUES ($1,$2,$3,'user',$4,NULL), ($1,$2,$3,'assistant',$5,NULLIF($6,''))`,
+		sessionID, orgID, flowID, userMsg, reply, model)
+	if err != nil {
+		log.Printf("save chat turn session=%s: %v", sessionID, err)
+	}
+}
+
+// loadChatHistory devolve as últimas `limit` mensagens da sessão em ordem
+// cronológica.
+func (a *App) loadChatHistory(ctx context.Context, sessionID string, orgID, flowID, limit int) ([]chatMessage, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT id, role, content, COALESCE(model,''), created_at FROM (
+  SELECT * FROM public.chat_messages
+   WHERE session_id=$1 AND org_id=$2 AND flow_id=$3
+   ORDER BY created_at DESC, id DESC
+   LIMIT $4
+) t ORDER BY created_at, id`, sessionID, orgID, flowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []chatMessage
+	for rows.Next() {
+		var m chatMessage
+		if err := rows.Scan(&m.ID, &m.Role, &m.Content, &m.Model, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// withStoredHistory preenche in.History com o histórico salvo quando o
+// cliente não enviou nenhum.
+func (a *App) withStoredHistory(ctx context.Context, in *chatReq, orgID, flowID int) {
+	if len(in.History) > 0 || in.SessionID == "" {
+		return
+	}
+	limit := chatHistoryLimit()
+	if limit == 0 {
+		return
+	}
+	msgs, err := a.loadChatHistory(ctx, in.SessionID, orgID, flowID, limit)
+	if err != nil {
+		log.Printf("load chat history session=%s: %v", in.SessionID, err)
+		return
+	}
+	for _, m := range msgs {
+		in.History = append(in.History, chatTurn{Role: m.Role, Content: m.Content})
+	}
+}
+
+// GET /api/chat/sessions/{id}/messages?limit=100
+func (a *App) chatSessionMessages(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimSpace(chi.URLParam(r, "id"))
+	if sessionID == "" {
+		render.Error(w, http.StatusBadRequest, "missing session id")
+		return
+	}
+	orgID := mustAtoi(strings.TrimSpace(r.Header.Get("X-Org-ID")))
+	flowID := mustAtoi(strings.TrimSpace(r.Header.Get("X-Flow-ID")))
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+	msgs, err := a.loadChatHistory(r.Context(), sessionID, orgID, flowID, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if msgs == nil {
+		msgs = []chatMessage{}
+	}
+	render.OK(w, map[string]any{"session_id": sessionID, "messages": msgs})
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Rascunho de pedido no chat (chat_order_drafts)
+// ================================================================
+//
+// O agente monta o pedido pelas ferramentas update_order_draft (itens,
+// endereço, pagamento) e request_order_confirmation, que devolve o resumo e
+// deixa o rascunho aguardando confirmação. A conversão em pedido não passa
+// pela IA: se a próxima mensagem do cliente for "confirmo" (ou equivalente),
+// completeOrderDraft cria o pedido com os preços atuais; "cancelar" descarta.
+// Qualquer outra resposta segue para o modelo, e alterar o rascunho exige
+// nova confirmação. Rascunhos expiram após ORDER_DRAFT_TTL (padrão 24h).
+// redeem_points pede desconto com pontos de fidelidade (loyalty.go); o valor
+// aparece no resumo e é debitado junto com a criação do pedido.
+
+var orderPaymentMethods = map[string]string{
+	"pix": "pix", "cartao": "cartao", "cartão": "cartao", "credito": "cartao", "crédito": "cartao",
+	"debito": "cartao", "débito": "cartao", "boleto": "boleto", "dinheiro": "dinheiro",
+}
+
+var (
+	orderConfirmRe = regexp.MustCompile(`(?i)^\s*(sim[, ]*)?(confirmo|confirmado|confirmar|pode confirmar|confirma|fechado|pode fechar)\s*[.!]*\s*$`)
+	orderCancelRe  = regexp.MustCompile(`(?i)^\s*(cancelar|cancela|cancele|cancelar pedido|cancela o pedido)\s*[.!]*\s*$`)
+)
+
+func orderDraftTTL() time.Duration {
+	if d, err := time.ParseDuration(getenv("ORDER_DRAFT_TTL", "24h")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+type orderDraftItem struct {
+	ProductID  int64  `json:"product_id"`
+	Title      string `json:"title"`
+	Qty        int    `json:"qty"`
+	PriceCents int    `json:"price_cents"`
+}
+
+type orderDraft struct {
+	SessionID     string           `json:"-"`
+	OrgID         int64            `json:"-"`
+	FlowID        int64            `json:"-"`
+	Items         []orderDraftItem `json:"items"`
+	CustomerName  string           `json:"customer_name,omitempty"`
+	Phone         string           `json:"phone,omitempty"`
+	Address       string           `json:"address,omitempty"`
+	PaymentMethod string           `json:"payment_method,omitempty"`
+	RedeemPoints  int              `json:"redeem_points,omitempty"`
+	Status        string           `json:"status"`
+
+	// desconto estimado no pedido de confirmação (não é gravado)
+	DiscountCents int `json:"discount_cents,omitempty"`
+}
+
+func (d *orderDraft) totalCents() int {
+	total := 0
+	for _, it := range d.Items {
+		total += it.Qty * it.PriceCents
+	}
+	return total
+}
+
+// missing lista o que falta para o pedido poder ser confirmado.
+func (d *orderDraft) missing() []string {
+	var out []string
+	if len(d.Items) == 0 {
+		out = append(out, "items")
+	}
+	if strings.TrimSpace(d.Address) == "" {
+		out = append(out, "address")
+	}
+	if d.PaymentMethod == "" {
+		out = append(out, "payment_method")
+	}
+	return out
+}
+
+// summary é o texto de confirmação enviado ao cliente.
+func (d *orderDraft) summary() string {
+	var b strings.Builder
+	b.WriteString("Resumo do pedido:\n")
+	for _, it := range d.Items {
+		fmt.Fprintf(&b, "• %dx %s — R$ %.2f\n", it.Qty, it.Title, float64(it.Qty*it.PriceCents)/100)
+	}
+	if d.DiscountCents > 0 {
+		fmt.Fprintf(&b, "Desconto (%d pontos): -R$ %.2f\n", d.RedeemPoints, float64(d.DiscountCents)/100)
+	}
+	fmt.Fprintf(&b, "Total: R$ %.2f\n", float64(d.totalCents()-d.DiscountCents)/100)
+	fmt.Fprintf(&b, "Entrega: %s\n", d.Address)
+	fmt.Fprintf(&b, "Pagamento: %s\n", d.PaymentMethod)
+	b.WriteString("\nResponda \"confirmo\" para fechar o pedido ou \"cancelar\" para desistir.")
+	return b.String()
+}
+
+func (a *App) getOrderDraft(ctx context.Context, session string, orgID, flowID int64) (*orderDraft, error) {
+	d := &orderDraft{SessionID: session, OrgID: orgID, FlowID: flowID, Status: "open"}
+	var items []byte
+	err := a.DB.QueryRow(ctx, `
+SELECT items, COALESCE(customer_name,''), COALESCE(phone,''), COALESCE(address,''), COALESCE(payment_method,''),
+       redeem_points, status
+  FROM public.chat_order_drafts
+ WHERE session_id=$1 AND org_id=$2 AND flow_id=$3 AND expires_at > NOW()`, session, orgID, flowID).
+		Scan(&items, &d.CustomerName, &d.Phone, &d.Address, &d.PaymentMethod, &d.RedeemPoints, &d.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.CustomerName, d.Phone = revealPII(orgID, d.CustomerName), revealPII(orgID, d.Phone)
+	return d, json.Unmarshal(items, &d.Items)
+}
+
+func (a *App) saveOrderDraft(ctx context.Context, d *orderDraft) error {
+	if d.Items == nil {
+		d.Items = []orderDraftItem{}
+	}
+	items, err := json.Marshal(d.Items)
+	if err != nil {
+		return err
+	}
+	version := piiKeyVersion(ctx, a.DB, d.OrgID)
+	name, err := encryptPII(d.OrgID, version, d.CustomerName)
+	if err != nil {
+		return err
+	}
+	phone, err := encryptPII(d.OrgID, version, d.Phone)
+	if err != nil {
+		return err
+	}
+	_, err = a.DB.Exec(ctx, `
+INSERT INTO public.chat_order_drafts (session_id, org_id, flow_id, items, customer_name, phone, address, payment_method,
+                                      redeem_points, status, expires_at)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), NULLIF($6,''), NULLIF($7,''), NULLIF($8,''), $9, $10, $11)
+ON CONFLICT (session_id, org_id, flow_id) DO UPDATE SET
+  items=EXCLUDED.items, customer_name=EXCLUDED.customer_name, phone=EXCLUDED.phone, address=EXCLUDED.address,
+  payment_method=EXCLUDED.payment_method, redeem_points=EXCLUDED.redeem_points, status=EXCLUDED.status,
+  updated_at=NOW(), expires_at=EXCLUDED.expires_at`,
+		d.SessionID, d.OrgID, d.FlowID, items, name, phone, d.Address, d.PaymentMethod, d.RedeemPoints, d.Status,
+		time.Now().Add(orderDraftTTL()))
+	return err
+}
+
+func (a *App) deleteOrderDraft(ctx context.Context, session string, orgID, flowID int64) error {
+	_, err := a.DB.Exec(ctx,
+		`DELETE FROM public.chat_order_drafts WHERE session_id=$1 AND org_id=$2 AND flow_id=$3`,
+		session, orgID, flowID)
+	return err
+}
+
+type orderDraftArgs struct {
+	Items []struct {
+		ProductID int64  `json:"product_id"`
+		Name      string `json:"name"`
+		Qty       int    `json:"qty"`
+	} `json:"items"`
+	ReplaceItems  bool   `json:"replace_items"`
+	CustomerName  string `json:"customer_name"`
+	Phone         string `json:"phone"`
+	Address       string `json:"address"`
+	CEP           string `json:"cep"`
+	Number        string `json:"number"`
+	Complement    string `json:"complement"`
+	PaymentMethod string `json:"payment_method"`
+	RedeemPoints  *int   `json:"redeem_points"`
+}
+
+// toolUpdateOrderDraft aplica os argumentos da ferramenta ao rascunho. qty 0
+// remove o item; replace_items troca a lista inteira.
+func (a *App) toolUpdateOrderDraft(ctx context.Context, orgID, flowID int64, session string, call openai.ToolCall) string {
+	if session == "" {
+		return toolJSON(map[string]any{"error": "order drafts require a sessionId"})
+	}
+	var args orderDraftArgs
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return toolJSON(map[string]any{"error": "invalid arguments"})
+	}
+	d, err := a.getOrderDraft(ctx, session, orgID, flowID)
+	if err != nil {
+		return toolError(call, err)
+	}
+	if args.ReplaceItems {
+		d.Items = nil
+	}
+	var problems []string
+	for _, in := range args.Items {
+		p, err := a.toolLookupProduct(ctx, orgID, flowID, in.ProductID, in.Name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			problems = append(problems, fmt.Sprintf("produto não encontrado: %s", chooseFirstNonEmpty(in.Name, fmt.Sprint(in.ProductID))))
+			continue
+		}
+		if err != nil {
+			return toolError(call, err)
+		}
+		if in.Qty > p.Stock {
+			problems = append(problems, fmt.Sprintf("%s: só há %d em estoque", p.Title, p.Stock))
+			continue
+		}
+		found := false
+		for i := range d.Items {
+			if d.Items[i].ProductID == p.ID {
+				d.Items[i].Qty, d.Items[i].PriceCents, found = in.Qty, p.PriceCents, true
+			}
+		}
+		if !found && in.Qty > 0 {
+			d.Items = append(d.Items, orderDraftItem{ProductID: p.ID, Title: p.Title, Qty: in.Qty, PriceCents: p.PriceCents})
+		}
+	}
+	kept := d.Items[:0]
+	for _, it := range d.Items {
+		if it.Qty > 0 {
+			kept = append(kept, it)
+		}
+	}
+	d.Items = kept
+
+	if v := strings.TrimSpace(args.CustomerName); v != "" {
+		d.CustomerName = v
+	}
+	if v := strings.TrimSpace(args.Phone); v != "" {
+		d.Phone = onlyDigits(v)
+	}
+	if v := strings.TrimSpace(args.Address); v != "" {
+		d.Address = limitRunes(v, 500)
+	}
+	// com CEP o endereço é montado a partir do ViaCEP (prevalece sobre address)
+	if strings.TrimSpace(args.CEP) != "" {
+		addr, err := lookupCEP(ctx, args.CEP)
+		switch {
+		case errors.Is(err, errCEPInvalid), errors.Is(err, errCEPNotFound):
+			problems = append(problems, fmt.Sprintf("CEP %s: %v", args.CEP, err))
+		case err != nil:
+			log.Printf("order draft %s: cep lookup: %v", session, err)
+			problems = append(problems, "consulta de CEP indisponível; peça o endereço completo")
+		default:
+			if strings.TrimSpace(args.Number) == "" {
+				problems = append(problems, "informe o número do endereço")
+			}
+			d.Address = limitRunes(addr.line(args.Number, args.Complement), 500)
+		}
+	}
+	if v := strings.ToLower(strings.TrimSpace(args.PaymentMethod)); v != "" {
+		pm, ok := orderPaymentMethods[v]
+		if !ok {
+			problems = append(problems, "forma de pagamento inválida (aceitas: pix, cartao, boleto, dinheiro)")
+		} else {
+			d.PaymentMethod = pm
+		}
+	}
+	if args.RedeemPoints != nil {
+		d.RedeemPoints = max(*args.RedeemPoints, 0)
+	}
+	// qualquer alteração invalida um resumo já enviado
+	d.Status = "open"
+	if err := a.saveOrderDraft(ctx, d); err != nil {
+		return toolError(call, err)
+	}
+	return toolJSON(map[string]any{
+		"draft":       d,
+		"total_cents": d.totalCents(),
+		"missing":     d.missing(),
+		"problems":    problems,
+	})
+}
+
+// toolRequestOrderConfirmation valida o rascunho e devolve o resumo que o
+// modelo deve repassar ao cliente.
+func (a *App) toolRequestOrderConfirmation(ctx context.Context, orgID, flowID int64, session string, call openai.ToolCall) string {
+	if session == "" {
+		return toolJSON(map[string]any{"error": "order drafts require a sessionId"})
+	}
+	d, err := a.getOrderDraft(ctx, session, orgID, flowID)
+	if err != nil {
+		return toolError(call, err)
+	}
+	if m := d.missing(); len(m) > 0 {
+		return toolJSON(map[string]any{"error": "draft incomplete", "missing": m})
+	}
+	if d.RedeemPoints > 0 {
+		used, discount, err := a.quoteDraftPoints(ctx, d)
+		if errors.Is(err, errLoyaltyInactive) || errors.Is(err, errLoyaltyBalance) {
+			return toolJSON(map[string]any{"error": err.Error(), "hint": "ajuste redeem_points (0 remove o desconto)"})
+		}
+		if err != nil {
+			return toolError(call, err)
+		}
+		d.RedeemPoints, d.DiscountCents = used, discount
+	}
+	d.Status = "awaiting_confirmation"
+	if err := a.saveOrderDraft(ctx, d); err != nil {
+		return toolError(call, err)
+	}
+	return toolJSON(map[string]any{
+		"summary":     d.summary(),
+		"total_cents": d.totalCents() - d.DiscountCents,
+		"instruction": "Envie o resumo exatamente como está e aguarde o cliente responder \"confirmo\".",
+	})
+}
+
+// completeOrderDraft trata a resposta a um resumo pendente, como
+// completePending faz com o preço de produtos. handled=false segue para a IA.
+func (a *App) completeOrderDraft(ctx context.Context, session string, orgID, flowID int64, message string) (reply string, order *Order, handled bool, err error) {
+	if session == "" || orgID <= 0 || flowID <= 0 {
+		return "", nil, false, nil
+	}
+	confirm, cancel := orderConfirmRe.MatchString(message), orderCancelRe.MatchString(message)
+	if !confirm && !cancel {
+		return "", nil, false, nil
+	}
+	d, err := a.getOrderDraft(ctx, session, orgID, flowID)
+	if err != nil || d.Status != "awaiting_confirmation" {
+		return "", nil, false, err
+	}
+	if cancel {
+		if err := a.deleteOrderDraft(ctx, session, orgID, flowID); err != nil {
+			return "", nil, true, err
+		}
+		return "Pedido cancelado. Se quiser, podemos montar outro.", nil, true, nil
+	}
+
+	order, err = a.createOrderFromDraft(ctx, d)
+	var stockErr *draftStockError
+	if errors.As(err, &stockErr) {
+		d.Status = "open"
+		_ = a.saveOrderDraft(ctx, d)
+		return fmt.Sprintf("Não consegui fechar o pedido: %s. Quer ajustar a quantidade?", stockErr.Error()), nil, true, nil
+	}
+	if errors.Is(err, errLoyaltyInactive) || errors.Is(err, errLoyaltyBalance) {
+		d.Status = "open"
+		_ = a.saveOrderDraft(ctx, d)
+		return fmt.Sprintf("Não consegui aplicar os pontos: %s. Quer fechar o pedido sem o desconto?", err.Error()), nil, true, nil
+	}
+	if err != nil {
+		return "", nil, true, err
+	}
+	return fmt.Sprintf("Pedido #%d confirmado! Total: R$ %.2f. Pagamento via %s.",
+		order.ID, float64(order.TotalCents)/100, d.PaymentMethod), order, true, nil
+}
+
+type draftStockError struct{ Title string }
+
+func (e *draftStockError) Error() string { return e.Title + " sem estoque suficiente" }
+
+// createOrderFromDraft grava pedido e itens com os preços atuais e remove o
+// rascunho, tudo na mesma transação.
+func (a *App) createOrderFromDraft(ctx context.Context, d *orderDraft) (*Order, error) {
+	leadID, err := a.leadIDByPhone(ctx, d.OrgID, d.FlowID, d.Phone, false)
+	if err != nil {
+		return nil, err
+	}
+	if leadID == 0 && (d.Phone != "" || d.CustomerName != "") {
+		if leadID, _, err = a.insertLead(ctx, d.OrgID, d.FlowID, d.CustomerName, d.Phone, "", "proposta"); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	total := 0
+	var flashSales []int64
+	for i, it := range d.Items {
+		var price, stock int
+		err := tx.QueryRow(ctx, `
+SELECT price_cents, stock FROM products WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND status='active'
+   AND `+productAvailableSQL(),
+			it.ProductID, d.OrgID, d.FlowID).Scan(&price, &stock)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && stock < it.Qty) {
+			return nil, &draftStockError{Title: it.Title}
+		}
+		if err != nil {
+			return nil, err
+		}
+		// oferta relâmpago em andamento: preço dela, dentro do limite (flash_sales.go)
+		saleID, salePrice, left, err := flashSaleReserve(ctx, tx, d.OrgID, it.ProductID, it.Qty)
+		if errors.Is(err, errFlashSaleCap) {
+			return nil, &draftStockError{Title: fmt.Sprintf("%s (oferta relâmpago: restam %d)", it.Title, left)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if saleID > 0 {
+			price = salePrice
+			flashSales = append(flashSales, saleID)
+		}
+		d.Items[i].PriceCents = price
+		total += price * it.Qty
+	}
+
+	// desconto com pontos: valida com o lead travado, debita após ter o id
+	points, discount := 0, 0
+	if d.RedeemPoints > 0 {
+		if leadID == 0 {
+			return nil, fmt.Errorf("%w (cliente sem cadastro)", errLoyaltyBalance)
+		}
+		if points, discount, err = a.redeemLoyaltyTx(ctx, tx, d.OrgID, leadID, d.RedeemPoints, total); err != nil {
+			return nil, err
+		}
+	}
+
+	o := &Order{OrgID: d.OrgID, FlowID: d.FlowID, LeadID: leadID, TotalCents: total - discount, Status: "pending"}
+	err = tx.QueryRow(ctx, `
+INSERT INTO orders (org_id, flow_id, lead_id, total_cents, status, shipping_address, payment_method, source,
+                    discount_cents, points_redeemed)
+VALUES ($1, $2, $3, $4, $5, $6, $7, 'chat', $8, $9) RETURNING id, created_at`,
+		d.OrgID, d.FlowID, leadID, o.TotalCents, o.Status, d.Address, d.PaymentMethod, discount, points).Scan(&o.ID, &o.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if points > 0 {
+		if err := debitLoyaltyTx(ctx, tx, d.OrgID, leadID, o.ID, points); err != nil {
+			return nil, err
+		}
+	}
+	for _, it := range d.Items {
+		if _, err := tx.Exec(ctx, `
+INSERT INTO order_items (org_id, flow_id, order_id, product_id, qty, unit_price_cents) VALUES ($1, $2, $3, $4, $5, $6)`,
+			d.OrgID, d.FlowID, o.ID, it.ProductID, it.Qty, it.PriceCents); err != nil {
+			return nil, err
+		}
+	}
+	// baixa o estoque (ou o pool) na mesma transação (order_stock.go)
+	if err := reserveOrderStockTx(ctx, tx, d.OrgID, o.ID, true); err != nil {
+		var se *orderStockError
+		if errors.As(err, &se) {
+			return nil, &draftStockError{Title: se.Title}
+		}
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM public.chat_order_drafts WHERE session_id=$1 AND org_id=$2 AND flow_id=$3`,
+		d.SessionID, d.OrgID, d.FlowID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	a.flashSaleAfterOrder(ctx, flashSales)
+	a.touchProductFeed(d.OrgID, 0)
+	a.publishEvent(ctx, o.OrgID, eventOrderCreated, *o)
+	return o, nil
+}
+
+// quoteDraftPoints estima pontos usados e desconto do rascunho com o saldo
+// atual do cliente (pelo telefone), sem debitar.
+func (a *App) quoteDraftPoints(ctx context.Context, d *orderDraft) (used, discountCents int, err error) {
+	leadID, err := a.leadIDByPhone(ctx, d.OrgID, d.FlowID, d.Phone, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if leadID == 0 {
+		return 0, 0, fmt.Errorf("%w (cliente sem cadastro)", errLoyaltyBalance)
+	}
+	s, err := a.loadLoyaltySettings(ctx, d.OrgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	balance, err := a.loyaltyBalance(ctx, leadID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.quote(balance, d.RedeemPoints, d.totalCents())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ================================================================
+//  Store de produtos pendentes (chat_pending_products)
+// ================================================================
+//
+// A sugestão gerada no /api/vision/upload fica aguardando o preço do
+// usuário. Guardamos no Postgres (chave sessão+org+flow) para sobreviver a
+// deploys e funcionar com várias réplicas. Cada pendência expira após
+// PENDING_TTL (padrão 30m); um loop remove as vencidas periodicamente.
+// images guarda todas as fotos da pendência, na ordem de envio; image_path e
+// image_url repetem a primeira (capa). Tabela em
+// migrations/0050_chat_pending_images.sql.
+
+func pendingTTL() time.Duration {
+	if d, err := time.ParseDuration(getenv("PENDING_TTL", "30m")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Minute
+}
+
+// setPending grava (ou substitui) a pendência da sessão.
+func (a *App) setPending(ctx context.Context, session string, p *pendingProduct) error {
+	if session == "" {
+		return nil
+	}
+	sug, err := json.Marshal(p.Suggest)
+	if err != nil {
+		return err
+	}
+	if len(p.Images) == 0 && p.ImageURL != "" {
+		p.Images = []pendingImage{{Path: p.ImagePath, URL: p.ImageURL}}
+	}
+	images, err := json.Marshal(p.Images)
+	if err != nil {
+		return err
+	}
+	_, err = a.DB.Exec(ctx, `
+INSERT INTO public.chat_pending_products (session_id, org_id, flow_id, image_path, image_url, suggest, images, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (session_id, org_id, flow_id) DO UPDATE
+SET image_path = EXCLUDED.image_path,
+    image_url  = EXCLUDED.image_url,
+    suggest    = EXCLUDED.suggest,
+    images     = EXCLUDED.images,
+    created_at = NOW(),
+    expires_at = EXCLUDED.expires_at
+`, session, p.OrgID, p.FlowID, p.ImagePath, p.ImageURL, sug, images, time.Now().Add(pendingTTL()))
+	return err
+}
+
+// getPending busca a pendência válida mais recente da sessão. org/flow <= 0
+// não filtram (o cliente nem sempre envia os headers no chat).
+func (a *App) getPending(ctx context.Context, session string, orgID, flowID int) (*pendingProduct, bool, error) {
+	if session == "" {
+		return nil, false, nil
+	}
+	var (
+		p      pendingProduct
+		sug    []byte
+		images []byte
+	)
+	err := a.DB.QueryRow(ctx, `
+SELECT org_id, flow_id, COALESCE(image_path,''), COALESCE(image_url,''), suggest, images
+  FROM public.chat_pending_products
+ WHERE session_id = $1
+   AND ($2 <= 0 OR org_id = $2)
+   AND ($3 <= 0 OR flow_id = $3)
+   AND expires_at > NOW()
+ ORDER BY created_at DESC
+ LIMIT 1`, session, orgID, flowID).Scan(&p.OrgID, &p.FlowID, &p.ImagePath, &p.ImageURL, &sug, &images)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(sug, &p.Suggest); err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(images, &p.Images); err != nil {
+		return nil, false, err
+	}
+	if len(p.Images) == 0 && p.ImageURL != "" {
+		p.Images = []pendingImage{{Path: p.ImagePath, URL: p.ImageURL}}
+	}
+	return &p, true, nil
+}
+
+func (a *App) clearPending(ctx context.Context, session string, orgID, flowID int) error {
+	_, err := a.DB.Exec(ctx,
+		`DELETE FROM public.chat_pending_products WHERE session_id=$1 AND org_id=$2 AND flow_id=$3`,
+		session, orgID, flowID)
+	return err
+}
+
+// pendingCleanupLoop remove pendências expiradas a cada 5 minutos.
+func (a *App) pendingCleanupLoop() {
+	t := time.NewTicker(5 * time.Minute)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		tag, err := a.DB.Exec(ctx, `DELETE FROM public.chat_pending_products WHERE expires_at <= NOW()`)
+		// rascunhos de pedido vencidos (chat_order_draft.go) saem no mesmo ciclo
+		_, _ = a.DB.Exec(ctx, `DELETE FROM public.chat_order_drafts WHERE expires_at <= NOW()`)
+		// sessões vencidas ou encerradas (chat_sessions.go)
+		_, _ = a.DB.Exec(ctx, `DELETE FROM public.chat_sessions WHERE expires_at <= NOW() OR ended_at IS NOT NULL`)
+		// jobs de visão (vision_jobs.go) ficam um dia para consulta
+		_, _ = a.DB.Exec(ctx, `DELETE FROM public.vision_jobs WHERE created_at <= NOW() - INTERVAL '1 day'`)
+		cancel()
+		if err != nil {
+			log.Printf("pending cleanup: %v", err)
+			continue
+		}
+		if n := tag.RowsAffected(); n > 0 {
+			log.Printf("pending cleanup: removed %d expired", n)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Sessões de chat emitidas pelo servidor (chat_sessions)
+// ================================================================
+//
+// O sessionId de /api/chat, /api/chat/ws e /api/vision/upload agrupa
+// histórico, produto pendente e rascunho de pedido. Antes era qualquer
+// string do cliente; agora é emitido aqui, preso à org/flow de quem criou, e
+// expira após CHAT_SESSION_TTL (padrão 24h) sem uso (cada mensagem renova).
+//
+// POST   /api/chat/sessions       -> {session_id, expires_at, ttl_seconds}
+// DELETE /api/chat/sessions/{id}  encerra e descarta pendência/rascunho
+//
+// Sessão ausente, desconhecida, de outra org/flow, vencida ou encerrada é
+// recusada (400 sem sessionId, 404 nos demais). CHAT_SESSION_ENFORCE=false
+// volta a aceitar ids livres enquanto os clientes migram; ids emitidos
+// continuam sendo validados. Tabela em migrations/0048_chat_sessions.sql.
+
+const chatSessionPrefix = "cs_"
+
+var errChatSessionInvalid = errors.New("chat session not found or expired")
+
+func chatSessionTTL() time.Duration {
+	if d, err := time.ParseDuration(getenv("CHAT_SESSION_TTL", "24h")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+func chatSessionsEnforced() bool {
+	v, err := strconv.ParseBool(getenv("CHAT_SESSION_ENFORCE", "true"))
+	return err != nil || v
+}
+
+type chatSession struct {
+	ID         string    `json:"session_id"`
+	OrgID      int64     `json:"org_id"`
+	FlowID     int64     `json:"flow_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	TTLSeconds int64     `json:"ttl_seconds"`
+}
+
+// POST /api/chat/sessions
+func (a *App) createChatSession(w http.ResponseWriter, r *http.Request) {
+	t, _ := tenantFrom(r.Context())
+	ttl := chatSessionTTL()
+	s := chatSession{ID: chatSessionPrefix + secureToken(16), OrgID: t.OrgID, FlowID: t.FlowID, TTLSeconds: int64(ttl.Seconds())}
+	var userID *int64
+	if t.UserID > 0 {
+		userID = &t.UserID
+	}
+	err := a.DB.QueryRow(r.Context(), `
+INSERT INTO public.chat_sessions (id, org_id, flow_id, user_id, expires_at)
+VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 second') RETURNING created_at, expires_at`,
+		s.ID, s.OrgID, s.FlowID, userID, s.TTLSeconds).Scan(&s.CreatedAt, &s.ExpiresAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, s)
+}
+
+// DELETE /api/chat/sessions/{id}
+func (a *App) endChatSession(w http.ResponseWriter, r *http.Request) {
+	t, _ := tenantFrom(r.Context())
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	tag, err := a.DB.Exec(r.Context(), `
+UPDATE public.chat_sessions SET ended_at=NOW()
+ WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND ended_at IS NULL`, id, t.OrgID, t.FlowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "chat session not found")
+		return
+	}
+	// o histórico fica; o que dependia da sessão aberta sai
+	if err := a.clearPending(r.Context(), id, int(t.OrgID), int(t.FlowID)); err != nil {
+		log.Printf("end chat session %s: %v", id, err)
+	}
+	if err := a.deleteOrderDraft(r.Context(), id, t.OrgID, t.FlowID); err != nil {
+		log.Printf("end chat session %s: %v", id, err)
+	}
+	render.NoContent(w)
+}
+
+// touchChatSession confirma que a sessão está aberta para a org/flow e
+// renova o prazo. Com CHAT_SESSION_ENFORCE=false, ids que não foram emitidos
+// aqui passam.
+func (a *App) touchChatSession(ctx context.Context, id string, orgID, flowID int64) error {
+	enforced := chatSessionsEnforced()
+	if id == "" {
+		if enforced {
+			return errChatSessionInvalid
+		}
+		return nil
+	}
+	if !strings.HasPrefix(id, chatSessionPrefix) && !enforced {
+		return nil
+	}
+	var ok bool
+	err := a.DB.QueryRow(ctx, `
+UPDATE public.chat_sessions SET last_seen_at=NOW(), expires_at=NOW() + $4 * INTERVAL '1 second'
+ WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND ended_at IS NULL AND expires_at > NOW()
+RETURNING true`, id, orgID, flowID, int64(chatSessionTTL().Seconds())).Scan(&ok)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errChatSessionInvalid
+	}
+	return err
+}
+
+// requireChatSession valida o sessionId da requisição e responde o erro
+// quando não passa.
+func (a *App) requireChatSession(w http.ResponseWriter, r *http.Request, id string, orgID, flowID int64) bool {
+	err := a.touchChatSession(r.Context(), id, orgID, flowID)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errChatSessionInvalid) && id == "":
+		render.Error(w, http.StatusBadRequest, "sessionId required (create one with POST /api/chat/sessions)")
+	case errors.Is(err, errChatSessionInvalid):
+		render.Error(w, http.StatusNotFound, err.Error())
+	default:
+		render.Error(w, http.StatusInternalServerError, err.Error())
+	}
+	return false
+}
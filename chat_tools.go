@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/llm"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Ferramentas (function calling) do agente de chat
+// ================================================================
+//
+// Com org/flow conhecidos, o modelo recebe ferramentas que consultam a base
+// do tenant: search_products, get_product_price, check_stock, create_lead e
+// lookup_cep (cep.go); a agenda usa list_available_slots e book_appointment
+// (appointments.go).
+// Com sessionId também monta pedidos: update_order_draft e
+// request_order_confirmation (chat_order_draft.go). get_loyalty_balance
+// consulta os pontos de fidelidade do cliente (loyalty.go).
+// runChatWithTools executa as chamadas e devolve os resultados ao modelo até
+// obter a resposta final (no máximo chatToolMaxRounds rodadas).
+
+const chatToolMaxRounds = 5
+
+func chatTools() []openai.Tool {
+	productRef := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"product_id": map[string]any{"type": "integer", "description": "ID do produto, se conhecido"},
+			"name":       map[string]any{"type": "string", "description": "Nome (ou parte do nome) do produto"},
+		},
+	}
+	defs := []openai.FunctionDefinition{
+		{
+			Name:        "search_products",
+			Description: "Busca produtos ativos do catálogo por nome, categoria ou tags (tolerante a erros de digitação).",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string"},
+					"limit": map[string]any{"type": "integer", "minimum": 1, "maximum": 20},
+				},
+				"required": []string{"query"},
+			},
+		},
+		{
+			Name:        "get_product_price",
+			Description: "Retorna o preço atual de um produto.",
+			Parameters:  productRef,
+		},
+		{
+			Name:        "check_stock",
+			Description: "Retorna a quantidade em estoque de um produto.",
+			Parameters:  productRef,
+		},
+		{
+			Name:        "create_lead",
+			Description: "Registra um lead (cliente interessado) com nome e contato.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":  map[string]any{"type": "string"},
+					"phone": map[string]any{"type": "string"},
+					"email": map[string]any{"type": "string"},
+				},
+				"required": []string{"name"},
+			},
+		},
+		{
+			Name:        "lookup_cep",
+			Description: "Consulta um CEP e retorna logradouro, bairro, cidade e UF. Use para confirmar o endereço de entrega.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"cep": map[string]any{"type": "string", "description": "CEP com ou sem hífen (8 dígitos)"},
+				},
+				"required": []string{"cep"},
+			},
+		},
+		{
+			Name:        "list_available_slots",
+			Description: "Lista horários livres da agenda para atendimento, a partir de uma data.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"date": map[string]any{"type": "string", "description": "Data inicial YYYY-MM-DD (padrão: hoje)"},
+					"days": map[string]any{"type": "integer", "minimum": 1, "maximum": 14},
+				},
+			},
+		},
+		{
+			Name:        "book_appointment",
+			Description: "Marca um atendimento num horário livre retornado por list_available_slots. Confirme data e hora com o cliente antes.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"starts_at": map[string]any{"type": "string", "description": "starts_at exato de list_available_slots"},
+					"name":      map[string]any{"type": "string"},
+					"phone":     map[string]any{"type": "string"},
+					"notes":     map[string]any{"type": "string", "description": "Serviço desejado ou observações"},
+				},
+				"required": []string{"starts_at", "phone"},
+			},
+		},
+		{
+			Name: "update_order_draft",
+			Description: "Cria ou altera o rascunho de pedido da conversa: itens (qty 0 remove), nome, telefone, " +
+				"endereço de entrega e forma de pagamento (pix, cartao, boleto, dinheiro). Envie só o que mudou.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"items": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"product_id": map[string]any{"type": "integer"},
+								"name":       map[string]any{"type": "string"},
+								"qty":        map[string]any{"type": "integer", "minimum": 0},
+							},
+							"required": []string{"qty"},
+						},
+					},
+					"replace_items":  map[string]any{"type": "boolean", "description": "Substitui todos os itens pelos enviados"},
+					"customer_name":  map[string]any{"type": "string"},
+					"phone":          map[string]any{"type": "string"},
+					"address":        map[string]any{"type": "string", "description": "Endereço completo de entrega"},
+					"cep":            map[string]any{"type": "string", "description": "CEP de entrega; monta o endereço junto com number e complement"},
+					"number":         map[string]any{"type": "string"},
+					"complement":     map[string]any{"type": "string"},
+					"payment_method": map[string]any{"type": "string", "enum": []string{"pix", "cartao", "boleto", "dinheiro"}},
+					"redeem_points":  map[string]any{"type": "integer", "minimum": 0, "description": "Pontos de fidelidade a usar como desconto (0 remove)"},
+				},
+			},
+		},
+		{
+			Name: "get_loyalty_balance",
+			Description: "Consulta o saldo de pontos de fidelidade do cliente e quanto valem em desconto. " +
+				"Sem telefone usa o do rascunho de pedido.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"phone": map[string]any{"type": "string"},
+				},
+			},
+		},
+		{
+			Name:        "request_order_confirmation",
+			Description: "Gera o resumo do rascunho para o cliente confirmar. Use quando itens, endereço e pagamento estiverem definidos.",
+			Parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
+		},
+	}
+	tools := make([]openai.Tool, 0, len(defs))
+	for i := range defs {
+		tools = append(tools, openai.Tool{Type: openai.ToolTypeFunction, Function: &defs[i]})
+	}
+	return tools
+}
+
+// runChatWithTools chama o modelo com as ferramentas do tenant e resolve as
+// tool calls, devolvendo a última resposta (sem tool calls). O gateway
+// WebSocket faz o equivalente em streaming (wsStreamRound).
+func (a *App) runChatWithTools(ctx context.Context, provider llm.Provider, req openai.ChatCompletionRequest, orgID, flowID int64, session string) (openai.ChatCompletionResponse, error) {
+	req.Tools = chatTools()
+	for round := 0; ; round++ {
+		if round == chatToolMaxRounds {
+			// chega de ferramentas: força uma resposta em texto
+			req.Tools, req.ToolChoice = nil, nil
+		}
+		resp, err := provider.Chat(ctx, req)
+		if err == nil {
+			a.recordAIUsage(orgID, flowID, "chat", resp.Model, resp.Usage)
+		}
+		if err != nil || len(resp.Choices) == 0 {
+			if err == nil {
+				err = errors.New("empty response")
+			}
+			return resp, err
+		}
+		msg := resp.Choices[0].Message
+		if len(msg.ToolCalls) == 0 {
+			return resp, nil
+		}
+		req.Messages = append(req.Messages, msg)
+		for _, call := range msg.ToolCalls {
+			req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Content:    a.execChatTool(ctx, orgID, flowID, session, call),
+			})
+		}
+	}
+}
+
+// execChatTool executa uma chamada e devolve o resultado em JSON. Erros
+// também voltam como JSON ({"error": ...}) para o modelo explicar ao usuário.
+func (a *App) execChatTool(ctx context.Context, orgID, flowID int64, session string, call openai.ToolCall) string {
+	switch call.Function.Name {
+	case "update_order_draft":
+		return a.toolUpdateOrderDraft(ctx, orgID, flowID, session, call)
+	case "request_order_confirmation":
+		return a.toolRequestOrderConfirmation(ctx, orgID, flowID, session, call)
+	case "list_available_slots":
+		return a.toolListAvailableSlots(ctx, orgID, flowID, call)
+	case "book_appointment":
+		return a.toolBookAppointment(ctx, orgID, flowID, call)
+	case "get_loyalty_balance":
+		return a.toolLoyaltyBalance(ctx, orgID, flowID, session, call)
+	}
+
+	var args struct {
+		Query     string `json:"query"`
+		Limit     int    `json:"limit"`
+		ProductID int64  `json:"product_id"`
+		Name      string `json:"name"`
+		Phone     string `json:"phone"`
+		Email     string `json:"email"`
+		CEP       string `json:"cep"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return toolJSON(map[string]any{"error": "invalid arguments"})
+	}
+
+	switch call.Function.Name {
+	case "search_products":
+		limit := args.Limit
+		if limit <= 0 || limit > 20 {
+			limit = 5
+		}
+		items, err := a.findProducts(ctx, orgID, flowID, args.Query, limit, 0)
+		if err != nil {
+			return toolError(call, err)
+		}
+		return toolJSON(map[string]any{"products": items})
+
+	case "get_product_price", "check_stock":
+		p, err := a.toolLookupProduct(ctx, orgID, flowID, args.ProductID, args.Name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return toolJSON(map[string]any{"error": "product not found"})
+		}
+		if err != nil {
+			return toolError(call, err)
+		}
+		if call.Function.Name == "check_stock" {
+			return toolJSON(map[string]any{"product_id": p.ID, "title": p.Title, "stock": p.Stock, "in_stock": p.Stock > 0})
+		}
+		return toolJSON(map[string]any{
+			"product_id":  p.ID,
+			"title":       p.Title,
+			"price_cents": p.PriceCents,
+			"price":       fmt.Sprintf("R$ %.2f", float64(p.PriceCents)/100),
+		})
+
+	case "lookup_cep":
+		addr, err := lookupCEP(ctx, args.CEP)
+		if errors.Is(err, errCEPInvalid) || errors.Is(err, errCEPNotFound) {
+			return toolJSON(map[string]any{"error": err.Error()})
+		}
+		if err != nil {
+			return toolError(call, err)
+		}
+		return toolJSON(addr)
+
+	case "create_lead":
+		if strings.TrimSpace(args.Name) == "" {
+			return toolJSON(map[string]any{"error": "name required"})
+		}
+		if strings.TrimSpace(args.Phone) != "" {
+			// mesmo telefone no org/flow = mesmo lead (lead_dedup.go)
+			id, err := a.leadIDByPhone(ctx, orgID, flowID, args.Phone, false)
+			if err != nil {
+				return toolError(call, err)
+			}
+			if id > 0 {
+				return toolJSON(map[string]any{"lead_id": id, "created": false})
+			}
+		}
+		id, _, err := a.insertLead(ctx, orgID, flowID, args.Name, args.Phone, args.Email, "novo")
+		if err != nil {
+			return toolError(call, err)
+		}
+		return toolJSON(map[string]any{"lead_id": id, "created": true})
+
+	default:
+		return toolJSON(map[string]any{"error": "unknown tool " + call.Function.Name})
+	}
+}
+
+// toolLookupProduct localiza um produto ativo pelo id ou pelo nome mais
+// parecido. Com oferta relâmpago em andamento o preço é o da oferta.
+func (a *App) toolLookupProduct(ctx context.Context, orgID, flowID, id int64, name string) (Product, error) {
+	if id <= 0 && strings.TrimSpace(name) != "" {
+		found, err := a.findProductSuggestions(ctx, orgID, flowID, name, 1)
+		if err != nil {
+			return Product{}, err
+		}
+		if len(found) == 0 {
+			return Product{}, pgx.ErrNoRows
+		}
+		id = found[0].ID
+	}
+	var p Product
+	err := a.DB.QueryRow(ctx, `
+SELECT id, title,
+       COALESCE((SELECT price_cents FROM flash_sales WHERE product_id=products.id AND `+flashSaleRunningSQL+`), price_cents),
+       stock FROM products
+ WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND status='active'
+   AND `+productAvailableSQL(), id, orgID, flowID).
+		Scan(&p.ID, &p.Title, &p.PriceCents, &p.Stock)
+	return p, err
+}
+
+func toolJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func toolError(call openai.ToolCall, err error) string {
+	log.Printf("chat tool %s: %v", call.Function.Name, err)
+	return toolJSON(map[string]any{"error": "internal error"})
+}
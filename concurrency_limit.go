@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Limite de requisições simultâneas por rota (por réplica)
+// ================================================================
+//
+// O rate limit (ratelimit.go) controla quantas requisições chegam por
+// minuto; este limite controla quantas rodam ao mesmo tempo nas rotas caras,
+// que seguram LLM, visão ou parsing de planilha por segundos. Excedido, a
+// requisição espera até CONCURRENCY_QUEUE_WAIT (padrão 0 = não espera) por
+// uma vaga e, sem vaga, recebe 429 com Retry-After, em vez de disputar CPU e
+// conexões com as que já estão em andamento.
+//
+//   CONCURRENCY_CHAT    POST /api/chat             (padrão 32)
+//   CONCURRENCY_VISION  POST /api/vision/upload    (padrão 8)
+//   CONCURRENCY_IMPORT  POST /api/products/import  (padrão 2)
+//
+// 0 desliga o limite. Os valores são por réplica. Recusas contam em
+// paclead_concurrency_shed_total{limit}; as vagas em uso saem no gauge
+// paclead_concurrency_in_flight{limit}.
+
+type concurrencySem struct {
+	slots chan struct{}
+}
+
+var (
+	concurrencyMu   sync.Mutex
+	concurrencySems = map[string]*concurrencySem{}
+)
+
+func concurrencyMax(envVar string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(getenv(envVar, strconv.Itoa(def))))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+func concurrencyQueueWait() time.Duration {
+	d, err := time.ParseDuration(getenv("CONCURRENCY_QUEUE_WAIT", "0s"))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// concurrencyLimit deixa no máximo max requisições da rota rodando ao mesmo
+// tempo. name identifica o limite (rotas com o mesmo nome dividem as vagas)
+// e rotula as métricas.
+func (a *App) concurrencyLimit(name string, max int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+		concurrencyMu.Lock()
+		sem, ok := concurrencySems[name]
+		if !ok {
+			sem = &concurrencySem{slots: make(chan struct{}, max)}
+			concurrencySems[name] = sem
+		}
+		concurrencyMu.Unlock()
+		wait := concurrencyQueueWait()
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !sem.acquire(r, wait) {
+				concurrencyShed.inc(name)
+				w.Header().Set("Retry-After", "1")
+				render.Error(w, http.StatusTooManyRequests, "server busy, too many concurrent requests; retry shortly")
+				return
+			}
+			defer func() { <-sem.slots }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acquire ocupa uma vaga, esperando até wait (ou o cliente desistir).
+func (s *concurrencySem) acquire(r *http.Request, wait time.Duration) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+var concurrencyShed = newCounter("paclead_concurrency_shed_total",
+	"Requests rejected with 429 by the per-route concurrency limit.", "limit")
+
+// writeConcurrencyMetrics escreve o shed e as vagas em uso (metrics.go).
+func writeConcurrencyMetrics(b *strings.Builder) {
+	concurrencyShed.write(b)
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	if len(concurrencySems) == 0 {
+		return
+	}
+	b.WriteString("# HELP paclead_concurrency_in_flight Requests running under a concurrency limit.\n")
+	b.WriteString("# TYPE paclead_concurrency_in_flight gauge\n")
+	for name, sem := range concurrencySems {
+		fmt.Fprintf(b, "paclead_concurrency_in_flight{limit=\"%s\"} %d\n", escapeLabel(name), len(sem.slots))
+	}
+	b.WriteString("# HELP paclead_concurrency_limit Concurrency limit per route.\n")
+	b.WriteString("# TYPE paclead_concurrency_limit gauge\n")
+	for name, sem := range concurrencySems {
+		fmt.Fprintf(b, "paclead_concurrency_limit{limit=\"%s\"} %d\n", escapeLabel(name), cap(sem.slots))
+	}
+}
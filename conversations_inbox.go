@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Inbox de conversas do WhatsApp
+// ================================================================
+//
+// Leitura das conversas e mensagens gravadas pela ingestão (wa_inbound.go)
+// e resposta pela instância da conversa.
+//
+// GET  /api/conversations                 ?status=open|closed|all&assigned=me|unassigned|<user_id>&limit=&cursor=
+// GET  /api/conversations/{id}
+// PUT  /api/conversations/{id}            {"status":"closed","assigned_to":7|null}
+// GET  /api/conversations/{id}/messages   ?before=<message_id>&limit=   (zera unread)
+// POST /api/conversations/{id}/reply      {"text"}
+//
+// A listagem é paginada por cursor (next_cursor), da conversa mais recente
+// para a mais antiga.
+
+const (
+	inboxDefaultLimit = 50
+	inboxMaxLimit     = 200
+)
+
+type inboxConversation struct {
+	ID            int64      `json:"id"`
+	InstanceID    string     `json:"instance_id"`
+	Contact       string     `json:"contact"`
+	LeadID        *int64     `json:"lead_id,omitempty"`
+	LeadName      string     `json:"lead_name,omitempty"`
+	LastMessage   string     `json:"last_message,omitempty"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+	Unread        int        `json:"unread"`
+	Status        string     `json:"status"`
+	AssignedTo    *int64     `json:"assigned_to,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	orgID  int64
+	flowID int64
+	sortAt time.Time
+}
+
+const inboxColumns = `c.id, COALESCE(c.instance_id,''), COALESCE(c.contact,''), c.lead_id, COALESCE(l.name,''),
+       COALESCE(c.last_message,''), c.last_message_at, c.unread, COALESCE(c.status,'open'), c.assigned_to,
+       c.created_at, c.org_id, c.flow_id, COALESCE(c.last_message_at, c.created_at)`
+
+const inboxFrom = `
+  FROM public.conversations c
+  LEFT JOIN public.leads l ON l.id = c.lead_id`
+
+func scanInboxConversation(row pgx.Row) (inboxConversation, error) {
+	var c inboxConversation
+	err := row.Scan(&c.ID, &c.InstanceID, &c.Contact, &c.LeadID, &c.LeadName, &c.LastMessage, &c.LastMessageAt,
+		&c.Unread, &c.Status, &c.AssignedTo, &c.CreatedAt, &c.orgID, &c.flowID, &c.sortAt)
+	if err != nil {
+		return c, err
+	}
+	c.LeadName = revealPII(c.orgID, c.LeadName)
+	return c, nil
+}
+
+type inboxMessage struct {
+	ID        int64  `json:"id"`
+	Direction string `json:"direction"` // in | out
+	Type      string `json:"type"`
+	Body      string `json:"body,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	// entrega dos enviados (wa_message_status.go)
+	Status      string     `json:"status,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (a *App) mountConversations(r chi.Router) {
+	r.Route("/conversations", func(r chi.Router) {
+		r.Get("/", a.listConversations)
+		r.Get("/{id}", a.getConversation)
+		r.Put("/{id}", a.updateConversation)
+		r.Get("/{id}/messages", a.listConversationMessages)
+		r.Post("/{id}/reply", a.replyConversation)
+	})
+}
+
+// inboxCursor codifica a posição (ordenação + id) da última conversa.
+func encodeInboxCursor(at time.Time, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(id, 10)))
+}
+
+func decodeInboxCursor(s string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+	ts, idStr, ok := strings.Cut(string(raw), "|")
+	at, err1 := time.Parse(time.RFC3339Nano, ts)
+	id, err2 := strconv.ParseInt(idStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+	return at, id, nil
+}
+
+func inboxLimit(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || n <= 0 {
+		return inboxDefaultLimit
+	}
+	return min(n, inboxMaxLimit)
+}
+
+// GET /api/conversations
+func (a *App) listConversations(w http.ResponseWriter, r *http.Request) {
+	t, _ := tenantFrom(r.Context())
+	q := r.URL.Query()
+
+	status := nonEmpty(strings.ToLower(strings.TrimSpace(q.Get("status"))), "open")
+	if status != "open" && status != "closed" && status != "all" {
+		render.Error(w, http.StatusBadRequest, "invalid status (use open, closed or all)")
+		return
+	}
+	// assigned: "" (todas), "me", "unassigned" ou id do usuário
+	var assignedTo *int64
+	unassigned := false
+	switch v := strings.TrimSpace(q.Get("assigned")); v {
+	case "":
+	case "me":
+		assignedTo = &t.UserID
+	case "unassigned":
+		unassigned = true
+	default:
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid assigned (use me, unassigned or a user id)")
+			return
+		}
+		assignedTo = &id
+	}
+	var curAt *time.Time
+	var curID int64
+	if c := q.Get("cursor"); c != "" {
+		at, id, err := decodeInboxCursor(c)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		curAt, curID = &at, id
+	}
+	limit := inboxLimit(r)
+
+	rows, err := a.DB.Query(r.Context(), `
+SELECT `+inboxColumns+inboxFrom+`
+ WHERE c.org_id=$1 AND c.flow_id=$2
+   AND ($3 = 'all' OR COALESCE(c.status,'open') = $3)
+   AND ($4::bigint IS NULL OR c.assigned_to = $4)
+   AND (NOT $5 OR c.assigned_to IS NULL)
+   AND ($6::timestamptz IS NULL OR (COALESCE(c.last_message_at, c.created_at), c.id) < ($6, $7))
+ ORDER BY COALESCE(c.last_message_at, c.created_at) DESC, c.id DESC
+ LIMIT $8`, t.OrgID, t.FlowID, status, assignedTo, unassigned, curAt, curID, limit+1)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []inboxConversation{}
+	for rows.Next() {
+		c, err := scanInboxConversation(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, c)
+	}
+	resp := map[string]any{"items": out, "next_cursor": nil}
+	if len(out) > limit {
+		out = out[:limit]
+		last := out[limit-1]
+		resp["items"], resp["next_cursor"] = out, encodeInboxCursor(last.sortAt, last.ID)
+	}
+	render.OK(w, resp)
+}
+
+// conversationForTenant carrega a conversa do tenant da requisição ou
+// responde 404.
+func (a *App) conversationForTenant(w http.ResponseWriter, r *http.Request) (inboxConversation, bool) {
+	t, _ := tenantFrom(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return inboxConversation{}, false
+	}
+	c, err := scanInboxConversation(a.DB.QueryRow(r.Context(), `
+SELECT `+inboxColumns+inboxFrom+` WHERE c.id=$1 AND c.org_id=$2 AND c.flow_id=$3`, id, t.OrgID, t.FlowID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "conversation not found")
+		return c, false
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return c, false
+	}
+	return c, true
+}
+
+// GET /api/conversations/{id}
+func (a *App) getConversation(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.conversationForTenant(w, r)
+	if !ok {
+		return
+	}
+	render.OK(w, c)
+}
+
+// PUT /api/conversations/{id}
+func (a *App) updateConversation(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.conversationForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if raw, ok := in["status"]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil || (s != "open" && s != "closed") {
+			render.Error(w, http.StatusBadRequest, "invalid status (use open or closed)")
+			return
+		}
+		c.Status = s
+	}
+	if raw, ok := in["assigned_to"]; ok {
+		// null desatribui
+		var uid *int64
+		if err := json.Unmarshal(raw, &uid); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid assigned_to")
+			return
+		}
+		if uid != nil {
+			var member bool
+			if err := a.DB.QueryRow(r.Context(),
+				`SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND org_id=$2)`, *uid, c.orgID).Scan(&member); err != nil {
+				render.Error(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !member {
+				render.Error(w, http.StatusBadRequest, "user not found")
+				return
+			}
+		}
+		c.AssignedTo = uid
+	}
+	if _, err := a.DB.Exec(r.Context(), `
+UPDATE public.conversations SET status=$2, assigned_to=$3, updated_at=NOW() WHERE id=$1`,
+		c.ID, c.Status, c.AssignedTo); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, c)
+}
+
+// GET /api/conversations/{id}/messages?before=&limit=
+//
+// Mensagens da mais recente para a mais antiga; "before" é o id da última
+// mensagem recebida na página anterior.
+func (a *App) listConversationMessages(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.conversationForTenant(w, r)
+	if !ok {
+		return
+	}
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	limit := inboxLimit(r)
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, COALESCE(direction,''), COALESCE(msg_type,'text'), COALESCE(body,''), COALESCE(message_id,''),
+       COALESCE(status,''), delivered_at, read_at, created_at
+  FROM public.wa_messages
+ WHERE conversation_id=$1 AND org_id=$2 AND ($3 = 0 OR id < $3)
+ ORDER BY created_at DESC, id DESC
+ LIMIT $4`, c.ID, c.orgID, before, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []inboxMessage{}
+	for rows.Next() {
+		var m inboxMessage
+		if err := rows.Scan(&m.ID, &m.Direction, &m.Type, &m.Body, &m.MessageID, &m.Status, &m.DeliveredAt, &m.ReadAt, &m.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, m)
+	}
+	if before == 0 && c.Unread > 0 {
+		if _, err := a.DB.Exec(r.Context(), `UPDATE public.conversations SET unread=0 WHERE id=$1`, c.ID); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	render.OK(w, map[string]any{"items": out, "has_more": len(out) == limit})
+}
+
+// POST /api/conversations/{id}/reply
+func (a *App) replyConversation(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.conversationForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Text = strings.TrimSpace(in.Text)
+	if in.Text == "" {
+		render.Error(w, http.StatusBadRequest, "text required")
+		return
+	}
+	if c.InstanceID == "" || c.Contact == "" {
+		render.Error(w, http.StatusUnprocessableEntity, "conversation has no whatsapp instance")
+		return
+	}
+	ctx := r.Context()
+	row, err := a.fetchWAInstance(ctx, c.InstanceID)
+	if err != nil || row.OrgID != c.orgID {
+		render.Error(w, http.StatusConflict, "conversation instance not available")
+		return
+	}
+	out, status, err := a.sendWAText(ctx, row, row.Token, c.Contact, in.Text)
+	if err != nil {
+		writeWASendError(w, err, status)
+		return
+	}
+	msg, err := a.recordInboxReply(ctx, c, row, in.Text, providerMessageID(out))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, msg)
+}
+
+// providerMessageID extrai o id da mensagem da resposta do envio (uazapi ou
+// Cloud API).
+func providerMessageID(out map[string]any) string {
+	for _, k := range []string{"id", "messageid", "messageId"} {
+		if v, ok := out[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// recordInboxReply grava a resposta na conversa. Com o id do provedor, o eco
+// que chega pelo webhook (fromMe) é descartado como duplicado.
+func (a *App) recordInboxReply(ctx context.Context, c inboxConversation, row waInstanceRow, text, messageID string) (inboxMessage, error) {
+	m := inboxMessage{Direction: "out", Type: "text", Body: text, MessageID: messageID}
+	err := a.DB.QueryRow(ctx, `
+INSERT INTO public.wa_messages (org_id, flow_id, instance_id, direction, to_number, conversation_id, lead_id,
+                                message_id, msg_type, body)
+VALUES ($1, $2, $3, 'out', $4, $5, $6, NULLIF($7,''), 'text', $8)
+ON CONFLICT (instance_id, message_id) WHERE message_id IS NOT NULL DO UPDATE
+   SET body = EXCLUDED.body, conversation_id = EXCLUDED.conversation_id,
+       lead_id = COALESCE(public.wa_messages.lead_id, EXCLUDED.lead_id)
+RETURNING id, COALESCE(status,''), created_at`,
+		c.orgID, c.flowID, row.InstanceID, c.Contact, c.ID, c.LeadID, messageID, text).Scan(&m.ID, &m.Status, &m.CreatedAt)
+	if err != nil {
+		return m, fmt.Errorf("record reply: %w", err)
+	}
+	_, err = a.DB.Exec(ctx, `
+UPDATE public.conversations
+   SET last_message=$2, last_message_at=GREATEST(last_message_at, $3), unread=0, updated_at=NOW()
+ WHERE id=$1`, c.ID, limitRunes(text, 500), m.CreatedAt)
+	return m, err
+}
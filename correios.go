@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================
+//  Rastreamento nos Correios (API SRO Rastro)
+// ================================================================
+//
+// Autenticação pelo Meu Correios: CORREIOS_USER + CORREIOS_ACCESS_CODE
+// (código de acesso às APIs) e, se houver contrato, CORREIOS_CARTAO (cartão de
+// postagem). O token dura ~24h e fica em cache até expirar.
+// CORREIOS_API_URL troca a base (padrão https://api.correios.com.br).
+
+var (
+	errCorreiosNotConfigured = errors.New("correios not configured (CORREIOS_USER/CORREIOS_ACCESS_CODE)")
+	errTrackingNotFound      = errors.New("tracking code not found")
+	trackingCodeRe           = regexp.MustCompile(`^[A-Z]{2}\d{9}[A-Z]{2}$`)
+)
+
+// trackingEvent é um evento de rastreio normalizado.
+type trackingEvent struct {
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	Location    string    `json:"location,omitempty"`
+	At          time.Time `json:"at"`
+	Delivered   bool      `json:"delivered"`
+}
+
+// normalizeTrackingCode valida o formato SRO (AA123456789BR).
+func normalizeTrackingCode(v string) (string, error) {
+	code := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(v), " ", ""))
+	if !trackingCodeRe.MatchString(code) {
+		return "", fmt.Errorf("invalid tracking code %q (expected AA123456789BR)", v)
+	}
+	return code, nil
+}
+
+var correiosAuth struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func correiosBase() string {
+	return strings.TrimRight(getenv("CORREIOS_API_URL", "https://api.correios.com.br"), "/")
+}
+
+// correiosToken devolve o token em cache ou autentica de novo.
+func correiosToken(ctx context.Context) (string, error) {
+	user, code := os.Getenv("CORREIOS_USER"), os.Getenv("CORREIOS_ACCESS_CODE")
+	if user == "" || code == "" {
+		return "", errCorreiosNotConfigured
+	}
+	correiosAuth.mu.Lock()
+	defer correiosAuth.mu.Unlock()
+	if correiosAuth.token != "" && time.Now().Add(5*time.Minute).Before(correiosAuth.expires) {
+		return correiosAuth.token, nil
+	}
+
+	path, body := "/token/v1/autentica", []byte(nil)
+	if cartao := os.Getenv("CORREIOS_CARTAO"); cartao != "" {
+		path = "/token/v1/autentica/cartaopostagem"
+		body, _ = json.Marshal(map[string]string{"numero": cartao})
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, correiosBase()+path, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(user, code)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("correios auth: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("correios auth: status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var out struct {
+		Token    string `json:"token"`
+		ExpiraEm string `json:"expiraEm"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("correios auth: %w", err)
+	}
+	exp, err := time.ParseInLocation("2006-01-02T15:04:05", out.ExpiraEm, brasiliaTZ())
+	if err != nil {
+		exp = time.Now().Add(12 * time.Hour)
+	}
+	correiosAuth.token, correiosAuth.expires = out.Token, exp
+	return out.Token, nil
+}
+
+// correiosTrack devolve os eventos do objeto, do mais antigo ao mais recente.
+func correiosTrack(ctx context.Context, code string) ([]trackingEvent, error) {
+	token, err := correiosToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		correiosBase()+"/srorastro/v1/objetos/"+code+"?resultado=T", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("correios: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		// token revogado antes do prazo: força nova autenticação na próxima
+		correiosAuth.mu.Lock()
+		correiosAuth.token = ""
+		correiosAuth.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("correios: status %d", resp.StatusCode)
+	}
+	var out struct {
+		Objetos []struct {
+			Mensagem string `json:"mensagem"`
+			Eventos  []struct {
+				Codigo     string `json:"codigo"`
+				Tipo       string `json:"tipo"`
+				DtHrCriado string `json:"dtHrCriado"`
+				Descricao  string `json:"descricao"`
+				Unidade    struct {
+					Endereco struct {
+						Cidade string `json:"cidade"`
+						UF     string `json:"uf"`
+					} `json:"endereco"`
+				} `json:"unidade"`
+			} `json:"eventos"`
+		} `json:"objetos"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("correios: %w", err)
+	}
+	if len(out.Objetos) == 0 || len(out.Objetos[0].Eventos) == 0 {
+		// objeto ainda não postado ou código inexistente (SRO-020)
+		return nil, errTrackingNotFound
+	}
+	evs := out.Objetos[0].Eventos
+	events := make([]trackingEvent, 0, len(evs))
+	// a API lista do mais recente para o mais antigo
+	for i := len(evs) - 1; i >= 0; i-- {
+		e := evs[i]
+		at, _ := time.ParseInLocation("2006-01-02T15:04:05", e.DtHrCriado, brasiliaTZ())
+		loc := e.Unidade.Endereco.Cidade
+		if e.Unidade.Endereco.UF != "" {
+			loc = strings.TrimSpace(loc + "/" + e.Unidade.Endereco.UF)
+		}
+		events = append(events, trackingEvent{
+			Code:        e.Codigo + "-" + e.Tipo,
+			Description: e.Descricao,
+			Location:    strings.Trim(loc, "/"),
+			At:          at,
+			// BDE/BDI/BDR tipo 01: entregue ao destinatário
+			Delivered: (e.Codigo == "BDE" || e.Codigo == "BDI" || e.Codigo == "BDR") && e.Tipo == "01",
+		})
+	}
+	return events, nil
+}
+
+func brasiliaTZ() *time.Location {
+	loc, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		return time.FixedZone("BRT", -3*3600)
+	}
+	return loc
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Exportação CSV de listas e análises (?format=csv)
+// ================================================================
+//
+// GET /api/leads/export, /api/orders/export e /api/products/export (ou as
+// listas com ?format=csv) percorrem todos os registros do tenant, sem o
+// LIMIT 500 da listagem, e escrevem o CSV enquanto leem do banco.
+//
+// As análises (/api/analytics/*?format=csv) passam por csvFromJSON, que
+// converte a resposta JSON já pronta: as linhas são o array "items" (ou o
+// indicado em ?table=, ex. by_day em appointments e recent_churned em
+// subscriptions); sem ele, o objeto vira uma linha só. Objetos aninhados
+// viram colunas "a.b"; arrays, JSON na célula.
+//
+// Encoding: UTF-8 com BOM (o Excel reconhece acentos); ?bom=false tira.
+// Separador: ?sep=; (Excel em pt-BR), ?sep=tab ou CSV_SEPARATOR (padrão ",").
+// Valores em centavos ganham uma coluna decimal ao lado (total = 12.34).
+
+const csvFlushEvery = 500
+
+func csvWanted(r *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("format")), "csv")
+}
+
+func csvSeparator(r *http.Request) rune {
+	switch v := nonEmpty(r.URL.Query().Get("sep"), getenv("CSV_SEPARATOR", ",")); strings.ToLower(v) {
+	case ";", "semicolon":
+		return ';'
+	case "tab", "\t":
+		return '\t'
+	case "|":
+		return '|'
+	}
+	return ','
+}
+
+// csvOut escreve o CSV direto na resposta, com flush a cada csvFlushEvery linhas.
+type csvOut struct {
+	w  http.ResponseWriter
+	cw *csv.Writer
+	n  int
+}
+
+// startCSV manda os headers HTTP e a linha de cabeçalho. name vira o nome do
+// arquivo (name_AAAAMMDD.csv).
+func startCSV(w http.ResponseWriter, r *http.Request, name string, header []string) *csvOut {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_%s.csv"`, name, time.Now().UTC().Format("20060102")))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if bom, err := strconv.ParseBool(r.URL.Query().Get("bom")); err != nil || bom {
+		_, _ = io.WriteString(w, "\ufeff")
+	}
+	c := &csvOut{w: w, cw: csv.NewWriter(w)}
+	c.cw.Comma = csvSeparator(r)
+	_ = c.cw.Write(header)
+	return c
+}
+
+func (c *csvOut) row(vals ...string) {
+	_ = c.cw.Write(vals)
+	if c.n++; c.n%csvFlushEvery == 0 {
+		c.cw.Flush()
+		_ = http.NewResponseController(c.w).Flush() // sem suporte, segue sem flush
+	}
+}
+
+// end fecha o arquivo. Os headers já foram, então um erro no meio do
+// caminho só pode ir para o log (o arquivo fica truncado).
+func (c *csvOut) end(what string, err error) {
+	c.cw.Flush()
+	if err == nil {
+		err = c.cw.Error()
+	}
+	if err != nil {
+		log.Printf("%s csv export: %v", what, err)
+	}
+}
+
+func csvCents(c int64) string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+func csvTime(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+
+func csvOptTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return csvTime(*t)
+}
+
+func csvInt(n int64) string { return strconv.FormatInt(n, 10) }
+
+// csvRows percorre rows chamando each; o primeiro erro encerra.
+func csvRows(rows pgx.Rows, each func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := each(); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GET /api/leads/export
+func (a *App) exportLeads(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, COALESCE(name,''), COALESCE(phone,''), COALESCE(email,''), COALESCE(stage,''), created_at
+  FROM leads WHERE org_id=$1 AND flow_id=$2 ORDER BY id`, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := startCSV(w, r, "leads", []string{"id", "name", "phone", "email", "stage", "created_at"})
+	var (
+		id                        int64
+		name, phone, email, stage string
+		created                   time.Time
+	)
+	out.end("leads", csvRows(rows, func() error {
+		if err := rows.Scan(&id, &name, &phone, &email, &stage, &created); err != nil {
+			return err
+		}
+		// PII cifrada na base (pii.go)
+		out.row(csvInt(id), revealPII(orgID, name), revealPII(orgID, phone), revealPII(orgID, email), stage, csvTime(created))
+		return nil
+	}))
+}
+
+// GET /api/orders/export
+func (a *App) exportOrders(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT o.id, COALESCE(o.lead_id, 0), COALESCE(l.name,''), COALESCE(o.status,''), o.total_cents,
+       COALESCE((SELECT SUM(oi.qty) FROM order_items oi WHERE oi.order_id = o.id), 0)::bigint, o.created_at
+  FROM orders o
+  LEFT JOIN leads l ON l.id = o.lead_id
+ WHERE o.org_id=$1 AND o.flow_id=$2
+ ORDER BY o.id`, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := startCSV(w, r, "orders", []string{"id", "lead_id", "lead_name", "status", "items", "total_cents", "total", "created_at"})
+	var (
+		id, leadID, total, items int64
+		leadName, status         string
+		created                  time.Time
+	)
+	out.end("orders", csvRows(rows, func() error {
+		if err := rows.Scan(&id, &leadID, &leadName, &status, &total, &items, &created); err != nil {
+			return err
+		}
+		out.row(csvInt(id), csvInt(leadID), revealPII(orgID, leadName), status, csvInt(items), csvInt(total), csvCents(total), csvTime(created))
+		return nil
+	}))
+}
+
+// GET /api/products/export
+func (a *App) exportProducts(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, title, COALESCE(slug,''), COALESCE(status,''), COALESCE(category,''), COALESCE(tags, '{}'),
+       price_cents, stock, stock_pool_id, COALESCE(recurrence,''), available_from, available_until, created_at
+  FROM products WHERE org_id=$1 AND flow_id=$2 AND `+productListFilter(r)+` ORDER BY id`, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := startCSV(w, r, "products", []string{"id", "title", "slug", "status", "category", "tags", "price_cents", "price",
+		"stock", "stock_pool_id", "recurrence", "available_from", "available_until", "created_at"})
+	var (
+		id, price, stock                 int64
+		title, slug, status, cat, recurr string
+		tags                             []string
+		poolID                           *int64
+		availFrom, availUntil            *time.Time
+		created                          time.Time
+	)
+	out.end("products", csvRows(rows, func() error {
+		if err := rows.Scan(&id, &title, &slug, &status, &cat, &tags, &price, &stock, &poolID, &recurr, &availFrom, &availUntil, &created); err != nil {
+			return err
+		}
+		pool := ""
+		if poolID != nil {
+			pool = csvInt(*poolID)
+		}
+		out.row(csvInt(id), title, slug, status, cat, strings.Join(tags, "|"), csvInt(price), csvCents(price),
+			csvInt(stock), pool, recurr, csvOptTime(availFrom), csvOptTime(availUntil), csvTime(created))
+		return nil
+	}))
+}
+
+// ----------------------------------------------------------------
+//  JSON -> CSV (análises)
+// ----------------------------------------------------------------
+
+// csvFromJSON converte para CSV a resposta JSON de sucesso quando
+// ?format=csv; erros seguem em JSON.
+func csvFromJSON(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !csvWanted(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &csvCapture{header: http.Header{}, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if rec.status != http.StatusOK {
+				for k, v := range rec.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(rec.status)
+				_, _ = w.Write(rec.body.Bytes())
+				return
+			}
+			dec := json.NewDecoder(&rec.body)
+			dec.UseNumber()
+			doc, err := decodeOrdered(dec)
+			if err != nil {
+				render.Error(w, http.StatusInternalServerError, "csv export: "+err.Error())
+				return
+			}
+			header, lines := csvTable(doc, nonEmpty(strings.TrimSpace(r.URL.Query().Get("table")), "items"))
+			out := startCSV(w, r, name, header)
+			for _, l := range lines {
+				out.row(l...)
+			}
+			out.end(name, nil)
+		})
+	}
+}
+
+// csvCapture guarda a resposta do handler para conversão.
+type csvCapture struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *csvCapture) Header() http.Header         { return c.header }
+func (c *csvCapture) Write(b []byte) (int, error) { return c.body.Write(b) }
+func (c *csvCapture) WriteHeader(status int)      { c.status = status }
+
+// orderedObject é um objeto JSON com as chaves na ordem em que vieram.
+type orderedObject struct {
+	keys []string
+	vals map[string]any
+}
+
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := orderedObject{vals: map[string]any{}}
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				k, _ := kt.(string)
+				v, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				if _, dup := obj.vals[k]; !dup {
+					obj.keys = append(obj.keys, k)
+				}
+				obj.vals[k] = v
+			}
+			_, err := dec.Token() // }
+			return obj, err
+		case '[':
+			var arr []any
+			for dec.More() {
+				v, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+			_, err := dec.Token() // ]
+			return arr, err
+		}
+	}
+	return tok, nil
+}
+
+// csvTable monta cabeçalho e linhas a partir do array table do documento
+// ou, sem ele, do próprio objeto numa linha só.
+func csvTable(doc any, table string) ([]string, [][]string) {
+	var records []any
+	if obj, ok := doc.(orderedObject); ok {
+		if arr, ok := obj.vals[table].([]any); ok {
+			records = arr
+		} else {
+			records = []any{obj}
+		}
+	} else if arr, ok := doc.([]any); ok {
+		records = arr
+	}
+	var header []string
+	seen := map[string]bool{}
+	flat := make([]map[string]string, 0, len(records))
+	for _, rec := range records {
+		m := map[string]string{}
+		var keys []string
+		flattenCSV("", rec, m, &keys)
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				header = append(header, k)
+			}
+		}
+		flat = append(flat, m)
+	}
+	lines := make([][]string, 0, len(flat))
+	for _, m := range flat {
+		l := make([]string, len(header))
+		for i, k := range header {
+			l[i] = m[k]
+		}
+		lines = append(lines, l)
+	}
+	return header, lines
+}
+
+func flattenCSV(prefix string, v any, out map[string]string, keys *[]string) {
+	set := func(s string) {
+		k := nonEmpty(prefix, "value")
+		if _, ok := out[k]; !ok {
+			*keys = append(*keys, k)
+		}
+		out[k] = s
+	}
+	switch t := v.(type) {
+	case orderedObject:
+		for _, k := range t.keys {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			flattenCSV(name, t.vals[k], out, keys)
+		}
+	case []any:
+		b, _ := json.Marshal(plainJSON(t))
+		set(string(b))
+	case nil:
+		set("")
+	case json.Number:
+		set(t.String())
+		if strings.HasSuffix(prefix, "_cents") {
+			if n, err := t.Int64(); err == nil {
+				k := strings.TrimSuffix(prefix, "_cents")
+				if _, ok := out[k]; !ok {
+					*keys = append(*keys, k)
+				}
+				out[k] = csvCents(n)
+			}
+		}
+	default:
+		set(fmt.Sprint(t))
+	}
+}
+
+// plainJSON devolve v sem orderedObject, para voltar a ser serializado.
+func plainJSON(v any) any {
+	switch t := v.(type) {
+	case orderedObject:
+		m := make(map[string]any, len(t.keys))
+		for _, k := range t.keys {
+			m[k] = plainJSON(t.vals[k])
+		}
+		return m
+	case []any:
+		out := make([]any, len(t))
+		for i, x := range t {
+			out[i] = plainJSON(x)
+		}
+		return out
+	}
+	return v
+}
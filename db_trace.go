@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Log de consultas lentas e contagem por fingerprint
+// ================================================================
+//
+// Tracer do pgx ligado ao pool em main.go. Cada consulta é reduzida a um
+// fingerprint: o SQL sem literais (números e strings viram ?), com espaços
+// colapsados, e um hash curto dele. Por fingerprint a réplica conta
+// chamadas, tempo total e máximo, lentas e erros.
+//
+//   DB_SLOW_QUERY_MS     acima disso a consulta vai para o log (padrão 500;
+//                        0 desliga o log, a contagem continua)
+//   DB_QUERY_STATS_MAX   fingerprints guardados (padrão 500); os demais
+//                        somam em "other"
+//
+// No log os argumentos nunca saem com valor, só tipo e tamanho
+// ($1=int64 $2=string(14)): telefones, e-mails e tokens passam por aqui.
+// Lentas contam em paclead_db_slow_queries_total{fingerprint}.
+//
+// GET    /api/admin/db/queries   ?sort=total|mean|max|slow|calls&limit=
+//                                 ranking das consultas (ADMIN_TOKEN, admin.go)
+// DELETE /api/admin/db/queries   zera a contagem
+
+type dbQueryStat struct {
+	Fingerprint string        `json:"fingerprint"`
+	SQL         string        `json:"sql"`
+	Calls       int64         `json:"calls"`
+	Errors      int64         `json:"errors"`
+	Slow        int64         `json:"slow"`
+	Rows        int64         `json:"rows"`
+	Total       time.Duration `json:"-"`
+	Max         time.Duration `json:"-"`
+	TotalMS     float64       `json:"total_ms"`
+	MeanMS      float64       `json:"mean_ms"`
+	MaxMS       float64       `json:"max_ms"`
+}
+
+var dbQueryStats = struct {
+	sync.Mutex
+	byFP  map[string]*dbQueryStat
+	since time.Time
+}{byFP: map[string]*dbQueryStat{}, since: time.Now()}
+
+var dbSlowQueries = newCounter("paclead_db_slow_queries_total",
+	"Queries above DB_SLOW_QUERY_MS by fingerprint.", "fingerprint")
+
+var (
+	sqlStringLitRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumberLitRe = regexp.MustCompile(`(^|[^$\w.])\d+(?:\.\d+)?\b`) // não pega $1
+	sqlInListRe    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)+\s*\)`)
+	sqlSpaceRe     = regexp.MustCompile(`\s+`)
+)
+
+// queryTracer implementa pgx.QueryTracer.
+type queryTracer struct {
+	slow     time.Duration
+	maxStats int
+}
+
+type queryTraceKey struct{}
+
+type queryTraceStart struct {
+	at   time.Time
+	sql  string
+	args []any
+}
+
+func newQueryTracer() *queryTracer {
+	ms, err := strconv.Atoi(getenv("DB_SLOW_QUERY_MS", "500"))
+	if err != nil || ms < 0 {
+		ms = 500
+	}
+	max, err := strconv.Atoi(getenv("DB_QUERY_STATS_MAX", "500"))
+	if err != nil || max < 1 {
+		max = 500
+	}
+	return &queryTracer{slow: time.Duration(ms) * time.Millisecond, maxStats: max}
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, queryTraceStart{at: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryTraceKey{}).(queryTraceStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	fp, norm := sqlFingerprint(start.sql)
+	slow := t.slow > 0 && elapsed >= t.slow
+	t.record(fp, norm, elapsed, data.CommandTag.RowsAffected(), data.Err != nil, slow)
+	if !slow {
+		return
+	}
+	dbSlowQueries.inc(fp)
+	status := "ok"
+	if data.Err != nil {
+		status = "error: " + data.Err.Error()
+	}
+	reqID := ""
+	if id := middleware.GetReqID(ctx); id != "" {
+		reqID = " req=" + id
+	}
+	log.Printf("slow query %s fp=%s rows=%d%s (%s) sql=%s args=[%s]",
+		elapsed.Round(time.Millisecond), fp, data.CommandTag.RowsAffected(), reqID, status,
+		limitRunes(norm, 1000), redactQueryArgs(start.args))
+}
+
+func (t *queryTracer) record(fp, norm string, elapsed time.Duration, rows int64, failed, slow bool) {
+	dbQueryStats.Lock()
+	defer dbQueryStats.Unlock()
+	s := dbQueryStats.byFP[fp]
+	if s == nil {
+		if len(dbQueryStats.byFP) >= t.maxStats {
+			fp, norm = "other", "(fingerprints beyond DB_QUERY_STATS_MAX)"
+			s = dbQueryStats.byFP[fp]
+		}
+		if s == nil {
+			s = &dbQueryStat{Fingerprint: fp, SQL: limitRunes(norm, 2000)}
+			dbQueryStats.byFP[fp] = s
+		}
+	}
+	s.Calls++
+	s.Rows += rows
+	s.Total += elapsed
+	if elapsed > s.Max {
+		s.Max = elapsed
+	}
+	if failed {
+		s.Errors++
+	}
+	if slow {
+		s.Slow++
+	}
+}
+
+// sqlFingerprint devolve o hash curto e o SQL normalizado.
+func sqlFingerprint(sql string) (string, string) {
+	norm := sqlStringLitRe.ReplaceAllString(sql, "?")
+	norm = sqlNumberLitRe.ReplaceAllString(norm, "${1}?")
+	norm = strings.TrimSpace(sqlSpaceRe.ReplaceAllString(norm, " "))
+	norm = sqlInListRe.ReplaceAllString(norm, "(?...)")
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(norm))
+	return fmt.Sprintf("%08x", h.Sum32()), norm
+}
+
+// redactQueryArgs descreve os argumentos sem os valores.
+func redactQueryArgs(args []any) string {
+	parts := make([]string, 0, len(args))
+	for i, v := range args {
+		var desc string
+		switch x := v.(type) {
+		case nil:
+			desc = "null"
+		case string:
+			desc = fmt.Sprintf("string(%d)", len(x))
+		case []byte:
+			desc = fmt.Sprintf("bytes(%d)", len(x))
+		case []string:
+			desc = fmt.Sprintf("[]string(%d)", len(x))
+		case []int64:
+			desc = fmt.Sprintf("[]int64(%d)", len(x))
+		default:
+			desc = fmt.Sprintf("%T", v)
+		}
+		parts = append(parts, fmt.Sprintf("$%d=%s", i+1, desc))
+	}
+	return strings.Join(parts, " ")
+}
+
+// dbQueryStatsSize entra em /api/admin/runtime (runtime_debug.go).
+func dbQueryStatsSize() int {
+	dbQueryStats.Lock()
+	defer dbQueryStats.Unlock()
+	return len(dbQueryStats.byFP)
+}
+
+// GET /api/admin/db/queries?sort=total|mean|max|slow|calls&limit=
+func (a *App) adminDBQueries(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	sortBy := nonEmpty(r.URL.Query().Get("sort"), "total")
+	key := map[string]func(s dbQueryStat) float64{
+		"total": func(s dbQueryStat) float64 { return s.TotalMS },
+		"mean":  func(s dbQueryStat) float64 { return s.MeanMS },
+		"max":   func(s dbQueryStat) float64 { return s.MaxMS },
+		"slow":  func(s dbQueryStat) float64 { return float64(s.Slow) },
+		"calls": func(s dbQueryStat) float64 { return float64(s.Calls) },
+	}[sortBy]
+	if key == nil {
+		render.Validation(w, []render.FieldError{{Field: "sort", Message: "must be one of total, mean, max, slow, calls"}})
+		return
+	}
+
+	dbQueryStats.Lock()
+	items := make([]dbQueryStat, 0, len(dbQueryStats.byFP))
+	for _, s := range dbQueryStats.byFP {
+		c := *s
+		c.TotalMS = float64(c.Total.Microseconds()) / 1000
+		c.MaxMS = float64(c.Max.Microseconds()) / 1000
+		if c.Calls > 0 {
+			c.MeanMS = c.TotalMS / float64(c.Calls)
+		}
+		items = append(items, c)
+	}
+	since := dbQueryStats.since
+	dbQueryStats.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return key(items[i]) > key(items[j]) })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	render.OK(w, map[string]any{"since": since, "items": items})
+}
+
+// DELETE /api/admin/db/queries
+func (a *App) adminResetDBQueries(w http.ResponseWriter, r *http.Request) {
+	dbQueryStats.Lock()
+	dbQueryStats.byFP = map[string]*dbQueryStat{}
+	dbQueryStats.since = time.Now()
+	dbQueryStats.Unlock()
+	render.NoContent(w)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// ================================================================
+//  Envio de e-mails transacionais
+// ================================================================
+//
+// EMAIL_PROVIDER seleciona o backend:
+//   smtp     -> SMTP_HOST, SMTP_PORT (587), SMTP_USER, SMTP_PASS
+//   sendgrid -> SENDGRID_API_KEY (API v3)
+//   log      -> apenas registra no log (padrão em desenvolvimento)
+// EMAIL_FROM define o remetente (padrão no-reply@localhost).
+
+type emailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type emailSender interface {
+	Send(ctx context.Context, m emailMessage) error
+}
+
+// emailSenderFromEnv monta o sender configurado. Sem EMAIL_PROVIDER, usa
+// SendGrid/SMTP se as credenciais existirem; caso contrário, log.
+func emailSenderFromEnv() emailSender {
+	from := getenv("EMAIL_FROM", "no-reply@localhost")
+	provider := strings.ToLower(strings.TrimSpace(getenv("EMAIL_PROVIDER", "")))
+	if provider == "" {
+		switch {
+		case getenv("SENDGRID_API_KEY", "") != "":
+			provider = "sendgrid"
+		case getenv("SMTP_HOST", "") != "":
+			provider = "smtp"
+		default:
+			provider = "log"
+		}
+	}
+	switch provider {
+	case "smtp":
+		return smtpSender{
+			addr: net.JoinHostPort(getenv("SMTP_HOST", "localhost"), getenv("SMTP_PORT", "587")),
+			host: getenv("SMTP_HOST", "localhost"),
+			user: getenv("SMTP_USER", ""),
+			pass: getenv("SMTP_PASS", ""),
+			from: from,
+		}
+	case "sendgrid":
+		return sendgridSender{apiKey: getenv("SENDGRID_API_KEY", ""), from: from}
+	default:
+		return logSender{}
+	}
+}
+
+type logSender struct{}
+
+func (logSender) Send(_ context.Context, m emailMessage) error {
+	log.Printf("email (log) to=%s subject=%q\n%s", m.To, m.Subject, m.Text)
+	return nil
+}
+
+type smtpSender struct {
+	addr, host, user, pass, from string
+}
+
+func (s smtpSender) Send(_ context.Context, m emailMessage) error {
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.pass, s.host)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", s.from, m.To, m.Subject)
+	if m.HTML != "" {
+		b.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+		b.WriteString(m.HTML)
+	} else {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(m.Text)
+	}
+	return smtp.SendMail(s.addr, auth, s.from, []string{m.To}, b.Bytes())
+}
+
+type sendgridSender struct {
+	apiKey, from string
+}
+
+func (s sendgridSender) Send(ctx context.Context, m emailMessage) error {
+	content := []map[string]string{{"type": "text/plain", "value": m.Text}}
+	if m.HTML != "" {
+		content = append(content, map[string]string{"type": "text/html", "value": m.HTML})
+	}
+	body, _ := json.Marshal(map[string]any{
+		"personalizations": []map[string]any{{"to": []map[string]string{{"email": m.To}}}},
+		"from":             map[string]string{"email": s.from},
+		"subject":          m.Subject,
+		"content":          content,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sendgrid %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Verificação de e-mail
+// ================================================================
+//
+// Todo usuário novo (registro ou convite em /api/org/users) recebe um link
+// assinado: PUBLIC_API_URL/api/auth/verify?token=<user>.<expira>.<hmac>.
+// O HMAC (EMAIL_VERIFY_SECRET, senão JWT_SECRET) cobre também o e-mail, então
+// trocar o e-mail invalida o link. Validade: EMAIL_VERIFY_TTL (padrão 72h).
+//
+// GET  /api/auth/verify?token=        grava users.email_verified_at; com
+//                                     EMAIL_VERIFY_REDIRECT_URL redireciona
+//                                     para ela com ?verified=1 (ou =0)
+// POST /api/auth/resend-verification  {email}; sempre 202, no máximo um
+//                                     e-mail por minuto
+//
+// EMAIL_VERIFY_REQUIRED (lista, vazio por padrão) liga as travas:
+//   login     -> login de e-mail não verificado dá 403; o registro não
+//                devolve token
+//   whatsapp  -> só usuário verificado cria instância de WhatsApp
+// Usuários que já existiam na migração 0032 contam como verificados.
+
+const emailVerifyPurpose = "email-verify"
+
+func emailVerifyTTL() time.Duration {
+	if d, err := time.ParseDuration(getenv("EMAIL_VERIFY_TTL", "72h")); err == nil && d > 0 {
+		return d
+	}
+	return 72 * time.Hour
+}
+
+// emailVerifyRequired diz se a trava ("login", "whatsapp") está ligada.
+func emailVerifyRequired(gate string) bool {
+	for _, g := range strings.Split(getenv("EMAIL_VERIFY_REQUIRED", ""), ",") {
+		if strings.EqualFold(strings.TrimSpace(g), gate) {
+			return true
+		}
+	}
+	return false
+}
+
+func emailVerifySignature(userID, exp int64, email string) string {
+	key := nonEmpty(os.Getenv("EMAIL_VERIFY_SECRET"), nonEmpty(os.Getenv("JWT_SECRET"), "secret"))
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s:%d:%d:%s", emailVerifyPurpose, userID, exp, strings.ToLower(email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func emailVerifyToken(userID int64, email string, now time.Time) string {
+	exp := now.Add(emailVerifyTTL()).Unix()
+	return fmt.Sprintf("%d.%d.%s", userID, exp, emailVerifySignature(userID, exp, email))
+}
+
+var errVerifyToken = errors.New("invalid or expired verification link")
+
+// parseEmailVerifyToken separa usuário e validade; a assinatura é conferida
+// depois, com o e-mail atual do usuário.
+func parseEmailVerifyToken(token string) (userID, exp int64, sig string, err error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return 0, 0, "", errVerifyToken
+	}
+	userID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	exp, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil || userID <= 0 || time.Now().Unix() > exp {
+		return 0, 0, "", errVerifyToken
+	}
+	return userID, exp, parts[2], nil
+}
+
+// sendVerificationEmail manda o link; roda fora da requisição.
+func (a *App) sendVerificationEmail(userID int64, email, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	base := strings.TrimRight(getenv("PUBLIC_API_URL", "http://localhost:8080"), "/")
+	link := base + "/api/auth/verify?token=" + url.QueryEscape(emailVerifyToken(userID, email, time.Now()))
+	msg := emailMessage{
+		To:      email,
+		Subject: "Confirme seu e-mail",
+		Text: fmt.Sprintf("Olá %s,\n\nConfirme seu e-mail acessando o link abaixo (válido por %s):\n\n%s\n\nSe você não criou esta conta, ignore este e-mail.\n",
+			nonEmpty(name, email), emailVerifyTTL(), link),
+	}
+	if err := emailSenderFromEnv().Send(ctx, msg); err != nil {
+		log.Printf("verification email to %s: %v", email, err)
+		return
+	}
+	if _, err := a.DB.Exec(ctx, `UPDATE users SET email_verification_sent_at=NOW() WHERE id=$1`, userID); err != nil {
+		log.Printf("verification email user=%d: %v", userID, err)
+	}
+}
+
+// GET /auth/verify?token=
+func (a *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	err := a.applyEmailVerification(r.Context(), r.URL.Query().Get("token"))
+	if redirect := getenv("EMAIL_VERIFY_REDIRECT_URL", ""); redirect != "" {
+		sep := "?"
+		if strings.Contains(redirect, "?") {
+			sep = "&"
+		}
+		ok := "1"
+		if err != nil {
+			ok = "0"
+		}
+		http.Redirect(w, r, redirect+sep+"verified="+ok, http.StatusFound)
+		return
+	}
+	if errors.Is(err, errVerifyToken) {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"verified": true})
+}
+
+func (a *App) applyEmailVerification(ctx context.Context, token string) error {
+	userID, exp, sig, err := parseEmailVerifyToken(token)
+	if err != nil {
+		return err
+	}
+	var email string
+	err = a.DB.QueryRow(ctx, `SELECT email FROM users WHERE id=$1`, userID).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errVerifyToken
+	}
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(emailVerifySignature(userID, exp, email))) {
+		return errVerifyToken
+	}
+	_, err = a.DB.Exec(ctx, `
+UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id=$1`, userID)
+	return err
+}
+
+// POST /auth/resend-verification
+func (a *App) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Email = strings.TrimSpace(strings.ToLower(in.Email))
+	if in.Email == "" {
+		render.Error(w, http.StatusBadRequest, "email required")
+		return
+	}
+	var (
+		userID int64
+		name   string
+	)
+	// só não verificados e fora da janela de 1 min; a resposta é a mesma
+	err := a.DB.QueryRow(r.Context(), `
+UPDATE users SET email_verification_sent_at = NOW()
+ WHERE LOWER(email)=LOWER($1) AND email_verified_at IS NULL
+   AND (email_verification_sent_at IS NULL OR email_verification_sent_at < NOW() - INTERVAL '1 minute')
+RETURNING id, name`, in.Email).Scan(&userID, &name)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err == nil {
+		go a.sendVerificationEmail(userID, in.Email, name)
+	}
+	render.Accepted(w, map[string]any{"ok": true})
+}
+
+// requireVerifiedEmail barra a rota (ex.: criar instância de WhatsApp) para
+// usuário não verificado quando a trava "whatsapp" está ligada. Vem depois de
+// requireRole, que já deixou as claims no contexto.
+func (a *App) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !emailVerifyRequired("whatsapp") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, _ := claimsFromContext(r.Context())
+		var verified bool
+		err := a.DB.QueryRow(r.Context(),
+			`SELECT email_verified_at IS NOT NULL FROM users WHERE id=$1 AND org_id=$2`, c.UserID, c.OrgID).Scan(&verified)
+		if errors.Is(err, pgx.ErrNoRows) {
+			render.Error(w, http.StatusUnauthorized, "user not found")
+			return
+		}
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !verified {
+			render.Error(w, http.StatusForbidden, "email not verified")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Relato de erros (Sentry ou compatível: GlitchTip, Bugsink...)
+// ================================================================
+//
+// SENTRY_DSN liga o envio; sem ele nada sai do processo. Vão para o
+// servidor, pela API store do Sentry, sem SDK:
+//
+//   - panics em handlers (errorReportingMiddleware, que também responde o
+//     500 no envelope de erro), com a pilha;
+//   - toda resposta 5xx, com a mensagem do envelope de erro (render.Error);
+//   - falhas de chamada ao LLM (meteredLLM) e ao provedor de WhatsApp
+//     (erro de transporte ou 5xx, via waprovider.Observe).
+//
+// Cada evento leva kind (panic | http | llm | uazapi), rota do chi, método,
+// status, request id e org/flow quando resolvidos. Query string, corpo e
+// cabeçalhos de autenticação nunca são enviados.
+//
+// SENTRY_ENVIRONMENT (padrão "production") e APP_VERSION/GIT_SHA (release)
+// identificam o deploy. O envio é assíncrono, com fila de 256 eventos; cheia,
+// o evento é descartado e logado. O mesmo erro (kind + mensagem) é enviado
+// no máximo uma vez por SENTRY_DEDUP_WINDOW (padrão 1m).
+//
+// reportServerError é o helper para handlers que têm o erro original em mãos:
+// relata com o tipo do erro e responde pelo envelope, sem relato duplicado
+// pelo middleware.
+
+type errorEvent struct {
+	Kind    string
+	Message string
+	Type    string
+	Stack   string
+	Tags    map[string]string
+	Request *http.Request
+}
+
+type sentryDSN struct {
+	storeURL, key string
+}
+
+// parseSentryDSN: https://<key>@<host>/<project> -> https://<host>/api/<project>/store/
+func parseSentryDSN(raw string) (sentryDSN, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.User == nil || u.Host == "" {
+		return sentryDSN{}, errors.New("invalid SENTRY_DSN")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" {
+		return sentryDSN{}, errors.New("invalid SENTRY_DSN: missing project id")
+	}
+	return sentryDSN{
+		storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:      u.User.Username(),
+	}, nil
+}
+
+type errorReporter struct {
+	dsn    sentryDSN
+	env    string
+	queue  chan errorEvent
+	client *http.Client
+	dedup  time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+var (
+	reporterOnce sync.Once
+	reporter     *errorReporter
+)
+
+// errorReporterFromEnv devolve o reporter do processo; nil sem SENTRY_DSN.
+func errorReporterFromEnv() *errorReporter {
+	reporterOnce.Do(func() {
+		raw := os.Getenv("SENTRY_DSN")
+		if strings.TrimSpace(raw) == "" {
+			return
+		}
+		dsn, err := parseSentryDSN(raw)
+		if err != nil {
+			log.Printf("error reporting disabled: %v", err)
+			return
+		}
+		dedup, err := time.ParseDuration(getenv("SENTRY_DEDUP_WINDOW", "1m"))
+		if err != nil || dedup < 0 {
+			dedup = time.Minute
+		}
+		reporter = &errorReporter{
+			dsn:    dsn,
+			env:    getenv("SENTRY_ENVIRONMENT", "production"),
+			queue:  make(chan errorEvent, 256),
+			client: &http.Client{Timeout: 10 * time.Second},
+			dedup:  dedup,
+			seen:   map[string]time.Time{},
+		}
+		go reporter.run()
+	})
+	return reporter
+}
+
+// reportError relata um erro fora de uma resposta HTTP (LLM, provedor).
+func reportError(ctx context.Context, kind string, err error, tags map[string]string) {
+	rep := errorReporterFromEnv()
+	if rep == nil || err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	if org := metricsOrg(ctx); org != "" && tags["org"] == "" {
+		tags["org"] = org
+	}
+	rep.enqueue(errorEvent{Kind: kind, Message: err.Error(), Type: fmt.Sprintf("%T", err), Tags: tags})
+}
+
+// reportServerError relata err e responde status com o envelope de erro.
+func reportServerError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if rep := errorReporterFromEnv(); rep != nil {
+		markErrorReported(r.Context())
+		rep.enqueue(errorEvent{Kind: "http", Message: err.Error(), Type: fmt.Sprintf("%T", err),
+			Tags: requestErrorTags(r, status), Request: r})
+	}
+	render.Error(w, status, err.Error())
+}
+
+func (rep *errorReporter) enqueue(ev errorEvent) {
+	key := ev.Kind + "\xff" + ev.Message
+	now := time.Now()
+	rep.mu.Lock()
+	if last, ok := rep.seen[key]; ok && now.Sub(last) < rep.dedup {
+		rep.mu.Unlock()
+		return
+	}
+	rep.seen[key] = now
+	if len(rep.seen) > 1000 {
+		for k, t := range rep.seen {
+			if now.Sub(t) >= rep.dedup {
+				delete(rep.seen, k)
+			}
+		}
+	}
+	rep.mu.Unlock()
+
+	select {
+	case rep.queue <- rep.withRequest(ev):
+	default:
+		log.Printf("error reporting: queue full, dropping %s event", ev.Kind)
+	}
+}
+
+// withRequest copia o que interessa da requisição antes de ela terminar.
+func (rep *errorReporter) withRequest(ev errorEvent) errorEvent {
+	if ev.Request == nil {
+		return ev
+	}
+	r := ev.Request
+	ev.Tags["method"] = r.Method
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		ev.Tags["request_id"] = id
+	}
+	u := *r.URL
+	u.RawQuery, u.Fragment = "", ""
+	ev.Request = &http.Request{Method: r.Method, URL: &u, Host: r.Host, Header: http.Header{}}
+	for _, h := range []string{"User-Agent", "Referer", "X-Request-Id"} {
+		if v := r.Header.Get(h); v != "" {
+			ev.Request.Header.Set(h, v)
+		}
+	}
+	return ev
+}
+
+func (rep *errorReporter) run() {
+	for ev := range rep.queue {
+		if err := rep.send(ev); err != nil {
+			log.Printf("error reporting: %v", err)
+		}
+	}
+}
+
+func (rep *errorReporter) send(ev errorEvent) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	level := "error"
+	if ev.Kind == "panic" {
+		level = "fatal"
+	}
+	tags := map[string]string{"kind": ev.Kind}
+	for k, v := range ev.Tags {
+		tags[k] = v
+	}
+	host, _ := os.Hostname()
+	payload := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      ev.Kind,
+		"server_name": host,
+		"environment": rep.env,
+		"release":     appVersion(),
+		"tags":        tags,
+		"exception": map[string]any{"values": []map[string]any{{
+			"type":  firstNonEmpty(ev.Type, ev.Kind),
+			"value": ev.Message,
+		}}},
+	}
+	if route := tags["route"]; route != "" {
+		payload["transaction"] = tags["method"] + " " + route
+	}
+	if ev.Stack != "" {
+		payload["extra"] = map[string]any{"stack": ev.Stack}
+	}
+	if r := ev.Request; r != nil {
+		headers := map[string]string{}
+		for k := range r.Header {
+			headers[k] = r.Header.Get(k)
+		}
+		payload["request"] = map[string]any{
+			"method":  r.Method,
+			"url":     "https://" + r.Host + r.URL.Path,
+			"headers": headers,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, rep.dsn.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=paclead/%s, sentry_key=%s", appVersion(), rep.dsn.key))
+	resp, err := rep.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sentry: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ---------------- middleware ----------------
+
+// errorReportReq marca a requisição já relatada por reportServerError.
+type errorReportReq struct{ reported bool }
+
+type errorReportCtxKey struct{}
+
+func markErrorReported(ctx context.Context) {
+	if m, ok := ctx.Value(errorReportCtxKey{}).(*errorReportReq); ok {
+		m.reported = true
+	}
+}
+
+func requestErrorTags(r *http.Request, status int) map[string]string {
+	tags := map[string]string{"status": strconv.Itoa(status)}
+	if rc := chi.RouteContext(r.Context()); rc != nil && rc.RoutePattern() != "" {
+		tags["route"] = rc.RoutePattern()
+	}
+	if t, ok := tenantFrom(r.Context()); ok {
+		if t.OrgID > 0 {
+			tags["org"] = strconv.FormatInt(t.OrgID, 10)
+		}
+		if t.FlowID > 0 {
+			tags["flow"] = strconv.FormatInt(t.FlowID, 10)
+		}
+	}
+	if tags["org"] == "" {
+		if org := metricsOrg(r.Context()); org != "" {
+			tags["org"] = org
+		}
+	}
+	return tags
+}
+
+// errorBodyCapture recebe o Tee do WrapResponseWriter e guarda só o começo
+// do corpo das respostas 5xx. O handler continua com o writer do chi, que
+// mantém Flush (SSE, CSV) e Hijack (WebSocket).
+type errorBodyCapture struct {
+	ww  middleware.WrapResponseWriter
+	buf bytes.Buffer
+}
+
+func (c *errorBodyCapture) Write(b []byte) (int, error) {
+	if c.ww.Status() >= 500 && c.buf.Len() < 2048 {
+		c.buf.Write(b[:min(len(b), 2048-c.buf.Len())])
+	}
+	return len(b), nil
+}
+
+// errorReportingMiddleware responde panics com o envelope de erro (500) e
+// relata panics e respostas 5xx. Sem SENTRY_DSN só loga o panic.
+func errorReportingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := errorReporterFromEnv()
+		defer func() {
+			rv := recover()
+			if rv == nil {
+				return
+			}
+			if rv == http.ErrAbortHandler {
+				panic(rv) // conexão abortada de propósito: o servidor trata
+			}
+			stack := string(debug.Stack())
+			log.Printf("panic: %v\n%s", rv, stack)
+			if rep != nil {
+				rep.enqueue(errorEvent{Kind: "panic", Message: fmt.Sprint(rv), Type: fmt.Sprintf("%T", rv),
+					Stack: stack, Tags: requestErrorTags(r, http.StatusInternalServerError), Request: r})
+			}
+			if r.Header.Get("Connection") != "Upgrade" {
+				render.Error(w, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+		if rep == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mark := &errorReportReq{}
+		r = r.WithContext(context.WithValue(r.Context(), errorReportCtxKey{}, mark))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		capture := &errorBodyCapture{ww: ww}
+		ww.Tee(capture)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status < 500 || mark.reported {
+			return
+		}
+		msg := http.StatusText(status)
+		var body render.ErrorBody
+		if json.Unmarshal(capture.buf.Bytes(), &body) == nil && body.Error.Message != "" {
+			msg = body.Error.Message
+		}
+		rep.enqueue(errorEvent{Kind: "http", Message: msg, Tags: requestErrorTags(r, status), Request: r})
+	})
+}
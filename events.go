@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ================================================================
+//  Barramento de eventos internos
+// ================================================================
+//
+// publishEvent é o ponto único de emissão de eventos de domínio
+// (lead.created, lead.stage_changed, order.paid...): grava as entregas das
+// assinaturas de webhook (webhooks_out.go) e chama os handlers internos
+// registrados com onEvent. Cada handler roda em goroutine própria, com o
+// contexto desacoplado da requisição e um limite de tempo.
+
+type eventHandler func(ctx context.Context, orgID int64, data any)
+
+var eventHandlers = map[string][]eventHandler{}
+
+// onEvent registra um handler; chamado pelas funções mount*, antes de o
+// servidor aceitar requisições.
+func onEvent(event string, h eventHandler) {
+	eventHandlers[event] = append(eventHandlers[event], h)
+}
+
+const eventHandlerTimeout = 30 * time.Second
+
+func (a *App) publishEvent(ctx context.Context, orgID int64, event string, data any) {
+	a.emitWebhookEvent(ctx, orgID, event, data)
+	for _, h := range eventHandlers[event] {
+		go func(h eventHandler) {
+			hctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), eventHandlerTimeout)
+			defer cancel()
+			defer func() {
+				if rec := recover(); rec != nil {
+					log.Printf("event %s org=%d: handler panic: %v", event, orgID, rec)
+				}
+			}()
+			h(hctx, orgID, data)
+		}(h)
+	}
+}
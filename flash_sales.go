@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Campanha relâmpago: oferta com prazo e quantidade limitada
+// ================================================================
+//
+// GET  /api/campaigns/flash-sales
+// POST /api/campaigns/flash-sales
+//      {"product_id","price_cents","quantity","starts_at","ends_at","message",
+//       "last_units_at","lead_ids"|"stage"|"source","limit","interval_seconds"}
+// GET  /api/campaigns/flash-sales/{id}
+// POST /api/campaigns/flash-sales/{id}/cancel
+//
+// Enquanto a oferta roda (starts_at <= agora < ends_at) o produto sai pelo
+// price_cents dela no agente, no pedido do chat e em POST /api/orders (sem
+// unit_price_cents explícito), até vender quantity unidades. O limite é reservado na transação do pedido (flashSaleReserve):
+// pedido acima do que resta é recusado e, esgotada, a oferta vira sold_out e
+// o produto volta ao preço normal. Pedido cancelado não devolve unidades.
+//
+// O anúncio vai por job flash_sale.send para o segmento escolhido (mesmos
+// filtros de /campaigns/personalize), com contagem regressiva e unidades
+// restantes calculadas na hora do envio. Quando restam last_units_at
+// unidades ou menos (padrão 3), quem recebeu o anúncio e respondeu depois
+// dele, sem ter comprado o produto, recebe uma vez o aviso de últimas
+// unidades.
+
+const (
+	jobFlashSaleSend = "flash_sale.send"
+
+	flashSaleAnnounce  = "announce"
+	flashSaleLastUnits = "last_units"
+)
+
+// flashSaleRunningSQL filtra as ofertas em andamento (colunas sem alias).
+const flashSaleRunningSQL = `status='active' AND starts_at <= NOW() AND ends_at > NOW()`
+
+func (a *App) mountFlashSales(r chi.Router) {
+	registerJob(jobFlashSaleSend, jobPolicy{MaxAttempts: 4, Timeout: time.Minute}, a.runFlashSaleSend)
+	r.Get("/campaigns/flash-sales", a.listFlashSales)
+	r.Post("/campaigns/flash-sales", a.createFlashSale)
+	r.Get("/campaigns/flash-sales/{id}", a.getFlashSale)
+	r.Post("/campaigns/flash-sales/{id}/cancel", a.cancelFlashSale)
+}
+
+type flashSale struct {
+	ID                int64      `json:"id"`
+	OrgID             int64      `json:"org_id"`
+	FlowID            int64      `json:"flow_id"`
+	ProductID         int64      `json:"product_id"`
+	ProductTitle      string     `json:"product_title"`
+	PriceCents        int        `json:"price_cents"`
+	RegularPriceCents int        `json:"regular_price_cents"`
+	Quantity          int        `json:"quantity"`
+	Sold              int        `json:"sold"`
+	Remaining         int        `json:"remaining"`
+	StartsAt          time.Time  `json:"starts_at"`
+	EndsAt            time.Time  `json:"ends_at"`
+	Message           string     `json:"message,omitempty"`
+	LastUnitsAt       int        `json:"last_units_at"`
+	LastUnitsSentAt   *time.Time `json:"last_units_sent_at,omitempty"`
+	Status            string     `json:"status"` // active | sold_out | canceled
+	Running           bool       `json:"running"`
+	Audience          int        `json:"audience"`
+	Announced         int        `json:"announced"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+const flashSaleCols = `
+SELECT s.id, s.org_id, s.flow_id, s.product_id, p.title, s.price_cents, p.price_cents,
+       s.quantity, s.sold, s.starts_at, s.ends_at, COALESCE(s.message,''), s.last_units_at,
+       s.last_units_sent_at, s.status, s.created_at,
+       (SELECT COUNT(*) FROM flash_sale_leads l WHERE l.flash_sale_id = s.id)::int,
+       (SELECT COUNT(*) FROM flash_sale_leads l WHERE l.flash_sale_id = s.id AND l.announced_at IS NOT NULL)::int
+  FROM flash_sales s JOIN products p ON p.id = s.product_id`
+
+func scanFlashSale(row pgx.Row) (flashSale, error) {
+	var s flashSale
+	err := row.Scan(&s.ID, &s.OrgID, &s.FlowID, &s.ProductID, &s.ProductTitle, &s.PriceCents, &s.RegularPriceCents,
+		&s.Quantity, &s.Sold, &s.StartsAt, &s.EndsAt, &s.Message, &s.LastUnitsAt,
+		&s.LastUnitsSentAt, &s.Status, &s.CreatedAt, &s.Audience, &s.Announced)
+	s.Remaining = max(s.Quantity-s.Sold, 0)
+	now := time.Now()
+	s.Running = s.Status == "active" && !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+	return s, err
+}
+
+// GET /api/campaigns/flash-sales
+func (a *App) listFlashSales(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), flashSaleCols+`
+ WHERE s.org_id=$1 AND s.flow_id=$2
+ ORDER BY s.starts_at DESC, s.id DESC LIMIT 200`, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []flashSale{}
+	for rows.Next() {
+		s, err := scanFlashSale(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, s)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// GET /api/campaigns/flash-sales/{id}
+func (a *App) getFlashSale(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	s, err := scanFlashSale(a.DB.QueryRow(r.Context(), flashSaleCols+`
+ WHERE s.id=$1 AND s.org_id=$2 AND s.flow_id=$3`, id, orgID, flowID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "flash sale not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// POST /api/campaigns/flash-sales
+func (a *App) createFlashSale(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		ProductID       int64      `json:"product_id"`
+		PriceCents      int        `json:"price_cents"`
+		Quantity        int        `json:"quantity"`
+		StartsAt        *time.Time `json:"starts_at,omitempty"`
+		EndsAt          time.Time  `json:"ends_at"`
+		Message         string     `json:"message,omitempty"`
+		LastUnitsAt     *int       `json:"last_units_at,omitempty"`
+		LeadIDs         []int64    `json:"lead_ids,omitempty"`
+		Stage           string     `json:"stage,omitempty"`
+		Source          string     `json:"source,omitempty"`
+		Limit           int        `json:"limit,omitempty"`
+		IntervalSeconds *int       `json:"interval_seconds,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	now := time.Now()
+	starts := now
+	if in.StartsAt != nil && in.StartsAt.After(now) {
+		starts = *in.StartsAt
+	}
+	switch {
+	case in.ProductID <= 0:
+		render.Error(w, http.StatusBadRequest, "product_id required")
+		return
+	case in.PriceCents <= 0:
+		render.Error(w, http.StatusBadRequest, "price_cents must be positive")
+		return
+	case in.Quantity <= 0:
+		render.Error(w, http.StatusBadRequest, "quantity must be positive")
+		return
+	case !in.EndsAt.After(starts):
+		render.Error(w, http.StatusBadRequest, "ends_at must be after starts_at and in the future")
+		return
+	}
+	lastUnits := min(3, in.Quantity)
+	if in.LastUnitsAt != nil {
+		if *in.LastUnitsAt < 0 || *in.LastUnitsAt > in.Quantity {
+			render.Error(w, http.StatusBadRequest, "last_units_at must be between 0 and quantity")
+			return
+		}
+		lastUnits = *in.LastUnitsAt
+	}
+	interval := 3 * time.Second
+	if in.IntervalSeconds != nil {
+		if *in.IntervalSeconds < 0 || *in.IntervalSeconds > 3600 {
+			render.Error(w, http.StatusBadRequest, "interval_seconds must be between 0 and 3600")
+			return
+		}
+		interval = time.Duration(*in.IntervalSeconds) * time.Second
+	}
+	if in.Limit <= 0 || in.Limit > campaignMaxLeads {
+		in.Limit = campaignMaxLeads
+	}
+
+	ctx := r.Context()
+	var regular int
+	err = a.DB.QueryRow(ctx, `SELECT price_cents FROM products WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND `+productListedSQL,
+		in.ProductID, orgID, flowID).Scan(&regular)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "product not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	leads, err := a.campaignSegment(ctx, orgID, flowID, campaignPersonalizeReq{
+		LeadIDs: in.LeadIDs, Stage: in.Stage, Source: in.Source, Limit: in.Limit,
+	})
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	// uma oferta por produto de cada vez: o preço do pedido não pode ser ambíguo
+	var overlap bool
+	if err := tx.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM flash_sales
+                WHERE product_id=$1 AND status='active' AND starts_at < $3 AND ends_at > $2)`,
+		in.ProductID, starts, in.EndsAt).Scan(&overlap); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if overlap {
+		render.Error(w, http.StatusConflict, "product already has a flash sale in this period")
+		return
+	}
+	var id int64
+	err = tx.QueryRow(ctx, `
+INSERT INTO flash_sales (org_id, flow_id, product_id, price_cents, quantity, starts_at, ends_at, message, last_units_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8,''), $9) RETURNING id`,
+		orgID, flowID, in.ProductID, in.PriceCents, in.Quantity, starts, in.EndsAt,
+		strings.TrimSpace(in.Message), lastUnits).Scan(&id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, l := range leads {
+		if _, err := tx.Exec(ctx, `
+INSERT INTO flash_sale_leads (flash_sale_id, lead_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, l.ID); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i, l := range leads {
+		if _, err := a.enqueueJob(ctx, orgID, jobFlashSaleSend,
+			flashSaleSendJob{SaleID: id, LeadID: l.ID, Kind: flashSaleAnnounce},
+			starts.Add(time.Duration(i)*interval)); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	s, err := scanFlashSale(a.DB.QueryRow(ctx, flashSaleCols+` WHERE s.id=$1`, id))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, s)
+}
+
+// POST /api/campaigns/flash-sales/{id}/cancel
+func (a *App) cancelFlashSale(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	tag, err := a.DB.Exec(r.Context(), `
+UPDATE flash_sales SET status='canceled'
+ WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND status='active'`, id, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "active flash sale not found")
+		return
+	}
+	// anúncios ainda na fila saem sem enviar (runFlashSaleSend)
+	render.NoContent(w)
+}
+
+// flashSaleReserve reserva qty unidades da oferta em andamento do produto, na
+// transação do pedido. saleID=0 quando não há oferta; o preço vale só com
+// saleID > 0. left é o que restava quando o pedido passa do limite.
+func flashSaleReserve(ctx context.Context, tx pgx.Tx, orgID, productID int64, qty int) (saleID int64, price, left int, err error) {
+	err = tx.QueryRow(ctx, `
+SELECT id, price_cents, quantity - sold FROM flash_sales
+ WHERE org_id=$1 AND product_id=$2 AND `+flashSaleRunningSQL+`
+ FOR UPDATE`, orgID, productID).Scan(&saleID, &price, &left)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if qty > left {
+		return saleID, 0, left, errFlashSaleCap
+	}
+	_, err = tx.Exec(ctx, `
+UPDATE flash_sales SET sold = sold + $2,
+       status = CASE WHEN sold + $2 >= quantity THEN 'sold_out' ELSE status END
+ WHERE id=$1`, saleID, qty)
+	return saleID, price, left - qty, err
+}
+
+var errFlashSaleCap = errors.New("flash sale quantity exceeded")
+
+// flashSaleAfterOrder dispara, uma vez por oferta, o aviso de últimas
+// unidades para os leads engajados quando o que resta chega ao limite.
+func (a *App) flashSaleAfterOrder(ctx context.Context, saleIDs []int64) {
+	for _, id := range saleIDs {
+		var orgID, productID int64
+		err := a.DB.QueryRow(ctx, `
+UPDATE flash_sales SET last_units_sent_at = NOW()
+ WHERE id=$1 AND last_units_sent_at IS NULL AND status='active'
+   AND quantity - sold <= last_units_at AND ends_at > NOW()
+RETURNING org_id, product_id`, id).Scan(&orgID, &productID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("flash sale %d last units: %v", id, err)
+			continue
+		}
+		leads, err := a.flashSaleEngagedLeads(ctx, id, orgID, productID)
+		if err != nil {
+			log.Printf("flash sale %d last units: %v", id, err)
+			continue
+		}
+		start := time.Now()
+		for i, leadID := range leads {
+			if _, err := a.enqueueJob(ctx, orgID, jobFlashSaleSend,
+				flashSaleSendJob{SaleID: id, LeadID: leadID, Kind: flashSaleLastUnits},
+				start.Add(time.Duration(i)*3*time.Second)); err != nil {
+				log.Printf("flash sale %d last units lead=%d: %v", id, leadID, err)
+			}
+		}
+	}
+}
+
+// flashSaleEngagedLeads são os leads que receberam o anúncio, responderam
+// depois dele e ainda não pediram o produto.
+func (a *App) flashSaleEngagedLeads(ctx context.Context, saleID, orgID, productID int64) ([]int64, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT fl.lead_id FROM flash_sale_leads fl
+ WHERE fl.flash_sale_id=$1 AND fl.announced_at IS NOT NULL AND fl.last_units_at IS NULL
+   AND EXISTS (SELECT 1 FROM public.wa_messages m
+                WHERE m.org_id=$2 AND m.lead_id=fl.lead_id AND m.direction='in'
+                  AND m.created_at >= fl.announced_at)
+   AND NOT EXISTS (SELECT 1 FROM order_items oi JOIN orders o ON o.id = oi.order_id
+                    WHERE o.org_id=$2 AND o.lead_id=fl.lead_id AND oi.product_id=$3
+                      AND o.created_at >= fl.announced_at)
+ ORDER BY fl.lead_id`, saleID, orgID, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// flashSaleSendJob é o payload do job flash_sale.send.
+type flashSaleSendJob struct {
+	SaleID int64  `json:"sale_id"`
+	LeadID int64  `json:"lead_id"`
+	Kind   string `json:"kind"` // announce | last_units
+}
+
+func (a *App) runFlashSaleSend(ctx context.Context, j job) error {
+	var m flashSaleSendJob
+	if err := j.decode(&m); err != nil {
+		return err
+	}
+	if j.OrgID == nil {
+		return permanentJobError(errors.New("flash sale job without org"))
+	}
+	s, err := scanFlashSale(a.DB.QueryRow(ctx, flashSaleCols+` WHERE s.id=$1 AND s.org_id=$2`, m.SaleID, *j.OrgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return permanentJobError(fmt.Errorf("flash sale %d not found", m.SaleID))
+	}
+	if err != nil {
+		return err
+	}
+	// oferta cancelada, esgotada ou vencida: o anúncio atrasado não sai
+	if !s.Running || s.Remaining == 0 {
+		return nil
+	}
+	row, contact, err := a.leadWAContact(ctx, s.OrgID, s.FlowID, m.LeadID)
+	if err != nil {
+		return permanentJobError(fmt.Errorf("lead %d: %w", m.LeadID, err))
+	}
+	text := s.announcement()
+	col := "announced_at"
+	if m.Kind == flashSaleLastUnits {
+		text, col = s.lastUnitsText(), "last_units_at"
+	}
+	_, status, err := a.sendWAText(ctx, row, row.Token, contact, text)
+	if err != nil && status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+		return permanentJobError(err)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = a.DB.Exec(ctx, `
+UPDATE flash_sale_leads SET `+col+` = NOW() WHERE flash_sale_id=$1 AND lead_id=$2`, s.ID, m.LeadID)
+	return err
+}
+
+// announcement monta o anúncio com a contagem regressiva do momento do envio.
+func (s flashSale) announcement() string {
+	var b strings.Builder
+	if s.Message != "" {
+		b.WriteString(s.Message)
+	} else {
+		fmt.Fprintf(&b, "⚡ Oferta relâmpago: %s por R$ %.2f", s.ProductTitle, float64(s.PriceCents)/100)
+		if s.RegularPriceCents > s.PriceCents {
+			fmt.Fprintf(&b, " (de R$ %.2f)", float64(s.RegularPriceCents)/100)
+		}
+		b.WriteString("!")
+	}
+	fmt.Fprintf(&b, "\n⏳ Termina em %s (até %s). Só %d unidades nesse preço.",
+		flashSaleCountdown(time.Until(s.EndsAt)), s.EndsAt.In(appointmentLoc()).Format("02/01 às 15:04"), s.Remaining)
+	return b.String()
+}
+
+func (s flashSale) lastUnitsText() string {
+	units := "unidades"
+	if s.Remaining == 1 {
+		units = "unidade"
+	}
+	return fmt.Sprintf("🔥 Últimas unidades! Restam só %d %s de %s por R$ %.2f, e a oferta termina em %s. Quer garantir a sua?",
+		s.Remaining, units, s.ProductTitle, float64(s.PriceCents)/100, flashSaleCountdown(time.Until(s.EndsAt)))
+}
+
+// flashSaleCountdown escreve o tempo restante: "2 dias e 3h", "3h20", "45 min".
+func flashSaleCountdown(d time.Duration) string {
+	if d < time.Minute {
+		return "menos de 1 min"
+	}
+	d = d.Round(time.Minute)
+	days, hours, mins := int(d/(24*time.Hour)), int(d/time.Hour)%24, int(d/time.Minute)%60
+	switch {
+	case days > 1:
+		return fmt.Sprintf("%d dias e %dh", days, hours)
+	case days == 1:
+		return fmt.Sprintf("1 dia e %dh", hours)
+	case hours > 0 && mins > 0:
+		return fmt.Sprintf("%dh%02d", hours, mins)
+	case hours > 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%d min", mins)
+	}
+}
@@ -3,11 +3,27 @@ module github.com/paclead/backend
 go 1.22
 
 require (
-    github.com/go-chi/chi/v5 v5.0.10
-    github.com/go-chi/jwtauth/v5 v5.3.1
-    github.com/lestrrat-go/jwx/v2 v2.0.18
-    github.com/jackc/pgx/v5 v5.6.0
-    github.com/joho/godotenv v1.5.1
-    golang.org/x/crypto v0.7.0
-    github.com/sashabaranov/go-openai v1.25.0
+	github.com/go-chi/chi/v5 v5.0.10
+	github.com/go-chi/cors v1.2.2
+	github.com/go-chi/jwtauth/v5 v5.3.1
+	github.com/gorilla/websocket v1.5.1
+	github.com/jackc/pgx/v5 v5.6.0
+	github.com/joho/godotenv v1.5.1
+	github.com/lestrrat-go/jwx/v2 v2.0.20
+	github.com/sashabaranov/go-openai v1.25.0
+	golang.org/x/crypto v0.21.0
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/puddle/v2 v2.2.1 // indirect
+	github.com/lestrrat-go/blackmagic v1.0.2 // indirect
+	github.com/lestrrat-go/httpcc v1.0.1 // indirect
+	github.com/lestrrat-go/httprc v1.0.4 // indirect
+	github.com/lestrrat-go/iter v1.0.2 // indirect
+	github.com/lestrrat-go/option v1.0.1 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
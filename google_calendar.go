@@ -0,0 +1,738 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	jwxjwt "github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Google Agenda (sincronização nos dois sentidos)
+// ================================================================
+//
+// Cada usuário conecta a própria agenda por OAuth (migrations/0019). Uma
+// conexão pode ser marcada como org_default (só admin) e passa a receber os
+// agendamentos da agenda da org.
+//
+//   - Agendamentos criados/alterados aqui viram eventos na agenda conectada
+//     (pushAppointmentToGoogle), com o id do agendamento em
+//     extendedProperties.private.
+//   - O worker (GOOGLE_CALENDAR_SYNC_INTERVAL, padrão 5m; 0 desliga) lê os
+//     eventos alterados no Google: evento removido cancela o agendamento,
+//     evento movido remarca.
+//   - Os horários ocupados no Google (freeBusy) saem da lista de horários
+//     livres oferecidos ao lead (busyIntervals, appointments.go).
+//
+// GET    /api/integrations/google-calendar            conexão do usuário
+// GET    /api/integrations/google-calendar/connect    ?org_default=true -> {"url"}
+// GET    /api/integrations/google-calendar/callback   retorno do OAuth (público)
+// DELETE /api/integrations/google-calendar
+//
+// Configuração: GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET, GOOGLE_REDIRECT_URL
+// (URL pública do callback) e, opcional, GOOGLE_OAUTH_SUCCESS_URL para onde o
+// navegador volta depois de conectar.
+
+const (
+	googleAuthURL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	googleRevokeURL   = "https://oauth2.googleapis.com/revoke"
+	googleCalendarAPI = "https://www.googleapis.com/calendar/v3"
+
+	googleCalendarScopes = "https://www.googleapis.com/auth/calendar.events https://www.googleapis.com/auth/calendar.freebusy"
+	gcalStateType        = "gcal_state"
+	gcalStateTTL         = 10 * time.Minute
+)
+
+func googleCalendarConfigured() bool {
+	return getenv("GOOGLE_CLIENT_ID", "") != "" && getenv("GOOGLE_CLIENT_SECRET", "") != "" &&
+		getenv("GOOGLE_REDIRECT_URL", "") != ""
+}
+
+type gcalConn struct {
+	ID           int64      `json:"id"`
+	OrgID        int64      `json:"org_id"`
+	FlowID       int64      `json:"flow_id"`
+	UserID       int64      `json:"user_id"`
+	OrgDefault   bool       `json:"org_default"`
+	Email        string     `json:"google_email,omitempty"`
+	CalendarID   string     `json:"calendar_id"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
+	LastError    *string    `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+const gcalConnColumns = `id, org_id, flow_id, user_id, org_default, COALESCE(google_email,''), calendar_id,
+       last_synced_at, last_error, created_at, access_token, refresh_token, token_expiry`
+
+func scanGCalConn(row pgx.Row) (gcalConn, error) {
+	var c gcalConn
+	err := row.Scan(&c.ID, &c.OrgID, &c.FlowID, &c.UserID, &c.OrgDefault, &c.Email, &c.CalendarID,
+		&c.LastSyncedAt, &c.LastError, &c.CreatedAt, &c.accessToken, &c.refreshToken, &c.expiry)
+	if err != nil {
+		return c, err
+	}
+	c.accessToken, c.refreshToken = revealPII(c.OrgID, c.accessToken), revealPII(c.OrgID, c.refreshToken)
+	return c, nil
+}
+
+func (a *App) mountGoogleCalendar(r chi.Router) {
+	r.Route("/integrations/google-calendar", func(r chi.Router) {
+		r.With(a.requireAuth).Get("/", a.getGoogleCalendar)
+		r.With(a.requireAuth).Get("/connect", a.connectGoogleCalendar)
+		r.With(a.requireAuth).Delete("/", a.disconnectGoogleCalendar)
+		r.Get("/callback", a.googleCalendarCallback)
+	})
+
+	if every := googleCalendarSyncInterval(); every > 0 && googleCalendarConfigured() {
+		go a.googleCalendarSyncLoop(every)
+	}
+}
+
+func googleCalendarSyncInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("GOOGLE_CALENDAR_SYNC_INTERVAL", "5m"))
+	if err != nil || d < 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// ---------------- API do Google ----------------
+
+type googleAPIError struct {
+	Status  int
+	Message string
+}
+
+func (e *googleAPIError) Error() string {
+	return fmt.Sprintf("google api %d: %s", e.Status, e.Message)
+}
+
+func isGoogleNotFound(err error) bool {
+	var ge *googleAPIError
+	return errors.As(err, &ge) && (ge.Status == http.StatusNotFound || ge.Status == http.StatusGone)
+}
+
+func googleDo(ctx context.Context, method, rawURL, token string, body, out any) error {
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rdr = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, rdr)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if resp.StatusCode >= 400 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		msg := e.Error.Message
+		if msg == "" {
+			msg = strings.TrimSpace(string(raw))
+		}
+		return &googleAPIError{Status: resp.StatusCode, Message: msg}
+	}
+	if out != nil && len(raw) > 0 {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+type googleTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	ErrorDesc    string `json:"error_description"`
+}
+
+func googleTokenRequest(ctx context.Context, form url.Values) (googleTokenResponse, error) {
+	var out googleTokenResponse
+	form.Set("client_id", getenv("GOOGLE_CLIENT_ID", ""))
+	form.Set("client_secret", getenv("GOOGLE_CLIENT_SECRET", ""))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return out, err
+	}
+	if resp.StatusCode >= 400 || out.AccessToken == "" {
+		return out, &googleAPIError{Status: resp.StatusCode, Message: firstNonEmpty(out.ErrorDesc, out.Error, "token request failed")}
+	}
+	return out, nil
+}
+
+// googleAccessToken devolve um access token válido, renovando (e gravando)
+// quando falta menos de um minuto para expirar.
+func (a *App) googleAccessToken(ctx context.Context, c *gcalConn) (string, error) {
+	if c.accessToken != "" && time.Until(c.expiry) > time.Minute {
+		return c.accessToken, nil
+	}
+	tok, err := googleTokenRequest(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {c.refreshToken},
+	})
+	if err != nil {
+		return "", err
+	}
+	c.accessToken = tok.AccessToken
+	c.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	enc, err := encryptPII(c.OrgID, piiKeyVersion(ctx, a.DB, c.OrgID), c.accessToken)
+	if err != nil {
+		return "", err
+	}
+	if _, err := a.DB.Exec(ctx, `
+UPDATE public.google_calendar_connections SET access_token=$2, token_expiry=$3, updated_at=NOW() WHERE id=$1`,
+		c.ID, enc, c.expiry); err != nil {
+		log.Printf("google calendar %d: save token: %v", c.ID, err)
+	}
+	return c.accessToken, nil
+}
+
+func (c gcalConn) eventsURL() string {
+	return googleCalendarAPI + "/calendars/" + url.PathEscape(c.CalendarID) + "/events"
+}
+
+// ---------------- OAuth ----------------
+
+// GET /api/integrations/google-calendar
+func (a *App) getGoogleCalendar(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	c, err := scanGCalConn(a.DB.QueryRow(r.Context(), `
+SELECT `+gcalConnColumns+` FROM public.google_calendar_connections WHERE user_id=$1 AND org_id=$2`,
+		claims.UserID, claims.OrgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.OK(w, map[string]any{"connected": false, "configured": googleCalendarConfigured()})
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"connected": true, "configured": googleCalendarConfigured(), "connection": c})
+}
+
+// GET /api/integrations/google-calendar/connect?org_default=true
+//
+// O state é um JWT curto assinado com o segredo da API; não tem user_id e
+// por isso não serve como token de acesso.
+func (a *App) connectGoogleCalendar(w http.ResponseWriter, r *http.Request) {
+	if !googleCalendarConfigured() {
+		render.Error(w, http.StatusServiceUnavailable, "google calendar not configured (set GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET and GOOGLE_REDIRECT_URL)")
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+	orgDefault, _ := strconv.ParseBool(r.URL.Query().Get("org_default"))
+	if orgDefault {
+		role, err := a.userRole(r.Context(), claims.UserID, claims.OrgID)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !roleAtLeast(role, roleAdmin) {
+			render.Error(w, http.StatusForbidden, "forbidden: org calendar requires admin role")
+			return
+		}
+	}
+	_, state, err := tokenAuth.Encode(map[string]any{
+		"typ":         gcalStateType,
+		"gcal_user":   claims.UserID,
+		"gcal_org":    claims.OrgID,
+		"gcal_flow":   claims.FlowID,
+		"org_default": orgDefault,
+		"exp":         time.Now().Add(gcalStateTTL).Unix(),
+	})
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	q := url.Values{
+		"client_id":              {getenv("GOOGLE_CLIENT_ID", "")},
+		"redirect_uri":           {getenv("GOOGLE_REDIRECT_URL", "")},
+		"response_type":          {"code"},
+		"scope":                  {googleCalendarScopes},
+		"access_type":            {"offline"},
+		"prompt":                 {"consent"},
+		"include_granted_scopes": {"true"},
+		"state":                  {state},
+	}
+	render.OK(w, map[string]any{"url": googleAuthURL + "?" + q.Encode()})
+}
+
+// GET /api/integrations/google-calendar/callback?code=&state=
+func (a *App) googleCalendarCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		a.googleCalendarDone(w, r, "error", e)
+		return
+	}
+	tok, err := tokenAuth.Decode(q.Get("state"))
+	if err != nil || tok == nil || jwxjwt.Validate(tok) != nil {
+		render.Error(w, http.StatusBadRequest, "invalid or expired state")
+		return
+	}
+	if typ, _ := tok.Get("typ"); typ != gcalStateType {
+		render.Error(w, http.StatusBadRequest, "invalid state")
+		return
+	}
+	userID, orgID, flowID := toInt64(getClaim(tok, "gcal_user")), toInt64(getClaim(tok, "gcal_org")), toInt64(getClaim(tok, "gcal_flow"))
+	orgDefault, _ := getClaim(tok, "org_default").(bool)
+	if userID == 0 || orgID == 0 || flowID == 0 {
+		render.Error(w, http.StatusBadRequest, "invalid state")
+		return
+	}
+
+	ctx := r.Context()
+	t, err := googleTokenRequest(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {q.Get("code")},
+		"redirect_uri": {getenv("GOOGLE_REDIRECT_URL", "")},
+	})
+	if err != nil {
+		log.Printf("google calendar oauth user=%d: %v", userID, err)
+		a.googleCalendarDone(w, r, "error", "token_exchange_failed")
+		return
+	}
+	if t.RefreshToken == "" {
+		a.googleCalendarDone(w, r, "error", "missing_refresh_token")
+		return
+	}
+	// o id da agenda principal é o e-mail da conta
+	var primary struct {
+		ID string `json:"id"`
+	}
+	if err := googleDo(ctx, http.MethodGet, googleCalendarAPI+"/calendars/primary", t.AccessToken, nil, &primary); err != nil {
+		log.Printf("google calendar oauth user=%d: %v", userID, err)
+	}
+
+	ver := piiKeyVersion(ctx, a.DB, orgID)
+	access, err := encryptPII(orgID, ver, t.AccessToken)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	refresh, err := encryptPII(orgID, ver, t.RefreshToken)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	if orgDefault {
+		if _, err := tx.Exec(ctx, `
+UPDATE public.google_calendar_connections SET org_default=FALSE
+ WHERE org_id=$1 AND flow_id=$2 AND org_default AND user_id <> $3`, orgID, flowID, userID); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO public.google_calendar_connections
+  (org_id, flow_id, user_id, org_default, google_email, access_token, refresh_token, token_expiry, last_synced_at)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), $6, $7, $8, NOW())
+ON CONFLICT (user_id) DO UPDATE SET
+  org_id=EXCLUDED.org_id, flow_id=EXCLUDED.flow_id,
+  org_default = EXCLUDED.org_default OR google_calendar_connections.org_default,
+  google_email=EXCLUDED.google_email, access_token=EXCLUDED.access_token,
+  refresh_token=EXCLUDED.refresh_token, token_expiry=EXCLUDED.token_expiry,
+  last_error=NULL, updated_at=NOW()`,
+		orgID, flowID, userID, orgDefault, primary.ID, access, refresh,
+		time.Now().Add(time.Duration(t.ExpiresIn)*time.Second)); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.googleCalendarDone(w, r, "connected", "")
+}
+
+// googleCalendarDone devolve o navegador ao front (GOOGLE_OAUTH_SUCCESS_URL)
+// ou, sem ele, responde em JSON.
+func (a *App) googleCalendarDone(w http.ResponseWriter, r *http.Request, status, reason string) {
+	if dest := getenv("GOOGLE_OAUTH_SUCCESS_URL", ""); dest != "" {
+		u, err := url.Parse(dest)
+		if err == nil {
+			q := u.Query()
+			q.Set("google_calendar", status)
+			if reason != "" {
+				q.Set("reason", reason)
+			}
+			u.RawQuery = q.Encode()
+			http.Redirect(w, r, u.String(), http.StatusFound)
+			return
+		}
+	}
+	if status != "connected" {
+		render.Error(w, http.StatusBadRequest, "google calendar: "+reason)
+		return
+	}
+	render.OK(w, map[string]any{"connected": true})
+}
+
+// DELETE /api/integrations/google-calendar
+func (a *App) disconnectGoogleCalendar(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	c, err := scanGCalConn(a.DB.QueryRow(r.Context(), `
+DELETE FROM public.google_calendar_connections WHERE user_id=$1 AND org_id=$2
+RETURNING `+gcalConnColumns, claims.UserID, claims.OrgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "google calendar not connected")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// revogação é cortesia: a conexão já foi apagada
+	go func(token string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL+"?token="+url.QueryEscape(token), nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}(c.refreshToken)
+	render.NoContent(w)
+}
+
+// ---------------- agendamentos -> Google ----------------
+
+// gcalConnFor escolhe a conexão da agenda: a do usuário ou, para a agenda da
+// org, a marcada como org_default.
+func (a *App) gcalConnFor(ctx context.Context, orgID, flowID int64, userID *int64) (gcalConn, error) {
+	if userID != nil {
+		return scanGCalConn(a.DB.QueryRow(ctx, `
+SELECT `+gcalConnColumns+` FROM public.google_calendar_connections WHERE user_id=$1 AND org_id=$2`, *userID, orgID))
+	}
+	return scanGCalConn(a.DB.QueryRow(ctx, `
+SELECT `+gcalConnColumns+` FROM public.google_calendar_connections
+ WHERE org_id=$1 AND flow_id=$2 AND org_default`, orgID, flowID))
+}
+
+type googleEventTime struct {
+	DateTime string `json:"dateTime,omitempty"`
+	Date     string `json:"date,omitempty"`
+}
+
+type googleEvent struct {
+	ID                 string          `json:"id,omitempty"`
+	Status             string          `json:"status,omitempty"`
+	Summary            string          `json:"summary,omitempty"`
+	Description        string          `json:"description,omitempty"`
+	Start              googleEventTime `json:"start"`
+	End                googleEventTime `json:"end"`
+	ExtendedProperties struct {
+		Private map[string]string `json:"private,omitempty"`
+	} `json:"extendedProperties"`
+}
+
+// pushAppointmentToGoogle cria, altera ou remove o evento do agendamento na
+// agenda conectada. Roda em segundo plano; falhas só vão para o log e para
+// last_error da conexão.
+func (a *App) pushAppointmentToGoogle(ctx context.Context, ap appointment) {
+	if !googleCalendarConfigured() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := a.syncAppointmentEvent(ctx, ap); err != nil {
+			log.Printf("google calendar appointment=%d: %v", ap.ID, err)
+		}
+	}()
+}
+
+func (a *App) syncAppointmentEvent(ctx context.Context, ap appointment) error {
+	var eventID string
+	var connID *int64
+	if err := a.DB.QueryRow(ctx,
+		`SELECT COALESCE(google_event_id,''), google_connection_id FROM public.appointments WHERE id=$1`, ap.ID).
+		Scan(&eventID, &connID); err != nil {
+		return err
+	}
+	var c gcalConn
+	var err error
+	if connID != nil {
+		c, err = scanGCalConn(a.DB.QueryRow(ctx,
+			`SELECT `+gcalConnColumns+` FROM public.google_calendar_connections WHERE id=$1`, *connID))
+	} else {
+		c, err = a.gcalConnFor(ctx, ap.OrgID, ap.FlowID, ap.UserID)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil // agenda sem Google conectado
+	}
+	if err != nil {
+		return err
+	}
+	token, err := a.googleAccessToken(ctx, &c)
+	if err != nil {
+		a.setGCalError(ctx, c.ID, err)
+		return err
+	}
+
+	if ap.Status == appointmentCanceled {
+		if eventID == "" {
+			return nil
+		}
+		err := googleDo(ctx, http.MethodDelete, c.eventsURL()+"/"+url.PathEscape(eventID), token, nil, nil)
+		if err != nil && !isGoogleNotFound(err) {
+			a.setGCalError(ctx, c.ID, err)
+			return err
+		}
+		return nil
+	}
+
+	ev := googleEvent{
+		Summary:     ap.Title,
+		Description: ap.Notes,
+		Start:       googleEventTime{DateTime: ap.StartsAt.UTC().Format(time.RFC3339)},
+		End:         googleEventTime{DateTime: ap.EndsAt.UTC().Format(time.RFC3339)},
+	}
+	ev.ExtendedProperties.Private = map[string]string{
+		"paclead_appointment_id": strconv.FormatInt(ap.ID, 10),
+		"paclead_org":            strconv.FormatInt(ap.OrgID, 10),
+	}
+	var out googleEvent
+	if eventID != "" {
+		err = googleDo(ctx, http.MethodPatch, c.eventsURL()+"/"+url.PathEscape(eventID), token, ev, &out)
+		if isGoogleNotFound(err) {
+			eventID, err = "", nil // apagado no Google: recria
+		}
+	}
+	if eventID == "" && err == nil {
+		err = googleDo(ctx, http.MethodPost, c.eventsURL(), token, ev, &out)
+	}
+	if err != nil {
+		a.setGCalError(ctx, c.ID, err)
+		return err
+	}
+	_, err = a.DB.Exec(ctx, `
+UPDATE public.appointments SET google_event_id=$2, google_connection_id=$3 WHERE id=$1`, ap.ID, out.ID, c.ID)
+	return err
+}
+
+func (a *App) setGCalError(ctx context.Context, connID int64, err error) {
+	if _, e := a.DB.Exec(ctx, `
+UPDATE public.google_calendar_connections SET last_error=$2, updated_at=NOW() WHERE id=$1`,
+		connID, limitRunes(err.Error(), 500)); e != nil {
+		log.Printf("google calendar %d: %v", connID, e)
+	}
+}
+
+// ---------------- Google -> agendamentos ----------------
+
+func (a *App) googleCalendarSyncLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		a.syncGoogleCalendars()
+	}
+}
+
+func (a *App) syncGoogleCalendars() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	rows, err := a.DB.Query(ctx, `SELECT `+gcalConnColumns+` FROM public.google_calendar_connections ORDER BY id`)
+	if err != nil {
+		log.Printf("google calendar sync: %v", err)
+		return
+	}
+	var conns []gcalConn
+	for rows.Next() {
+		c, err := scanGCalConn(rows)
+		if err != nil {
+			log.Printf("google calendar sync: %v", err)
+			continue
+		}
+		conns = append(conns, c)
+	}
+	rows.Close()
+	for i := range conns {
+		if err := a.pullGoogleChanges(ctx, &conns[i]); err != nil {
+			log.Printf("google calendar %d sync: %v", conns[i].ID, err)
+			a.setGCalError(ctx, conns[i].ID, err)
+		}
+	}
+}
+
+// pullGoogleChanges aplica aos agendamentos os eventos nossos alterados no
+// Google desde a última leitura. Mudanças vindas do Google não passam pela
+// checagem de conflito: a agenda do Google é a referência do usuário.
+func (a *App) pullGoogleChanges(ctx context.Context, c *gcalConn) error {
+	token, err := a.googleAccessToken(ctx, c)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	since := started.Add(-24 * time.Hour)
+	if c.LastSyncedAt != nil {
+		since = c.LastSyncedAt.Add(-time.Minute)
+	}
+	q := url.Values{
+		"updatedMin":              {since.UTC().Format(time.RFC3339)},
+		"showDeleted":             {"true"},
+		"singleEvents":            {"true"},
+		"maxResults":              {"250"},
+		"privateExtendedProperty": {"paclead_org=" + strconv.FormatInt(c.OrgID, 10)},
+	}
+	for page := 0; page < 20; page++ {
+		var resp struct {
+			Items         []googleEvent `json:"items"`
+			NextPageToken string        `json:"nextPageToken"`
+		}
+		if err := googleDo(ctx, http.MethodGet, c.eventsURL()+"?"+q.Encode(), token, nil, &resp); err != nil {
+			return err
+		}
+		for _, ev := range resp.Items {
+			if err := a.applyGoogleEvent(ctx, c, ev); err != nil {
+				log.Printf("google calendar %d event %s: %v", c.ID, ev.ID, err)
+			}
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		q.Set("pageToken", resp.NextPageToken)
+	}
+	_, err = a.DB.Exec(ctx, `
+UPDATE public.google_calendar_connections SET last_synced_at=$2, last_error=NULL, updated_at=NOW() WHERE id=$1`,
+		c.ID, started)
+	return err
+}
+
+func (a *App) applyGoogleEvent(ctx context.Context, c *gcalConn, ev googleEvent) error {
+	apID, err := strconv.ParseInt(ev.ExtendedProperties.Private["paclead_appointment_id"], 10, 64)
+	if err != nil || apID == 0 {
+		return nil
+	}
+	if ev.Status == "cancelled" {
+		_, err := a.DB.Exec(ctx, `
+UPDATE public.appointments SET status='canceled', updated_at=NOW()
+ WHERE id=$1 AND org_id=$2 AND google_event_id=$3 AND status='booked'`, apID, c.OrgID, ev.ID)
+		return err
+	}
+	start, err1 := time.Parse(time.RFC3339, ev.Start.DateTime)
+	end, err2 := time.Parse(time.RFC3339, ev.End.DateTime)
+	if err1 != nil || err2 != nil || !start.Before(end) {
+		return nil // virou evento de dia inteiro: mantém o horário daqui
+	}
+	_, err = a.DB.Exec(ctx, `
+UPDATE public.appointments
+   SET starts_at=$4, ends_at=$5, title=COALESCE(NULLIF($6,''), title), reminder_sent_at=NULL, updated_at=NOW()
+ WHERE id=$1 AND org_id=$2 AND google_event_id=$3 AND status='booked'
+   AND (starts_at <> $4 OR ends_at <> $5)`, apID, c.OrgID, ev.ID, start, end, ev.Summary)
+	return err
+}
+
+// ---------------- ocupado no Google ----------------
+
+// googleBusy consulta o freeBusy das agendas conectadas do flow. A chave é a
+// do agendamento (scheduleKey): o usuário, e 0 para a conexão org_default.
+// Falhas numa agenda só vão para o log: o horário é oferecido mesmo assim.
+func (a *App) googleBusy(ctx context.Context, orgID, flowID int64, from, to time.Time) map[int64][]timeInterval {
+	out := map[int64][]timeInterval{}
+	if !googleCalendarConfigured() {
+		return out
+	}
+	rows, err := a.DB.Query(ctx, `
+SELECT `+gcalConnColumns+` FROM public.google_calendar_connections WHERE org_id=$1 AND flow_id=$2`, orgID, flowID)
+	if err != nil {
+		log.Printf("google busy org=%d: %v", orgID, err)
+		return out
+	}
+	var conns []gcalConn
+	for rows.Next() {
+		if c, err := scanGCalConn(rows); err == nil {
+			conns = append(conns, c)
+		}
+	}
+	rows.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for i := range conns {
+		c := &conns[i]
+		token, err := a.googleAccessToken(ctx, c)
+		if err != nil {
+			log.Printf("google busy %d: %v", c.ID, err)
+			continue
+		}
+		var resp struct {
+			Calendars map[string]struct {
+				Busy []struct {
+					Start time.Time `json:"start"`
+					End   time.Time `json:"end"`
+				} `json:"busy"`
+			} `json:"calendars"`
+		}
+		err = googleDo(ctx, http.MethodPost, googleCalendarAPI+"/freeBusy", token, map[string]any{
+			"timeMin": from.UTC().Format(time.RFC3339),
+			"timeMax": to.UTC().Format(time.RFC3339),
+			"items":   []map[string]string{{"id": c.CalendarID}},
+		}, &resp)
+		if err != nil {
+			log.Printf("google busy %d: %v", c.ID, err)
+			continue
+		}
+		for _, cal := range resp.Calendars {
+			for _, b := range cal.Busy {
+				iv := timeInterval{start: b.Start, end: b.End}
+				out[c.UserID] = append(out[c.UserID], iv)
+				if c.OrgDefault {
+					out[0] = append(out[0], iv)
+				}
+			}
+		}
+	}
+	return out
+}
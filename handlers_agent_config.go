@@ -6,11 +6,12 @@ import (
     "encoding/json"
     "errors"
     "net/http"
-    "strconv"
     "strings"
     "time"
 
     "github.com/go-chi/chi/v5"
+    "github.com/paclead/backend/llm"
+    "github.com/paclead/backend/render"
 )
 
 type AgentSettings struct {
@@ -23,27 +24,33 @@ type AgentSettings struct {
     ProfileCustom      string    `json:"profileCustom"`
     BasePrompt         string    `json:"basePrompt"`
     TaxID              string    `json:"tax_id"`
+    LLMProvider        string    `json:"llmProvider"` // vazio = LLM_PROVIDER (ai_llm.go)
+    LLMModel           string    `json:"llmModel"`
+    PersonaID          *int64    `json:"personaId"` // atribuída via PUT /api/agent/persona (agent_personas.go)
     UpdatedAt          time.Time `json:"updated_at"`
 }
 
 func (a *App) mountAgentConfig(r chi.Router) {
     r.Route("/agent", func(r chi.Router) {
         r.Get("/settings", a.getAgentSettings)
-        r.Put("/settings", a.putAgentSettings)
+        r.With(a.requireRole(roleAdmin)).Put("/settings", a.putAgentSettings)
     })
     // >>> Compatibilidade com rota antiga:
     r.Get("/agent-config", a.getAgentSettings)
-    r.Put("/agent-config", a.putAgentSettings)
+    r.With(a.requireRole(roleAdmin)).Put("/agent-config", a.putAgentSettings)
 }
 
 func (a *App) getAgentSettings(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
-
-    orgID, flowID := parseTenant(r)
+    // tenant resolvido em main.go (tenantDefaultOrg: JWT > headers/query > 1)
+    orgID, flowID, err := tenantOf(r)
+    if err != nil {
+        render.Error(w, http.StatusBadRequest, err.Error())
+        return
+    }
     ctx := r.Context()
 
     var s AgentSettings
-    err := a.DB.QueryRow(ctx, `
+    err = a.DB.QueryRow(ctx, `
         SELECT org_id, flow_id,
                COALESCE(name, ''),
                COALESCE(communication_style, ''),
@@ -52,29 +59,35 @@ func (a *App) getAgentSettings(w http.ResponseWriter, r *http.Request) {
                COALESCE(profile_custom, ''),
                COALESCE(base_prompt, ''),
                COALESCE(tax_id, ''),
+               COALESCE(llm_provider, ''),
+               COALESCE(llm_model, ''),
+               persona_id,
                updated_at
           FROM agent_settings
          WHERE org_id=$1 AND flow_id=$2
     `, orgID, flowID).Scan(
         &s.OrgID, &s.FlowID, &s.Name, &s.CommunicationStyle, &s.Sector,
-        &s.ProfileType, &s.ProfileCustom, &s.BasePrompt, &s.TaxID, &s.UpdatedAt,
+        &s.ProfileType, &s.ProfileCustom, &s.BasePrompt, &s.TaxID, &s.LLMProvider, &s.LLMModel, &s.PersonaID, &s.UpdatedAt,
     )
     if err != nil {
         // Retorna payload “vazio” se não existir ainda (sem 404 para facilitar consumo)
         s = AgentSettings{OrgID: orgID, FlowID: flowID}
     }
 
-    _ = json.NewEncoder(w).Encode(s)
+    render.OK(w, s)
 }
 
 func (a *App) putAgentSettings(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
-
-    orgID, flowID := parseTenant(r)
+    // requireRole: tenant do JWT
+    orgID, flowID, err := tenantOf(r)
+    if err != nil {
+        render.Error(w, http.StatusBadRequest, err.Error())
+        return
+    }
 
     var in AgentSettings
     if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-        http.Error(w, "bad json", http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "bad json")
         return
     }
 
@@ -88,16 +101,22 @@ func (a *App) putAgentSettings(w http.ResponseWriter, r *http.Request) {
     in.ProfileCustom = strings.TrimSpace(in.ProfileCustom)
     in.BasePrompt = strings.TrimSpace(in.BasePrompt)
     in.TaxID = onlyDigits(in.TaxID)
+    in.LLMProvider = strings.ToLower(strings.TrimSpace(in.LLMProvider))
+    in.LLMModel = strings.TrimSpace(in.LLMModel)
+    if in.LLMProvider != "" && !llm.Valid(in.LLMProvider) {
+        render.Error(w, http.StatusBadRequest, "invalid llmProvider (use "+strings.Join(llm.Providers, ", ")+")")
+        return
+    }
 
     ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
     defer cancel()
 
-    // UPSERT
-    _, err := a.DB.Exec(ctx, `
+    // UPSERT (persona_id só muda via PUT /api/agent/persona)
+    err = a.DB.QueryRow(ctx, `
         INSERT INTO agent_settings
-            (org_id, flow_id, name, communication_style, sector, profile_type, profile_custom, base_prompt, tax_id, updated_at)
+            (org_id, flow_id, name, communication_style, sector, profile_type, profile_custom, base_prompt, tax_id, llm_provider, llm_model, updated_at)
         VALUES
-            ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+            ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10,''), NULLIF($11,''), NOW())
         ON CONFLICT (org_id, flow_id)
         DO UPDATE SET
             name=EXCLUDED.name,
@@ -107,44 +126,22 @@ func (a *App) putAgentSettings(w http.ResponseWriter, r *http.Request) {
             profile_custom=EXCLUDED.profile_custom,
             base_prompt=EXCLUDED.base_prompt,
             tax_id=EXCLUDED.tax_id,
+            llm_provider=EXCLUDED.llm_provider,
+            llm_model=EXCLUDED.llm_model,
             updated_at=NOW()
+        RETURNING persona_id
     `,
         in.OrgID, in.FlowID, in.Name, in.CommunicationStyle, in.Sector, in.ProfileType, in.ProfileCustom, in.BasePrompt, in.TaxID,
-    )
+        in.LLMProvider, in.LLMModel,
+    ).Scan(&in.PersonaID)
     if err != nil {
-        http.Error(w, "db error", http.StatusInternalServerError)
+        render.Error(w, http.StatusInternalServerError, "db error")
         return
     }
+    a.recordAgentVersion(ctx, orgID, flowID) // agent_versions.go
 
     in.UpdatedAt = time.Now().UTC()
-    _ = json.NewEncoder(w).Encode(in)
-}
-
-func parseTenant(r *http.Request) (int64, int64) {
-    // Headers têm precedência; fallback para querystring (?org_id=, ?flow_id=); por fim, default "1".
-    org := strings.TrimSpace(r.Header.Get("X-Org-ID"))
-    flow := strings.TrimSpace(r.Header.Get("X-Flow-ID"))
-    if org == "" {
-        org = strings.TrimSpace(r.URL.Query().Get("org_id"))
-    }
-    if flow == "" {
-        flow = strings.TrimSpace(r.URL.Query().Get("flow_id"))
-    }
-    if org == "" {
-        org = "1"
-    }
-    if flow == "" {
-        flow = "1"
-    }
-    orgID, _ := strconv.ParseInt(org, 10, 64)
-    flowID, _ := strconv.ParseInt(flow, 10, 64)
-    if orgID <= 0 {
-        orgID = 1
-    }
-    if flowID <= 0 {
-        flowID = 1
-    }
-    return orgID, flowID
+    render.OK(w, in)
 }
 
 // helper de limpeza de dígitos (útil para CPF/CNPJ)
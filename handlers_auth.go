@@ -16,17 +16,23 @@ import (
 	"github.com/go-chi/jwtauth/v5"
 	jwxjwt "github.com/lestrrat-go/jwx/v2/jwt"
 	"golang.org/x/crypto/bcrypt"
+	"github.com/paclead/backend/render"
 )
 
-// signer/verifier global
-var tokenAuth *jwtauth.JWTAuth
+// signer/verifier global; tokenAuthPrev verifica tokens assinados com o
+// segredo anterior durante a rotação (secrets.go)
+var tokenAuth, tokenAuthPrev *jwtauth.JWTAuth
 
 func init() {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
+		// só em desenvolvimento: validateSecrets barra isso em produção
 		secret = "secret"
 	}
 	tokenAuth = jwtauth.New("HS256", []byte(secret), nil)
+	if prev := os.Getenv("JWT_SECRET_PREVIOUS"); prev != "" {
+		tokenAuthPrev = jwtauth.New("HS256", []byte(prev), nil)
+	}
 }
 
 // rotas
@@ -35,6 +41,10 @@ func (a *App) mountAuth(r chi.Router) {
 	r.Post("/auth/login", a.login)
 	r.Post("/auth/refresh", a.refresh)
 	r.Get("/auth/me", a.me)
+	r.Post("/auth/forgot-password", a.forgotPassword)
+	r.Post("/auth/reset-password", a.resetPassword)
+	r.Get("/auth/verify", a.verifyEmail)
+	r.Post("/auth/resend-verification", a.resendVerification)
 }
 
 // POST /auth/register
@@ -51,16 +61,12 @@ func (a *App) register(w http.ResponseWriter, r *http.Request) {
         TaxID    string `json:"tax_id"`
     }
     if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-        http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
         return
     }
     in.Email = strings.TrimSpace(strings.ToLower(in.Email))
     in.Name = strings.TrimSpace(in.Name)
     in.TaxID = strings.TrimSpace(in.TaxID)
-    if in.Email == "" || in.Password == "" || in.Name == "" || in.TaxID == "" {
-        http.Error(w, "name, email, password and tax_id are required", http.StatusBadRequest)
-        return
-    }
     // validate TaxID: remove non‑digits and ensure it has either 11 (CPF) or 14 (CNPJ) digits
     digits := strings.Map(func(r rune) rune {
         if r >= '0' && r <= '9' {
@@ -68,8 +74,17 @@ func (a *App) register(w http.ResponseWriter, r *http.Request) {
         }
         return -1
     }, in.TaxID)
-    if len(digits) != 11 && len(digits) != 14 {
-        http.Error(w, "tax_id must be a valid CPF (11 digits) or CNPJ (14 digits)", http.StatusBadRequest)
+    var fields []render.FieldError
+    for _, f := range []struct{ name, value string }{{"name", in.Name}, {"email", in.Email}, {"password", in.Password}, {"tax_id", in.TaxID}} {
+        if f.value == "" {
+            fields = append(fields, render.FieldError{Field: f.name, Message: "required"})
+        }
+    }
+    if in.TaxID != "" && len(digits) != 11 && len(digits) != 14 {
+        fields = append(fields, render.FieldError{Field: "tax_id", Message: "must be a valid CPF (11 digits) or CNPJ (14 digits)"})
+    }
+    if len(fields) > 0 {
+        render.Validation(w, fields)
         return
     }
     // normalise: store only digits
@@ -79,18 +94,18 @@ func (a *App) register(w http.ResponseWriter, r *http.Request) {
 	var exists bool
 	if err := a.DB.QueryRow(r.Context(),
 		`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email)=LOWER($1))`, in.Email).Scan(&exists); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	if exists {
-		http.Error(w, "user already exists", http.StatusConflict)
+		render.Error(w, http.StatusConflict, "user already exists")
 		return
 	}
 
 	// hash
 	hashed, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -101,38 +116,50 @@ func (a *App) register(w http.ResponseWriter, r *http.Request) {
     // insert organisation with tax_id; assumes the orgs table has a tax_id column.
     if err := a.DB.QueryRow(ctx,
         `INSERT INTO orgs(name, tax_id) VALUES($1, $2) RETURNING id`, in.Name, in.TaxID).Scan(&orgID); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        render.Error(w, http.StatusInternalServerError, err.Error())
         return
     }
 	// flow
 	var flowID int64
 	if err := a.DB.QueryRow(ctx,
 		`INSERT INTO flows(org_id, name) VALUES($1, 'Fluxo 1') RETURNING id`, orgID).Scan(&flowID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	// user
 	var userID int64
 	if err := a.DB.QueryRow(ctx,
-		`INSERT INTO users(org_id, flow_id, name, email, password)
-		 VALUES($1,$2,$3,$4,$5) RETURNING id`,
+		`INSERT INTO users(org_id, flow_id, name, email, password, role)
+		 VALUES($1,$2,$3,$4,$5,'owner') RETURNING id`,
 		orgID, flowID, in.Name, in.Email, string(hashed)).Scan(&userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// link de verificação (email_verification.go)
+	go a.sendVerificationEmail(userID, in.Email, in.Name)
+	if emailVerifyRequired("login") {
+		render.OK(w, map[string]any{
+			"verification_required": true,
+			"id": userID, "email": in.Email, "name": in.Name, "org_id": orgID, "flow_id": flowID,
+			"role": roleOwner, "tax_id": in.TaxID,
+		})
 		return
 	}
 
 	// token
 	token, err := generateToken(userID, orgID, flowID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-    w.Header().Set("Content-Type", "application/json")
-    _ = json.NewEncoder(w).Encode(map[string]any{
+    render.OK(w, map[string]any{
         "access_token": token, "token_type": "bearer", "expires_in": 24 * 3600,
         "id": userID, "email": in.Email, "name": in.Name, "org_id": orgID, "flow_id": flowID,
+        "role": roleOwner,
         // include tax_id in the response so clients can persist it if needed
         "tax_id": in.TaxID,
+        "email_verified": false,
     })
 }
 
@@ -143,42 +170,47 @@ func (a *App) login(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
 		return
 	}
 	in.Email = strings.TrimSpace(strings.ToLower(in.Email))
 	if in.Email == "" || in.Password == "" {
-		http.Error(w, "email and password required", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "email and password required")
 		return
 	}
 
     var userID, orgID, flowID int64
-    var hashed, name, taxID string
+    var hashed, name, taxID, role string
+    var verified bool
     // join users with orgs to fetch the tax identifier
     if err := a.DB.QueryRow(r.Context(),
-        `SELECT u.id, u.org_id, u.flow_id, u.name, u.password, o.tax_id
+        `SELECT u.id, u.org_id, u.flow_id, u.name, u.password, o.tax_id, u.role, u.email_verified_at IS NOT NULL
          FROM users u
          JOIN orgs o ON u.org_id=o.id
          WHERE LOWER(u.email)=LOWER($1)`,
-        in.Email).Scan(&userID, &orgID, &flowID, &name, &hashed, &taxID); err != nil {
-        http.Error(w, "invalid credentials", http.StatusUnauthorized)
+        in.Email).Scan(&userID, &orgID, &flowID, &name, &hashed, &taxID, &role, &verified); err != nil {
+        render.Error(w, http.StatusUnauthorized, "invalid credentials")
         return
     }
 	if bcrypt.CompareHashAndPassword([]byte(hashed), []byte(in.Password)) != nil {
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		render.Error(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	// só depois da senha, para não revelar quais e-mails existem
+	if !verified && emailVerifyRequired("login") {
+		render.Error(w, http.StatusForbidden, "email not verified")
 		return
 	}
 
 	token, err := generateToken(userID, orgID, flowID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-    w.Header().Set("Content-Type", "application/json")
-    _ = json.NewEncoder(w).Encode(map[string]any{
+    render.OK(w, map[string]any{
         "access_token": token, "token_type": "bearer", "expires_in": 24 * 3600,
         "id": userID, "email": in.Email, "name": name, "org_id": orgID, "flow_id": flowID,
-        "tax_id": taxID,
+        "tax_id": taxID, "role": role, "email_verified": verified,
     })
 }
 
@@ -186,16 +218,15 @@ func (a *App) login(w http.ResponseWriter, r *http.Request) {
 func (a *App) refresh(w http.ResponseWriter, r *http.Request) {
 	uid, org, flow, err := extractUserFromToken(r)
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		render.Error(w, http.StatusUnauthorized, "invalid token")
 		return
 	}
 	token, err := generateToken(uid, org, flow)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{
+	render.OK(w, map[string]any{
 		"access_token": token, "token_type": "bearer", "expires_in": 24 * 3600,
 	})
 }
@@ -204,18 +235,19 @@ func (a *App) refresh(w http.ResponseWriter, r *http.Request) {
 func (a *App) me(w http.ResponseWriter, r *http.Request) {
 	uid, org, flow, err := extractUserFromToken(r)
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		render.Error(w, http.StatusUnauthorized, "invalid token")
 		return
 	}
-	var email, name string
+	var email, name, role string
+	var verified bool
 	if err := a.DB.QueryRow(r.Context(),
-		`SELECT email, name FROM users WHERE id=$1`, uid).Scan(&email, &name); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		`SELECT email, name, role, email_verified_at IS NOT NULL FROM users WHERE id=$1`, uid).Scan(&email, &name, &role, &verified); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"id": uid, "email": email, "name": name, "org_id": org, "flow_id": flow,
+	render.OK(w, map[string]any{
+		"id": uid, "email": email, "name": name, "org_id": org, "flow_id": flow, "role": role,
+		"email_verified": verified,
 	})
 }
 
@@ -247,7 +279,13 @@ func extractUserFromToken(r *http.Request) (int64, int64, int64, error) {
 	// jwtauth v5 com jwx/v2: Decode -> (jwt.Token, error)
 	tok, err := tokenAuth.Decode(raw)
 	if err != nil || tok == nil {
-		return 0, 0, 0, errors.New("invalid token")
+		prev := previousTokenAuth(time.Now())
+		if prev == nil {
+			return 0, 0, 0, errors.New("invalid token")
+		}
+		if tok, err = prev.Decode(raw); err != nil || tok == nil {
+			return 0, 0, 0, errors.New("invalid token")
+		}
 	}
 	// valida exp/iat
 	if err := jwxjwt.Validate(tok); err != nil {
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Campanhas: personalização de mensagens em lote
+// ================================================================
+//
+// POST /api/campaigns/personalize   gera uma variação da mensagem por lead
+// POST /api/campaigns/send          {"messages":[{"lead_id","text"}],"interval_seconds"}
+//
+// O envio vira um job campaign.send por lead (jobs.go), espaçados por
+// interval_seconds (padrão 3s) para não disparar tudo de uma vez pela
+// instância; cada job fala com o lead pela última conversa (leadWAContact).
+//
+// Ofertas relâmpago (prazo + quantidade limitada) ficam em flash_sales.go.
+
+const (
+	campaignMaxLeads       = 200
+	campaignMaxConcurrency = 10
+
+	jobCampaignSend = "campaign.send"
+)
+
+func (a *App) mountCampaigns(r chi.Router) {
+	registerJob(jobCampaignSend, jobPolicy{MaxAttempts: 4, Timeout: time.Minute}, a.runCampaignSend)
+	r.Post("/campaigns/personalize", a.campaignPersonalize)
+	r.Post("/campaigns/send", a.campaignSend)
+	a.mountFlashSales(r)
+}
+
+// campaignPersonalizeReq define o segmento de leads e as instruções usadas
+// para gerar uma variação de mensagem por lead.
+type campaignPersonalizeReq struct {
+	Instructions string  `json:"instructions"`          // objetivo/tom da campanha
+	BaseMessage  string  `json:"base_message"`          // texto de referência
+	LeadIDs      []int64 `json:"lead_ids,omitempty"`    // seleção explícita
+	Stage        string  `json:"stage,omitempty"`       // ou filtro por estágio
+	Source       string  `json:"source,omitempty"`      // e/ou origem
+	Limit        int     `json:"limit,omitempty"`       // máx. de leads (<= 200)
+	Concurrency  int     `json:"concurrency,omitempty"` // chamadas simultâneas (<= 10)
+	Model        string  `json:"model,omitempty"`
+}
+
+type campaignVariant struct {
+	LeadID int64  `json:"lead_id"`
+	Name   string `json:"name"`
+	Phone  string `json:"phone"`
+	Text   string `json:"text,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type campaignLead struct {
+	ID    int64
+	Name  string
+	Phone string
+	Stage string
+}
+
+// POST /api/campaigns/personalize
+func (a *App) campaignPersonalize(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var in campaignPersonalizeReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Instructions = strings.TrimSpace(in.Instructions)
+	in.BaseMessage = strings.TrimSpace(in.BaseMessage)
+	if in.Instructions == "" && in.BaseMessage == "" {
+		render.Error(w, http.StatusBadRequest, "instructions or base_message required")
+		return
+	}
+	if in.Limit <= 0 || in.Limit > campaignMaxLeads {
+		in.Limit = campaignMaxLeads
+	}
+	if in.Concurrency <= 0 {
+		in.Concurrency = 4
+	}
+	if in.Concurrency > campaignMaxConcurrency {
+		in.Concurrency = campaignMaxConcurrency
+	}
+	if !a.requireAIBudget(w, r, orgID) {
+		return
+	}
+	provider, model, err := a.llmFor(r.Context(), orgID, flowID) // ai_llm.go
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	model = nonEmpty(in.Model, model)
+
+	leads, err := a.campaignSegment(r.Context(), orgID, flowID, in)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	system := "Você escreve mensagens curtas de WhatsApp para campanhas de vendas. " +
+		"Personalize a mensagem para o lead indicado, mantendo o objetivo da campanha. " +
+		"Responda apenas com o texto final, sem aspas nem comentários."
+	if in.Instructions != "" {
+		system += "\nInstruções da campanha: " + in.Instructions
+	}
+
+	out := make([]campaignVariant, len(leads))
+	var (
+		mu               sync.Mutex
+		promptTokens     int
+		completionTokens int
+		costUSD          float64
+		usedModel        string
+		failed           int
+		wg               sync.WaitGroup
+	)
+	sem := make(chan struct{}, in.Concurrency)
+	for i, l := range leads {
+		wg.Add(1)
+		go func(i int, l campaignLead) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			v := campaignVariant{LeadID: l.ID, Name: l.Name, Phone: l.Phone}
+			user := "Lead: " + nonEmpty(l.Name, "cliente") + "\nEstágio: " + nonEmpty(l.Stage, "-")
+			if in.BaseMessage != "" {
+				user += "\nMensagem base: " + in.BaseMessage
+			}
+			resp, err := provider.Chat(r.Context(), openai.ChatCompletionRequest{
+				Model: model,
+				Messages: []openai.ChatCompletionMessage{
+					{Role: openai.ChatMessageRoleSystem, Content: system},
+					{Role: openai.ChatMessageRoleUser, Content: user},
+				},
+				Temperature: 0.7,
+			})
+			if err == nil {
+				a.recordAIUsage(orgID, flowID, "campaign", resp.Model, resp.Usage)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil || len(resp.Choices) == 0 {
+				v.Error = provider.Name() + " error"
+				if err != nil {
+					v.Error += ": " + err.Error()
+				}
+				failed++
+			} else {
+				v.Text = strings.TrimSpace(resp.Choices[0].Message.Content)
+				promptTokens += resp.Usage.PromptTokens
+				completionTokens += resp.Usage.CompletionTokens
+				costUSD += estimateCostUSD(resp.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+				usedModel = resp.Model
+			}
+			out[i] = v
+		}(i, l)
+	}
+	wg.Wait()
+
+	render.OK(w, map[string]any{
+		"items":  out,
+		"total":  len(out),
+		"failed": failed,
+		"usage": map[string]any{
+			"provider":           provider.Name(),
+			"model":              nonEmpty(usedModel, model),
+			"prompt_tokens":      promptTokens,
+			"completion_tokens":  completionTokens,
+			"estimated_cost_usd": costUSD,
+		},
+	})
+}
+
+// campaignSegment seleciona os leads do tenant pelos filtros da campanha.
+func (a *App) campaignSegment(ctx context.Context, orgID, flowID int64, in campaignPersonalizeReq) ([]campaignLead, error) {
+	rows, err := a.DB.Query(ctx, `
+		SELECT id, COALESCE(name,''), COALESCE(phone,''), COALESCE(stage,'')
+		  FROM leads
+		 WHERE org_id=$1 AND flow_id=$2
+		   AND (COALESCE(cardinality($3::bigint[]), 0) = 0 OR id = ANY($3))
+		   AND ($4 = '' OR LOWER(stage) = LOWER($4))
+		   AND ($5 = '' OR LOWER(source) = LOWER($5))
+		 ORDER BY created_at DESC
+		 LIMIT $6`,
+		orgID, flowID, in.LeadIDs, strings.TrimSpace(in.Stage), strings.TrimSpace(in.Source), in.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []campaignLead
+	for rows.Next() {
+		var l campaignLead
+		if err := rows.Scan(&l.ID, &l.Name, &l.Phone, &l.Stage); err != nil {
+			return nil, err
+		}
+		l.Name, l.Phone = revealPII(orgID, l.Name), revealPII(orgID, l.Phone)
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// campaignSendJob é o payload do job campaign.send.
+type campaignSendJob struct {
+	FlowID int64  `json:"flow_id"`
+	LeadID int64  `json:"lead_id"`
+	Text   string `json:"text"`
+}
+
+// POST /api/campaigns/send
+func (a *App) campaignSend(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		Messages []struct {
+			LeadID int64  `json:"lead_id"`
+			Text   string `json:"text"`
+		} `json:"messages"`
+		IntervalSeconds *int `json:"interval_seconds,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if len(in.Messages) == 0 || len(in.Messages) > campaignMaxLeads {
+		render.Error(w, http.StatusBadRequest, fmt.Sprintf("messages must have 1 to %d items", campaignMaxLeads))
+		return
+	}
+	interval := 3 * time.Second
+	if in.IntervalSeconds != nil {
+		if *in.IntervalSeconds < 0 || *in.IntervalSeconds > 3600 {
+			render.Error(w, http.StatusBadRequest, "interval_seconds must be between 0 and 3600")
+			return
+		}
+		interval = time.Duration(*in.IntervalSeconds) * time.Second
+	}
+	ids := make([]int64, 0, len(in.Messages))
+	for _, m := range in.Messages {
+		if m.LeadID <= 0 || strings.TrimSpace(m.Text) == "" {
+			render.Error(w, http.StatusBadRequest, "each message needs lead_id and text")
+			return
+		}
+	}
+	start := time.Now()
+	for i, m := range in.Messages {
+		id, err := a.enqueueJob(r.Context(), orgID, jobCampaignSend,
+			campaignSendJob{FlowID: flowID, LeadID: m.LeadID, Text: strings.TrimSpace(m.Text)},
+			start.Add(time.Duration(i)*interval))
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		ids = append(ids, id)
+	}
+	render.Accepted(w, map[string]any{"queued": len(ids), "job_ids": ids})
+}
+
+func (a *App) runCampaignSend(ctx context.Context, j job) error {
+	var m campaignSendJob
+	if err := j.decode(&m); err != nil {
+		return err
+	}
+	if j.OrgID == nil {
+		return permanentJobError(errors.New("campaign job without org"))
+	}
+	row, contact, err := a.leadWAContact(ctx, *j.OrgID, m.FlowID, m.LeadID)
+	if err != nil {
+		return permanentJobError(fmt.Errorf("lead %d: %w", m.LeadID, err))
+	}
+	_, status, err := a.sendWAText(ctx, row, row.Token, contact, m.Text)
+	// 429 e provedor fora do ar voltam para a fila; o resto do 4xx não adianta repetir
+	if err != nil && status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+		return permanentJobError(err)
+	}
+	return err
+}
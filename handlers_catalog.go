@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
 )
 
 // Product represents an item for sale. In addition to the original fields,
@@ -24,32 +27,59 @@ type Product struct {
     FlowID    int64     `json:"flow_id"`
     Title     string    `json:"title"`
     Slug      string    `json:"slug,omitempty"`
+    Description string  `json:"description,omitempty"`
     Status    string    `json:"status"`
     ImageBase64 string  `json:"-"`
     ImageURL  string    `json:"image_url,omitempty"`
+    ImageThumbURL string `json:"image_thumb_url,omitempty"`
+    ImageSizes    map[string]string `json:"image_sizes,omitempty"` // variantes do upload (image_resize.go)
     PriceCents int      `json:"price_cents,omitempty"`
     Stock     int      `json:"stock,omitempty"`
     Category  string   `json:"category,omitempty"`
+    Tags      []string `json:"tags,omitempty"`
+    VideoURL      string `json:"video_url,omitempty"`
+    VideoThumbURL string `json:"video_thumb_url,omitempty"`
+    Recurrence    string `json:"recurrence,omitempty"` // weekly | monthly (subscriptions.go)
+    StockPoolID   *int64 `json:"stock_pool_id,omitempty"` // estoque compartilhado (stock_pools.go)
+    AvailableFrom     *time.Time `json:"available_from,omitempty"` // janela de venda (product_schedule.go)
+    AvailableUntil    *time.Time `json:"available_until,omitempty"`
+    AvailableWeekdays []int      `json:"available_weekdays,omitempty"`
+    ArchivedAt *time.Time `json:"archived_at,omitempty"` // arquivo/lixeira (product_archive.go)
+    DeletedAt  *time.Time `json:"deleted_at,omitempty"`
     CreatedAt time.Time `json:"created_at"`
 }
 
 func (a *App) mountCatalog(r chi.Router) {
+	a.detectProductTrgm(context.Background()) // índice em migrations/0060
+	a.registerImageJobs() // miniaturas (image_resize.go)
 	r.Get("/products", a.listProducts)
-	r.Post("/products", a.createProduct)
+	r.Get("/products/suggest", a.suggestProducts)
+	r.Get("/products/search", a.searchProductsFTS) // full-text, ver product_search.go
+	r.With(a.idempotent).Post("/products", a.createProduct) // Idempotency-Key (idempotency.go)
+	r.With(a.concurrencyLimit("import", concurrencyMax("CONCURRENCY_IMPORT", 2))).Post("/products/import", a.importProducts) // CSV/XLSX, ver product_import.go
+	r.Get("/products/export", a.exportProducts)  // CSV, ver csv_export.go
 	r.Put("/products/{id}", a.updateProduct)
-	r.Delete("/products/{id}", a.deleteProduct)
+	r.With(a.requireRole(roleAdmin)).Delete("/products/{id}", a.deleteProduct) // lixeira, ver product_archive.go
+	r.Post("/products/{id}/video", a.uploadProductVideo)
 }
 
 func (a *App) listProducts(w http.ResponseWriter, r *http.Request) {
-	orgID, flowID, _ := tenantFromHeaders(r)
+	if csvWanted(r) {
+		a.exportProducts(w, r)
+		return
+	}
+	orgID, flowID, _ := tenantOf(r)
     rows, err := a.DB.Query(r.Context(),
-        `SELECT id,org_id,flow_id,title,slug,status,image_base64,price_cents,stock,category,created_at
+        `SELECT products.id,products.org_id,flow_id,title,COALESCE(slug,''),COALESCE(description,''),status,image_base64,price_cents,stock,category,tags,
+                COALESCE(video_url,''),COALESCE(video_thumb_url,''),COALESCE(image_thumb_url,''),COALESCE(recurrence,''),stock_pool_id,
+                available_from,available_until,available_weekdays,products.archived_at,products.deleted_at,products.created_at,v.sizes
          FROM products
-         WHERE org_id=$1 AND flow_id=$2
-         ORDER BY created_at DESC LIMIT 500`,
+         LEFT JOIN image_variants v ON v.url = products.image_base64
+         WHERE products.org_id=$1 AND flow_id=$2 AND `+productListFilter(r)+`
+         ORDER BY products.created_at DESC LIMIT 500`,
         orgID, flowID)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		render.Error(w, 500, err.Error())
 		return
 	}
 	defer rows.Close()
@@ -57,10 +87,13 @@ func (a *App) listProducts(w http.ResponseWriter, r *http.Request) {
     var out []Product
     for rows.Next() {
         var p Product
-        if err := rows.Scan(&p.ID, &p.OrgID, &p.FlowID, &p.Title, &p.Slug, &p.Status, &p.ImageBase64, &p.PriceCents, &p.Stock, &p.Category, &p.CreatedAt); err != nil {
-            http.Error(w, err.Error(), 500)
+        if err := rows.Scan(&p.ID, &p.OrgID, &p.FlowID, &p.Title, &p.Slug, &p.Description, &p.Status, &p.ImageBase64, &p.PriceCents, &p.Stock, &p.Category, &p.Tags, &p.VideoURL, &p.VideoThumbURL, &p.ImageThumbURL, &p.Recurrence, &p.StockPoolID, &p.AvailableFrom, &p.AvailableUntil, &p.AvailableWeekdays, &p.ArchivedAt, &p.DeletedAt, &p.CreatedAt, &p.ImageSizes); err != nil {
+            render.Error(w, 500, err.Error())
             return
         }
+        if p.ImageThumbURL == "" {
+            p.ImageThumbURL = p.ImageSizes["thumb"]
+        }
         // Expose image URL instead of the raw base64 contents. The
         // ImageBase64 column may already contain a URL (for newer entries) or
         // a plain base64 string (legacy). In either case we forward the value
@@ -70,8 +103,7 @@ func (a *App) listProducts(w http.ResponseWriter, r *http.Request) {
         p.ImageBase64 = ""
         out = append(out, p)
     }
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{"items": out})
+	render.OK(w, map[string]any{"items": out})
 }
 
 func (a *App) createProduct(w http.ResponseWriter, r *http.Request) {
@@ -85,33 +117,56 @@ func (a *App) createProduct(w http.ResponseWriter, r *http.Request) {
         FlowID      int64  `json:"flow_id"`
         Title       string `json:"title"`
         Slug        string `json:"slug"`
+        Description string `json:"description"`
         Status      string `json:"status"`
         ImageURL    string `json:"image_url"`
         ImageBase64 string `json:"image_base64"`
         PriceCents  int    `json:"price_cents"`
         Stock       int    `json:"stock"`
         Category    string `json:"category"`
+        Tags        []string `json:"tags"`
+        VideoURL    string `json:"video_url"`
     }
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "invalid json: "+err.Error(), 400)
+		render.Error(w, 400, "invalid json: "+err.Error())
 		return
 	}
 
+	// com JWT, o tenant do token prevalece sobre o body
+	if c, ok := claimsFromContext(r.Context()); ok {
+		in.OrgID, in.FlowID = c.OrgID, c.FlowID
+	}
 	// fallback para headers se não vier no body
 	if in.OrgID == 0 || in.FlowID == 0 {
-		orgID, flowID, err := tenantFromHeaders(r)
+		orgID, flowID, err := tenantOf(r)
 		if err == nil {
 			in.OrgID, in.FlowID = orgID, flowID
 		}
 	}
     if in.Title == "" {
-		http.Error(w, "title required", 400)
+		render.Error(w, 400, "title required")
 		return
 	}
     if in.Status == "" {
         in.Status = "active"
     }
 
+    // slug canônico (URL-safe) e único por org: o informado precisa estar
+    // livre; sem slug, gera a partir do título com sufixo se necessário
+    if in.Slug = slugify(in.Slug); in.Slug != "" {
+        if err := a.ensureProductSlugUnique(r.Context(), in.OrgID, in.Slug, 0); err != nil {
+            render.Error(w, http.StatusConflict, err.Error())
+            return
+        }
+    } else {
+        slug, err := a.uniqueProductSlug(r.Context(), in.OrgID, in.Title, 0)
+        if err != nil {
+            render.Error(w, 500, err.Error())
+            return
+        }
+        in.Slug = slug
+    }
+
     // If image_url is provided, use it as the value for image_base64 so
     // that we can reuse the existing image_base64 column without schema changes.
     if in.ImageBase64 == "" && in.ImageURL != "" {
@@ -122,12 +177,12 @@ func (a *App) createProduct(w http.ResponseWriter, r *http.Request) {
     var id int64
     var created time.Time
     err := a.DB.QueryRow(r.Context(),
-        `INSERT INTO products(org_id,flow_id,title,slug,status,image_base64,price_cents,stock,category)
-         VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
+        `INSERT INTO products(org_id,flow_id,title,slug,status,image_base64,price_cents,stock,category,video_url,description,tags)
+         VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,NULLIF($10,''),NULLIF($11,''),COALESCE($12,'{}'))
          RETURNING id,created_at`,
-        in.OrgID, in.FlowID, in.Title, in.Slug, in.Status, in.ImageBase64, in.PriceCents, in.Stock, in.Category).Scan(&id, &created)
+        in.OrgID, in.FlowID, in.Title, in.Slug, in.Status, in.ImageBase64, in.PriceCents, in.Stock, in.Category, in.VideoURL, in.Description, productTags(in.Tags)).Scan(&id, &created)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		render.Error(w, 500, err.Error())
 		return
 	}
 
@@ -137,29 +192,48 @@ func (a *App) createProduct(w http.ResponseWriter, r *http.Request) {
 		FlowID:    in.FlowID,
 		Title:     in.Title,
 		Slug:      in.Slug,
+		Description: in.Description,
 		Status:    in.Status,
+		Tags:      productTags(in.Tags),
+		VideoURL:  in.VideoURL,
 		CreatedAt: created,
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(p)
+	a.touchProductFeed(in.OrgID, in.FlowID)
+	// a miniatura sai do evento (registerImageJobs, image_resize.go)
+	a.publishEvent(r.Context(), in.OrgID, eventProductCreated, chatProduct{
+		ID: id, OrgID: in.OrgID, FlowID: in.FlowID, Title: in.Title, Slug: in.Slug, Status: in.Status,
+		ImageURL: in.ImageBase64, PriceCents: in.PriceCents, Stock: in.Stock, Category: in.Category,
+	})
+	render.OK(w, p)
 }
 
 func (a *App) updateProduct(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	orgID, flowID, _ := tenantOf(r)
     var in struct {
         Title       string `json:"title"`
         Slug        string `json:"slug"`
+        Description string `json:"description"`
         Status      string `json:"status"`
         ImageURL    string `json:"image_url"`
         ImageBase64 string `json:"image_base64"`
         PriceCents  *int   `json:"price_cents"`
         Stock       *int   `json:"stock"`
         Category    string `json:"category"`
+        Tags        []string `json:"tags"` // ausente mantém; [] limpa
+        VideoURL    string `json:"video_url"`
     }
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "invalid json: "+err.Error(), 400)
+		render.Error(w, 400, "invalid json: "+err.Error())
 		return
 	}
+    if in.Slug != "" {
+        in.Slug = slugify(in.Slug)
+        if err := a.ensureProductSlugUnique(r.Context(), orgID, in.Slug, id); err != nil {
+            render.Error(w, http.StatusConflict, err.Error())
+            return
+        }
+    }
     // If the caller sends image_url but not image_base64, use it for
     // image_base64 to preserve backwards compatibility with the existing
     // column. When both are provided, image_base64 takes precedence.
@@ -173,10 +247,14 @@ func (a *App) updateProduct(w http.ResponseWriter, r *http.Request) {
           slug=COALESCE(NULLIF($2,''),slug),
           status=COALESCE(NULLIF($3,''),status),
           image_base64=COALESCE(NULLIF($4,''),image_base64),
+          image_thumb_url=CASE WHEN NULLIF($4,'') IS NULL THEN image_thumb_url END,
           price_cents=COALESCE($5, price_cents),
           stock=COALESCE($6, stock),
-          category=COALESCE(NULLIF($7,''),category)
-      WHERE id=$8`
+          category=COALESCE(NULLIF($7,''),category),
+          video_url=COALESCE(NULLIF($10,''),video_url),
+          description=COALESCE(NULLIF($11,''),description),
+          tags=COALESCE($12,tags)
+      WHERE id=$8 AND org_id=$9 AND deleted_at IS NULL`
     var priceArg any
     if in.PriceCents != nil {
         priceArg = *in.PriceCents
@@ -191,20 +269,20 @@ func (a *App) updateProduct(w http.ResponseWriter, r *http.Request) {
     }
     _, err := a.DB.Exec(r.Context(), query,
         in.Title, in.Slug, in.Status, in.ImageBase64,
-        priceArg, stockArg, in.Category, id)
+        priceArg, stockArg, in.Category, id, orgID, in.VideoURL, in.Description, productTags(in.Tags))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		render.Error(w, 500, err.Error())
 		return
 	}
-	w.WriteHeader(204)
-}
-
-func (a *App) deleteProduct(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	_, err := a.DB.Exec(r.Context(), `DELETE FROM products WHERE id=$1`, id)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+	a.touchProductFeed(orgID, flowID)
+	if in.ImageBase64 != "" {
+		a.enqueueProductThumb(r.Context(), orgID, id, in.ImageBase64)
+	}
+	if in.Stock != nil {
+		// produto em pool compartilhado: o novo estoque vale para o pool (stock_pools.go)
+		if err := a.pushProductStockToPool(r.Context(), orgID, id); err != nil {
+			log.Printf("product %d stock pool: %v", id, err)
+		}
 	}
-	w.WriteHeader(204)
+	render.NoContent(w)
 }
@@ -1,25 +1,27 @@
 package main
 
 import (
-    "encoding/base64"
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
+    "log"
     "mime/multipart"
     "net/http"
     "os"
     "path/filepath"
     "strconv"
     "strings"
-    "sync"
     "time"
 
     "github.com/go-chi/chi/v5"
     openai "github.com/sashabaranov/go-openai"
+    "github.com/paclead/backend/render"
 )
 
 // ================================================================
-//  Estado em memória para produtos pendentes
+//  Produtos pendentes (persistidos em chat_pending_products)
 // ================================================================
 
 // productSuggest representa os dados sugeridos pela IA para um produto.
@@ -37,36 +39,15 @@ type pendingProduct struct {
     FlowID    int
     ImagePath string // caminho local onde o arquivo foi salvo
     ImageURL  string // URL pública (/uploads/...) para exibir no chat
+    Images    []pendingImage // todas as fotos, na ordem de envio (a primeira é a capa)
     Suggest   productSuggest
 }
 
-// pendBySession armazena os produtos pendentes indexados por sessionId. É
-// protegido por pendMu para acesso concorrente.
-var (
-    pendMu       sync.Mutex
-    pendBySession = make(map[string]*pendingProduct)
-)
-
-func setPending(session string, p *pendingProduct) {
-    pendMu.Lock()
-    defer pendMu.Unlock()
-    if session == "" {
-        return
-    }
-    pendBySession[session] = p
-}
-
-func getPending(session string) (*pendingProduct, bool) {
-    pendMu.Lock()
-    defer pendMu.Unlock()
-    p, ok := pendBySession[session]
-    return p, ok
-}
-
-func clearPending(session string) {
-    pendMu.Lock()
-    defer pendMu.Unlock()
-    delete(pendBySession, session)
+// pendingImage é uma foto já salva e aprovada pelo antivírus.
+type pendingImage struct {
+    Path  string            `json:"path"`
+    URL   string            `json:"url"`
+    Sizes map[string]string `json:"sizes,omitempty"` // thumb/medium/large/original (image_resize.go)
 }
 
 // ================================================================
@@ -77,8 +58,19 @@ func clearPending(session string) {
 // vision/upload agora cria pendências para produtos. O endpoint chat
 // trata preços pendentes e conversa normal.
 func (a *App) mountChat(r chi.Router) {
-    r.Post("/chat", a.chatHandler)
-    r.Post("/vision/upload", a.visionUpload)
+    go a.pendingCleanupLoop()
+
+    // vagas simultâneas por réplica (concurrency_limit.go)
+    r.With(a.concurrencyLimit("chat", concurrencyMax("CONCURRENCY_CHAT", 32))).Post("/chat", a.chatHandler)
+    r.Post("/chat/sessions", a.createChatSession) // chat_sessions.go
+    r.Delete("/chat/sessions/{id}", a.endChatSession)
+    r.Get("/chat/sessions/{id}/messages", a.chatSessionMessages)
+    r.Get("/chat/ws", a.chatWebSocket) // gateway WebSocket (streaming)
+    r.With(a.concurrencyLimit("vision", concurrencyMax("CONCURRENCY_VISION", 8))).Post("/vision/upload", a.visionUpload)
+    // análise assíncrona (vision_jobs.go)
+    registerJob(jobVisionAnalyze, jobPolicy{MaxAttempts: 3, Timeout: 3 * time.Minute}, a.runVisionAnalyze)
+    r.Get("/vision/jobs/{id}", a.getVisionJob)
+    r.Get("/vision/jobs/{id}/events", a.visionJobEvents)
 }
 
 // chatReq representa o payload recebido em /api/chat. Inclui o message,
@@ -88,112 +80,250 @@ type chatReq struct {
     Message   string `json:"message"`
     System    string `json:"system,omitempty"`
     SessionID string `json:"sessionId,omitempty"`
-    History   []struct {
-        Role    string `json:"role"`
-        Content string `json:"content"`
-    } `json:"history,omitempty"`
-    Timestamp string `json:"timestamp,omitempty"`
+    History   []chatTurn `json:"history,omitempty"`
+    Timestamp string     `json:"timestamp,omitempty"`
+}
+
+// chatTurn é uma mensagem do histórico (enviada pelo cliente ou recarregada
+// de chat_messages).
+type chatTurn struct {
+    Role    string `json:"role"`
+    Content string `json:"content"`
 }
 
 // chatHandler atende /api/chat. Se houver um produto pendente para o
 // sessionId e o usuário enviar um preço, cria o produto na base e
 // responde informando. Caso contrário, repassa a mensagem para a IA.
 func (a *App) chatHandler(w http.ResponseWriter, r *http.Request) {
-    apiKey := os.Getenv("OPENAI_API_KEY")
-    if apiKey == "" {
-        http.Error(w, "OPENAI_API_KEY not set", http.StatusInternalServerError)
-        return
-    }
-    model := getenv("TEXT_MODEL", "gpt-4o-mini")
-
     var in chatReq
     if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-        http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
         return
     }
     in.Message = strings.TrimSpace(in.Message)
     if in.Message == "" {
-        http.Error(w, "message required", http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "message required")
         return
     }
 
-    // Se há pendência para esta sessão e a mensagem contém um preço,
-    // processa a criação do produto.
-    if p, ok := getPending(in.SessionID); ok {
-        if cents, okp := parsePriceToCents(in.Message); okp {
-            // lê org/flow do cabeçalho ou fallback para pendência
-            orgID := mustAtoi(strings.TrimSpace(r.Header.Get("X-Org-ID")))
-            flowID := mustAtoi(strings.TrimSpace(r.Header.Get("X-Flow-ID")))
-            if orgID <= 0 {
-                orgID = p.OrgID
-            }
-            if flowID <= 0 {
-                flowID = p.FlowID
-            }
-            if orgID <= 0 {
-                orgID = 1
-            }
-            if flowID <= 0 {
-                flowID = 1
-            }
-
-            // monta slug usando description ou tags
-            slug := firstNonEmpty(p.Suggest.Description, strings.Join(p.Suggest.Tags, ", "))
-
-            row := a.DB.QueryRow(r.Context(), `
-                INSERT INTO products (org_id, flow_id, title, slug, status, image_base64, price_cents, stock, category)
-                VALUES ($1,$2,$3,$4,'active',$5,$6,0,$7)
-                RETURNING id, org_id, flow_id, title, slug, status, image_base64, price_cents, stock, category
-            `,
-                orgID, flowID,
-                limitRunes(p.Suggest.Title, 60),
-                limitRunes(slug, 300),
-                p.ImageURL,
-                cents,
-                limitRunes(p.Suggest.Category, 80),
-            )
-
-            var prod struct {
-                ID         int64  `json:"id"`
-                OrgID      int64  `json:"org_id"`
-                FlowID     int64  `json:"flow_id"`
-                Title      string `json:"title"`
-                Slug       string `json:"slug"`
-                Status     string `json:"status"`
-                ImageURL   string `json:"image_url"`
-                PriceCents int    `json:"price_cents"`
-                Stock      int    `json:"stock"`
-                Category   string `json:"category"`
-            }
-            if err := row.Scan(&prod.ID, &prod.OrgID, &prod.FlowID, &prod.Title, &prod.Slug, &prod.Status, &prod.ImageURL, &prod.PriceCents, &prod.Stock, &prod.Category); err != nil {
-                http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
-                return
-            }
-
-            // limpa a pendência
-            clearPending(in.SessionID)
-
-            msg := fmt.Sprintf("✅ Produto **%s** cadastrado por R$ %.2f.\nCategoria: %s\nImagem: %s",
-                prod.Title, float64(prod.PriceCents)/100.0, prod.Category, prod.ImageURL)
-
-            writeJSON(w, map[string]any{
-                "ok":      true,
-                "reply":   msg,
-                "product": prod,
-            })
+    // Se há pendência para esta sessão, tenta concluir o cadastro com o preço
+    // informado (ou pede o preço novamente).
+    t, _ := tenantFrom(r.Context()) // tenantAllowHeaders (main.go)
+    orgID, flowID := int(t.OrgID), int(t.FlowID)
+    if !a.requireChatSession(w, r, in.SessionID, t.OrgID, t.FlowID) {
+        return
+    }
+    if reply, prod, handled, err := a.completePending(r.Context(), in.SessionID, orgID, flowID, in.Message); handled {
+        if err != nil {
+            render.Error(w, http.StatusInternalServerError, "db insert error: "+err.Error())
             return
         }
-        // existe pendência mas não identificamos preço
-        writeJSON(w, map[string]any{
-            "ok":    true,
-            "reply": "Por favor, informe o preço no formato 12,34 ou 12.34 (ex.: 129,90).",
-        })
+        a.saveChatTurn(r.Context(), in.SessionID, orgID, flowID, in.Message, reply, "")
+        extra := map[string]any{}
+        if prod != nil {
+            extra["product"] = prod
+        }
+        renderChatReply(w, r, reply, extra, false) // chat_envelope.go
+        return
+    }
+
+    // Resposta ao resumo de um rascunho de pedido ("confirmo"/"cancelar")
+    if reply, order, handled, err := a.completeOrderDraft(r.Context(), in.SessionID, int64(orgID), int64(flowID), in.Message); handled {
+        if err != nil {
+            render.Error(w, http.StatusInternalServerError, "order error: "+err.Error())
+            return
+        }
+        a.saveChatTurn(r.Context(), in.SessionID, orgID, flowID, in.Message, reply, "")
+        extra := map[string]any{}
+        if order != nil {
+            extra["order"] = order
+        }
+        renderChatReply(w, r, reply, extra, false)
         return
     }
 
-    // Sem pendência: fluxo normal de chat
-    client := openai.NewClient(apiKey)
+    // Sem pendência: fluxo normal de chat (histórico salvo se o cliente não enviar)
+    if !a.requireAIBudget(w, r, int64(orgID)) {
+        return
+    }
+    provider, model, err := a.llmFor(r.Context(), int64(orgID), int64(flowID)) // ai_llm.go
+    if err != nil {
+        render.Error(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    a.withStoredHistory(r.Context(), &in, orgID, flowID)
+    a.withAgentPrompt(r.Context(), &in, orgID, flowID) // agent_prompt.go
+    a.withKnowledge(r.Context(), &in, orgID, flowID) // agent_knowledge.go
+
+    req := openai.ChatCompletionRequest{
+        Model:    model,
+        Messages: buildChatMessages(in),
+    }
+    var resp openai.ChatCompletionResponse
+    if orgID > 0 && flowID > 0 {
+        // com tenant conhecido, o modelo pode consultar catálogo/estoque (chat_tools.go)
+        resp, err = a.runChatWithTools(r.Context(), provider, req, int64(orgID), int64(flowID), in.SessionID)
+    } else {
+        resp, err = provider.Chat(r.Context(), req)
+        if err == nil {
+            a.recordAIUsage(int64(orgID), int64(flowID), "chat", resp.Model, resp.Usage)
+        }
+    }
+    if err != nil || len(resp.Choices) == 0 {
+        msg := "empty response"
+        if err != nil {
+            msg = err.Error()
+        }
+        render.Error(w, http.StatusBadGateway, provider.Name()+" error: "+msg)
+        return
+    }
+    text := strings.TrimSpace(resp.Choices[0].Message.Content)
+    a.saveChatTurn(r.Context(), in.SessionID, orgID, flowID, in.Message, text, resp.Model)
+    // legacy repete o texto em message/text/content/choices; compact não
+    extra := map[string]any{}
+    if chatFormatOf(r) == chatFormatCompact {
+        extra["model"] = resp.Model
+    }
+    renderChatReply(w, r, text, extra, true)
+}
+
+// chatProduct é o produto devolvido ao cliente quando uma pendência é
+// concluída pelo chat.
+type chatProduct struct {
+    ID         int64  `json:"id"`
+    OrgID      int64  `json:"org_id"`
+    FlowID     int64  `json:"flow_id"`
+    Title      string `json:"title"`
+    Slug       string `json:"slug"`
+    Status     string `json:"status"`
+    ImageURL   string   `json:"image_url"`
+    Images     []string `json:"images,omitempty"` // todas as fotos, por posição
+    PriceCents int      `json:"price_cents"`
+    Stock      int      `json:"stock"`
+    Category   string   `json:"category"`
+}
+
+// productCataloged é o payload do evento product.cataloged: o produto criado
+// pelo fluxo visão + preço, a sugestão da IA que o originou e as fotos com as
+// variantes, para o tenant espelhar o item no ERP/n8n (webhooks_out.go).
+type productCataloged struct {
+    Product    chatProduct      `json:"product"`
+    Suggestion productSuggest   `json:"suggestion"`
+    ImageURL   string           `json:"image_url"`
+    Images     []catalogedImage `json:"images"`
+    SessionID  string           `json:"session_id"`
+    Source     string           `json:"source"` // "vision"
+}
+
+// catalogedImage é a pendingImage sem o caminho local do servidor.
+type catalogedImage struct {
+    URL   string            `json:"url"`
+    Sizes map[string]string `json:"sizes,omitempty"`
+}
+
+func newProductCataloged(sessionID string, prod chatProduct, p pendingProduct) productCataloged {
+    ev := productCataloged{Product: prod, Suggestion: p.Suggest, ImageURL: prod.ImageURL, SessionID: sessionID, Source: "vision", Images: []catalogedImage{}}
+    for _, img := range p.Images {
+        ev.Images = append(ev.Images, catalogedImage{URL: img.URL, Sizes: img.Sizes})
+    }
+    if len(ev.Images) == 0 && prod.ImageURL != "" {
+        ev.Images = append(ev.Images, catalogedImage{URL: prod.ImageURL})
+    }
+    return ev
+}
+
+// completePending trata a mensagem quando há um produto pendente para a
+// sessão. handled=false indica que não há pendência e a mensagem deve seguir
+// para a IA. Se a mensagem contém um preço, cria o produto e limpa a
+// pendência; caso contrário devolve apenas o pedido de preço.
+func (a *App) completePending(ctx context.Context, sessionID string, orgID, flowID int, message string) (string, *chatProduct, bool, error) {
+    p, ok, err := a.getPending(ctx, sessionID, orgID, flowID)
+    if err != nil {
+        return "", nil, true, err
+    }
+    if !ok {
+        return "", nil, false, nil
+    }
+    cents, okp := parsePriceToCents(message)
+    if !okp {
+        // existe pendência mas não identificamos preço
+        return "Por favor, informe o preço no formato 12,34 ou 12.34 (ex.: 129,90).", nil, true, nil
+    }
 
+    // org/flow do chamador ou fallback para pendência
+    if orgID <= 0 {
+        orgID = p.OrgID
+    }
+    if flowID <= 0 {
+        flowID = p.FlowID
+    }
+    if orgID <= 0 {
+        orgID = 1
+    }
+    if flowID <= 0 {
+        flowID = 1
+    }
+
+    // slug gerado do título (único na org); sem descrição da IA, as tags
+    // servem de descrição (e vão também para tags, usadas na busca)
+    title := limitRunes(p.Suggest.Title, 60)
+    slug, err := a.uniqueProductSlug(ctx, int64(orgID), title, 0)
+    if err != nil {
+        return "", nil, true, err
+    }
+    desc := firstNonEmpty(p.Suggest.Description, strings.Join(p.Suggest.Tags, ", "))
+
+    row := a.DB.QueryRow(ctx, `
+        INSERT INTO products (org_id, flow_id, title, slug, description, status, image_base64, price_cents, stock, category, tags)
+        VALUES ($1,$2,$3,$4,NULLIF($5,''),'active',$6,$7,0,$8,COALESCE($9,'{}'))
+        RETURNING id, org_id, flow_id, title, slug, status, image_base64, price_cents, stock, category
+    `,
+        orgID, flowID,
+        title,
+        slug,
+        limitRunes(desc, 1000),
+        p.ImageURL,
+        cents,
+        limitRunes(p.Suggest.Category, 80),
+        productTags(p.Suggest.Tags),
+    )
+
+    var prod chatProduct
+    if err := row.Scan(&prod.ID, &prod.OrgID, &prod.FlowID, &prod.Title, &prod.Slug, &prod.Status, &prod.ImageURL, &prod.PriceCents, &prod.Stock, &prod.Category); err != nil {
+        return "", nil, true, err
+    }
+
+    // galeria: todas as fotos da pendência em product_images, na ordem de envio
+    for i, img := range p.Images {
+        if _, err := a.DB.Exec(ctx, `
+            INSERT INTO product_images (org_id, product_id, url, position)
+            VALUES ($1,$2,$3,$4)
+            ON CONFLICT (product_id, position) DO NOTHING`,
+            prod.OrgID, prod.ID, img.URL, i); err != nil {
+            log.Printf("product %d image %d: %v", prod.ID, i, err)
+            continue
+        }
+        prod.Images = append(prod.Images, img.URL)
+    }
+
+    // limpa a pendência
+    if err := a.clearPending(ctx, sessionID, p.OrgID, p.FlowID); err != nil {
+        log.Printf("clear pending session=%s: %v", sessionID, err)
+    }
+    a.publishEvent(ctx, prod.OrgID, eventProductCreated, prod)
+    a.publishEvent(ctx, prod.OrgID, eventProductCataloged, newProductCataloged(sessionID, prod, *p))
+
+    msg := fmt.Sprintf("✅ Produto **%s** cadastrado por R$ %.2f.\nCategoria: %s\nImagem: %s",
+        prod.Title, float64(prod.PriceCents)/100.0, prod.Category, prod.ImageURL)
+    if len(prod.Images) > 1 {
+        msg += fmt.Sprintf(" (+%d fotos)", len(prod.Images)-1)
+    }
+    return msg, &prod, true, nil
+}
+
+// buildChatMessages monta a lista de mensagens (system + histórico + mensagem
+// atual) enviada ao modelo.
+func buildChatMessages(in chatReq) []openai.ChatCompletionMessage {
     var msgs []openai.ChatCompletionMessage
     if s := strings.TrimSpace(in.System); s != "" {
         msgs = append(msgs, openai.ChatCompletionMessage{
@@ -215,93 +345,219 @@ func (a *App) chatHandler(w http.ResponseWriter, r *http.Request) {
         Role:    openai.ChatMessageRoleUser,
         Content: in.Message,
     })
-
-    resp, err := client.CreateChatCompletion(r.Context(), openai.ChatCompletionRequest{
-        Model:    model,
-        Messages: msgs,
-    })
-    if err != nil || len(resp.Choices) == 0 {
-        http.Error(w, "openai error: "+err.Error(), http.StatusBadGateway)
-        return
-    }
-    text := strings.TrimSpace(resp.Choices[0].Message.Content)
-    writeJSON(w, map[string]any{
-        "ok":      true,
-        "reply":   text,
-        "message": text,
-        "text":    text,
-        "content": text,
-        "choices": []map[string]any{
-            {"message": map[string]any{"content": text}},
-        },
-    })
+    return msgs
 }
 
 // ================================================================
-//  visionUpload: analisa imagem, sugere produto e pede preço
+//  visionUpload: analisa imagens, sugere produto e pede preço
 // ================================================================
 
-// visionUpload recebe uma imagem, utiliza a IA de visão para sugerir
-// dados de produto (nome, descrição, categoria, tags), salva a imagem
-// em /uploads e registra uma pendência aguardando o preço.
+// visionMaxImages limita as fotos por produto pendente (VISION_MAX_IMAGES,
+// padrão 8); só as primeiras visionPromptImages vão para a IA.
+const visionPromptImages = 4
+
+func visionMaxImages() int {
+    n, err := strconv.Atoi(getenv("VISION_MAX_IMAGES", "8"))
+    if err != nil || n <= 0 {
+        return 8
+    }
+    return n
+}
+
+// visionFile é uma foto recebida no upload, já salva e verificada.
+type visionFile struct {
+    pendingImage
+    dataURL string
+    scan    scanResult
+}
+
+// visionUpload recebe uma ou mais imagens (campos "image" e/ou "images"),
+// utiliza a IA de visão para sugerir dados de produto (nome, descrição,
+// categoria, tags), salva as imagens em /uploads e registra uma pendência
+// aguardando o preço. Com append=true as imagens são apenas acrescentadas à
+// pendência já existente da sessão, sem nova chamada à IA.
 func (a *App) visionUpload(w http.ResponseWriter, r *http.Request) {
-    apiKey := os.Getenv("OPENAI_API_KEY")
-    if apiKey == "" {
-        http.Error(w, "OPENAI_API_KEY not set", http.StatusInternalServerError)
+    t, _ := tenantFrom(r.Context())
+    orgHdr, flowHdr := t.OrgID, t.FlowID
+
+    // limites configuráveis por implantação (vision_limits.go)
+    r.Body = http.MaxBytesReader(w, r.Body, visionUploadMaxBytes())
+    if err := r.ParseMultipartForm(8 << 20); err != nil {
+        var tooBig *http.MaxBytesError
+        if errors.As(err, &tooBig) {
+            render.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds %d MB", visionUploadMaxBytes()>>20))
+            return
+        }
+        render.Error(w, http.StatusBadRequest, "multipart parse error: "+err.Error())
+        return
+    }
+    var hdrs []*multipart.FileHeader
+    if r.MultipartForm != nil {
+        hdrs = append(hdrs, r.MultipartForm.File["image"]...)
+        hdrs = append(hdrs, r.MultipartForm.File["images"]...)
+    }
+    if len(hdrs) == 0 {
+        render.Error(w, http.StatusBadRequest, "image file required")
         return
     }
-    model := getenv("VISION_MODEL", "gpt-4o")
+    if len(hdrs) > visionMaxImages() {
+        render.Error(w, http.StatusBadRequest, fmt.Sprintf("at most %d images per product", visionMaxImages()))
+        return
+    }
+    for _, h := range hdrs {
+        if h.Size > imageMaxFileBytes() {
+            render.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s exceeds %d MB", h.Filename, imageMaxFileBytes()>>20))
+            return
+        }
+    }
 
-    if err := r.ParseMultipartForm(20 << 20); err != nil {
-        http.Error(w, "multipart parse error: "+err.Error(), http.StatusBadRequest)
+    // sessão e prompts opcionais
+    sessionID := strings.TrimSpace(r.FormValue("sessionId"))
+    nameHint := strings.TrimSpace(r.FormValue("prompt"))
+    appendMode, _ := strconv.ParseBool(r.FormValue("append"))
+    if !a.requireChatSession(w, r, sessionID, orgHdr, flowHdr) {
         return
     }
-    file, hdr, err := r.FormFile("image")
-    if err != nil {
-        http.Error(w, "image file required", http.StatusBadRequest)
+
+    // captura org/flow da requisição para quando formos criar o produto
+    orgID, flowID := int(orgHdr), int(flowHdr)
+    if orgID <= 0 {
+        orgID = 1
+    }
+    if flowID <= 0 {
+        flowID = 1
+    }
+
+    // mais fotos para a pendência da sessão: valida antes de gravar qualquer arquivo
+    var pending *pendingProduct
+    if appendMode {
+        if sessionID == "" {
+            render.Error(w, http.StatusBadRequest, "sessionId required to append images")
+            return
+        }
+        p, ok, err := a.getPending(r.Context(), sessionID, orgID, flowID)
+        if err != nil {
+            render.Error(w, http.StatusInternalServerError, "load pending error: "+err.Error())
+            return
+        }
+        if !ok {
+            render.Error(w, http.StatusNotFound, "no pending product for this session")
+            return
+        }
+        if len(p.Images)+len(hdrs) > visionMaxImages() {
+            render.Error(w, http.StatusBadRequest, fmt.Sprintf("at most %d images per product", visionMaxImages()))
+            return
+        }
+        pending = p
+    } else if !a.requireAIBudget(w, r, orgHdr) {
+        return
+    }
+
+    files, ok := a.saveVisionFiles(w, r, orgHdr, hdrs)
+    if !ok {
+        return
+    }
+    urls := make([]string, 0, len(files))
+    scans := make([]scanResult, 0, len(files))
+    for _, f := range files {
+        urls = append(urls, f.URL)
+        scans = append(scans, f.scan)
+    }
+
+    if pending != nil {
+        for _, f := range files {
+            pending.Images = append(pending.Images, f.pendingImage)
+        }
+        if err := a.setPending(r.Context(), sessionID, pending); err != nil {
+            render.Error(w, http.StatusInternalServerError, "save pending error: "+err.Error())
+            return
+        }
+        all := make([]string, 0, len(pending.Images))
+        for _, img := range pending.Images {
+            all = append(all, img.URL)
+        }
+        render.OK(w, map[string]any{
+            "ok":        true,
+            "reply":     fmt.Sprintf("Adicionei %d foto(s) a **%s**. Me diga o preço (ex.: 129,90) que eu já cadastro.", len(files), limitRunes(pending.Suggest.Title, 60)),
+            "image_url": pending.ImageURL,
+            "images":    all,
+            "suggest":   pending.Suggest,
+            "scans":     scans,
+        })
+        return
+    }
+
+    // análise em segundo plano: responde 202 com o job (vision_jobs.go)
+    if visionAsync(r) {
+        a.enqueueVisionJob(w, r, sessionID, orgID, flowID, nameHint, files)
         return
     }
-    defer file.Close()
 
-    raw, err := io.ReadAll(file)
+    dataURLs := make([]string, 0, len(files))
+    images := make([]pendingImage, 0, len(files))
+    for _, f := range files {
+        dataURLs = append(dataURLs, f.dataURL)
+        images = append(images, f.pendingImage)
+    }
+    sug, err := a.visionSuggest(r.Context(), orgHdr, flowHdr, dataURLs, nameHint)
     if err != nil {
-        http.Error(w, "read file error: "+err.Error(), http.StatusBadRequest)
+        render.Error(w, http.StatusBadGateway, err.Error())
         return
     }
-    mime := contentTypeFromHeader(hdr)
-    if !strings.HasPrefix(mime, "image/") {
-        mime = "image/png"
+    out, err := a.finishVision(r.Context(), sessionID, orgID, flowID, images, scans, sug)
+    if err != nil {
+        render.Error(w, http.StatusInternalServerError, "save pending error: "+err.Error())
+        return
     }
-    dataURL := "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(raw)
+    render.OK(w, out)
+}
 
-    // sessão e prompts opcionais
-    sessionID := strings.TrimSpace(r.FormValue("sessionId"))
-    nameHint := strings.TrimSpace(r.FormValue("prompt"))
+// visionSuggest pede ao modelo de visão título, descrição, categoria e tags
+// a partir das imagens (data URLs); só as primeiras visionPromptImages vão.
+func (a *App) visionSuggest(ctx context.Context, orgID, flowID int64, dataURLs []string, nameHint string) (productSuggest, error) {
+    // modelo vazio: o provedor usa o seu modelo de visão (VISION_MODEL etc.)
+    provider, _, err := a.llmFor(ctx, orgID, flowID)
+    if err != nil {
+        return productSuggest{}, err
+    }
 
     // construímos o prompt para gerar JSON estrito
     prompt := "Você é um assistente de catalogação de e-commerce. Gere APENAS um JSON com os campos: " +
         `{"title": string (máx 60 chars), "description": string (150-300 chars), "category": string, "tags": string[]}` +
         ". Sem comentários, sem markdown, sem texto extra. Se a imagem não for clara, dê um título genérico."
+    if len(dataURLs) > 1 {
+        prompt += " As imagens são fotos do mesmo produto."
+    }
 
-    client := openai.NewClient(apiKey)
+    parts := []openai.ChatMessagePart{
+        {Type: openai.ChatMessagePartTypeText, Text: prompt + "\nDica: " + nameHint},
+    }
+    for i, u := range dataURLs {
+        if i == visionPromptImages {
+            break
+        }
+        parts = append(parts, openai.ChatMessagePart{
+            Type: openai.ChatMessagePartTypeImageURL,
+            ImageURL: &openai.ChatMessageImageURL{URL: u},
+        })
+    }
     msg := openai.ChatCompletionMessage{
         Role: openai.ChatMessageRoleUser,
-        MultiContent: []openai.ChatMessagePart{
-            {Type: openai.ChatMessagePartTypeText, Text: prompt + "\nDica: " + nameHint},
-            {
-                Type: openai.ChatMessagePartTypeImageURL,
-                ImageURL: &openai.ChatMessageImageURL{URL: dataURL},
-            },
-        },
-    }
-    resp, err := client.CreateChatCompletion(r.Context(), openai.ChatCompletionRequest{
-        Model:       model,
+        MultiContent: parts,
+    }
+    resp, err := provider.Chat(ctx, openai.ChatCompletionRequest{
         Messages:    []openai.ChatCompletionMessage{msg},
         Temperature: 0.2,
     })
+    if err == nil {
+        a.recordAIUsage(orgID, flowID, "vision", resp.Model, resp.Usage)
+    }
     if err != nil || len(resp.Choices) == 0 {
-        http.Error(w, "openai error: "+err.Error(), http.StatusBadGateway)
-        return
+        msg := "empty response"
+        if err != nil {
+            msg = err.Error()
+        }
+        return productSuggest{}, errors.New(provider.Name() + " error: " + msg)
     }
     // tenta parsear JSON estrito
     var sug productSuggest
@@ -313,39 +569,22 @@ func (a *App) visionUpload(w http.ResponseWriter, r *http.Request) {
             sug.Category = "Geral"
         }
     }
+    return sug, nil
+}
 
-    // salva imagem em uploads
-    uploadDir := getenv("UPLOAD_DIR", "uploads")
-    if err := os.MkdirAll(uploadDir, 0o755); err != nil {
-        http.Error(w, "create upload dir error: "+err.Error(), http.StatusInternalServerError)
-        return
-    }
-    filename := fmt.Sprintf("prod_%d%s", time.Now().UnixNano(), guessExt(mime))
-    dst := filepath.Join(uploadDir, filename)
-    if err := os.WriteFile(dst, raw, 0o644); err != nil {
-        http.Error(w, "save file error: "+err.Error(), http.StatusInternalServerError)
-        return
-    }
-    publicURL := "/uploads/" + filename
-
-    // captura org/flow dos headers para quando formos criar o produto
-    orgID := mustAtoi(strings.TrimSpace(r.Header.Get("X-Org-ID")))
-    flowID := mustAtoi(strings.TrimSpace(r.Header.Get("X-Flow-ID")))
-    if orgID <= 0 {
-        orgID = 1
-    }
-    if flowID <= 0 {
-        flowID = 1
-    }
-
-    // registra pendência
-    setPending(sessionID, &pendingProduct{
+// finishVision registra a pendência da sessão (substitui a anterior, se
+// houver) e monta a resposta do upload.
+func (a *App) finishVision(ctx context.Context, sessionID string, orgID, flowID int, images []pendingImage, scans []scanResult, sug productSuggest) (map[string]any, error) {
+    if err := a.setPending(ctx, sessionID, &pendingProduct{
         OrgID:     orgID,
         FlowID:    flowID,
-        ImagePath: dst,
-        ImageURL:  publicURL,
+        ImagePath: images[0].Path,
+        ImageURL:  images[0].URL,
+        Images:    images,
         Suggest:   sug,
-    })
+    }); err != nil {
+        return nil, err
+    }
 
     text := fmt.Sprintf(
         "Sugeri **%s**.\nDescrição: %s\nCategoria: %s\nMe diga o preço (ex.: 129,90) que eu já cadastro.",
@@ -353,13 +592,81 @@ func (a *App) visionUpload(w http.ResponseWriter, r *http.Request) {
         limitRunes(sug.Description, 280),
         limitRunes(sug.Category, 80),
     )
+    urls := make([]string, 0, len(images))
+    for _, img := range images {
+        urls = append(urls, img.URL)
+    }
+    out := map[string]any{
+        "ok":        true,
+        "reply":     text,
+        "image_url": images[0].URL,
+        "sizes":     images[0].Sizes,
+        "images":    urls,
+        "suggest":   sug,
+        "scans":     scans,
+    }
+    if len(scans) > 0 {
+        out["scan"] = scans[0]
+    }
+    return out, nil
+}
 
-    writeJSON(w, map[string]any{
-        "ok":       true,
-        "reply":    text,
-        "image_url": publicURL,
-        "suggest":  sug,
-    })
+// saveVisionFiles grava cada arquivo em uploads, passa pelo antivírus e pelo
+// driver de armazenamento. Em caso de erro já respondeu e devolve ok=false.
+func (a *App) saveVisionFiles(w http.ResponseWriter, r *http.Request, orgID int64, hdrs []*multipart.FileHeader) ([]visionFile, bool) {
+    uploadDir := getenv("UPLOAD_DIR", "uploads")
+    if err := os.MkdirAll(uploadDir, 0o755); err != nil {
+        render.Error(w, http.StatusInternalServerError, "create upload dir error: "+err.Error())
+        return nil, false
+    }
+    out := make([]visionFile, 0, len(hdrs))
+    for i, hdr := range hdrs {
+        file, err := hdr.Open()
+        if err != nil {
+            render.Error(w, http.StatusBadRequest, "read file error: "+err.Error())
+            return nil, false
+        }
+        raw, err := io.ReadAll(file)
+        file.Close()
+        if err != nil {
+            render.Error(w, http.StatusBadRequest, "read file error: "+err.Error())
+            return nil, false
+        }
+        mime := contentTypeFromHeader(hdr)
+        if !strings.HasPrefix(mime, "image/") {
+            mime = "image/png"
+        }
+
+        filename := fmt.Sprintf("prod_%d_%d%s", time.Now().UnixNano(), i, guessExt(mime))
+        dst := filepath.Join(uploadDir, filename)
+        if err := os.WriteFile(dst, raw, 0o644); err != nil {
+            render.Error(w, http.StatusInternalServerError, "save file error: "+err.Error())
+            return nil, false
+        }
+
+        // antivírus antes de enviar a imagem para a IA
+        scan := a.scanFile(r.Context(), orgID, "vision", dst)
+        if !scan.Accepted() {
+            render.APIError(w, http.StatusUnprocessableEntity, "file_rejected", "file rejected by antivirus", map[string]any{"file": hdr.Filename, "scan": scan})
+            return nil, false
+        }
+        // variantes antes de o original sair do disco local
+        sizes := makeImageVariants(r.Context(), nil, dst)
+        // disco local: URL relativa (/uploads/...); S3: URL pública ou pré-assinada
+        publicURL, err := storeUpload(r.Context(), nil, dst, mime)
+        if err != nil {
+            render.Error(w, http.StatusBadGateway, "storage error: "+err.Error())
+            return nil, false
+        }
+        a.saveImageVariants(r.Context(), orgID, publicURL, sizes)
+        sizes["original"] = publicURL
+        out = append(out, visionFile{
+            pendingImage: pendingImage{Path: dst, URL: publicURL, Sizes: sizes},
+            dataURL:      visionImageDataURL(raw, mime), // reduzida para o modelo
+            scan:         scan,
+        })
+    }
+    return out, true
 }
 
 // ================================================================
@@ -375,11 +682,6 @@ func contentTypeFromHeader(h *multipart.FileHeader) string {
     return "image/png"
 }
 
-// writeJSON codifica qualquer objeto como JSON e envia ao cliente.
-func writeJSON(w http.ResponseWriter, v any) {
-    w.Header().Set("Content-Type", "application/json")
-    _ = json.NewEncoder(w).Encode(v)
-}
 
 // guessExt retorna uma extensão de arquivo adequada a partir do tipo MIME.
 func guessExt(mime string) string {
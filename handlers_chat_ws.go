@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/paclead/backend/llm"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Gateway WebSocket do chat do dashboard
+// ================================================================
+//
+// GET /api/chat/ws?sessionId=...&org_id=...&flow_id=...
+//
+// Navegadores não enviam headers customizados no handshake, por isso o
+// tenant também pode vir na querystring. Cada frame é um objeto JSON com o
+// campo "type".
+//
+// Cliente -> servidor:
+//   {"type":"message","message":"...","system":"...","history":[...]}
+//   {"type":"typing"}                     (usuário digitando; ignorado)
+//   {"type":"ping"}
+//
+// Servidor -> cliente:
+//   {"type":"ready","sessionId":"..."}
+//   {"type":"typing"}                     (IA processando)
+//   {"type":"delta","content":"..."}      (trecho da resposta em streaming)
+//   {"type":"done","reply":"..."}         (resposta completa)
+//   {"type":"pending_price","reply":"..."} (há produto aguardando preço)
+//   {"type":"product_created","reply":"...","product":{...}}
+//   {"type":"order_created","reply":"...","order":{...}} (cliente confirmou o rascunho)
+//   {"type":"error","error":"..."}
+//   {"type":"pong"}
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = 50 * time.Second
+	wsMaxFrame   = 64 << 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     wsCheckOrigin,
+}
+
+// wsCheckOrigin aplica a mesma lista de ALLOWED_ORIGINS usada no CORS.
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range allowedOrigins() {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsFrame é o envelope de todas as mensagens trocadas no socket.
+type wsFrame struct {
+	Type      string       `json:"type"`
+	SessionID string       `json:"sessionId,omitempty"`
+	Content   string       `json:"content,omitempty"`
+	Reply     string       `json:"reply,omitempty"`
+	Product   *chatProduct `json:"product,omitempty"`
+	Order     *Order       `json:"order,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// wsInbound é o frame recebido do cliente; reaproveita o formato do /api/chat.
+type wsInbound struct {
+	Type string `json:"type"`
+	chatReq
+}
+
+// wsConn serializa as escritas (o gorilla/websocket aceita um único escritor).
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsConn) send(f wsFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(f)
+}
+
+func (c *wsConn) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+}
+
+func (a *App) chatWebSocket(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimSpace(r.URL.Query().Get("sessionId"))
+	// tenantAllowHeaders também aceita ?org_id=/?flow_id= (o browser não envia
+	// headers no upgrade do WebSocket)
+	t, _ := tenantFrom(r.Context())
+	orgID, flowID := int(t.OrgID), int(t.FlowID)
+	// sem sessionId na URL, cada frame precisa trazer o seu (chat_sessions.go)
+	if sessionID != "" && !a.requireChatSession(w, r, sessionID, t.OrgID, t.FlowID) {
+		return
+	}
+	provider, model, err := a.llmFor(r.Context(), int64(orgID), int64(flowID)) // ai_llm.go
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	raw, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade já respondeu ao cliente
+		log.Printf("chat ws upgrade: %v", err)
+		return
+	}
+	conn := &wsConn{conn: raw}
+	defer raw.Close()
+
+	// Não herdamos r.Context(): o middleware.Timeout global o cancelaria
+	// após 60s, derrubando sessões longas.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	raw.SetReadLimit(wsMaxFrame)
+	_ = raw.SetReadDeadline(time.Now().Add(wsPongWait))
+	raw.SetPongHandler(func(string) error {
+		return raw.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go func() {
+		t := time.NewTicker(wsPingPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := conn.ping(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	_ = conn.send(wsFrame{Type: "ready", SessionID: sessionID})
+
+	for {
+		var in wsInbound
+		if err := raw.ReadJSON(&in); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("chat ws read: %v", err)
+			}
+			return
+		}
+		_ = raw.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		switch in.Type {
+		case "ping":
+			_ = conn.send(wsFrame{Type: "pong"})
+			continue
+		case "typing":
+			continue
+		case "message", "":
+		default:
+			_ = conn.send(wsFrame{Type: "error", Error: "unknown frame type"})
+			continue
+		}
+
+		if in.SessionID == "" {
+			in.SessionID = sessionID
+		}
+		in.Message = strings.TrimSpace(in.Message)
+		if in.Message == "" {
+			_ = conn.send(wsFrame{Type: "error", Error: "message required"})
+			continue
+		}
+		if err := a.touchChatSession(ctx, in.SessionID, t.OrgID, t.FlowID); err != nil {
+			_ = conn.send(wsFrame{Type: "error", Error: err.Error()})
+			continue
+		}
+
+		if err := a.wsHandleMessage(ctx, conn, provider, model, orgID, flowID, in.chatReq); err != nil {
+			log.Printf("chat ws: %v", err)
+			return
+		}
+	}
+}
+
+// wsHandleMessage processa uma mensagem do usuário. Erros de negócio viram
+// frames "error"; apenas falhas de escrita no socket são devolvidas.
+func (a *App) wsHandleMessage(ctx context.Context, conn *wsConn, provider llm.Provider, model string, orgID, flowID int, in chatReq) error {
+	err := a.wsRespond(ctx, conn, provider, model, orgID, flowID, in)
+	if errors.Is(err, errWSAborted) {
+		return nil
+	}
+	return err
+}
+
+func (a *App) wsRespond(ctx context.Context, conn *wsConn, provider llm.Provider, model string, orgID, flowID int, in chatReq) error {
+	reply, prod, handled, err := a.completePending(ctx, in.SessionID, orgID, flowID, in.Message)
+	if handled {
+		if err != nil {
+			return conn.send(wsFrame{Type: "error", Error: "db insert error: " + err.Error()})
+		}
+		a.saveChatTurn(ctx, in.SessionID, orgID, flowID, in.Message, reply, "")
+		if prod == nil {
+			return conn.send(wsFrame{Type: "pending_price", Reply: reply})
+		}
+		return conn.send(wsFrame{Type: "product_created", Reply: reply, Product: prod})
+	}
+
+	reply, order, handled, err := a.completeOrderDraft(ctx, in.SessionID, int64(orgID), int64(flowID), in.Message)
+	if handled {
+		if err != nil {
+			return conn.send(wsFrame{Type: "error", Error: "order error: " + err.Error()})
+		}
+		a.saveChatTurn(ctx, in.SessionID, orgID, flowID, in.Message, reply, "")
+		if order == nil {
+			return conn.send(wsFrame{Type: "done", Reply: reply})
+		}
+		return conn.send(wsFrame{Type: "order_created", Reply: reply, Order: order})
+	}
+
+	if exceeded, spent, budget := a.aiBudgetExceeded(ctx, int64(orgID)); exceeded {
+		return conn.send(wsFrame{Type: "error", Error: aiBudgetMessage(spent, budget)})
+	}
+	if a.aiQuotaShareExceeded(ctx, int64(orgID)) {
+		return conn.send(wsFrame{Type: "error", Error: aiQuotaShareMessage()})
+	}
+	if err := conn.send(wsFrame{Type: "typing"}); err != nil {
+		return err
+	}
+	a.withStoredHistory(ctx, &in, orgID, flowID)
+	a.withAgentPrompt(ctx, &in, orgID, flowID)
+	a.withKnowledge(ctx, &in, orgID, flowID)
+	req := openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: buildChatMessages(in),
+		Stream:   true,
+		// o último chunk traz o consumo (ai_usage.go)
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	}
+	// usage e model (o efetivamente usado, após um eventual failover) vêm
+	// dos chunks
+	var usage openai.Usage
+	defer func() { a.recordAIUsage(int64(orgID), int64(flowID), "chat_ws", model, usage) }()
+	if orgID > 0 && flowID > 0 {
+		req.Tools = chatTools() // chat_tools.go
+	}
+
+	var full strings.Builder
+	for round := 0; ; round++ {
+		if round == chatToolMaxRounds {
+			req.Tools = nil
+		}
+		calls, err := a.wsStreamRound(ctx, conn, provider, req, &full, &usage, &model)
+		if err != nil {
+			return err
+		}
+		if len(calls) == 0 {
+			break
+		}
+		// o modelo pediu ferramentas: executa e abre uma nova rodada de streaming
+		req.Messages = append(req.Messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: calls})
+		for _, call := range calls {
+			req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Content:    a.execChatTool(ctx, int64(orgID), int64(flowID), in.SessionID, call),
+			})
+		}
+	}
+	text := strings.TrimSpace(full.String())
+	a.saveChatTurn(ctx, in.SessionID, orgID, flowID, in.Message, text, model)
+	return conn.send(wsFrame{Type: "done", Reply: text})
+}
+
+// wsStreamRound faz uma chamada em streaming, repassando o texto como frames
+// "delta" e acumulando os fragmentos de tool calls (indexados por Index) e o
+// consumo de tokens em usage (e o modelo que respondeu em model).
+// Erros do provedor já são enviados ao cliente; o erro devolvido é apenas de
+// escrita no socket (ou errWSAborted).
+func (a *App) wsStreamRound(ctx context.Context, conn *wsConn, provider llm.Provider, req openai.ChatCompletionRequest, full *strings.Builder, usage *openai.Usage, model *string) ([]openai.ToolCall, error) {
+	stream, err := provider.ChatStream(ctx, req)
+	if err != nil {
+		return nil, wsAbort(conn, provider.Name()+" error: "+err.Error())
+	}
+	defer stream.Close()
+
+	var calls []openai.ToolCall
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return calls, nil
+		}
+		if err != nil {
+			return nil, wsAbort(conn, provider.Name()+" error: "+err.Error())
+		}
+		if chunk.Model != "" {
+			*model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage.PromptTokens += chunk.Usage.PromptTokens
+			usage.CompletionTokens += chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		for _, tc := range delta.ToolCalls {
+			idx := len(calls)
+			if tc.Index != nil {
+				idx = *tc.Index
+			}
+			for len(calls) <= idx {
+				calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+			}
+			if tc.ID != "" {
+				calls[idx].ID = tc.ID
+			}
+			calls[idx].Function.Name += tc.Function.Name
+			calls[idx].Function.Arguments += tc.Function.Arguments
+		}
+		if delta.Content == "" {
+			continue
+		}
+		full.WriteString(delta.Content)
+		if err := conn.send(wsFrame{Type: "delta", Content: delta.Content}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// errWSAborted indica que a mensagem foi encerrada com um frame "error"
+// (a conexão continua aberta).
+var errWSAborted = errors.New("ws message aborted")
+
+func wsAbort(conn *wsConn, msg string) error {
+	if err := conn.send(wsFrame{Type: "error", Error: msg}); err != nil {
+		return err
+	}
+	return errWSAborted
+}
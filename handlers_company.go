@@ -2,9 +2,13 @@ package main
 
 import (
     "encoding/json"
+    "errors"
+    "log"
     "net/http"
+    "strings"
 
     "github.com/go-chi/chi/v5"
+    "github.com/paclead/backend/render"
 )
 
 // mountCompany registers the company (organisation) management endpoints under
@@ -15,10 +19,14 @@ import (
 func (a *App) mountCompany(r chi.Router) {
     // Fetch current organisation details. Requires a valid JWT in the
     // Authorization header. Returns 401 if the token is missing or invalid.
-    r.Get("/company", a.getCompany)
+    r.With(a.requireAuth).Get("/company", a.getCompany)
     // Update organisation details. Accepts a JSON body with the fields
-    // defined in the CompanyInput struct. Requires authentication.
-    r.Put("/company", a.updateCompany)
+    // defined in the CompanyInput struct.
+    // Restricted to admins/owners (rbac.go).
+    r.With(a.requireRole(roleAdmin)).Put("/company", a.updateCompany)
+    // Expand a CEP into street/district/city/UF (ViaCEP). Used by the form
+    // to pre-fill the address; see cep.go.
+    r.Get("/cep/{cep}", a.getCEP)
 }
 
 // Company represents the organisation record returned by getCompany. Most
@@ -44,29 +52,25 @@ type Company struct {
 }
 
 // getCompany retrieves the organisation associated with the authenticated
-// user. It extracts the user and organisation IDs from the JWT claims and
+// user. It takes the organisation ID from the JWT claims (requireAuth) and
 // queries the orgs table for all relevant columns. If the record cannot be
 // found a 404 is returned.
 func (a *App) getCompany(w http.ResponseWriter, r *http.Request) {
-    _, orgID, _, err := extractUserFromToken(r)
-    if err != nil {
-        http.Error(w, "invalid token", http.StatusUnauthorized)
-        return
-    }
+    claims, _ := claimsFromContext(r.Context())
+    orgID := claims.OrgID
     // Query all company fields. Some may be nullable; use pointers to scan.
     var c Company
-    err = a.DB.QueryRow(r.Context(),
+    err := a.DB.QueryRow(r.Context(),
         `SELECT id, name, tax_id, razao_social, nome_fantasia, inscricao_estadual, segmento, telefone, email, bairro, endereco, numero, cep, cidade, uf, observacoes
          FROM orgs
          WHERE id=$1`, orgID).
         Scan(&c.ID, &c.Name, &c.TaxID, &c.RazaoSocial, &c.NomeFantasia, &c.InscEstadual, &c.Segmento,
             &c.Telefone, &c.Email, &c.Bairro, &c.Endereco, &c.Numero, &c.CEP, &c.Cidade, &c.UF, &c.Observacoes)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusNotFound)
+        render.Error(w, http.StatusNotFound, err.Error())
         return
     }
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(c)
+    render.OK(w, c)
 }
 
 // CompanyInput defines the payload accepted by updateCompany. It mirrors the
@@ -98,18 +102,38 @@ type CompanyInput struct {
 // payload remain unchanged. If the organisation cannot be found a 404 is
 // returned.
 func (a *App) updateCompany(w http.ResponseWriter, r *http.Request) {
-    _, orgID, _, err := extractUserFromToken(r)
-    if err != nil {
-        http.Error(w, "invalid token", http.StatusUnauthorized)
-        return
-    }
+    claims, _ := claimsFromContext(r.Context())
+    orgID := claims.OrgID
     var in CompanyInput
     if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-        http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
         return
     }
+    // Validate the CEP and fill in address fields the form left blank. If
+    // ViaCEP is unavailable the CEP is saved as typed (digits only).
+    if in.CEP != nil && strings.TrimSpace(*in.CEP) != "" {
+        addr, err := lookupCEP(r.Context(), *in.CEP)
+        if errors.Is(err, errCEPInvalid) || errors.Is(err, errCEPNotFound) {
+            render.Error(w, cepHTTPStatus(err), "cep: "+err.Error())
+            return
+        }
+        if err != nil {
+            log.Printf("company %d: cep lookup: %v", orgID, err)
+        }
+        cep := onlyDigits(*in.CEP)
+        in.CEP = &cep
+        fill := func(dst **string, v string) {
+            if v != "" && (*dst == nil || strings.TrimSpace(**dst) == "") {
+                *dst = &v
+            }
+        }
+        fill(&in.Endereco, addr.Logradouro)
+        fill(&in.Bairro, addr.Bairro)
+        fill(&in.Cidade, addr.Cidade)
+        fill(&in.UF, addr.UF)
+    }
     // Build update statement. Use COALESCE to keep existing values when nil.
-    _, err = a.DB.Exec(r.Context(),
+    _, err := a.DB.Exec(r.Context(),
         `UPDATE orgs
          SET name=COALESCE($1, name),
              tax_id=COALESCE($2, tax_id),
@@ -130,8 +154,8 @@ func (a *App) updateCompany(w http.ResponseWriter, r *http.Request) {
         in.Name, in.TaxID, in.RazaoSocial, in.NomeFantasia, in.InscEstadual, in.Segmento, in.Telefone,
         in.Email, in.Bairro, in.Endereco, in.Numero, in.CEP, in.Cidade, in.UF, in.Observacoes, orgID)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        render.Error(w, http.StatusInternalServerError, err.Error())
         return
     }
-    w.WriteHeader(http.StatusNoContent)
+    render.NoContent(w)
 }
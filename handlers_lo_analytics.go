@@ -1,38 +1,196 @@
 
 package main
-import ("encoding/json"; "net/http"; "time"; "fmt"; "github.com/go-chi/chi/v5")
-type Lead struct{ ID int64 `json:"id"`; OrgID int64 `json:"org_id"`; FlowID int64 `json:"flow_id"`; Name string `json:"name"`; Phone string `json:"phone"`; Stage string `json:"stage"`; CreatedAt time.Time `json:"created_at"` }
+import ("context"; "encoding/json"; "errors"; "log"; "net/http"; "strings"; "time"; "fmt"; "github.com/go-chi/chi/v5"; "github.com/jackc/pgx/v5"; "github.com/paclead/backend/render")
+type Lead struct{ ID int64 `json:"id"`; OrgID int64 `json:"org_id"`; FlowID int64 `json:"flow_id"`; Name string `json:"name"`; Phone string `json:"phone"`; Email string `json:"email,omitempty"`; Stage string `json:"stage"`; CreatedAt time.Time `json:"created_at"` }
 type Order struct{ ID int64 `json:"id"`; OrgID int64 `json:"org_id"`; FlowID int64 `json:"flow_id"`; LeadID int64 `json:"lead_id"`; TotalCents int `json:"total_cents"`; Status string `json:"status"`; CreatedAt time.Time `json:"created_at"` }
-func (a *App) mountLeads(r chi.Router){ r.Get("/leads", a.listLeads); r.Post("/leads", a.createLead) }
-func (a *App) mountOrders(r chi.Router){ r.Get("/orders", a.listOrders); r.Post("/orders", a.createOrder) }
+func (a *App) mountLeads(r chi.Router){
+  r.Get("/leads", a.listLeads); r.With(a.idempotent).Post("/leads", a.createLead)
+  r.Get("/leads/export", a.exportLeads) // CSV, ver csv_export.go
+  r.Get("/leads/{id}", a.getLead); r.Put("/leads/{id}", a.updateLead); r.Delete("/leads/{id}", a.deleteLead)
+  r.Post("/leads/{id}/stage", a.setLeadStage)
+  r.Get("/leads/duplicates", a.listDuplicateLeads); r.Post("/leads/{id}/merge/{other_id}", a.mergeLeads)
+}
+func (a *App) mountOrders(r chi.Router){ r.Get("/orders", a.listOrders); r.Get("/orders/export", a.exportOrders); r.With(a.idempotent).Post("/orders", a.createOrder) }
+// ?format=csv em todas as análises (csv_export.go)
 func (a *App) mountAnalytics(r chi.Router){
-  r.Get("/analytics/top-products", a.analyticsTopProducts)
-  r.Get("/analytics/sales-by-hour", a.analyticsSalesByHour)
-  r.Get("/analytics/summary", a.analyticsSummary)
+  r.With(csvFromJSON("top_products")).Get("/analytics/top-products", a.analyticsTopProducts)
+  r.With(csvFromJSON("sales_by_hour")).Get("/analytics/sales-by-hour", a.analyticsSalesByHour)
+  r.With(csvFromJSON("summary")).Get("/analytics/summary", a.analyticsSummary)
+  r.With(csvFromJSON("ai_usage")).Get("/analytics/ai-usage", a.analyticsAIUsage)
+}
+// listLeads lista os leads do tenant. Os filtros ?phone= e ?email= usam as
+// colunas de hash, já que os valores ficam cifrados na base.
+func (a *App) listLeads(w http.ResponseWriter, r *http.Request){
+  if csvWanted(r) { a.exportLeads(w, r); return }
+  orgID, flowID, _ := tenantOf(r)
+  q := r.URL.Query()
+  phone := phoneHash(orgID, q.Get("phone"))
+  emailHash := piiHash(orgID, q.Get("email"))
+  rows, err := a.DB.Query(r.Context(),
+    `SELECT id,org_id,flow_id,COALESCE(name,''),COALESCE(phone,''),COALESCE(email,''),COALESCE(stage,''),created_at
+     FROM leads
+     WHERE org_id=$1 AND flow_id=$2
+       AND ($3 = '' OR phone_hash=$3)
+       AND ($4 = '' OR email_hash=$4)
+     ORDER BY created_at DESC LIMIT 500`, orgID, flowID, phone, emailHash)
+  if err != nil { render.Error(w, 500, err.Error()); return }
+  defer rows.Close()
+  var out []Lead
+  for rows.Next(){
+    var v Lead
+    if err := rows.Scan(&v.ID,&v.OrgID,&v.FlowID,&v.Name,&v.Phone,&v.Email,&v.Stage,&v.CreatedAt); err != nil { render.Error(w, 500, err.Error()); return }
+    v.Name, v.Phone, v.Email = revealPII(v.OrgID, v.Name), revealPII(v.OrgID, v.Phone), revealPII(v.OrgID, v.Email)
+    out = append(out, v)
+  }
+  render.OK(w, map[string]any{"items": out})
+}
+// createLead grava o lead com nome/telefone/e-mail cifrados pela chave da org.
+// Telefone já cadastrado no org/flow devolve o lead existente com
+// "duplicate": true em vez de criar outro (lead_dedup.go).
+func (a *App) createLead(w http.ResponseWriter, r *http.Request){
+  var in struct{ OrgID, FlowID int64; Name, Phone, Email, Stage string; RefCode string `json:"ref_code"` }
+  if err := json.NewDecoder(r.Body).Decode(&in); err != nil { render.Error(w, 400, err.Error()); return }
+  if c, ok := claimsFromContext(r.Context()); ok { in.OrgID, in.FlowID = c.OrgID, c.FlowID }
+  if in.Stage = normalizeLeadStage(in.Stage); in.Stage == "" { in.Stage = "novo" }
+  if strings.TrimSpace(in.Phone) != "" {
+    id, err := a.leadIDByPhone(r.Context(), in.OrgID, in.FlowID, in.Phone, false)
+    if err != nil { render.Error(w, 500, err.Error()); return }
+    if id > 0 {
+      lead, err := a.loadLead(r.Context(), in.OrgID, in.FlowID, id)
+      if err != nil { render.Error(w, 500, err.Error()); return }
+      if in.RefCode != "" { if _, err := a.attributeReferral(r.Context(), in.OrgID, id, in.RefCode, "api"); err != nil { log.Printf("lead %d referral: %v", id, err) } }
+      render.OK(w, struct{ Lead; Duplicate bool `json:"duplicate"` }{lead, true}); return
+    }
+  }
+  in.Phone = leadPhone(in.Phone)
+  id, created, err := a.insertLead(r.Context(), in.OrgID, in.FlowID, in.Name, in.Phone, in.Email, in.Stage)
+  if err != nil { render.Error(w, 500, err.Error()); return }
+  // código de indicação vindo do checkout/site (referrals.go)
+  if in.RefCode != "" { if _, err := a.attributeReferral(r.Context(), in.OrgID, id, in.RefCode, "api"); err != nil { log.Printf("lead %d referral: %v", id, err) } }
+  render.OK(w, Lead{ID:id, OrgID:in.OrgID, FlowID:in.FlowID, Name:in.Name, Phone:in.Phone, Email:in.Email, Stage:in.Stage, CreatedAt:created})
+}
+// insertLead cifra os campos de PII, calcula os hashes de busca e insere o
+// lead. Sem etapa informada o lead entra como "novo".
+func (a *App) insertLead(ctx context.Context, orgID, flowID int64, name, phone, email, stage string) (int64, time.Time, error){
+  if stage = normalizeLeadStage(stage); stage == "" { stage = "novo" }
+  phone = leadPhone(phone)
+  version := piiKeyVersion(ctx, a.DB, orgID)
+  var enc [3]string
+  for i, v := range []string{name, phone, email} {
+    e, err := encryptPII(orgID, version, v)
+    if err != nil { return 0, time.Time{}, err }
+    enc[i] = e
+  }
+  var id int64; var created time.Time
+  err := a.DB.QueryRow(ctx,
+    `INSERT INTO leads(org_id,flow_id,name,phone,email,stage,phone_hash,email_hash,stage_changed_at)
+     VALUES($1,$2,$3,$4,$5,$6,NULLIF($7,''),NULLIF($8,''),NOW()) RETURNING id, created_at`,
+    orgID,flowID,enc[0],enc[1],enc[2],stage,phoneHash(orgID, phone),piiHash(orgID, email)).Scan(&id,&created)
+  if err != nil { return 0, time.Time{}, err }
+  a.publishEvent(ctx, orgID, eventLeadCreated, map[string]any{
+    "id": id, "org_id": orgID, "flow_id": flowID, "name": name, "phone": phone, "email": email, "stage": stage, "created_at": created,
+  })
+  return id, created, nil
+}
+func (a *App) listOrders(w http.ResponseWriter, r *http.Request){ if csvWanted(r) { a.exportOrders(w, r); return }; orgID, flowID, _ := tenantOf(r); rows, err := a.DB.Query(r.Context(), `SELECT id,org_id,flow_id,lead_id,total_cents,status,created_at FROM orders WHERE org_id=$1 AND flow_id=$2 ORDER BY created_at DESC LIMIT 500`, orgID, flowID); if err != nil { render.Error(w, 500, err.Error()); return }; defer rows.Close(); var out []Order; for rows.Next(){ var v Order; if err := rows.Scan(&v.ID,&v.OrgID,&v.FlowID,&v.LeadID,&v.TotalCents,&v.Status,&v.CreatedAt); err != nil { render.Error(w, 500, err.Error()); return }; out = append(out, v) }; render.OK(w, map[string]any{"items": out}) }
+// createOrder grava o pedido. Com items, os itens entram na mesma transação e
+// baixam o estoque (order_stock.go): falta de estoque responde 409 e nada é
+// gravado. Sem total_cents, o total é a soma dos itens (preço atual do
+// produto, ou o da oferta relâmpago, quando unit_price_cents não vem).
+func (a *App) createOrder(w http.ResponseWriter, r *http.Request){
+  var in struct{ OrgID, FlowID int64; LeadID int64; TotalCents int; Status string; RefCode string `json:"ref_code"`
+    Items []struct{ ProductID int64 `json:"product_id"`; Qty int `json:"qty"`; UnitPriceCents int `json:"unit_price_cents"` } `json:"items"` }
+  if err := json.NewDecoder(r.Body).Decode(&in); err != nil { render.Error(w, 400, err.Error()); return }
+  if c, ok := claimsFromContext(r.Context()); ok { in.OrgID, in.FlowID = c.OrgID, c.FlowID }
+  for _, it := range in.Items {
+    if it.ProductID <= 0 || it.Qty <= 0 { render.Error(w, 400, "items need product_id and qty > 0"); return }
+  }
+  ctx := r.Context()
+  tx, err := a.DB.Begin(ctx); if err != nil { render.Error(w, 500, err.Error()); return }
+  defer tx.Rollback(ctx)
+  var id int64; var created time.Time
+  err = tx.QueryRow(ctx, `INSERT INTO orders(org_id,flow_id,lead_id,total_cents,status) VALUES($1,$2,$3,$4,$5) RETURNING id, created_at`, in.OrgID,in.FlowID,in.LeadID,in.TotalCents,in.Status).Scan(&id,&created)
+  if err != nil { render.Error(w, 500, err.Error()); return }
+  o := Order{ID:id, OrgID:in.OrgID, FlowID:in.FlowID, LeadID:in.LeadID, TotalCents:in.TotalCents, Status:in.Status, CreatedAt:created}
+  sum := 0
+  var flashSales []int64
+  for _, it := range in.Items {
+    // só produto à venda: ativo, fora da lixeira e na janela de venda (product_schedule.go)
+    var listPrice int
+    err := tx.QueryRow(ctx, `SELECT price_cents FROM products WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND status='active' AND `+productAvailableSQL(), it.ProductID, in.OrgID, in.FlowID).Scan(&listPrice)
+    if errors.Is(err, pgx.ErrNoRows) { render.Error(w, 400, fmt.Sprintf("product %d not found or not available", it.ProductID)); return }
+    if err != nil { render.Error(w, 500, err.Error()); return }
+    // oferta relâmpago em andamento: mesmo limite e preço do pedido do chat
+    // (flash_sales.go). Com unit_price_cents explícito o preço da oferta não
+    // se aplica, então nenhuma unidade dela é reservada.
+    if in.Status != "canceled" && it.UnitPriceCents <= 0 {
+      saleID, salePrice, left, err := flashSaleReserve(ctx, tx, in.OrgID, it.ProductID, it.Qty)
+      if errors.Is(err, errFlashSaleCap) { render.Error(w, http.StatusConflict, fmt.Sprintf("product %d: flash sale has only %d unit(s) left", it.ProductID, left)); return }
+      if err != nil { render.Error(w, 500, err.Error()); return }
+      if saleID > 0 {
+        listPrice = salePrice
+        flashSales = append(flashSales, saleID)
+      }
+    }
+    price := it.UnitPriceCents
+    if price <= 0 {
+      price = listPrice
+    }
+    if _, err := tx.Exec(ctx, `INSERT INTO order_items (org_id, flow_id, order_id, product_id, qty, unit_price_cents) VALUES ($1,$2,$3,$4,$5,$6)`,
+      in.OrgID, in.FlowID, id, it.ProductID, it.Qty, price); err != nil { render.Error(w, 500, err.Error()); return }
+    sum += price * it.Qty
+  }
+  if len(in.Items) > 0 {
+    if o.TotalCents == 0 {
+      if _, err := tx.Exec(ctx, `UPDATE orders SET total_cents=$2 WHERE id=$1`, id, sum); err != nil { render.Error(w, 500, err.Error()); return }
+      o.TotalCents = sum
+    }
+    if o.Status != "canceled" {
+      if err := reserveOrderStockTx(ctx, tx, in.OrgID, id, true); err != nil {
+        var se *orderStockError
+        if errors.As(err, &se) { render.Error(w, http.StatusConflict, se.Error()); return }
+        render.Error(w, 500, err.Error()); return
+      }
+    }
+  }
+  if err := tx.Commit(ctx); err != nil { render.Error(w, 500, err.Error()); return }
+  if len(in.Items) > 0 { a.touchProductFeed(in.OrgID, 0) }
+  a.flashSaleAfterOrder(ctx, flashSales)
+  if in.RefCode != "" && in.LeadID > 0 { if _, err := a.attributeReferral(ctx, in.OrgID, in.LeadID, in.RefCode, "api"); err != nil { log.Printf("order %d referral: %v", id, err) } }
+  a.publishEvent(ctx, o.OrgID, eventOrderCreated, o)
+  if o.Status == "paid" { a.publishEvent(ctx, o.OrgID, eventOrderPaid, o) }
+  render.OK(w, o)
 }
-func (a *App) listLeads(w http.ResponseWriter, r *http.Request){ orgID, flowID, _ := tenantFromHeaders(r); rows, err := a.DB.Query(r.Context(), `SELECT id,org_id,flow_id,name,phone,stage,created_at FROM leads WHERE org_id=$1 AND flow_id=$2 ORDER BY created_at DESC LIMIT 500`, orgID, flowID); if err != nil { http.Error(w, err.Error(), 500); return }; defer rows.Close(); var out []Lead; for rows.Next(){ var v Lead; if err := rows.Scan(&v.ID,&v.OrgID,&v.FlowID,&v.Name,&v.Phone,&v.Stage,&v.CreatedAt); err != nil { http.Error(w, err.Error(), 500); return }; out = append(out, v) }; json.NewEncoder(w).Encode(map[string]any{"items": out}) }
-func (a *App) createLead(w http.ResponseWriter, r *http.Request){ var in struct{ OrgID, FlowID int64; Name, Phone, Stage string }; if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, err.Error(), 400); return }; var id int64; var created time.Time; err := a.DB.QueryRow(r.Context(), `INSERT INTO leads(org_id,flow_id,name,phone,stage) VALUES($1,$2,$3,$4,$5) RETURNING id, created_at`, in.OrgID,in.FlowID,in.Name,in.Phone,in.Stage).Scan(&id,&created); if err != nil { http.Error(w, err.Error(), 500); return }; json.NewEncoder(w).Encode(Lead{ID:id, OrgID:in.OrgID, FlowID:in.FlowID, Name:in.Name, Phone:in.Phone, Stage:in.Stage, CreatedAt:created}) }
-func (a *App) listOrders(w http.ResponseWriter, r *http.Request){ orgID, flowID, _ := tenantFromHeaders(r); rows, err := a.DB.Query(r.Context(), `SELECT id,org_id,flow_id,lead_id,total_cents,status,created_at FROM orders WHERE org_id=$1 AND flow_id=$2 ORDER BY created_at DESC LIMIT 500`, orgID, flowID); if err != nil { http.Error(w, err.Error(), 500); return }; defer rows.Close(); var out []Order; for rows.Next(){ var v Order; if err := rows.Scan(&v.ID,&v.OrgID,&v.FlowID,&v.LeadID,&v.TotalCents,&v.Status,&v.CreatedAt); err != nil { http.Error(w, err.Error(), 500); return }; out = append(out, v) }; json.NewEncoder(w).Encode(map[string]any{"items": out}) }
-func (a *App) createOrder(w http.ResponseWriter, r *http.Request){ var in struct{ OrgID, FlowID int64; LeadID int64; TotalCents int; Status string }; if err := json.NewDecoder(r.Body).Decode(&in); err != nil { http.Error(w, err.Error(), 400); return }; var id int64; var created time.Time; err := a.DB.QueryRow(r.Context(), `INSERT INTO orders(org_id,flow_id,lead_id,total_cents,status) VALUES($1,$2,$3,$4,$5) RETURNING id, created_at`, in.OrgID,in.FlowID,in.LeadID,in.TotalCents,in.Status).Scan(&id,&created); if err != nil { http.Error(w, err.Error(), 500); return }; json.NewEncoder(w).Encode(Order{ID:id, OrgID:in.OrgID, FlowID:in.FlowID, LeadID:in.LeadID, TotalCents:in.TotalCents, Status:in.Status, CreatedAt:created}) }
 func (a *App) analyticsTopProducts(w http.ResponseWriter, r *http.Request){
-  orgID, flowID, _ := tenantFromHeaders(r)
-  q := `SELECT oi.product_id, p.title, SUM(oi.qty) AS units, SUM(oi.qty*oi.unit_price_cents) AS revenue_cents FROM order_items oi JOIN products p ON p.id = oi.product_id WHERE oi.org_id=$1 AND oi.flow_id=$2 GROUP BY oi.product_id,p.title ORDER BY units DESC LIMIT 10`
-  rows, err := a.DB.Query(r.Context(), q, orgID, flowID); if err != nil { http.Error(w, err.Error(), 500); return }
+  orgID, flowID, _ := tenantOf(r)
+  rg, err := parseAnalyticsRange(r); if err != nil { render.Error(w, 400, err.Error()); return }
+  q := `SELECT oi.product_id, p.title, SUM(oi.qty) AS units, SUM(oi.qty*oi.unit_price_cents) AS revenue_cents
+        FROM order_items oi JOIN products p ON p.id = oi.product_id JOIN orders o ON o.id = oi.order_id
+        WHERE oi.org_id=$1 AND oi.flow_id=$2
+          AND ($3::timestamptz IS NULL OR o.created_at >= $3) AND ($4::timestamptz IS NULL OR o.created_at < $4)
+        GROUP BY oi.product_id,p.title ORDER BY units DESC LIMIT 10`
+  rows, err := a.DB.Query(r.Context(), q, orgID, flowID, rg.From, rg.To); if err != nil { render.Error(w, 500, err.Error()); return }
   defer rows.Close()
   type row struct{ ProductID int64 `json:"product_id"`; Title string `json:"title"`; Units int64 `json:"units"`; RevenueCents int64 `json:"revenue_cents"`}
   out := []row{}
-  for rows.Next(){ var x row; if err:=rows.Scan(&x.ProductID,&x.Title,&x.Units,&x.RevenueCents); err!=nil { http.Error(w, err.Error(), 500); return }; out=append(out,x) }
-  json.NewEncoder(w).Encode(map[string]any{"items": out})
+  for rows.Next(){ var x row; if err:=rows.Scan(&x.ProductID,&x.Title,&x.Units,&x.RevenueCents); err!=nil { render.Error(w, 500, err.Error()); return }; out=append(out,x) }
+  render.OK(w, map[string]any{"items": out, "range": rg.meta()})
 }
+// analyticsSalesByHour agrupa os pedidos pagos por hora no fuso pedido (tz).
 func (a *App) analyticsSalesByHour(w http.ResponseWriter, r *http.Request){
-  orgID, flowID, _ := tenantFromHeaders(r)
-  q := `SELECT date_trunc('hour', created_at) AS t, COUNT(*) FROM orders WHERE org_id=$1 AND flow_id=$2 AND status='paid' GROUP BY 1 ORDER BY 1`
-  rows, err := a.DB.Query(r.Context(), q, orgID, flowID); if err != nil { http.Error(w, err.Error(), 500); return }
+  orgID, flowID, _ := tenantOf(r)
+  rg, err := parseAnalyticsRange(r); if err != nil { render.Error(w, 400, err.Error()); return }
+  q := `SELECT date_trunc('hour', created_at AT TIME ZONE $5) AT TIME ZONE $5 AS t, COUNT(*)
+        FROM orders
+        WHERE org_id=$1 AND flow_id=$2 AND status='paid'
+          AND ($3::timestamptz IS NULL OR created_at >= $3) AND ($4::timestamptz IS NULL OR created_at < $4)
+        GROUP BY 1 ORDER BY 1`
+  rows, err := a.DB.Query(r.Context(), q, orgID, flowID, rg.From, rg.To, rg.TZ); if err != nil { render.Error(w, 500, err.Error()); return }
   defer rows.Close()
   type row struct{ T time.Time `json:"t"`; C int64 `json:"c"` }
   out := []row{}
-  for rows.Next(){ var x row; if err:=rows.Scan(&x.T,&x.C); err!=nil { http.Error(w, err.Error(), 500); return }; out=append(out,x) }
-  json.NewEncoder(w).Encode(map[string]any{"items": out})
+  for rows.Next(){ var x row; if err:=rows.Scan(&x.T,&x.C); err!=nil { render.Error(w, 500, err.Error()); return }; x.T = x.T.In(rg.Loc); out=append(out,x) }
+  render.OK(w, map[string]any{"items": out, "range": rg.meta()})
 }
 
 // analyticsSummary retorna dados agregados para a tela de análise. Ele inclui
@@ -41,39 +199,42 @@ func (a *App) analyticsSalesByHour(w http.ResponseWriter, r *http.Request){
 // baseado em pedidos pagos, e o produto mais vendido. Caso algum valor não
 // possa ser calculado, campos vazios ou zero são retornados.
 func (a *App) analyticsSummary(w http.ResponseWriter, r *http.Request){
-  orgID, flowID, _ := tenantFromHeaders(r)
+  orgID, flowID, _ := tenantOf(r)
+  rg, err := parseAnalyticsRange(r); if err != nil { render.Error(w, 400, err.Error()); return }
   ctx := r.Context()
+  // filtro de período comum às consultas ($3/$4)
+  const period = ` AND ($3::timestamptz IS NULL OR created_at >= $3) AND ($4::timestamptz IS NULL OR created_at < $4)`
 
   // total de leads
   var leadsCount int64
-  if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM leads WHERE org_id=$1 AND flow_id=$2`, orgID, flowID).Scan(&leadsCount); err != nil {
-    http.Error(w, err.Error(), 500)
+  if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM leads WHERE org_id=$1 AND flow_id=$2`+period, orgID, flowID, rg.From, rg.To).Scan(&leadsCount); err != nil {
+    render.Error(w, 500, err.Error())
     return
   }
 
   // total de pedidos pagos (conversões/vendas)
   var salesCount int64
-  if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM orders WHERE org_id=$1 AND flow_id=$2 AND status='paid'`, orgID, flowID).Scan(&salesCount); err != nil {
-    http.Error(w, err.Error(), 500)
+  if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM orders WHERE org_id=$1 AND flow_id=$2 AND status='paid'`+period, orgID, flowID, rg.From, rg.To).Scan(&salesCount); err != nil {
+    render.Error(w, 500, err.Error())
     return
   }
 
   // leads recuperados (clientes)
   var recoveredCount int64
-  if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM leads WHERE org_id=$1 AND flow_id=$2 AND LOWER(stage)='cliente'`, orgID, flowID).Scan(&recoveredCount); err != nil {
-    http.Error(w, err.Error(), 500)
+  if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM leads WHERE org_id=$1 AND flow_id=$2 AND LOWER(stage)='cliente'`+period, orgID, flowID, rg.From, rg.To).Scan(&recoveredCount); err != nil {
+    render.Error(w, 500, err.Error())
     return
   }
 
-  // melhor horário de conversão (hora com mais pedidos pagos)
+  // melhor horário de conversão (hora com mais pedidos pagos, no fuso pedido)
   var bestTime *time.Time
   _ = a.DB.QueryRow(ctx,
-    `SELECT date_trunc('hour', created_at) AS t
+    `SELECT date_trunc('hour', created_at AT TIME ZONE $5) AS t
      FROM orders
-     WHERE org_id=$1 AND flow_id=$2 AND status='paid'
+     WHERE org_id=$1 AND flow_id=$2 AND status='paid'`+period+`
      GROUP BY 1
      ORDER BY COUNT(*) DESC
-     LIMIT 1`, orgID, flowID).Scan(&bestTime)
+     LIMIT 1`, orgID, flowID, rg.From, rg.To, rg.TZ).Scan(&bestTime)
 
   bestRange := ""
   if bestTime != nil {
@@ -88,10 +249,12 @@ func (a *App) analyticsSummary(w http.ResponseWriter, r *http.Request){
     `SELECT p.title
      FROM order_items oi
      JOIN products p ON p.id = oi.product_id
+     JOIN orders o ON o.id = oi.order_id
      WHERE oi.org_id=$1 AND oi.flow_id=$2
+       AND ($3::timestamptz IS NULL OR o.created_at >= $3) AND ($4::timestamptz IS NULL OR o.created_at < $4)
      GROUP BY p.title
      ORDER BY SUM(oi.qty) DESC
-     LIMIT 1`, orgID, flowID).Scan(&topProduct)
+     LIMIT 1`, orgID, flowID, rg.From, rg.To).Scan(&topProduct)
 
   // aproximação do total de conversas: utiliza o total de leads como proxy
   conversations := leadsCount
@@ -110,6 +273,7 @@ func (a *App) analyticsSummary(w http.ResponseWriter, r *http.Request){
     "recovered_leads":  recoveredCount,
     "best_time_range":  bestRange,
     "top_product":      topProduct,
+    "range":            rg.meta(),
   }
-  json.NewEncoder(w).Encode(out)
+  render.OK(w, out)
 }
@@ -1,11 +1,11 @@
 package main
 
 import (
-    "encoding/json"
     "net/http"
     "regexp"
 
     "github.com/go-chi/chi/v5"
+    "github.com/paclead/backend/render"
 )
 
 // mountResolve registers routes used to resolve an organization (and its
@@ -34,7 +34,7 @@ func (a *App) resolveOrg(w http.ResponseWriter, r *http.Request) {
     re := regexp.MustCompile(`\D`)
     digits := re.ReplaceAllString(raw, "")
     if digits == "" {
-        http.Error(w, "invalid tax_id", http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "invalid tax_id")
         return
     }
 
@@ -42,7 +42,7 @@ func (a *App) resolveOrg(w http.ResponseWriter, r *http.Request) {
     var orgID int64
     err := a.DB.QueryRow(r.Context(), `SELECT id FROM orgs WHERE tax_id=$1`, digits).Scan(&orgID)
     if err != nil {
-        http.Error(w, "org not found", http.StatusNotFound)
+        render.Error(w, http.StatusNotFound, "org not found")
         return
     }
 
@@ -50,12 +50,11 @@ func (a *App) resolveOrg(w http.ResponseWriter, r *http.Request) {
     var flowID int64
     err = a.DB.QueryRow(r.Context(), `SELECT id FROM flows WHERE org_id=$1 ORDER BY id LIMIT 1`, orgID).Scan(&flowID)
     if err != nil {
-        http.Error(w, "flow not found", http.StatusNotFound)
+        render.Error(w, http.StatusNotFound, "flow not found")
         return
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]int64{
+    render.OK(w, map[string]int64{
         "org_id":  orgID,
         "flow_id": flowID,
     })
@@ -1,7 +1,7 @@
 package main
 
 import (
-    "encoding/json"
+    "errors"
     "fmt"
     "io"
     "net/http"
@@ -12,38 +12,49 @@ import (
     "time"
 
     "github.com/go-chi/chi/v5"
+    "github.com/paclead/backend/render"
 )
 
 // mountUpload registers the image upload endpoint on the given router. The
 // route accepts multipart form requests containing a file under the key
-// "image" and persists it to a local uploads directory. It returns a JSON
-// object with a URL pointing to the saved file. The URL uses the same
-// scheme and host as the incoming request. The upload directory can be
-// configured via the UPLOAD_DIR environment variable (default: "uploads").
+// "image", stages it in the local uploads directory (UPLOAD_DIR, default
+// "uploads") for the antivirus scan and then publishes it through the
+// configured storage driver (see storage.go). It returns a JSON object with
+// the public (or presigned) URL of the file.
 func (a *App) mountUpload(r chi.Router) {
     r.Post("/upload", a.uploadImage)
 }
 
 // uploadImage handles POST /api/upload. It reads the uploaded image from
-// the multipart form, saves it with a unique filename in the configured
-// upload directory and responds with a JSON containing the public URL.
+// the multipart form, saves it with a unique filename, publishes it to the
+// storage backend and responds with a JSON containing the URL.
 func (a *App) uploadImage(w http.ResponseWriter, r *http.Request) {
-    // Parse up to 10MB of incoming multipart data. Adjust size as needed.
-    if err := r.ParseMultipartForm(10 << 20); err != nil {
-        http.Error(w, "multipart parse error: "+err.Error(), http.StatusBadRequest)
+    // Body size is capped by UPLOAD_MAX_MB (see vision_limits.go).
+    r.Body = http.MaxBytesReader(w, r.Body, uploadMaxBytes())
+    if err := r.ParseMultipartForm(8 << 20); err != nil {
+        var tooBig *http.MaxBytesError
+        if errors.As(err, &tooBig) {
+            render.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds %d MB", uploadMaxBytes()>>20))
+            return
+        }
+        render.Error(w, http.StatusBadRequest, "multipart parse error: "+err.Error())
         return
     }
     file, header, err := r.FormFile("image")
     if err != nil {
-        http.Error(w, "image file required", http.StatusBadRequest)
+        render.Error(w, http.StatusBadRequest, "image file required")
         return
     }
     defer file.Close()
+    if header.Size > imageMaxFileBytes() {
+        render.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("%s exceeds %d MB", header.Filename, imageMaxFileBytes()>>20))
+        return
+    }
 
     // Ensure uploads directory exists. Use UPLOAD_DIR env or default.
     uploadDir := getenv("UPLOAD_DIR", "uploads")
     if err := os.MkdirAll(uploadDir, 0o755); err != nil {
-        http.Error(w, "cannot create upload dir: "+err.Error(), http.StatusInternalServerError)
+        render.Error(w, http.StatusInternalServerError, "cannot create upload dir: "+err.Error())
         return
     }
     // Determine file extension from original filename (fallback to .png).
@@ -58,22 +69,35 @@ func (a *App) uploadImage(w http.ResponseWriter, r *http.Request) {
 
     dst, err := os.Create(destPath)
     if err != nil {
-        http.Error(w, "cannot save file: "+err.Error(), http.StatusInternalServerError)
+        render.Error(w, http.StatusInternalServerError, "cannot save file: "+err.Error())
         return
     }
     defer dst.Close()
 
     if _, err := io.Copy(dst, file); err != nil {
-        http.Error(w, "write file error: "+err.Error(), http.StatusInternalServerError)
+        render.Error(w, http.StatusInternalServerError, "write file error: "+err.Error())
         return
     }
-    // Build the full URL. Use the request's host and scheme.
-    scheme := "http"
-    if r.TLS != nil {
-        scheme = "https"
+    dst.Close()
+
+    // Antivírus (opcional, AV_SCANNER). Arquivos recusados vão para a
+    // quarentena e não ficam acessíveis em /uploads.
+    t, _ := tenantFrom(r.Context())
+    scan := a.scanFile(r.Context(), t.OrgID, "upload", destPath)
+    if !scan.Accepted() {
+        render.APIError(w, http.StatusUnprocessableEntity, "file_rejected", "file rejected by antivirus", map[string]any{"scan": scan})
+        return
+    }
+    // Variantes thumb/medium/large (image_resize.go), geradas antes de o
+    // original sair do disco local.
+    sizes := makeImageVariants(r.Context(), r, destPath)
+    // Publica no driver de armazenamento (STORAGE_DRIVER: disco local ou S3).
+    url, err := storeUpload(r.Context(), r, destPath, header.Header.Get("Content-Type"))
+    if err != nil {
+        render.Error(w, http.StatusBadGateway, "storage error: "+err.Error())
+        return
     }
-    // r.Host includes host and port
-    url := fmt.Sprintf("%s://%s/uploads/%s", scheme, r.Host, filename)
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"url": url})
+    a.saveImageVariants(r.Context(), t.OrgID, url, sizes)
+    sizes["original"] = url
+    render.OK(w, map[string]any{"url": url, "sizes": sizes, "scan": scan})
 }
\ No newline at end of file
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,41 +10,79 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
 )
 
 /*
    WHATSAPP (uazapi) - Handlers consolidados com escopo por conta (org_id/flow_id)
 
    - Cada instância fica vinculada a um tenant (org_id, flow_id).
-   - Todos os endpoints validam o acesso: mesmo tenant OU token correto da instância.
+   - Todos os endpoints validam o acesso (waInstanceAccess): mesmo tenant OU token
+     correto da instância; o token guardado é que vai ao provedor.
    - Webhook da uazapi continua em webhook_wa.go (encaminhando p/ Agente com headers do tenant).
+   - Toda chamada ao provedor passa pelo cliente do pacote waprovider
+     (UAZAPI_AUTH_MODE=bearer|admintoken).
 */
 
 // ================================
 // Montagem das rotas (chamada por main.go)
 // ================================
 func (app *App) mountWhatsApp(r chi.Router) {
-	// Garante que as tabelas necessárias existam (idempotente)
-	if err := app.ensureWhatsAppTables(context.Background()); err != nil {
-		log.Printf("ensureWhatsAppTables: %v", err)
-	}
+	// Tabelas wa_instances/webhooks_log: migrations/0001 e 0002.
+	registerJob(jobAgentForward, jobPolicy{MaxAttempts: 6, Timeout: 30 * time.Second}, app.runAgentForward)
 
 	r.Route("/wa", func(r chi.Router) {
-		r.Post("/instances", app.waCreateInstance)
-
-		r.Get("/instances/{instance}/status", app.waInstanceStatus)
-		r.Get("/instances/{instance}/qr", app.waInstanceQR)
-		r.Get("/instances/{instance}/qrcode", app.waInstanceQR) // alias
+		// criar instância exige JWT de admin/owner (rbac.go) e, se
+		// EMAIL_VERIFY_REQUIRED tiver "whatsapp", e-mail verificado
+		r.With(app.requireRole(roleAdmin), app.requireVerifiedEmail).Post("/instances", app.waCreateInstance)
+		r.With(app.requireRole(roleAdmin), app.requireVerifiedEmail).Post("/instances/meta", app.waCreateMetaInstance)
+		r.Get("/messages/{id}", app.getWAMessage) // status de entrega (wa_message_status.go)
+		// fora do subrouter abaixo: o admin da org move instâncias de qualquer
+		// flow dela, sem o token da instância (wa_instance_transfer.go)
+		r.With(app.requireRole(roleAdmin)).Post("/instances/{instance}/transfer", app.waTransferInstance)
+
+		// dono e token da instância conferidos uma vez (wa_instance_access.go)
+		r.Route("/instances/{instance}", func(r chi.Router) {
+			r.Use(app.waInstanceAccess)
+
+			// apagar e desconectar também exigem admin/owner, como criar
+			r.With(app.requireRole(roleAdmin)).Delete("/", app.waDeleteInstance)
+			r.With(app.requireRole(roleAdmin)).Post("/logout", app.waLogoutInstance)
+
+			r.Get("/status", app.waInstanceStatus)
+			r.Get("/events", app.waConnectionEvents) // histórico de conexão (wa_instance_events.go)
+			r.Get("/qr", app.waInstanceQR)
+			r.Get("/qrcode", app.waInstanceQR)          // alias
+			r.Get("/qr/stream", app.waInstanceQRStream) // SSE com QR sempre válido (wa_qr_refresh.go)
+
+			r.Post("/webhook", app.waSetWebhook)
+			r.Post("/webhook/reprovision", app.waReprovisionWebhook)
+			r.With(app.idempotent).Post("/send/text", app.waSendText)
+			r.With(app.idempotent).Post("/send/video", app.waSendVideo)
+			r.With(app.idempotent).Post("/send/media", app.waSendMedia)
+			r.With(app.idempotent).Post("/send/template", app.waSendTemplate)
+
+			r.Get("/window", app.waContactWindow)
+			r.Get("/templates", app.waListTemplates)
+			r.Post("/templates", app.waSubmitTemplate)
+			r.Post("/templates/sync", app.waSyncTemplates)
+		})
 
-		r.Post("/instances/{instance}/webhook", app.waSetWebhook)
-		r.Post("/instances/{instance}/send/text", app.waSendText)
+		app.mountWAMock(r) // /api/wa/mock/inject (só com o provedor simulado)
 	})
+
+	if every := waTemplatePollInterval(); every > 0 {
+		go app.waTemplatePollLoop(every)
+	}
+	if every := waQRRefreshInterval(); every > 0 {
+		go app.waQRRefreshLoop(every)
+	}
 }
 
 // ================================
@@ -56,88 +93,14 @@ type waCreateReq struct {
 }
 
 type waSendTextReq struct {
-	Token string `json:"token"`
-	To    string `json:"to"`
-	Text  string `json:"text"`
-}
-
-type uazClient struct {
-	BaseURL    string
-	APIKey     string
-	AuthHeader string // ex.: "Authorization" ou "X-API-KEY"
-	AuthValue  string // ex.: "Bearer %s" (interpolando APIKey)
-	HTTP       *http.Client
-}
-
-func newUAZClient() *uazClient {
-	base := strings.TrimRight(os.Getenv("UAZAPI_BASE"), "/")
-	apiKey := os.Getenv("UAZAPI_TOKEN")
-	hName := os.Getenv("UAZAPI_AUTH_HEADER")
-	if hName == "" {
-		hName = "Authorization"
-	}
-	hVal := os.Getenv("UAZAPI_AUTH_VALUE")
-	if hVal == "" {
-		hVal = "Bearer %s"
-	}
-	return &uazClient{
-		BaseURL:    base,
-		APIKey:     apiKey,
-		AuthHeader: hName,
-		AuthValue:  hVal,
-		HTTP:       &http.Client{Timeout: 35 * time.Second},
-	}
-}
-
-func (c *uazClient) configured() bool { return c.BaseURL != "" }
-
-// Faz requisição JSON ao provedor uazapi; se body!=nil, envia como JSON.
-func (c *uazClient) doJSON(ctx context.Context, method, path string, q url.Values, body any) (*http.Response, error) {
-	if !c.configured() {
-		return nil, errors.New("uazapi not configured (defina UAZAPI_BASE)")
-	}
-	u := c.BaseURL + path
-	if len(q) > 0 {
-		u += "?" + q.Encode()
-	}
-	var rdr io.Reader
-	if body != nil {
-		b, _ := json.Marshal(body)
-		rdr = bytes.NewReader(b)
-	}
-	req, err := http.NewRequestWithContext(ctx, method, u, rdr)
-	if err != nil {
-		return nil, err
-	}
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	// Header de autenticação do provedor
-	if c.AuthHeader != "" {
-		val := c.AuthValue
-		if strings.Contains(val, "%s") {
-			val = fmt.Sprintf(val, c.APIKey)
-		}
-		if val == "" {
-			val = c.APIKey
-		}
-		if val != "" {
-			req.Header.Set(c.AuthHeader, val)
-		}
-	}
-	return c.HTTP.Do(req)
+	To   string `json:"to"`
+	Text string `json:"text"`
 }
 
-func parseIntHeader(r *http.Request, key string, def int64) int64 {
-	v := strings.TrimSpace(r.Header.Get(key))
-	if v == "" {
-		return def
-	}
-	n, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		return def
-	}
-	return n
+type waSendVideoReq struct {
+	To      string `json:"to"`
+	URL     string `json:"url"`
+	Caption string `json:"caption"`
 }
 
 // Extrai string de mapa JSON com múltiplas chaves candidatas
@@ -185,24 +148,34 @@ type waInstanceRow struct {
 	OrgID      int64
 	FlowID     int64
 	WebhookURL string
+	State      string
+	// API oficial (provider = "meta_cloud"); vazios nas instâncias uazapi
+	Provider    string
+	MetaWABAID  string
+	MetaPhoneID string
+	MetaToken   string
 }
 
 func (app *App) fetchWAInstance(ctx context.Context, instanceID string) (waInstanceRow, error) {
 	var row waInstanceRow
 	err := app.DB.QueryRow(ctx, `
-		SELECT instance_id, token, org_id, flow_id, COALESCE(webhook_url,'')
+		SELECT instance_id, token, org_id, flow_id, COALESCE(webhook_url,''), COALESCE(state,''),
+		       provider, COALESCE(meta_waba_id,''), COALESCE(meta_phone_number_id,''), COALESCE(meta_access_token,'')
 		FROM public.wa_instances
-		WHERE instance_id = $1
+		WHERE instance_id = $1 AND deleted_at IS NULL
 		LIMIT 1
-	`, instanceID).Scan(&row.InstanceID, &row.Token, &row.OrgID, &row.FlowID, &row.WebhookURL)
+	`, instanceID).Scan(&row.InstanceID, &row.Token, &row.OrgID, &row.FlowID, &row.WebhookURL, &row.State,
+		&row.Provider, &row.MetaWABAID, &row.MetaPhoneID, &row.MetaToken)
+	if err == nil && waprovider.UsingMock() && row.Provider != waProviderMetaCloud {
+		// o provedor simulado perde a memória em um restart
+		waprovider.DefaultMock.Register(row.InstanceID, row.Token)
+	}
 	return row, err
 }
 
 func (app *App) authorizeInstanceAccess(r *http.Request, row waInstanceRow, suppliedToken string) bool {
-	reqOrg := parseIntHeader(r, "X-Org-ID", -1)
-	reqFlow := parseIntHeader(r, "X-Flow-ID", -1)
 	// Regra: ou é o mesmo tenant, ou possui o token da instância
-	if reqOrg > 0 && reqFlow > 0 && row.OrgID == reqOrg && row.FlowID == reqFlow {
+	if t, ok := tenantFrom(r.Context()); ok && t.OrgID > 0 && t.FlowID > 0 && row.OrgID == t.OrgID && row.FlowID == t.FlowID {
 		return true
 	}
 	if strings.TrimSpace(suppliedToken) != "" && strings.TrimSpace(suppliedToken) == strings.TrimSpace(row.Token) {
@@ -211,38 +184,6 @@ func (app *App) authorizeInstanceAccess(r *http.Request, row waInstanceRow, supp
 	return false
 }
 
-// ================================
-// Tabelas necessárias
-// ================================
-func (app *App) ensureWhatsAppTables(ctx context.Context) error {
-	// wa_instances
-	_, err := app.DB.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS public.wa_instances (
-  instance_id TEXT PRIMARY KEY,
-  token       TEXT NOT NULL,
-  org_id      BIGINT NOT NULL DEFAULT 1,
-  flow_id     BIGINT NOT NULL DEFAULT 1,
-  webhook_url TEXT,
-  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`)
-	if err != nil {
-		return err
-	}
-	// Índice auxiliar por tenant
-	_, _ = app.DB.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_wa_instances_org_flow ON public.wa_instances(org_id, flow_id);`)
-
-	// webhooks_log (usada pelo webhook_wa.go)
-	_, err = app.DB.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS public.webhooks_log (
-  id         BIGSERIAL PRIMARY KEY,
-  source     TEXT,
-  payload    JSONB,
-  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`)
-	return err
-}
-
 // Upsert da instância no banco
 func (app *App) upsertWAInstance(ctx context.Context, instanceID, token string, orgID, flowID int64, webhookURL string) error {
 	_, err := app.DB.Exec(ctx, `
@@ -254,6 +195,7 @@ SET
   org_id      = EXCLUDED.org_id,
   flow_id     = EXCLUDED.flow_id,
   webhook_url = COALESCE(EXCLUDED.webhook_url, public.wa_instances.webhook_url),
+  deleted_at  = NULL,
   updated_at  = NOW()
 `, instanceID, token, orgID, flowID, webhookURL)
 	return err
@@ -266,46 +208,23 @@ SET
 // POST /api/wa/instances
 func (app *App) waCreateInstance(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_ = app.ensureWhatsAppTables(ctx)
 
 	var in waCreateReq
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Name) == "" {
-		http.Error(w, "invalid body: expected {\"name\":\"...\"}", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "invalid body: expected {\"name\":\"...\"}")
 		return
 	}
-	orgID := parseIntHeader(r, "X-Org-ID", 1)
-	flowID := parseIntHeader(r, "X-Flow-ID", 1)
-
-	uaz := newUAZClient()
-
-	// Caso não exista configuração de UAZAPI, retornamos um "mock" funcional para o front (modo demo).
-	if !uaz.configured() {
-		inst := strings.ToLower(strings.ReplaceAll(in.Name, " ", "-")) + "-" + randToken(6)
-		tok := randToken(32)
+	// org/flow do JWT (requireRole); os headers só valem se baterem com ele
+	orgID, flowID, _ := tenantOf(r)
 
-		// persiste/atualiza
-		_ = app.upsertWAInstance(ctx, inst, tok, orgID, flowID, "")
-
-		out := map[string]any{
-			"instanceId": inst,
-			"token":      tok,
-			"connect": map[string]any{
-				"status":  "waiting-qr",
-				"qrcode":  "UAZAPI_MOCK_" + inst,
-				"message": "UAZAPI_BASE não configurado; retornando modo mock.",
-			},
-		}
-		// Seu writeJSON tem assinatura writeJSON(w, v)
-		writeJSON(w, out)
-		return
-	}
+	uaz := app.uazapiFor(ctx, orgID)
 
 	// Provedor real: tentamos caminho padrão "/instances"
-	resp, err := uaz.doJSON(ctx, http.MethodPost, "/instances", nil, map[string]any{
+	resp, err := uaz.DoJSON(ctx, http.MethodPost, "/instances", nil, map[string]any{
 		"name": in.Name,
 	})
 	if err != nil {
-		http.Error(w, "provider error: "+err.Error(), http.StatusBadGateway)
+		render.Error(w, http.StatusBadGateway, "provider error: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
@@ -337,54 +256,39 @@ func (app *App) waCreateInstance(w http.ResponseWriter, r *http.Request) {
 	if token != "" {
 		raw["token"] = token
 	}
-	writeJSON(w, raw)
+	render.OK(w, raw)
 }
 
-// GET /api/wa/instances/{instance}/status?token=...
+// GET /api/wa/instances/{instance}/status
 func (app *App) waInstanceStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	instance := chi.URLParam(r, "instance")
-	if strings.TrimSpace(instance) == "" {
-		http.Error(w, "missing instance", http.StatusBadRequest)
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
 		return
 	}
-	suppliedToken := strings.TrimSpace(r.URL.Query().Get("token"))
-
-	row, err := app.fetchWAInstance(ctx, instance)
-	if err != nil {
-		http.Error(w, "instance not found", http.StatusNotFound)
-		return
-	}
-	if !app.authorizeInstanceAccess(r, row, suppliedToken) {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	instance := row.InstanceID
+	// API oficial: não há sessão/QR para consultar
+	if row.Provider == waProviderMetaCloud {
+		render.OK(w, map[string]any{"instance": instance, "status": chooseFirstNonEmpty(row.State, "connected"), "provider": row.Provider})
 		return
 	}
 
-	uaz := newUAZClient()
-	// Sem provedor: modo mock
-	if !uaz.configured() {
-		out := map[string]any{
-			"instance": instance,
-			"status":   "waiting-qr",
-			"qrcode":   "UAZAPI_MOCK_" + instance,
-			"connect": map[string]any{
-				"status": "waiting-qr",
-			},
-		}
-		writeJSON(w, out)
-		return
-	}
+	uaz := app.uazapiFor(ctx, row.OrgID)
 
+	// modo bearer: o token guardado da instância vai na query
 	q := url.Values{}
-	if suppliedToken != "" {
-		q.Set("token", suppliedToken)
-	} else if row.Token != "" {
-		// fallback: usa o token persistido caso o front não tenha enviado
+	if row.Token != "" {
 		q.Set("token", row.Token)
 	}
-	resp, err := uaz.doJSON(ctx, http.MethodGet, "/instances/"+url.PathEscape(instance)+"/status", q, nil)
+	// em backoff (429 do provedor) responde com o último estado conhecido
+	// em vez de entrar na fila
+	if b := waprovider.BackoffFor(instance); b.Active {
+		render.OK(w, map[string]any{"instance": instance, "status": chooseFirstNonEmpty(row.State, "unknown"), "backoff": b})
+		return
+	}
+	resp, err := uaz.DoInstance(ctx, http.MethodGet, waprovider.InstancePath(instance, "/status"), q.Get("token"), q, nil)
 	if err != nil {
-		http.Error(w, "provider error: "+err.Error(), http.StatusBadGateway)
+		render.Error(w, http.StatusBadGateway, "provider error: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
@@ -407,61 +311,57 @@ func (app *App) waInstanceStatus(w http.ResponseWriter, r *http.Request) {
 			data["status"] = s
 		}
 	}
-	writeJSON(w, data)
+	// o polling do front também detecta quedas que não chegaram por webhook
+	if state := normalizeWAState(fmt.Sprint(data["status"])); state != "" {
+		app.handleWAStateChange(ctx, instance, state, waStateCause{Source: "poll"}, instanceInfo{
+			Token:  row.Token,
+			OrgID:  strconv.FormatInt(row.OrgID, 10),
+			FlowID: strconv.FormatInt(row.FlowID, 10),
+		})
+	}
+	app.noteWAQR(ctx, row, data) // qr_expires_at/qr_expired (wa_qr_refresh.go)
+	data["backoff"] = waprovider.BackoffFor(instance)
+	render.OK(w, data)
 }
 
 // GET /api/wa/instances/{instance}/qr  (ou /qrcode)
 func (app *App) waInstanceQR(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	instance := chi.URLParam(r, "instance")
-	if strings.TrimSpace(instance) == "" {
-		http.Error(w, "missing instance", http.StatusBadRequest)
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
 		return
 	}
-	suppliedToken := strings.TrimSpace(r.URL.Query().Get("token"))
+	instance := row.InstanceID
 
-	row, err := app.fetchWAInstance(ctx, instance)
-	if err != nil {
-		http.Error(w, "instance not found", http.StatusNotFound)
-		return
-	}
-	if !app.authorizeInstanceAccess(r, row, suppliedToken) {
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return
-	}
-
-	uaz := newUAZClient()
-	if !uaz.configured() {
-		out := map[string]any{
-			"instance": instance,
-			"qrcode":   "UAZAPI_MOCK_" + instance,
-			"status":   "waiting-qr",
-		}
-		writeJSON(w, out)
-		return
-	}
+	uaz := app.uazapiFor(ctx, row.OrgID)
 
 	q := url.Values{}
-	if suppliedToken != "" {
-		q.Set("token", suppliedToken)
-	} else if row.Token != "" {
+	if row.Token != "" {
 		q.Set("token", row.Token)
 	}
 
 	// Tentamos endpoint /qr e /qrcode
 	paths := []string{
-		"/instances/" + url.PathEscape(instance) + "/qr",
-		"/instances/" + url.PathEscape(instance) + "/qrcode",
+		waprovider.InstancePath(instance, "/qr"),
+		waprovider.InstancePath(instance, "/qrcode"),
 	}
 	var lastBody []byte
 	for _, p := range paths {
-		resp, err := uaz.doJSON(ctx, http.MethodGet, p, q, nil)
+		resp, err := uaz.DoInstance(ctx, http.MethodGet, p, q.Get("token"), q, nil)
 		if err != nil {
 			continue
 		}
 		b, _ := io.ReadAll(resp.Body)
 		_ = resp.Body.Close()
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 && len(b) > 0 {
+			// guarda o QR e devolve a validade (wa_qr_refresh.go)
+			var data map[string]any
+			if json.Unmarshal(b, &data) == nil && data != nil {
+				app.watchWAQR(ctx, instance)
+				app.noteWAQR(ctx, row, data)
+				render.OK(w, data)
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			_, _ = w.Write(b)
@@ -476,49 +376,39 @@ func (app *App) waInstanceQR(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write(lastBody)
 		return
 	}
-	writeJSON(w, map[string]any{"instance": instance, "status": "waiting-qr"})
+	render.OK(w, map[string]any{"instance": instance, "status": "waiting-qr"})
 }
 
 // POST /api/wa/instances/{instance}/webhook
 func (app *App) waSetWebhook(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	instance := chi.URLParam(r, "instance")
-	if strings.TrimSpace(instance) == "" {
-		http.Error(w, "missing instance", http.StatusBadRequest)
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
 		return
 	}
+	instance := row.InstanceID
 	var body map[string]any
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "invalid body")
 		return
 	}
 	webhookURL := strings.TrimSpace(fmt.Sprint(body["url"]))
-	token := strings.TrimSpace(fmt.Sprint(body["token"]))
-
-	row, err := app.fetchWAInstance(ctx, instance)
-	if err != nil {
-		http.Error(w, "instance not found", http.StatusNotFound)
-		return
-	}
-	// Acesso autorizado?
-	if !app.authorizeInstanceAccess(r, row, token) {
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return
-	}
+	// "token" no corpo troca o token guardado (o middleware já conferiu o dono)
+	token, _ := body["token"].(string)
+	token = strings.TrimSpace(token)
 
 	// Atualiza DB (salva URL do webhook)
-	_ = app.upsertWAInstance(ctx, instance, chooseFirstNonEmpty(token, row.Token), parseIntHeader(r, "X-Org-ID", row.OrgID), parseIntHeader(r, "X-Flow-ID", row.FlowID), webhookURL)
-
-	uaz := newUAZClient()
-	if !uaz.configured() {
-		// Modo demo: registra localmente e responde ok
-		writeJSON(w, map[string]any{"ok": true, "message": "webhook salvo (mock)"})
-		return
+	orgID, flowID := row.OrgID, row.FlowID
+	if t, ok := tenantFrom(r.Context()); ok && t.OrgID > 0 && t.FlowID > 0 {
+		orgID, flowID = t.OrgID, t.FlowID
 	}
+	_ = app.upsertWAInstance(ctx, instance, chooseFirstNonEmpty(token, row.Token), orgID, flowID, webhookURL)
+
+	uaz := app.uazapiFor(ctx, row.OrgID)
 	// Proxy p/ provedor
-	resp, err := uaz.doJSON(ctx, http.MethodPost, "/instances/"+url.PathEscape(instance)+"/webhook", nil, body)
+	resp, err := uaz.DoInstance(ctx, http.MethodPost, waprovider.InstancePath(instance, "/webhook"), chooseFirstNonEmpty(token, row.Token), nil, body)
 	if err != nil {
-		http.Error(w, "provider error: "+err.Error(), http.StatusBadGateway)
+		render.Error(w, http.StatusBadGateway, "provider error: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
@@ -527,58 +417,74 @@ func (app *App) waSetWebhook(w http.ResponseWriter, r *http.Request) {
 	if out == nil {
 		out = map[string]any{"ok": resp.StatusCode >= 200 && resp.StatusCode < 300}
 	}
-	writeJSON(w, out)
+	render.OK(w, out)
 }
 
 // POST /api/wa/instances/{instance}/send/text
 func (app *App) waSendText(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	instance := chi.URLParam(r, "instance")
-	if strings.TrimSpace(instance) == "" {
-		http.Error(w, "missing instance", http.StatusBadRequest)
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
 		return
 	}
 	var in waSendTextReq
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		http.Error(w, "invalid body", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "invalid body")
 		return
 	}
 	if strings.TrimSpace(in.To) == "" || strings.TrimSpace(in.Text) == "" {
-		http.Error(w, "missing to/text", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "missing to/text")
 		return
 	}
 
-	row, err := app.fetchWAInstance(ctx, instance)
+	out, status, err := app.sendWAText(ctx, row, row.Token, in.To, in.Text)
 	if err != nil {
-		http.Error(w, "instance not found", http.StatusNotFound)
-		return
-	}
-	if !app.authorizeInstanceAccess(r, row, in.Token) {
-		http.Error(w, "forbidden", http.StatusForbidden)
+		writeWASendError(w, err, status)
 		return
 	}
+	render.OK(w, out)
+}
 
-	uaz := newUAZClient()
-	if !uaz.configured() {
-		// Modo demo: tudo certo
-		writeJSON(w, map[string]any{
-			"ok":      true,
-			"mock":    true,
-			"message": "Mensagem simulada (UAZAPI_BASE não configurado)",
-		})
-		return
-	}
+// sendWAText envia um texto pela instância via uazapi (real ou simulada).
+// Em caso de erro devolve também o status HTTP adequado para o chamador.
+func (app *App) sendWAText(ctx context.Context, row waInstanceRow, token, to, text string) (map[string]any, int, error) {
+	return app.waProviderSend(ctx, row, token, "/send/text", map[string]any{
+		"to":   to,
+		"text": text,
+	})
+}
 
+// waProviderSend faz o POST de envio em /instances/{id}{suffix}. O token da
+// instância é incluído no corpo (modo bearer) e no header (modo admintoken).
+// Instâncias da API oficial seguem por metaCloudSend. Toda chamada conta no
+// uso diário da org (provider_quota.go) e o envio aceito fica em wa_messages
+// para o acompanhamento de entrega (wa_message_status.go).
+func (app *App) waProviderSend(ctx context.Context, row waInstanceRow, token, suffix string, body map[string]any) (result map[string]any, status int, err error) {
+	defer func() {
+		app.recordProviderCall(row.OrgID, chooseFirstNonEmpty(row.Provider, "uazapi"), status, err)
+		if err == nil {
+			app.recordWAOutbound(ctx, row, suffix, body, providerMessageID(result))
+		}
+	}()
+	if row.Provider == waProviderMetaCloud {
+		return app.metaCloudSend(ctx, row, suffix, body)
+	}
+	uaz := app.uazapiFor(ctx, row.OrgID)
 	// Proxy p/ provedor
-	reqBody := map[string]any{
-		"token": chooseFirstNonEmpty(in.Token, row.Token),
-		"to":    in.To,
-		"text":  in.Text,
+	token = chooseFirstNonEmpty(token, row.Token)
+	body["token"] = token
+	resp, err := uaz.DoInstance(ctx, http.MethodPost, waprovider.InstancePath(row.InstanceID, suffix), token, nil, body)
+	var rl *waprovider.RateLimitedError
+	if errors.As(err, &rl) {
+		return nil, http.StatusTooManyRequests, err
 	}
-	resp, err := uaz.doJSON(ctx, http.MethodPost, "/instances/"+url.PathEscape(instance)+"/send/text", nil, reqBody)
 	if err != nil {
-		http.Error(w, "provider error: "+err.Error(), http.StatusBadGateway)
-		return
+		return nil, http.StatusBadGateway, errors.New("provider error: " + err.Error())
+	}
+	// 429 mesmo após as novas tentativas do cliente
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, http.StatusTooManyRequests, errors.New("provider rate limited")
 	}
 	defer resp.Body.Close()
 
@@ -589,13 +495,47 @@ func (app *App) waSendText(w http.ResponseWriter, r *http.Request) {
 		if msg == "" {
 			msg = "disconnected or provider error"
 		}
-		http.Error(w, msg, http.StatusServiceUnavailable)
-		return
+		return nil, http.StatusServiceUnavailable, errors.New(msg)
 	}
 	var out map[string]any
 	_ = json.NewDecoder(resp.Body).Decode(&out)
 	if out == nil {
 		out = map[string]any{"ok": true}
 	}
-	writeJSON(w, out)
+	return out, http.StatusOK, nil
+}
+
+// POST /api/wa/instances/{instance}/send/video
+// O vídeo é referenciado por URL (ex.: video_url do produto). Antes do envio
+// conferimos tipo, tamanho (VIDEO_MAX_MB) e duração (VIDEO_MAX_SECONDS).
+func (app *App) waSendVideo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
+		return
+	}
+	var in waSendVideoReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if strings.TrimSpace(in.To) == "" || strings.TrimSpace(in.URL) == "" {
+		render.Error(w, http.StatusBadRequest, "missing to/url")
+		return
+	}
+	if err := validateRemoteVideo(ctx, in.URL); err != nil {
+		render.Error(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	out, status, err := app.waProviderSend(ctx, row, row.Token, "/send/video", map[string]any{
+		"to":      in.To,
+		"url":     in.URL,
+		"caption": in.Caption,
+	})
+	if err != nil {
+		writeWASendError(w, err, status)
+		return
+	}
+	render.OK(w, out)
 }
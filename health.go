@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Probes de liveness e readiness
+// ================================================================
+//
+// GET /livez   processo no ar (não toca em dependências); 200 sempre
+// GET /readyz  pinga o Postgres e, com READYZ_CHECK_UAZAPI=true, o
+//              UAZAPI_BASE; 503 se alguma dependência falhar:
+//
+//   {"status":"fail","checks":{"postgres":{"status":"ok","latency_ms":2},
+//                              "uazapi":{"status":"fail","error":"..."}}}
+//
+// Cada checagem tem READYZ_TIMEOUT (padrão 2s). /healthz continua como
+// apelido de /livez para probes antigos.
+
+type healthCheck struct {
+	Status    string `json:"status"` // ok | fail
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (a *App) mountHealth(r chi.Router) {
+	r.Get("/livez", a.livez)
+	r.Get("/healthz", a.livez)
+	r.Get("/readyz", a.readyz)
+}
+
+func readyzTimeout() time.Duration {
+	d, err := time.ParseDuration(getenv("READYZ_TIMEOUT", "2s"))
+	if err != nil || d <= 0 {
+		return 2 * time.Second
+	}
+	return d
+}
+
+func (a *App) livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	render.OK(w, map[string]string{"status": "ok"})
+}
+
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(context.Context) error{
+		"postgres": a.DB.Ping,
+	}
+	if strings.EqualFold(getenv("READYZ_CHECK_UAZAPI", "false"), "true") {
+		checks["uazapi"] = waprovider.FromEnv().Ping
+	}
+
+	timeout := readyzTimeout()
+	out := make(map[string]healthCheck, len(checks))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			start := time.Now()
+			err := check(ctx)
+			c := healthCheck{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				c.Status, c.Error = "fail", err.Error()
+			}
+			mu.Lock()
+			out[name] = c
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, c := range out {
+		if c.Status != "ok" {
+			status, code = "fail", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	render.JSON(w, code, map[string]any{"status": status, "checks": out})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Idempotency-Key em rotas de escrita
+// ================================================================
+//
+// POST /api/orders, /api/leads, /api/products e /api/wa/instances/{i}/send/*
+// aceitam o header Idempotency-Key (até 255 caracteres). A primeira resposta
+// (status < 500) fica em idempotency_keys por IDEMPOTENCY_TTL (padrão 24h) e
+// é devolvida igual, com Idempotent-Replayed: true, a cada repetição da
+// mesma chave — retries do n8n não criam lead ou pedido duplicado.
+//
+//   - a chave é por org; reutilizá-la com outro corpo, outra rota, outra
+//     query string ou outro flow dá 422
+//   - repetição enquanto a primeira ainda roda dá 409
+//   - resposta 5xx não é guardada: a chave fica livre para nova tentativa
+//   - corpo acima de 32 MB com a chave dá 413
+//
+// Sem o header, ou sem org resolvida, a rota funciona como antes.
+
+const (
+	idempotencyHeader     = "Idempotency-Key"
+	idempotencyMaxKey     = 255
+	idempotencyMaxBody    = 1 << 20  // resposta guardada
+	idempotencyMaxRequest = 32 << 20 // corpo lido para o fingerprint
+)
+
+func idempotencyTTL() time.Duration {
+	d, err := time.ParseDuration(getenv("IDEMPOTENCY_TTL", "24h"))
+	if err != nil || d <= 0 {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// idempotent é o middleware das rotas acima; o tenant já vem resolvido.
+func (a *App) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(idempotencyHeader))
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, _ := tenantFrom(r.Context())
+		if t.OrgID == 0 {
+			// sem org as chaves de tenants diferentes colidiriam
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKey {
+			render.Error(w, http.StatusBadRequest, "Idempotency-Key too long")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxRequest+1))
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, "read error")
+			return
+		}
+		if len(body) > idempotencyMaxRequest {
+			render.Error(w, http.StatusRequestEntityTooLarge, "request body too large for Idempotency-Key")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		head := fmt.Sprintf("%s %s?%s flow=%d\n", r.Method, r.URL.Path, r.URL.RawQuery, t.FlowID)
+		sum := sha256.Sum256(append([]byte(head), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx := r.Context()
+		tag, err := a.DB.Exec(ctx, `
+INSERT INTO idempotency_keys (org_id, key, fingerprint, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (org_id, key) DO UPDATE
+SET fingerprint=EXCLUDED.fingerprint, status_code=NULL, content_type=NULL, body=NULL,
+    created_at=NOW(), expires_at=EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < NOW()`,
+			t.OrgID, key, fingerprint, time.Now().Add(idempotencyTTL()))
+		if err != nil {
+			// sem a tabela a rota segue sem proteção, como antes
+			log.Printf("idempotency org=%d: %v", t.OrgID, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			a.replayIdempotent(w, r, t.OrgID, key, fingerprint)
+			return
+		}
+
+		var buf bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&limitedWriter{w: &buf, n: idempotencyMaxBody + 1})
+		next.ServeHTTP(ww, r)
+
+		// o contexto da requisição pode já ter sido cancelado
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= 500 || buf.Len() > idempotencyMaxBody {
+			_, err = a.DB.Exec(sctx, `DELETE FROM idempotency_keys WHERE org_id=$1 AND key=$2`, t.OrgID, key)
+		} else {
+			_, err = a.DB.Exec(sctx, `
+UPDATE idempotency_keys SET status_code=$3, content_type=$4, body=$5
+ WHERE org_id=$1 AND key=$2`, t.OrgID, key, status, ww.Header().Get("Content-Type"), buf.Bytes())
+		}
+		if err != nil {
+			log.Printf("idempotency org=%d store: %v", t.OrgID, err)
+		}
+	})
+}
+
+// replayIdempotent responde a uma chave já usada.
+func (a *App) replayIdempotent(w http.ResponseWriter, r *http.Request, orgID int64, key, fingerprint string) {
+	var (
+		fp          string
+		status      *int
+		contentType *string
+		body        []byte
+	)
+	err := a.DB.QueryRow(r.Context(), `
+SELECT fingerprint, status_code, content_type, body FROM idempotency_keys WHERE org_id=$1 AND key=$2`,
+		orgID, key).Scan(&fp, &status, &contentType, &body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// a primeira tentativa falhou (5xx) entre o INSERT e esta leitura
+		render.Error(w, http.StatusConflict, "request with this Idempotency-Key failed, retry")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if fp != fingerprint {
+		render.Error(w, http.StatusUnprocessableEntity, "Idempotency-Key already used with a different request")
+		return
+	}
+	if status == nil {
+		render.Error(w, http.StatusConflict, "request with this Idempotency-Key is still in progress")
+		return
+	}
+	if contentType != nil && *contentType != "" {
+		w.Header().Set("Content-Type", *contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*status)
+	_, _ = w.Write(body)
+}
+
+// limitedWriter guarda no máximo n bytes e descarta o resto sem erro, para
+// não atrapalhar a resposta real.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		k := min(len(p), l.n)
+		_, _ = l.w.Write(p[:k])
+		l.n -= k
+	}
+	return len(p), nil
+}
+
+// idempotencyCleanupLoop apaga as chaves vencidas de hora em hora.
+func (a *App) idempotencyCleanupLoop() {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if _, err := a.DB.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`); err != nil {
+			log.Printf("idempotency cleanup: %v", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================================================================
+//  Miniaturas de imagem de produto (job image.resize)
+// ================================================================
+//
+// Produto criado (evento product.created) ou com imagem trocada enfileira um
+// job que baixa a imagem principal (image_base64 com URL), reduz para no
+// máximo PRODUCT_THUMB_PX (padrão 400) pixels no maior lado, grava um JPEG
+// pelo driver de armazenamento e preenche products.image_thumb_url. Base64
+// legado e formatos que a biblioteca padrão não decodifica (webp) ficam sem
+// miniatura.
+//
+// Uploads (/api/upload e /api/vision/upload) já geram na hora as variantes
+// thumb, medium e large (PRODUCT_THUMB_PX, IMAGE_MEDIUM_PX=800,
+// IMAGE_LARGE_PX=1600), gravadas ao lado do original e registradas em
+// image_variants pela URL do original. A listagem do catálogo devolve o mapa
+// em image_sizes e o job acima só reaproveita a thumb já pronta.
+
+const (
+	jobImageResize    = "image.resize"
+	imageResizeMaxSrc = 15 << 20
+)
+
+type imageResizeJob struct {
+	ProductID int64  `json:"product_id"`
+	Image     string `json:"image"`
+}
+
+func (a *App) registerImageJobs() {
+	registerJob(jobImageResize, jobPolicy{MaxAttempts: 3, Timeout: 2 * time.Minute}, a.runImageResize)
+	onEvent(eventProductCreated, func(ctx context.Context, orgID int64, data any) {
+		if p, ok := data.(chatProduct); ok {
+			a.enqueueProductThumb(ctx, orgID, p.ID, p.ImageURL)
+		}
+	})
+}
+
+func productThumbPx() int {
+	n, err := strconv.Atoi(getenv("PRODUCT_THUMB_PX", "400"))
+	if err != nil || n < 32 {
+		return 400
+	}
+	return n
+}
+
+// enqueueProductThumb agenda a miniatura quando a imagem é uma URL. Nunca
+// falha para quem chama.
+func (a *App) enqueueProductThumb(ctx context.Context, orgID, productID int64, img string) {
+	img = strings.TrimSpace(img)
+	if productID <= 0 || !(strings.HasPrefix(img, "/uploads/") || isHTTPURL(img)) {
+		return
+	}
+	if _, err := a.enqueueJob(ctx, orgID, jobImageResize, imageResizeJob{ProductID: productID, Image: img}, time.Time{}); err != nil {
+		log.Printf("product %d thumb: %v", productID, err)
+	}
+}
+
+// imageVariant é um tamanho gerado no upload: maior lado em pixels.
+type imageVariant struct {
+	Name string
+	Px   int
+}
+
+func imageVariantSizes() []imageVariant {
+	px := func(env string, def int) int {
+		if n, err := strconv.Atoi(getenv(env, strconv.Itoa(def))); err == nil && n >= 32 {
+			return n
+		}
+		return def
+	}
+	// do maior para o menor: cada um é reduzido a partir do anterior
+	return []imageVariant{
+		{"large", px("IMAGE_LARGE_PX", 1600)},
+		{"medium", px("IMAGE_MEDIUM_PX", 800)},
+		{"thumb", productThumbPx()},
+	}
+}
+
+// makeImageVariants gera as variantes de um arquivo já gravado em UPLOAD_DIR
+// (antes de ele ir para o armazenamento) e devolve nome -> URL. Tamanho que
+// o original não alcança fica de fora; imagem que não decodifica volta vazio.
+func makeImageVariants(ctx context.Context, r *http.Request, localPath string) map[string]string {
+	sizes := map[string]string{}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return sizes
+	}
+	img, _, err := image.Decode(io.LimitReader(f, imageResizeMaxSrc))
+	f.Close()
+	if err != nil {
+		return sizes
+	}
+	base := strings.TrimSuffix(filepath.Base(localPath), filepath.Ext(localPath))
+	for _, v := range imageVariantSizes() {
+		b := img.Bounds()
+		if b.Dx() <= v.Px && b.Dy() <= v.Px {
+			continue
+		}
+		img = resizeImage(img, v.Px)
+		dst := filepath.Join(filepath.Dir(localPath), base+"_"+v.Name+".jpg")
+		out, err := os.Create(dst)
+		if err != nil {
+			log.Printf("image variant %s: %v", dst, err)
+			continue
+		}
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: 82})
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			sizes[v.Name], err = storeUpload(ctx, r, dst, "image/jpeg")
+		}
+		if err != nil {
+			_ = os.Remove(dst)
+			delete(sizes, v.Name)
+			log.Printf("image variant %s: %v", dst, err)
+		}
+	}
+	return sizes
+}
+
+// saveImageVariants registra as variantes pela URL do original (que é o que
+// os produtos guardam em image_base64).
+func (a *App) saveImageVariants(ctx context.Context, orgID int64, original string, sizes map[string]string) {
+	if original == "" || len(sizes) == 0 {
+		return
+	}
+	if _, err := a.DB.Exec(ctx, `
+INSERT INTO public.image_variants (url, org_id, sizes) VALUES ($1, NULLIF($2, 0), $3)
+ON CONFLICT (url) DO UPDATE SET sizes=EXCLUDED.sizes`, original, orgID, sizes); err != nil {
+		log.Printf("image variants %s: %v", original, err)
+	}
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+func (a *App) runImageResize(ctx context.Context, j job) error {
+	var in imageResizeJob
+	if err := j.decode(&in); err != nil {
+		return err
+	}
+	// upload que já gerou as variantes: só aponta a thumb
+	var ready string
+	if err := a.DB.QueryRow(ctx, `SELECT COALESCE(sizes->>'thumb','') FROM public.image_variants WHERE url=$1`, in.Image).Scan(&ready); err == nil && ready != "" {
+		_, err = a.DB.Exec(ctx,
+			`UPDATE products SET image_thumb_url=$1 WHERE id=$2 AND org_id=$3 AND image_base64=$4`,
+			ready, in.ProductID, j.OrgID, in.Image)
+		return err
+	}
+	src, err := openProductImage(ctx, in.Image)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	img, _, err := image.Decode(io.LimitReader(src, imageResizeMaxSrc))
+	if err != nil {
+		return permanentJobError(fmt.Errorf("decode %s: %w", in.Image, err))
+	}
+
+	uploadDir := getenv("UPLOAD_DIR", "uploads")
+	if err := os.MkdirAll(uploadDir, 0o755); err != nil {
+		return err
+	}
+	dst := filepath.Join(uploadDir, fmt.Sprintf("thumb_%d_%d.jpg", in.ProductID, time.Now().UnixNano()))
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(f, resizeImage(img, productThumbPx()), &jpeg.Options{Quality: 82})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	thumbURL, err := storeUpload(ctx, nil, dst, "image/jpeg")
+	if err != nil {
+		return err
+	}
+	// só grava se a imagem do produto ainda é a mesma do job
+	_, err = a.DB.Exec(ctx,
+		`UPDATE products SET image_thumb_url=$1 WHERE id=$2 AND org_id=$3 AND image_base64=$4`,
+		thumbURL, in.ProductID, j.OrgID, in.Image)
+	return err
+}
+
+// openProductImage abre a imagem do disco (/uploads/...) ou baixa por HTTP,
+// com as mesmas restrições de destino dos webhooks de saída.
+func openProductImage(ctx context.Context, ref string) (io.ReadCloser, error) {
+	// URLs do disco local (relativas ou com o host da API) são lidas direto
+	if u, err := url.Parse(ref); err == nil && strings.HasPrefix(u.Path, "/uploads/") {
+		f, err := os.Open(filepath.Join(getenv("UPLOAD_DIR", "uploads"), path.Base(u.Path)))
+		if err == nil || !isHTTPURL(ref) {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, permanentJobError(err)
+			}
+			return f, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, permanentJobError(err)
+	}
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("fetch %s: status %d", ref, resp.StatusCode)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return nil, permanentJobError(err)
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// resizeImage reduz src para caber em maxSide×maxSide (média por bloco),
+// sobre fundo branco. Imagens menores só perdem a transparência.
+func resizeImage(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	nw, nh := w, h
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			nw, nh = maxSide, max(1, h*maxSide/w)
+		} else {
+			nw, nh = max(1, w*maxSide/h), maxSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+		y1 = max(y1, y0+1)
+		for x := 0; x < nw; x++ {
+			x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+			x1 = max(x1, x0+1)
+			var sr, sg, sb, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, bl, al := src.At(sx, sy).RGBA()
+					// cores pré-multiplicadas: completa com branco o que falta de alfa
+					sr += uint64(r + 0xffff - al)
+					sg += uint64(g + 0xffff - al)
+					sb += uint64(bl + 0xffff - al)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(sr / n >> 8)
+			dst.Pix[i+1] = uint8(sg / n >> 8)
+			dst.Pix[i+2] = uint8(sb / n >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
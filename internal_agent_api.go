@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/agentv1"
+	"github.com/paclead/backend/render"
+)
+
+// API interna (serviço-a-serviço) consumida pelo backend do Agente IA.
+// O contrato está em proto/agent/v1/agent.proto e os tipos/cliente Go em
+// agentv1. Autenticação por segredo compartilhado (INTERNAL_API_TOKEN);
+// sem o segredo configurado, todas as chamadas são recusadas.
+
+// mountInternalAgentAPI registra POST /internal/twirp/agent.v1.AgentService/{method}.
+func (a *App) mountInternalAgentAPI(r chi.Router) {
+	r.Post(agentv1.ServicePath+"{method}", a.internalAgentRPC)
+}
+
+func (a *App) internalAgentRPC(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("INTERNAL_API_TOKEN")
+	if secret == "" {
+		writeRPCError(w, agentv1.CodeUnavailable, "INTERNAL_API_TOKEN not set")
+		return
+	}
+	got := headerTrim(r, agentv1.TokenHeader)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		writeRPCError(w, agentv1.CodeUnauthenticated, "invalid internal token")
+		return
+	}
+
+	switch chi.URLParam(r, "method") {
+	case "LookupInstance":
+		var in agentv1.LookupInstanceRequest
+		if !decodeRPC(w, r, &in) {
+			return
+		}
+		a.rpcLookupInstance(w, r, &in)
+	case "ListProducts":
+		var in agentv1.ListProductsRequest
+		if !decodeRPC(w, r, &in) {
+			return
+		}
+		a.rpcListProducts(w, r, &in)
+	case "GetProduct":
+		var in agentv1.GetProductRequest
+		if !decodeRPC(w, r, &in) {
+			return
+		}
+		a.rpcGetProduct(w, r, &in)
+	case "SendText":
+		var in agentv1.SendTextRequest
+		if !decodeRPC(w, r, &in) {
+			return
+		}
+		a.rpcSendText(w, r, &in)
+	case "ReportIntent":
+		var in agentv1.ReportIntentRequest
+		if !decodeRPC(w, r, &in) {
+			return
+		}
+		a.rpcReportIntent(w, r, &in)
+	default:
+		writeRPCError(w, agentv1.CodeNotFound, "unknown method")
+	}
+}
+
+func (a *App) rpcLookupInstance(w http.ResponseWriter, r *http.Request, in *agentv1.LookupInstanceRequest) {
+	if strings.TrimSpace(in.InstanceID) == "" {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "instance_id required")
+		return
+	}
+	row, err := a.fetchWAInstance(r.Context(), strings.TrimSpace(in.InstanceID))
+	if err != nil {
+		writeRPCError(w, agentv1.CodeNotFound, "instance not found")
+		return
+	}
+	out := agentv1.Instance{
+		InstanceID: row.InstanceID,
+		OrgID:      row.OrgID,
+		FlowID:     row.FlowID,
+		Token:      row.Token,
+		WebhookURL: row.WebhookURL,
+	}
+	p, err := a.effectivePersona(r.Context(), row.OrgID, row.FlowID, row.InstanceID)
+	if err != nil {
+		writeRPCError(w, agentv1.CodeInternal, err.Error())
+		return
+	}
+	out.Persona = &agentv1.Persona{
+		ID:                 p.ID,
+		Name:               p.Name,
+		CommunicationStyle: p.CommunicationStyle,
+		Sector:             p.Sector,
+		ProfileType:        p.ProfileType,
+		ProfileCustom:      p.ProfileCustom,
+		BasePrompt:         p.BasePrompt,
+		LLMProvider:        p.LLMProvider,
+		LLMModel:           p.LLMModel,
+	}
+	if out.SystemPrompt, _, err = a.agentSystemPrompt(r.Context(), row.OrgID, row.FlowID, row.InstanceID); err != nil {
+		writeRPCError(w, agentv1.CodeInternal, err.Error())
+		return
+	}
+	render.OK(w, out)
+}
+
+func (a *App) rpcListProducts(w http.ResponseWriter, r *http.Request, in *agentv1.ListProductsRequest) {
+	if in.OrgID <= 0 || in.FlowID <= 0 {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "org_id and flow_id required")
+		return
+	}
+	limit := in.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	// com pg_trgm, a busca do agente tolera erros de digitação (similarity)
+	match, order := `title ILIKE '%' || $3 || '%' OR category ILIKE '%' || $3 || '%'`, `title`
+	if trgmEnabled {
+		match += ` OR lower(title) % lower($3)`
+		order = `similarity(lower(title), lower($3)) DESC, title`
+	}
+	rows, err := a.DB.Query(r.Context(),
+		`SELECT id, org_id, flow_id, title, COALESCE(slug,''), status, COALESCE(category,''),
+		        COALESCE(image_base64,''), price_cents, stock
+		   FROM products
+		  WHERE org_id=$1 AND flow_id=$2 AND status='active' AND `+productAvailableSQL()+`
+		    AND ($3 = '' OR `+match+`)
+		  ORDER BY `+order+`
+		  LIMIT $4`,
+		in.OrgID, in.FlowID, strings.TrimSpace(in.Query), limit)
+	if err != nil {
+		writeRPCError(w, agentv1.CodeInternal, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	out := agentv1.ListProductsResponse{Products: []agentv1.Product{}}
+	for rows.Next() {
+		var p agentv1.Product
+		if err := rows.Scan(&p.ID, &p.OrgID, &p.FlowID, &p.Title, &p.Slug, &p.Status, &p.Category, &p.ImageURL, &p.PriceCents, &p.Stock); err != nil {
+			writeRPCError(w, agentv1.CodeInternal, err.Error())
+			return
+		}
+		out.Products = append(out.Products, p)
+	}
+	render.OK(w, out)
+}
+
+func (a *App) rpcGetProduct(w http.ResponseWriter, r *http.Request, in *agentv1.GetProductRequest) {
+	if in.OrgID <= 0 || in.FlowID <= 0 || in.ID <= 0 {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "org_id, flow_id and id required")
+		return
+	}
+	var p agentv1.Product
+	err := a.DB.QueryRow(r.Context(),
+		`SELECT id, org_id, flow_id, title, COALESCE(slug,''), status, COALESCE(category,''),
+		        COALESCE(image_base64,''), price_cents, stock
+		   FROM products
+		  WHERE id=$1 AND org_id=$2 AND flow_id=$3 AND deleted_at IS NULL`,
+		in.ID, in.OrgID, in.FlowID).
+		Scan(&p.ID, &p.OrgID, &p.FlowID, &p.Title, &p.Slug, &p.Status, &p.Category, &p.ImageURL, &p.PriceCents, &p.Stock)
+	if err != nil {
+		writeRPCError(w, agentv1.CodeNotFound, "product not found")
+		return
+	}
+	render.OK(w, p)
+}
+
+func (a *App) rpcSendText(w http.ResponseWriter, r *http.Request, in *agentv1.SendTextRequest) {
+	if strings.TrimSpace(in.InstanceID) == "" || strings.TrimSpace(in.To) == "" || strings.TrimSpace(in.Text) == "" {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "instance_id, to and text required")
+		return
+	}
+	row, err := a.fetchWAInstance(r.Context(), strings.TrimSpace(in.InstanceID))
+	if err != nil {
+		writeRPCError(w, agentv1.CodeNotFound, "instance not found")
+		return
+	}
+	out, _, err := a.sendWAText(r.Context(), row, row.Token, in.To, in.Text)
+	if err != nil {
+		writeRPCError(w, agentv1.CodeUnavailable, err.Error())
+		return
+	}
+	raw, _ := json.Marshal(out)
+	mock, _ := out["mock"].(bool)
+	render.OK(w, agentv1.SendTextResponse{OK: true, Mock: mock, ProviderResponse: string(raw)})
+}
+
+// rpcReportIntent aplica a regra de etapa do evento ao lead do contato
+// (lead_stage_rules.go).
+func (a *App) rpcReportIntent(w http.ResponseWriter, r *http.Request, in *agentv1.ReportIntentRequest) {
+	contact := onlyDigits(in.Contact)
+	if strings.TrimSpace(in.InstanceID) == "" || (contact == "" && in.LeadID <= 0) {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "instance_id and contact (or lead_id) required")
+		return
+	}
+	if in.Intent != agentv1.IntentBuying {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "unsupported intent "+strconv.Quote(in.Intent))
+		return
+	}
+	ctx := r.Context()
+	row, err := a.fetchWAInstance(ctx, strings.TrimSpace(in.InstanceID))
+	if err != nil {
+		writeRPCError(w, agentv1.CodeNotFound, "instance not found")
+		return
+	}
+	leadID := in.LeadID
+	if leadID <= 0 {
+		if leadID, err = a.leadIDByPhone(ctx, row.OrgID, row.FlowID, contact, true); err != nil {
+			writeRPCError(w, agentv1.CodeInternal, err.Error())
+			return
+		}
+	}
+	confidence := float64(in.Confidence)
+	if confidence <= 0 {
+		confidence = 1
+	}
+	moved, err := a.applyStageRule(ctx, row.OrgID, row.FlowID, leadID, stageRuleBuyingIntent, confidence)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeRPCError(w, agentv1.CodeNotFound, "lead not found")
+		return
+	}
+	if err != nil {
+		writeRPCError(w, agentv1.CodeInternal, err.Error())
+		return
+	}
+	out := agentv1.ReportIntentResponse{LeadID: leadID, Moved: moved}
+	_ = a.DB.QueryRow(ctx, `SELECT COALESCE(stage,'') FROM leads WHERE id=$1`, leadID).Scan(&out.Stage)
+	render.OK(w, out)
+}
+
+// decodeRPC lê o corpo JSON da chamada; em caso de erro já responde ao cliente.
+func decodeRPC(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeRPCError(w, agentv1.CodeInvalidArgument, "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeRPCError responde no formato de erro do Twirp ({"code","msg"}).
+func writeRPCError(w http.ResponseWriter, code, msg string) {
+	render.JSON(w, agentv1.HTTPStatus(code), agentv1.Error{Code: code, Msg: msg})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Allowlist de IP por grupo de rotas
+// ================================================================
+//
+// Escopos e variáveis de ambiente (IPs ou CIDRs separados por vírgula):
+//   webhooks -> WEBHOOK_IP_ALLOWLIST  (faixas de saída do provedor)
+//   admin    -> ADMIN_IP_ALLOWLIST    (IPs do escritório/VPN)
+//
+// Lista vazia = sem restrição. Além da lista global, cada org pode cadastrar
+// faixas próprias em org_ip_allowlists (escopo "webhooks"), aplicadas aos
+// webhooks das instâncias daquela org. Rejeições são registradas em
+// ip_rejections para auditoria.
+//
+// O IP avaliado é o par da conexão TCP, guardado por socketPeer antes do
+// middleware.RealIP (que reescreve r.RemoteAddr a partir dos headers e serve
+// só para o log). X-Forwarded-For / X-Real-IP só valem quando esse par está
+// em TRUSTED_PROXIES (IPs ou CIDRs do load balancer/ingress, mesma sintaxe):
+// do X-Forwarded-For vale o endereço mais à direita que não é proxy
+// confiável. Sem TRUSTED_PROXIES os headers são ignorados; atrás de proxy,
+// configure-o ou as listas verão o IP do proxy.
+// Tabelas em migrations/0054_ip_allowlists.sql.
+
+// parseIPAllowlist converte "1.2.3.4, 10.0.0.0/8" em redes; entradas
+// inválidas são ignoradas com log.
+func parseIPAllowlist(v string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, p := range strings.Split(v, ",") {
+		s := strings.TrimSpace(p)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Printf("ip allowlist: ignoring invalid entry %q", p)
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func ipAllowed(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type socketPeerKey struct{}
+
+var trustedProxies = sync.OnceValue(func() []*net.IPNet {
+	return parseIPAllowlist(os.Getenv("TRUSTED_PROXIES"))
+})
+
+// socketPeer guarda o r.RemoteAddr original; vai antes do middleware.RealIP
+// (main.go).
+func socketPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), socketPeerKey{}, r.RemoteAddr)))
+	})
+}
+
+func parseHostIP(addr string) net.IP {
+	host := strings.TrimSpace(addr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return net.ParseIP(host)
+}
+
+// clientIP devolve o IP do cliente: o par da conexão ou, vindo de proxy
+// confiável, o informado por ele.
+func clientIP(r *http.Request) net.IP {
+	addr, ok := r.Context().Value(socketPeerKey{}).(string)
+	if !ok {
+		addr = r.RemoteAddr
+	}
+	peer := parseHostIP(addr)
+	proxies := trustedProxies()
+	if peer == nil || !ipAllowed(peer, proxies) {
+		return peer
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseHostIP(hops[i])
+			if ip == nil {
+				break
+			}
+			if !ipAllowed(ip, proxies) {
+				return ip
+			}
+		}
+	}
+	if ip := parseHostIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// ipAllowlist restringe as rotas do grupo à lista global do escopo.
+func (a *App) ipAllowlist(scope, envVar string) func(http.Handler) http.Handler {
+	nets := parseIPAllowlist(os.Getenv(envVar))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(nets) > 0 && !ipAllowed(clientIP(r), nets) {
+				a.rejectIP(w, r, scope, 0)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// orgWebhookAllowlist aplica as faixas cadastradas pela org dona da
+// instância ({instance} na rota). Sem faixas cadastradas, libera.
+func (a *App) orgWebhookAllowlist(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instance := strings.TrimSpace(chi.URLParam(r, "instance"))
+		if instance == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		row, err := a.fetchWAInstance(r.Context(), instance)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		nets, err := a.orgAllowlist(r.Context(), row.OrgID, "webhooks")
+		if err != nil {
+			log.Printf("org allowlist org=%d: %v", row.OrgID, err)
+		}
+		if len(nets) > 0 && !ipAllowed(clientIP(r), nets) {
+			a.rejectIP(w, r, "webhooks", row.OrgID)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *App) orgAllowlist(ctx context.Context, orgID int64, scope string) ([]*net.IPNet, error) {
+	rows, err := a.DB.Query(ctx, `SELECT cidr FROM org_ip_allowlists WHERE org_id=$1 AND scope=$2`, orgID, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		entries = append(entries, c)
+	}
+	return parseIPAllowlist(strings.Join(entries, ",")), rows.Err()
+}
+
+// rejectIP responde 403 e registra a tentativa (best-effort).
+func (a *App) rejectIP(w http.ResponseWriter, r *http.Request, scope string, orgID int64) {
+	ip := clientIP(r).String()
+	log.Printf("ip allowlist: rejected scope=%s ip=%s path=%s", scope, ip, r.URL.Path)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, _ = a.DB.Exec(ctx,
+		`INSERT INTO ip_rejections (scope, org_id, ip, method, path) VALUES ($1, NULLIF($2,0), $3, $4, $5)`,
+		scope, orgID, ip, r.Method, r.URL.Path)
+	render.Error(w, http.StatusForbidden, "forbidden")
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Fila de jobs em segundo plano (Postgres)
+// ================================================================
+//
+// Trabalho que não precisa (ou não deve) rodar dentro da requisição HTTP:
+// encaminhamento de webhooks ao Agente, envios de campanha, miniaturas de
+// imagens de produto. enqueueJob grava o job em public.jobs; o worker
+// (JOBS_WORKER_INTERVAL, padrão 2s; 0 desliga nesta réplica) reserva os jobs
+// vencidos com FOR UPDATE SKIP LOCKED e roda até JOBS_CONCURRENCY (padrão 4)
+// ao mesmo tempo.
+//
+// Cada tipo registra o handler e a política com registerJob (nas funções
+// mount*, antes de startJobWorker). Erro do handler reagenda com backoff;
+// ao esgotar as tentativas, ou com permanentJobError, o job vira 'dead'.
+// Um job 'running' cuja reserva expirou (réplica morreu no meio) volta a ser
+// executado.
+//
+// GET  /api/admin/jobs              ?status=pending|running|done|dead&kind=&org_id=&limit=
+// POST /api/admin/jobs/{id}/retry   recoloca um job 'dead' na fila
+
+const (
+	jobStatusPending = "pending"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusDead    = "dead"
+
+	// jobLease é quanto tempo um job reservado fica com o worker antes de
+	// poder ser reexecutado; os timeouts das políticas ficam abaixo disso.
+	jobLease = 10 * time.Minute
+)
+
+type jobHandler func(ctx context.Context, j job) error
+
+// jobPolicy define tentativas, tempo limite e backoff de um tipo de job.
+type jobPolicy struct {
+	MaxAttempts int
+	Timeout     time.Duration
+	// Backoff recebe o número de tentativas já feitas; nil usa jobBackoff.
+	Backoff func(attempts int) time.Duration
+}
+
+type job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	OrgID       *int64          `json:"org_id,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// decode lê o payload do job.
+func (j job) decode(v any) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return permanentJobError(fmt.Errorf("invalid payload: %w", err))
+	}
+	return nil
+}
+
+type jobKind struct {
+	policy  jobPolicy
+	handler jobHandler
+}
+
+var jobKinds = map[string]jobKind{}
+
+// registerJob registra o handler de um tipo de job.
+func registerJob(kind string, p jobPolicy, h jobHandler) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 5
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = time.Minute
+	}
+	p.Timeout = min(p.Timeout, jobLease-time.Minute)
+	if p.Backoff == nil {
+		p.Backoff = jobBackoff
+	}
+	jobKinds[kind] = jobKind{policy: p, handler: h}
+}
+
+// jobBackoff: 10s × 2^(tentativas-1), limitado a 1h.
+func jobBackoff(attempts int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempts && d < time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, time.Hour)
+}
+
+type permanentErr struct{ err error }
+
+func (e permanentErr) Error() string { return e.err.Error() }
+func (e permanentErr) Unwrap() error { return e.err }
+
+// permanentJobError marca um erro que não adianta repetir (payload inválido,
+// destino recusou com 4xx...): o job vai direto para 'dead'.
+func permanentJobError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentErr{err}
+}
+
+// jobWake acorda o worker quando há job novo.
+var jobWake = make(chan struct{}, 1)
+
+// enqueueJob grava um job para rodar em runAt (zero = agora). orgID 0 indica
+// job sem org.
+func (a *App) enqueueJob(ctx context.Context, orgID int64, kind string, payload any, runAt time.Time) (int64, error) {
+	k, ok := jobKinds[kind]
+	if !ok {
+		return 0, fmt.Errorf("unknown job kind %q", kind)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("job %s payload: %w", kind, err)
+	}
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+	var org *int64
+	if orgID > 0 {
+		org = &orgID
+	}
+	var id int64
+	err = a.DB.QueryRow(ctx, `
+INSERT INTO public.jobs (kind, org_id, payload, max_attempts, run_at)
+VALUES ($1, $2, $3::jsonb, $4, $5) RETURNING id`, kind, org, string(body), k.policy.MaxAttempts, runAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("enqueue %s: %w", kind, err)
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// ---------------- worker ----------------
+
+func jobWorkerInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("JOBS_WORKER_INTERVAL", "2s"))
+	if err != nil || d < 0 {
+		return 2 * time.Second
+	}
+	return d
+}
+
+func jobConcurrency() int {
+	n, err := strconv.Atoi(getenv("JOBS_CONCURRENCY", "4"))
+	if err != nil || n < 1 {
+		return 4
+	}
+	return n
+}
+
+// jobRetention: jobs 'done' mais antigos que isso são apagados (JOBS_RETENTION,
+// padrão 7 dias). Os 'dead' ficam para inspeção.
+func jobRetention() time.Duration {
+	d, err := time.ParseDuration(getenv("JOBS_RETENTION", "168h"))
+	if err != nil || d <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// startJobWorker sobe o worker. Chamado em main depois de todos os mount*,
+// quando os tipos de job já estão registrados.
+func (a *App) startJobWorker() {
+	if every := jobWorkerInterval(); every > 0 {
+		go a.jobWorkerLoop(every, jobConcurrency())
+	}
+}
+
+func (a *App) jobWorkerLoop(every time.Duration, concurrency int) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	var lastCleanup time.Time
+	for {
+		select {
+		case <-t.C:
+		case <-jobWake:
+		}
+		for a.runJobBatch(concurrency) {
+			// lote cheio: pode haver mais na fila
+		}
+		if time.Since(lastCleanup) > time.Hour {
+			a.cleanupJobs()
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+// runJobBatch reserva e executa até concurrency jobs vencidos. Devolve true
+// se o lote veio cheio.
+func (a *App) runJobBatch(concurrency int) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.jobs
+   SET status='running', attempts=attempts+1, locked_until=$2, updated_at=NOW()
+ WHERE id IN (SELECT id FROM public.jobs
+               WHERE (status='pending' AND run_at <= NOW())
+                  OR (status='running' AND locked_until < NOW())
+               ORDER BY run_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+RETURNING id, kind, org_id, payload, status, attempts, max_attempts, run_at, created_at`,
+		concurrency, time.Now().Add(jobLease))
+	if err != nil {
+		cancel()
+		log.Printf("jobs: %v", err)
+		return false
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.ID, &j.Kind, &j.OrgID, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts,
+			&j.RunAt, &j.CreatedAt); err != nil {
+			log.Printf("jobs: %v", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	cancel()
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			a.finishJob(j, a.runJob(j))
+		}(j)
+	}
+	wg.Wait()
+	return len(jobs) == concurrency
+}
+
+func (a *App) runJob(j job) (err error) {
+	k, ok := jobKinds[j.Kind]
+	if !ok {
+		return permanentJobError(fmt.Errorf("unknown job kind %q", j.Kind))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.policy.Timeout)
+	defer cancel()
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return k.handler(ctx, j)
+}
+
+// finishJob grava o resultado da execução: concluído, reagendado com backoff
+// ou, sem mais tentativas (ou erro permanente), no dead-letter.
+func (a *App) finishJob(j job, runErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var perm permanentErr
+	var err error
+	switch {
+	case runErr == nil:
+		_, err = a.DB.Exec(ctx, `
+UPDATE public.jobs SET status='done', last_error=NULL, locked_until=NULL, finished_at=NOW(), updated_at=NOW()
+ WHERE id=$1`, j.ID)
+	case errors.As(runErr, &perm) || j.Attempts >= j.MaxAttempts:
+		log.Printf("job %d %s: dead after %d attempts: %v", j.ID, j.Kind, j.Attempts, runErr)
+		_, err = a.DB.Exec(ctx, `
+UPDATE public.jobs SET status='dead', last_error=$2, locked_until=NULL, finished_at=NOW(), updated_at=NOW()
+ WHERE id=$1`, j.ID, limitRunes(runErr.Error(), 500))
+	default:
+		backoff := jobBackoff
+		if k, ok := jobKinds[j.Kind]; ok {
+			backoff = k.policy.Backoff
+		}
+		_, err = a.DB.Exec(ctx, `
+UPDATE public.jobs SET status='pending', last_error=$2, locked_until=NULL, run_at=$3, updated_at=NOW()
+ WHERE id=$1`, j.ID, limitRunes(runErr.Error(), 500), time.Now().Add(backoff(j.Attempts)))
+	}
+	if err != nil {
+		log.Printf("job %d %s: %v", j.ID, j.Kind, err)
+	}
+}
+
+func (a *App) cleanupJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := a.DB.Exec(ctx, `DELETE FROM public.jobs WHERE status='done' AND finished_at < $1`,
+		time.Now().Add(-jobRetention())); err != nil {
+		log.Printf("jobs cleanup: %v", err)
+	}
+}
+
+// ---------------- admin ----------------
+
+// GET /api/admin/jobs?status=&kind=&org_id=&limit=
+func (a *App) adminListJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := strings.TrimSpace(q.Get("status"))
+	switch status {
+	case "", jobStatusPending, jobStatusRunning, jobStatusDone, jobStatusDead:
+	default:
+		render.Error(w, http.StatusBadRequest, "invalid status (use pending, running, done or dead)")
+		return
+	}
+	orgID := int64(mustAtoi(q.Get("org_id")))
+	limit := mustAtoi(q.Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, kind, org_id, payload, status, attempts, max_attempts, run_at, COALESCE(last_error,''), created_at, finished_at
+  FROM public.jobs
+ WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2) AND ($3 = 0 OR org_id = $3)
+ ORDER BY id DESC LIMIT $4`, status, strings.TrimSpace(q.Get("kind")), orgID, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []job{}
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.ID, &j.Kind, &j.OrgID, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts,
+			&j.RunAt, &j.LastError, &j.CreatedAt, &j.FinishedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, j)
+	}
+	if err := rows.Err(); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// contagem por tipo e status, para o painel
+	crows, err := a.DB.Query(r.Context(), `SELECT kind, status, COUNT(*) FROM public.jobs GROUP BY kind, status ORDER BY kind, status`)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer crows.Close()
+	counts := map[string]map[string]int64{}
+	for crows.Next() {
+		var kind, st string
+		var n int64
+		if err := crows.Scan(&kind, &st, &n); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if counts[kind] == nil {
+			counts[kind] = map[string]int64{}
+		}
+		counts[kind][st] = n
+	}
+	render.OK(w, map[string]any{"items": out, "counts": counts})
+}
+
+// POST /api/admin/jobs/{id}/retry
+func (a *App) adminRetryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid job id")
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `
+UPDATE public.jobs SET status='pending', attempts=0, run_at=NOW(), finished_at=NULL, updated_at=NOW()
+ WHERE id=$1 AND status='dead'`, id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "job not found or not dead")
+		return
+	}
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Leads duplicados: telefone em E.164 e mesclagem
+// ================================================================
+//
+// Todo telefone de lead é gravado e procurado em E.164 (+5511987654321):
+// sem DDI recebe PHONE_DEFAULT_COUNTRY (padrão 55), JIDs do WhatsApp perdem o
+// sufixo e celular brasileiro sem o nono dígito ganha o 9. Assim o lead que
+// chega pelo WhatsApp, pela API e pelo agente é o mesmo: POST /api/leads,
+// create_lead e a mensagem recebida devolvem o lead existente do org/flow em
+// vez de criar outro.
+//
+// GET  /api/leads/duplicates               grupos com o mesmo telefone
+// POST /api/leads/{id}/merge/{other_id}    mescla other_id em id
+//
+// A mesclagem move conversas, mensagens, pedidos, agenda, tarefas,
+// assinaturas, pontos e indicações para {id}, completa nome/telefone/e-mail
+// vazios, mantém a etapa mais avançada e a data de criação mais antiga, e
+// apaga {other_id}. Fica registrada em lead_merges.
+//
+// Leads gravados antes da normalização: subcomando "normalize-phones
+// [org_id ...]" recalcula telefone e hash.
+
+func phoneDefaultCountry() string {
+	return nonEmpty(onlyDigits(getenv("PHONE_DEFAULT_COUNTRY", "55")), "55")
+}
+
+// normalizePhone devolve o telefone em E.164, ou "" se não parecer um número.
+func normalizePhone(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if i := strings.IndexAny(raw, "@:"); i >= 0 {
+		raw = raw[:i] // JID do WhatsApp (5511...@s.whatsapp.net, 5511...:12@...)
+	}
+	intl := strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "00")
+	d := onlyDigits(raw)
+	if intl {
+		d = strings.TrimPrefix(d, "00")
+	} else {
+		// número nacional (com ou sem o 0 de longa distância) não tem DDI
+		d = strings.TrimLeft(d, "0")
+		if len(d) <= 11 {
+			d = phoneDefaultCountry() + d
+		}
+	}
+	// celular brasileiro sem o nono dígito: 55 + DDD + 8 dígitos começando em 6-9
+	if len(d) == 12 && strings.HasPrefix(d, "55") && d[4] >= '6' {
+		d = d[:4] + "9" + d[4:]
+	}
+	if len(d) < 8 || len(d) > 15 {
+		return ""
+	}
+	return "+" + d
+}
+
+// phoneHash é o valor de busca de leads.phone_hash para um telefone em
+// qualquer formato.
+func phoneHash(orgID int64, phone string) string {
+	if n := normalizePhone(phone); n != "" {
+		return piiHash(orgID, n)
+	}
+	return piiHash(orgID, phone)
+}
+
+// leadPhone é o telefone como gravado no lead (E.164 quando possível).
+func leadPhone(phone string) string {
+	return nonEmpty(normalizePhone(phone), strings.TrimSpace(phone))
+}
+
+type duplicateGroup struct {
+	LeadIDs []int64 `json:"lead_ids"`
+	Phone   string  `json:"phone"`
+}
+
+// GET /api/leads/duplicates
+func (a *App) listDuplicateLeads(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT array_agg(id ORDER BY id), (array_agg(COALESCE(phone,'') ORDER BY id))[1]
+  FROM leads
+ WHERE org_id=$1 AND flow_id=$2 AND phone_hash IS NOT NULL
+ GROUP BY phone_hash HAVING COUNT(*) > 1
+ ORDER BY MIN(id) LIMIT 200`, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []duplicateGroup{}
+	for rows.Next() {
+		var g duplicateGroup
+		if err := rows.Scan(&g.LeadIDs, &g.Phone); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		g.Phone = revealPII(orgID, g.Phone)
+		out = append(out, g)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// leadStageRank ordena as etapas do funil; "perdido" e etapas livres ficam
+// abaixo de todas.
+func leadStageRank(stage string) int {
+	switch normalizeLeadStage(stage) {
+	case "novo":
+		return 1
+	case "contato":
+		return 2
+	case "qualificado":
+		return 3
+	case "proposta":
+		return 4
+	case "negociacao":
+		return 5
+	case "cliente":
+		return 6
+	}
+	return 0
+}
+
+// referências a leads movidas na mesclagem ($1 = fica, $2 = sai)
+var leadMergeMoves = []struct{ name, sql string }{
+	{"conversations", `UPDATE conversations SET lead_id=$1 WHERE lead_id=$2`},
+	{"messages", `UPDATE wa_messages SET lead_id=$1 WHERE lead_id=$2`},
+	{"orders", `UPDATE orders SET lead_id=$1 WHERE lead_id=$2`},
+	{"referred_orders", `UPDATE orders SET referrer_lead_id=$1 WHERE referrer_lead_id=$2`},
+	{"stage_changes", `UPDATE lead_stage_changes SET lead_id=$1 WHERE lead_id=$2`},
+	{"automation_runs", `UPDATE stage_automation_runs SET lead_id=$1 WHERE lead_id=$2`},
+	{"tasks", `UPDATE lead_tasks SET lead_id=$1 WHERE lead_id=$2`},
+	{"sequence_steps", `UPDATE lead_sequence_steps SET lead_id=$1 WHERE lead_id=$2`},
+	{"appointments", `UPDATE appointments SET lead_id=$1 WHERE lead_id=$2`},
+	{"subscriptions", `UPDATE subscriptions SET lead_id=$1 WHERE lead_id=$2`},
+	{"loyalty_entries", `UPDATE loyalty_ledger SET lead_id=$1 WHERE lead_id=$2`},
+	{"referral_rewards", `UPDATE referral_rewards SET referrer_lead_id=$1 WHERE referrer_lead_id=$2`},
+	{"referred_rewards", `UPDATE referral_rewards SET referred_lead_id=$1 WHERE referred_lead_id=$2`},
+	{"referred_leads", `UPDATE leads SET referred_by_lead_id=$1 WHERE referred_by_lead_id=$2 AND id <> $1`},
+	{"referral_code", `
+UPDATE referral_codes SET lead_id=$1 WHERE lead_id=$2
+   AND NOT EXISTS (SELECT 1 FROM referral_codes WHERE lead_id=$1)`},
+	{"flash_sales", `
+UPDATE flash_sale_leads f SET lead_id=$1 WHERE lead_id=$2
+   AND NOT EXISTS (SELECT 1 FROM flash_sale_leads k WHERE k.flash_sale_id = f.flash_sale_id AND k.lead_id=$1)`},
+}
+
+// POST /api/leads/{id}/merge/{other_id}
+func (a *App) mergeLeads(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	keepID, ok := leadIDParam(r)
+	otherID, err := strconv.ParseInt(chi.URLParam(r, "other_id"), 10, 64)
+	if !ok || err != nil || otherID <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if keepID == otherID {
+		render.Error(w, http.StatusBadRequest, "cannot merge a lead into itself")
+		return
+	}
+	var by *int64
+	if c, ok := claimsFromContext(r.Context()); ok && c.UserID > 0 {
+		by = &c.UserID
+	}
+	lead, moved, err := a.mergeLeadInto(r.Context(), orgID, flowID, keepID, otherID, by)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"lead": lead, "merged_lead_id": otherID, "moved": moved})
+}
+
+// mergeLeadInto mescla otherID em keepID numa transação, com os dois leads
+// travados. pgx.ErrNoRows se algum não for do org/flow.
+func (a *App) mergeLeadInto(ctx context.Context, orgID, flowID, keepID, otherID int64, by *int64) (Lead, map[string]int64, error) {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return Lead{}, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked int
+	if err := tx.QueryRow(ctx, `
+SELECT COUNT(*) FROM (SELECT id FROM leads WHERE id IN ($1, $2) AND org_id=$3 AND flow_id=$4 ORDER BY id FOR UPDATE) l`,
+		keepID, otherID, orgID, flowID).Scan(&locked); err != nil {
+		return Lead{}, nil, err
+	}
+	if locked != 2 {
+		return Lead{}, nil, pgx.ErrNoRows
+	}
+	keep, err := a.loadLead(ctx, orgID, flowID, keepID)
+	if err != nil {
+		return Lead{}, nil, err
+	}
+	other, err := a.loadLead(ctx, orgID, flowID, otherID)
+	if err != nil {
+		return Lead{}, nil, err
+	}
+
+	moved := map[string]int64{}
+	for _, m := range leadMergeMoves {
+		tag, err := tx.Exec(ctx, m.sql, keepID, otherID)
+		if err != nil {
+			return Lead{}, nil, fmt.Errorf("%s: %w", m.name, err)
+		}
+		if n := tag.RowsAffected(); n > 0 {
+			moved[m.name] = n
+		}
+	}
+
+	// campos vazios vêm do outro; a etapa fica a mais avançada
+	from := normalizeLeadStage(keep.Stage)
+	keep.Name = nonEmpty(keep.Name, other.Name)
+	keep.Phone = leadPhone(nonEmpty(keep.Phone, other.Phone))
+	keep.Email = nonEmpty(keep.Email, other.Email)
+	if leadStageRank(other.Stage) > leadStageRank(keep.Stage) {
+		keep.Stage = normalizeLeadStage(other.Stage)
+	}
+	version := piiKeyVersion(ctx, a.DB, orgID)
+	var enc [3]string
+	for i, v := range []string{keep.Name, keep.Phone, keep.Email} {
+		if enc[i], err = encryptPII(orgID, version, v); err != nil {
+			return Lead{}, nil, err
+		}
+	}
+	err = tx.QueryRow(ctx, `
+UPDATE leads k SET name=$3, phone=$4, email=$5, phone_hash=NULLIF($6,''), email_hash=NULLIF($7,''),
+       stage=$8, stage_changed_at = CASE WHEN k.stage IS DISTINCT FROM $8 THEN NOW() ELSE k.stage_changed_at END,
+       created_at = LEAST(k.created_at, o.created_at),
+       referred_by_lead_id = COALESCE(NULLIF(k.referred_by_lead_id, $2), NULLIF(o.referred_by_lead_id, $1)),
+       source = COALESCE(k.source, o.source),
+       tracking_opt_out_at = COALESCE(k.tracking_opt_out_at, o.tracking_opt_out_at),
+       updated_at = NOW()
+  FROM leads o
+ WHERE k.id=$1 AND o.id=$2
+RETURNING k.created_at`,
+		keepID, otherID, enc[0], enc[1], enc[2], phoneHash(orgID, keep.Phone), piiHash(orgID, keep.Email), keep.Stage).
+		Scan(&keep.CreatedAt)
+	if err != nil {
+		return Lead{}, nil, err
+	}
+	if keep.Stage != from {
+		if _, err := tx.Exec(ctx, `
+INSERT INTO lead_stage_changes (org_id, flow_id, lead_id, from_stage, to_stage, changed_by)
+VALUES ($1, $2, $3, NULLIF($4,''), $5, $6)`, orgID, flowID, keepID, from, keep.Stage, by); err != nil {
+			return Lead{}, nil, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO lead_merges (org_id, flow_id, kept_lead_id, merged_lead_id, merged_stage, merged_created_at, moved, merged_by)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), $6, $7, $8)`,
+		orgID, flowID, keepID, otherID, other.Stage, other.CreatedAt, moved, by); err != nil {
+		return Lead{}, nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM leads WHERE id=$1`, otherID); err != nil {
+		return Lead{}, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Lead{}, nil, err
+	}
+	return keep, moved, nil
+}
+
+// runNormalizePhones implementa o subcomando "normalize-phones [org_id ...]":
+// regrava telefone (E.164) e phone_hash dos leads de cada org (todas, se
+// nenhuma for informada). Não mescla nada; os grupos aparecem depois em
+// GET /api/leads/duplicates.
+func runNormalizePhones(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	orgs, err := orgIDsArg(ctx, db, args)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range orgs {
+		n, err := normalizeOrgPhones(ctx, db, orgID)
+		if err != nil {
+			return fmt.Errorf("org %d: %w", orgID, err)
+		}
+		log.Printf("normalize-phones: org=%d leads=%d", orgID, n)
+	}
+	return nil
+}
+
+func normalizeOrgPhones(ctx context.Context, db *pgxpool.Pool, orgID int64) (int, error) {
+	rows, err := db.Query(ctx, `SELECT id, phone FROM leads WHERE org_id=$1 AND COALESCE(phone,'') <> ''`, orgID)
+	if err != nil {
+		return 0, err
+	}
+	type leadRow struct {
+		ID    int64
+		Phone string
+	}
+	var all []leadRow
+	for rows.Next() {
+		var l leadRow
+		if err := rows.Scan(&l.ID, &l.Phone); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		all = append(all, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	version := piiKeyVersion(ctx, db, orgID)
+	n := 0
+	for _, l := range all {
+		plain, err := decryptPII(orgID, l.Phone)
+		if err != nil {
+			return n, fmt.Errorf("lead %d: %w", l.ID, err)
+		}
+		phone := leadPhone(plain)
+		if phone == plain {
+			// só o hash pode estar no formato antigo
+			if _, err := db.Exec(ctx, `UPDATE leads SET phone_hash=NULLIF($2,'') WHERE id=$1`, l.ID, phoneHash(orgID, phone)); err != nil {
+				return n, err
+			}
+			continue
+		}
+		enc, err := encryptPII(orgID, version, phone)
+		if err != nil {
+			return n, err
+		}
+		if _, err := db.Exec(ctx, `UPDATE leads SET phone=$2, phone_hash=NULLIF($3,'') WHERE id=$1`,
+			l.ID, enc, phoneHash(orgID, phone)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Avanço automático de etapa
+// ================================================================
+//
+// O lead nasce em "novo" na primeira mensagem de um número desconhecido
+// (ingestão do WhatsApp, wa_inbound.go). Daí em diante, eventos da conversa
+// movem o lead para a etapa configurada na org (migrations/0047):
+//
+//   buying_intent   o Agente informou intenção de compra (RPC ReportIntent,
+//                   internal_agent_api.go); ignorado abaixo de min_confidence
+//   order_created   pedido criado para o lead (order.created)
+//   order_paid      pedido pago (order.paid)
+//
+// Sem regra gravada vale o padrão: buying_intent → qualificado,
+// order_created → proposta, order_paid → cliente. Uma regra inativa desliga
+// o evento. A regra só avança no funil (podendo pular etapas e reabrir lead
+// perdido); nunca volta e não mexe em etapa legada fora da lista. A mudança
+// entra no histórico com o evento (lead_stage_changes.rule_event) e dispara
+// as automações da etapa como uma mudança manual.
+//
+// GET    /api/automations/stage-rules            regras efetivas (com os padrões)
+// PUT    /api/automations/stage-rules/{event}    {"to_stage","active","min_confidence"}
+// DELETE /api/automations/stage-rules/{event}    volta ao padrão
+
+const (
+	stageRuleBuyingIntent = "buying_intent"
+	stageRuleOrderCreated = "order_created"
+	stageRuleOrderPaid    = "order_paid"
+)
+
+var stageRuleEvents = []string{stageRuleBuyingIntent, stageRuleOrderCreated, stageRuleOrderPaid}
+
+var defaultStageRules = map[string]string{
+	stageRuleBuyingIntent: "qualificado",
+	stageRuleOrderCreated: "proposta",
+	stageRuleOrderPaid:    "cliente",
+}
+
+// stageRuleAllows é o allow de moveLeadStage para as regras: só avança
+// (leadStageRank, lead_dedup.go, põe perdido abaixo de todas).
+func stageRuleAllows(from, to string) error {
+	if _, known := leadStageTransitions[from]; !known || leadStageRank(from) >= leadStageRank(to) {
+		return fmt.Errorf("%w: %s -> %s (rules only advance)", errStageTransition, from, to)
+	}
+	return nil
+}
+
+type leadStageRule struct {
+	ID            int64      `json:"id,omitempty"`
+	Event         string     `json:"event"`
+	ToStage       string     `json:"to_stage"`
+	MinConfidence float64    `json:"min_confidence"`
+	Active        bool       `json:"active"`
+	Default       bool       `json:"default"` // padrão do código, sem regra gravada
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+func validStageRuleEvent(event string) bool {
+	_, ok := defaultStageRules[event]
+	return ok
+}
+
+func (a *App) mountLeadStageRules(r chi.Router) {
+	onEvent(eventOrderCreated, a.onOrderStageRule(stageRuleOrderCreated))
+	onEvent(eventOrderPaid, a.onOrderStageRule(stageRuleOrderPaid))
+
+	admin := a.requireRole(roleAdmin)
+	r.Route("/automations/stage-rules", func(r chi.Router) {
+		r.Get("/", a.listStageRules)
+		r.With(admin).Put("/{event}", a.putStageRule)
+		r.With(admin).Delete("/{event}", a.deleteStageRule)
+	})
+}
+
+// stageRuleFor devolve a regra gravada do evento ou o padrão.
+func (a *App) stageRuleFor(ctx context.Context, orgID int64, event string) (leadStageRule, error) {
+	var rule leadStageRule
+	var updated time.Time
+	err := a.DB.QueryRow(ctx, `
+SELECT id, event, to_stage, min_confidence, active, updated_at
+  FROM public.lead_stage_rules WHERE org_id=$1 AND event=$2`, orgID, event).
+		Scan(&rule.ID, &rule.Event, &rule.ToStage, &rule.MinConfidence, &rule.Active, &updated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		to, ok := defaultStageRules[event]
+		return leadStageRule{Event: event, ToStage: to, Active: ok, Default: true}, nil
+	}
+	if err != nil {
+		return rule, err
+	}
+	rule.UpdatedAt = &updated
+	return rule, nil
+}
+
+// applyStageRule move o lead pela regra do evento. confidence vai de 0 a 1
+// (eventos de pedido usam 1). true = o lead mudou de etapa.
+func (a *App) applyStageRule(ctx context.Context, orgID, flowID, leadID int64, event string, confidence float64) (bool, error) {
+	rule, err := a.stageRuleFor(ctx, orgID, event)
+	if err != nil {
+		return false, err
+	}
+	if !rule.Active || rule.ToStage == "" || confidence < rule.MinConfidence {
+		return false, nil
+	}
+	moved, err := a.moveLeadStage(ctx, orgID, flowID, leadID, rule.ToStage, nil, event, stageRuleAllows)
+	if errors.Is(err, errStageTransition) {
+		return false, nil
+	}
+	return moved, err
+}
+
+// onOrderStageRule aplica a regra de order.created / order.paid ao lead do
+// pedido.
+func (a *App) onOrderStageRule(event string) eventHandler {
+	return func(ctx context.Context, orgID int64, data any) {
+		o, ok := data.(Order)
+		if !ok || o.LeadID <= 0 {
+			return
+		}
+		if _, err := a.applyStageRule(ctx, orgID, o.FlowID, o.LeadID, event, 1); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("stage rule %s org=%d order=%d lead=%d: %v", event, orgID, o.ID, o.LeadID, err)
+		}
+	}
+}
+
+// GET /api/automations/stage-rules
+func (a *App) listStageRules(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _ := tenantOf(r)
+	out := make([]leadStageRule, 0, len(stageRuleEvents))
+	for _, ev := range stageRuleEvents {
+		rule, err := a.stageRuleFor(r.Context(), orgID, ev)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, rule)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// PUT /api/automations/stage-rules/{event}
+func (a *App) putStageRule(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _ := tenantOf(r)
+	event := chi.URLParam(r, "event")
+	if !validStageRuleEvent(event) {
+		render.Error(w, http.StatusNotFound, fmt.Sprintf("unknown event %q (use %s)", event, strings.Join(stageRuleEvents, ", ")))
+		return
+	}
+	var in struct {
+		ToStage       string   `json:"to_stage"`
+		Active        *bool    `json:"active"`
+		MinConfidence *float64 `json:"min_confidence"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	rule := leadStageRule{Event: event, ToStage: normalizeLeadStage(in.ToStage), Active: true}
+	if in.Active != nil {
+		rule.Active = *in.Active
+	}
+	if in.MinConfidence != nil {
+		rule.MinConfidence = *in.MinConfidence
+	}
+	var fields []render.FieldError
+	if _, ok := leadStageTransitions[rule.ToStage]; !ok || rule.ToStage == "novo" || rule.ToStage == "perdido" {
+		fields = append(fields, render.FieldError{Field: "to_stage", Message: "must be one of contato, qualificado, proposta, negociacao, cliente"})
+	}
+	if rule.MinConfidence < 0 || rule.MinConfidence > 1 {
+		fields = append(fields, render.FieldError{Field: "min_confidence", Message: "must be between 0 and 1"})
+	}
+	if len(fields) > 0 {
+		render.Validation(w, fields)
+		return
+	}
+	var updated time.Time
+	err := a.DB.QueryRow(r.Context(), `
+INSERT INTO public.lead_stage_rules (org_id, event, to_stage, min_confidence, active)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (org_id, event) DO UPDATE
+   SET to_stage=EXCLUDED.to_stage, min_confidence=EXCLUDED.min_confidence, active=EXCLUDED.active, updated_at=NOW()
+RETURNING id, updated_at`, orgID, event, rule.ToStage, rule.MinConfidence, rule.Active).Scan(&rule.ID, &updated)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rule.UpdatedAt = &updated
+	render.OK(w, rule)
+}
+
+// DELETE /api/automations/stage-rules/{event}
+func (a *App) deleteStageRule(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _ := tenantOf(r)
+	event := chi.URLParam(r, "event")
+	if !validStageRuleEvent(event) {
+		render.Error(w, http.StatusNotFound, fmt.Sprintf("unknown event %q", event))
+		return
+	}
+	if _, err := a.DB.Exec(r.Context(),
+		`DELETE FROM public.lead_stage_rules WHERE org_id=$1 AND event=$2`, orgID, event); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.NoContent(w)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Leads: detalhe, edição, exclusão e etapas do funil
+// ================================================================
+//
+// Etapas: novo → contato → qualificado → proposta → negociacao → cliente,
+// com "perdido" como saída de qualquer etapa aberta. Cada mudança é gravada
+// em lead_stage_changes (migrations/0003) e em leads.stage_changed_at, base
+// para as análises de funil. Leads com etapa fora da lista (legado em texto
+// livre) podem ir para qualquer etapa. As regras automáticas
+// (lead_stage_rules.go) seguem outra validação: só avançam.
+
+var leadStageTransitions = map[string][]string{
+	"novo":        {"contato", "qualificado", "perdido"},
+	"contato":     {"qualificado", "proposta", "perdido"},
+	"qualificado": {"contato", "proposta", "negociacao", "perdido"},
+	"proposta":    {"qualificado", "negociacao", "cliente", "perdido"},
+	"negociacao":  {"proposta", "cliente", "perdido"},
+	"cliente":     {"perdido"},
+	"perdido":     {"novo", "contato"},
+}
+
+// aliases aceitos na entrada (integrações antigas gravavam em inglês)
+var leadStageAliases = map[string]string{
+	"new": "novo", "contacted": "contato", "qualified": "qualificado",
+	"proposal": "proposta", "negotiation": "negociacao", "negociação": "negociacao",
+	"won": "cliente", "customer": "cliente", "lost": "perdido",
+}
+
+func normalizeLeadStage(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if v, ok := leadStageAliases[s]; ok {
+		return v
+	}
+	return s
+}
+
+var (
+	errStageTransition = errors.New("stage transition not allowed")
+	errUnknownStage    = errors.New("unknown stage")
+)
+
+func validateStageTransition(from, to string) error {
+	if _, ok := leadStageTransitions[to]; !ok {
+		return fmt.Errorf("%w %q", errUnknownStage, to)
+	}
+	next, known := leadStageTransitions[from]
+	if !known || from == to {
+		return nil
+	}
+	for _, s := range next {
+		if s == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", errStageTransition, from, to)
+}
+
+type leadStageChange struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedBy *int64    `json:"changed_by,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func leadIDParam(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// loadLead lê e decifra um lead do tenant.
+func (a *App) loadLead(ctx context.Context, orgID, flowID, id int64) (Lead, error) {
+	var v Lead
+	err := a.DB.QueryRow(ctx, `
+SELECT id, org_id, flow_id, COALESCE(name,''), COALESCE(phone,''), COALESCE(email,''), COALESCE(stage,''), created_at
+  FROM leads WHERE id=$1 AND org_id=$2 AND flow_id=$3`, id, orgID, flowID).
+		Scan(&v.ID, &v.OrgID, &v.FlowID, &v.Name, &v.Phone, &v.Email, &v.Stage, &v.CreatedAt)
+	if err != nil {
+		return v, err
+	}
+	v.Name, v.Phone, v.Email = revealPII(orgID, v.Name), revealPII(orgID, v.Phone), revealPII(orgID, v.Email)
+	return v, nil
+}
+
+// GET /api/leads/{id}
+func (a *App) getLead(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	id, ok := leadIDParam(r)
+	if !ok {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	lead, err := a.loadLead(r.Context(), orgID, flowID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rows, err := a.DB.Query(r.Context(), `
+SELECT COALESCE(from_stage,''), to_stage, changed_by, COALESCE(rule_event,''), changed_at
+  FROM lead_stage_changes WHERE lead_id=$1 ORDER BY changed_at`, id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	history := []leadStageChange{}
+	for rows.Next() {
+		var c leadStageChange
+		if err := rows.Scan(&c.From, &c.To, &c.ChangedBy, &c.Rule, &c.ChangedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		history = append(history, c)
+	}
+	render.OK(w, map[string]any{"lead": lead, "stage_history": history})
+}
+
+// PUT /api/leads/{id}  {name?, phone?, email?, stage?}
+func (a *App) updateLead(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	id, ok := leadIDParam(r)
+	if !ok {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var in struct {
+		Name  *string `json:"name"`
+		Phone *string `json:"phone"`
+		Email *string `json:"email"`
+		Stage *string `json:"stage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	lead, err := a.loadLead(ctx, orgID, flowID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if in.Name != nil {
+		lead.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.Phone != nil {
+		lead.Phone = leadPhone(*in.Phone)
+	}
+	if in.Email != nil {
+		lead.Email = strings.TrimSpace(*in.Email)
+	}
+	to := ""
+	if in.Stage != nil && normalizeLeadStage(*in.Stage) != normalizeLeadStage(lead.Stage) {
+		// valida antes de gravar qualquer coisa (sem atualização parcial)
+		to = normalizeLeadStage(*in.Stage)
+		if err := validateStageTransition(normalizeLeadStage(lead.Stage), to); err != nil {
+			stageError(w, err)
+			return
+		}
+	}
+
+	version := piiKeyVersion(ctx, a.DB, orgID)
+	var enc [3]string
+	for i, v := range []string{lead.Name, lead.Phone, lead.Email} {
+		if enc[i], err = encryptPII(orgID, version, v); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if _, err := a.DB.Exec(ctx, `
+UPDATE leads SET name=$1, phone=$2, email=$3, phone_hash=NULLIF($4,''), email_hash=NULLIF($5,''), updated_at=NOW()
+ WHERE id=$6 AND org_id=$7 AND flow_id=$8`,
+		enc[0], enc[1], enc[2], phoneHash(orgID, lead.Phone), piiHash(orgID, lead.Email), id, orgID, flowID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if to != "" {
+		if err := a.changeLeadStage(ctx, r, orgID, flowID, id, to); err != nil {
+			stageError(w, err)
+			return
+		}
+		lead.Stage = to
+	}
+	render.OK(w, lead)
+}
+
+// DELETE /api/leads/{id}
+func (a *App) deleteLead(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	id, ok := leadIDParam(r)
+	if !ok {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `DELETE FROM leads WHERE id=$1 AND org_id=$2 AND flow_id=$3`, id, orgID, flowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	render.NoContent(w)
+}
+
+// POST /api/leads/{id}/stage  {stage}
+func (a *App) setLeadStage(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	id, ok := leadIDParam(r)
+	if !ok {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var in struct {
+		Stage string `json:"stage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	to := normalizeLeadStage(in.Stage)
+	if to == "" {
+		render.Error(w, http.StatusBadRequest, "stage required")
+		return
+	}
+	if err := a.changeLeadStage(r.Context(), r, orgID, flowID, id, to); err != nil {
+		stageError(w, err)
+		return
+	}
+	render.OK(w, map[string]any{"id": id, "stage": to})
+}
+
+// changeLeadStage valida e aplica a transição pedida por um usuário.
+func (a *App) changeLeadStage(ctx context.Context, r *http.Request, orgID, flowID, id int64, to string) error {
+	var by *int64
+	if c, ok := claimsFromContext(r.Context()); ok && c.UserID > 0 {
+		by = &c.UserID
+	}
+	_, err := a.moveLeadStage(ctx, orgID, flowID, id, to, by, "", validateStageTransition)
+	return err
+}
+
+// moveLeadStage aplica a mudança se allow aceitar a transição, registrando o
+// histórico na mesma transação (FOR UPDATE evita corrida entre duas
+// mudanças). rule é o evento da regra automática que moveu o lead
+// (lead_stage_rules.go); vazio = mudança manual. false = já estava na etapa.
+func (a *App) moveLeadStage(ctx context.Context, orgID, flowID, id int64, to string, by *int64, rule string, allow func(from, to string) error) (bool, error) {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var from string
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(stage,'') FROM leads WHERE id=$1 AND org_id=$2 AND flow_id=$3 FOR UPDATE`,
+		id, orgID, flowID).Scan(&from)
+	if err != nil {
+		return false, err
+	}
+	from = normalizeLeadStage(from)
+	if from == to {
+		return false, tx.Commit(ctx)
+	}
+	if err := allow(from, to); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE leads SET stage=$1, stage_changed_at=NOW(), updated_at=NOW() WHERE id=$2`, to, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO lead_stage_changes (org_id, flow_id, lead_id, from_stage, to_stage, changed_by, rule_event)
+VALUES ($1, $2, $3, NULLIF($4,''), $5, $6, NULLIF($7,''))`, orgID, flowID, id, from, to, by, rule); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	a.publishEvent(ctx, orgID, eventLeadStageChanged, leadStageEvent{
+		LeadID: id, OrgID: orgID, FlowID: flowID, From: from, To: to, ChangedBy: by, Rule: rule,
+	})
+	return true, nil
+}
+
+// leadStageEvent é o payload de lead.stage_changed.
+type leadStageEvent struct {
+	LeadID    int64  `json:"lead_id"`
+	OrgID     int64  `json:"org_id"`
+	FlowID    int64  `json:"flow_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	ChangedBy *int64 `json:"changed_by,omitempty"`
+	Rule      string `json:"rule,omitempty"` // evento da regra automática (lead_stage_rules.go)
+}
+
+func stageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		render.Error(w, http.StatusNotFound, "lead not found")
+	case errors.Is(err, errStageTransition):
+		render.Error(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errUnknownStage):
+		render.Error(w, http.StatusBadRequest, err.Error())
+	default:
+		render.Error(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,230 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// anthropicProvider fala com a Messages API (POST /v1/messages). O streaming
+// é emulado: a resposta completa chega como um único chunk.
+type anthropicProvider struct {
+	cfg  Config
+	http *http.Client
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Source    *anthropicImage `json:"source,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicImage struct {
+	Type      string `json:"type"` // base64 | url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+func (p *anthropicProvider) Name() string { return Anthropic }
+
+func (p *anthropicProvider) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	model := p.cfg.modelFor(req)
+	body := map[string]any{
+		"model":      model,
+		"max_tokens": p.cfg.MaxTokens,
+	}
+	if req.MaxTokens > 0 {
+		body["max_tokens"] = req.MaxTokens
+	}
+	if req.Temperature > 0 {
+		body["temperature"] = req.Temperature
+	}
+
+	var system []string
+	var msgs []anthropicMessage
+	add := func(role string, blocks ...anthropicBlock) {
+		// a API exige alternância user/assistant: mensagens seguidas do mesmo
+		// papel (ex.: vários tool_result) viram uma só
+		if n := len(msgs); n > 0 && msgs[n-1].Role == role {
+			msgs[n-1].Content = append(msgs[n-1].Content, blocks...)
+			return
+		}
+		msgs = append(msgs, anthropicMessage{Role: role, Content: blocks})
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case openai.ChatMessageRoleSystem:
+			system = append(system, m.Content)
+		case openai.ChatMessageRoleTool:
+			add("user", anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content})
+		case openai.ChatMessageRoleAssistant:
+			var blocks []anthropicBlock
+			if strings.TrimSpace(m.Content) != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage(`{}`)
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+			}
+			if len(blocks) > 0 {
+				add("assistant", blocks...)
+			}
+		default:
+			add("user", anthropicUserBlocks(m)...)
+		}
+	}
+	if len(system) > 0 {
+		body["system"] = strings.Join(system, "\n\n")
+	}
+	body["messages"] = msgs
+	if len(req.Tools) > 0 {
+		tools := make([]anthropicTool, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			if t.Function == nil {
+				continue
+			}
+			schema := t.Function.Parameters
+			if schema == nil {
+				schema = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			tools = append(tools, anthropicTool{Name: t.Function.Name, Description: t.Function.Description, InputSchema: schema})
+		}
+		body["tools"] = tools
+	}
+
+	var out struct {
+		ID         string           `json:"id"`
+		Model      string           `json:"model"`
+		Content    []anthropicBlock `json:"content"`
+		StopReason string           `json:"stop_reason"`
+		Usage      struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := p.do(ctx, body, &out); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	var text strings.Builder
+	for _, b := range out.Content {
+		switch b.Type {
+		case "text":
+			text.WriteString(b.Text)
+		case "tool_use":
+			args := string(b.Input)
+			if args == "" {
+				args = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+				ID:       b.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: b.Name, Arguments: args},
+			})
+		}
+	}
+	msg.Content = text.String()
+	finish := openai.FinishReasonStop
+	switch out.StopReason {
+	case "tool_use":
+		finish = openai.FinishReasonToolCalls
+	case "max_tokens":
+		finish = openai.FinishReasonLength
+	}
+	return openai.ChatCompletionResponse{
+		ID:      out.ID,
+		Object:  "chat.completion",
+		Model:   firstNonEmpty(out.Model, model),
+		Choices: []openai.ChatCompletionChoice{{Message: msg, FinishReason: finish}},
+		Usage: openai.Usage{
+			PromptTokens:     out.Usage.InputTokens,
+			CompletionTokens: out.Usage.OutputTokens,
+			TotalTokens:      out.Usage.InputTokens + out.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (p *anthropicProvider) ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error) {
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return newSingleStream(resp), nil
+}
+
+func anthropicUserBlocks(m openai.ChatCompletionMessage) []anthropicBlock {
+	if len(m.MultiContent) == 0 {
+		return []anthropicBlock{{Type: "text", Text: m.Content}}
+	}
+	var blocks []anthropicBlock
+	for _, part := range m.MultiContent {
+		switch {
+		case part.Type == openai.ChatMessagePartTypeText:
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case part.ImageURL != nil:
+			if mime, data, ok := parseDataURL(part.ImageURL.URL); ok {
+				blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImage{Type: "base64", MediaType: mime, Data: data}})
+			} else {
+				blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImage{Type: "url", URL: part.ImageURL.URL}})
+			}
+		}
+	}
+	return blocks
+}
+
+func (p *anthropicProvider) do(ctx context.Context, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.cfg.BaseURL, "/")+"/v1/messages", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", p.cfg.APIKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("anthropic: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		return &StatusError{Provider: Anthropic, Status: resp.StatusCode, Message: firstNonEmpty(e.Error.Message, string(raw))}
+	}
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Embedder gera embeddings de texto (base de conhecimento do agente). Só
+// OpenAI e Ollama (API compatível) têm endpoint de embeddings aqui.
+type Embedder interface {
+	Name() string
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float32, openai.Usage, error)
+}
+
+// EmbedderFromEnv lê EMBEDDING_PROVIDER (padrão openai) e EMBEDDING_MODEL
+// (padrão text-embedding-3-small; nomic-embed-text no Ollama). As credenciais
+// são as do chat (OPENAI_API_KEY/OPENAI_BASE_URL, OLLAMA_URL).
+func EmbedderFromEnv() (Embedder, error) {
+	provider := strings.ToLower(strings.TrimSpace(getenv("EMBEDDING_PROVIDER", OpenAI)))
+	cfg := ConfigFromEnv(provider)
+	cfg.Timeout = 60 * time.Second
+	switch cfg.Provider {
+	case OpenAI:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("%w: OPENAI_API_KEY not set", ErrNotConfigured)
+		}
+		cfg.Model = getenv("EMBEDDING_MODEL", "text-embedding-3-small")
+		return &openaiEmbedder{provider: newOpenAI(cfg)}, nil
+	case Ollama:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("%w: OLLAMA_URL not set", ErrNotConfigured)
+		}
+		cfg.Model = getenv("EMBEDDING_MODEL", "nomic-embed-text")
+		return &openaiEmbedder{provider: newOllama(cfg)}, nil
+	}
+	return nil, fmt.Errorf("embedding provider %q not supported (use openai or ollama)", provider)
+}
+
+type openaiEmbedder struct{ provider *openaiProvider }
+
+func (e *openaiEmbedder) Name() string { return e.provider.name }
+
+// Model inclui o prefixo do provedor local ("ollama/..."), como no chat.
+func (e *openaiEmbedder) Model() string { return e.provider.prefix + e.provider.cfg.Model }
+
+func (e *openaiEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, openai.Usage, error) {
+	resp, err := e.provider.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.provider.cfg.Model),
+	})
+	if err != nil {
+		return nil, openai.Usage{}, err
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, resp.Usage, fmt.Errorf("embeddings: got %d vectors for %d inputs", len(resp.Data), len(texts))
+	}
+	out := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(out) {
+			return nil, resp.Usage, fmt.Errorf("embeddings: index %d out of range", d.Index)
+		}
+		out[d.Index] = d.Embedding
+	}
+	return out, resp.Usage, nil
+}
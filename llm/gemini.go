@@ -0,0 +1,300 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// geminiProvider fala com a API generateContent do Google AI. O streaming é
+// emulado, como no adaptador da Anthropic.
+type geminiProvider struct {
+	cfg  Config
+	http *http.Client
+}
+
+type geminiPart struct {
+	Text             string          `json:"text,omitempty"`
+	InlineData       *geminiBlob     `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFuncCall `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFuncResp `json:"functionResponse,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFuncCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFuncResp struct {
+	Name     string `json:"name"`
+	Response any    `json:"response"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// campos de schema aceitos nas declarações de função
+var geminiSchemaKeys = map[string]bool{
+	"type": true, "description": true, "enum": true, "properties": true,
+	"required": true, "items": true, "format": true, "nullable": true,
+}
+
+func (p *geminiProvider) Name() string { return Gemini }
+
+func (p *geminiProvider) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	model := p.cfg.modelFor(req)
+
+	// o Gemini não tem ids de chamada: a resposta da ferramenta é associada
+	// pelo nome
+	callNames := map[string]string{}
+	var system []string
+	var contents []geminiContent
+	add := func(role string, parts ...geminiPart) {
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			return
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: parts})
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case openai.ChatMessageRoleSystem:
+			system = append(system, m.Content)
+		case openai.ChatMessageRoleTool:
+			var resp any
+			if err := json.Unmarshal([]byte(m.Content), &resp); err != nil {
+				resp = map[string]any{"content": m.Content}
+			} else if _, isObj := resp.(map[string]any); !isObj {
+				resp = map[string]any{"content": resp}
+			}
+			add("user", geminiPart{FunctionResponse: &geminiFuncResp{Name: callNames[m.ToolCallID], Response: resp}})
+		case openai.ChatMessageRoleAssistant:
+			var parts []geminiPart
+			if strings.TrimSpace(m.Content) != "" {
+				parts = append(parts, geminiPart{Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				callNames[tc.ID] = tc.Function.Name
+				args := json.RawMessage(tc.Function.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage(`{}`)
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFuncCall{Name: tc.Function.Name, Args: args}})
+			}
+			if len(parts) > 0 {
+				add("model", parts...)
+			}
+		default:
+			parts, err := geminiUserParts(m)
+			if err != nil {
+				return openai.ChatCompletionResponse{}, err
+			}
+			add("user", parts...)
+		}
+	}
+
+	body := map[string]any{"contents": contents}
+	if len(system) > 0 {
+		body["systemInstruction"] = geminiContent{Parts: []geminiPart{{Text: strings.Join(system, "\n\n")}}}
+	}
+	gen := map[string]any{}
+	if req.Temperature > 0 {
+		gen["temperature"] = req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		gen["maxOutputTokens"] = req.MaxTokens
+	}
+	if len(gen) > 0 {
+		body["generationConfig"] = gen
+	}
+	if len(req.Tools) > 0 {
+		var decls []map[string]any
+		for _, t := range req.Tools {
+			if t.Function == nil {
+				continue
+			}
+			d := map[string]any{"name": t.Function.Name, "description": t.Function.Description}
+			if schema := geminiSchema(t.Function.Parameters); schema != nil {
+				d["parameters"] = schema
+			}
+			decls = append(decls, d)
+		}
+		body["tools"] = []map[string]any{{"functionDeclarations": decls}}
+	}
+
+	var out struct {
+		Candidates []struct {
+			Content      geminiContent `json:"content"`
+			FinishReason string        `json:"finishReason"`
+		} `json:"candidates"`
+		UsageMetadata struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+		} `json:"usageMetadata"`
+		ModelVersion string `json:"modelVersion"`
+	}
+	if err := p.do(ctx, model, body, &out); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	if len(out.Candidates) == 0 {
+		return openai.ChatCompletionResponse{}, &StatusError{Provider: Gemini, Status: http.StatusBadGateway, Message: "no candidates (blocked by safety filters?)"}
+	}
+
+	cand := out.Candidates[0]
+	msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	var text strings.Builder
+	for i, part := range cand.Content.Parts {
+		if part.FunctionCall != nil {
+			args := string(part.FunctionCall.Args)
+			if args == "" {
+				args = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+				ID:       fmt.Sprintf("call_%d", i),
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: part.FunctionCall.Name, Arguments: args},
+			})
+			continue
+		}
+		text.WriteString(part.Text)
+	}
+	msg.Content = text.String()
+	finish := openai.FinishReasonStop
+	switch {
+	case len(msg.ToolCalls) > 0:
+		finish = openai.FinishReasonToolCalls
+	case cand.FinishReason == "MAX_TOKENS":
+		finish = openai.FinishReasonLength
+	}
+	u := out.UsageMetadata
+	return openai.ChatCompletionResponse{
+		Object:  "chat.completion",
+		Model:   firstNonEmpty(out.ModelVersion, model),
+		Choices: []openai.ChatCompletionChoice{{Message: msg, FinishReason: finish}},
+		Usage: openai.Usage{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount,
+			TotalTokens:      u.PromptTokenCount + u.CandidatesTokenCount,
+		},
+	}, nil
+}
+
+func (p *geminiProvider) ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error) {
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return newSingleStream(resp), nil
+}
+
+func geminiUserParts(m openai.ChatCompletionMessage) ([]geminiPart, error) {
+	if len(m.MultiContent) == 0 {
+		return []geminiPart{{Text: m.Content}}, nil
+	}
+	var parts []geminiPart
+	for _, part := range m.MultiContent {
+		switch {
+		case part.Type == openai.ChatMessagePartTypeText:
+			parts = append(parts, geminiPart{Text: part.Text})
+		case part.ImageURL != nil:
+			mime, data, ok := parseDataURL(part.ImageURL.URL)
+			if !ok {
+				return nil, &StatusError{Provider: Gemini, Status: http.StatusBadRequest, Message: "only data: image URLs are supported"}
+			}
+			parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: mime, Data: data}})
+		}
+	}
+	return parts, nil
+}
+
+// geminiSchema reduz o JSON Schema das ferramentas ao subconjunto aceito
+// pelo Gemini. Objetos sem propriedades não podem ser declarados (nil).
+func geminiSchema(params any) map[string]any {
+	if params == nil {
+		return nil
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	var schema map[string]any
+	if json.Unmarshal(b, &schema) != nil {
+		return nil
+	}
+	schema = geminiCleanSchema(schema)
+	if props, _ := schema["properties"].(map[string]any); schema["type"] == "object" && len(props) == 0 {
+		return nil
+	}
+	return schema
+}
+
+func geminiCleanSchema(s map[string]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range s {
+		if !geminiSchemaKeys[k] {
+			continue
+		}
+		switch k {
+		case "properties":
+			props, _ := v.(map[string]any)
+			clean := map[string]any{}
+			for name, p := range props {
+				if pm, ok := p.(map[string]any); ok {
+					clean[name] = geminiCleanSchema(pm)
+				}
+			}
+			out[k] = clean
+		case "items":
+			if im, ok := v.(map[string]any); ok {
+				out[k] = geminiCleanSchema(im)
+			}
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func (p *geminiProvider) do(ctx context.Context, model string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimRight(p.cfg.BaseURL, "/") + "/models/" + url.PathEscape(model) + ":generateContent"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", p.cfg.APIKey)
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("gemini: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		return &StatusError{Provider: Gemini, Status: resp.StatusCode, Message: firstNonEmpty(e.Error.Message, string(raw))}
+	}
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,298 @@
+// Package llm isola as chamadas de chat/visão do provedor de modelo. O formato
+// de troca é o da OpenAI (go-openai): os adaptadores de Anthropic e Gemini
+// traduzem mensagens, imagens, ferramentas e consumo de tokens; o Ollama usa
+// a API compatível com a OpenAI. Chain encadeia provedores para failover.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Nomes dos provedores suportados (LLM_PROVIDER, agent_settings.llm_provider).
+const (
+	OpenAI    = "openai"
+	Anthropic = "anthropic"
+	Gemini    = "gemini"
+	Ollama    = "ollama"
+)
+
+// Providers lista os nomes aceitos.
+var Providers = []string{OpenAI, Anthropic, Gemini, Ollama}
+
+// ErrNotConfigured indica que faltam credenciais/URL do provedor.
+var ErrNotConfigured = errors.New("llm provider not configured")
+
+// Provider é um backend de chat. Request.Model vazio usa o modelo padrão do
+// provedor (o de visão quando há imagens). A resposta traz em Model o modelo
+// efetivamente usado.
+type Provider interface {
+	Name() string
+	Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error)
+}
+
+// Stream é satisfeito por *openai.ChatCompletionStream. Recv devolve io.EOF
+// ao terminar.
+type Stream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// Config reúne as opções de um provedor.
+type Config struct {
+	Provider    string
+	APIKey      string
+	BaseURL     string
+	Model       string // modelo de texto padrão
+	VisionModel string // modelo para mensagens com imagem (padrão: Model)
+	MaxTokens   int    // Anthropic exige max_tokens
+	Timeout     time.Duration
+}
+
+// ConfigFromEnv lê a configuração do provedor:
+//
+//	openai:    OPENAI_API_KEY, OPENAI_BASE_URL, TEXT_MODEL, VISION_MODEL
+//	anthropic: ANTHROPIC_API_KEY, ANTHROPIC_BASE_URL, ANTHROPIC_MODEL, ANTHROPIC_MAX_TOKENS
+//	gemini:    GEMINI_API_KEY, GEMINI_BASE_URL, GEMINI_MODEL
+//	ollama:    OLLAMA_URL, OLLAMA_MODEL, OLLAMA_VISION_MODEL
+func ConfigFromEnv(provider string) Config {
+	cfg := Config{Provider: strings.ToLower(strings.TrimSpace(provider)), Timeout: 120 * time.Second}
+	switch cfg.Provider {
+	case OpenAI, "":
+		cfg.Provider = OpenAI
+		cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+		cfg.BaseURL = os.Getenv("OPENAI_BASE_URL")
+		cfg.Model = getenv("TEXT_MODEL", "gpt-4o-mini")
+		cfg.VisionModel = getenv("VISION_MODEL", "gpt-4o")
+	case Anthropic:
+		cfg.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		cfg.BaseURL = getenv("ANTHROPIC_BASE_URL", "https://api.anthropic.com")
+		cfg.Model = getenv("ANTHROPIC_MODEL", "claude-3-5-haiku-latest")
+		cfg.MaxTokens, _ = strconv.Atoi(getenv("ANTHROPIC_MAX_TOKENS", "1024"))
+	case Gemini:
+		cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+		cfg.BaseURL = getenv("GEMINI_BASE_URL", "https://generativelanguage.googleapis.com/v1beta")
+		cfg.Model = getenv("GEMINI_MODEL", "gemini-1.5-flash")
+	case Ollama:
+		cfg.BaseURL = os.Getenv("OLLAMA_URL")
+		cfg.Model = getenv("OLLAMA_MODEL", "llama3.1")
+		cfg.VisionModel = getenv("OLLAMA_VISION_MODEL", "llava")
+	}
+	return cfg
+}
+
+// New cria o provedor descrito em cfg.
+func New(cfg Config) (Provider, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 120 * time.Second
+	}
+	switch cfg.Provider {
+	case OpenAI:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("%w: OPENAI_API_KEY not set", ErrNotConfigured)
+		}
+		return newOpenAI(cfg), nil
+	case Anthropic:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("%w: ANTHROPIC_API_KEY not set", ErrNotConfigured)
+		}
+		if cfg.MaxTokens <= 0 {
+			cfg.MaxTokens = 1024
+		}
+		return &anthropicProvider{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}, nil
+	case Gemini:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("%w: GEMINI_API_KEY not set", ErrNotConfigured)
+		}
+		return &geminiProvider{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}, nil
+	case Ollama:
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("%w: OLLAMA_URL not set", ErrNotConfigured)
+		}
+		return newOllama(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown llm provider %q", cfg.Provider)
+}
+
+// Valid indica se name é um provedor suportado.
+func Valid(name string) bool {
+	for _, p := range Providers {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusError é o erro HTTP devolvido pelos adaptadores Anthropic/Gemini.
+type StatusError struct {
+	Provider string
+	Status   int
+	Message  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status %d: %s", e.Provider, e.Status, e.Message)
+}
+
+// Retryable indica se vale tentar o próximo provedor: falhas de rede,
+// timeouts, 429 e 5xx. Erros de requisição (4xx) se repetiriam em outro
+// provedor e cancelamentos do cliente encerram a tentativa.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	status := 0
+	var se *StatusError
+	var ae *openai.APIError
+	var re *openai.RequestError
+	switch {
+	case errors.As(err, &se):
+		status = se.Status
+	case errors.As(err, &ae):
+		status = ae.HTTPStatusCode
+	case errors.As(err, &re):
+		status = re.HTTPStatusCode
+	}
+	if status != 0 {
+		return status == http.StatusTooManyRequests || status >= 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Chain tenta os provedores em ordem, passando ao próximo quando o erro é
+// Retryable. O modelo pedido vale só para o primeiro; os demais usam o
+// padrão de cada um. No streaming o failover acontece apenas na abertura.
+type Chain []Provider
+
+func (c Chain) Name() string {
+	if len(c) == 0 {
+		return ""
+	}
+	return c[0].Name()
+}
+
+func (c Chain) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	var lastErr error = ErrNotConfigured
+	for i, p := range c {
+		r := req
+		if i > 0 {
+			r.Model = ""
+		}
+		resp, err := p.Chat(ctx, r)
+		if err == nil || !Retryable(err) || i == len(c)-1 {
+			return resp, err
+		}
+		log.Printf("llm: %s failed, trying %s: %v", p.Name(), c[i+1].Name(), err)
+		lastErr = err
+	}
+	return openai.ChatCompletionResponse{}, lastErr
+}
+
+func (c Chain) ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error) {
+	var lastErr error = ErrNotConfigured
+	for i, p := range c {
+		r := req
+		if i > 0 {
+			r.Model = ""
+		}
+		s, err := p.ChatStream(ctx, r)
+		if err == nil || !Retryable(err) || i == len(c)-1 {
+			return s, err
+		}
+		log.Printf("llm: %s stream failed, trying %s: %v", p.Name(), c[i+1].Name(), err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// modelFor escolhe o modelo da requisição: o pedido, o de visão (se houver
+// imagem) ou o padrão.
+func (cfg Config) modelFor(req openai.ChatCompletionRequest) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	if cfg.VisionModel != "" && hasImages(req.Messages) {
+		return cfg.VisionModel
+	}
+	return cfg.Model
+}
+
+func hasImages(msgs []openai.ChatCompletionMessage) bool {
+	for _, m := range msgs {
+		for _, p := range m.MultiContent {
+			if p.Type == openai.ChatMessagePartTypeImageURL && p.ImageURL != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseDataURL separa "data:<mime>;base64,<dados>".
+func parseDataURL(u string) (mime, data string, ok bool) {
+	rest, found := strings.CutPrefix(u, "data:")
+	if !found {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(rest, ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
+
+// singleStream entrega uma resposta completa como um único chunk (para
+// provedores sem streaming implementado aqui).
+type singleStream struct {
+	chunk openai.ChatCompletionStreamResponse
+	done  bool
+}
+
+func newSingleStream(resp openai.ChatCompletionResponse) *singleStream {
+	chunk := openai.ChatCompletionStreamResponse{ID: resp.ID, Model: resp.Model, Usage: &resp.Usage}
+	if len(resp.Choices) > 0 {
+		msg := resp.Choices[0].Message
+		calls := make([]openai.ToolCall, len(msg.ToolCalls))
+		for i, tc := range msg.ToolCalls {
+			idx := i
+			tc.Index = &idx
+			calls[i] = tc
+		}
+		chunk.Choices = []openai.ChatCompletionStreamChoice{{
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Role: msg.Role, Content: msg.Content, ToolCalls: calls},
+			FinishReason: resp.Choices[0].FinishReason,
+		}}
+	}
+	return &singleStream{chunk: chunk}
+}
+
+func (s *singleStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if s.done {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	s.done = true
+	return s.chunk, nil
+}
+
+func (s *singleStream) Close() error { return nil }
+
+func getenv(k, def string) string {
+	if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// openaiProvider atende OpenAI e qualquer endpoint compatível (Ollama).
+type openaiProvider struct {
+	name   string
+	cfg    Config
+	client *openai.Client
+	// prefix é acrescentado ao Model da resposta (ex.: "ollama/") para
+	// distinguir o consumo de modelos locais em ai_usage.
+	prefix string
+}
+
+func newOpenAI(cfg Config) *openaiProvider {
+	oc := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		oc.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	}
+	oc.HTTPClient = &http.Client{Timeout: cfg.Timeout}
+	return &openaiProvider{name: OpenAI, cfg: cfg, client: openai.NewClientWithConfig(oc)}
+}
+
+// newOllama usa a API compatível com a OpenAI do Ollama ({OLLAMA_URL}/v1).
+func newOllama(cfg Config) *openaiProvider {
+	oc := openai.DefaultConfig("ollama")
+	oc.BaseURL = strings.TrimRight(cfg.BaseURL, "/") + "/v1"
+	oc.HTTPClient = &http.Client{Timeout: cfg.Timeout}
+	return &openaiProvider{name: Ollama, cfg: cfg, client: openai.NewClientWithConfig(oc), prefix: "ollama/"}
+}
+
+func (p *openaiProvider) Name() string { return p.name }
+
+func (p *openaiProvider) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	req.Model = p.cfg.modelFor(req)
+	if p.name == Ollama {
+		// o Ollama não aceita stream_options
+		req.StreamOptions = nil
+	}
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	resp.Model = p.prefix + firstNonEmpty(resp.Model, req.Model)
+	return resp, err
+}
+
+func (p *openaiProvider) ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error) {
+	req.Model = p.cfg.modelFor(req)
+	req.Stream = true
+	if p.name == Ollama {
+		req.StreamOptions = nil
+	}
+	s, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if p.prefix == "" {
+		return s, nil
+	}
+	return &prefixedStream{Stream: s, prefix: p.prefix, model: req.Model}, nil
+}
+
+type prefixedStream struct {
+	Stream
+	prefix, model string
+}
+
+func (s *prefixedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	chunk, err := s.Stream.Recv()
+	chunk.Model = s.prefix + firstNonEmpty(chunk.Model, s.model)
+	return chunk, err
+}
+
+func firstNonEmpty(v ...string) string {
+	for _, s := range v {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Programa de pontos (fidelidade)
+// ================================================================
+//
+// GET  /api/loyalty/settings               regras da org
+// PUT  /api/loyalty/settings               (admin)
+// GET  /api/leads/{id}/loyalty             saldo e extrato do lead
+// POST /api/leads/{id}/loyalty/adjust      (admin) {points, note} ajuste manual
+// POST /api/orders/{id}/redeem-points      {points} desconto em pedido pendente
+//
+// Todo movimento é uma linha em loyalty_ledger e o saldo é a soma delas:
+//   earn           pedido pago: points_per_real pontos por real pago
+//   redeem         pontos trocados por desconto no pedido (negativo)
+//   earn_reversal  pedido cancelado: estorna os pontos ganhos
+//   redeem_refund  pedido cancelado: devolve os pontos usados
+//   adjust         ajuste manual, com o usuário que fez
+// Cada pedido tem no máximo um movimento de cada tipo (índice único), então
+// eventos repetidos não duplicam pontos.
+//
+// No resgate cada ponto vale point_value_cents; o desconto respeita o mínimo
+// de min_redeem_points e o teto de max_redeem_bps do total do pedido
+// (pontos-base: 5000 = 50%). O agente consulta o saldo com a ferramenta
+// get_loyalty_balance e resgata no rascunho de pedido (redeem_points).
+
+var (
+	errLoyaltyInactive = errors.New("programa de pontos desativado")
+	errLoyaltyBalance  = errors.New("saldo de pontos insuficiente")
+)
+
+func (a *App) mountLoyalty(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.Get("/loyalty/settings", a.getLoyaltySettings)
+	r.With(admin).Put("/loyalty/settings", a.putLoyaltySettings)
+	r.Get("/leads/{id}/loyalty", a.getLeadLoyalty)
+	r.With(admin).Post("/leads/{id}/loyalty/adjust", a.adjustLeadLoyalty)
+	r.Post("/orders/{id}/redeem-points", a.redeemOrderPoints)
+
+	onEvent(eventOrderPaid, func(ctx context.Context, orgID int64, data any) {
+		if o, ok := data.(Order); ok {
+			if err := a.earnLoyalty(ctx, o); err != nil {
+				log.Printf("order %d loyalty: %v", o.ID, err)
+			}
+		}
+	})
+}
+
+type loyaltySettings struct {
+	Active          bool `json:"active"`
+	PointsPerReal   int  `json:"points_per_real"`
+	PointValueCents int  `json:"point_value_cents"`
+	MinRedeemPoints int  `json:"min_redeem_points"`
+	MaxRedeemBps    int  `json:"max_redeem_bps"`
+}
+
+func defaultLoyaltySettings() loyaltySettings {
+	return loyaltySettings{PointsPerReal: 1, PointValueCents: 5, MinRedeemPoints: 100, MaxRedeemBps: 5000}
+}
+
+func (a *App) loadLoyaltySettings(ctx context.Context, orgID int64) (loyaltySettings, error) {
+	s := defaultLoyaltySettings()
+	err := a.DB.QueryRow(ctx, `
+SELECT active, points_per_real, point_value_cents, min_redeem_points, max_redeem_bps
+  FROM loyalty_settings WHERE org_id=$1`, orgID).
+		Scan(&s.Active, &s.PointsPerReal, &s.PointValueCents, &s.MinRedeemPoints, &s.MaxRedeemBps)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, nil
+	}
+	return s, err
+}
+
+// quote calcula quantos pontos podem ser usados num pedido de totalCents e o
+// desconto correspondente. Pede-se points; usa-se até o saldo e o teto.
+func (s loyaltySettings) quote(balance, points, totalCents int) (used, discountCents int, err error) {
+	if !s.Active || s.PointValueCents <= 0 {
+		return 0, 0, errLoyaltyInactive
+	}
+	used = min(points, balance)
+	if capCents := totalCents * s.MaxRedeemBps / 10000; used*s.PointValueCents > capCents {
+		used = capCents / s.PointValueCents
+	}
+	if used <= 0 || used < s.MinRedeemPoints {
+		return 0, 0, fmt.Errorf("%w (mínimo de %d pontos, saldo %d)", errLoyaltyBalance, s.MinRedeemPoints, balance)
+	}
+	return used, used * s.PointValueCents, nil
+}
+
+// GET /api/loyalty/settings
+func (a *App) getLoyaltySettings(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := a.loadLoyaltySettings(r.Context(), orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// PUT /api/loyalty/settings
+func (a *App) putLoyaltySettings(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := defaultLoyaltySettings()
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	switch {
+	case s.PointsPerReal < 0 || s.PointValueCents < 0 || s.MinRedeemPoints < 0:
+		render.Error(w, http.StatusBadRequest, "points_per_real, point_value_cents and min_redeem_points must not be negative")
+		return
+	case s.MaxRedeemBps < 0 || s.MaxRedeemBps > 10000:
+		render.Error(w, http.StatusBadRequest, "max_redeem_bps out of range (basis points, max 10000)")
+		return
+	}
+	_, err = a.DB.Exec(r.Context(), `
+INSERT INTO loyalty_settings (org_id, active, points_per_real, point_value_cents, min_redeem_points, max_redeem_bps, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW())
+ON CONFLICT (org_id) DO UPDATE SET
+  active=EXCLUDED.active, points_per_real=EXCLUDED.points_per_real, point_value_cents=EXCLUDED.point_value_cents,
+  min_redeem_points=EXCLUDED.min_redeem_points, max_redeem_bps=EXCLUDED.max_redeem_bps, updated_at=NOW()`,
+		orgID, s.Active, s.PointsPerReal, s.PointValueCents, s.MinRedeemPoints, s.MaxRedeemBps)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+func (a *App) loyaltyBalance(ctx context.Context, leadID int64) (int, error) {
+	var balance int
+	err := a.DB.QueryRow(ctx, `SELECT COALESCE(SUM(points), 0) FROM loyalty_ledger WHERE lead_id=$1`, leadID).Scan(&balance)
+	return balance, err
+}
+
+// lockLoyalty trava o lead (serializa resgates e ajustes) e devolve o saldo.
+func lockLoyalty(ctx context.Context, tx pgx.Tx, orgID, leadID int64) (int, error) {
+	var balance int
+	err := tx.QueryRow(ctx, `
+SELECT (SELECT COALESCE(SUM(points), 0) FROM loyalty_ledger WHERE lead_id = l.id)
+  FROM leads l WHERE l.id=$1 AND l.org_id=$2 FOR UPDATE`, leadID, orgID).Scan(&balance)
+	return balance, err
+}
+
+// earnLoyalty credita os pontos de um pedido pago.
+func (a *App) earnLoyalty(ctx context.Context, o Order) error {
+	if o.LeadID <= 0 {
+		return nil
+	}
+	s, err := a.loadLoyaltySettings(ctx, o.OrgID)
+	if err != nil || !s.Active {
+		return err
+	}
+	points := o.TotalCents * s.PointsPerReal / 100
+	if points <= 0 {
+		return nil
+	}
+	_, err = a.DB.Exec(ctx, `
+INSERT INTO loyalty_ledger (org_id, lead_id, order_id, kind, points, note)
+VALUES ($1, $2, $3, 'earn', $4, $5) ON CONFLICT (order_id, kind) WHERE order_id IS NOT NULL DO NOTHING`,
+		o.OrgID, o.LeadID, o.ID, points, fmt.Sprintf("pedido #%d", o.ID))
+	return err
+}
+
+// reverseLoyalty desfaz os movimentos de um pedido cancelado: estorna os
+// pontos ganhos e devolve os usados no desconto.
+func (a *App) reverseLoyalty(ctx context.Context, orderID int64) error {
+	_, err := a.DB.Exec(ctx, `
+INSERT INTO loyalty_ledger (org_id, lead_id, order_id, kind, points, note)
+SELECT org_id, lead_id, order_id,
+       CASE kind WHEN 'earn' THEN 'earn_reversal' ELSE 'redeem_refund' END,
+       -points, 'pedido #' || order_id || ' cancelado'
+  FROM loyalty_ledger
+ WHERE order_id=$1 AND kind IN ('earn', 'redeem')
+ON CONFLICT (order_id, kind) WHERE order_id IS NOT NULL DO NOTHING`, orderID)
+	return err
+}
+
+type loyaltyEntry struct {
+	ID        int64     `json:"id"`
+	OrderID   *int64    `json:"order_id,omitempty"`
+	Kind      string    `json:"kind"`
+	Points    int       `json:"points"`
+	Note      string    `json:"note,omitempty"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET /api/leads/{id}/loyalty
+func (a *App) getLeadLoyalty(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	leadID, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	ctx := r.Context()
+	var exists bool
+	if err := a.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM leads WHERE id=$1 AND org_id=$2 AND flow_id=$3)`,
+		leadID, orgID, flowID).Scan(&exists); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	s, err := a.loadLoyaltySettings(ctx, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(ctx, `
+SELECT id, order_id, kind, points, COALESCE(note,''), user_id, created_at
+  FROM loyalty_ledger WHERE lead_id=$1
+ ORDER BY created_at DESC, id DESC LIMIT 200`, leadID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	items := []loyaltyEntry{}
+	for rows.Next() {
+		var x loyaltyEntry
+		if err := rows.Scan(&x.ID, &x.OrderID, &x.Kind, &x.Points, &x.Note, &x.UserID, &x.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		items = append(items, x)
+	}
+	if err := rows.Err(); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	balance, err := a.loyaltyBalance(ctx, leadID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{
+		"lead_id":     leadID,
+		"balance":     balance,
+		"value_cents": balance * s.PointValueCents,
+		"items":       items,
+	})
+}
+
+// POST /api/leads/{id}/loyalty/adjust {"points": -50, "note": "..."}
+func (a *App) adjustLeadLoyalty(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	leadID, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	var in struct {
+		Points int    `json:"points"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Note = limitRunes(strings.TrimSpace(in.Note), 300)
+	if in.Points == 0 || in.Note == "" {
+		render.Error(w, http.StatusBadRequest, "points (non-zero) and note are required")
+		return
+	}
+	ctx := r.Context()
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	var inFlow bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM leads WHERE id=$1 AND org_id=$2 AND flow_id=$3)`,
+		leadID, orgID, flowID).Scan(&inFlow); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !inFlow {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	balance, err := lockLoyalty(ctx, tx, orgID, leadID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if balance+in.Points < 0 {
+		render.Error(w, http.StatusConflict, fmt.Sprintf("adjustment would make the balance negative (balance %d)", balance))
+		return
+	}
+	var x loyaltyEntry
+	err = tx.QueryRow(ctx, `
+INSERT INTO loyalty_ledger (org_id, lead_id, kind, points, note, user_id)
+VALUES ($1, $2, 'adjust', $3, $4, $5)
+RETURNING id, kind, points, note, user_id, created_at`, orgID, leadID, in.Points, in.Note, c.UserID).
+		Scan(&x.ID, &x.Kind, &x.Points, &x.Note, &x.UserID, &x.CreatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, map[string]any{"entry": x, "balance": balance + in.Points})
+}
+
+// redeemLoyaltyTx valida o resgate de points num pedido de totalCents dentro
+// da transação do pedido, com o lead travado. O movimento é gravado depois,
+// com o id do pedido (debitLoyaltyTx).
+func (a *App) redeemLoyaltyTx(ctx context.Context, tx pgx.Tx, orgID, leadID int64, points, totalCents int) (used, discountCents int, err error) {
+	s, err := a.loadLoyaltySettings(ctx, orgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	balance, err := lockLoyalty(ctx, tx, orgID, leadID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.quote(balance, points, totalCents)
+}
+
+func debitLoyaltyTx(ctx context.Context, tx pgx.Tx, orgID, leadID, orderID int64, points int) error {
+	_, err := tx.Exec(ctx, `
+INSERT INTO loyalty_ledger (org_id, lead_id, order_id, kind, points, note)
+VALUES ($1, $2, $3, 'redeem', $4, $5)`, orgID, leadID, orderID, -points, fmt.Sprintf("desconto no pedido #%d", orderID))
+	return err
+}
+
+// POST /api/orders/{id}/redeem-points {"points": 200}
+func (a *App) redeemOrderPoints(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	orderID, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	var in struct {
+		Points int `json:"points"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.Points <= 0 {
+		render.Error(w, http.StatusBadRequest, "points must be positive")
+		return
+	}
+	ctx := r.Context()
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	var (
+		leadID          int64
+		total, redeemed int
+		status          string
+	)
+	err = tx.QueryRow(ctx, `
+SELECT COALESCE(lead_id, 0), total_cents, points_redeemed, status
+  FROM orders WHERE id=$1 AND org_id=$2 AND flow_id=$3 FOR UPDATE`, orderID, orgID, flowID).
+		Scan(&leadID, &total, &redeemed, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "order not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch {
+	case status != "pending":
+		render.Error(w, http.StatusConflict, "points can only be redeemed on pending orders")
+		return
+	case leadID == 0:
+		render.Error(w, http.StatusConflict, "order has no lead")
+		return
+	case redeemed > 0:
+		render.Error(w, http.StatusConflict, "order already has a points discount")
+		return
+	}
+	used, discount, err := a.redeemLoyaltyTx(ctx, tx, orgID, leadID, in.Points, total)
+	if errors.Is(err, errLoyaltyInactive) || errors.Is(err, errLoyaltyBalance) {
+		render.Error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+UPDATE orders SET total_cents = total_cents - $2, discount_cents = discount_cents + $2, points_redeemed = $3
+ WHERE id=$1`, orderID, discount, used); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := debitLoyaltyTx(ctx, tx, orgID, leadID, orderID, used); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{
+		"order_id":        orderID,
+		"points_redeemed": used,
+		"discount_cents":  discount,
+		"total_cents":     total - discount,
+	})
+}
+
+// toolLoyaltyBalance atende a ferramenta get_loyalty_balance do agente.
+func (a *App) toolLoyaltyBalance(ctx context.Context, orgID, flowID int64, session string, call openai.ToolCall) string {
+	var args struct {
+		Phone string `json:"phone"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return toolJSON(map[string]any{"error": "invalid arguments"})
+	}
+	phone := onlyDigits(args.Phone)
+	if phone == "" && session != "" {
+		d, err := a.getOrderDraft(ctx, session, orgID, flowID)
+		if err != nil {
+			return toolError(call, err)
+		}
+		phone = d.Phone
+	}
+	if phone == "" {
+		return toolJSON(map[string]any{"error": "phone required"})
+	}
+	s, err := a.loadLoyaltySettings(ctx, orgID)
+	if err != nil {
+		return toolError(call, err)
+	}
+	if !s.Active {
+		return toolJSON(map[string]any{"active": false})
+	}
+	leadID, err := a.leadIDByPhone(ctx, orgID, flowID, phone, false)
+	if err != nil {
+		return toolError(call, err)
+	}
+	balance := 0
+	if leadID > 0 {
+		if balance, err = a.loyaltyBalance(ctx, leadID); err != nil {
+			return toolError(call, err)
+		}
+	}
+	return toolJSON(map[string]any{
+		"active":            true,
+		"points":            balance,
+		"value":             fmt.Sprintf("R$ %.2f", float64(balance*s.PointValueCents)/100),
+		"min_redeem_points": s.MinRedeemPoints,
+		"max_order_share":   fmt.Sprintf("%d%%", s.MaxRedeemBps/100),
+		"can_redeem":        balance > 0 && balance >= s.MinRedeemPoints,
+	})
+}
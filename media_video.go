@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Vídeo: limites, duração e thumbnail
+// ================================================================
+//
+// Duração e thumbnail dependem de ffprobe/ffmpeg no PATH (ou FFPROBE_PATH /
+// FFMPEG_PATH). Sem eles, a validação fica apenas no tamanho e o produto
+// segue sem thumbnail.
+//
+// Limites (WhatsApp aceita até 16 MB):
+//   VIDEO_MAX_MB       padrão 16
+//   VIDEO_MAX_SECONDS  padrão 180
+// Colunas video_url/video_thumb_url: migrations/0055_product_video.sql.
+
+var allowedVideoTypes = map[string]string{
+	"video/mp4":       ".mp4",
+	"video/3gpp":      ".3gp",
+	"video/quicktime": ".mov",
+	"video/webm":      ".webm",
+}
+
+func videoMaxBytes() int64 {
+	mb, err := strconv.ParseInt(getenv("VIDEO_MAX_MB", "16"), 10, 64)
+	if err != nil || mb <= 0 {
+		mb = 16
+	}
+	return mb << 20
+}
+
+func videoMaxSeconds() float64 {
+	s, err := strconv.ParseFloat(getenv("VIDEO_MAX_SECONDS", "180"), 64)
+	if err != nil || s <= 0 {
+		s = 180
+	}
+	return s
+}
+
+// errNoFFmpeg indica que a ferramenta não está disponível no ambiente.
+var errNoFFmpeg = errors.New("ffmpeg/ffprobe not available")
+
+// probeVideoDuration devolve a duração (segundos) via ffprobe.
+func probeVideoDuration(ctx context.Context, path string) (float64, error) {
+	bin, err := exec.LookPath(getenv("FFPROBE_PATH", "ffprobe"))
+	if err != nil {
+		return 0, errNoFFmpeg
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin,
+		"-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe: %w", err)
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
+
+// extractVideoThumb grava em dst (jpg) um quadro próximo de 1s do vídeo.
+func extractVideoThumb(ctx context.Context, src, dst string) error {
+	bin, err := exec.LookPath(getenv("FFMPEG_PATH", "ffmpeg"))
+	if err != nil {
+		return errNoFFmpeg
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin,
+		"-y", "-loglevel", "error", "-ss", "1", "-i", src,
+		"-frames:v", "1", "-vf", "scale='min(640,iw)':-2", dst)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// validateVideo confere tipo, tamanho e (se possível) duração de um arquivo local.
+func validateVideo(ctx context.Context, path, mime string, size int64) error {
+	if _, ok := allowedVideoTypes[strings.ToLower(mime)]; !ok {
+		return fmt.Errorf("unsupported video type %q", mime)
+	}
+	if size > videoMaxBytes() {
+		return fmt.Errorf("video too large (max %d MB)", videoMaxBytes()>>20)
+	}
+	dur, err := probeVideoDuration(ctx, path)
+	if errors.Is(err, errNoFFmpeg) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dur > videoMaxSeconds() {
+		return fmt.Errorf("video too long (%.0fs, max %.0fs)", dur, videoMaxSeconds())
+	}
+	return nil
+}
+
+// validateRemoteVideo faz HEAD na URL para checar tipo/tamanho e, com
+// ffprobe disponível, a duração (ffprobe lê a URL diretamente).
+func validateRemoteVideo(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid video url: %w", err)
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("video url unreachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("video url returned %d", resp.StatusCode)
+	}
+	mime := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if mime == "" || mime == "application/octet-stream" {
+		mime = videoTypeFromExt(rawURL)
+	}
+	return validateVideo(ctx, rawURL, mime, resp.ContentLength)
+}
+
+func videoTypeFromExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(strings.SplitN(name, "?", 2)[0]))
+	for mime, e := range allowedVideoTypes {
+		if e == ext {
+			return mime
+		}
+	}
+	return ""
+}
+
+// POST /api/products/{id}/video (multipart, campo "video")
+// Salva o vídeo em UPLOAD_DIR, valida tamanho/duração, passa pelo antivírus,
+// gera a thumbnail (se houver ffmpeg) e grava as URLs no produto.
+func (a *App) uploadProductVideo(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	orgID, flowID, _ := tenantOf(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, videoMaxBytes()+(1<<20))
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		render.Error(w, http.StatusRequestEntityTooLarge, "multipart parse error: "+err.Error())
+		return
+	}
+	file, header, err := r.FormFile("video")
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "video file required")
+		return
+	}
+	defer file.Close()
+
+	mime := contentTypeFromHeader(header)
+	ext, ok := allowedVideoTypes[mime]
+	if !ok {
+		if mime = videoTypeFromExt(header.Filename); mime != "" {
+			ext = allowedVideoTypes[mime]
+		}
+	}
+	if ext == "" {
+		render.Error(w, http.StatusUnsupportedMediaType, "unsupported video type")
+		return
+	}
+
+	uploadDir := getenv("UPLOAD_DIR", "uploads")
+	if err := os.MkdirAll(uploadDir, 0o755); err != nil {
+		render.Error(w, http.StatusInternalServerError, "cannot create upload dir: "+err.Error())
+		return
+	}
+	base := strconv.FormatInt(time.Now().UnixNano(), 10)
+	dst := filepath.Join(uploadDir, base+ext)
+	out, err := os.Create(dst)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, "cannot save file: "+err.Error())
+		return
+	}
+	size, err := io.Copy(out, file)
+	out.Close()
+	if err != nil {
+		_ = os.Remove(dst)
+		render.Error(w, http.StatusInternalServerError, "write file error: "+err.Error())
+		return
+	}
+
+	if err := validateVideo(r.Context(), dst, mime, size); err != nil {
+		_ = os.Remove(dst)
+		render.Error(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	scan := a.scanFile(r.Context(), orgID, "video", dst)
+	if !scan.Accepted() {
+		render.APIError(w, http.StatusUnprocessableEntity, "file_rejected", "file rejected by antivirus", map[string]any{"scan": scan})
+		return
+	}
+
+	// a miniatura sai do arquivo local, antes de publicar no armazenamento
+	thumbPath := filepath.Join(uploadDir, base+"_thumb.jpg")
+	hasThumb := false
+	if err := extractVideoThumb(r.Context(), dst, thumbPath); err == nil {
+		hasThumb = true
+	} else if !errors.Is(err, errNoFFmpeg) {
+		log.Printf("video thumb %s: %v", dst, err)
+	}
+	videoURL, err := storeUpload(r.Context(), r, dst, mime)
+	if err != nil {
+		render.Error(w, http.StatusBadGateway, "storage error: "+err.Error())
+		return
+	}
+	thumbURL := ""
+	if hasThumb {
+		if thumbURL, err = storeUpload(r.Context(), r, thumbPath, "image/jpeg"); err != nil {
+			log.Printf("video thumb store %s: %v", thumbPath, err)
+			thumbURL = ""
+		}
+	}
+
+	tag, err := a.DB.Exec(r.Context(),
+		`UPDATE products SET video_url=$1, video_thumb_url=NULLIF($2,'') WHERE id=$3 AND org_id=$4`,
+		videoURL, thumbURL, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		_ = os.Remove(dst)
+		render.Error(w, http.StatusNotFound, "product not found")
+		return
+	}
+	a.touchProductFeed(orgID, flowID)
+	render.OK(w, map[string]any{"video_url": videoURL, "video_thumb_url": thumbURL, "size": size, "scan": scan})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  WhatsApp Cloud API (Meta) — instâncias "meta_cloud"
+// ================================================================
+//
+// Instâncias com provider='meta_cloud' não passam pela uazapi: os envios vão
+// para POST {META_GRAPH_URL}/{phone_number_id}/messages com o token da WABA.
+// Cadastro: POST /api/wa/instances/meta {name, waba_id, phone_number_id, access_token}.
+
+const waProviderMetaCloud = "meta_cloud"
+
+func metaGraphBase() string {
+	return strings.TrimRight(getenv("META_GRAPH_URL", "https://graph.facebook.com/v19.0"), "/")
+}
+
+// metaGraphError é o erro estruturado devolvido pela Graph API.
+type metaGraphError struct {
+	Status  int
+	Code    int    `json:"code"`
+	Subcode int    `json:"error_subcode"`
+	Message string `json:"message"`
+}
+
+func (e *metaGraphError) Error() string {
+	return fmt.Sprintf("graph api %d (code %d): %s", e.Status, e.Code, e.Message)
+}
+
+// metaGraphDo chama a Graph API e decodifica a resposta em out (se != nil).
+func metaGraphDo(ctx context.Context, method, path, token string, body, out any) error {
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rdr = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, metaGraphBase()+path, rdr)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := (&http.Client{Timeout: 60 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 400 {
+		var e struct {
+			Error metaGraphError `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		e.Error.Status = resp.StatusCode
+		if e.Error.Message == "" {
+			e.Error.Message = strings.TrimSpace(string(raw))
+		}
+		return &e.Error
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// metaCloudMessage converte o corpo de envio no formato uazapi (to, text,
+// url, caption, type, filename, template) para o payload da Cloud API.
+func metaCloudMessage(suffix string, body map[string]any) (map[string]any, error) {
+	to := onlyDigits(fmt.Sprint(body["to"]))
+	if to == "" {
+		return nil, errors.New("missing to")
+	}
+	msg := map[string]any{"messaging_product": "whatsapp", "recipient_type": "individual", "to": to}
+	str := func(k string) string {
+		s, _ := body[k].(string)
+		return s
+	}
+	switch suffix {
+	case "/send/text":
+		msg["type"] = "text"
+		msg["text"] = map[string]any{"body": str("text"), "preview_url": true}
+	case "/send/video", "/send/media":
+		typ := str("type")
+		if suffix == "/send/video" {
+			typ = "video"
+		}
+		media := map[string]any{"link": str("url")}
+		if c := str("caption"); c != "" {
+			media["caption"] = c
+		}
+		if f := str("filename"); f != "" {
+			media["filename"] = f
+		}
+		msg["type"] = typ
+		msg[typ] = media
+	case "/send/template":
+		msg["type"] = "template"
+		msg["template"] = body["template"]
+	default:
+		return nil, fmt.Errorf("unsupported send %s on meta cloud", suffix)
+	}
+	return msg, nil
+}
+
+// metaCloudSend envia pela Cloud API e devolve {ok, id} como os demais envios.
+func (app *App) metaCloudSend(ctx context.Context, row waInstanceRow, suffix string, body map[string]any) (map[string]any, int, error) {
+	if row.MetaPhoneID == "" || row.MetaToken == "" {
+		return nil, http.StatusServiceUnavailable, errors.New("meta cloud instance without phone_number_id/access_token")
+	}
+	msg, err := metaCloudMessage(suffix, body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	to := fmt.Sprint(body["to"])
+	if isFreeFormSend(suffix) {
+		if err := app.checkServiceWindow(ctx, row, to); err != nil {
+			return nil, http.StatusConflict, err
+		}
+	}
+	var resp struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	err = metaGraphDo(ctx, http.MethodPost, "/"+row.MetaPhoneID+"/messages", row.MetaToken, msg, &resp)
+	var ge *metaGraphError
+	if errors.As(err, &ge) && ge.Code == metaErrOutsideWindow {
+		last, _ := app.lastInbound(ctx, row.InstanceID, to)
+		return nil, http.StatusConflict, app.outsideWindowError(ctx, row, to, last)
+	}
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	out := map[string]any{"ok": true, "provider": waProviderMetaCloud}
+	if len(resp.Messages) > 0 {
+		out["id"] = resp.Messages[0].ID
+	}
+	return out, http.StatusOK, nil
+}
+
+// POST /api/wa/instances/meta
+func (app *App) waCreateMetaInstance(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name          string `json:"name"`
+		WABAID        string `json:"waba_id"`
+		PhoneNumberID string `json:"phone_number_id"`
+		AccessToken   string `json:"access_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.WABAID, in.PhoneNumberID = strings.TrimSpace(in.WABAID), strings.TrimSpace(in.PhoneNumberID)
+	if in.WABAID == "" || in.PhoneNumberID == "" || strings.TrimSpace(in.AccessToken) == "" {
+		render.Error(w, http.StatusBadRequest, "waba_id, phone_number_id and access_token required")
+		return
+	}
+	// org/flow do JWT (requireRole); os headers só valem se baterem com ele
+	orgID, flowID, _ := tenantOf(r)
+
+	// valida as credenciais antes de gravar
+	var phone struct {
+		DisplayPhoneNumber string `json:"display_phone_number"`
+		VerifiedName       string `json:"verified_name"`
+	}
+	if err := metaGraphDo(r.Context(), http.MethodGet, "/"+in.PhoneNumberID+"?fields=display_phone_number,verified_name", in.AccessToken, nil, &phone); err != nil {
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	instanceID := "meta-" + in.PhoneNumberID
+	token := secureToken(24)
+	_, err := app.DB.Exec(r.Context(), `
+INSERT INTO public.wa_instances (instance_id, token, org_id, flow_id, provider, meta_waba_id, meta_phone_number_id, meta_access_token, state, state_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'connected', NOW())
+ON CONFLICT (instance_id) DO UPDATE SET
+  org_id=EXCLUDED.org_id, flow_id=EXCLUDED.flow_id, provider=EXCLUDED.provider,
+  meta_waba_id=EXCLUDED.meta_waba_id, meta_access_token=EXCLUDED.meta_access_token,
+  state='connected', state_at=NOW(), deleted_at=NULL, updated_at=NOW()`,
+		instanceID, token, orgID, flowID, waProviderMetaCloud, in.WABAID, in.PhoneNumberID, in.AccessToken)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	row, err := app.fetchWAInstance(r.Context(), instanceID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{
+		"instanceId":   instanceID,
+		"token":        row.Token,
+		"provider":     waProviderMetaCloud,
+		"name":         chooseFirstNonEmpty(in.Name, phone.VerifiedName),
+		"phone_number": phone.DisplayPhoneNumber,
+		"status":       "connected",
+	})
+}
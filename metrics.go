@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/paclead/backend/llm"
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Métricas Prometheus (GET /metrics)
+// ================================================================
+//
+// Formato texto 0.0.4, sem dependência externa. Séries:
+//
+//   paclead_http_requests_total{method,route,status,org}
+//   paclead_http_request_duration_seconds{method,route,org}      (histograma)
+//   paclead_db_pool_*                                           (pgxpool.Stat)
+//   paclead_llm_request_duration_seconds{provider,org}          (histograma)
+//   paclead_llm_errors_total{provider,org}
+//   paclead_uazapi_requests_total{op,status}
+//   paclead_uazapi_errors_total{op,org}   (erro de transporte ou 5xx)
+//   paclead_rate_limited_total{limit}     (429 do ratelimit.go)
+//   paclead_db_slow_queries_total{fingerprint}  (db_trace.go)
+//
+// route é o padrão do chi ("/api/products/{id}"), nunca o path cru; org fica
+// vazio quando a rota não resolve tenant (webhooks, rotas públicas).
+//
+// Acesso: METRICS_IP_ALLOWLIST e, se definido, METRICS_TOKEN como Bearer.
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type metricSeries struct {
+	labels  []string
+	value   float64  // contador
+	buckets []uint64 // histograma: contagem por faixa (não cumulativa)
+	sum     float64  // histograma
+	count   uint64   // histograma
+}
+
+// metricVec é um contador ou histograma com labels.
+type metricVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // nil = contador
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, labels: labels, series: map[string]*metricSeries{}}
+}
+
+func newHistogram(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, labels: labels, buckets: defaultBuckets, series: map[string]*metricSeries{}}
+}
+
+// size é o número de séries (combinações de labels) em memória.
+func (m *metricVec) size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.series)
+}
+
+func (m *metricVec) get(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s := m.series[key]
+	if s == nil {
+		s = &metricSeries{labels: values}
+		if m.buckets != nil {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) inc(values ...string) {
+	m.mu.Lock()
+	m.get(values).value++
+	m.mu.Unlock()
+}
+
+func (m *metricVec) observe(v float64, values ...string) {
+	m.mu.Lock()
+	s := m.get(values)
+	for i, b := range m.buckets {
+		if v <= b {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+	m.mu.Unlock()
+}
+
+func (m *metricVec) write(b *strings.Builder) {
+	kind := "counter"
+	if m.buckets != nil {
+		kind = "histogram"
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, kind)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		lbl := labelPairs(m.labels, s.labels)
+		if m.buckets == nil {
+			fmt.Fprintf(b, "%s%s %s\n", m.name, wrapLabels(lbl), formatFloat(s.value))
+			continue
+		}
+		var cum uint64
+		for i, le := range m.buckets {
+			cum += s.buckets[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, wrapLabels(append(lbl, `le="`+formatFloat(le)+`"`)), cum)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, wrapLabels(append(lbl, `le="+Inf"`)), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", m.name, wrapLabels(lbl), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, wrapLabels(lbl), s.count)
+	}
+}
+
+func labelPairs(names, values []string) []string {
+	out := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		out = append(out, n+`="`+escapeLabel(values[i])+`"`)
+	}
+	return out
+}
+
+func wrapLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	httpRequests = newCounter("paclead_http_requests_total",
+		"HTTP requests by route, status and org.", "method", "route", "status", "org")
+	httpDuration = newHistogram("paclead_http_request_duration_seconds",
+		"HTTP request latency by route and org.", "method", "route", "org")
+	llmDuration = newHistogram("paclead_llm_request_duration_seconds",
+		"LLM chat call latency by provider and org.", "provider", "org")
+	llmErrors = newCounter("paclead_llm_errors_total",
+		"Failed LLM chat calls by provider and org.", "provider", "org")
+	uazapiRequests = newCounter("paclead_uazapi_requests_total",
+		"Calls to the WhatsApp provider by operation and status.", "op", "status")
+	uazapiErrors = newCounter("paclead_uazapi_errors_total",
+		"Provider calls that failed (transport error or 5xx) by operation and org.", "op", "org")
+	rateLimited = newCounter("paclead_rate_limited_total",
+		"Requests rejected with 429 by rate limit.", "limit")
+)
+
+// ---------------- org da requisição ----------------
+
+// metricsReq é preenchido ao longo da requisição: o tenant é resolvido em
+// middlewares internos (tenant_context.go), depois do middleware de métricas.
+type metricsReq struct{ org int64 }
+
+type metricsCtxKey struct{}
+
+func setMetricsOrg(ctx context.Context, org int64) {
+	if m, ok := ctx.Value(metricsCtxKey{}).(*metricsReq); ok && org > 0 {
+		m.org = org
+	}
+}
+
+// metricsOrg: org resolvida no contexto (tenant ou anotação do middleware).
+func metricsOrg(ctx context.Context) string {
+	if t, ok := tenantFrom(ctx); ok && t.OrgID > 0 {
+		return strconv.FormatInt(t.OrgID, 10)
+	}
+	if m, ok := ctx.Value(metricsCtxKey{}).(*metricsReq); ok && m.org > 0 {
+		return strconv.FormatInt(m.org, 10)
+	}
+	return ""
+}
+
+// metricsMiddleware mede cada requisição pelo padrão de rota do chi.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		mr := &metricsReq{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ctx := context.WithValue(r.Context(), metricsCtxKey{}, mr)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := "unmatched"
+		if rc := chi.RouteContext(ctx); rc != nil && rc.RoutePattern() != "" {
+			route = rc.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		org := ""
+		if mr.org > 0 {
+			org = strconv.FormatInt(mr.org, 10)
+		}
+		httpRequests.inc(r.Method, route, strconv.Itoa(status), org)
+		httpDuration.observe(time.Since(start).Seconds(), r.Method, route, org)
+	})
+}
+
+// ---------------- LLM e provedor de WhatsApp ----------------
+
+// meteredLLM mede as chamadas de um provedor de LLM para a org.
+type meteredLLM struct {
+	llm.Provider
+	org   string
+	orgID int64
+}
+
+func meterLLM(p llm.Provider, orgID int64) llm.Provider {
+	org := ""
+	if orgID > 0 {
+		org = strconv.FormatInt(orgID, 10)
+	}
+	return meteredLLM{Provider: p, org: org, orgID: orgID}
+}
+
+func (m meteredLLM) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	start := time.Now()
+	resp, err := m.Provider.Chat(ctx, req)
+	m.record(ctx, start, err)
+	return resp, err
+}
+
+// ChatStream mede até a abertura do stream (tempo até o primeiro byte).
+func (m meteredLLM) ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (llm.Stream, error) {
+	start := time.Now()
+	s, err := m.Provider.ChatStream(ctx, req)
+	m.record(ctx, start, err)
+	return s, err
+}
+
+func (m meteredLLM) record(ctx context.Context, start time.Time, err error) {
+	llmDuration.observe(time.Since(start).Seconds(), m.Name(), m.org)
+	if err != nil {
+		llmErrors.inc(m.Name(), m.org)
+		reportError(ctx, "llm", err, map[string]string{"provider": m.Name(), "org": m.org}) // error_reporting.go
+	}
+	observeLLM(ctx, m.orgID, err) // ai_fallback.go
+}
+
+func observeUazapi(ctx context.Context, op string, status int, err error, d time.Duration) {
+	uazapiRequests.inc(op, strconv.Itoa(status))
+	if err != nil || status >= 500 {
+		uazapiErrors.inc(op, metricsOrg(ctx))
+		if err == nil {
+			err = fmt.Errorf("provider status %d", status)
+		}
+		reportError(ctx, "uazapi", err, map[string]string{"op": op, "status": strconv.Itoa(status)}) // error_reporting.go
+	}
+}
+
+// ---------------- endpoint ----------------
+
+func (a *App) mountMetrics(r chi.Router) {
+	waprovider.Observe = observeUazapi
+	r.With(a.ipAllowlist("metrics", "METRICS_IP_ALLOWLIST")).Get("/metrics", a.metricsHandler)
+}
+
+// GET /metrics
+func (a *App) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if tok := os.Getenv("METRICS_TOKEN"); tok != "" {
+		got := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if subtle.ConstantTimeCompare([]byte(got), []byte(tok)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			render.Error(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	var b strings.Builder
+	for _, m := range []*metricVec{httpRequests, httpDuration, llmDuration, llmErrors, uazapiRequests, uazapiErrors, rateLimited, dbSlowQueries} {
+		m.write(&b)
+	}
+	writeConcurrencyMetrics(&b) // concurrency_limit.go
+	a.writeDBPoolMetrics(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func (a *App) writeDBPoolMetrics(b *strings.Builder) {
+	if a.DB == nil {
+		return
+	}
+	st := a.DB.Stat()
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	counter := func(name, help string, v float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, formatFloat(v))
+	}
+	gauge("paclead_db_pool_total_conns", "Open connections in the pool.", float64(st.TotalConns()))
+	gauge("paclead_db_pool_idle_conns", "Idle connections.", float64(st.IdleConns()))
+	gauge("paclead_db_pool_acquired_conns", "Connections in use.", float64(st.AcquiredConns()))
+	gauge("paclead_db_pool_constructing_conns", "Connections being opened.", float64(st.ConstructingConns()))
+	gauge("paclead_db_pool_max_conns", "Pool size limit.", float64(st.MaxConns()))
+	counter("paclead_db_pool_acquire_total", "Connection acquisitions.", float64(st.AcquireCount()))
+	counter("paclead_db_pool_empty_acquire_total", "Acquisitions that had to wait for a connection.", float64(st.EmptyAcquireCount()))
+	counter("paclead_db_pool_canceled_acquire_total", "Acquisitions canceled by the caller.", float64(st.CanceledAcquireCount()))
+	counter("paclead_db_pool_acquire_duration_seconds_total", "Time spent acquiring connections.", st.AcquireDuration().Seconds())
+}
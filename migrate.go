@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ================================================================
+//  Migrações versionadas
+// ================================================================
+//
+// Arquivos migrations/NNNN_descricao.sql (embutidos no binário) são
+// aplicados em ordem, cada um na sua transação, e registrados em
+// schema_migrations. Um advisory lock impede que réplicas subindo juntas
+// apliquem a mesma versão duas vezes.
+//
+//   api migrate [up]   aplica as pendentes e sai
+//   api migrate status lista aplicadas/pendentes
+//
+// Na subida do servidor as pendentes são aplicadas automaticamente, a menos
+// que MIGRATE_ON_START=false (ex.: quando o deploy roda "migrate" antes).
+//
+// Nunca edite uma migração já aplicada: crie uma nova. O checksum gravado
+// acusa arquivos alterados no status e na subida.
+//
+// Aplicações, falhas e checksums alterados também vão para schema_changes,
+// ligados ao deploy que os viu (schema_audit.go).
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// chave arbitrária do pg_advisory_lock das migrações
+const migrationLockKey = 727100
+
+type migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var out []migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		num, _, ok := strings.Cut(name, "_")
+		v, err := strconv.Atoi(num)
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_description.sql", name)
+		}
+		if prev, dup := seen[v]; dup {
+			return nil, fmt.Errorf("migration version %d duplicated (%s, %s)", v, prev, name)
+		}
+		seen[v] = name
+		b, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		out = append(out, migration{
+			Version:  v,
+			Name:     strings.TrimSuffix(name, ".sql"),
+			SQL:      string(b),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func ensureMigrationsTable(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS public.schema_migrations (
+  version    INTEGER PRIMARY KEY,
+  name       TEXT NOT NULL,
+  checksum   TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`)
+	return err
+}
+
+// appliedMigrations devolve versão -> checksum das migrações já aplicadas.
+func appliedMigrations(ctx context.Context, db *pgxpool.Pool) (map[int]string, error) {
+	rows, err := db.Query(ctx, `SELECT version, checksum FROM public.schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]string{}
+	for rows.Next() {
+		var v int
+		var sum string
+		if err := rows.Scan(&v, &sum); err != nil {
+			return nil, err
+		}
+		out[v] = sum
+	}
+	return out, rows.Err()
+}
+
+// runMigrations aplica as migrações pendentes e devolve quantas foram aplicadas.
+func runMigrations(ctx context.Context, db *pgxpool.Pool) (int, error) {
+	migs, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationsTable(ctx, db); err != nil {
+		return 0, err
+	}
+
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return 0, err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	// relido sob o lock: outra réplica pode ter acabado de aplicar
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	n, latest := 0, 0
+	defer func() { finishDeploymentSchema(ctx, db, latest) }()
+	for _, m := range migs {
+		version := m.Version
+		if sum, ok := applied[m.Version]; ok {
+			latest = m.Version
+			if sum != m.Checksum {
+				log.Printf("migrate: %s changed after being applied (checksum mismatch)", m.Name)
+				recordSchemaChange(ctx, db, schemaChange{Kind: schemaChangeMigration, Name: m.Name, Version: &version, Checksum: m.Checksum, Status: "modified"})
+			}
+			continue
+		}
+		start := time.Now()
+		failed := func(err error) error {
+			recordSchemaChange(ctx, db, schemaChange{Kind: schemaChangeMigration, Name: m.Name, Version: &version, Checksum: m.Checksum,
+				Status: "failed", Error: err.Error(), DurationMS: time.Since(start).Milliseconds()})
+			return err
+		}
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return n, err
+		}
+		if _, err := tx.Exec(ctx, `SET LOCAL search_path TO public`); err != nil {
+			_ = tx.Rollback(ctx)
+			return n, err
+		}
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			_ = tx.Rollback(ctx)
+			return n, failed(fmt.Errorf("%s: %w", m.Name, err))
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO public.schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, m.Checksum); err != nil {
+			_ = tx.Rollback(ctx)
+			return n, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return n, failed(fmt.Errorf("%s: %w", m.Name, err))
+		}
+		log.Printf("migrate: applied %s (%s)", m.Name, time.Since(start).Round(time.Millisecond))
+		recordSchemaChange(ctx, db, schemaChange{Kind: schemaChangeMigration, Name: m.Name, Version: &version, Checksum: m.Checksum,
+			Status: "applied", DurationMS: time.Since(start).Milliseconds()})
+		latest = m.Version
+		n++
+	}
+	return n, nil
+}
+
+// runMigrateCommand implementa o subcomando "migrate [up|status]".
+func runMigrateCommand(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		n, err := runMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		log.Printf("migrate: %d migration(s) applied", n)
+		return nil
+	case "status":
+		migs, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		if err := ensureMigrationsTable(ctx, db); err != nil {
+			return err
+		}
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, m := range migs {
+			state := "pending"
+			if sum, ok := applied[m.Version]; ok {
+				state = "applied"
+				if sum != m.Checksum {
+					state = "applied (modified!)"
+				}
+			}
+			fmt.Printf("%04d  %-40s %s\n", m.Version, m.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q (use up or status)", cmd)
+	}
+}
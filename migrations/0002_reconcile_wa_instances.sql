@@ -0,0 +1,37 @@
+-- Unifica wa_instances: bancos criados pelo antigo ensureSchema têm id
+-- BIGSERIAL como PK e colunas status/jid/logged_in que nunca foram usadas;
+-- os criados por ensureWhatsAppTables usam instance_id como PK. O formato
+-- canônico é o segundo, mais o estado da conexão e o controle do webhook.
+
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS webhook_url TEXT;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS state TEXT;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS state_at TIMESTAMPTZ;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS webhook_test_nonce TEXT;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS webhook_verified_at TIMESTAMPTZ;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS webhook_error TEXT;
+
+DO $$ BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_schema='public' AND table_name='wa_instances' AND column_name='id'
+  ) THEN
+    -- aproveita o status antigo como estado inicial
+    EXECUTE 'UPDATE public.wa_instances SET state = status WHERE state IS NULL AND status IS NOT NULL';
+    EXECUTE 'ALTER TABLE public.wa_instances DROP CONSTRAINT IF EXISTS wa_instances_pkey';
+    EXECUTE 'ALTER TABLE public.wa_instances DROP COLUMN id';
+    EXECUTE 'DROP INDEX IF EXISTS public.uq_wa_instances_instance_id';
+    EXECUTE 'ALTER TABLE public.wa_instances ADD PRIMARY KEY (instance_id)';
+  END IF;
+END $$;
+
+ALTER TABLE public.wa_instances DROP COLUMN IF EXISTS status;
+ALTER TABLE public.wa_instances DROP COLUMN IF EXISTS jid;
+ALTER TABLE public.wa_instances DROP COLUMN IF EXISTS logged_in;
+
+CREATE INDEX IF NOT EXISTS idx_wa_instances_org_flow ON public.wa_instances(org_id, flow_id);
+
+-- webhooks_log também divergia (org/flow/event só existiam no ensureSchema)
+ALTER TABLE public.webhooks_log ADD COLUMN IF NOT EXISTS org_id BIGINT;
+ALTER TABLE public.webhooks_log ADD COLUMN IF NOT EXISTS flow_id BIGINT;
+ALTER TABLE public.webhooks_log ADD COLUMN IF NOT EXISTS event TEXT;
@@ -0,0 +1,17 @@
+-- Histórico de etapas do funil de leads (POST /api/leads/{id}/stage).
+
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS stage_changed_at TIMESTAMPTZ;
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS public.lead_stage_changes (
+  id          BIGSERIAL PRIMARY KEY,
+  org_id      BIGINT NOT NULL,
+  flow_id     BIGINT NOT NULL,
+  lead_id     BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  from_stage  TEXT,
+  to_stage    TEXT NOT NULL,
+  changed_by  BIGINT,
+  changed_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_lead_stage_changes_lead ON public.lead_stage_changes (lead_id, changed_at);
+CREATE INDEX IF NOT EXISTS idx_lead_stage_changes_org_flow ON public.lead_stage_changes (org_id, flow_id, changed_at);
@@ -0,0 +1,24 @@
+-- Instâncias da API oficial (Meta Cloud API) e templates de mensagem.
+
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT 'uazapi';
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS meta_waba_id TEXT;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS meta_phone_number_id TEXT;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS meta_access_token TEXT;
+
+CREATE TABLE IF NOT EXISTS public.wa_templates (
+  id               BIGSERIAL PRIMARY KEY,
+  org_id           BIGINT NOT NULL,
+  flow_id          BIGINT NOT NULL,
+  instance_id      TEXT NOT NULL REFERENCES public.wa_instances(instance_id) ON DELETE CASCADE,
+  name             TEXT NOT NULL,
+  language         TEXT NOT NULL,
+  category         TEXT NOT NULL,
+  components       JSONB NOT NULL DEFAULT '[]',
+  meta_template_id TEXT,
+  status           TEXT NOT NULL DEFAULT 'PENDING',
+  rejected_reason  TEXT,
+  submitted_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (instance_id, name, language)
+);
+CREATE INDEX IF NOT EXISTS idx_wa_templates_status ON public.wa_templates (status) WHERE status = 'PENDING';
@@ -0,0 +1,12 @@
+-- Última mensagem recebida de cada contato por instância: base da janela de
+-- atendimento de 24h da API oficial.
+
+CREATE TABLE IF NOT EXISTS public.wa_contact_windows (
+  instance_id     TEXT NOT NULL REFERENCES public.wa_instances(instance_id) ON DELETE CASCADE,
+  contact         TEXT NOT NULL,
+  org_id          BIGINT NOT NULL,
+  flow_id         BIGINT NOT NULL,
+  last_inbound_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (instance_id, contact)
+);
+CREATE INDEX IF NOT EXISTS idx_wa_contact_windows_org_flow ON public.wa_contact_windows (org_id, flow_id, last_inbound_at DESC);
@@ -0,0 +1,21 @@
+-- Inbox próprio: conversas por instância + contato e mensagens ligadas a elas.
+
+ALTER TABLE public.conversations ADD COLUMN IF NOT EXISTS instance_id TEXT;
+ALTER TABLE public.conversations ADD COLUMN IF NOT EXISTS contact TEXT;
+ALTER TABLE public.conversations ADD COLUMN IF NOT EXISTS last_message_at TIMESTAMPTZ;
+ALTER TABLE public.conversations ADD COLUMN IF NOT EXISTS unread INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE public.conversations ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE UNIQUE INDEX IF NOT EXISTS uq_conversations_instance_contact
+  ON public.conversations (instance_id, contact) WHERE instance_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_conversations_org_flow_last
+  ON public.conversations (org_id, flow_id, last_message_at DESC);
+
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS conversation_id BIGINT REFERENCES public.conversations(id) ON DELETE SET NULL;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS lead_id BIGINT;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS message_id TEXT;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS msg_type TEXT;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS body TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_wa_messages_instance_msg
+  ON public.wa_messages (instance_id, message_id) WHERE message_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_wa_messages_conversation
+  ON public.wa_messages (conversation_id, created_at);
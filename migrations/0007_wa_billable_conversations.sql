@@ -0,0 +1,14 @@
+-- Conversas cobradas pela Meta (API oficial), a partir dos webhooks de status
+-- (conversation.id + pricing.category). Base do estimador de custos.
+
+CREATE TABLE IF NOT EXISTS public.wa_billable_conversations (
+  conversation_id TEXT PRIMARY KEY,
+  org_id          BIGINT NOT NULL,
+  flow_id         BIGINT NOT NULL,
+  instance_id     TEXT NOT NULL,
+  contact         TEXT,
+  category        TEXT NOT NULL,
+  billable        BOOLEAN NOT NULL DEFAULT TRUE,
+  opened_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_wa_billable_conv_org_opened ON public.wa_billable_conversations (org_id, opened_at);
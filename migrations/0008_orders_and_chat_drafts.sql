@@ -0,0 +1,44 @@
+-- Pedidos: as tabelas eram criadas fora do repositório; IF NOT EXISTS mantém
+-- as bases antigas intactas.
+CREATE TABLE IF NOT EXISTS public.orders (
+  id          BIGSERIAL PRIMARY KEY,
+  org_id      BIGINT NOT NULL,
+  flow_id     BIGINT NOT NULL,
+  lead_id     BIGINT,
+  total_cents INTEGER NOT NULL DEFAULT 0,
+  status      TEXT NOT NULL DEFAULT 'pending',
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_orders_org_flow_created ON public.orders (org_id, flow_id, created_at);
+
+CREATE TABLE IF NOT EXISTS public.order_items (
+  id               BIGSERIAL PRIMARY KEY,
+  org_id           BIGINT NOT NULL,
+  flow_id          BIGINT NOT NULL,
+  order_id         BIGINT NOT NULL REFERENCES public.orders(id) ON DELETE CASCADE,
+  product_id       BIGINT NOT NULL,
+  qty              INTEGER NOT NULL,
+  unit_price_cents INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_order_items_order ON public.order_items (order_id);
+
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS shipping_address TEXT;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS payment_method TEXT;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS source TEXT;
+
+-- Rascunho de pedido montado pelo agente no chat (um por sessão).
+CREATE TABLE IF NOT EXISTS public.chat_order_drafts (
+  session_id     TEXT NOT NULL,
+  org_id         BIGINT NOT NULL,
+  flow_id        BIGINT NOT NULL,
+  items          JSONB NOT NULL DEFAULT '[]',
+  customer_name  TEXT,
+  phone          TEXT,
+  address        TEXT,
+  payment_method TEXT,
+  status         TEXT NOT NULL DEFAULT 'open', -- open | awaiting_confirmation
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at     TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (session_id, org_id, flow_id)
+);
+CREATE INDEX IF NOT EXISTS idx_chat_order_drafts_expires ON public.chat_order_drafts (expires_at);
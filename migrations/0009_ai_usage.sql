@@ -0,0 +1,21 @@
+-- Consumo da OpenAI por chamada e orçamento mensal por org.
+
+CREATE TABLE IF NOT EXISTS public.ai_usage (
+  id                BIGSERIAL PRIMARY KEY,
+  org_id            BIGINT NOT NULL,
+  flow_id           BIGINT NOT NULL DEFAULT 0,
+  feature           TEXT NOT NULL,           -- chat | chat_ws | vision | campaign
+  model             TEXT NOT NULL,
+  prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+  completion_tokens INTEGER NOT NULL DEFAULT 0,
+  cost_usd          NUMERIC(12,6) NOT NULL DEFAULT 0,
+  created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_ai_usage_org_created ON public.ai_usage (org_id, created_at);
+
+-- Sobrescreve AI_MONTHLY_BUDGET_USD para a org (0 = sem limite).
+CREATE TABLE IF NOT EXISTS public.ai_budgets (
+  org_id      BIGINT PRIMARY KEY,
+  monthly_usd NUMERIC(12,2) NOT NULL,
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
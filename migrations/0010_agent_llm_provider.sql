@@ -0,0 +1,19 @@
+-- Provedor/modelo de IA por org/flow (vazio = LLM_PROVIDER do ambiente).
+-- agent_settings existia fora das migrações; criada aqui se faltar.
+
+CREATE TABLE IF NOT EXISTS public.agent_settings (
+  org_id              BIGINT NOT NULL,
+  flow_id             BIGINT NOT NULL,
+  name                TEXT,
+  communication_style TEXT,
+  sector              TEXT,
+  profile_type        TEXT,
+  profile_custom      TEXT,
+  base_prompt         TEXT,
+  tax_id              TEXT,
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (org_id, flow_id)
+);
+
+ALTER TABLE public.agent_settings ADD COLUMN IF NOT EXISTS llm_provider TEXT;
+ALTER TABLE public.agent_settings ADD COLUMN IF NOT EXISTS llm_model TEXT;
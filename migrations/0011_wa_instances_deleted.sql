@@ -0,0 +1,5 @@
+-- Instâncias removidas (DELETE /api/wa/instances/{instance}) ficam marcadas
+-- em vez de apagadas: o histórico de mensagens/conversas continua ligado a
+-- elas e os webhooks que ainda chegarem são descartados.
+
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
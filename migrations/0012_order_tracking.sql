@@ -0,0 +1,25 @@
+-- Rastreamento de entregas: código por pedido, histórico de eventos e
+-- opt-out do lead para os avisos no WhatsApp.
+
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS carrier TEXT;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS tracking_code TEXT;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS tracking_status TEXT;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS tracking_checked_at TIMESTAMPTZ;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS tracking_notify BOOLEAN NOT NULL DEFAULT TRUE;
+CREATE INDEX IF NOT EXISTS idx_orders_tracking_pending
+  ON public.orders (tracking_checked_at) WHERE tracking_code IS NOT NULL AND delivered_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS public.order_tracking_events (
+  id          BIGSERIAL PRIMARY KEY,
+  order_id    BIGINT NOT NULL REFERENCES public.orders(id) ON DELETE CASCADE,
+  code        TEXT NOT NULL,
+  description TEXT NOT NULL,
+  location    TEXT,
+  event_at    TIMESTAMPTZ NOT NULL,
+  notified_at TIMESTAMPTZ,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (order_id, code, event_at)
+);
+
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS tracking_opt_out_at TIMESTAMPTZ;
@@ -0,0 +1,25 @@
+-- Biblioteca de personas do agente por org. Uma persona pode ser atribuída a
+-- um flow (agent_settings.persona_id) ou a uma instância de WhatsApp
+-- (wa_instances.persona_id, tem precedência sobre a do flow).
+
+CREATE TABLE IF NOT EXISTS public.agent_personas (
+  id                  BIGSERIAL PRIMARY KEY,
+  org_id              BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  name                TEXT NOT NULL,
+  communication_style TEXT,
+  sector              TEXT,
+  profile_type        TEXT,
+  profile_custom      TEXT,
+  base_prompt         TEXT,
+  llm_provider        TEXT,
+  llm_model           TEXT,
+  cloned_from         BIGINT REFERENCES public.agent_personas(id) ON DELETE SET NULL,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_agent_personas_org_name ON public.agent_personas (org_id, lower(name));
+
+ALTER TABLE public.agent_settings ADD COLUMN IF NOT EXISTS persona_id BIGINT
+  REFERENCES public.agent_personas(id) ON DELETE SET NULL;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS persona_id BIGINT
+  REFERENCES public.agent_personas(id) ON DELETE SET NULL;
@@ -0,0 +1,12 @@
+-- Papéis por org: owner > admin > agent. Os usuários existentes eram os
+-- donos das orgs que registraram, então entram como owner; novos membros
+-- convidados entram como agent.
+
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'owner';
+ALTER TABLE public.users ALTER COLUMN role SET DEFAULT 'agent';
+DO $$ BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_check') THEN
+    ALTER TABLE public.users ADD CONSTRAINT users_role_check CHECK (role IN ('owner','admin','agent'));
+  END IF;
+END $$;
+CREATE INDEX IF NOT EXISTS idx_users_org ON public.users (org_id);
@@ -0,0 +1,34 @@
+-- Webhooks de saída por org: assinaturas e fila de entregas. Entregas que
+-- esgotam as tentativas ficam com status 'dead' (dead-letter) para consulta
+-- e reenvio manual.
+
+CREATE TABLE IF NOT EXISTS public.webhook_subscriptions (
+  id          BIGSERIAL PRIMARY KEY,
+  org_id      BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  url         TEXT NOT NULL,
+  events      TEXT[] NOT NULL,
+  secret      TEXT NOT NULL,
+  description TEXT,
+  active      BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_org ON public.webhook_subscriptions (org_id) WHERE active;
+
+CREATE TABLE IF NOT EXISTS public.webhook_deliveries (
+  id              BIGSERIAL PRIMARY KEY,
+  subscription_id BIGINT NOT NULL REFERENCES public.webhook_subscriptions(id) ON DELETE CASCADE,
+  org_id          BIGINT NOT NULL,
+  event           TEXT NOT NULL,
+  event_id        TEXT NOT NULL,
+  payload         JSONB NOT NULL,
+  status          TEXT NOT NULL DEFAULT 'pending', -- pending | delivered | dead
+  attempts        INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_status     INTEGER,
+  last_error      TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  delivered_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON public.webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_sub ON public.webhook_deliveries (subscription_id, created_at DESC);
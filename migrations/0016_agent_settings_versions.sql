@@ -0,0 +1,25 @@
+-- Histórico das configurações do agente por flow. Cada gravação que muda
+-- agent_settings (PUT /api/agent/settings, atribuição de persona) gera uma
+-- versão com o snapshot da linha; a análise por versão usa a janela
+-- [created_at, created_at da próxima versão).
+
+CREATE TABLE IF NOT EXISTS public.agent_settings_versions (
+  id          BIGSERIAL PRIMARY KEY,
+  org_id      BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id     BIGINT NOT NULL REFERENCES public.flows(id) ON DELETE CASCADE,
+  version     INT NOT NULL,
+  snapshot    JSONB NOT NULL,
+  changed_by  BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, flow_id, version)
+);
+
+-- configuração atual vira a versão 1
+INSERT INTO public.agent_settings_versions (org_id, flow_id, version, snapshot, created_at)
+SELECT s.org_id, s.flow_id, 1, to_jsonb(s) - 'updated_at', COALESCE(s.updated_at, NOW())
+  FROM public.agent_settings s
+ON CONFLICT (org_id, flow_id, version) DO NOTHING;
+
+-- latência de resposta: mensagens em ordem dentro da conversa
+CREATE INDEX IF NOT EXISTS idx_wa_messages_org_flow_created
+  ON public.wa_messages (org_id, flow_id, created_at);
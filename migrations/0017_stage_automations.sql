@@ -0,0 +1,59 @@
+-- Automações por etapa do funil: ações disparadas quando um lead entra numa
+-- etapa (enviar template, criar tarefa, avisar usuário, iniciar sequência).
+-- Cada execução fica em stage_automation_runs; os passos de sequência
+-- agendados ficam em lead_sequence_steps até o envio.
+
+CREATE TABLE IF NOT EXISTS public.stage_automations (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id    BIGINT NOT NULL,
+  stage      TEXT NOT NULL,
+  action     TEXT NOT NULL CHECK (action IN ('send_template','create_task','notify_user','start_sequence')),
+  config     JSONB NOT NULL DEFAULT '{}'::jsonb,
+  active     BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_stage_automations_stage ON public.stage_automations (org_id, flow_id, stage) WHERE active;
+
+CREATE TABLE IF NOT EXISTS public.stage_automation_runs (
+  id            BIGSERIAL PRIMARY KEY,
+  automation_id BIGINT NOT NULL REFERENCES public.stage_automations(id) ON DELETE CASCADE,
+  org_id        BIGINT NOT NULL,
+  lead_id       BIGINT NOT NULL,
+  status        TEXT NOT NULL, -- done | failed | scheduled
+  error         TEXT,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_stage_automation_runs_auto ON public.stage_automation_runs (automation_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS public.lead_tasks (
+  id            BIGSERIAL PRIMARY KEY,
+  org_id        BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id       BIGINT NOT NULL,
+  lead_id       BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  title         TEXT NOT NULL,
+  notes         TEXT,
+  assigned_to   BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  due_at        TIMESTAMPTZ,
+  done_at       TIMESTAMPTZ,
+  automation_id BIGINT REFERENCES public.stage_automations(id) ON DELETE SET NULL,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_lead_tasks_open ON public.lead_tasks (org_id, flow_id, due_at) WHERE done_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS public.lead_sequence_steps (
+  id            BIGSERIAL PRIMARY KEY,
+  automation_id BIGINT NOT NULL REFERENCES public.stage_automations(id) ON DELETE CASCADE,
+  org_id        BIGINT NOT NULL,
+  flow_id       BIGINT NOT NULL,
+  lead_id       BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  stage         TEXT NOT NULL,
+  step          INTEGER NOT NULL,
+  run_at        TIMESTAMPTZ NOT NULL,
+  payload       JSONB NOT NULL,
+  status        TEXT NOT NULL DEFAULT 'pending', -- pending | sent | canceled | failed
+  error         TEXT,
+  sent_at       TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_lead_sequence_steps_due ON public.lead_sequence_steps (run_at) WHERE status = 'pending';
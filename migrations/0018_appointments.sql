@@ -0,0 +1,38 @@
+-- Agenda: janelas de disponibilidade semanais (da org ou de um usuário) e
+-- agendamentos. Horários das janelas são locais ao fuso da linha; os
+-- agendamentos guardam o instante (timestamptz).
+
+CREATE TABLE IF NOT EXISTS public.availability_slots (
+  id           BIGSERIAL PRIMARY KEY,
+  org_id       BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id      BIGINT NOT NULL,
+  user_id      BIGINT REFERENCES public.users(id) ON DELETE CASCADE, -- NULL = agenda da org
+  weekday      SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6), -- 0 = domingo
+  start_time   TIME NOT NULL,
+  end_time     TIME NOT NULL,
+  slot_minutes INTEGER NOT NULL DEFAULT 30 CHECK (slot_minutes BETWEEN 5 AND 480),
+  timezone     TEXT NOT NULL DEFAULT 'America/Sao_Paulo',
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (start_time < end_time)
+);
+CREATE INDEX IF NOT EXISTS idx_availability_slots_tenant ON public.availability_slots (org_id, flow_id, weekday);
+
+CREATE TABLE IF NOT EXISTS public.appointments (
+  id               BIGSERIAL PRIMARY KEY,
+  org_id           BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id          BIGINT NOT NULL,
+  user_id          BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  lead_id          BIGINT REFERENCES public.leads(id) ON DELETE SET NULL,
+  title            TEXT NOT NULL,
+  notes            TEXT,
+  starts_at        TIMESTAMPTZ NOT NULL,
+  ends_at          TIMESTAMPTZ NOT NULL,
+  status           TEXT NOT NULL DEFAULT 'booked' CHECK (status IN ('booked','canceled','done')),
+  source           TEXT NOT NULL DEFAULT 'api', -- api | agent
+  reminder_sent_at TIMESTAMPTZ,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (starts_at < ends_at)
+);
+CREATE INDEX IF NOT EXISTS idx_appointments_tenant_time ON public.appointments (org_id, flow_id, starts_at) WHERE status = 'booked';
+CREATE INDEX IF NOT EXISTS idx_appointments_reminder ON public.appointments (starts_at) WHERE status = 'booked' AND reminder_sent_at IS NULL;
@@ -0,0 +1,26 @@
+-- Google Agenda: uma conexão OAuth por usuário. A conexão marcada como
+-- org_default recebe os agendamentos da agenda da org (sem user_id). Tokens
+-- são gravados cifrados com a chave da org (pii.go).
+
+CREATE TABLE IF NOT EXISTS public.google_calendar_connections (
+  id             BIGSERIAL PRIMARY KEY,
+  org_id         BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id        BIGINT NOT NULL,
+  user_id        BIGINT NOT NULL UNIQUE REFERENCES public.users(id) ON DELETE CASCADE,
+  org_default    BOOLEAN NOT NULL DEFAULT FALSE,
+  google_email   TEXT,
+  calendar_id    TEXT NOT NULL DEFAULT 'primary',
+  access_token   TEXT NOT NULL,
+  refresh_token  TEXT NOT NULL,
+  token_expiry   TIMESTAMPTZ NOT NULL,
+  last_synced_at TIMESTAMPTZ,
+  last_error     TEXT,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_google_calendar_org_default
+  ON public.google_calendar_connections (org_id, flow_id) WHERE org_default;
+
+ALTER TABLE public.appointments ADD COLUMN IF NOT EXISTS google_event_id TEXT;
+ALTER TABLE public.appointments ADD COLUMN IF NOT EXISTS google_connection_id BIGINT
+  REFERENCES public.google_calendar_connections(id) ON DELETE SET NULL;
@@ -0,0 +1,13 @@
+-- Resultado dos agendamentos: comparecimento (done), falta (no_show) ou
+-- cancelamento, com o motivo e o momento em que foi registrado. A oferta de
+-- remarcação por WhatsApp é enviada uma única vez (rebook_offer_sent_at).
+
+ALTER TABLE public.appointments DROP CONSTRAINT IF EXISTS appointments_status_check;
+ALTER TABLE public.appointments ADD CONSTRAINT appointments_status_check
+  CHECK (status IN ('booked','canceled','done','no_show'));
+ALTER TABLE public.appointments ADD COLUMN IF NOT EXISTS outcome_at TIMESTAMPTZ;
+ALTER TABLE public.appointments ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
+ALTER TABLE public.appointments ADD COLUMN IF NOT EXISTS rebook_offer_sent_at TIMESTAMPTZ;
+ALTER TABLE public.appointments ADD COLUMN IF NOT EXISTS rebooked_from BIGINT
+  REFERENCES public.appointments(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_appointments_outcome ON public.appointments (org_id, flow_id, starts_at, status);
@@ -0,0 +1,10 @@
+-- Inbox: atribuição de conversas a um atendente e índices para a listagem
+-- por status/atribuição.
+
+ALTER TABLE public.conversations ADD COLUMN IF NOT EXISTS assigned_to BIGINT
+  REFERENCES public.users(id) ON DELETE SET NULL;
+UPDATE public.conversations SET status='open' WHERE status IS NULL;
+CREATE INDEX IF NOT EXISTS idx_conversations_inbox
+  ON public.conversations (org_id, flow_id, status, last_message_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_conversations_assigned
+  ON public.conversations (assigned_to, last_message_at DESC) WHERE assigned_to IS NOT NULL;
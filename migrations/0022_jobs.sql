@@ -0,0 +1,25 @@
+-- Fila de jobs em segundo plano (jobs.go): encaminhamento ao Agente, envios
+-- de campanha, miniaturas de produto. Jobs que esgotam as tentativas ficam
+-- com status 'dead' para consulta e reenvio em /api/admin/jobs.
+
+CREATE TABLE IF NOT EXISTS public.jobs (
+  id           BIGSERIAL PRIMARY KEY,
+  kind         TEXT NOT NULL,
+  org_id       BIGINT REFERENCES public.orgs(id) ON DELETE CASCADE,
+  payload      JSONB NOT NULL DEFAULT '{}'::jsonb,
+  status       TEXT NOT NULL DEFAULT 'pending', -- pending | running | done | dead
+  attempts     INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 5,
+  run_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  locked_until TIMESTAMPTZ,
+  last_error   TEXT,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON public.jobs (run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_jobs_running ON public.jobs (locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON public.jobs (status, id DESC);
+
+-- Miniatura da imagem principal do produto (job image.resize).
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS image_thumb_url TEXT;
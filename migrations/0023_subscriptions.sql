@@ -0,0 +1,36 @@
+-- Assinaturas (subscriptions.go): produtos recorrentes, uma assinatura por
+-- item recorrente de pedido pago e os pedidos de renovação gerados por ela.
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS recurrence TEXT; -- weekly | monthly
+
+CREATE TABLE IF NOT EXISTS public.subscriptions (
+  id               BIGSERIAL PRIMARY KEY,
+  org_id           BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id          BIGINT NOT NULL,
+  lead_id          BIGINT NOT NULL,
+  product_id       BIGINT NOT NULL,
+  qty              INTEGER NOT NULL DEFAULT 1,
+  unit_price_cents INTEGER NOT NULL,
+  recurrence       TEXT NOT NULL,                  -- weekly | monthly
+  status           TEXT NOT NULL DEFAULT 'active', -- active | paused | canceled | churned
+  next_order_at    TIMESTAMPTZ NOT NULL,
+  last_order_id    BIGINT,
+  source_order_id  BIGINT,
+  payment_method   TEXT,
+  shipping_address TEXT,
+  renewals         INTEGER NOT NULL DEFAULT 0,
+  reminder_sent_at TIMESTAMPTZ,
+  started_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  ended_at         TIMESTAMPTZ,
+  end_reason       TEXT,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_subscriptions_source_product
+  ON public.subscriptions (source_order_id, product_id) WHERE source_order_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_subscriptions_due ON public.subscriptions (next_order_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_subscriptions_org ON public.subscriptions (org_id, flow_id, status);
+
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS subscription_id BIGINT
+  REFERENCES public.subscriptions(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_orders_subscription ON public.orders (subscription_id) WHERE subscription_id IS NOT NULL;
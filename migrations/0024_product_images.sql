@@ -0,0 +1,13 @@
+-- Galeria de fotos do produto (vision/upload com várias imagens). A posição 0
+-- é a capa, a mesma gravada em products.image_base64.
+
+CREATE TABLE IF NOT EXISTS public.product_images (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  product_id BIGINT NOT NULL REFERENCES public.products(id) ON DELETE CASCADE,
+  url        TEXT NOT NULL,
+  position   INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (product_id, position)
+);
+CREATE INDEX IF NOT EXISTS idx_product_images_org ON public.product_images (org_id, product_id);
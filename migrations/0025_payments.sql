@@ -0,0 +1,34 @@
+-- Pagamentos (payments.go): uma conta por provedor na org, com credenciais e
+-- segredo do webhook cifrados pela chave da org (pii.go), e os links de
+-- cobrança gerados por pedido.
+
+CREATE TABLE IF NOT EXISTS public.payment_accounts (
+  id             BIGSERIAL PRIMARY KEY,
+  org_id         BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  provider       TEXT NOT NULL, -- mercadopago | stripe | pix
+  credentials    TEXT NOT NULL,
+  webhook_secret TEXT,
+  active         BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, provider)
+);
+
+CREATE TABLE IF NOT EXISTS public.payment_links (
+  id           BIGSERIAL PRIMARY KEY,
+  org_id       BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id      BIGINT NOT NULL,
+  order_id     BIGINT NOT NULL REFERENCES public.orders(id) ON DELETE CASCADE,
+  account_id   BIGINT REFERENCES public.payment_accounts(id) ON DELETE SET NULL,
+  provider     TEXT NOT NULL,
+  amount_cents INTEGER NOT NULL,
+  status       TEXT NOT NULL DEFAULT 'pending', -- pending | paid | expired | failed
+  url          TEXT,
+  pix_code     TEXT,
+  provider_ref TEXT,
+  last_error   TEXT,
+  expires_at   TIMESTAMPTZ,
+  paid_at      TIMESTAMPTZ,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_payment_links_order ON public.payment_links (order_id, id DESC);
@@ -0,0 +1,19 @@
+-- Estoque compartilhado entre flows da mesma org (stock_pools.go). O pool
+-- guarda a quantidade; products.stock dos produtos do pool é um espelho.
+
+CREATE TABLE IF NOT EXISTS public.stock_pools (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  name       TEXT NOT NULL,
+  quantity   INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, name)
+);
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS stock_pool_id BIGINT
+  REFERENCES public.stock_pools(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_products_stock_pool ON public.products (stock_pool_id) WHERE stock_pool_id IS NOT NULL;
+
+-- baixa de estoque feita uma única vez por pedido pago
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS stock_committed_at TIMESTAMPTZ;
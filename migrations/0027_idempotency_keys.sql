@@ -0,0 +1,16 @@
+-- Idempotency-Key (idempotency.go): primeira resposta de cada chave por org,
+-- devolvida de novo nas repetições até expires_at. status_code NULL = a
+-- primeira requisição ainda está rodando.
+
+CREATE TABLE IF NOT EXISTS public.idempotency_keys (
+  org_id       BIGINT NOT NULL DEFAULT 0,
+  key          TEXT NOT NULL,
+  fingerprint  TEXT NOT NULL,
+  status_code  INTEGER,
+  content_type TEXT,
+  body         BYTEA,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at   TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (org_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON public.idempotency_keys (expires_at);
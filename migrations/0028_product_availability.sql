@@ -0,0 +1,10 @@
+-- Janela de disponibilidade dos produtos (product_schedule.go).
+-- available_weekdays usa 0 = domingo, no fuso de APPOINTMENT_TZ.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS available_from     TIMESTAMPTZ;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS available_until    TIMESTAMPTZ;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS available_weekdays INTEGER[];
+
+CREATE INDEX IF NOT EXISTS idx_products_scheduled
+    ON products (id)
+ WHERE available_from IS NOT NULL OR available_until IS NOT NULL
+    OR available_weekdays IS NOT NULL OR status = 'scheduled';
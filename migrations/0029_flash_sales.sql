@@ -0,0 +1,35 @@
+-- Ofertas relâmpago (flash_sales.go): preço com prazo e quantidade limitada
+-- por produto, e o público de cada uma (anúncio e aviso de últimas unidades).
+
+CREATE TABLE IF NOT EXISTS public.flash_sales (
+  id                 BIGSERIAL PRIMARY KEY,
+  org_id             BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id            BIGINT NOT NULL REFERENCES public.flows(id) ON DELETE CASCADE,
+  product_id         BIGINT NOT NULL REFERENCES public.products(id) ON DELETE CASCADE,
+  price_cents        INTEGER NOT NULL CHECK (price_cents > 0),
+  quantity           INTEGER NOT NULL CHECK (quantity > 0),
+  sold               INTEGER NOT NULL DEFAULT 0 CHECK (sold >= 0),
+  starts_at          TIMESTAMPTZ NOT NULL,
+  ends_at            TIMESTAMPTZ NOT NULL,
+  message            TEXT,
+  last_units_at      INTEGER NOT NULL DEFAULT 3,
+  last_units_sent_at TIMESTAMPTZ,
+  status             TEXT NOT NULL DEFAULT 'active', -- active | sold_out | canceled
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (ends_at > starts_at)
+);
+CREATE INDEX IF NOT EXISTS idx_flash_sales_product_active
+  ON public.flash_sales (product_id, starts_at, ends_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_flash_sales_org_flow
+  ON public.flash_sales (org_id, flow_id, starts_at DESC);
+
+CREATE TABLE IF NOT EXISTS public.flash_sale_leads (
+  flash_sale_id BIGINT NOT NULL REFERENCES public.flash_sales(id) ON DELETE CASCADE,
+  lead_id       BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  announced_at  TIMESTAMPTZ,
+  last_units_at TIMESTAMPTZ,
+  PRIMARY KEY (flash_sale_id, lead_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_wa_messages_lead_in
+  ON public.wa_messages (lead_id, created_at) WHERE direction = 'in';
@@ -0,0 +1,18 @@
+-- Busca full-text de produtos (product_search.go): tags e coluna gerada
+-- search_vector com título (A), tags (B), categoria (C) e slug (D).
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+-- array_to_string é STABLE; a coluna gerada exige função IMMUTABLE
+CREATE OR REPLACE FUNCTION public.product_search_vector(title TEXT, slug TEXT, category TEXT, tags TEXT[])
+RETURNS tsvector LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+  SELECT setweight(to_tsvector('portuguese', COALESCE(title, '')), 'A')
+      || setweight(to_tsvector('portuguese', array_to_string(COALESCE(tags, '{}'), ' ')), 'B')
+      || setweight(to_tsvector('portuguese', COALESCE(category, '')), 'C')
+      || setweight(to_tsvector('simple', replace(COALESCE(slug, ''), '-', ' ')), 'D')
+$$;
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS search_vector tsvector
+  GENERATED ALWAYS AS (public.product_search_vector(title, slug, category, tags)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON public.products USING GIN (search_vector);
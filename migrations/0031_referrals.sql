@@ -0,0 +1,49 @@
+-- Programa de indicação (referrals.go): código por lead, atribuição do
+-- indicado e do pedido ao indicador, regras e recompensas por org.
+
+CREATE TABLE IF NOT EXISTS public.referral_codes (
+  lead_id    BIGINT PRIMARY KEY REFERENCES public.leads(id) ON DELETE CASCADE,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  code       TEXT NOT NULL,
+  clicks     INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, code)
+);
+
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS referred_by_lead_id BIGINT
+  REFERENCES public.leads(id) ON DELETE SET NULL;
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS referred_at TIMESTAMPTZ;
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS referral_source TEXT; -- message | api
+CREATE INDEX IF NOT EXISTS idx_leads_referred_by
+  ON public.leads (referred_by_lead_id) WHERE referred_by_lead_id IS NOT NULL;
+
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS referrer_lead_id BIGINT
+  REFERENCES public.leads(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_orders_referrer
+  ON public.orders (referrer_lead_id) WHERE referrer_lead_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS public.referral_settings (
+  org_id           BIGINT PRIMARY KEY REFERENCES public.orgs(id) ON DELETE CASCADE,
+  active           BOOLEAN NOT NULL DEFAULT FALSE,
+  reward_type      TEXT NOT NULL DEFAULT 'fixed', -- fixed (centavos) | percent (pontos-base)
+  reward_value     INTEGER NOT NULL DEFAULT 0,
+  first_order_only BOOLEAN NOT NULL DEFAULT TRUE,
+  min_order_cents  INTEGER NOT NULL DEFAULT 0,
+  whatsapp_number  TEXT,
+  share_message    TEXT,
+  updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.referral_rewards (
+  id               BIGSERIAL PRIMARY KEY,
+  org_id           BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  referrer_lead_id BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  referred_lead_id BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  order_id         BIGINT NOT NULL UNIQUE REFERENCES public.orders(id) ON DELETE CASCADE,
+  amount_cents     INTEGER NOT NULL,
+  status           TEXT NOT NULL DEFAULT 'pending', -- pending | paid | canceled
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  paid_at          TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_referrer ON public.referral_rewards (referrer_lead_id);
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_org ON public.referral_rewards (org_id, status, created_at DESC);
@@ -0,0 +1,6 @@
+-- Verificação de e-mail (email_verification.go).
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS email_verification_sent_at TIMESTAMPTZ;
+
+-- contas anteriores à verificação ficam como verificadas
+UPDATE public.users SET email_verified_at = COALESCE(created_at, NOW()) WHERE email_verified_at IS NULL;
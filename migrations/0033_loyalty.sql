@@ -0,0 +1,32 @@
+-- Programa de pontos (loyalty.go): regras por org, extrato de movimentos e
+-- desconto com pontos nos pedidos e rascunhos do chat.
+
+CREATE TABLE IF NOT EXISTS public.loyalty_settings (
+  org_id            BIGINT PRIMARY KEY REFERENCES public.orgs(id) ON DELETE CASCADE,
+  active            BOOLEAN NOT NULL DEFAULT FALSE,
+  points_per_real   INTEGER NOT NULL DEFAULT 1,
+  point_value_cents INTEGER NOT NULL DEFAULT 5,
+  min_redeem_points INTEGER NOT NULL DEFAULT 100,
+  max_redeem_bps    INTEGER NOT NULL DEFAULT 5000, -- teto do desconto sobre o pedido
+  updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.loyalty_ledger (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  lead_id    BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  order_id   BIGINT REFERENCES public.orders(id) ON DELETE SET NULL,
+  kind       TEXT NOT NULL, -- earn | redeem | earn_reversal | redeem_refund | adjust
+  points     INTEGER NOT NULL,
+  note       TEXT,
+  user_id    BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_loyalty_ledger_lead ON public.loyalty_ledger (lead_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_loyalty_ledger_order_kind
+  ON public.loyalty_ledger (order_id, kind) WHERE order_id IS NOT NULL;
+
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS discount_cents INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE public.orders ADD COLUMN IF NOT EXISTS points_redeemed INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE public.chat_order_drafts ADD COLUMN IF NOT EXISTS redeem_points INTEGER NOT NULL DEFAULT 0;
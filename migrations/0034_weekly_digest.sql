@@ -0,0 +1,25 @@
+-- Resumo semanal de desempenho (weekly_digest.go).
+
+CREATE TABLE IF NOT EXISTS public.weekly_digest_settings (
+  org_id            BIGINT PRIMARY KEY REFERENCES public.orgs(id) ON DELETE CASCADE,
+  active            BOOLEAN NOT NULL DEFAULT TRUE,
+  email             BOOLEAN NOT NULL DEFAULT TRUE,
+  whatsapp_number   TEXT,
+  weekday           SMALLINT NOT NULL DEFAULT 1, -- 0 = domingo
+  hour              SMALLINT NOT NULL DEFAULT 8,
+  last_period_start TIMESTAMPTZ,                 -- última semana já enfileirada
+  updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.weekly_digests (
+  id           BIGSERIAL PRIMARY KEY,
+  org_id       BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  period_start TIMESTAMPTZ NOT NULL,
+  period_end   TIMESTAMPTZ NOT NULL,
+  metrics      JSONB NOT NULL,
+  summary      TEXT NOT NULL,
+  model        TEXT, -- NULL = resumo sem IA
+  delivered_to TEXT[] NOT NULL DEFAULT '{}',
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, period_start)
+);
@@ -0,0 +1,17 @@
+-- Mesclagem de leads duplicados (lead_dedup.go). Os telefones antigos são
+-- normalizados para E.164 com o subcomando "api normalize-phones".
+
+CREATE TABLE IF NOT EXISTS public.lead_merges (
+  id                BIGSERIAL PRIMARY KEY,
+  org_id            BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id           BIGINT NOT NULL REFERENCES public.flows(id) ON DELETE CASCADE,
+  kept_lead_id      BIGINT NOT NULL REFERENCES public.leads(id) ON DELETE CASCADE,
+  merged_lead_id    BIGINT NOT NULL,               -- já apagado
+  merged_stage      TEXT,
+  merged_created_at TIMESTAMPTZ,
+  moved             JSONB NOT NULL DEFAULT '{}',   -- registros movidos por tipo
+  merged_by         BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_lead_merges_kept ON public.lead_merges (kept_lead_id);
+CREATE INDEX IF NOT EXISTS idx_lead_merges_org ON public.lead_merges (org_id, created_at DESC);
@@ -0,0 +1,18 @@
+-- Chaves de API por org para integrações servidor a servidor (api_keys.go).
+-- Só o SHA-256 da chave é guardado; prefix serve para exibição.
+
+CREATE TABLE IF NOT EXISTS public.api_keys (
+  id           BIGSERIAL PRIMARY KEY,
+  org_id       BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id      BIGINT NOT NULL REFERENCES public.flows(id) ON DELETE CASCADE,
+  name         TEXT NOT NULL,
+  prefix       TEXT NOT NULL,
+  key_hash     TEXT NOT NULL UNIQUE,
+  scopes       TEXT[] NOT NULL DEFAULT '{}',
+  created_by   BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_used_at TIMESTAMPTZ,
+  expires_at   TIMESTAMPTZ,
+  revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_org ON public.api_keys (org_id, created_at DESC);
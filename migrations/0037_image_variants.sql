@@ -0,0 +1,9 @@
+-- Variantes (thumb/medium/large) geradas no upload de imagens, pela URL do
+-- original (image_resize.go). products.image_base64 guarda essa mesma URL.
+
+CREATE TABLE IF NOT EXISTS public.image_variants (
+  url        TEXT PRIMARY KEY,
+  org_id     BIGINT REFERENCES public.orgs(id) ON DELETE CASCADE,
+  sizes      JSONB NOT NULL DEFAULT '{}',   -- {"thumb": url, "medium": url, "large": url}
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
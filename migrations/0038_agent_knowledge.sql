@@ -0,0 +1,32 @@
+-- Base de conhecimento do agente (agent_knowledge.go): documentos enviados
+-- pela org (PDF, TXT, FAQ) divididos em trechos com embedding. A coluna
+-- embedding_vec (pgvector) é criada em Go quando a extensão está disponível;
+-- sem ela, a busca usa o REAL[] com similaridade calculada na aplicação.
+
+CREATE TABLE IF NOT EXISTS public.agent_knowledge_docs (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  flow_id    BIGINT REFERENCES public.flows(id) ON DELETE CASCADE,  -- NULL = todos os flows
+  title      TEXT NOT NULL,
+  kind       TEXT NOT NULL,                    -- txt | pdf | faq
+  filename   TEXT,
+  status     TEXT NOT NULL DEFAULT 'processing', -- processing | ready | failed
+  chunks     INTEGER NOT NULL DEFAULT 0,
+  error      TEXT,
+  created_by BIGINT REFERENCES public.users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_agent_knowledge_docs_org ON public.agent_knowledge_docs (org_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS public.agent_knowledge_chunks (
+  id              BIGSERIAL PRIMARY KEY,
+  doc_id          BIGINT NOT NULL REFERENCES public.agent_knowledge_docs(id) ON DELETE CASCADE,
+  org_id          BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  position        INTEGER NOT NULL,
+  content         TEXT NOT NULL,
+  embedding_model TEXT,
+  embedding       REAL[]                        -- NULL até o job knowledge.embed
+);
+CREATE INDEX IF NOT EXISTS idx_agent_knowledge_chunks_doc ON public.agent_knowledge_chunks (doc_id, position);
+CREATE INDEX IF NOT EXISTS idx_agent_knowledge_chunks_org ON public.agent_knowledge_chunks (org_id);
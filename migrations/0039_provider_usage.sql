@@ -0,0 +1,15 @@
+-- Chamadas de envio ao provedor de WhatsApp por org e dia (provider_quota.go).
+-- O consumo de IA já fica em ai_usage; aqui entra o lado uazapi/Meta para o
+-- operador acompanhar a cota global compartilhada.
+
+CREATE TABLE IF NOT EXISTS public.provider_usage_daily (
+  org_id       BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  provider     TEXT NOT NULL,              -- uazapi | meta_cloud
+  day          DATE NOT NULL,              -- UTC
+  calls        BIGINT NOT NULL DEFAULT 0,
+  errors       BIGINT NOT NULL DEFAULT 0,
+  rate_limited BIGINT NOT NULL DEFAULT 0,
+  updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (org_id, provider, day)
+);
+CREATE INDEX IF NOT EXISTS idx_provider_usage_daily_day ON public.provider_usage_daily (day);
@@ -0,0 +1,19 @@
+-- Credenciais próprias de provedor de IA por org (org_llm_credentials.go).
+-- api_key cifrada com a chave da org (pii.go); key_hint guarda só o final
+-- para exibição.
+
+CREATE TABLE IF NOT EXISTS public.org_llm_credentials (
+  org_id       BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  provider     TEXT NOT NULL,             -- openai | anthropic | gemini
+  api_key      TEXT NOT NULL,
+  base_url     TEXT,
+  key_hint     TEXT NOT NULL DEFAULT '',
+  verified_at  TIMESTAMPTZ,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (org_id, provider)
+);
+
+-- Consumo pago com a chave da própria org: fica fora do orçamento mensal e
+-- das cotas da chave da plataforma.
+ALTER TABLE public.ai_usage ADD COLUMN IF NOT EXISTS byok BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,14 @@
+-- Conta própria da org no provedor de WhatsApp (wa_credentials.go).
+-- admin_token cifrado com a chave da org (pii.go); token_hint guarda só o
+-- final para exibição.
+
+CREATE TABLE IF NOT EXISTS public.org_wa_credentials (
+  org_id       BIGINT PRIMARY KEY REFERENCES public.orgs(id) ON DELETE CASCADE,
+  base_url     TEXT NOT NULL,
+  admin_token  TEXT NOT NULL,
+  auth_mode    TEXT,                      -- bearer | admintoken; NULL = UAZAPI_AUTH_MODE
+  token_hint   TEXT NOT NULL DEFAULT '',
+  verified_at  TIMESTAMPTZ,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+-- Status de entrega das mensagens enviadas (wa_message_status.go): o
+-- provedor avisa sent/delivered/read/failed pelo webhook.
+
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS status       TEXT;  -- sent | delivered | read | failed
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS status_error TEXT;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS sent_at      TIMESTAMPTZ;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMPTZ;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS read_at      TIMESTAMPTZ;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS failed_at    TIMESTAMPTZ;
+ALTER TABLE public.wa_messages ADD COLUMN IF NOT EXISTS updated_at   TIMESTAMPTZ;
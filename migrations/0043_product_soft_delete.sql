@@ -0,0 +1,13 @@
+-- Exclusão lógica e arquivamento de produtos (product_archive.go).
+-- DELETE /api/products/{id} só carimba deleted_at; o purge do admin apaga
+-- de vez. Arquivado = status 'archived' com archived_at.
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS deleted_at  TIMESTAMPTZ;
+
+-- listas e buscas olham só os vivos
+CREATE INDEX IF NOT EXISTS products_live_idx
+  ON public.products (org_id, flow_id, created_at DESC) WHERE deleted_at IS NULL;
+-- purge
+CREATE INDEX IF NOT EXISTS products_deleted_at_idx
+  ON public.products (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,12 @@
+-- QR de conexão acompanhado pelo servidor (wa_qr_refresh.go): o último QR
+-- lido do provedor, quando expira e até quando alguém está olhando a tela
+-- de conexão (só então o worker pede QR novo).
+
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS qr_code          TEXT;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS qr_issued_at     TIMESTAMPTZ;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS qr_expires_at    TIMESTAMPTZ;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS qr_refreshes     INT NOT NULL DEFAULT 0;
+ALTER TABLE public.wa_instances ADD COLUMN IF NOT EXISTS qr_watched_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS wa_instances_qr_watched_idx
+  ON public.wa_instances (qr_watched_until) WHERE qr_watched_until IS NOT NULL AND deleted_at IS NULL;
@@ -0,0 +1,17 @@
+-- Histórico de conexão das instâncias (wa_instance_events.go): cada troca de
+-- estado (connected, disconnected, qr-expired, deleted) com origem e motivo.
+
+CREATE TABLE IF NOT EXISTS public.wa_connection_events (
+  id          BIGSERIAL PRIMARY KEY,
+  instance_id TEXT NOT NULL,
+  org_id      BIGINT,
+  flow_id     BIGINT,
+  state       TEXT NOT NULL,
+  previous    TEXT,
+  source      TEXT,          -- webhook | poll | qr | api
+  reason      TEXT,          -- motivo informado pelo provedor, se houver
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS wa_connection_events_instance_idx
+  ON public.wa_connection_events (instance_id, id DESC);
@@ -0,0 +1,31 @@
+-- Modo degradado da IA (ai_fallback.go): orgs com a IA fora do ar, mensagens
+-- do WhatsApp guardadas para reprocessar quando ela voltar e o controle da
+-- resposta automática de espera por contato.
+
+CREATE TABLE IF NOT EXISTS public.ai_degraded_orgs (
+  org_id     BIGINT PRIMARY KEY,
+  since      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  failures   INT NOT NULL DEFAULT 0,
+  last_error TEXT,
+  probed_at  TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS public.ai_held_messages (
+  id          BIGSERIAL PRIMARY KEY,
+  org_id      BIGINT NOT NULL,
+  instance_id TEXT NOT NULL,
+  contacts    TEXT[] NOT NULL DEFAULT '{}',
+  payload     JSONB NOT NULL,              -- agentForwardJob (webhook_wa.go)
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  released_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS ai_held_messages_pending_idx
+  ON public.ai_held_messages (org_id, id) WHERE released_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS public.ai_fallback_replies (
+  instance_id TEXT NOT NULL,
+  contact     TEXT NOT NULL,
+  sent_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (instance_id, contact)
+);
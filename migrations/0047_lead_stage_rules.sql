@@ -0,0 +1,19 @@
+-- Regras de avanço automático de etapa por org (lead_stage_rules.go):
+-- intenção de compra detectada pelo Agente, pedido criado e pedido pago
+-- movem o lead para a etapa configurada. Sem linha para o evento, vale o
+-- padrão do código.
+
+CREATE TABLE IF NOT EXISTS public.lead_stage_rules (
+  id             BIGSERIAL PRIMARY KEY,
+  org_id         BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  event          TEXT NOT NULL CHECK (event IN ('buying_intent','order_created','order_paid')),
+  to_stage       TEXT NOT NULL,
+  min_confidence DOUBLE PRECISION NOT NULL DEFAULT 0, -- só buying_intent
+  active         BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (org_id, event)
+);
+
+-- evento da regra que fez a mudança (NULL = manual)
+ALTER TABLE public.lead_stage_changes ADD COLUMN IF NOT EXISTS rule_event TEXT;
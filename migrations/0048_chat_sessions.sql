@@ -0,0 +1,17 @@
+-- Sessões de chat emitidas pelo servidor (chat_sessions.go): o sessionId de
+-- /api/chat, /api/chat/ws e /api/vision/upload, preso à org/flow e com
+-- validade renovada a cada mensagem. Antes criada na subida; IF NOT EXISTS
+-- mantém as bases que já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.chat_sessions (
+  id           TEXT PRIMARY KEY,
+  org_id       BIGINT NOT NULL DEFAULT 0,
+  flow_id      BIGINT NOT NULL DEFAULT 0,
+  user_id      BIGINT,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at   TIMESTAMPTZ NOT NULL,
+  ended_at     TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_sessions_expires ON public.chat_sessions (expires_at);
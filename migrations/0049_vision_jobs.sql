@@ -0,0 +1,17 @@
+-- Análise assíncrona de /api/vision/upload (vision_jobs.go): estado e
+-- resultado de cada job vision.analyze. Antes criada na subida; IF NOT
+-- EXISTS mantém as bases que já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.vision_jobs (
+  id         TEXT PRIMARY KEY,
+  org_id     BIGINT NOT NULL DEFAULT 0,
+  flow_id    BIGINT NOT NULL DEFAULT 0,
+  status     TEXT NOT NULL DEFAULT 'queued',   -- queued | analyzing | done | failed
+  input      JSONB NOT NULL,
+  result     JSONB,
+  error      TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_vision_jobs_created ON public.vision_jobs (created_at);
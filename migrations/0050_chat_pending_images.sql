@@ -0,0 +1,21 @@
+-- Produtos pendentes do chat (chat_pending_store.go) com todas as fotos da
+-- pendência em images. A tabela era criada na subida, depois das migrações,
+-- então vem inteira aqui (IF NOT EXISTS mantém as bases que já a têm) antes
+-- da coluna nova.
+
+CREATE TABLE IF NOT EXISTS public.chat_pending_products (
+  session_id TEXT NOT NULL,
+  org_id     BIGINT NOT NULL,
+  flow_id    BIGINT NOT NULL,
+  image_path TEXT,
+  image_url  TEXT,
+  suggest    JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (session_id, org_id, flow_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_pending_expires ON public.chat_pending_products (expires_at);
+
+ALTER TABLE public.chat_pending_products
+  ADD COLUMN IF NOT EXISTS images JSONB NOT NULL DEFAULT '[]';  -- fotos em ordem; image_path/url = capa
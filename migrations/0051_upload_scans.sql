@@ -0,0 +1,13 @@
+-- Resultado do antivírus por upload (av_scan.go). Antes criada na subida;
+-- IF NOT EXISTS mantém as bases que já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.upload_scans (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT,
+  source     TEXT NOT NULL,
+  path       TEXT NOT NULL,
+  status     TEXT NOT NULL,
+  signature  TEXT,
+  engine     TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
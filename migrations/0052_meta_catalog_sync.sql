@@ -0,0 +1,16 @@
+-- Configuração da sincronização com o catálogo da Meta por tenant
+-- (catalog_meta_sync.go). Antes criada na subida; IF NOT EXISTS mantém as
+-- bases que já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.meta_catalog_sync (
+  org_id       BIGINT NOT NULL,
+  flow_id      BIGINT NOT NULL,
+  catalog_id   TEXT NOT NULL,
+  access_token TEXT NOT NULL,
+  enabled      BOOLEAN NOT NULL DEFAULT TRUE,
+  last_sync_at TIMESTAMPTZ,
+  last_error   TEXT,
+  last_count   INTEGER NOT NULL DEFAULT 0,
+  updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (org_id, flow_id)
+);
@@ -0,0 +1,15 @@
+-- Histórico do chat por sessão (chat_history_store.go). Antes criada na
+-- subida; IF NOT EXISTS mantém as bases que já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.chat_messages (
+  id         BIGSERIAL PRIMARY KEY,
+  session_id TEXT NOT NULL,
+  org_id     BIGINT NOT NULL DEFAULT 0,
+  flow_id    BIGINT NOT NULL DEFAULT 0,
+  role       TEXT NOT NULL,
+  content    TEXT NOT NULL,
+  model      TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_session ON public.chat_messages (session_id, org_id, flow_id, created_at);
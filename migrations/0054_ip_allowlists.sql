@@ -0,0 +1,24 @@
+-- Allowlist de IPs por org e registro das recusas (ip_allowlist.go). Antes
+-- criadas na subida; IF NOT EXISTS mantém as bases que já têm as tabelas.
+
+CREATE TABLE IF NOT EXISTS public.org_ip_allowlists (
+  id         BIGSERIAL PRIMARY KEY,
+  org_id     BIGINT NOT NULL REFERENCES public.orgs(id) ON DELETE CASCADE,
+  scope      TEXT NOT NULL,
+  cidr       TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_ip_allowlists_org_scope ON public.org_ip_allowlists (org_id, scope);
+
+CREATE TABLE IF NOT EXISTS public.ip_rejections (
+  id         BIGSERIAL PRIMARY KEY,
+  scope      TEXT NOT NULL,
+  org_id     BIGINT,
+  ip         TEXT,
+  method     TEXT,
+  path       TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_rejections_created ON public.ip_rejections (created_at);
@@ -0,0 +1,5 @@
+-- Vídeo do produto e sua miniatura (media_video.go). Antes adicionadas na
+-- subida.
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS video_url TEXT;
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS video_thumb_url TEXT;
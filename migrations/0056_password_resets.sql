@@ -0,0 +1,13 @@
+-- Tokens de redefinição de senha (password_reset.go); só o hash SHA-256
+-- fica guardado. Antes criada na subida; IF NOT EXISTS mantém as bases que
+-- já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.password_resets (
+  id           BIGSERIAL PRIMARY KEY,
+  user_id      BIGINT NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+  token_hash   TEXT NOT NULL UNIQUE,
+  expires_at   TIMESTAMPTZ NOT NULL,
+  used_at      TIMESTAMPTZ,
+  requested_ip TEXT,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+-- Criptografia de PII dos leads (pii.go): hashes de busca de telefone e
+-- e-mail e versão da chave por org. Antes criados na subida.
+
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS phone_hash TEXT;
+ALTER TABLE public.leads ADD COLUMN IF NOT EXISTS email_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_leads_org_phone_hash ON public.leads (org_id, phone_hash);
+CREATE INDEX IF NOT EXISTS idx_leads_org_email_hash ON public.leads (org_id, email_hash);
+
+CREATE TABLE IF NOT EXISTS public.org_pii_keys (
+  org_id      BIGINT PRIMARY KEY REFERENCES public.orgs(id) ON DELETE CASCADE,
+  key_version INTEGER NOT NULL DEFAULT 1,
+  rotated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+-- Feed público de produtos por tenant (product_feed.go). Antes criada na
+-- subida; IF NOT EXISTS mantém as bases que já têm a tabela.
+
+CREATE TABLE IF NOT EXISTS public.product_feeds (
+  org_id       BIGINT NOT NULL,
+  flow_id      BIGINT NOT NULL,
+  token        TEXT NOT NULL UNIQUE,
+  xml          TEXT,
+  csv          TEXT,
+  item_count   INTEGER NOT NULL DEFAULT 0,
+  generated_at TIMESTAMPTZ,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (org_id, flow_id)
+);
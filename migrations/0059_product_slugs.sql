@@ -0,0 +1,36 @@
+-- Slugs de produto únicos por org (product_slugs.go, storefront.go). Antes
+-- feito na subida, em Go:
+--   - slugs antigos em texto livre (gerados pela IA) eram, na prática, a
+--     descrição do produto: vão para description;
+--   - slug vazio ou fora do formato é refeito a partir do título, com a
+--     mesma troca de acentos de slugify;
+--   - duplicados na org recebem o id como sufixo (o primeiro fica como está).
+-- Em bases já corrigidas os UPDATEs não acham linhas.
+
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS description TEXT;
+
+UPDATE public.products SET description = slug
+ WHERE description IS NULL AND slug IS NOT NULL AND slug !~ '^[a-z0-9]+(-[a-z0-9]+)*$';
+
+UPDATE public.products
+   SET slug = COALESCE(NULLIF(trim(BOTH '-' FROM left(trim(BOTH '-' FROM regexp_replace(
+                translate(lower(trim(title)), 'áàâãäéèêëíìîïóòôõöúùûüçñ', 'aaaaaeeeeiiiiooooouuuucn'),
+                '[^a-z0-9]+', '-', 'g')), 72)), ''), 'produto')
+ WHERE slug IS NULL OR slug !~ '^[a-z0-9]+(-[a-z0-9]+)*$';
+
+DO $$
+BEGIN
+  LOOP
+    WITH d AS (
+      SELECT id, ROW_NUMBER() OVER (PARTITION BY org_id, slug ORDER BY id) AS rn
+        FROM public.products
+    )
+    UPDATE public.products p SET slug = p.slug || '-' || p.id
+      FROM d
+     WHERE d.id = p.id AND d.rn > 1;
+    EXIT WHEN NOT FOUND;
+  END LOOP;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS ux_products_org_slug ON public.products (org_id, slug);
+CREATE INDEX IF NOT EXISTS idx_products_org_slug ON public.products (org_id, slug);
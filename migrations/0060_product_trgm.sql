@@ -0,0 +1,18 @@
+-- Autocomplete de produtos (product_suggest.go): pg_trgm e índice GIN
+-- trigram no título. Sem permissão para CREATE EXTENSION a migração segue
+-- sem o índice e o suggest usa ILIKE; o servidor confere pg_extension na
+-- subida.
+
+DO $$
+BEGIN
+  CREATE EXTENSION IF NOT EXISTS pg_trgm;
+EXCEPTION WHEN OTHERS THEN
+  RAISE NOTICE 'pg_trgm unavailable, suggest will use ILIKE: %', SQLERRM;
+END $$;
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm') THEN
+    CREATE INDEX IF NOT EXISTS idx_products_title_trgm ON public.products USING GIN (lower(title) gin_trgm_ops);
+  END IF;
+END $$;
@@ -0,0 +1,18 @@
+-- Base de conhecimento (agent_knowledge.go): coluna embedding_vec com
+-- pgvector. Sem a extensão (ou sem permissão para criá-la) a migração segue
+-- sem a coluna e a busca usa o REAL[] de 0038; o servidor confere a coluna
+-- na subida.
+
+DO $$
+BEGIN
+  CREATE EXTENSION IF NOT EXISTS vector;
+EXCEPTION WHEN OTHERS THEN
+  RAISE NOTICE 'pgvector unavailable, knowledge search will scan in memory: %', SQLERRM;
+END $$;
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'vector') THEN
+    ALTER TABLE public.agent_knowledge_chunks ADD COLUMN IF NOT EXISTS embedding_vec vector;
+  END IF;
+END $$;
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/agentv1"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Especificação OpenAPI 3 da API (/api/openapi.json)
+// ================================================================
+//
+// O documento sai do próprio roteador: chi.Walk lista todas as rotas
+// registradas, então rota nova aparece sem passo extra. Por cima disso:
+//
+//   - openAPIAccess decide, pelo caminho, o esquema de autenticação
+//     (Bearer JWT, X-API-Key, X-Admin-Token, X-Internal-Token ou público) e
+//     se a rota aceita os headers de tenant X-Org-ID/X-Flow-ID
+//     (tenant_context.go);
+//   - openAPIOps anota as rotas principais com resumo e corpos; os schemas
+//     são gerados por reflexão dos tipos Go (tags json), então o contrato
+//     acompanha as structs. Rotas sem anotação saem com resumo genérico e
+//     corpo livre.
+//
+// GET /api/openapi.json   sempre ligado, sem autenticação
+// GET /api/docs           Swagger UI (assets do CDN), com OPENAPI_DOCS=true
+//
+// O servidor anunciado é OPENAPI_SERVER_URL ou o host da requisição.
+
+type openAPIOp struct {
+	Summary  string
+	Request  any  // valor de exemplo do corpo; nil = sem corpo documentado
+	Response any  // idem para a resposta de sucesso
+	List     bool // resposta {"items": [Response]}
+	Status   int  // status de sucesso (padrão 200)
+	// Idempotent: rota com o middleware idempotent (Idempotency-Key)
+	Idempotent bool
+}
+
+// openAPIOps anota as rotas por "MÉTODO /caminho" (padrões do chi, com {param}).
+var openAPIOps = map[string]openAPIOp{
+	"POST /api/auth/register": {Summary: "Cria conta, org e flow", Status: http.StatusCreated, Request: struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		TaxID    string `json:"tax_id"`
+	}{}, Response: openAPIToken{}},
+	"POST /api/auth/login": {Summary: "Login por e-mail e senha", Request: struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{}, Response: openAPIToken{}},
+	"POST /api/auth/refresh": {Summary: "Renova o JWT", Response: openAPIToken{}},
+	"GET /api/auth/me":       {Summary: "Usuário do token"},
+
+	"GET /api/products":               {Summary: "Lista produtos (?format=csv exporta)", Response: Product{}, List: true},
+	"POST /api/products":              {Summary: "Cria produto", Request: Product{}, Response: Product{}, Status: http.StatusCreated, Idempotent: true},
+	"PUT /api/products/{id}":          {Summary: "Atualiza produto", Request: Product{}, Response: Product{}},
+	"DELETE /api/products/{id}":       {Summary: "Manda o produto para a lixeira (admin)", Status: http.StatusNoContent},
+	"POST /api/products/{id}/archive": {Summary: "Arquiva produto", Status: http.StatusNoContent},
+	"POST /api/products/{id}/restore": {Summary: "Restaura produto arquivado ou excluído"},
+	"GET /api/products/search":        {Summary: "Busca full-text", Response: Product{}, List: true},
+	"GET /api/products/export":        {Summary: "Exporta produtos em CSV"},
+	"POST /api/products/import":       {Summary: "Importa produtos de CSV/XLSX"},
+
+	"GET /api/leads":      {Summary: "Lista leads (?phone=, ?email=, ?format=csv)", Response: Lead{}, List: true},
+	"POST /api/leads":     {Summary: "Cria lead (deduplica por telefone)", Request: openAPILeadIn{}, Response: Lead{}, Status: http.StatusCreated, Idempotent: true},
+	"GET /api/leads/{id}": {Summary: "Detalhe do lead", Response: Lead{}},
+	"PUT /api/leads/{id}": {Summary: "Atualiza lead", Request: openAPILeadIn{}, Response: Lead{}},
+	"GET /api/orders":     {Summary: "Lista pedidos (?format=csv exporta)", Response: Order{}, List: true},
+	"POST /api/orders":    {Summary: "Cria pedido", Request: openAPIOrderIn{}, Response: Order{}, Status: http.StatusCreated, Idempotent: true},
+
+	"POST /api/chat":          {Summary: "Mensagem ao agente de IA", Request: chatReq{}},
+	"POST /api/chat/sessions": {Summary: "Abre sessão de chat", Response: chatSession{}, Status: http.StatusCreated},
+	"GET /api/chat/ws":        {Summary: "Gateway WebSocket do chat (streaming)"},
+	"POST /api/vision/upload": {Summary: "Fotos de produto para a visão (multipart)"},
+	"POST /api/upload":        {Summary: "Upload de imagem (multipart)"},
+	"GET /api/company":        {Summary: "Dados da empresa", Response: Company{}},
+	"PUT /api/company":        {Summary: "Atualiza a empresa (admin)", Request: CompanyInput{}, Response: Company{}},
+	"GET /api/agent/settings": {Summary: "Configuração do agente", Response: AgentSettings{}},
+	"PUT /api/agent/settings": {Summary: "Atualiza a configuração do agente (admin)", Request: AgentSettings{}, Response: AgentSettings{}},
+
+	"POST /api/wa/instances":                                {Summary: "Cria instância uazapi (admin)", Request: waCreateReq{}, Status: http.StatusCreated},
+	"GET /api/wa/instances/{instance}/status":               {Summary: "Status da conexão"},
+	"GET /api/wa/instances/{instance}/qr":                   {Summary: "QR code de pareamento"},
+	"GET /api/wa/instances/{instance}/events":               {Summary: "Histórico de conexão da instância"},
+	"GET /api/wa/instances/{instance}/qr/stream":            {Summary: "QR sempre válido (SSE: qr, status, expired)"},
+	"POST /api/wa/instances/{instance}/send/text":           {Summary: "Envia texto", Request: waSendTextReq{}, Idempotent: true},
+	"POST /api/wa/instances/{instance}/send/video":          {Summary: "Envia vídeo por URL", Request: waSendVideoReq{}, Idempotent: true},
+	"POST /api/wa/instances/{instance}/send/media":          {Summary: "Envia mídia (JSON com URL ou multipart)", Request: waSendMediaReq{}, Idempotent: true},
+	"POST /api/wa/instances/{instance}/send/template":       {Summary: "Envia template aprovado (Meta)", Idempotent: true},
+	"GET /api/wa/instances/{instance}/window":               {Summary: "Janela de 24h do contato"},
+	"DELETE /api/wa/instances/{instance}":                   {Summary: "Apaga a instância", Status: http.StatusNoContent},
+	"POST /api/wa/instances/{instance}/webhook/reprovision": {Summary: "Reaponta o webhook da instância"},
+
+	"GET /api/orgs/api-keys":                      {Summary: "Chaves de API da org", Response: apiKey{}, List: true},
+	"DELETE /api/orgs/api-keys/{id}":              {Summary: "Revoga chave de API (admin)", Status: http.StatusNoContent},
+	"GET /api/orgs/llm-credentials":               {Summary: "Chaves próprias de provedor de IA", Response: llmCredential{}, List: true},
+	"PUT /api/orgs/llm-credentials/{provider}":    {Summary: "Cadastra chave própria de IA (admin)", Request: openAPILLMKeyIn{}, Response: llmCredential{}},
+	"DELETE /api/orgs/llm-credentials/{provider}": {Summary: "Remove chave própria de IA (admin)", Status: http.StatusNoContent},
+	"GET /api/webhook-subscriptions":              {Summary: "Assinaturas de webhook", Response: webhookSubscription{}, List: true},
+	"GET /api/agent/personas":                     {Summary: "Personas do agente", Response: agentPersona{}, List: true},
+	"GET /api/agent/prompt/preview":               {Summary: "Prompt de sistema montado com as variáveis", Response: agentPromptPreview{}},
+	"GET /api/appointments":                       {Summary: "Agendamentos", Response: appointment{}, List: true},
+	"GET /api/subscriptions":                      {Summary: "Assinaturas recorrentes", Response: subscription{}, List: true},
+	"GET /api/stock-pools":                        {Summary: "Estoques compartilhados", Response: stockPool{}, List: true},
+	"GET /api/conversations":                      {Summary: "Inbox de conversas", Response: inboxConversation{}, List: true},
+	"GET /api/automations/stage-rules":            {Summary: "Regras de avanço automático de etapa", Response: leadStageRule{}, List: true},
+	"PUT /api/automations/stage-rules/{event}":    {Summary: "Configura a regra do evento (admin)", Request: leadStageRule{}, Response: leadStageRule{}},
+	"GET /api/admin/provider-usage":               {Summary: "Consumo e cotas dos provedores por org"},
+	"GET /api/admin/runtime":                      {Summary: "Memória, GC e mapas em memória da réplica", Response: runtimeStats{}},
+	"POST /api/admin/runtime/gc":                  {Summary: "Força um GC e devolve a memória ao SO", Response: runtimeStats{}},
+	"GET /api/admin/db/queries":                   {Summary: "Consultas SQL por fingerprint (tempo, lentas, erros)", Response: dbQueryStat{}, List: true},
+	"DELETE /api/admin/db/queries":                {Summary: "Zera a contagem de consultas", Status: http.StatusNoContent},
+	"GET /api/ai/status":                          {Summary: "Modo degradado da IA e mensagens guardadas", Response: aiFallbackState{}},
+	"POST /api/ai/status/release":                 {Summary: "Encerra o modo degradado e reprocessa as mensagens (admin)"},
+}
+
+type openAPIToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type openAPILeadIn struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Email   string `json:"email,omitempty"`
+	Stage   string `json:"stage,omitempty"`
+	RefCode string `json:"ref_code,omitempty"`
+}
+
+type openAPIOrderIn struct {
+	LeadID     int64  `json:"lead_id"`
+	TotalCents int    `json:"total_cents"`
+	Status     string `json:"status,omitempty"`
+	RefCode    string `json:"ref_code,omitempty"`
+	Items      []struct {
+		ProductID      int64 `json:"product_id"`
+		Qty            int   `json:"qty"`
+		UnitPriceCents int   `json:"unit_price_cents,omitempty"`
+	} `json:"items"`
+}
+
+type openAPILLMKeyIn struct {
+	APIKey  string `json:"api_key"`
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+func (a *App) mountOpenAPI(r chi.Router) {
+	var (
+		once sync.Once
+		doc  map[string]any
+		err  error
+	)
+	r.Get("/api/openapi.json", func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() { doc, err = buildOpenAPI(r) })
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out := make(map[string]any, len(doc)+1)
+		for k, v := range doc {
+			out[k] = v
+		}
+		out["servers"] = []map[string]any{{"url": openAPIServerURL(req)}}
+		render.OK(w, out)
+	})
+	if strings.EqualFold(os.Getenv("OPENAPI_DOCS"), "true") {
+		r.Get("/api/docs", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(swaggerUIPage))
+		})
+	}
+}
+
+const swaggerUIPage = `<!doctype html>
+<html><head><meta charset="utf-8"><title>PAC-LEAD API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css"></head>
+<body><div id="ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#ui", persistAuthorization: true});</script>
+</body></html>`
+
+func openAPIServerURL(r *http.Request) string {
+	if u := strings.TrimRight(strings.TrimSpace(os.Getenv("OPENAPI_SERVER_URL")), "/"); u != "" {
+		return u
+	}
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// openAPIAccess descreve quem pode chamar a rota. Espelha os grupos de
+// main.go; rota nova fora do padrão precisa de uma linha aqui.
+type openAPIAccessRule struct {
+	Security      []map[string][]string
+	TenantHeaders bool
+	InstanceToken bool
+}
+
+func openAPIAccess(method, path string) openAPIAccessRule {
+	jwt := map[string][]string{"bearerAuth": {}}
+	public := []map[string][]string{}
+	has := func(prefixes ...string) bool {
+		for _, p := range prefixes {
+			if path == p || strings.HasPrefix(path, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case strings.HasPrefix(path, agentv1.ServicePath):
+		return openAPIAccessRule{Security: []map[string][]string{{"internalToken": {}}}}
+	case has("/api/admin"):
+		return openAPIAccessRule{Security: []map[string][]string{{"adminToken": {}}}}
+	case path == "/metrics":
+		return openAPIAccessRule{Security: []map[string][]string{jwt}}
+	case has("/api/webhooks", "/api/orgs/resolve", "/api/cep", "/feeds", "/store", "/livez", "/healthz", "/readyz", "/api/openapi.json", "/api/docs"),
+		path == "/api/integrations/google-calendar/callback",
+		has("/api/auth") && path != "/api/auth/me" && path != "/api/auth/refresh":
+		return openAPIAccessRule{Security: public}
+	case path == "/api/company":
+		return openAPIAccessRule{Security: []map[string][]string{jwt}}
+	case has("/api/chat", "/api/vision", "/api/upload", "/api/wa", "/api/agent/settings", "/api/agent-config"):
+		rule := openAPIAccessRule{TenantHeaders: true, Security: []map[string][]string{jwt}}
+		if apiKeyRouteScope(method, path) != "" {
+			rule.Security = append(rule.Security, map[string][]string{"apiKey": {}})
+		}
+		// sem JWT/chave valem X-Org-ID/X-Flow-ID (ou o token da instância)
+		rule.Security = append(rule.Security, map[string][]string{})
+		rule.InstanceToken = has("/api/wa/instances/{instance}")
+		return rule
+	}
+	rule := openAPIAccessRule{Security: []map[string][]string{jwt}}
+	if apiKeyRouteScope(method, path) != "" {
+		rule.Security = append(rule.Security, map[string][]string{"apiKey": {}})
+	}
+	return rule
+}
+
+var (
+	openAPIParamRe = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+	openAPIAnnot   = regexp.MustCompile(`\{[^}]+\}`)
+)
+
+// openAPIPath normaliza o padrão do chi: sem regex nos parâmetros e sem a
+// barra final dos subrouters.
+func openAPIPath(pattern string) string {
+	p := openAPIParamRe.ReplaceAllString(pattern, "{$1}")
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+func buildOpenAPI(root chi.Routes) (map[string]any, error) {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	err := chi.Walk(root, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if method == http.MethodOptions || method == http.MethodHead || strings.Contains(route, "*") {
+			return nil
+		}
+		path := openAPIPath(route)
+		op := openAPIOperation(method, path, schemas)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = op
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	schemas["ErrorBody"] = openAPISchema(reflect.TypeOf(render.ErrorBody{}), schemas)
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "PAC-LEAD API",
+			"version":     appVersion(),
+			"description": "Gerada a partir das rotas registradas. Erros seguem {\"error\": {\"code\", \"message\"}}.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth":    map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":        map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"adminToken":    map[string]any{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+				"internalToken": map[string]any{"type": "apiKey", "in": "header", "name": agentv1.TokenHeader},
+			},
+			"parameters": map[string]any{
+				"OrgID":         openAPIHeader("X-Org-ID", "Org do tenant quando não há JWT", "integer"),
+				"FlowID":        openAPIHeader("X-Flow-ID", "Flow do tenant quando não há JWT", "integer"),
+				"InstanceToken": openAPIHeader("X-Instance-Token", "Token da instância, para chamadas sem tenant", "string"),
+				"IdempotencyKey": openAPIHeader("Idempotency-Key",
+					"Repetições com a mesma chave devolvem a resposta original (idempotency.go)", "string"),
+			},
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "Erro",
+					"content":     map[string]any{"application/json": map[string]any{"schema": openAPIRef("ErrorBody")}},
+				},
+			},
+		},
+	}, nil
+}
+
+func openAPIHeader(name, desc, typ string) map[string]any {
+	return map[string]any{"name": name, "in": "header", "required": false, "description": desc, "schema": map[string]any{"type": typ}}
+}
+
+func openAPIRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func openAPIOperation(method, path string, schemas map[string]any) map[string]any {
+	ann, annotated := openAPIOps[method+" "+path]
+	summary := ann.Summary
+	if !annotated {
+		summary = method + " " + path
+	}
+	op := map[string]any{
+		"summary":     summary,
+		"operationId": openAPIOperationID(method, path),
+		"tags":        []string{openAPITag(path)},
+	}
+	access := openAPIAccess(method, path)
+	op["security"] = access.Security
+
+	params := []map[string]any{}
+	for _, m := range openAPIAnnot.FindAllString(path, -1) {
+		params = append(params, map[string]any{
+			"name": strings.Trim(m, "{}"), "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	if access.TenantHeaders {
+		params = append(params, map[string]any{"$ref": "#/components/parameters/OrgID"}, map[string]any{"$ref": "#/components/parameters/FlowID"})
+	}
+	if access.InstanceToken {
+		params = append(params, map[string]any{"$ref": "#/components/parameters/InstanceToken"})
+	}
+	if ann.Idempotent {
+		params = append(params, map[string]any{"$ref": "#/components/parameters/IdempotencyKey"})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if ann.Request != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": openAPISchema(reflect.TypeOf(ann.Request), schemas)}},
+		}
+	} else if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
+		op["requestBody"] = map[string]any{
+			"required": false,
+			"content":  map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}},
+		}
+	}
+
+	status := ann.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := map[string]any{"description": http.StatusText(status)}
+	if status != http.StatusNoContent {
+		schema := map[string]any{"type": "object"}
+		if ann.Response != nil {
+			schema = openAPISchema(reflect.TypeOf(ann.Response), schemas)
+			if ann.List {
+				schema = map[string]any{"type": "object", "properties": map[string]any{
+					"items": map[string]any{"type": "array", "items": schema},
+				}}
+			}
+		}
+		ok["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+	}
+	op["responses"] = map[string]any{
+		fmt.Sprint(status): ok,
+		"default":          map[string]any{"$ref": "#/components/responses/Error"},
+	}
+	return op
+}
+
+func openAPITag(path string) string {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) > 1 && segs[0] == "api" {
+		segs = segs[1:]
+	}
+	if segs[0] == "" {
+		return "root"
+	}
+	return segs[0]
+}
+
+func openAPIOperationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	up := true
+	for _, r := range path {
+		switch {
+		case r == '/' || r == '-' || r == '_' || r == '.' || r == '{' || r == '}':
+			up = true
+		case up:
+			b.WriteString(strings.ToUpper(string(r)))
+			up = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+var openAPITimeType = reflect.TypeOf(time.Time{})
+
+// openAPISchema gera o schema de t a partir das tags json. Structs nomeadas
+// viram components/schemas e são referenciadas por $ref.
+func openAPISchema(t reflect.Type, schemas map[string]any) map[string]any {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+	var s map[string]any
+	switch {
+	case t == openAPITimeType:
+		s = map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(reflect.TypeOf((*json.Marshaler)(nil)).Elem()):
+		s = map[string]any{}
+	default:
+		switch t.Kind() {
+		case reflect.Bool:
+			s = map[string]any{"type": "boolean"}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			s = map[string]any{"type": "integer", "format": "int32"}
+		case reflect.Int64, reflect.Uint64:
+			s = map[string]any{"type": "integer", "format": "int64"}
+		case reflect.Float32, reflect.Float64:
+			s = map[string]any{"type": "number"}
+		case reflect.String:
+			s = map[string]any{"type": "string"}
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				s = map[string]any{"type": "string", "format": "byte"}
+			} else {
+				s = map[string]any{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+			}
+		case reflect.Map:
+			s = map[string]any{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+		case reflect.Struct:
+			if name := openAPISchemaName(t); name != "" {
+				if _, seen := schemas[name]; !seen {
+					schemas[name] = map[string]any{} // tipos recursivos
+					schemas[name] = openAPIStruct(t, schemas)
+				}
+				s = openAPIRef(name)
+			} else {
+				s = openAPIStruct(t, schemas)
+			}
+		default:
+			s = map[string]any{}
+		}
+	}
+	if nullable {
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+	}
+	return s
+}
+
+// openAPISchemaName: "Product", "apiKey" -> "ApiKey", "openAPILeadIn" ->
+// "LeadIn"; structs anônimas ficam inline ("").
+func openAPISchemaName(t reflect.Type) string {
+	n := strings.TrimPrefix(t.Name(), "openAPI")
+	if n == "" {
+		return ""
+	}
+	return strings.ToUpper(n[:1]) + n[1:]
+}
+
+func openAPIStruct(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = openAPISchema(f.Type, schemas)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ================================================================
+//  Baixa de estoque na criação do pedido
+// ================================================================
+//
+// O pedido reserva o estoque na mesma transação em que é gravado: cada item
+// sai de products.stock ou, se o produto está num pool, de
+// stock_pools.quantity (replicado aos produtos do pool, stock_pools.go).
+// Faltando estoque, a criação é recusada (orderStockError) e nada é gravado.
+// orders.stock_committed_at marca o pedido que já baixou estoque.
+//
+// Cancelamento (status "canceled") devolve as quantidades e limpa a marca.
+// Pedido sem baixa que chega a "paid" (criado antes desta regra, ou
+// reativado depois de cancelado) baixa nesse momento, sem recusar
+// (commitOrderStock). Renovações de assinatura também baixam sem recusar:
+// o ciclo já foi contratado.
+//
+// Não há estoque por variante no catálogo; a unidade é o produto.
+
+type orderStockError struct {
+	ProductID int64
+	Title     string
+	Requested int
+	Available int
+}
+
+func (e *orderStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %q: requested %d, available %d", e.Title, e.Requested, e.Available)
+}
+
+type orderStockLine struct {
+	productID int64
+	title     string
+	poolID    *int64
+	qty       int
+}
+
+// orderStockLines soma as quantidades do pedido por produto, na ordem de id
+// (ordem fixa de travamento entre transações concorrentes).
+func orderStockLines(ctx context.Context, tx pgx.Tx, orgID, orderID int64) ([]orderStockLine, error) {
+	rows, err := tx.Query(ctx, `
+SELECT p.id, p.title, p.stock_pool_id, SUM(oi.qty)::int
+  FROM order_items oi
+  JOIN products p ON p.id = oi.product_id AND p.org_id = $2
+ WHERE oi.order_id = $1
+ GROUP BY p.id, p.title, p.stock_pool_id
+ ORDER BY p.id`, orderID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []orderStockLine
+	for rows.Next() {
+		var l orderStockLine
+		if err := rows.Scan(&l.productID, &l.title, &l.poolID, &l.qty); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// applyOrderStockTx move o estoque dos itens do pedido: dir=-1 baixa, dir=+1
+// devolve. Com strict, a baixa que deixaria o saldo negativo falha com
+// *orderStockError; sem strict, o saldo para em zero.
+func applyOrderStockTx(ctx context.Context, tx pgx.Tx, orgID, orderID int64, dir int, strict bool) error {
+	lines, err := orderStockLines(ctx, tx, orgID, orderID)
+	if err != nil {
+		return err
+	}
+	// produtos do mesmo pool somam na mesma conta
+	poolQty := map[int64]int{}
+	var poolOrder []int64
+	for _, l := range lines {
+		delta := dir * l.qty
+		if l.poolID != nil {
+			if _, ok := poolQty[*l.poolID]; !ok {
+				poolOrder = append(poolOrder, *l.poolID)
+			}
+			poolQty[*l.poolID] += delta
+			continue
+		}
+		var left int
+		err := tx.QueryRow(ctx, `
+UPDATE products SET stock = GREATEST(stock + $3, 0)
+ WHERE id=$1 AND org_id=$2 AND (NOT $4 OR stock + $3 >= 0)
+RETURNING stock`, l.productID, orgID, delta, strict && dir < 0).Scan(&left)
+		if errors.Is(err, pgx.ErrNoRows) {
+			var avail int
+			_ = tx.QueryRow(ctx, `SELECT stock FROM products WHERE id=$1`, l.productID).Scan(&avail)
+			return &orderStockError{ProductID: l.productID, Title: l.title, Requested: l.qty, Available: avail}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range poolOrder {
+		delta := poolQty[id]
+		tag, err := tx.Exec(ctx, `
+UPDATE stock_pools SET quantity = GREATEST(quantity + $3, 0), updated_at = NOW()
+ WHERE id=$1 AND org_id=$2 AND (NOT $4 OR quantity + $3 >= 0)`, id, orgID, delta, strict && dir < 0)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			var avail int
+			_ = tx.QueryRow(ctx, `SELECT quantity FROM stock_pools WHERE id=$1`, id).Scan(&avail)
+			e := &orderStockError{Requested: -delta, Available: avail}
+			for _, l := range lines {
+				if l.poolID != nil && *l.poolID == id {
+					e.ProductID, e.Title = l.productID, l.title
+					break
+				}
+			}
+			return e
+		}
+	}
+	if len(poolOrder) > 0 {
+		return syncPoolProducts(ctx, tx, poolOrder)
+	}
+	return nil
+}
+
+// reserveOrderStockTx baixa o estoque de um pedido recém-gravado (itens já
+// inseridos) e marca stock_committed_at. strict=false não recusa por falta.
+func reserveOrderStockTx(ctx context.Context, tx pgx.Tx, orgID, orderID int64, strict bool) error {
+	tag, err := tx.Exec(ctx, `UPDATE orders SET stock_committed_at = NOW() WHERE id=$1 AND stock_committed_at IS NULL`, orderID)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	return applyOrderStockTx(ctx, tx, orgID, orderID, -1, strict)
+}
+
+// releaseOrderStock devolve ao estoque o que o pedido baixou. Pedido sem
+// baixa: nada a fazer.
+func (a *App) releaseOrderStock(ctx context.Context, orgID, orderID int64) error {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, `
+UPDATE orders SET stock_committed_at = NULL WHERE id=$1 AND org_id=$2 AND stock_committed_at IS NOT NULL`, orderID, orgID)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	if err := applyOrderStockTx(ctx, tx, orgID, orderID, +1, false); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	a.touchProductFeed(orgID, 0)
+	return nil
+}
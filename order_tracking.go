@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Rastreamento de entregas e avisos ao cliente
+// ================================================================
+//
+// PUT  /api/orders/{id}/tracking          {"tracking_code":"AA123456789BR","notify":true}
+// GET  /api/orders/{id}/tracking          código, último status e eventos
+// POST /api/orders/{id}/tracking/refresh  consulta a transportadora agora
+//
+// Um job (TRACKING_POLL_INTERVAL, padrão 2h; 0 desliga) consulta os pedidos
+// com código e ainda não entregues. A cada evento novo o lead recebe uma
+// mensagem no WhatsApp pela instância da última conversa com ele (ou pela
+// primeira instância ativa do tenant). Responder SAIR/PARAR desliga os
+// avisos do lead (leads.tracking_opt_out_at); "notify": false desliga só o
+// pedido. Na API oficial a mensagem só sai com a janela de 24h aberta.
+
+const carrierCorreios = "correios"
+
+func (a *App) mountOrderTracking(r chi.Router) {
+	r.Get("/orders/{id}/tracking", a.getOrderTracking)
+	r.Put("/orders/{id}/tracking", a.putOrderTracking)
+	r.Post("/orders/{id}/tracking/refresh", a.refreshOrderTrackingHandler)
+
+	if every := trackingPollInterval(); every > 0 {
+		go a.trackingPollLoop(every)
+	}
+}
+
+func trackingPollInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("TRACKING_POLL_INTERVAL", "2h"))
+	if err != nil || d < 0 {
+		return 2 * time.Hour
+	}
+	return d
+}
+
+// orderTracking é a visão do rastreio devolvida pela API.
+type orderTracking struct {
+	OrderID      int64           `json:"order_id"`
+	Carrier      string          `json:"carrier"`
+	TrackingCode string          `json:"tracking_code"`
+	Status       string          `json:"status,omitempty"`
+	CheckedAt    *time.Time      `json:"checked_at,omitempty"`
+	DeliveredAt  *time.Time      `json:"delivered_at,omitempty"`
+	Notify       bool            `json:"notify"`
+	Events       []trackingEvent `json:"events"`
+}
+
+// orderIDForTenant lê o {id} da URL e confere que o pedido é do tenant.
+func (a *App) orderIDForTenant(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid order id")
+		return 0, false
+	}
+	var ok bool
+	if err := a.DB.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM orders WHERE id=$1 AND org_id=$2 AND flow_id=$3)`,
+		id, orgID, flowID).Scan(&ok); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return 0, false
+	}
+	if !ok {
+		render.Error(w, http.StatusNotFound, "order not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func (a *App) loadOrderTracking(ctx context.Context, orderID int64) (orderTracking, error) {
+	t := orderTracking{OrderID: orderID, Events: []trackingEvent{}}
+	err := a.DB.QueryRow(ctx, `
+SELECT COALESCE(carrier,''), COALESCE(tracking_code,''), COALESCE(tracking_status,''), tracking_checked_at, delivered_at, tracking_notify
+  FROM orders WHERE id=$1`, orderID).
+		Scan(&t.Carrier, &t.TrackingCode, &t.Status, &t.CheckedAt, &t.DeliveredAt, &t.Notify)
+	if err != nil {
+		return t, err
+	}
+	rows, err := a.DB.Query(ctx, `
+SELECT code, description, COALESCE(location,''), event_at
+  FROM order_tracking_events WHERE order_id=$1 ORDER BY event_at, id`, orderID)
+	if err != nil {
+		return t, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e trackingEvent
+		if err := rows.Scan(&e.Code, &e.Description, &e.Location, &e.At); err != nil {
+			return t, err
+		}
+		e.Delivered = t.DeliveredAt != nil && e.At.Equal(*t.DeliveredAt)
+		t.Events = append(t.Events, e)
+	}
+	return t, rows.Err()
+}
+
+// GET /api/orders/{id}/tracking
+func (a *App) getOrderTracking(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.orderIDForTenant(w, r)
+	if !ok {
+		return
+	}
+	t, err := a.loadOrderTracking(r.Context(), id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, t)
+}
+
+// PUT /api/orders/{id}/tracking
+func (a *App) putOrderTracking(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.orderIDForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		TrackingCode *string `json:"tracking_code"`
+		Carrier      string  `json:"carrier"`
+		Notify       *bool   `json:"notify"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	carrier := strings.ToLower(nonEmpty(strings.TrimSpace(in.Carrier), carrierCorreios))
+	if carrier != carrierCorreios {
+		render.Error(w, http.StatusBadRequest, "unsupported carrier (only correios)")
+		return
+	}
+	ctx := r.Context()
+	if in.TrackingCode != nil {
+		code := ""
+		if strings.TrimSpace(*in.TrackingCode) != "" {
+			var err error
+			if code, err = normalizeTrackingCode(*in.TrackingCode); err != nil {
+				render.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		// código novo: o histórico anterior não vale mais
+		_, err := a.DB.Exec(ctx, `
+WITH upd AS (
+  UPDATE orders SET carrier=$2, tracking_code=NULLIF($3,''), tracking_status=NULL, tracking_checked_at=NULL, delivered_at=NULL
+   WHERE id=$1 AND tracking_code IS DISTINCT FROM NULLIF($3,'')
+  RETURNING id
+)
+DELETE FROM order_tracking_events WHERE order_id IN (SELECT id FROM upd)`, id, carrier, code)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if in.Notify != nil {
+		if _, err := a.DB.Exec(ctx, `UPDATE orders SET tracking_notify=$2 WHERE id=$1`, id, *in.Notify); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	t, err := a.loadOrderTracking(ctx, id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, t)
+}
+
+// POST /api/orders/{id}/tracking/refresh
+func (a *App) refreshOrderTrackingHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.orderIDForTenant(w, r)
+	if !ok {
+		return
+	}
+	fresh, err := a.refreshOrderTracking(r.Context(), id)
+	switch {
+	case errors.Is(err, errCorreiosNotConfigured):
+		render.Error(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	t, err := a.loadOrderTracking(r.Context(), id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"tracking": t, "new_events": len(fresh)})
+}
+
+// refreshOrderTracking consulta a transportadora, grava os eventos novos e
+// avisa o lead sobre o mais recente deles. Devolve os eventos novos.
+func (a *App) refreshOrderTracking(ctx context.Context, orderID int64) ([]trackingEvent, error) {
+	var orgID, flowID int64
+	var leadID *int64
+	var code string
+	var notify bool
+	err := a.DB.QueryRow(ctx, `
+SELECT org_id, flow_id, lead_id, COALESCE(tracking_code,''), tracking_notify FROM orders WHERE id=$1`, orderID).
+		Scan(&orgID, &flowID, &leadID, &code, &notify)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, nil
+	}
+
+	events, err := correiosTrack(ctx, code)
+	if errors.Is(err, errTrackingNotFound) {
+		// ainda não postado: tenta de novo no próximo ciclo
+		_, err = a.DB.Exec(ctx, `UPDATE orders SET tracking_checked_at=NOW() WHERE id=$1`, orderID)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fresh []trackingEvent
+	for _, e := range events {
+		tag, err := a.DB.Exec(ctx, `
+INSERT INTO order_tracking_events (order_id, code, description, location, event_at)
+VALUES ($1, $2, $3, NULLIF($4,''), $5)
+ON CONFLICT (order_id, code, event_at) DO NOTHING`, orderID, e.Code, e.Description, e.Location, e.At)
+		if err != nil {
+			return nil, err
+		}
+		if tag.RowsAffected() > 0 {
+			fresh = append(fresh, e)
+		}
+	}
+	latest := events[len(events)-1]
+	var deliveredAt *time.Time
+	if latest.Delivered {
+		deliveredAt = &latest.At
+	}
+	if _, err := a.DB.Exec(ctx, `
+UPDATE orders SET tracking_status=$2, tracking_checked_at=NOW(), delivered_at=COALESCE(delivered_at, $3) WHERE id=$1`,
+		orderID, latest.Description, deliveredAt); err != nil {
+		return nil, err
+	}
+
+	// um aviso por consulta, com o evento mais recente; os demais ficam só
+	// no histórico
+	if len(fresh) > 0 && notify && leadID != nil {
+		ev := fresh[len(fresh)-1]
+		if err := a.notifyTrackingUpdate(ctx, orgID, flowID, *leadID, orderID, code, ev); err != nil {
+			log.Printf("tracking notify order=%d: %v", orderID, err)
+		} else {
+			_, _ = a.DB.Exec(ctx, `UPDATE order_tracking_events SET notified_at=NOW() WHERE order_id=$1 AND code=$2 AND event_at=$3`,
+				orderID, ev.Code, ev.At)
+		}
+	}
+	return fresh, nil
+}
+
+// notifyTrackingUpdate envia o status ao lead pelo WhatsApp.
+func (a *App) notifyTrackingUpdate(ctx context.Context, orgID, flowID, leadID, orderID int64, code string, ev trackingEvent) error {
+	var optedOut bool
+	err := a.DB.QueryRow(ctx,
+		`SELECT tracking_opt_out_at IS NOT NULL FROM leads WHERE id=$1 AND org_id=$2`,
+		leadID, orgID).Scan(&optedOut)
+	if err != nil {
+		return err
+	}
+	if optedOut {
+		return nil
+	}
+	row, contact, err := a.leadWAContact(ctx, orgID, flowID, leadID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Pedido #%d: %s", orderID, ev.Description)
+	if ev.Location != "" {
+		text += " (" + ev.Location + ")"
+	}
+	text += fmt.Sprintf("\nCódigo de rastreio: %s", code)
+	text += "\n\nResponda SAIR para não receber mais atualizações de entrega."
+	_, _, err = a.sendWAText(ctx, row, row.Token, contact, text)
+	return err
+}
+
+// leadWAContact escolhe por onde falar com o lead: a instância e o contato
+// da última conversa ou, sem conversa, a instância ativa do tenant e o
+// telefone do lead.
+func (a *App) leadWAContact(ctx context.Context, orgID, flowID, leadID int64) (waInstanceRow, string, error) {
+	var instance, contact string
+	err := a.DB.QueryRow(ctx, `
+SELECT c.instance_id, c.contact FROM public.conversations c
+  JOIN public.wa_instances i ON i.instance_id = c.instance_id AND i.deleted_at IS NULL
+ WHERE c.lead_id=$1 AND c.org_id=$2
+ ORDER BY c.last_message_at DESC NULLS LAST LIMIT 1`, leadID, orgID).Scan(&instance, &contact)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var phone string
+		if err = a.DB.QueryRow(ctx,
+			`SELECT COALESCE(phone,'') FROM leads WHERE id=$1 AND org_id=$2`, leadID, orgID).Scan(&phone); err != nil {
+			return waInstanceRow{}, "", err
+		}
+		contact = onlyDigits(revealPII(orgID, phone))
+		err = a.DB.QueryRow(ctx, `
+SELECT instance_id FROM public.wa_instances
+ WHERE org_id=$1 AND flow_id=$2 AND deleted_at IS NULL
+ ORDER BY (state = 'connected') DESC, updated_at DESC LIMIT 1`, orgID, flowID).Scan(&instance)
+	}
+	if errors.Is(err, pgx.ErrNoRows) || contact == "" {
+		return waInstanceRow{}, "", errors.New("no whatsapp instance or phone for lead")
+	}
+	if err != nil {
+		return waInstanceRow{}, "", err
+	}
+	row, err := a.fetchWAInstance(ctx, instance)
+	return row, contact, err
+}
+
+// isTrackingOptOut reconhece o pedido de descadastro nos avisos.
+func isTrackingOptOut(text string) bool {
+	switch strings.ToUpper(strings.Trim(strings.TrimSpace(text), ".!")) {
+	case "SAIR", "PARAR", "STOP":
+		return true
+	}
+	return false
+}
+
+// trackingOptOut desliga os avisos do lead (só se ele tiver pedidos com
+// rastreio) e confirma pelo mesmo número.
+func (a *App) trackingOptOut(ctx context.Context, row waInstanceRow, leadID int64, contact string) {
+	tag, err := a.DB.Exec(ctx, `
+UPDATE leads SET tracking_opt_out_at=NOW()
+ WHERE id=$1 AND tracking_opt_out_at IS NULL
+   AND EXISTS (SELECT 1 FROM orders WHERE lead_id=$1 AND tracking_code IS NOT NULL)`, leadID)
+	if err != nil {
+		log.Printf("tracking opt-out lead=%d: %v", leadID, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		return
+	}
+	if _, _, err := a.sendWAText(ctx, row, row.Token, contact, "Pronto, você não receberá mais atualizações de entrega por aqui."); err != nil {
+		log.Printf("tracking opt-out lead=%d: confirm: %v", leadID, err)
+	}
+}
+
+// trackingPollLoop consulta periodicamente os pedidos em trânsito.
+func (a *App) trackingPollLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), every)
+		rows, err := a.DB.Query(ctx, `
+SELECT id FROM orders
+ WHERE tracking_code IS NOT NULL AND delivered_at IS NULL
+   AND created_at > NOW() - INTERVAL '90 days'
+   AND (tracking_checked_at IS NULL OR tracking_checked_at < NOW() - $1::interval)
+ ORDER BY tracking_checked_at NULLS FIRST LIMIT 500`, fmt.Sprintf("%d seconds", int(every.Seconds()/2)))
+		if err != nil {
+			cancel()
+			log.Printf("tracking poll: %v", err)
+			continue
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		for _, id := range ids {
+			if _, err := a.refreshOrderTracking(ctx, id); err != nil {
+				log.Printf("tracking poll order=%d: %v", id, err)
+				if errors.Is(err, errCorreiosNotConfigured) {
+					break
+				}
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/llm"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Chave própria de provedor de IA por org (BYOK)
+// ================================================================
+//
+// A org pode cadastrar a própria chave da OpenAI (ou Anthropic/Gemini);
+// chat, visão e campanhas daquela org passam a usá-la em llmFor, com o
+// consumo e os limites de taxa na conta do cliente. Sem chave cadastrada
+// vale a da plataforma, como antes.
+//
+// GET    /api/orgs/llm-credentials             provedores cadastrados, só o final da chave
+// PUT    /api/orgs/llm-credentials/{provider}  (admin) {api_key, base_url?}
+// DELETE /api/orgs/llm-credentials/{provider}  (admin) volta para a chave da plataforma
+//
+// A chave é validada no provedor antes de gravar e guardada cifrada com a
+// chave da org (pii.go); sem PII_MASTER_KEY o cadastro fica desligado (503).
+// Se a chave da org falhar (revogada, sem saldo), a chamada falha também:
+// LLM_BYOK_PLATFORM_FALLBACK=true põe a chave da plataforma como último
+// recurso. O uso com a chave da org é gravado em ai_usage com byok=true e
+// não conta no orçamento mensal nem nas cotas globais (provider_quota.go).
+// Embeddings da base de conhecimento continuam na chave da plataforma.
+
+var byokProviders = []string{llm.OpenAI, llm.Anthropic, llm.Gemini}
+
+func (a *App) mountLLMCredentials(r chi.Router) {
+	r.Get("/orgs/llm-credentials", a.listLLMCredentials)
+	r.With(a.requireRole(roleAdmin)).Put("/orgs/llm-credentials/{provider}", a.putLLMCredential)
+	r.With(a.requireRole(roleAdmin)).Delete("/orgs/llm-credentials/{provider}", a.deleteLLMCredential)
+}
+
+type llmCredential struct {
+	Provider   string     `json:"provider"`
+	KeyHint    string     `json:"key_hint"`
+	BaseURL    string     `json:"base_url,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func byokProvider(r *http.Request) (string, bool) {
+	p := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "provider")))
+	return p, hasScope(byokProviders, p)
+}
+
+func llmKeyHint(key string) string {
+	if len(key) <= 8 {
+		return "…"
+	}
+	return "…" + key[len(key)-4:]
+}
+
+// GET /api/orgs/llm-credentials
+func (a *App) listLLMCredentials(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT provider, key_hint, COALESCE(base_url,''), verified_at, created_at, updated_at
+  FROM public.org_llm_credentials WHERE org_id=$1 ORDER BY provider`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []llmCredential{}
+	for rows.Next() {
+		var c llmCredential
+		if err := rows.Scan(&c.Provider, &c.KeyHint, &c.BaseURL, &c.VerifiedAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, c)
+	}
+	render.OK(w, map[string]any{"items": out, "providers": byokProviders, "enabled": piiEnabled()})
+}
+
+// PUT /api/orgs/llm-credentials/{provider}
+func (a *App) putLLMCredential(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	provider, ok := byokProvider(r)
+	if !ok {
+		render.Error(w, http.StatusBadRequest, "provider must be one of "+strings.Join(byokProviders, ", "))
+		return
+	}
+	if !piiEnabled() {
+		render.Error(w, http.StatusServiceUnavailable, "PII_MASTER_KEY required to store provider credentials")
+		return
+	}
+	var in struct {
+		APIKey  string `json:"api_key"`
+		BaseURL string `json:"base_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.APIKey, in.BaseURL = strings.TrimSpace(in.APIKey), strings.TrimRight(strings.TrimSpace(in.BaseURL), "/")
+	if in.APIKey == "" {
+		render.Error(w, http.StatusBadRequest, "api_key required")
+		return
+	}
+	if in.BaseURL != "" {
+		if u, err := url.Parse(in.BaseURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			render.Error(w, http.StatusBadRequest, "base_url must be an https URL")
+			return
+		}
+	}
+	cfg := llm.ConfigFromEnv(provider)
+	cfg.APIKey = in.APIKey
+	if in.BaseURL != "" {
+		cfg.BaseURL = in.BaseURL
+	}
+	if err := verifyLLMKey(r.Context(), cfg); err != nil {
+		render.Error(w, http.StatusUnprocessableEntity, "provider rejected the key: "+err.Error())
+		return
+	}
+	enc, err := encryptPII(orgID, piiKeyVersion(r.Context(), a.DB, orgID), in.APIKey)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c := llmCredential{Provider: provider, KeyHint: llmKeyHint(in.APIKey), BaseURL: in.BaseURL}
+	err = a.DB.QueryRow(r.Context(), `
+INSERT INTO public.org_llm_credentials (org_id, provider, api_key, base_url, key_hint, verified_at)
+VALUES ($1, $2, $3, NULLIF($4,''), $5, NOW())
+ON CONFLICT (org_id, provider) DO UPDATE
+   SET api_key=EXCLUDED.api_key, base_url=EXCLUDED.base_url, key_hint=EXCLUDED.key_hint,
+       verified_at=EXCLUDED.verified_at, updated_at=NOW()
+RETURNING verified_at, created_at, updated_at`,
+		orgID, provider, enc, in.BaseURL, c.KeyHint).Scan(&c.VerifiedAt, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, c)
+}
+
+// DELETE /api/orgs/llm-credentials/{provider}
+func (a *App) deleteLLMCredential(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	provider, ok := byokProvider(r)
+	if !ok {
+		render.Error(w, http.StatusBadRequest, "provider must be one of "+strings.Join(byokProviders, ", "))
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `DELETE FROM public.org_llm_credentials WHERE org_id=$1 AND provider=$2`, orgID, provider)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "credential not found")
+		return
+	}
+	render.NoContent(w)
+}
+
+// verifyLLMKey lista os modelos do provedor com a chave: barato, não gasta
+// tokens e separa chave inválida (401/403) de indisponibilidade.
+func verifyLLMKey(ctx context.Context, cfg llm.Config) error {
+	var endpoint string
+	switch cfg.Provider {
+	case llm.OpenAI:
+		endpoint = firstNonEmpty(strings.TrimRight(cfg.BaseURL, "/"), "https://api.openai.com/v1") + "/models"
+	case llm.Anthropic:
+		endpoint = strings.TrimRight(cfg.BaseURL, "/") + "/v1/models"
+	case llm.Gemini:
+		endpoint = strings.TrimRight(cfg.BaseURL, "/") + "/models?key=" + url.QueryEscape(cfg.APIKey)
+	default:
+		return fmt.Errorf("unsupported provider %q", cfg.Provider)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	switch cfg.Provider {
+	case llm.OpenAI:
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	case llm.Anthropic:
+		req.Header.Set("x-api-key", cfg.APIKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	}
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		// não vaza a chave do Gemini que vai na URL
+		return errors.New("provider unreachable")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// orgLLMConfig devolve a configuração do provedor com a chave da org, se
+// houver. Falha ao decifrar é logada e cai na chave da plataforma.
+func (a *App) orgLLMConfig(ctx context.Context, orgID int64, provider string) (llm.Config, bool) {
+	cfg := llm.ConfigFromEnv(provider)
+	if orgID <= 0 || !hasScope(byokProviders, cfg.Provider) {
+		return cfg, false
+	}
+	var enc, baseURL string
+	err := a.DB.QueryRow(ctx, `
+SELECT api_key, COALESCE(base_url,'') FROM public.org_llm_credentials WHERE org_id=$1 AND provider=$2`,
+		orgID, cfg.Provider).Scan(&enc, &baseURL)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("llm credentials org=%d %s: %v", orgID, cfg.Provider, err)
+		}
+		return cfg, false
+	}
+	key, err := decryptPII(orgID, enc)
+	if err != nil || key == "" {
+		log.Printf("llm credentials org=%d %s: decrypt: %v", orgID, cfg.Provider, err)
+		return cfg, false
+	}
+	cfg.APIKey = key
+	if baseURL != "" {
+		cfg.BaseURL = baseURL
+	}
+	return cfg, true
+}
+
+func byokPlatformFallback() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("LLM_BYOK_PLATFORM_FALLBACK")), "true")
+}
+
+// llmProviderOfModel deduz o provedor pelo nome do modelo gravado em ai_usage.
+func llmProviderOfModel(model string) string {
+	m := strings.ToLower(strings.TrimSpace(model))
+	switch {
+	case strings.HasPrefix(m, "ollama/"):
+		return llm.Ollama
+	case strings.HasPrefix(m, "claude"):
+		return llm.Anthropic
+	case strings.HasPrefix(m, "gemini"):
+		return llm.Gemini
+	}
+	return llm.OpenAI
+}
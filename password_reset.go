@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ================================================================
+//  Recuperação de senha
+// ================================================================
+//
+// POST /api/auth/forgot-password {email}  -> sempre 202 (não revela se o
+//   e-mail existe); se existir, envia um link com token de uso único.
+// POST /api/auth/reset-password {token, password}
+//
+// Só o hash SHA-256 do token fica em password_resets. Validade:
+// PASSWORD_RESET_TTL (padrão 1h). Link: PASSWORD_RESET_URL?token=...
+// Tabela em migrations/0056_password_resets.sql.
+
+func passwordResetTTL() time.Duration {
+	if d, err := time.ParseDuration(getenv("PASSWORD_RESET_TTL", "1h")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// POST /auth/forgot-password
+func (a *App) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Email = strings.TrimSpace(strings.ToLower(in.Email))
+	if in.Email == "" {
+		render.Error(w, http.StatusBadRequest, "email required")
+		return
+	}
+
+	var userID int64
+	var name string
+	err := a.DB.QueryRow(r.Context(),
+		`SELECT id, name FROM users WHERE LOWER(email)=LOWER($1)`, in.Email).Scan(&userID, &name)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err == nil {
+		token := secureToken(32)
+		if _, err := a.DB.Exec(r.Context(), `
+INSERT INTO public.password_resets (user_id, token_hash, expires_at, requested_ip)
+VALUES ($1, $2, $3, $4)`, userID, hashResetToken(token), time.Now().Add(passwordResetTTL()), clientIP(r).String()); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// envio fora da requisição: o tempo de resposta não denuncia o e-mail
+		go a.sendPasswordResetEmail(in.Email, name, token)
+	}
+
+	render.Accepted(w, map[string]any{"ok": true})
+}
+
+func (a *App) sendPasswordResetEmail(email, name, token string) {
+	link := getenv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password")
+	sep := "?"
+	if strings.Contains(link, "?") {
+		sep = "&"
+	}
+	link += sep + "token=" + token
+	msg := emailMessage{
+		To:      email,
+		Subject: "Redefinição de senha",
+		Text: fmt.Sprintf("Olá %s,\n\nRecebemos um pedido para redefinir sua senha. Acesse o link abaixo (válido por %s):\n\n%s\n\nSe não foi você, ignore este e-mail.\n",
+			name, passwordResetTTL(), link),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := emailSenderFromEnv().Send(ctx, msg); err != nil {
+		log.Printf("password reset email to %s: %v", email, err)
+	}
+}
+
+// POST /auth/reset-password
+func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Token = strings.TrimSpace(in.Token)
+	if in.Token == "" || in.Password == "" {
+		render.Error(w, http.StatusBadRequest, "token and password required")
+		return
+	}
+	if len(in.Password) < 8 {
+		render.Error(w, http.StatusBadRequest, "password must have at least 8 characters")
+		return
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// consome o token (uso único) de forma atômica
+	var userID int64
+	err = tx.QueryRow(ctx, `
+UPDATE public.password_resets SET used_at=NOW()
+ WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id`, hashResetToken(in.Token)).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET password=$1 WHERE id=$2`, string(hashed), userID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// invalida outros links pendentes do mesmo usuário
+	if _, err := tx.Exec(ctx,
+		`UPDATE public.password_resets SET used_at=NOW() WHERE user_id=$1 AND used_at IS NULL`, userID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"ok": true})
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Pagamentos: links de cobrança por pedido
+// ================================================================
+//
+// GET    /api/payments/accounts                 contas configuradas da org
+// PUT    /api/payments/accounts/{provider}      {"credentials":{...},"webhook_secret","active"} (admin)
+// DELETE /api/payments/accounts/{provider}      (admin)
+// POST   /api/orders/{id}/payment-link          {"provider"} -> {"url","pix_code",...}
+// GET    /api/orders/{id}/payment-links
+// POST   /api/webhooks/payments/{provider}?account={id}   notificação do provedor (pública)
+//
+// Provedores (payments_providers.go) e credenciais:
+//
+//   mercadopago  {"access_token"}; checkout com cartão, Pix e boleto
+//   stripe       {"secret_key","currency"}; webhook_secret = whsec_... do painel
+//   pix          {"key","merchant_name","city"}; gera o Pix copia e cola
+//                estático, confirmado por POST assinado do PSP/automação
+//
+// Credenciais e segredo do webhook são gravados cifrados com a chave da org
+// (pii.go). A notificação confirmada marca o link e o pedido como pagos e
+// publica order.paid (webhooks de saída, assinaturas, analytics). A URL de
+// notificação usa PUBLIC_API_URL; links valem PAYMENT_LINK_TTL (padrão 24h) e
+// um link pendente do mesmo provedor e valor é reaproveitado.
+
+const (
+	paymentLinkPending = "pending"
+	paymentLinkPaid    = "paid"
+	paymentLinkExpired = "expired"
+)
+
+func (a *App) mountPayments(r chi.Router) {
+	auth := r.With(a.requireAuth)
+	admin := r.With(a.requireAuth, a.requireRole(roleAdmin))
+	auth.Get("/payments/accounts", a.listPaymentAccounts)
+	admin.Put("/payments/accounts/{provider}", a.putPaymentAccount)
+	admin.Delete("/payments/accounts/{provider}", a.deletePaymentAccount)
+	auth.Post("/orders/{id}/payment-link", a.createPaymentLinkHandler)
+	auth.Get("/orders/{id}/payment-links", a.listPaymentLinks)
+
+	r.Post("/webhooks/payments/{provider}", a.paymentWebhook)
+}
+
+func paymentLinkTTL() time.Duration {
+	d, err := time.ParseDuration(getenv("PAYMENT_LINK_TTL", "24h"))
+	if err != nil || d <= 0 {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// paymentWebhookURL é a URL de notificação da conta ("" sem PUBLIC_API_URL).
+func paymentWebhookURL(provider string, accountID int64) string {
+	base := strings.TrimRight(getenv("PUBLIC_API_URL", ""), "/")
+	if base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/webhooks/payments/%s?account=%d", base, provider, accountID)
+}
+
+// paymentAccount é a conta de um provedor na org. Credenciais nunca saem na API.
+type paymentAccount struct {
+	ID         int64     `json:"id"`
+	OrgID      int64     `json:"org_id"`
+	Provider   string    `json:"provider"`
+	Active     bool      `json:"active"`
+	WebhookURL string    `json:"webhook_url,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	creds         map[string]string
+	webhookSecret string
+}
+
+const paymentAccountColumns = `id, org_id, provider, active, created_at, updated_at, credentials, COALESCE(webhook_secret,'')`
+
+func scanPaymentAccount(row pgx.Row) (paymentAccount, error) {
+	var (
+		acc   paymentAccount
+		creds string
+	)
+	if err := row.Scan(&acc.ID, &acc.OrgID, &acc.Provider, &acc.Active, &acc.CreatedAt, &acc.UpdatedAt,
+		&creds, &acc.webhookSecret); err != nil {
+		return acc, err
+	}
+	acc.WebhookURL = paymentWebhookURL(acc.Provider, acc.ID)
+	acc.webhookSecret = revealPII(acc.OrgID, acc.webhookSecret)
+	acc.creds = map[string]string{}
+	if plain := revealPII(acc.OrgID, creds); plain != "" {
+		if err := json.Unmarshal([]byte(plain), &acc.creds); err != nil {
+			return acc, fmt.Errorf("payment account %d credentials: %w", acc.ID, err)
+		}
+	}
+	return acc, nil
+}
+
+// paymentAccountFor devolve a conta ativa do provedor; sem provedor, a conta
+// ativa mais antiga da org.
+func (a *App) paymentAccountFor(ctx context.Context, orgID int64, provider string) (paymentAccount, error) {
+	return scanPaymentAccount(a.DB.QueryRow(ctx, `
+SELECT `+paymentAccountColumns+` FROM payment_accounts
+ WHERE org_id=$1 AND active AND ($2 = '' OR provider=$2)
+ ORDER BY id LIMIT 1`, orgID, provider))
+}
+
+// GET /api/payments/accounts
+func (a *App) listPaymentAccounts(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(),
+		`SELECT `+paymentAccountColumns+` FROM payment_accounts WHERE org_id=$1 ORDER BY id`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []paymentAccount{}
+	for rows.Next() {
+		acc, err := scanPaymentAccount(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, acc)
+	}
+	if err := rows.Err(); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	names := make([]string, 0, len(paymentProviders))
+	for name := range paymentProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	render.OK(w, map[string]any{"items": out, "providers": names})
+}
+
+// PUT /api/payments/accounts/{provider}
+func (a *App) putPaymentAccount(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := strings.ToLower(chi.URLParam(r, "provider"))
+	provider, ok := paymentProviders[name]
+	if !ok {
+		render.Error(w, http.StatusNotFound, "unknown payment provider")
+		return
+	}
+	var in struct {
+		Credentials   map[string]string `json:"credentials"`
+		WebhookSecret *string           `json:"webhook_secret,omitempty"`
+		Active        *bool             `json:"active,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	current, err := a.paymentAccountByProvider(ctx, orgID, name)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	exists := err == nil
+
+	// credenciais parciais completam as já gravadas (ex.: trocar só a moeda)
+	creds := map[string]string{}
+	for k, v := range current.creds {
+		creds[k] = v
+	}
+	for k, v := range in.Credentials {
+		if v = strings.TrimSpace(v); v == "" {
+			delete(creds, k)
+		} else {
+			creds[k] = v
+		}
+	}
+	if err := provider.validate(creds); err != nil {
+		render.Error(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	secret := current.webhookSecret
+	if in.WebhookSecret != nil {
+		secret = strings.TrimSpace(*in.WebhookSecret)
+	}
+	generated := false
+	if secret == "" && name == paymentPix {
+		// no Pix quem assina é a nossa automação: geramos o segredo
+		secret, generated = secureToken(24), true
+	}
+	active := !exists || current.Active
+	if in.Active != nil {
+		active = *in.Active
+	}
+
+	ver := piiKeyVersion(ctx, a.DB, orgID)
+	rawCreds, _ := json.Marshal(creds)
+	encCreds, err := encryptPII(orgID, ver, string(rawCreds))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	encSecret, err := encryptPII(orgID, ver, secret)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	acc, err := scanPaymentAccount(a.DB.QueryRow(ctx, `
+INSERT INTO payment_accounts (org_id, provider, credentials, webhook_secret, active)
+VALUES ($1, $2, $3, NULLIF($4,''), $5)
+ON CONFLICT (org_id, provider) DO UPDATE
+SET credentials=EXCLUDED.credentials, webhook_secret=EXCLUDED.webhook_secret,
+    active=EXCLUDED.active, updated_at=NOW()
+RETURNING `+paymentAccountColumns, orgID, name, encCreds, encSecret, active))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := map[string]any{"account": acc, "webhook_secret_set": secret != ""}
+	if generated {
+		out["webhook_secret"] = secret // mostrado uma única vez
+	}
+	if secret == "" && name != paymentMercadoPago {
+		// o Mercado Pago ainda confirma pela API; os demais dependem da assinatura
+		out["warning"] = "webhook_secret not set: notifications will be rejected"
+	}
+	render.OK(w, out)
+}
+
+func (a *App) paymentAccountByProvider(ctx context.Context, orgID int64, provider string) (paymentAccount, error) {
+	return scanPaymentAccount(a.DB.QueryRow(ctx,
+		`SELECT `+paymentAccountColumns+` FROM payment_accounts WHERE org_id=$1 AND provider=$2`, orgID, provider))
+}
+
+// DELETE /api/payments/accounts/{provider}
+func (a *App) deletePaymentAccount(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `DELETE FROM payment_accounts WHERE org_id=$1 AND provider=$2`,
+		orgID, strings.ToLower(chi.URLParam(r, "provider")))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "payment account not found")
+		return
+	}
+	render.NoContent(w)
+}
+
+// ---------------- links ----------------
+
+// paymentLink é um link de cobrança de um pedido.
+type paymentLink struct {
+	ID          int64      `json:"id"`
+	OrderID     int64      `json:"order_id"`
+	Provider    string     `json:"provider"`
+	AmountCents int        `json:"amount_cents"`
+	Status      string     `json:"status"`
+	URL         string     `json:"url,omitempty"`
+	PixCode     string     `json:"pix_code,omitempty"`
+	ProviderRef string     `json:"provider_ref,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+const paymentLinkColumns = `id, order_id, provider, amount_cents, status, COALESCE(url,''), COALESCE(pix_code,''),
+       COALESCE(provider_ref,''), expires_at, paid_at, created_at`
+
+func scanPaymentLink(row pgx.Row) (paymentLink, error) {
+	var l paymentLink
+	err := row.Scan(&l.ID, &l.OrderID, &l.Provider, &l.AmountCents, &l.Status, &l.URL, &l.PixCode,
+		&l.ProviderRef, &l.ExpiresAt, &l.PaidAt, &l.CreatedAt)
+	return l, err
+}
+
+// customerText é a linha enviada ao cliente com o link ou o Pix copia e cola.
+func (l paymentLink) customerText() string {
+	if l.URL != "" {
+		return "Pague por aqui: " + l.URL
+	}
+	if l.PixCode != "" {
+		return "Pix copia e cola: " + l.PixCode
+	}
+	return ""
+}
+
+var (
+	errOrderNotPayable   = errors.New("order is not pending")
+	errNoPaymentAccount  = errors.New("no active payment account for this org")
+	errOrderWithoutTotal = errors.New("order total must be greater than zero")
+)
+
+// createPaymentLink gera (ou reaproveita) o link de cobrança do pedido.
+// provider vazio usa a conta ativa padrão da org.
+func (a *App) createPaymentLink(ctx context.Context, o Order, provider string) (paymentLink, bool, error) {
+	if o.Status != "" && o.Status != "pending" {
+		return paymentLink{}, false, errOrderNotPayable
+	}
+	if o.TotalCents <= 0 {
+		return paymentLink{}, false, errOrderWithoutTotal
+	}
+	acc, err := a.paymentAccountFor(ctx, o.OrgID, provider)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return paymentLink{}, false, errNoPaymentAccount
+	}
+	if err != nil {
+		return paymentLink{}, false, err
+	}
+
+	l, err := scanPaymentLink(a.DB.QueryRow(ctx, `
+SELECT `+paymentLinkColumns+` FROM payment_links
+ WHERE order_id=$1 AND provider=$2 AND amount_cents=$3 AND status='pending'
+   AND (expires_at IS NULL OR expires_at > NOW() + INTERVAL '10 minutes')
+ ORDER BY id DESC LIMIT 1`, o.ID, acc.Provider, o.TotalCents))
+	if err == nil {
+		return l, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return l, false, err
+	}
+
+	expires := time.Now().Add(paymentLinkTTL())
+	var linkID int64
+	if err := a.DB.QueryRow(ctx, `
+INSERT INTO payment_links (org_id, flow_id, order_id, account_id, provider, amount_cents, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		o.OrgID, o.FlowID, o.ID, acc.ID, acc.Provider, o.TotalCents, expires).Scan(&linkID); err != nil {
+		return l, false, err
+	}
+	res, err := paymentProviders[acc.Provider].createLink(ctx, acc, paymentCheckout{
+		LinkID:      linkID,
+		OrderID:     o.ID,
+		AmountCents: o.TotalCents,
+		Title:       fmt.Sprintf("Pedido #%d", o.ID),
+		ExpiresAt:   expires,
+		NotifyURL:   paymentWebhookURL(acc.Provider, acc.ID),
+	})
+	if err != nil {
+		_, _ = a.DB.Exec(ctx, `UPDATE payment_links SET status='failed', last_error=$2 WHERE id=$1`, linkID, limitRunes(err.Error(), 500))
+		return l, false, err
+	}
+	l, err = scanPaymentLink(a.DB.QueryRow(ctx, `
+UPDATE payment_links SET url=NULLIF($2,''), pix_code=NULLIF($3,''), provider_ref=NULLIF($4,'')
+ WHERE id=$1 RETURNING `+paymentLinkColumns, linkID, res.URL, res.PixCode, res.ProviderRef))
+	return l, true, err
+}
+
+// POST /api/orders/{id}/payment-link
+func (a *App) createPaymentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := a.orderIDForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Provider string `json:"provider"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+			render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+			return
+		}
+	}
+	in.Provider = strings.ToLower(strings.TrimSpace(in.Provider))
+	if _, known := paymentProviders[in.Provider]; in.Provider != "" && !known {
+		render.Error(w, http.StatusBadRequest, "unknown payment provider")
+		return
+	}
+
+	ctx := r.Context()
+	var o Order
+	if err := a.DB.QueryRow(ctx, `
+SELECT id, org_id, flow_id, COALESCE(lead_id,0), total_cents, COALESCE(status,''), created_at
+  FROM orders WHERE id=$1`, orderID).
+		Scan(&o.ID, &o.OrgID, &o.FlowID, &o.LeadID, &o.TotalCents, &o.Status, &o.CreatedAt); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	l, created, err := a.createPaymentLink(ctx, o, in.Provider)
+	switch {
+	case errors.Is(err, errOrderNotPayable):
+		render.Error(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, errOrderWithoutTotal), errors.Is(err, errNoPaymentAccount):
+		render.Error(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		render.Error(w, http.StatusBadGateway, "payment provider error: "+err.Error())
+		return
+	}
+	if created {
+		render.Created(w, l)
+		return
+	}
+	render.OK(w, l)
+}
+
+// GET /api/orders/{id}/payment-links
+func (a *App) listPaymentLinks(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := a.orderIDForTenant(w, r)
+	if !ok {
+		return
+	}
+	rows, err := a.DB.Query(r.Context(),
+		`SELECT `+paymentLinkColumns+` FROM payment_links WHERE order_id=$1 ORDER BY id DESC`, orderID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []paymentLink{}
+	for rows.Next() {
+		l, err := scanPaymentLink(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, l)
+	}
+	if err := rows.Err(); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// ---------------- webhook ----------------
+
+// POST /api/webhooks/payments/{provider}?account={id}
+func (a *App) paymentWebhook(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(chi.URLParam(r, "provider"))
+	provider, ok := paymentProviders[name]
+	if !ok {
+		render.Error(w, http.StatusNotFound, "unknown payment provider")
+		return
+	}
+	accountID, err := strconv.ParseInt(r.URL.Query().Get("account"), 10, 64)
+	if err != nil || accountID <= 0 {
+		render.Error(w, http.StatusBadRequest, "account query parameter required")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "read error")
+		return
+	}
+
+	ctx := r.Context()
+	acc, err := scanPaymentAccount(a.DB.QueryRow(ctx,
+		`SELECT `+paymentAccountColumns+` FROM payment_accounts WHERE id=$1 AND provider=$2`, accountID, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "payment account not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := a.DB.Exec(ctx,
+		`INSERT INTO public.webhooks_log (org_id, source, event, payload) VALUES ($1, 'payments', $2, $3)`,
+		acc.OrgID, name, body); err != nil {
+		log.Printf("payment webhook log: %v", err)
+	}
+
+	n, err := provider.parseWebhook(ctx, acc, r, body)
+	var pe *paymentWebhookError
+	if errors.As(err, &pe) {
+		render.Error(w, pe.status, pe.msg)
+		return
+	}
+	if err != nil {
+		// falha ao consultar o provedor: 5xx faz o provedor reenviar
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if n.LinkID == 0 || n.Status == "" {
+		render.OK(w, map[string]any{"ok": true, "ignored": true})
+		return
+	}
+	l, err := a.applyPaymentNotice(ctx, acc, n)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "payment link not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"ok": true, "link": l})
+}
+
+// applyPaymentNotice aplica a notificação ao link e, se pago, ao pedido.
+// Notificações repetidas não publicam order.paid de novo.
+func (a *App) applyPaymentNotice(ctx context.Context, acc paymentAccount, n paymentNotice) (paymentLink, error) {
+	var (
+		flowID int64
+		prev   string
+	)
+	err := a.DB.QueryRow(ctx, `
+SELECT flow_id, status FROM payment_links WHERE id=$1 AND account_id=$2`, n.LinkID, acc.ID).Scan(&flowID, &prev)
+	if err != nil {
+		return paymentLink{}, err
+	}
+	if prev == paymentLinkPaid || (n.Status == paymentLinkExpired && prev != paymentLinkPending) {
+		return scanPaymentLink(a.DB.QueryRow(ctx, `SELECT `+paymentLinkColumns+` FROM payment_links WHERE id=$1`, n.LinkID))
+	}
+	l, err := scanPaymentLink(a.DB.QueryRow(ctx, `
+UPDATE payment_links
+   SET status=$2, provider_ref=COALESCE(NULLIF($3,''), provider_ref),
+       paid_at=CASE WHEN $2 = 'paid' THEN NOW() ELSE paid_at END
+ WHERE id=$1 AND status <> 'paid'
+RETURNING `+paymentLinkColumns, n.LinkID, n.Status, n.ProviderRef))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// outra notificação chegou primeiro
+		return scanPaymentLink(a.DB.QueryRow(ctx, `SELECT `+paymentLinkColumns+` FROM payment_links WHERE id=$1`, n.LinkID))
+	}
+	if err != nil || l.Status != paymentLinkPaid {
+		return l, err
+	}
+	if _, err := a.DB.Exec(ctx, `UPDATE orders SET payment_method=COALESCE(NULLIF(payment_method,''), $2) WHERE id=$1`,
+		l.OrderID, l.Provider); err != nil {
+		log.Printf("order %d payment method: %v", l.OrderID, err)
+	}
+	if _, err := a.setOrderStatus(ctx, acc.OrgID, flowID, l.OrderID, "paid"); err != nil {
+		return l, err
+	}
+	return l, nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ================================================================
+//  Adaptadores de provedores de pagamento
+// ================================================================
+//
+// Cada provedor cria o link de cobrança de um pedido e interpreta as
+// notificações recebidas em /api/webhooks/payments/{provider}. O vínculo com
+// o nosso link (payment_links.id) vai na referência externa do provedor:
+// external_reference (Mercado Pago), client_reference_id (Stripe) ou o txid
+// do Pix ("PAC" + id).
+
+const (
+	paymentMercadoPago = "mercadopago"
+	paymentStripe      = "stripe"
+	paymentPix         = "pix"
+
+	// tolerância de relógio das assinaturas com timestamp
+	paymentSignatureTolerance = 5 * time.Minute
+)
+
+// paymentCheckout é o que o provedor precisa para cobrar um pedido.
+type paymentCheckout struct {
+	LinkID      int64
+	OrderID     int64
+	AmountCents int
+	Title       string
+	ExpiresAt   time.Time
+	NotifyURL   string
+}
+
+// paymentLinkResult é o link criado no provedor.
+type paymentLinkResult struct {
+	URL         string
+	PixCode     string
+	ProviderRef string
+}
+
+// paymentNotice é uma notificação já validada. LinkID zero ou Status vazio:
+// evento que não nos interessa (ex.: pagamento ainda em análise).
+type paymentNotice struct {
+	LinkID      int64
+	Status      string // paid | expired
+	ProviderRef string
+}
+
+// paymentWebhookError carrega o status HTTP de uma notificação recusada.
+type paymentWebhookError struct {
+	status int
+	msg    string
+}
+
+func (e *paymentWebhookError) Error() string { return e.msg }
+
+var errPaymentSignature = &paymentWebhookError{http.StatusUnauthorized, "invalid signature"}
+
+type paymentProvider interface {
+	validate(creds map[string]string) error
+	createLink(ctx context.Context, acc paymentAccount, c paymentCheckout) (paymentLinkResult, error)
+	parseWebhook(ctx context.Context, acc paymentAccount, r *http.Request, body []byte) (paymentNotice, error)
+}
+
+var paymentProviders = map[string]paymentProvider{
+	paymentMercadoPago: mercadoPagoProvider{},
+	paymentStripe:      stripeProvider{},
+	paymentPix:         pixProvider{},
+}
+
+func requireCreds(creds map[string]string, keys ...string) error {
+	for _, k := range keys {
+		if creds[k] == "" {
+			return fmt.Errorf("credentials.%s required", k)
+		}
+	}
+	return nil
+}
+
+// paymentHTTP faz a chamada ao provedor e decodifica a resposta JSON.
+func paymentHTTP(req *http.Request, out any) error {
+	resp, err := (&http.Client{Timeout: 20 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 400 {
+		var e struct {
+			Message string `json:"message"`
+			Error   struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		msg := firstNonEmpty(e.Error.Message, e.Message, strings.TrimSpace(string(raw)))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, limitRunes(msg, 300))
+	}
+	if out != nil && len(raw) > 0 {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// signedHeaderParts lê cabeçalhos no formato "ts=1,v1=abc" / "t=1,v1=abc".
+func signedHeaderParts(h string) map[string][]string {
+	out := map[string][]string{}
+	for _, part := range strings.Split(h, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			out[k] = append(out[k], v)
+		}
+	}
+	return out
+}
+
+func hmacHex(secret, payload string) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(payload))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func signatureFresh(ts string) bool {
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	d := time.Since(time.Unix(n, 0))
+	return d < paymentSignatureTolerance && d > -paymentSignatureTolerance
+}
+
+// ---------------- Mercado Pago ----------------
+
+const mercadoPagoAPI = "https://api.mercadopago.com"
+
+type mercadoPagoProvider struct{}
+
+func (mercadoPagoProvider) validate(creds map[string]string) error {
+	return requireCreds(creds, "access_token")
+}
+
+func (mercadoPagoProvider) createLink(ctx context.Context, acc paymentAccount, c paymentCheckout) (paymentLinkResult, error) {
+	pref := map[string]any{
+		"items": []map[string]any{{
+			"title":       c.Title,
+			"quantity":    1,
+			"currency_id": "BRL",
+			"unit_price":  float64(c.AmountCents) / 100,
+		}},
+		"external_reference":   strconv.FormatInt(c.LinkID, 10),
+		"expires":              true,
+		"expiration_date_to":   c.ExpiresAt.Format("2006-01-02T15:04:05.000-07:00"),
+		"statement_descriptor": "PACLEAD",
+	}
+	if c.NotifyURL != "" {
+		pref["notification_url"] = c.NotifyURL
+	}
+	b, _ := json.Marshal(pref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mercadoPagoAPI+"/checkout/preferences", bytes.NewReader(b))
+	if err != nil {
+		return paymentLinkResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+acc.creds["access_token"])
+	req.Header.Set("X-Idempotency-Key", fmt.Sprintf("paclead-link-%d", c.LinkID))
+	var out struct {
+		ID        string `json:"id"`
+		InitPoint string `json:"init_point"`
+	}
+	if err := paymentHTTP(req, &out); err != nil {
+		return paymentLinkResult{}, err
+	}
+	return paymentLinkResult{URL: out.InitPoint, ProviderRef: out.ID}, nil
+}
+
+// parseWebhook valida x-signature (quando há segredo; ts fora da tolerância
+// também é recusado) e consulta o pagamento na API: só o status lido de lá
+// vale, nunca o corpo da notificação.
+func (mercadoPagoProvider) parseWebhook(ctx context.Context, acc paymentAccount, r *http.Request, body []byte) (paymentNotice, error) {
+	var ev struct {
+		Type string `json:"type"`
+		Data struct {
+			ID json.RawMessage `json:"id"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(body, &ev)
+	q := r.URL.Query()
+	paymentID := firstNonEmpty(q.Get("data.id"), q.Get("id"), strings.Trim(string(ev.Data.ID), `"`))
+	kind := firstNonEmpty(ev.Type, q.Get("type"), q.Get("topic"))
+	if kind != "payment" || paymentID == "" {
+		return paymentNotice{}, nil
+	}
+
+	if acc.webhookSecret != "" {
+		sig := signedHeaderParts(r.Header.Get("x-signature"))
+		ts, v1 := "", ""
+		if len(sig["ts"]) > 0 {
+			ts = sig["ts"][0]
+		}
+		if len(sig["v1"]) > 0 {
+			v1 = sig["v1"][0]
+		}
+		manifest := "id:" + strings.ToLower(paymentID) + ";"
+		if rid := r.Header.Get("x-request-id"); rid != "" {
+			manifest += "request-id:" + rid + ";"
+		}
+		manifest += "ts:" + ts + ";"
+		// ts pode vir em milissegundos; a janela é a mesma do Stripe
+		fresh := ts
+		if len(fresh) > 10 {
+			fresh = fresh[:len(fresh)-3]
+		}
+		if ts == "" || !signatureFresh(fresh) || !hmac.Equal([]byte(hmacHex(acc.webhookSecret, manifest)), []byte(v1)) {
+			return paymentNotice{}, errPaymentSignature
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mercadoPagoAPI+"/v1/payments/"+url.PathEscape(paymentID), nil)
+	if err != nil {
+		return paymentNotice{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+acc.creds["access_token"])
+	var p struct {
+		Status            string `json:"status"`
+		ExternalReference string `json:"external_reference"`
+	}
+	if err := paymentHTTP(req, &p); err != nil {
+		return paymentNotice{}, err
+	}
+	linkID, _ := strconv.ParseInt(p.ExternalReference, 10, 64)
+	if p.Status != "approved" {
+		return paymentNotice{LinkID: linkID}, nil
+	}
+	return paymentNotice{LinkID: linkID, Status: paymentLinkPaid, ProviderRef: paymentID}, nil
+}
+
+// ---------------- Stripe ----------------
+
+const stripeAPI = "https://api.stripe.com/v1"
+
+type stripeProvider struct{}
+
+func (stripeProvider) validate(creds map[string]string) error {
+	if err := requireCreds(creds, "secret_key"); err != nil {
+		return err
+	}
+	if c := creds["currency"]; c != "" && len(c) != 3 {
+		return errors.New("credentials.currency must be an ISO 4217 code (e.g. brl)")
+	}
+	return nil
+}
+
+func (stripeProvider) createLink(ctx context.Context, acc paymentAccount, c paymentCheckout) (paymentLinkResult, error) {
+	success := getenv("PAYMENT_SUCCESS_URL", "")
+	if success == "" {
+		success = strings.TrimRight(getenv("STOREFRONT_BASE_URL", ""), "/")
+	}
+	if success == "" {
+		return paymentLinkResult{}, errors.New("stripe needs PAYMENT_SUCCESS_URL or STOREFRONT_BASE_URL")
+	}
+	ref := strconv.FormatInt(c.LinkID, 10)
+	form := url.Values{
+		"mode":                                          {"payment"},
+		"success_url":                                   {success},
+		"client_reference_id":                           {ref},
+		"metadata[payment_link_id]":                     {ref},
+		"metadata[order_id]":                            {strconv.FormatInt(c.OrderID, 10)},
+		"line_items[0][quantity]":                       {"1"},
+		"line_items[0][price_data][currency]":           {strings.ToLower(nonEmpty(acc.creds["currency"], "brl"))},
+		"line_items[0][price_data][unit_amount]":        {strconv.Itoa(c.AmountCents)},
+		"line_items[0][price_data][product_data][name]": {c.Title},
+	}
+	// a Stripe aceita sessões de 30min a 24h
+	if d := time.Until(c.ExpiresAt); d >= 30*time.Minute && d <= 24*time.Hour {
+		form.Set("expires_at", strconv.FormatInt(c.ExpiresAt.Unix(), 10))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stripeAPI+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return paymentLinkResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", "paclead-link-"+ref)
+	req.SetBasicAuth(acc.creds["secret_key"], "")
+	var out struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := paymentHTTP(req, &out); err != nil {
+		return paymentLinkResult{}, err
+	}
+	return paymentLinkResult{URL: out.URL, ProviderRef: out.ID}, nil
+}
+
+// parseWebhook confere Stripe-Signature: v1 = HMAC-SHA256(secret, "t.corpo").
+func (stripeProvider) parseWebhook(_ context.Context, acc paymentAccount, r *http.Request, body []byte) (paymentNotice, error) {
+	if acc.webhookSecret == "" {
+		return paymentNotice{}, &paymentWebhookError{http.StatusServiceUnavailable, "stripe webhook_secret not configured"}
+	}
+	sig := signedHeaderParts(r.Header.Get("Stripe-Signature"))
+	if len(sig["t"]) == 0 || !signatureFresh(sig["t"][0]) {
+		return paymentNotice{}, errPaymentSignature
+	}
+	want := hmacHex(acc.webhookSecret, sig["t"][0]+"."+string(body))
+	valid := false
+	for _, v := range sig["v1"] {
+		valid = valid || hmac.Equal([]byte(want), []byte(v))
+	}
+	if !valid {
+		return paymentNotice{}, errPaymentSignature
+	}
+
+	var ev struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID                string `json:"id"`
+				ClientReferenceID string `json:"client_reference_id"`
+				PaymentStatus     string `json:"payment_status"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return paymentNotice{}, &paymentWebhookError{http.StatusBadRequest, "invalid json"}
+	}
+	obj := ev.Data.Object
+	linkID, _ := strconv.ParseInt(obj.ClientReferenceID, 10, 64)
+	n := paymentNotice{LinkID: linkID, ProviderRef: obj.ID}
+	switch ev.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		if obj.PaymentStatus == "paid" {
+			n.Status = paymentLinkPaid
+		}
+	case "checkout.session.expired":
+		n.Status = paymentLinkExpired
+	}
+	return n, nil
+}
+
+// ---------------- Pix estático ----------------
+
+// pixProvider gera o BR Code (Pix copia e cola) com a chave da org, sem
+// intermediário. A confirmação chega por POST do PSP ou de uma automação:
+//
+//	{"txid":"PAC123","status":"paid","end_to_end_id":"E..."}
+//	X-PacLead-Signature: sha256=hex(HMAC-SHA256(webhook_secret, corpo))
+type pixProvider struct{}
+
+func (pixProvider) validate(creds map[string]string) error {
+	return requireCreds(creds, "key", "merchant_name", "city")
+}
+
+func pixTxID(linkID int64) string { return "PAC" + strconv.FormatInt(linkID, 10) }
+
+func (pixProvider) createLink(_ context.Context, acc paymentAccount, c paymentCheckout) (paymentLinkResult, error) {
+	code := pixBRCode(acc.creds["key"], acc.creds["merchant_name"], acc.creds["city"],
+		pixTxID(c.LinkID), c.AmountCents)
+	return paymentLinkResult{PixCode: code, ProviderRef: pixTxID(c.LinkID)}, nil
+}
+
+func (pixProvider) parseWebhook(_ context.Context, acc paymentAccount, r *http.Request, body []byte) (paymentNotice, error) {
+	if acc.webhookSecret == "" {
+		return paymentNotice{}, &paymentWebhookError{http.StatusServiceUnavailable, "pix webhook_secret not configured"}
+	}
+	want := "sha256=" + hmacHex(acc.webhookSecret, string(body))
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get("X-PacLead-Signature"))) {
+		return paymentNotice{}, errPaymentSignature
+	}
+	var in struct {
+		TxID       string `json:"txid"`
+		Status     string `json:"status"`
+		EndToEndID string `json:"end_to_end_id"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return paymentNotice{}, &paymentWebhookError{http.StatusBadRequest, "invalid json"}
+	}
+	linkID, err := strconv.ParseInt(strings.TrimPrefix(strings.ToUpper(in.TxID), "PAC"), 10, 64)
+	if err != nil {
+		return paymentNotice{}, &paymentWebhookError{http.StatusUnprocessableEntity, "unknown txid"}
+	}
+	n := paymentNotice{LinkID: linkID, ProviderRef: in.EndToEndID}
+	if strings.EqualFold(in.Status, "paid") || strings.EqualFold(in.Status, "concluida") {
+		n.Status = paymentLinkPaid
+	}
+	return n, nil
+}
+
+// pixBRCode monta o payload EMV do Pix estático (manual do BR Code do Bacen).
+func pixBRCode(key, name, city, txid string, amountCents int) string {
+	field := func(id, v string) string { return fmt.Sprintf("%s%02d%s", id, len(v), v) }
+	account := field("00", "br.gov.bcb.pix") + field("01", key)
+	var b strings.Builder
+	b.WriteString(field("00", "01"))
+	b.WriteString(field("26", account))
+	b.WriteString(field("52", "0000"))
+	b.WriteString(field("53", "986"))
+	if amountCents > 0 {
+		b.WriteString(field("54", fmt.Sprintf("%d.%02d", amountCents/100, amountCents%100)))
+	}
+	b.WriteString(field("58", "BR"))
+	b.WriteString(field("59", pixText(name, 25)))
+	b.WriteString(field("60", pixText(city, 15)))
+	b.WriteString(field("62", field("05", pixText(txid, 25))))
+	b.WriteString("6304")
+	return b.String() + fmt.Sprintf("%04X", crc16CCITT(b.String()))
+}
+
+// pixText deixa só ASCII imprimível em maiúsculas (sem acentos, como pede o
+// padrão) e corta em n caracteres.
+func pixText(s string, n int) string {
+	var b strings.Builder
+	for _, r := range accentFolder.Replace(strings.ToLower(s)) { // storefront.go
+		if r < unicode.MaxASCII && unicode.IsPrint(r) {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	out := strings.TrimSpace(b.String())
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// crc16CCITT (polinômio 0x1021, inicial 0xFFFF) do campo 63 do BR Code.
+func crc16CCITT(s string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
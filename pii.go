@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ================================================================
+//  Criptografia de PII dos leads (nome, telefone, e-mail)
+// ================================================================
+//
+// Cada org tem uma chave própria derivada de PII_MASTER_KEY (32 bytes em
+// base64) + org_id + versão. A versão atual fica em org_pii_keys e só muda
+// no re-key. O texto cifrado carrega a versão usada:
+//
+//   enc:v<versão>:<base64(nonce || AES-256-GCM)>
+//
+// Valores sem o prefixo "enc:" são tratados como legado (texto puro), o que
+// permite ligar a criptografia sem migrar a base de uma vez. Buscas por
+// telefone/e-mail usam as colunas phone_hash/email_hash (HMAC por org).
+//
+// Sem PII_MASTER_KEY a criptografia fica desligada (valores em texto puro),
+// mas os hashes continuam sendo gravados.
+// Colunas de hash e org_pii_keys: migrations/0057_lead_pii.sql.
+
+const piiPrefix = "enc:"
+
+var piiMasterKey = loadPIIMasterKey()
+
+func loadPIIMasterKey() []byte {
+	v := strings.TrimSpace(os.Getenv("PII_MASTER_KEY"))
+	if v == "" {
+		return nil
+	}
+	k, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(k) != 32 {
+		log.Fatalf("PII_MASTER_KEY must be 32 bytes encoded in base64")
+	}
+	return k
+}
+
+func piiEnabled() bool { return len(piiMasterKey) > 0 }
+
+// piiOrgKey deriva a chave AES da org para uma versão.
+func piiOrgKey(orgID int64, version int) []byte {
+	m := hmac.New(sha256.New, piiMasterKey)
+	fmt.Fprintf(m, "lead-pii/org/%d/v%d", orgID, version)
+	return m.Sum(nil)
+}
+
+// piiHash gera o valor de busca (determinístico) para um campo normalizado.
+func piiHash(orgID int64, value string) string {
+	value = strings.TrimSpace(strings.ToLower(value))
+	if value == "" {
+		return ""
+	}
+	key := piiMasterKey
+	if key == nil {
+		key = []byte("paclead-pii-lookup")
+	}
+	m := hmac.New(sha256.New, key)
+	fmt.Fprintf(m, "lookup/org/%d/%s", orgID, value)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// encryptPII cifra value com a chave da org na versão informada.
+func encryptPII(orgID int64, version int, value string) (string, error) {
+	if !piiEnabled() || value == "" {
+		return value, nil
+	}
+	block, err := aes.NewCipher(piiOrgKey(orgID, version))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(strconv.FormatInt(orgID, 10)))
+	return fmt.Sprintf("%sv%d:%s", piiPrefix, version, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// decryptPII reverte encryptPII; valores legados (sem prefixo) voltam como estão.
+func decryptPII(orgID int64, value string) (string, error) {
+	if !strings.HasPrefix(value, piiPrefix) {
+		return value, nil
+	}
+	if !piiEnabled() {
+		return "", errors.New("encrypted value but PII_MASTER_KEY not set")
+	}
+	rest := strings.TrimPrefix(value, piiPrefix)
+	ver, payload, ok := strings.Cut(rest, ":")
+	if !ok || !strings.HasPrefix(ver, "v") {
+		return "", errors.New("malformed encrypted value")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(ver, "v"))
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(piiOrgKey(orgID, version))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], []byte(strconv.FormatInt(orgID, 10)))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// revealPII decifra para exibição; em caso de falha devolve vazio e loga.
+func revealPII(orgID int64, value string) string {
+	v, err := decryptPII(orgID, value)
+	if err != nil {
+		log.Printf("pii decrypt org=%d: %v", orgID, err)
+		return ""
+	}
+	return v
+}
+
+// piiKeyVersion retorna a versão atual da chave da org (1 se nunca rotacionada).
+func piiKeyVersion(ctx context.Context, db *pgxpool.Pool, orgID int64) int {
+	v := 1
+	_ = db.QueryRow(ctx, `SELECT key_version FROM org_pii_keys WHERE org_id=$1`, orgID).Scan(&v)
+	return v
+}
+
+// runRekeyPII implementa o subcomando "rekey-pii [org_id ...]": avança a
+// versão da chave de cada org (todas, se nenhuma for informada) e recifra
+// nome/telefone/e-mail dos leads numa única transação por org. Também
+// cifra linhas legadas e recalcula os hashes de busca.
+func runRekeyPII(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	if !piiEnabled() {
+		return errors.New("PII_MASTER_KEY not set")
+	}
+	orgs, err := orgIDsArg(ctx, db, args)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range orgs {
+		n, version, err := rekeyOrgPII(ctx, db, orgID)
+		if err != nil {
+			return fmt.Errorf("org %d: %w", orgID, err)
+		}
+		log.Printf("rekey-pii: org=%d version=%d leads=%d", orgID, version, n)
+	}
+	return nil
+}
+
+// orgIDsArg lê os org_id dos argumentos de um subcomando; sem argumentos,
+// todas as orgs.
+func orgIDsArg(ctx context.Context, db *pgxpool.Pool, args []string) ([]int64, error) {
+	var orgs []int64
+	for _, s := range args {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid org_id %q", s)
+		}
+		orgs = append(orgs, id)
+	}
+	if len(orgs) > 0 {
+		return orgs, nil
+	}
+	rows, err := db.Query(ctx, `SELECT id FROM orgs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, id)
+	}
+	return orgs, rows.Err()
+}
+
+func rekeyOrgPII(ctx context.Context, db *pgxpool.Pool, orgID int64) (int, int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var version int
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO org_pii_keys (org_id, key_version) VALUES ($1, 2)
+		ON CONFLICT (org_id) DO UPDATE SET key_version = org_pii_keys.key_version + 1, rotated_at = NOW()
+		RETURNING key_version`, orgID).Scan(&version); err != nil {
+		return 0, 0, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, COALESCE(name,''), COALESCE(phone,''), COALESCE(email,'')
+		  FROM leads WHERE org_id=$1 FOR UPDATE`, orgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	type leadPII struct {
+		ID                 int64
+		Name, Phone, Email string
+	}
+	var all []leadPII
+	for rows.Next() {
+		var l leadPII
+		if err := rows.Scan(&l.ID, &l.Name, &l.Phone, &l.Email); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		all = append(all, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, l := range all {
+		var plain [3]string
+		for i, v := range []string{l.Name, l.Phone, l.Email} {
+			if plain[i], err = decryptPII(orgID, v); err != nil {
+				return 0, 0, fmt.Errorf("lead %d: %w", l.ID, err)
+			}
+		}
+		var enc [3]string
+		for i, v := range plain {
+			if enc[i], err = encryptPII(orgID, version, v); err != nil {
+				return 0, 0, err
+			}
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE leads SET name=$1, phone=$2, email=$3, phone_hash=NULLIF($4,''), email_hash=NULLIF($5,'')
+			 WHERE id=$6`,
+			enc[0], enc[1], enc[2], phoneHash(orgID, plain[1]), piiHash(orgID, plain[2]), l.ID); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(all), version, tx.Commit(ctx)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Arquivamento e exclusão lógica de produtos
+// ================================================================
+//
+// DELETE /api/products/{id}          (admin) manda para a lixeira (deleted_at)
+// POST   /api/products/{id}/archive  tira de venda sem apagar (status archived)
+// POST   /api/products/{id}/restore  volta da lixeira ou do arquivo como active
+// POST   /api/admin/products/purge   (ADMIN_TOKEN) ?older_than_days=&org_id=
+//
+// Pedidos, assinaturas e relatórios continuam apontando para o produto, que
+// só é apagado de verdade no purge (padrão: na lixeira há mais de
+// PRODUCT_PURGE_AFTER_DAYS, 30 dias). Arquivado ou excluído, o produto some
+// das listas (a não ser com ?include=archived,deleted), da busca, do agente,
+// da vitrine e dos feeds, e não entra em pedido novo. Na restauração o worker
+// de janela (product_schedule.go) devolve a scheduled se estiver fora dela.
+
+func (a *App) mountProductArchive(r chi.Router) {
+	r.Post("/products/{id}/archive", a.archiveProduct)
+	r.Post("/products/{id}/restore", a.restoreProduct)
+}
+
+// productListedSQL é o filtro padrão das listas do painel: nem arquivado nem
+// na lixeira. As consultas de venda já exigem status='active' e
+// productAvailableSQL.
+const productListedSQL = `products.deleted_at IS NULL AND products.status <> 'archived'`
+
+// productListFilter monta o filtro de ?include=archived,deleted.
+func productListFilter(r *http.Request) string {
+	var archived, deleted bool
+	for _, s := range strings.Split(r.URL.Query().Get("include"), ",") {
+		switch strings.TrimSpace(s) {
+		case "archived":
+			archived = true
+		case "deleted":
+			deleted = true
+		}
+	}
+	switch {
+	case archived && deleted:
+		return "TRUE"
+	case archived:
+		return "products.deleted_at IS NULL"
+	case deleted:
+		return "products.status <> 'archived'"
+	}
+	return productListedSQL
+}
+
+// DELETE /api/products/{id}
+func (a *App) deleteProduct(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	orgID, flowID, _ := tenantOf(r)
+	tag, err := a.DB.Exec(r.Context(),
+		`UPDATE products SET deleted_at=NOW() WHERE id=$1 AND org_id=$2 AND deleted_at IS NULL`, id, orgID)
+	if err != nil {
+		render.Error(w, 500, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "product not found")
+		return
+	}
+	a.touchProductFeed(orgID, flowID)
+	render.NoContent(w)
+}
+
+// POST /api/products/{id}/archive
+func (a *App) archiveProduct(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	orgID, flowID, _ := tenantOf(r)
+	tag, err := a.DB.Exec(r.Context(), `
+UPDATE products SET status='archived', archived_at=NOW()
+ WHERE id=$1 AND org_id=$2 AND deleted_at IS NULL AND status <> 'archived'`, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "product not found or already archived")
+		return
+	}
+	a.touchProductFeed(orgID, flowID)
+	render.NoContent(w)
+}
+
+// POST /api/products/{id}/restore
+func (a *App) restoreProduct(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	orgID, flowID, _ := tenantOf(r)
+	ctx := r.Context()
+	var status string
+	err := a.DB.QueryRow(ctx, `
+UPDATE products SET deleted_at=NULL, archived_at=NULL,
+       status = CASE WHEN status='archived' THEN 'active' ELSE status END
+ WHERE id=$1 AND org_id=$2 AND (deleted_at IS NOT NULL OR status='archived')
+RETURNING status`, id, orgID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "product not found or not archived")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.applyProductSchedule(ctx, id); err != nil {
+		log.Printf("product %d schedule: %v", id, err)
+	}
+	_ = a.DB.QueryRow(ctx, `SELECT status FROM products WHERE id=$1`, id).Scan(&status)
+	a.touchProductFeed(orgID, flowID)
+	render.OK(w, map[string]any{"id": id, "status": status})
+}
+
+func productPurgeAfter() time.Duration {
+	n, err := strconv.Atoi(getenv("PRODUCT_PURGE_AFTER_DAYS", "30"))
+	if err != nil || n < 0 {
+		n = 30
+	}
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// POST /api/admin/products/purge
+func (a *App) adminPurgeProducts(w http.ResponseWriter, r *http.Request) {
+	after := productPurgeAfter()
+	if s := r.URL.Query().Get("older_than_days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			render.Error(w, http.StatusBadRequest, "invalid older_than_days")
+			return
+		}
+		after = time.Duration(n) * 24 * time.Hour
+	}
+	var orgID int64
+	if s := r.URL.Query().Get("org_id"); s != "" {
+		var err error
+		if orgID, err = strconv.ParseInt(s, 10, 64); err != nil || orgID <= 0 {
+			render.Error(w, http.StatusBadRequest, "invalid org_id")
+			return
+		}
+	}
+	n, err := a.purgeDeletedProducts(r.Context(), orgID, time.Now().Add(-after))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("products purge: %d removed (org=%d, before %s)", n, orgID, time.Now().Add(-after).Format(time.RFC3339))
+	render.OK(w, map[string]any{"purged": n})
+}
+
+// purgeDeletedProducts apaga de vez os produtos na lixeira desde antes de
+// cutoff (orgID 0 = todas). Imagens extras e ofertas relâmpago vão junto
+// (ON DELETE CASCADE); itens de pedido guardam só o product_id.
+func (a *App) purgeDeletedProducts(ctx context.Context, orgID int64, cutoff time.Time) (int64, error) {
+	tag, err := a.DB.Exec(ctx, `
+DELETE FROM products
+ WHERE deleted_at IS NOT NULL AND deleted_at < $1 AND ($2 = 0 OR org_id = $2)`, cutoff, orgID)
+	return tag.RowsAffected(), err
+}
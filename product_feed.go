@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Feed de produtos (Google Merchant Center / Instagram Shopping)
+// ================================================================
+//
+// Cada org/flow pode ter um feed público em /feeds/{token}.xml (RSS 2.0 com
+// namespace g:) e /feeds/{token}.csv. O conteúdo fica em cache na tabela
+// product_feeds e é regerado sempre que o catálogo muda. Trocar o token
+// (POST /api/feeds/rotate) invalida a URL anterior.
+// Tabela em migrations/0058_product_feeds.sql.
+
+const googleNS = "http://base.google.com/ns/1.0"
+
+type feedItem struct {
+	ID           string `xml:"g:id"`
+	Title        string `xml:"g:title"`
+	Description  string `xml:"g:description"`
+	Link         string `xml:"g:link,omitempty"`
+	ImageLink    string `xml:"g:image_link,omitempty"`
+	Availability string `xml:"g:availability"`
+	Price        string `xml:"g:price"`
+	Condition    string `xml:"g:condition"`
+	ProductType  string `xml:"g:product_type,omitempty"`
+}
+
+type feedRSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	NS      string   `xml:"xmlns:g,attr"`
+	Channel struct {
+		Title string     `xml:"title"`
+		Link  string     `xml:"link"`
+		Desc  string     `xml:"description"`
+		Items []feedItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// mountFeedAdmin registra as rotas autenticadas de gestão do feed.
+func (a *App) mountFeedAdmin(r chi.Router) {
+	r.Get("/feeds", a.getProductFeed)
+	r.Post("/feeds/rotate", a.rotateProductFeed)
+}
+
+// mountPublicFeeds registra /feeds/{token}.xml|.csv (sem /api, sem JWT).
+func (a *App) mountPublicFeeds(r chi.Router) {
+	r.Get("/feeds/{file}", a.serveProductFeed)
+}
+
+func feedURLs(r *http.Request, token string) map[string]string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := fmt.Sprintf("%s://%s/feeds/%s", scheme, r.Host, token)
+	return map[string]string{"xml": base + ".xml", "csv": base + ".csv"}
+}
+
+// GET /api/feeds — dados do feed do tenant (cria na primeira chamada).
+func (a *App) getProductFeed(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	ctx := r.Context()
+	var (
+		token string
+		count int
+		gen   *time.Time
+	)
+	err := a.DB.QueryRow(ctx, `SELECT token, item_count, generated_at FROM public.product_feeds WHERE org_id=$1 AND flow_id=$2`,
+		orgID, flowID).Scan(&token, &count, &gen)
+	if errors.Is(err, pgx.ErrNoRows) {
+		token = secureToken(20)
+		_, err = a.DB.Exec(ctx, `INSERT INTO public.product_feeds (org_id, flow_id, token) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`,
+			orgID, flowID, token)
+		if err == nil {
+			count, err = a.regenerateProductFeed(ctx, orgID, flowID)
+			now := time.Now()
+			gen = &now
+		}
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"urls": feedURLs(r, token), "item_count": count, "generated_at": gen})
+}
+
+// POST /api/feeds/rotate — gera um novo token (a URL antiga deixa de valer).
+func (a *App) rotateProductFeed(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	token := secureToken(20)
+	_, err := a.DB.Exec(r.Context(), `
+INSERT INTO public.product_feeds (org_id, flow_id, token) VALUES ($1,$2,$3)
+ON CONFLICT (org_id, flow_id) DO UPDATE SET token = EXCLUDED.token`, orgID, flowID, token)
+	if err == nil {
+		_, err = a.regenerateProductFeed(r.Context(), orgID, flowID)
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"urls": feedURLs(r, token)})
+}
+
+// GET /feeds/{token}.xml | /feeds/{token}.csv
+func (a *App) serveProductFeed(w http.ResponseWriter, r *http.Request) {
+	file := chi.URLParam(r, "file")
+	var token, col, ctype string
+	switch {
+	case strings.HasSuffix(file, ".xml"):
+		token, col, ctype = strings.TrimSuffix(file, ".xml"), "xml", "application/xml; charset=utf-8"
+	case strings.HasSuffix(file, ".csv"):
+		token, col, ctype = strings.TrimSuffix(file, ".csv"), "csv", "text/csv; charset=utf-8"
+	default:
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	var (
+		body *string
+		gen  *time.Time
+	)
+	err := a.DB.QueryRow(r.Context(),
+		`SELECT `+col+`, generated_at FROM public.product_feeds WHERE token=$1`, token).Scan(&body, &gen)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if gen != nil {
+		w.Header().Set("Last-Modified", gen.UTC().Format(http.TimeFormat))
+	}
+	if body != nil {
+		_, _ = w.Write([]byte(*body))
+	}
+}
+
+// touchProductFeed regera o feed em background após mudanças no catálogo.
+// Tenants sem feed são ignorados.
+func (a *App) touchProductFeed(orgID, flowID int64) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if _, err := a.regenerateProductFeed(ctx, orgID, flowID); err != nil {
+			log.Printf("product feed org=%d flow=%d: %v", orgID, flowID, err)
+		}
+	}()
+}
+
+// regenerateProductFeed monta XML e CSV com os produtos ativos e grava no
+// cache. flowID <= 0 regera todos os feeds da org.
+func (a *App) regenerateProductFeed(ctx context.Context, orgID, flowID int64) (int, error) {
+	rows, err := a.DB.Query(ctx,
+		`SELECT flow_id FROM public.product_feeds WHERE org_id=$1 AND ($2 <= 0 OR flow_id=$2)`, orgID, flowID)
+	if err != nil {
+		return 0, err
+	}
+	var flows []int64
+	for rows.Next() {
+		var f int64
+		if err := rows.Scan(&f); err == nil {
+			flows = append(flows, f)
+		}
+	}
+	rows.Close()
+
+	total := 0
+	for _, f := range flows {
+		items, err := a.feedItems(ctx, orgID, f)
+		if err != nil {
+			return total, err
+		}
+		xmlBody, csvBody, err := renderFeed(items)
+		if err != nil {
+			return total, err
+		}
+		if _, err := a.DB.Exec(ctx, `
+UPDATE public.product_feeds SET xml=$3, csv=$4, item_count=$5, generated_at=NOW()
+ WHERE org_id=$1 AND flow_id=$2`, orgID, f, xmlBody, csvBody, len(items)); err != nil {
+			return total, err
+		}
+		total += len(items)
+	}
+	return total, nil
+}
+
+func (a *App) feedItems(ctx context.Context, orgID, flowID int64) ([]feedItem, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT id, title, COALESCE(slug,''), COALESCE(category,''), COALESCE(image_base64,''), price_cents, stock
+  FROM products WHERE org_id=$1 AND flow_id=$2 AND status='active' AND `+productAvailableSQL()+`
+ ORDER BY id`, orgID, flowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currency := getenv("CATALOG_CURRENCY", "BRL")
+	var out []feedItem
+	for rows.Next() {
+		var (
+			id                    int64
+			title, slug, cat, img string
+			price, stock          int
+		)
+		if err := rows.Scan(&id, &title, &slug, &cat, &img, &price, &stock); err != nil {
+			return nil, err
+		}
+		it := feedItem{
+			ID:           fmt.Sprint(id),
+			Title:        title,
+			Description:  firstNonEmpty(cat, title),
+			Availability: "in_stock",
+			Price:        fmt.Sprintf("%d.%02d %s", price/100, price%100, currency),
+			Condition:    "new",
+			ProductType:  cat,
+		}
+		if stock <= 0 {
+			it.Availability = "out_of_stock"
+		}
+		if strings.HasPrefix(img, "http://") || strings.HasPrefix(img, "https://") {
+			it.ImageLink = img
+		}
+		it.Link = productPublicURL(nil, orgID, id, slug)
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+func renderFeed(items []feedItem) (string, string, error) {
+	var doc feedRSS
+	doc.Version = "2.0"
+	doc.NS = googleNS
+	doc.Channel.Title = "Catálogo"
+	doc.Channel.Link = getenv("STOREFRONT_BASE_URL", "")
+	doc.Channel.Desc = "Product feed"
+	doc.Channel.Items = items
+	xb, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+
+	var cb bytes.Buffer
+	cw := csv.NewWriter(&cb)
+	_ = cw.Write([]string{"id", "title", "description", "link", "image_link", "availability", "price", "condition", "product_type"})
+	for _, it := range items {
+		_ = cw.Write([]string{it.ID, it.Title, it.Description, it.Link, it.ImageLink, it.Availability, it.Price, it.Condition, it.ProductType})
+	}
+	cw.Flush()
+	return xml.Header + string(xb), cb.String(), cw.Error()
+}
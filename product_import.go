@@ -0,0 +1,542 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Importação de produtos em massa (CSV/XLSX)
+// ================================================================
+//
+// POST /api/products/import (multipart)
+//   file     .csv (separador , ou ;) ou .xlsx (primeira planilha)
+//   mapping  opcional, JSON campo→coluna: {"title":"Nome","price":"Valor"}
+//   dry_run  "true" só valida
+//   strict   "true" não grava nada se alguma linha tiver erro
+//
+// A primeira linha é o cabeçalho. Sem mapping, as colunas são reconhecidas
+// pelo nome (title/título/nome, price/preço/valor, ...). Linhas válidas são
+// gravadas numa única transação, em lotes; a resposta traz o erro de cada
+// linha rejeitada (número da linha na planilha, contando o cabeçalho).
+
+const (
+	importMaxBytes = 10 << 20
+	importMaxRows  = 5000
+	importBatch    = 500
+)
+
+// campos aceitos e os nomes de coluna reconhecidos sem mapping
+var importFieldAliases = map[string][]string{
+	"title":       {"title", "titulo", "nome", "name", "produto", "product"},
+	"description": {"description", "descricao", "detalhes"},
+	"price":       {"price", "preco", "valor", "preco_reais"},
+	"price_cents": {"price_cents", "preco_centavos"},
+	"stock":       {"stock", "estoque", "quantidade", "qtd"},
+	"category":    {"category", "categoria"},
+	"status":      {"status", "situacao"},
+	"slug":        {"slug"},
+	"image_url":   {"image_url", "imagem", "image", "foto", "url_imagem"},
+}
+
+type importRowError struct {
+	Row    int      `json:"row"`
+	Errors []string `json:"errors"`
+}
+
+type importProduct struct {
+	row         int
+	id          int64
+	Title       string
+	Description string
+	PriceCents  int
+	Stock       int
+	Category    string
+	Status      string
+	Slug        string
+	ImageURL    string
+}
+
+// POST /api/products/import
+func (a *App) importProducts(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes+(1<<20))
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid multipart form (max 10MB): "+err.Error())
+		return
+	}
+	file, hdr, err := r.FormFile("file")
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "file required")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var records [][]string
+	switch ext := strings.ToLower(path.Ext(hdr.Filename)); {
+	case ext == ".xlsx" || bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		records, err = readXLSX(data)
+	case ext == ".csv" || ext == ".txt" || ext == "":
+		records, err = readImportCSV(data)
+	default:
+		render.Error(w, http.StatusBadRequest, "unsupported file type (use .csv or .xlsx)")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "could not read file: "+err.Error())
+		return
+	}
+	if len(records) < 2 {
+		render.Error(w, http.StatusBadRequest, "file has no data rows (first row must be the header)")
+		return
+	}
+	if len(records)-1 > importMaxRows {
+		render.Error(w, http.StatusBadRequest, fmt.Sprintf("too many rows (max %d)", importMaxRows))
+		return
+	}
+
+	var mapping map[string]string
+	if v := strings.TrimSpace(r.FormValue("mapping")); v != "" {
+		if err := json.Unmarshal([]byte(v), &mapping); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid mapping json: "+err.Error())
+			return
+		}
+	}
+	cols, err := importColumns(records[0], mapping)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	taken, err := a.orgProductSlugs(ctx, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var valid []importProduct
+	rowErrors := []importRowError{}
+	for i, rec := range records[1:] {
+		if importRowBlank(rec) {
+			continue // linha vazia no meio da planilha
+		}
+		p, errs := parseImportRow(rec, cols)
+		p.row = i + 2
+		if len(errs) == 0 {
+			if p.Slug != "" {
+				if taken[p.Slug] {
+					errs = append(errs, fmt.Sprintf("slug %q already exists", p.Slug))
+				}
+			} else {
+				p.Slug = nextFreeSlug(p.Title, taken)
+			}
+		}
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, importRowError{Row: p.row, Errors: errs})
+			continue
+		}
+		taken[p.Slug] = true
+		valid = append(valid, p)
+	}
+
+	dryRun := r.FormValue("dry_run") == "true"
+	strict := r.FormValue("strict") == "true"
+	imported := 0
+	if !dryRun && len(valid) > 0 && !(strict && len(rowErrors) > 0) {
+		if err := a.insertImportedProducts(ctx, orgID, flowID, valid); err != nil {
+			render.Error(w, http.StatusInternalServerError, "import failed, nothing was saved: "+err.Error())
+			return
+		}
+		imported = len(valid)
+		a.touchProductFeed(orgID, flowID)
+		for _, p := range valid {
+			a.publishEvent(ctx, orgID, eventProductCreated, chatProduct{
+				ID: p.id, OrgID: orgID, FlowID: flowID, Title: p.Title, Slug: p.Slug, Status: p.Status,
+				ImageURL: p.ImageURL, PriceCents: p.PriceCents, Stock: p.Stock, Category: p.Category,
+			})
+		}
+	}
+	render.OK(w, map[string]any{
+		"rows":     len(valid) + len(rowErrors),
+		"valid":    len(valid),
+		"imported": imported,
+		"failed":   len(rowErrors),
+		"dry_run":  dryRun,
+		"errors":   rowErrors,
+	})
+}
+
+// insertImportedProducts grava tudo numa transação, em lotes.
+func (a *App) insertImportedProducts(ctx context.Context, orgID, flowID int64, items []importProduct) error {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for start := 0; start < len(items); start += importBatch {
+		end := min(start+importBatch, len(items))
+		b := &pgx.Batch{}
+		for _, p := range items[start:end] {
+			b.Queue(`
+INSERT INTO products (org_id, flow_id, title, slug, status, image_base64, price_cents, stock, category, description)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10,''))
+RETURNING id`,
+				orgID, flowID, p.Title, p.Slug, p.Status, p.ImageURL, p.PriceCents, p.Stock, p.Category, p.Description)
+		}
+		br := tx.SendBatch(ctx, b)
+		for i := start; i < end; i++ {
+			if err := br.QueryRow().Scan(&items[i].id); err != nil {
+				br.Close()
+				return fmt.Errorf("row %d: %w", items[i].row, err)
+			}
+		}
+		if err := br.Close(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// orgProductSlugs carrega os slugs já usados na org.
+func (a *App) orgProductSlugs(ctx context.Context, orgID int64) (map[string]bool, error) {
+	rows, err := a.DB.Query(ctx, `SELECT slug FROM products WHERE org_id=$1 AND slug IS NOT NULL`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	taken := map[string]bool{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		taken[s] = true
+	}
+	return taken, rows.Err()
+}
+
+// nextFreeSlug segue a mesma regra de uniqueProductSlug, sobre um conjunto
+// em memória (as linhas da própria planilha ainda não estão no banco).
+func nextFreeSlug(title string, taken map[string]bool) string {
+	slug := slugify(title)
+	if slug == "" {
+		slug = "produto"
+	}
+	if len(slug) > 72 {
+		slug = strings.TrimRight(slug[:72], "-")
+	}
+	if !taken[slug] {
+		return slug
+	}
+	for n := 2; ; n++ {
+		if cand := slug + "-" + strconv.Itoa(n); !taken[cand] {
+			return cand
+		}
+	}
+}
+
+// importColumns resolve o índice de cada campo no cabeçalho.
+func importColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	idx := map[string]int{}
+	for i, h := range header {
+		idx[importKey(h)] = i
+	}
+	cols := map[string]int{}
+	for field, col := range mapping {
+		if _, ok := importFieldAliases[field]; !ok {
+			return nil, fmt.Errorf("mapping: unknown field %q", field)
+		}
+		i, ok := idx[importKey(col)]
+		if !ok {
+			return nil, fmt.Errorf("mapping: column %q not found in header", col)
+		}
+		cols[field] = i
+	}
+	for field, aliases := range importFieldAliases {
+		if _, ok := cols[field]; ok {
+			continue
+		}
+		for _, al := range aliases {
+			if i, ok := idx[al]; ok {
+				cols[field] = i
+				break
+			}
+		}
+	}
+	if _, ok := cols["title"]; !ok {
+		return nil, errors.New("title column not found (use mapping, e.g. {\"title\":\"Nome\"})")
+	}
+	return cols, nil
+}
+
+// importKey normaliza um nome de coluna: minúsculas, sem acento, "_" no
+// lugar de espaços.
+func importKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(foldAccent(r))
+		case r == ' ' || r == '_' || r == '-':
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func foldAccent(r rune) rune {
+	switch r {
+	case 'á', 'à', 'â', 'ã', 'ä':
+		return 'a'
+	case 'é', 'è', 'ê', 'ë':
+		return 'e'
+	case 'í', 'ì', 'î', 'ï':
+		return 'i'
+	case 'ó', 'ò', 'ô', 'õ', 'ö':
+		return 'o'
+	case 'ú', 'ù', 'û', 'ü':
+		return 'u'
+	case 'ç':
+		return 'c'
+	}
+	return r
+}
+
+func importRowBlank(rec []string) bool {
+	for _, v := range rec {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseImportRow valida uma linha e devolve o produto e os erros.
+func parseImportRow(rec []string, cols map[string]int) (importProduct, []string) {
+	get := func(field string) string {
+		i, ok := cols[field]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	var errs []string
+	p := importProduct{
+		Title:       limitRunes(get("title"), 200),
+		Description: get("description"),
+		Category:    get("category"),
+		ImageURL:    get("image_url"),
+		Status:      strings.ToLower(nonEmpty(get("status"), "active")),
+	}
+	if p.Title == "" {
+		errs = append(errs, "title required")
+	}
+	if v := get("price_cents"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			errs = append(errs, fmt.Sprintf("invalid price_cents %q", v))
+		}
+		p.PriceCents = n
+	} else if v := get("price"); v != "" {
+		n, err := parsePriceCents(v)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		p.PriceCents = n
+	}
+	if v := get("stock"); v != "" {
+		f, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", "."), 64)
+		if err != nil || f < 0 || f != float64(int(f)) {
+			errs = append(errs, fmt.Sprintf("invalid stock %q", v))
+		}
+		p.Stock = int(f)
+	}
+	switch p.Status {
+	case "active", "ativo":
+		p.Status = "active"
+	case "inactive", "inativo", "draft", "rascunho":
+		p.Status = "inactive"
+	default:
+		errs = append(errs, fmt.Sprintf("invalid status %q (use active or inactive)", p.Status))
+	}
+	if v := get("slug"); v != "" {
+		if p.Slug = slugify(v); p.Slug == "" {
+			errs = append(errs, fmt.Sprintf("invalid slug %q", v))
+		}
+	}
+	if p.ImageURL != "" && !strings.HasPrefix(p.ImageURL, "http://") && !strings.HasPrefix(p.ImageURL, "https://") &&
+		!strings.HasPrefix(p.ImageURL, "/uploads/") {
+		errs = append(errs, fmt.Sprintf("invalid image_url %q (use an http(s) URL)", p.ImageURL))
+	}
+	return p, errs
+}
+
+// parsePriceCents aceita "19,90", "19.90", "R$ 1.234,56" e "1,234.56".
+func parsePriceCents(v string) (int, error) {
+	s := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v), "R$"))
+	s = strings.ReplaceAll(s, " ", "")
+	lastComma, lastDot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	switch {
+	case lastComma > lastDot: // vírgula decimal (pt-BR)
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	case lastDot > lastComma: // ponto decimal
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid price %q", v)
+	}
+	return int(f*100 + 0.5), nil
+}
+
+// readImportCSV lê CSV com separador "," ou ";" (Excel pt-BR) e BOM opcional.
+func readImportCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(first, []byte(";")) > bytes.Count(first, []byte(",")) {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	return cr.ReadAll()
+}
+
+// readXLSX lê a primeira planilha de um .xlsx só com a biblioteca padrão
+// (valores já calculados; fórmulas e formatação são ignoradas).
+func readXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx: %w", err)
+	}
+	files := map[string]*zip.File{}
+	var sheets []string
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if strings.HasPrefix(f.Name, "xl/worksheets/") && strings.HasSuffix(f.Name, ".xml") {
+			sheets = append(sheets, f.Name)
+		}
+	}
+	if len(sheets) == 0 {
+		return nil, errors.New("invalid xlsx: no worksheets")
+	}
+	sort.Strings(sheets)
+	sheet := sheets[0]
+	if _, ok := files["xl/worksheets/sheet1.xml"]; ok {
+		sheet = "xl/worksheets/sheet1.xml"
+	}
+
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []struct {
+				T    string `xml:"t"`
+				Runs []struct {
+					T string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"si"`
+		}
+		if err := decodeZipXML(f, &sst); err != nil {
+			return nil, err
+		}
+		for _, it := range sst.Items {
+			s := it.T
+			for _, r := range it.Runs {
+				s += r.T
+			}
+			shared = append(shared, s)
+		}
+	}
+
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				V      string `xml:"v"`
+				Inline struct {
+					T string `xml:"t"`
+				} `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeZipXML(files[sheet], &ws); err != nil {
+		return nil, err
+	}
+	var out [][]string
+	for _, row := range ws.Rows {
+		var rec []string
+		for i, c := range row.Cells {
+			col := xlsxColumn(c.Ref)
+			if col < 0 {
+				col = i
+			}
+			for len(rec) <= col {
+				rec = append(rec, "")
+			}
+			switch c.Type {
+			case "s":
+				if n, err := strconv.Atoi(c.V); err == nil && n >= 0 && n < len(shared) {
+					rec[col] = shared[n]
+				}
+			case "inlineStr":
+				rec[col] = c.Inline.T
+			default:
+				rec[col] = c.V
+			}
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func decodeZipXML(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(io.LimitReader(rc, 64<<20)).Decode(v); err != nil {
+		return fmt.Errorf("invalid xlsx (%s): %w", f.Name, err)
+	}
+	return nil
+}
+
+// xlsxColumn converte a referência da célula ("C12") no índice da coluna
+// (2); -1 se não houver letras.
+func xlsxColumn(ref string) int {
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+	return n - 1
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Janela de disponibilidade de produtos
+// ================================================================
+//
+// PUT /api/products/{id}/availability
+//     {"from":"2026-12-01T00:00:00-03:00","until":null,"weekdays":[5,6,0]}
+//
+// from/until limitam o período (qualquer um pode ser nulo) e weekdays os
+// dias da semana (0 = domingo), no fuso de APPOINTMENT_TZ. Sem nenhum dos três
+// o produto não tem janela. Fora da janela o produto some do agente, da
+// vitrine e dos feeds (productAvailableSQL) e o worker
+// (PRODUCT_SCHEDULE_INTERVAL, padrão 1m; 0 desliga) troca o status:
+// active -> scheduled ao sair da janela, scheduled -> active ao voltar.
+// Produto desativado à mão (inactive) não é reativado.
+
+func (a *App) mountProductSchedule(r chi.Router) {
+	r.Put("/products/{id}/availability", a.setProductAvailability)
+}
+
+func productScheduleInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("PRODUCT_SCHEDULE_INTERVAL", "1m"))
+	if err != nil || d < 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// productAvailableSQL é o filtro "dentro da janela agora" sobre as colunas de
+// products (sem alias), para somar ao status='active' das consultas. Produto
+// na lixeira (product_archive.go) nunca está disponível.
+func productAvailableSQL() string {
+	tz := strings.ReplaceAll(appointmentLoc().String(), "'", "")
+	return `deleted_at IS NULL
+   AND (available_from IS NULL OR available_from <= NOW())
+   AND (available_until IS NULL OR available_until > NOW())
+   AND (available_weekdays IS NULL OR EXTRACT(DOW FROM NOW() AT TIME ZONE '` + tz + `')::int = ANY(available_weekdays))`
+}
+
+// productAvailability é a janela devolvida pela API.
+type productAvailability struct {
+	ProductID int64      `json:"product_id"`
+	Status    string     `json:"status"`
+	From      *time.Time `json:"from"`
+	Until     *time.Time `json:"until"`
+	Weekdays  []int      `json:"weekdays"`
+}
+
+// PUT /api/products/{id}/availability
+func (a *App) setProductAvailability(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	var in struct {
+		From     *time.Time `json:"from"`
+		Until    *time.Time `json:"until"`
+		Weekdays []int      `json:"weekdays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.From != nil && in.Until != nil && !in.Until.After(*in.From) {
+		render.Error(w, http.StatusBadRequest, "until must be after from")
+		return
+	}
+	var weekdays []int
+	seen := map[int]bool{}
+	for _, d := range in.Weekdays {
+		if d < 0 || d > 6 {
+			render.Error(w, http.StatusBadRequest, "weekdays must be between 0 (sunday) and 6 (saturday)")
+			return
+		}
+		if !seen[d] {
+			seen[d] = true
+			weekdays = append(weekdays, d)
+		}
+	}
+	sort.Ints(weekdays)
+	if len(weekdays) == 7 {
+		weekdays = nil // todos os dias = sem restrição
+	}
+
+	ctx := r.Context()
+	out := productAvailability{ProductID: id}
+	err = a.DB.QueryRow(ctx, `
+UPDATE products SET available_from=$3, available_until=$4, available_weekdays=$5
+ WHERE id=$1 AND org_id=$2
+RETURNING status, available_from, available_until, available_weekdays`,
+		id, orgID, in.From, in.Until, weekdays).Scan(&out.Status, &out.From, &out.Until, &out.Weekdays)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "product not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// aplica já, sem esperar o worker
+	if err := a.applyProductSchedule(ctx, id); err != nil {
+		log.Printf("product %d schedule: %v", id, err)
+	}
+	_ = a.DB.QueryRow(ctx, `SELECT status FROM products WHERE id=$1`, id).Scan(&out.Status)
+	a.touchProductFeed(orgID, flowID)
+	render.OK(w, out)
+}
+
+func (a *App) productScheduleLoop() {
+	every := productScheduleInterval()
+	if every == 0 {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := a.applyProductSchedule(ctx, 0); err != nil {
+			log.Printf("product schedule: %v", err)
+		}
+		cancel()
+	}
+}
+
+// applyProductSchedule troca o status dos produtos com janela (todos, ou só
+// productID) e regera os feeds dos tenants afetados.
+func (a *App) applyProductSchedule(ctx context.Context, productID int64) error {
+	rows, err := a.DB.Query(ctx, `
+UPDATE products SET status = CASE status WHEN 'active' THEN 'scheduled' ELSE 'active' END
+ WHERE ($1 = 0 OR id = $1)
+   AND (available_from IS NOT NULL OR available_until IS NOT NULL OR available_weekdays IS NOT NULL
+        OR status = 'scheduled')
+   AND ((status = 'active' AND NOT (`+productAvailableSQL()+`))
+     OR (status = 'scheduled' AND `+productAvailableSQL()+`))
+RETURNING org_id, flow_id`, productID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type tenant struct{ org, flow int64 }
+	touched := map[tenant]bool{}
+	for rows.Next() {
+		var t tenant
+		if err := rows.Scan(&t.org, &t.flow); err != nil {
+			return err
+		}
+		touched[t] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for t := range touched {
+		a.touchProductFeed(t.org, t.flow)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Busca de produtos (GET /api/products/search?q=)
+// ================================================================
+//
+// Full-text em products.search_vector, coluna gerada com título (peso A),
+// tags (B), categoria (C) e slug (D) no dicionário portuguese. Cada termo da
+// busca vira prefixo ("cami" acha "camiseta"), todos obrigatórios, e o
+// resultado vem ordenado por ts_rank_cd. Sem nenhum acerto, cai para o
+// trigram (pg_trgm, tolerante a erro de digitação) ou, sem a extensão, ILIKE;
+// o campo "match" diz qual foi usado.
+//
+// "highlight" é o título em HTML escapado com <mark> nos termos encontrados.
+// Só entram produtos ativos e dentro da janela de disponibilidade. A mesma
+// busca atende a vitrine (GET /store/{org}/search) e a ferramenta
+// search_products do agente.
+
+const (
+	productSearchMaxTerms = 8
+
+	// marcadores do ts_headline, trocados por <mark> depois do escape
+	searchMarkStart = "\x02"
+	searchMarkStop  = "\x03"
+)
+
+type productSearchHit struct {
+	ID         int64    `json:"id"`
+	Title      string   `json:"title"`
+	Slug       string   `json:"slug,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	PriceCents int      `json:"price_cents"`
+	InStock    bool     `json:"in_stock"`
+	Rank       float64  `json:"rank"`
+	Match      string   `json:"match"` // fulltext | fuzzy
+	Highlight  string   `json:"highlight"`
+	URL        string   `json:"url,omitempty"` // só na vitrine
+}
+
+// GET /api/products/search?q=camiseta azul&limit=20&offset=0
+func (a *App) searchProductsFTS(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit, offset := productSearchPage(r)
+	items, err := a.findProducts(r.Context(), orgID, flowID, q, limit, offset)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"query": q, "items": items, "limit": limit, "offset": offset})
+}
+
+// GET /store/{org}/search?q=
+func (a *App) storeSearch(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := storeOrgID(r)
+	if !ok {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit, offset := productSearchPage(r)
+	items, err := a.findProducts(r.Context(), orgID, 0, q, limit, offset)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range items {
+		slug := items[i].Slug
+		if !slugRe.MatchString(slug) {
+			slug = ""
+		}
+		items[i].URL = productPublicURL(r, orgID, items[i].ID, slug)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	render.OK(w, map[string]any{"query": q, "items": items})
+}
+
+func productSearchPage(r *http.Request) (limit, offset int) {
+	limit = 20
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 100 {
+		limit = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && n > 0 && n <= 10000 {
+		offset = n
+	}
+	return limit, offset
+}
+
+// productTSQuery converte o texto livre em tsquery de prefixos ("a:* & b:*"),
+// sem operadores vindos do usuário. Vazio quando não sobra termo.
+func productTSQuery(q string) string {
+	terms := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > productSearchMaxTerms {
+		terms = terms[:productSearchMaxTerms]
+	}
+	for i, t := range terms {
+		terms[i] = t + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// findProducts busca produtos ativos do tenant; flowID 0 busca na org toda
+// (vitrine).
+func (a *App) findProducts(ctx context.Context, orgID, flowID int64, q string, limit, offset int) ([]productSearchHit, error) {
+	tsq := productTSQuery(q)
+	if tsq == "" {
+		return []productSearchHit{}, nil
+	}
+	where := `org_id=$1 AND ($2 = 0 OR flow_id=$2) AND status='active' AND ` + productAvailableSQL()
+	hits, err := a.collectProductHits(ctx, "fulltext", `
+SELECT id, title, COALESCE(slug,''), COALESCE(category,''), tags, price_cents, stock > 0,
+       ts_rank_cd(search_vector, tsq, 32)::float8,
+       ts_headline('portuguese', title, tsq, $6)
+  FROM products, to_tsquery('portuguese', $3) tsq
+ WHERE `+where+` AND search_vector @@ tsq
+ ORDER BY 8 DESC, id DESC
+ LIMIT $4 OFFSET $5`, orgID, flowID, tsq, limit, offset,
+		"StartSel="+searchMarkStart+", StopSel="+searchMarkStop+", HighlightAll=true")
+	if err != nil || len(hits) > 0 {
+		return hits, err
+	}
+	if offset > 0 {
+		// página além do fim do full-text não é motivo para trocar de modo
+		var found bool
+		if err := a.DB.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM products WHERE `+where+` AND search_vector @@ to_tsquery('portuguese', $3))`,
+			orgID, flowID, tsq).Scan(&found); err != nil || found {
+			return hits, err
+		}
+	}
+
+	// sem acerto: provável erro de digitação
+	q = strings.ToLower(strings.TrimSpace(q))
+	if trgmEnabled {
+		return a.collectProductHits(ctx, "fuzzy", `
+SELECT id, title, COALESCE(slug,''), COALESCE(category,''), tags, price_cents, stock > 0,
+       GREATEST(similarity(lower(title), $3), word_similarity($3, lower(title)))::float8,
+       title
+  FROM products
+ WHERE `+where+` AND (lower(title) % $3 OR $3 <% lower(title))
+ ORDER BY 8 DESC, id DESC
+ LIMIT $4 OFFSET $5`, orgID, flowID, q, limit, offset)
+	}
+	return a.collectProductHits(ctx, "fuzzy", `
+SELECT id, title, COALESCE(slug,''), COALESCE(category,''), tags, price_cents, stock > 0,
+       0.5::float8, title
+  FROM products
+ WHERE `+where+`
+   AND (lower(title) LIKE '%' || $3 || '%' OR lower(COALESCE(category,'')) LIKE '%' || $3 || '%')
+ ORDER BY id DESC
+ LIMIT $4 OFFSET $5`, orgID, flowID, escapeLike(q), limit, offset)
+}
+
+// productTags limpa as tags do produto (minúsculas, sem repetição, até 20 de
+// 40 caracteres). nil continua nil: no update significa "não mexer".
+func productTags(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, t := range in {
+		t = limitRunes(strings.ToLower(strings.TrimSpace(t)), 40)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		if out = append(out, t); len(out) == 20 {
+			break
+		}
+	}
+	return out
+}
+
+func (a *App) collectProductHits(ctx context.Context, match, sql string, args ...any) ([]productSearchHit, error) {
+	rows, err := a.DB.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []productSearchHit{}
+	for rows.Next() {
+		h := productSearchHit{Match: match}
+		if err := rows.Scan(&h.ID, &h.Title, &h.Slug, &h.Category, &h.Tags, &h.PriceCents, &h.InStock,
+			&h.Rank, &h.Highlight); err != nil {
+			return nil, err
+		}
+		h.Highlight = strings.NewReplacer(searchMarkStart, "<mark>", searchMarkStop, "</mark>").
+			Replace(html.EscapeString(h.Highlight))
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// ================================================================
+//  Slugs de produto: geração automática e unicidade por org
+// ================================================================
+//
+// Sem slug explícito, o slug é gerado a partir do título; em caso de
+// colisão na mesma org recebe sufixo numérico (camiseta, camiseta-2, ...).
+// Linhas antigas (slug vazio, texto livre gerado pela IA ou duplicado) e o
+// índice único (org_id, slug) ficam em migrations/0059_product_slugs.sql.
+
+// uniqueProductSlug devolve um slug livre na org derivado de base.
+func (a *App) uniqueProductSlug(ctx context.Context, orgID int64, base string, exceptID int64) (string, error) {
+	slug := slugify(base)
+	if slug == "" {
+		slug = "produto"
+	}
+	if len(slug) > 72 {
+		slug = strings.TrimRight(slug[:72], "-")
+	}
+	rows, err := a.DB.Query(ctx, `
+SELECT slug FROM products
+ WHERE org_id=$1 AND id<>$3 AND (slug=$2 OR slug LIKE $2 || '-%')`, orgID, slug, exceptID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	taken := map[string]bool{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return "", err
+		}
+		taken[s] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if !taken[slug] {
+		return slug, nil
+	}
+	for n := 2; ; n++ {
+		cand := slug + "-" + strconv.Itoa(n)
+		if !taken[cand] {
+			return cand, nil
+		}
+	}
+}
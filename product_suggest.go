@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Autocomplete de produtos (GET /api/products/suggest?q=)
+// ================================================================
+//
+// Resposta enxuta (id, título, slug, preço) para o autocomplete do dashboard
+// e o casamento aproximado de nomes pelo agente. Com a extensão pg_trgm, o
+// ranking usa similarity() e índice GIN trigram; sem ela (sem permissão para
+// CREATE EXTENSION), cai para ILIKE por prefixo/substring. Extensão e índice
+// vêm de migrations/0060_product_trgm.sql.
+
+// trgmEnabled é definido na montagem do catálogo.
+var trgmEnabled bool
+
+type productSuggestion struct {
+	ID         int64   `json:"id"`
+	Title      string  `json:"title"`
+	Slug       string  `json:"slug,omitempty"`
+	PriceCents int     `json:"price_cents"`
+	Score      float64 `json:"score"`
+}
+
+// detectProductTrgm liga trgmEnabled se a migração conseguiu criar o índice.
+func (a *App) detectProductTrgm(ctx context.Context) {
+	err := a.DB.QueryRow(ctx,
+		`SELECT to_regclass('public.idx_products_title_trgm') IS NOT NULL`).Scan(&trgmEnabled)
+	if err != nil {
+		log.Printf("products trigram index: %v", err)
+	}
+	if !trgmEnabled {
+		log.Printf("pg_trgm unavailable, suggest will use ILIKE")
+	}
+}
+
+// escapeLike protege %, _ e \ para uso em LIKE/ILIKE.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GET /api/products/suggest?q=cami&limit=10
+func (a *App) suggestProducts(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		render.OK(w, map[string]any{"items": []productSuggestion{}})
+		return
+	}
+	limit := 10
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 50 {
+		limit = n
+	}
+	items, err := a.findProductSuggestions(r.Context(), orgID, flowID, q, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"items": items})
+}
+
+// findProductSuggestions busca produtos ativos cujo título se parece com q.
+func (a *App) findProductSuggestions(ctx context.Context, orgID, flowID int64, q string, limit int) ([]productSuggestion, error) {
+	q = strings.ToLower(q)
+	var sql string
+	if trgmEnabled {
+		sql = `
+SELECT id, title, COALESCE(slug,''), price_cents,
+       GREATEST(similarity(lower(title), $3), CASE WHEN lower(title) LIKE $4 || '%' THEN 1 ELSE 0 END)::float8 AS score
+  FROM products
+ WHERE org_id=$1 AND flow_id=$2 AND status='active' AND `+productAvailableSQL()+`
+   AND (lower(title) % $3 OR lower(title) LIKE '%' || $4 || '%')
+ ORDER BY score DESC, title
+ LIMIT $5`
+	} else {
+		sql = `
+SELECT id, title, COALESCE(slug,''), price_cents,
+       (CASE WHEN lower(title) LIKE $4 || '%' THEN 1 ELSE 0.5 END)::float8 AS score
+  FROM products
+ WHERE org_id=$1 AND flow_id=$2 AND status='active' AND `+productAvailableSQL()+`
+   AND lower(title) LIKE '%' || $4 || '%' AND $3 <> ''
+ ORDER BY score DESC, title
+ LIMIT $5`
+	}
+	rows, err := a.DB.Query(ctx, sql, orgID, flowID, q, escapeLike(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []productSuggestion{}
+	for rows.Next() {
+		var s productSuggestion
+		if err := rows.Scan(&s.ID, &s.Title, &s.Slug, &s.PriceCents, &s.Score); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
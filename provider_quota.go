@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Uso dos provedores por tenant e cotas globais (operador)
+// ================================================================
+//
+// GET /api/admin/provider-usage?all=true
+//
+// Todas as orgs dividem a mesma OPENAI_API_KEY e a mesma conta uazapi. Este
+// painel soma, por org, o que cada uma consome e compara com as cotas
+// globais configuradas pelo operador:
+//
+//   OPENAI_QUOTA_TPM           tokens por minuto da chave (janela: último minuto)
+//   OPENAI_QUOTA_RPM           requisições por minuto
+//   OPENAI_QUOTA_MONTHLY_USD   gasto do mês (UTC)
+//   WA_QUOTA_DAILY_MESSAGES    envios por dia (UTC) somando uazapi e Meta
+//
+// IA vem de ai_usage, sem as chamadas feitas com a chave própria da org
+// (byok); WhatsApp, de provider_usage_daily (gravado em waProviderSend). Cota não configurada (0) não gera alerta.
+//
+// Alertas (campo "alerts" e, a cada PROVIDER_QUOTA_CHECK_INTERVAL, padrão
+// 5m, no log e no e-mail PROVIDER_QUOTA_ALERT_EMAIL, no máximo um por hora
+// para o mesmo alerta):
+//   - plataforma acima de PROVIDER_QUOTA_ALERT_PCT (padrão 80) da cota:
+//     warning; acima de 100: critical;
+//   - uma org sozinha acima de PROVIDER_TENANT_ALERT_PCT (padrão 50) da cota.
+//
+// Com OPENAI_TENANT_MAX_SHARE_PCT > 0, a org que passar dessa fatia do TPM
+// ou RPM no último minuto recebe 429 no chat, na visão e nas campanhas
+// (requireAIBudget) até a janela aliviar.
+
+const (
+	quotaOpenAITPM     = "openai_tpm"
+	quotaOpenAIRPM     = "openai_rpm"
+	quotaOpenAIMonthly = "openai_monthly_usd"
+	quotaWADaily       = "wa_daily_messages"
+
+	providerAlertWarning  = "warning"
+	providerAlertCritical = "critical"
+)
+
+type providerQuotas struct {
+	Limits         map[string]float64 `json:"limits"`
+	AlertPct       float64            `json:"alert_pct"`
+	TenantAlertPct float64            `json:"tenant_alert_pct"`
+	TenantMaxShare float64            `json:"tenant_max_share_pct,omitempty"`
+}
+
+func providerQuotasFromEnv() providerQuotas {
+	f := func(name string, def float64) float64 {
+		v, err := strconv.ParseFloat(getenv(name, strconv.FormatFloat(def, 'f', -1, 64)), 64)
+		if err != nil || v < 0 {
+			return def
+		}
+		return v
+	}
+	q := providerQuotas{
+		Limits:         map[string]float64{},
+		AlertPct:       f("PROVIDER_QUOTA_ALERT_PCT", 80),
+		TenantAlertPct: f("PROVIDER_TENANT_ALERT_PCT", 50),
+		TenantMaxShare: f("OPENAI_TENANT_MAX_SHARE_PCT", 0),
+	}
+	for metric, env := range map[string]string{
+		quotaOpenAITPM:     "OPENAI_QUOTA_TPM",
+		quotaOpenAIRPM:     "OPENAI_QUOTA_RPM",
+		quotaOpenAIMonthly: "OPENAI_QUOTA_MONTHLY_USD",
+		quotaWADaily:       "WA_QUOTA_DAILY_MESSAGES",
+	} {
+		if v := f(env, 0); v > 0 {
+			q.Limits[metric] = v
+		}
+	}
+	return q
+}
+
+type tenantProviderUsage struct {
+	OrgID int64  `json:"org_id"`
+	Name  string `json:"name"`
+	AI    struct {
+		Requests1m   int64   `json:"requests_1m"`
+		Tokens1m     int64   `json:"tokens_1m"`
+		Requests24h  int64   `json:"requests_24h"`
+		Tokens24h    int64   `json:"tokens_24h"`
+		CostMonthUSD float64 `json:"cost_month_usd"`
+	} `json:"openai"`
+	WA struct {
+		CallsToday       int64 `json:"calls_today"`
+		ErrorsToday      int64 `json:"errors_today"`
+		RateLimitedToday int64 `json:"rate_limited_today"`
+	} `json:"whatsapp"`
+	// fatia de cada cota configurada usada pela org (%)
+	QuotaPct map[string]float64 `json:"quota_pct,omitempty"`
+}
+
+// usage devolve o valor da org para a métrica da cota.
+func (u tenantProviderUsage) usage(metric string) float64 {
+	switch metric {
+	case quotaOpenAITPM:
+		return float64(u.AI.Tokens1m)
+	case quotaOpenAIRPM:
+		return float64(u.AI.Requests1m)
+	case quotaOpenAIMonthly:
+		return u.AI.CostMonthUSD
+	case quotaWADaily:
+		return float64(u.WA.CallsToday)
+	}
+	return 0
+}
+
+type providerAlert struct {
+	Level   string  `json:"level"`
+	Metric  string  `json:"metric"`
+	OrgID   int64   `json:"org_id,omitempty"` // vazio: plataforma inteira
+	Used    float64 `json:"used"`
+	Quota   float64 `json:"quota"`
+	Pct     float64 `json:"pct"`
+	Message string  `json:"message"`
+}
+
+func (al providerAlert) key() string {
+	return fmt.Sprintf("%s/%s/%d", al.Level, al.Metric, al.OrgID)
+}
+
+// loadProviderUsage mede todas as orgs (orgID = 0) ou uma só.
+func (a *App) loadProviderUsage(ctx context.Context, orgID int64) ([]tenantProviderUsage, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT o.id, o.name,
+       COALESCE(ai.req1m, 0), COALESCE(ai.tok1m, 0), COALESCE(ai.req24, 0), COALESCE(ai.tok24, 0), COALESCE(ai.cost_month, 0)::float8,
+       COALESCE(wa.calls, 0), COALESCE(wa.errors, 0), COALESCE(wa.rl, 0)
+  FROM public.orgs o
+  LEFT JOIN LATERAL (
+       SELECT COUNT(*) FILTER (WHERE created_at >= NOW() - INTERVAL '1 minute') AS req1m,
+              SUM(prompt_tokens + completion_tokens) FILTER (WHERE created_at >= NOW() - INTERVAL '1 minute') AS tok1m,
+              COUNT(*) FILTER (WHERE created_at >= NOW() - INTERVAL '24 hours') AS req24,
+              SUM(prompt_tokens + completion_tokens) FILTER (WHERE created_at >= NOW() - INTERVAL '24 hours') AS tok24,
+              SUM(cost_usd) FILTER (WHERE created_at >= $2) AS cost_month
+         FROM public.ai_usage
+        WHERE org_id = o.id AND NOT byok AND created_at >= LEAST($2, NOW() - INTERVAL '24 hours')) ai ON TRUE
+  LEFT JOIN LATERAL (
+       SELECT SUM(calls) AS calls, SUM(errors) AS errors, SUM(rate_limited) AS rl
+         FROM public.provider_usage_daily
+        WHERE org_id = o.id AND day = (NOW() AT TIME ZONE 'UTC')::date) wa ON TRUE
+ WHERE ($1 = 0 OR o.id = $1)
+ ORDER BY o.id`, orgID, monthStartUTC(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []tenantProviderUsage{}
+	for rows.Next() {
+		var u tenantProviderUsage
+		if err := rows.Scan(&u.OrgID, &u.Name, &u.AI.Requests1m, &u.AI.Tokens1m, &u.AI.Requests24h, &u.AI.Tokens24h, &u.AI.CostMonthUSD,
+			&u.WA.CallsToday, &u.WA.ErrorsToday, &u.WA.RateLimitedToday); err != nil {
+			return nil, err
+		}
+		u.AI.CostMonthUSD = roundUSD(u.AI.CostMonthUSD)
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func quotaPct(used, quota float64) float64 {
+	return math.Round(used/quota*1000) / 10
+}
+
+// providerAlerts preenche QuotaPct de cada org e devolve os totais da
+// plataforma e os alertas, do mais grave para o menos.
+func providerAlerts(q providerQuotas, usage []tenantProviderUsage) (map[string]float64, []providerAlert) {
+	totals := map[string]float64{}
+	alerts := []providerAlert{}
+	for i := range usage {
+		u := &usage[i]
+		for metric, quota := range q.Limits {
+			used := u.usage(metric)
+			totals[metric] += used
+			if used <= 0 {
+				continue
+			}
+			if u.QuotaPct == nil {
+				u.QuotaPct = map[string]float64{}
+			}
+			pct := quotaPct(used, quota)
+			u.QuotaPct[metric] = pct
+			if q.TenantAlertPct > 0 && pct >= q.TenantAlertPct {
+				alerts = append(alerts, providerAlert{
+					Level: providerAlertWarning, Metric: metric, OrgID: u.OrgID, Used: used, Quota: quota, Pct: pct,
+					Message: fmt.Sprintf("org %d (%s) alone uses %.1f%% of %s", u.OrgID, u.Name, pct, metric),
+				})
+			}
+		}
+	}
+	for metric, quota := range q.Limits {
+		pct := quotaPct(totals[metric], quota)
+		level := ""
+		switch {
+		case pct >= 100:
+			level = providerAlertCritical
+		case q.AlertPct > 0 && pct >= q.AlertPct:
+			level = providerAlertWarning
+		}
+		if level != "" {
+			alerts = append(alerts, providerAlert{
+				Level: level, Metric: metric, Used: totals[metric], Quota: quota, Pct: pct,
+				Message: fmt.Sprintf("platform at %.1f%% of %s", pct, metric),
+			})
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].Level != alerts[j].Level {
+			return alerts[i].Level == providerAlertCritical
+		}
+		return alerts[i].Pct > alerts[j].Pct
+	})
+	return totals, alerts
+}
+
+// GET /api/admin/provider-usage?all=true
+// Sem all=true, só as orgs com algum consumo nas janelas.
+func (a *App) adminProviderUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := a.loadProviderUsage(r.Context(), 0)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	q := providerQuotasFromEnv()
+	totals, alerts := providerAlerts(q, usage)
+	if all, _ := strconv.ParseBool(r.URL.Query().Get("all")); !all {
+		active := usage[:0]
+		for _, u := range usage {
+			if u.AI.Requests24h > 0 || u.AI.CostMonthUSD > 0 || u.WA.CallsToday > 0 {
+				active = append(active, u)
+			}
+		}
+		usage = active
+	}
+	// maiores consumidores primeiro
+	sort.SliceStable(usage, func(i, j int) bool {
+		if usage[i].AI.Tokens24h != usage[j].AI.Tokens24h {
+			return usage[i].AI.Tokens24h > usage[j].AI.Tokens24h
+		}
+		return usage[i].WA.CallsToday > usage[j].WA.CallsToday
+	})
+	render.OK(w, map[string]any{
+		"items":  usage,
+		"totals": totals,
+		"quotas": q,
+		"alerts": alerts,
+	})
+}
+
+// recordProviderCall soma uma chamada de envio ao provedor de WhatsApp.
+func (a *App) recordProviderCall(orgID int64, provider string, status int, callErr error) {
+	if orgID <= 0 {
+		return
+	}
+	failed := callErr != nil || status >= 400
+	limited := status == http.StatusTooManyRequests
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := a.DB.Exec(ctx, `
+INSERT INTO public.provider_usage_daily (org_id, provider, day, calls, errors, rate_limited)
+VALUES ($1, $2, (NOW() AT TIME ZONE 'UTC')::date, 1, $3::int, $4::int)
+ON CONFLICT (org_id, provider, day) DO UPDATE SET
+  calls = provider_usage_daily.calls + 1,
+  errors = provider_usage_daily.errors + EXCLUDED.errors,
+  rate_limited = provider_usage_daily.rate_limited + EXCLUDED.rate_limited,
+  updated_at = NOW()`, orgID, provider, failed, limited)
+	if err != nil {
+		log.Printf("provider usage org=%d: %v", orgID, err)
+	}
+}
+
+// aiQuotaShareExceeded diz se a org passou de OPENAI_TENANT_MAX_SHARE_PCT
+// do TPM/RPM global no último minuto. Falhas de consulta não bloqueiam.
+func (a *App) aiQuotaShareExceeded(ctx context.Context, orgID int64) bool {
+	q := providerQuotasFromEnv()
+	tpm, rpm := q.Limits[quotaOpenAITPM], q.Limits[quotaOpenAIRPM]
+	if orgID <= 0 || q.TenantMaxShare <= 0 || (tpm == 0 && rpm == 0) {
+		return false
+	}
+	var reqs, toks int64
+	err := a.DB.QueryRow(ctx, `
+SELECT COUNT(*), COALESCE(SUM(prompt_tokens + completion_tokens), 0)
+  FROM public.ai_usage WHERE org_id=$1 AND NOT byok AND created_at >= NOW() - INTERVAL '1 minute'`, orgID).Scan(&reqs, &toks)
+	if err != nil {
+		log.Printf("ai quota share org=%d: %v", orgID, err)
+		return false
+	}
+	return (tpm > 0 && quotaPct(float64(toks), tpm) >= q.TenantMaxShare) ||
+		(rpm > 0 && quotaPct(float64(reqs), rpm) >= q.TenantMaxShare)
+}
+
+func aiQuotaShareMessage() string {
+	return "shared AI capacity limit reached for this organization, retry in a minute"
+}
+
+// ----------------------------------------------------------------
+//  Monitor periódico
+// ----------------------------------------------------------------
+
+func providerQuotaCheckInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("PROVIDER_QUOTA_CHECK_INTERVAL", "5m"))
+	if err != nil || d < 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+var (
+	providerAlertMu   sync.Mutex
+	providerAlertSent = map[string]time.Time{}
+)
+
+// providerQuotaLoop só roda com alguma cota configurada.
+func (a *App) providerQuotaLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		q := providerQuotasFromEnv()
+		if len(q.Limits) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		usage, err := a.loadProviderUsage(ctx, 0)
+		if err != nil {
+			cancel()
+			log.Printf("provider quota check: %v", err)
+			continue
+		}
+		_, alerts := providerAlerts(q, usage)
+		a.notifyProviderAlerts(ctx, alerts)
+		cancel()
+	}
+}
+
+func (a *App) notifyProviderAlerts(ctx context.Context, alerts []providerAlert) {
+	now := time.Now()
+	var fresh []providerAlert
+	providerAlertMu.Lock()
+	for _, al := range alerts {
+		if last, ok := providerAlertSent[al.key()]; ok && now.Sub(last) < time.Hour {
+			continue
+		}
+		providerAlertSent[al.key()] = now
+		fresh = append(fresh, al)
+	}
+	providerAlertMu.Unlock()
+	if len(fresh) == 0 {
+		return
+	}
+	lines := make([]string, 0, len(fresh))
+	for _, al := range fresh {
+		log.Printf("provider quota %s: %s", al.Level, al.Message)
+		lines = append(lines, fmt.Sprintf("[%s] %s (%.0f of %.0f)", al.Level, al.Message, al.Used, al.Quota))
+	}
+	to := strings.TrimSpace(getenv("PROVIDER_QUOTA_ALERT_EMAIL", ""))
+	if to == "" {
+		return
+	}
+	err := emailSenderFromEnv().Send(ctx, emailMessage{
+		To:      to,
+		Subject: fmt.Sprintf("PacLead: %d provider quota alert(s)", len(fresh)),
+		Text:    strings.Join(lines, "\n") + "\n\nGET /api/admin/provider-usage\n",
+	})
+	if err != nil {
+		log.Printf("provider quota alert email: %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Rate limit (token bucket) por IP e por org
+// ================================================================
+//
+// Cada limite é um balde de N fichas (N = requisições por minuto) que se
+// repõe continuamente. Estourado, a resposta é 429 com Retry-After.
+//
+//   RATE_LIMIT_AUTH_PER_MIN  por IP,  /api/auth/*          (padrão 20)
+//   RATE_LIMIT_CHAT_PER_MIN  por org, /api/chat, /api/vision (padrão 120)
+//   RATE_LIMIT_API_PER_MIN   por org, grupo autenticado     (padrão 0 = sem)
+//
+// 0 desliga o limite. O limite por org só vale para org autenticada (JWT ou
+// API key); sem ela, ou com org só nos headers X-Org-ID, cai para o IP.
+//
+// Os baldes ficam em memória (um por réplica). Com RATE_LIMIT_REDIS_URL
+// (redis://[usuario:senha@]host:6379/db, ou rediss:// com TLS; com usuário
+// o AUTH vai na forma ACL, AUTH usuario senha) passam a ser compartilhados
+// entre réplicas via script Lua; se o Redis falhar, a requisição é decidida
+// pelo balde em memória e o erro vai para o log.
+// Recusas contam em paclead_rate_limited_total{limit}.
+
+type rateLimitStore interface {
+	// take consome uma ficha do balde key; sem ficha, devolve quanto esperar.
+	take(ctx context.Context, key string, perMin int) (ok bool, retry time.Duration, err error)
+}
+
+var (
+	rateStoreOnce sync.Once
+	rateStore     rateLimitStore
+	rateMemory    = &memoryRateStore{buckets: map[string]*tokenBucket{}}
+)
+
+func rateLimitStoreFromEnv() rateLimitStore {
+	rateStoreOnce.Do(func() {
+		rateStore = rateMemory
+		raw := strings.TrimSpace(getenv("RATE_LIMIT_REDIS_URL", ""))
+		if raw == "" {
+			return
+		}
+		s, err := newRedisRateStore(raw)
+		if err != nil {
+			log.Printf("rate limit: RATE_LIMIT_REDIS_URL: %v (using memory)", err)
+			return
+		}
+		rateStore = s
+	})
+	return rateStore
+}
+
+func rateLimitPerMin(envVar string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(getenv(envVar, strconv.Itoa(def))))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+const (
+	rateScopeIP  = "ip"
+	rateScopeOrg = "org"
+)
+
+// rateLimit limita as rotas do grupo a perMin requisições por minuto por IP
+// ou por org (scope). name separa os baldes e rotula a métrica.
+func (a *App) rateLimit(name, scope string, perMin int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if perMin <= 0 {
+			return next
+		}
+		store := rateLimitStoreFromEnv()
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "rl:" + name + ":ip:" + clientIP(r).String()
+			if scope == rateScopeOrg {
+				if t, ok := tenantFrom(r.Context()); ok && t.OrgID > 0 &&
+					(t.Source == tenantSourceJWT || t.Source == tenantSourceAPIKey) {
+					key = "rl:" + name + ":org:" + strconv.FormatInt(t.OrgID, 10)
+				}
+			}
+			ok, retry, err := store.take(r.Context(), key, perMin)
+			if err != nil {
+				logRateStoreError(err)
+				ok, retry, _ = rateMemory.take(r.Context(), key, perMin)
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(perMin))
+			if !ok {
+				rateLimited.inc(name)
+				secs := int(math.Ceil(retry.Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(secs))
+				render.Error(w, http.StatusTooManyRequests, "rate limit exceeded, retry in "+strconv.Itoa(secs)+"s")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var (
+	rateErrMu   sync.Mutex
+	rateErrLast time.Time
+)
+
+// logRateStoreError loga falhas do Redis no máximo uma vez por minuto.
+func logRateStoreError(err error) {
+	rateErrMu.Lock()
+	defer rateErrMu.Unlock()
+	if time.Since(rateErrLast) < time.Minute {
+		return
+	}
+	rateErrLast = time.Now()
+	log.Printf("rate limit: redis: %v (falling back to memory)", err)
+}
+
+// ---------------- memória ----------------
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+type memoryRateStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (s *memoryRateStore) take(_ context.Context, key string, perMin int) (bool, time.Duration, error) {
+	now := time.Now()
+	rate := float64(perMin) / 60 // fichas por segundo
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	b := s.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(perMin), at: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(perMin), b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+}
+
+// sweep descarta baldes parados há mais de 10 min (já estariam cheios).
+func (s *memoryRateStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for k, b := range s.buckets {
+		if now.Sub(b.at) > 10*time.Minute {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+// ---------------- Redis ----------------
+
+// O relógio é o do Redis (TIME), não o de cada réplica.
+const redisTokenBucketScript = `
+local perMin = tonumber(ARGV[1])
+local rate = perMin / 60000
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(b[1]) or perMin
+local ts = tonumber(b[2]) or now
+tokens = math.min(perMin, tokens + (now - ts) * rate)
+local ok, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  ok = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(perMin / rate) + 1000)
+return {ok, wait}`
+
+// redisRateStore fala RESP direto (sem dependência), com um pool pequeno de
+// conexões.
+type redisRateStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	useTLS   bool
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func newRedisRateStore(raw string) (*redisRateStore, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	s := &redisRateStore{addr: u.Host, useTLS: u.Scheme == "rediss", timeout: 2 * time.Second, idle: make(chan *redisConn, 16)}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		if s.db, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid db %q", p)
+		}
+	}
+	return s, nil
+}
+
+func (s *redisRateStore) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: s.timeout}
+	var (
+		c   net.Conn
+		err error
+	)
+	if s.useTLS {
+		c, err = (&tls.Dialer{NetDialer: &d}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		c, err = d.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{Conn: c, br: bufio.NewReader(c)}
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, s.password}
+		}
+		if _, err := rc.do(s.timeout, args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.db > 0 {
+		if _, err := rc.do(s.timeout, "SELECT", strconv.Itoa(s.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (s *redisRateStore) take(ctx context.Context, key string, perMin int) (bool, time.Duration, error) {
+	var c *redisConn
+	select {
+	case c = <-s.idle:
+	default:
+		var err error
+		if c, err = s.dial(ctx); err != nil {
+			return false, 0, err
+		}
+	}
+	reply, err := c.do(s.timeout, "EVAL", redisTokenBucketScript, "1", key, strconv.Itoa(perMin))
+	if err != nil {
+		c.Close()
+		return false, 0, err
+	}
+	select {
+	case s.idle <- c:
+	default:
+		c.Close()
+	}
+	arr, ok := reply.([]any)
+	if !ok || len(arr) != 2 {
+		return false, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	allowed, _ := arr[0].(int64)
+	wait, _ := arr[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+// do envia um comando e lê a resposta.
+func (c *redisConn) do(timeout time.Duration, args ...string) (any, error) {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return readRESP(c.br)
+}
+
+var errRedisNil = errors.New("redis: nil")
+
+func readRESP(br *bufio.Reader) (any, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]any, 0, max(n, 0))
+		for i := 0; i < n; i++ {
+			v, err := readRESP(br)
+			if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
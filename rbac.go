@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ================================================================
+//  Papéis por org (owner/admin/agent)
+// ================================================================
+//
+// users.role (migrations/0014): owner > admin > agent. requireRole protege as
+// rotas destrutivas (exclusão de produto, dados da empresa, configurações e
+// personas do agente, criação de instância de WhatsApp). O papel é lido do
+// banco a cada requisição, então rebaixar alguém vale na hora, sem esperar o
+// JWT expirar.
+//
+// GET    /api/org/users           membros da org
+// POST   /api/org/users           {"name","email","password","role"}
+// PUT    /api/org/users/{id}      {"role":"admin"}
+// DELETE /api/org/users/{id}
+//
+// Admins gerenciam agents; só owners concedem ou retiram admin/owner. A org
+// nunca fica sem owner.
+
+const (
+	roleOwner = "owner"
+	roleAdmin = "admin"
+	roleAgent = "agent"
+)
+
+var roleRank = map[string]int{roleAgent: 1, roleAdmin: 2, roleOwner: 3}
+
+func validRole(role string) bool { return roleRank[role] > 0 }
+
+// roleAtLeast diz se role tem o nível de min ou acima.
+func roleAtLeast(role, min string) bool { return roleRank[role] >= roleRank[min] }
+
+func (a *App) userRole(ctx context.Context, userID, orgID int64) (string, error) {
+	var role string
+	err := a.DB.QueryRow(ctx, `SELECT role FROM users WHERE id=$1 AND org_id=$2`, userID, orgID).Scan(&role)
+	return role, err
+}
+
+// requireRole exige um usuário autenticado com papel min ou superior. Pode
+// ser usado dentro do grupo de requireAuth ou sozinho, em rotas que ainda
+// não passam pelo grupo (aí também valida o token).
+func (a *App) requireRole(min string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		check := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, _ := claimsFromContext(r.Context())
+			role, err := a.userRole(r.Context(), c.UserID, c.OrgID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				render.Error(w, http.StatusUnauthorized, "user not found")
+				return
+			}
+			if err != nil {
+				render.Error(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !roleAtLeast(role, min) {
+				render.Error(w, http.StatusForbidden, "forbidden: requires "+min+" role")
+				return
+			}
+			c.Role = role
+			next.ServeHTTP(w, r.WithContext(withTenantCtx(r.Context(), c)))
+		})
+		authed := a.requireAuth(check)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := claimsFromContext(r.Context()); ok {
+				check.ServeHTTP(w, r)
+				return
+			}
+			authed.ServeHTTP(w, r)
+		})
+	}
+}
+
+type orgUser struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	FlowID    int64     `json:"flow_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *App) mountOrgUsers(r chi.Router) {
+	r.Get("/org/users", a.listOrgUsers)
+	r.With(a.requireRole(roleAdmin)).Post("/org/users", a.createOrgUser)
+	r.With(a.requireRole(roleAdmin)).Put("/org/users/{id}", a.updateOrgUserRole)
+	r.With(a.requireRole(roleAdmin)).Delete("/org/users/{id}", a.deleteOrgUser)
+}
+
+// GET /api/org/users
+func (a *App) listOrgUsers(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, name, email, role, flow_id, created_at FROM users
+ WHERE org_id=$1 ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, lower(name)`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []orgUser{}
+	for rows.Next() {
+		var u orgUser
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.FlowID, &u.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, u)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// canAssignRole: admins só lidam com agents; owners com qualquer papel.
+func canAssignRole(actor, target string) bool {
+	if actor == roleOwner {
+		return true
+	}
+	return actor == roleAdmin && target == roleAgent
+}
+
+// POST /api/org/users
+func (a *App) createOrgUser(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	var in struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+		FlowID   int64  `json:"flow_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	in.Email = strings.TrimSpace(strings.ToLower(in.Email))
+	in.Role = strings.ToLower(nonEmpty(strings.TrimSpace(in.Role), roleAgent))
+	if in.Name == "" || in.Email == "" || in.Password == "" {
+		render.Error(w, http.StatusBadRequest, "name, email and password are required")
+		return
+	}
+	if len(in.Password) < 8 {
+		render.Error(w, http.StatusBadRequest, "password must have at least 8 characters")
+		return
+	}
+	if !validRole(in.Role) {
+		render.Error(w, http.StatusBadRequest, "invalid role (use owner, admin or agent)")
+		return
+	}
+	if !canAssignRole(c.Role, in.Role) {
+		render.Error(w, http.StatusForbidden, "forbidden: only owners can grant "+in.Role)
+		return
+	}
+	ctx := r.Context()
+	if in.FlowID == 0 {
+		in.FlowID = c.FlowID
+	} else if ok, err := a.flowInOrg(ctx, c.OrgID, in.FlowID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if !ok {
+		render.Error(w, http.StatusNotFound, "flow not found")
+		return
+	}
+
+	var exists bool
+	if err := a.DB.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email)=LOWER($1))`, in.Email).Scan(&exists); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		render.Error(w, http.StatusConflict, "user already exists")
+		return
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	u := orgUser{Name: in.Name, Email: in.Email, Role: in.Role, FlowID: in.FlowID}
+	err = a.DB.QueryRow(ctx, `
+INSERT INTO users (org_id, flow_id, name, email, password, role) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at`, c.OrgID, in.FlowID, in.Name, in.Email, string(hashed), in.Role).Scan(&u.ID, &u.CreatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	go a.sendVerificationEmail(u.ID, u.Email, u.Name)
+	render.Created(w, u)
+}
+
+// orgMember carrega o usuário {id} da org do token.
+func (a *App) orgMember(w http.ResponseWriter, r *http.Request) (orgUser, bool) {
+	c, _ := claimsFromContext(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid user id")
+		return orgUser{}, false
+	}
+	var u orgUser
+	err = a.DB.QueryRow(r.Context(),
+		`SELECT id, name, email, role, flow_id, created_at FROM users WHERE id=$1 AND org_id=$2`, id, c.OrgID).
+		Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.FlowID, &u.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "user not found")
+		return u, false
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return u, false
+	}
+	return u, true
+}
+
+// lastOwner diz se u é o único owner da org.
+func (a *App) lastOwner(ctx context.Context, orgID int64, u orgUser) (bool, error) {
+	if u.Role != roleOwner {
+		return false, nil
+	}
+	var n int
+	err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE org_id=$1 AND role='owner'`, orgID).Scan(&n)
+	return n <= 1, err
+}
+
+// PUT /api/org/users/{id}
+func (a *App) updateOrgUserRole(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	u, ok := a.orgMember(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Role = strings.ToLower(strings.TrimSpace(in.Role))
+	if !validRole(in.Role) {
+		render.Error(w, http.StatusBadRequest, "invalid role (use owner, admin or agent)")
+		return
+	}
+	if !canAssignRole(c.Role, u.Role) || !canAssignRole(c.Role, in.Role) {
+		render.Error(w, http.StatusForbidden, "forbidden: only owners can manage admins and owners")
+		return
+	}
+	ctx := r.Context()
+	if in.Role != roleOwner {
+		if last, err := a.lastOwner(ctx, c.OrgID, u); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		} else if last {
+			render.Error(w, http.StatusConflict, "org must keep at least one owner")
+			return
+		}
+	}
+	if _, err := a.DB.Exec(ctx, `UPDATE users SET role=$3 WHERE id=$1 AND org_id=$2`, u.ID, c.OrgID, in.Role); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	u.Role = in.Role
+	render.OK(w, u)
+}
+
+// DELETE /api/org/users/{id}
+func (a *App) deleteOrgUser(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	u, ok := a.orgMember(w, r)
+	if !ok {
+		return
+	}
+	if u.ID == c.UserID {
+		render.Error(w, http.StatusConflict, "cannot remove yourself")
+		return
+	}
+	if !canAssignRole(c.Role, u.Role) {
+		render.Error(w, http.StatusForbidden, "forbidden: only owners can remove admins and owners")
+		return
+	}
+	if last, err := a.lastOwner(r.Context(), c.OrgID, u); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if last {
+		render.Error(w, http.StatusConflict, "org must keep at least one owner")
+		return
+	}
+	if _, err := a.DB.Exec(r.Context(), `DELETE FROM users WHERE id=$1 AND org_id=$2`, u.ID, c.OrgID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.NoContent(w)
+}
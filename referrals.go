@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Programa de indicação
+// ================================================================
+//
+// GET  /api/leads/{id}/referral            código IND-XXXXXX do lead (criado na
+//                                          primeira consulta), link e números
+// GET  /api/referrals/top?from=&to=&limit= ranking de quem mais indicou
+// GET  /api/referrals/settings             regras de recompensa da org
+// PUT  /api/referrals/settings             (admin)
+// GET  /api/referrals/rewards?status=      recompensas geradas
+// POST /api/referrals/rewards/{id}/paid    (admin) marca como paga
+// POST /api/referrals/rewards/{id}/cancel  (admin)
+// GET  /store/{org}/r/{code}               link público de indicação
+//
+// Um lead passa a ser indicado por outro (leads.referred_by_lead_id) quando:
+//   - manda no WhatsApp uma mensagem com o código ("vim pela indicação
+//     IND-AB12CD"); o link público abre o wa.me com esse texto pronto
+//   - POST /api/leads ou /api/orders chega com "ref_code" (checkout do site;
+//     sem número configurado o link leva a STOREFRONT_BASE_URL?ref=CODE)
+// Só vale para lead ainda sem indicação e sem pedido pago, e nunca para o
+// próprio dono do código.
+//
+// Pedido pago de lead indicado é atribuído ao indicador (orders.
+// referrer_lead_id) e, com o programa ativo, gera uma recompensa em
+// referral_rewards: valor fixo (reward_type=fixed, em centavos) ou
+// percentual do pedido (percent, reward_value em pontos-base: 1000 = 10%),
+// por padrão só no primeiro pedido pago do indicado.
+
+const (
+	referralPrefix   = "IND-"
+	referralAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // sem 0/O, 1/I
+	referralCodeLen  = 6
+)
+
+var referralCodeRe = regexp.MustCompile(`(?i)\bIND-?([A-HJ-NP-Z2-9]{6})\b`)
+
+func (a *App) mountReferrals(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.Get("/leads/{id}/referral", a.getLeadReferral)
+	r.Route("/referrals", func(r chi.Router) {
+		r.Get("/top", a.topReferrers)
+		r.Get("/settings", a.getReferralSettings)
+		r.With(admin).Put("/settings", a.putReferralSettings)
+		r.Get("/rewards", a.listReferralRewards)
+		r.With(admin).Post("/rewards/{id}/paid", a.setReferralRewardStatus("paid"))
+		r.With(admin).Post("/rewards/{id}/cancel", a.setReferralRewardStatus("canceled"))
+	})
+
+	onEvent(eventWAMessageReceived, func(ctx context.Context, orgID int64, data any) {
+		m, ok := data.(map[string]any)
+		if !ok {
+			return
+		}
+		leadID, _ := m["lead_id"].(*int64)
+		text, _ := m["text"].(string)
+		code := parseReferralCode(text)
+		if leadID == nil || code == "" {
+			return
+		}
+		if _, err := a.attributeReferral(ctx, orgID, *leadID, code, "message"); err != nil {
+			log.Printf("referral lead=%d: %v", *leadID, err)
+		}
+	})
+	onEvent(eventOrderPaid, func(ctx context.Context, orgID int64, data any) {
+		if o, ok := data.(Order); ok {
+			if err := a.rewardReferral(ctx, o); err != nil {
+				log.Printf("order %d referral: %v", o.ID, err)
+			}
+		}
+	})
+}
+
+// parseReferralCode extrai o código (sem prefixo, maiúsculo) de um texto.
+func parseReferralCode(text string) string {
+	m := referralCodeRe.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return strings.ToUpper(m[1])
+}
+
+func newReferralCode() string {
+	b := make([]byte, referralCodeLen)
+	size := big.NewInt(int64(len(referralAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			panic("crypto/rand: " + err.Error())
+		}
+		b[i] = referralAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// referralCode devolve o código do lead, criando na primeira vez.
+func (a *App) referralCode(ctx context.Context, orgID, leadID int64) (string, error) {
+	for range 5 {
+		var code string
+		err := a.DB.QueryRow(ctx, `SELECT code FROM referral_codes WHERE lead_id=$1 AND org_id=$2`, leadID, orgID).Scan(&code)
+		if err == nil {
+			return code, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+		// colisão de código (ou corrida no mesmo lead) não insere; tenta de novo
+		if _, err := a.DB.Exec(ctx, `
+INSERT INTO referral_codes (org_id, lead_id, code) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			orgID, leadID, newReferralCode()); err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("could not allocate referral code")
+}
+
+// attributeReferral liga o lead ao dono do código. false quando não se aplica
+// (código desconhecido, autoindicação, lead já indicado ou já cliente).
+func (a *App) attributeReferral(ctx context.Context, orgID, leadID int64, code, source string) (bool, error) {
+	code = strings.ToUpper(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(code)), referralPrefix))
+	if code == "" {
+		return false, nil
+	}
+	tag, err := a.DB.Exec(ctx, `
+UPDATE leads l SET referred_by_lead_id = rc.lead_id, referred_at = NOW(), referral_source = $4
+  FROM referral_codes rc
+ WHERE rc.org_id = $1 AND rc.code = $3
+   AND l.id = $2 AND l.org_id = $1 AND l.id <> rc.lead_id
+   AND l.referred_by_lead_id IS NULL
+   AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.lead_id = l.id AND o.status = 'paid')`,
+		orgID, leadID, code, source)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// referralSettings são as regras de recompensa da org.
+type referralSettings struct {
+	Active         bool   `json:"active"`
+	RewardType     string `json:"reward_type"` // fixed | percent
+	RewardValue    int    `json:"reward_value"`
+	FirstOrderOnly bool   `json:"first_order_only"`
+	MinOrderCents  int    `json:"min_order_cents"`
+	WhatsAppNumber string `json:"whatsapp_number,omitempty"` // destino do link wa.me
+	ShareMessage   string `json:"share_message,omitempty"`   // texto pronto; {code} vira o código
+}
+
+func defaultReferralSettings() referralSettings {
+	return referralSettings{RewardType: "fixed", FirstOrderOnly: true}
+}
+
+func (a *App) loadReferralSettings(ctx context.Context, orgID int64) (referralSettings, error) {
+	s := defaultReferralSettings()
+	err := a.DB.QueryRow(ctx, `
+SELECT active, reward_type, reward_value, first_order_only, min_order_cents,
+       COALESCE(whatsapp_number,''), COALESCE(share_message,'')
+  FROM referral_settings WHERE org_id=$1`, orgID).
+		Scan(&s.Active, &s.RewardType, &s.RewardValue, &s.FirstOrderOnly, &s.MinOrderCents, &s.WhatsAppNumber, &s.ShareMessage)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, nil
+	}
+	return s, err
+}
+
+// GET /api/referrals/settings
+func (a *App) getReferralSettings(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := a.loadReferralSettings(r.Context(), orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// PUT /api/referrals/settings
+func (a *App) putReferralSettings(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := defaultReferralSettings()
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	s.WhatsAppNumber = onlyDigits(s.WhatsAppNumber)
+	s.ShareMessage = limitRunes(strings.TrimSpace(s.ShareMessage), 500)
+	switch {
+	case s.RewardType != "fixed" && s.RewardType != "percent":
+		render.Error(w, http.StatusBadRequest, "reward_type must be fixed or percent")
+		return
+	case s.RewardValue < 0 || (s.RewardType == "percent" && s.RewardValue > 10000):
+		render.Error(w, http.StatusBadRequest, "reward_value out of range (percent uses basis points, max 10000)")
+		return
+	case s.MinOrderCents < 0:
+		render.Error(w, http.StatusBadRequest, "min_order_cents must not be negative")
+		return
+	}
+	_, err = a.DB.Exec(r.Context(), `
+INSERT INTO referral_settings (org_id, active, reward_type, reward_value, first_order_only, min_order_cents,
+                               whatsapp_number, share_message, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7,''), NULLIF($8,''), NOW())
+ON CONFLICT (org_id) DO UPDATE SET
+  active=EXCLUDED.active, reward_type=EXCLUDED.reward_type, reward_value=EXCLUDED.reward_value,
+  first_order_only=EXCLUDED.first_order_only, min_order_cents=EXCLUDED.min_order_cents,
+  whatsapp_number=EXCLUDED.whatsapp_number, share_message=EXCLUDED.share_message, updated_at=NOW()`,
+		orgID, s.Active, s.RewardType, s.RewardValue, s.FirstOrderOnly, s.MinOrderCents, s.WhatsAppNumber, s.ShareMessage)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// shareText é a mensagem que o indicado manda ao abrir o link.
+func (s referralSettings) shareText(code string) string {
+	msg := nonEmpty(s.ShareMessage, "Olá! Vim pela indicação {code}.")
+	if !strings.Contains(msg, "{code}") {
+		msg += " {code}"
+	}
+	return strings.ReplaceAll(msg, "{code}", referralPrefix+code)
+}
+
+// GET /api/leads/{id}/referral
+func (a *App) getLeadReferral(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	leadID, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	ctx := r.Context()
+	var exists bool
+	if err := a.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM leads WHERE id=$1 AND org_id=$2 AND flow_id=$3)`,
+		leadID, orgID, flowID).Scan(&exists); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		render.Error(w, http.StatusNotFound, "lead not found")
+		return
+	}
+	code, err := a.referralCode(ctx, orgID, leadID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	settings, err := a.loadReferralSettings(ctx, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := map[string]any{
+		"lead_id":    leadID,
+		"code":       referralPrefix + code,
+		"link":       storefrontBase(r) + fmt.Sprintf("/store/%d/r/%s", orgID, code),
+		"share_text": settings.shareText(code),
+	}
+	var clicks, referred, paidOrders, rewardsCents int64
+	err = a.DB.QueryRow(ctx, `
+SELECT rc.clicks,
+       (SELECT COUNT(*) FROM leads l WHERE l.referred_by_lead_id = rc.lead_id),
+       (SELECT COUNT(*) FROM orders o WHERE o.referrer_lead_id = rc.lead_id AND o.status = 'paid'),
+       (SELECT COALESCE(SUM(amount_cents), 0) FROM referral_rewards rw
+         WHERE rw.referrer_lead_id = rc.lead_id AND rw.status <> 'canceled')
+  FROM referral_codes rc WHERE rc.lead_id = $1`, leadID).Scan(&clicks, &referred, &paidOrders, &rewardsCents)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out["clicks"], out["referred_leads"], out["paid_orders"], out["rewards_cents"] = clicks, referred, paidOrders, rewardsCents
+	render.OK(w, out)
+}
+
+// GET /store/{org}/r/{code}
+func (a *App) referralRedirect(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := storeOrgID(r)
+	code := strings.ToUpper(strings.TrimPrefix(strings.ToUpper(chi.URLParam(r, "code")), referralPrefix))
+	if !ok || code == "" {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	ctx := r.Context()
+	tag, err := a.DB.Exec(ctx, `UPDATE referral_codes SET clicks = clicks + 1 WHERE org_id=$1 AND code=$2`, orgID, code)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	settings, err := a.loadReferralSettings(ctx, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	target := ""
+	switch base := strings.TrimRight(getenv("STOREFRONT_BASE_URL", ""), "/"); {
+	case settings.WhatsAppNumber != "":
+		target = "https://wa.me/" + settings.WhatsAppNumber + "?text=" + url.QueryEscape(settings.shareText(code))
+	case base != "":
+		target = base + "/?ref=" + url.QueryEscape(referralPrefix+code)
+	default:
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// rewardReferral atribui o pedido pago ao indicador e gera a recompensa.
+func (a *App) rewardReferral(ctx context.Context, o Order) error {
+	var referrer *int64
+	err := a.DB.QueryRow(ctx, `
+UPDATE orders o SET referrer_lead_id = l.referred_by_lead_id
+  FROM leads l
+ WHERE o.id = $1 AND l.id = o.lead_id AND l.referred_by_lead_id IS NOT NULL
+RETURNING o.referrer_lead_id`, o.ID).Scan(&referrer)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && referrer == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s, err := a.loadReferralSettings(ctx, o.OrgID)
+	if err != nil || !s.Active || o.TotalCents < s.MinOrderCents {
+		return err
+	}
+	if s.FirstOrderOnly {
+		var earlier bool
+		if err := a.DB.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM orders WHERE lead_id=$1 AND status='paid' AND id <> $2 AND created_at <= $3)`,
+			o.LeadID, o.ID, o.CreatedAt).Scan(&earlier); err != nil || earlier {
+			return err
+		}
+	}
+	amount := s.RewardValue
+	if s.RewardType == "percent" {
+		amount = o.TotalCents * s.RewardValue / 10000
+	}
+	if amount <= 0 {
+		return nil
+	}
+	_, err = a.DB.Exec(ctx, `
+INSERT INTO referral_rewards (org_id, referrer_lead_id, referred_lead_id, order_id, amount_cents)
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (order_id) DO NOTHING`,
+		o.OrgID, *referrer, o.LeadID, o.ID, amount)
+	return err
+}
+
+// GET /api/referrals/top?from=&to=&limit=
+func (a *App) topReferrers(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 10
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 100 {
+		limit = n
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT ref.id, COALESCE(ref.name,''), COALESCE(rc.code,''),
+       COUNT(DISTINCT l.id),
+       COUNT(DISTINCT o.id),
+       COALESCE(SUM(o.total_cents), 0)::bigint,
+       (SELECT COALESCE(SUM(amount_cents), 0) FROM referral_rewards rw
+         WHERE rw.referrer_lead_id = ref.id AND rw.status <> 'canceled')::bigint
+  FROM leads l
+  JOIN leads ref ON ref.id = l.referred_by_lead_id
+  LEFT JOIN referral_codes rc ON rc.lead_id = ref.id
+  LEFT JOIN orders o ON o.lead_id = l.id AND o.referrer_lead_id = ref.id AND o.status = 'paid'
+ WHERE ref.org_id=$1 AND ref.flow_id=$2
+   AND ($3::timestamptz IS NULL OR l.referred_at >= $3) AND ($4::timestamptz IS NULL OR l.referred_at < $4)
+ GROUP BY ref.id, ref.name, rc.code
+ ORDER BY 5 DESC, 4 DESC, ref.id
+ LIMIT $5`, orgID, flowID, rg.From, rg.To, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	type row struct {
+		LeadID        int64  `json:"lead_id"`
+		Name          string `json:"name"`
+		Code          string `json:"code,omitempty"`
+		ReferredLeads int64  `json:"referred_leads"`
+		PaidOrders    int64  `json:"paid_orders"`
+		RevenueCents  int64  `json:"revenue_cents"`
+		RewardsCents  int64  `json:"rewards_cents"`
+	}
+	out := []row{}
+	for rows.Next() {
+		var x row
+		if err := rows.Scan(&x.LeadID, &x.Name, &x.Code, &x.ReferredLeads, &x.PaidOrders, &x.RevenueCents, &x.RewardsCents); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		x.Name = revealPII(orgID, x.Name)
+		if x.Code != "" {
+			x.Code = referralPrefix + x.Code
+		}
+		out = append(out, x)
+	}
+	render.OK(w, map[string]any{"items": out, "range": rg.meta()})
+}
+
+type referralReward struct {
+	ID             int64      `json:"id"`
+	ReferrerLeadID int64      `json:"referrer_lead_id"`
+	ReferredLeadID int64      `json:"referred_lead_id"`
+	OrderID        int64      `json:"order_id"`
+	AmountCents    int        `json:"amount_cents"`
+	Status         string     `json:"status"` // pending | paid | canceled
+	CreatedAt      time.Time  `json:"created_at"`
+	PaidAt         *time.Time `json:"paid_at,omitempty"`
+}
+
+// GET /api/referrals/rewards?status=pending
+func (a *App) listReferralRewards(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, referrer_lead_id, referred_lead_id, order_id, amount_cents, status, created_at, paid_at
+  FROM referral_rewards
+ WHERE org_id=$1 AND ($2 = '' OR status = $2)
+ ORDER BY created_at DESC LIMIT 500`, orgID, strings.TrimSpace(r.URL.Query().Get("status")))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []referralReward{}
+	for rows.Next() {
+		var x referralReward
+		if err := rows.Scan(&x.ID, &x.ReferrerLeadID, &x.ReferredLeadID, &x.OrderID, &x.AmountCents, &x.Status, &x.CreatedAt, &x.PaidAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, x)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// POST /api/referrals/rewards/{id}/paid | /cancel
+func (a *App) setReferralRewardStatus(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orgID, _, err := tenantOf(r)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		tag, err := a.DB.Exec(r.Context(), `
+UPDATE referral_rewards SET status=$3, paid_at = CASE WHEN $3 = 'paid' THEN NOW() END
+ WHERE id=$1 AND org_id=$2 AND status='pending'`, id, orgID, status)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			render.Error(w, http.StatusNotFound, "pending reward not found")
+			return
+		}
+		render.NoContent(w)
+	}
+}
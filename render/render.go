@@ -0,0 +1,90 @@
+// Package render concentra a escrita de respostas JSON da API.
+//
+// Convenções:
+//   - sucesso: o próprio recurso (objeto) ou {"items": [...]} para listas,
+//     com o status adequado (OK, Created, Accepted, NoContent);
+//   - erro: {"error": {"code": "not_found", "message": "...", "details": ...}},
+//     onde code é derivado do status HTTP ou próprio do caso
+//     ("outside_24h_window", "validation_failed") e details é opcional
+//     (campos inválidos em details.fields). Nenhum handler escreve erro em
+//     texto puro.
+package render
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// JSON escreve v com o status informado.
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// OK responde 200 com v.
+func OK(w http.ResponseWriter, v any) { JSON(w, http.StatusOK, v) }
+
+// Created responde 201 com o recurso criado.
+func Created(w http.ResponseWriter, v any) { JSON(w, http.StatusCreated, v) }
+
+// Accepted responde 202 (trabalho enfileirado) com v.
+func Accepted(w http.ResponseWriter, v any) { JSON(w, http.StatusAccepted, v) }
+
+// NoContent responde 204 sem corpo.
+func NoContent(w http.ResponseWriter) { w.WriteHeader(http.StatusNoContent) }
+
+// ErrorBody é o envelope de erro.
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// FieldError é um campo inválido da requisição ("email", "slots[2].start").
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error responde status com o envelope de erro; substitui http.Error.
+func Error(w http.ResponseWriter, status int, msg string) {
+	APIError(w, status, "", msg, nil)
+}
+
+// APIError responde status com o envelope de erro, um code próprio (vazio =
+// derivado do status) e details opcionais.
+func APIError(w http.ResponseWriter, status int, code, msg string, details any) {
+	if code == "" {
+		code = StatusCode(status)
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	JSON(w, status, ErrorBody{Error: ErrorDetail{Code: code, Message: msg, Details: details}})
+}
+
+// Validation responde 400 "validation_failed" com todos os campos inválidos
+// em details.fields; message junta as mensagens para clientes antigos.
+func Validation(w http.ResponseWriter, fields []FieldError) {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	APIError(w, http.StatusBadRequest, "validation_failed", strings.Join(msgs, "; "), map[string]any{"fields": fields})
+}
+
+// StatusCode devolve o código do envelope para um status HTTP
+// (404 -> "not_found", 429 -> "too_many_requests").
+func StatusCode(status int) string {
+	t := http.StatusText(status)
+	if t == "" {
+		return "error"
+	}
+	t = strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(t))
+	return t
+}
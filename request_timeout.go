@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ================================================================
+//  Timeout das requisições (global) e dos streams SSE
+// ================================================================
+//
+// Toda requisição tem 60s (middleware.Timeout, que responde 504). Os streams
+// SSE abaixo ficam abertos até o fim do que acompanham e, com o timeout
+// global, caíam no meio aos 60s; eles recebem um prazo próprio,
+// SSE_MAX_DURATION (padrão 10m), sem o 504 no fim (a resposta já começou):
+//
+//   GET /api/vision/jobs/{id}/events           (vision_jobs.go)
+//   GET /api/wa/instances/{instance}/qr/stream (wa_qr_refresh.go)
+//
+// O WebSocket do chat não herda o contexto da requisição
+// (handlers_chat_ws.go).
+
+var sseStreamPaths = []string{
+	"/api/vision/jobs/*/events",
+	"/api/wa/instances/*/qr/stream",
+}
+
+func isSSEStream(p string) bool {
+	for _, pattern := range sseStreamPaths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sseMaxDuration() time.Duration {
+	d, err := time.ParseDuration(getenv("SSE_MAX_DURATION", "10m"))
+	if err != nil || d <= 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// requestTimeout aplica d às requisições comuns e SSE_MAX_DURATION aos
+// streams.
+func requestTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timed := middleware.Timeout(d)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !isSSEStream(r.URL.Path) {
+				timed.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), sseMaxDuration())
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
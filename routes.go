@@ -1,5 +1,6 @@
 package main
 
 // Este arquivo agrega documentação e serve como ponto de organização de rotas.
-// As rotas da integração WhatsApp estão em wa_instances_api.go.
+// As rotas da integração WhatsApp estão em handlers_whatsapp.go e o cliente do
+// provedor (uazapi) no pacote waprovider.
 // Demais módulos (auth, catálogo, leads, etc.) permanecem onde já foram implementados.
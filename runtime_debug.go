@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Ajuste do runtime e diagnóstico de memória (operador)
+// ================================================================
+//
+// Ajustes lidos na subida (applyRuntimeTuning, main.go). As variáveis
+// nativas do Go (GOMAXPROCS, GOGC, GOMEMLIMIT) continuam valendo e têm
+// precedência; estas existem porque o Go 1.22 não enxerga o limite do
+// container:
+//
+//   RUNTIME_MAXPROCS      n ou "auto" (cota de CPU do cgroup, arredondada
+//                         para cima); vazio = número de CPUs do host
+//   RUNTIME_GC_PERCENT    como GOGC (padrão 100; -1 desliga o GC por
+//                         proporção, útil só junto com limite de memória)
+//   RUNTIME_MEMORY_LIMIT  limite brando do heap: bytes ("900MiB", "1GB") ou
+//                         porcentagem do limite de memória do cgroup ("80%")
+//
+// Rotas no grupo /api/admin (ADMIN_TOKEN + ADMIN_IP_ALLOWLIST, admin.go):
+//
+// GET  /api/admin/runtime      memória, GC, goroutines e o tamanho dos mapas
+//                              em memória (métricas, rate limit, caches)
+// POST /api/admin/runtime/gc   força um GC e devolve a memória ao SO; o que
+//                              sobra em heap_alloc_bytes é memória viva
+// GET  /api/admin/debug/pprof/ pprof (heap, allocs, goroutine, profile?seconds=,
+//                              trace...) e /api/admin/debug/vars (expvar), só
+//                              com PPROF_ENABLED=true; senão 404
+//
+// Perfis de CPU e trace ficam abaixo do timeout de 60s das rotas (main.go):
+// use seconds de até 30.
+
+// runtimeTuning guarda o que foi aplicado na subida, para o /admin/runtime.
+var runtimeTuning struct {
+	sync.Mutex
+	gcPercent int
+	sources   map[string]string // ajuste -> origem (env nativa, RUNTIME_*, cgroup)
+}
+
+// applyRuntimeTuning aplica RUNTIME_* que não tenham a variável nativa
+// correspondente definida.
+func applyRuntimeTuning() {
+	runtimeTuning.Lock()
+	defer runtimeTuning.Unlock()
+	runtimeTuning.gcPercent = 100
+	runtimeTuning.sources = map[string]string{}
+
+	if v := os.Getenv("GOMAXPROCS"); v != "" {
+		runtimeTuning.sources["maxprocs"] = "GOMAXPROCS"
+	} else if v := strings.TrimSpace(getenv("RUNTIME_MAXPROCS", "")); v != "" {
+		n, src, err := runtimeMaxProcs(v)
+		if err != nil {
+			log.Printf("runtime: RUNTIME_MAXPROCS=%q: %v (ignored)", v, err)
+		} else {
+			runtime.GOMAXPROCS(n)
+			runtimeTuning.sources["maxprocs"] = src
+		}
+	}
+
+	if v := os.Getenv("GOGC"); v != "" {
+		runtimeTuning.sources["gc_percent"] = "GOGC"
+		if v == "off" {
+			runtimeTuning.gcPercent = -1
+		} else if n, err := strconv.Atoi(v); err == nil {
+			runtimeTuning.gcPercent = n
+		}
+	} else if v := strings.TrimSpace(getenv("RUNTIME_GC_PERCENT", "")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < -1 {
+			log.Printf("runtime: RUNTIME_GC_PERCENT=%q: invalid (ignored)", v)
+		} else {
+			debug.SetGCPercent(n)
+			runtimeTuning.gcPercent = n
+			runtimeTuning.sources["gc_percent"] = "RUNTIME_GC_PERCENT"
+		}
+	}
+
+	if os.Getenv("GOMEMLIMIT") != "" {
+		runtimeTuning.sources["memory_limit"] = "GOMEMLIMIT"
+	} else if v := strings.TrimSpace(getenv("RUNTIME_MEMORY_LIMIT", "")); v != "" {
+		n, src, err := runtimeMemoryLimit(v)
+		if err != nil {
+			log.Printf("runtime: RUNTIME_MEMORY_LIMIT=%q: %v (ignored)", v, err)
+		} else {
+			debug.SetMemoryLimit(n)
+			runtimeTuning.sources["memory_limit"] = src
+		}
+	}
+
+	if len(runtimeTuning.sources) > 0 {
+		log.Printf("runtime: GOMAXPROCS=%d gc_percent=%d memory_limit=%d (%v)",
+			runtime.GOMAXPROCS(0), runtimeTuning.gcPercent, debug.SetMemoryLimit(-1), runtimeTuning.sources)
+	}
+}
+
+func runtimeMaxProcs(v string) (int, string, error) {
+	if v != "auto" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, "", fmt.Errorf("want a positive integer or auto")
+		}
+		return n, "RUNTIME_MAXPROCS", nil
+	}
+	quota, ok := cgroupCPUQuota()
+	if !ok {
+		return runtime.NumCPU(), "RUNTIME_MAXPROCS=auto (no cgroup quota)", nil
+	}
+	n := int(quota)
+	if float64(n) < quota {
+		n++
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n, "RUNTIME_MAXPROCS=auto (cgroup)", nil
+}
+
+func runtimeMemoryLimit(v string) (int64, string, error) {
+	if pct, ok := strings.CutSuffix(v, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, "", fmt.Errorf("percentage must be in (0, 100]")
+		}
+		limit, ok := cgroupMemoryLimit()
+		if !ok {
+			return 0, "", fmt.Errorf("no cgroup memory limit to take %s of", v)
+		}
+		return int64(float64(limit) * p / 100), "RUNTIME_MEMORY_LIMIT (cgroup)", nil
+	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, "RUNTIME_MEMORY_LIMIT", nil
+}
+
+// parseByteSize aceita bytes com sufixo opcional: KB/MB/GB (1000) ou
+// KiB/MiB/GiB (1024).
+func parseByteSize(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"B", 1},
+	}
+	s := strings.TrimSpace(v)
+	mult := int64(1)
+	for _, u := range units {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(rest), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size (e.g. 900MiB, 1GB)")
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// cgroupCPUQuota lê a cota de CPU (em CPUs) do cgroup v2 ou v1.
+func cgroupCPUQuota() (float64, bool) {
+	if b, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		f := strings.Fields(string(b))
+		if len(f) == 2 && f[0] != "max" {
+			q, err1 := strconv.ParseFloat(f[0], 64)
+			p, err2 := strconv.ParseFloat(f[1], 64)
+			if err1 == nil && err2 == nil && q > 0 && p > 0 {
+				return q / p, true
+			}
+		}
+		return 0, false
+	}
+	q, err1 := readCgroupInt("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	p, err2 := readCgroupInt("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err1 != nil || err2 != nil || q <= 0 || p <= 0 {
+		return 0, false
+	}
+	return float64(q) / float64(p), true
+}
+
+// cgroupMemoryLimit lê o limite de memória (bytes) do cgroup v2 ou v1.
+func cgroupMemoryLimit() (int64, bool) {
+	if n, err := readCgroupInt("/sys/fs/cgroup/memory.max"); err == nil {
+		return n, n > 0
+	}
+	n, err := readCgroupInt("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	// v1 sem limite devolve um valor perto de MaxInt64
+	if err != nil || n <= 0 || n >= 1<<62 {
+		return 0, false
+	}
+	return n, true
+}
+
+func readCgroupInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// ---------------- rotas ----------------
+
+func pprofEnabled() bool {
+	v, _ := strconv.ParseBool(getenv("PPROF_ENABLED", "false"))
+	return v
+}
+
+// mountRuntimeDebug é chamado dentro do grupo /admin (admin.go).
+func (a *App) mountRuntimeDebug(r chi.Router) {
+	r.Get("/runtime", a.adminRuntime)
+	r.Post("/runtime/gc", a.adminRuntimeGC)
+	r.Mount("/debug", requirePprof(middleware.Profiler()))
+}
+
+func requirePprof(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !pprofEnabled() {
+			render.Error(w, http.StatusNotFound, "not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type runtimeStats struct {
+	GoVersion      string            `json:"go_version"`
+	Uptime         string            `json:"uptime"`
+	NumCPU         int               `json:"num_cpu"`
+	GOMAXPROCS     int               `json:"gomaxprocs"`
+	GCPercent      int               `json:"gc_percent"`
+	MemoryLimit    int64             `json:"memory_limit_bytes"` // math.MaxInt64 = sem limite
+	CgroupMemory   int64             `json:"cgroup_memory_limit_bytes,omitempty"`
+	Tuning         map[string]string `json:"tuning"`
+	Goroutines     int               `json:"goroutines"`
+	HeapAlloc      uint64            `json:"heap_alloc_bytes"`
+	HeapInuse      uint64            `json:"heap_inuse_bytes"`
+	HeapIdle       uint64            `json:"heap_idle_bytes"`
+	HeapReleased   uint64            `json:"heap_released_bytes"`
+	HeapObjects    uint64            `json:"heap_objects"`
+	StackInuse     uint64            `json:"stack_inuse_bytes"`
+	Sys            uint64            `json:"sys_bytes"`
+	TotalAlloc     uint64            `json:"total_alloc_bytes"`
+	NextGC         uint64            `json:"next_gc_bytes"`
+	NumGC          uint32            `json:"num_gc"`
+	NumForcedGC    uint32            `json:"num_forced_gc"`
+	LastGC         *time.Time        `json:"last_gc,omitempty"`
+	GCPauseTotal   string            `json:"gc_pause_total"`
+	GCCPUFraction  float64           `json:"gc_cpu_fraction"`
+	InMemory       map[string]int    `json:"in_memory"`
+	DBPoolAcquired int32             `json:"db_pool_acquired_conns"`
+	DBPoolTotal    int32             `json:"db_pool_total_conns"`
+}
+
+var processStart = time.Now()
+
+func (a *App) runtimeStats() runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	runtimeTuning.Lock()
+	tuning := make(map[string]string, len(runtimeTuning.sources))
+	for k, v := range runtimeTuning.sources {
+		tuning[k] = v
+	}
+	gcPercent := runtimeTuning.gcPercent
+	if runtimeTuning.sources == nil {
+		gcPercent = 100 // applyRuntimeTuning não rodou
+	}
+	runtimeTuning.Unlock()
+
+	out := runtimeStats{
+		GoVersion:     runtime.Version(),
+		Uptime:        time.Since(processStart).Round(time.Second).String(),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		GCPercent:     gcPercent,
+		MemoryLimit:   debug.SetMemoryLimit(-1),
+		Tuning:        tuning,
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		HeapIdle:      m.HeapIdle,
+		HeapReleased:  m.HeapReleased,
+		HeapObjects:   m.HeapObjects,
+		StackInuse:    m.StackInuse,
+		Sys:           m.Sys,
+		TotalAlloc:    m.TotalAlloc,
+		NextGC:        m.NextGC,
+		NumGC:         m.NumGC,
+		NumForcedGC:   m.NumForcedGC,
+		GCPauseTotal:  time.Duration(m.PauseTotalNs).String(),
+		GCCPUFraction: m.GCCPUFraction,
+		InMemory:      inMemorySizes(),
+	}
+	if limit, ok := cgroupMemoryLimit(); ok {
+		out.CgroupMemory = limit
+	}
+	if m.LastGC > 0 {
+		t := time.Unix(0, int64(m.LastGC))
+		out.LastGC = &t
+	}
+	if a.DB != nil {
+		st := a.DB.Stat()
+		out.DBPoolAcquired = st.AcquiredConns()
+		out.DBPoolTotal = st.TotalConns()
+	}
+	return out
+}
+
+// inMemorySizes conta as entradas dos mapas que vivem no processo e crescem
+// com o tráfego.
+func inMemorySizes() map[string]int {
+	out := map[string]int{}
+	for _, m := range []*metricVec{httpRequests, httpDuration, llmDuration, llmErrors, uazapiRequests, uazapiErrors, rateLimited, concurrencyShed, dbSlowQueries} {
+		out["metric_series"] += m.size()
+	}
+
+	rateMemory.mu.Lock()
+	out["rate_limit_buckets"] = len(rateMemory.buckets)
+	rateMemory.mu.Unlock()
+
+	cepCache.Range(func(_, _ any) bool {
+		out["cep_cache"]++
+		return true
+	})
+
+	aiHealth.Lock()
+	out["ai_fallback_orgs"] = len(aiHealth.failures) + len(aiHealth.degraded)
+	aiHealth.Unlock()
+
+	providerAlertMu.Lock()
+	out["provider_alerts"] = len(providerAlertSent)
+	providerAlertMu.Unlock()
+
+	out["db_query_fingerprints"] = dbQueryStatsSize()
+
+	concurrencyMu.Lock()
+	for _, sem := range concurrencySems {
+		out["concurrency_in_flight"] += len(sem.slots)
+	}
+	concurrencyMu.Unlock()
+	return out
+}
+
+// GET /api/admin/runtime
+func (a *App) adminRuntime(w http.ResponseWriter, r *http.Request) {
+	render.OK(w, a.runtimeStats())
+}
+
+// POST /api/admin/runtime/gc
+func (a *App) adminRuntimeGC(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	debug.FreeOSMemory() // roda um GC completo antes de devolver a memória
+	log.Printf("runtime: forced GC via admin in %s", time.Since(start))
+	render.OK(w, a.runtimeStats())
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Auditoria de mudanças de schema por deploy
+// ================================================================
+//
+// Cada processo que sobe (servidor ou "api migrate") abre uma linha em
+// schema_deployments com APP_VERSION (ou GIT_SHA), host e horário. Tudo o que
+// ele faz no schema fica em schema_changes, ligado ao deploy:
+//
+//   migration  cada migrations/NNNN aplicada (ou que falhou, ou cujo
+//              checksum mudou depois de aplicada)
+//
+// Todo DDL passa por migrations/; linhas antigas com kind "ensure" vêm da
+// época em que os mount* criavam tabelas na subida.
+//
+// Para o suporte cruzar bug de tenant com o rollout:
+//
+// GET /api/admin/schema/deployments?limit=
+// GET /api/admin/schema/changes?since=&until=&kind=&deployment_id=&format=json|ndjson|csv
+//
+// ndjson/csv saem em streaming, em ordem de id, prontos para carga no
+// warehouse (use since= com o created_at da última carga).
+
+const schemaChangeMigration = "migration"
+
+// deploy deste processo; 0 enquanto não registrado (falha não derruba nada)
+var currentDeployment atomic.Int64
+
+func ensureSchemaAuditTables(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS public.schema_deployments (
+  id             BIGSERIAL PRIMARY KEY,
+  version        TEXT NOT NULL,
+  hostname       TEXT,
+  role           TEXT NOT NULL,              -- server | migrate
+  schema_version INTEGER,                    -- última migração aplicada ao final
+  started_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS public.schema_changes (
+  id            BIGSERIAL PRIMARY KEY,
+  deployment_id BIGINT REFERENCES public.schema_deployments(id) ON DELETE SET NULL,
+  kind          TEXT NOT NULL,               -- migration (ensure: linhas antigas)
+  name          TEXT NOT NULL,
+  version       INTEGER,
+  checksum      TEXT,
+  status        TEXT NOT NULL,               -- applied | failed | modified
+  error         TEXT,
+  duration_ms   INTEGER NOT NULL DEFAULT 0,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_schema_changes_created ON public.schema_changes (created_at);
+CREATE INDEX IF NOT EXISTS idx_schema_changes_deployment ON public.schema_changes (deployment_id);`)
+	return err
+}
+
+func appVersion() string {
+	return firstNonEmpty(os.Getenv("APP_VERSION"), os.Getenv("GIT_SHA"), "dev")
+}
+
+// startDeployment registra o deploy do processo (role: server | migrate).
+func startDeployment(ctx context.Context, db *pgxpool.Pool, role string) {
+	if err := ensureSchemaAuditTables(ctx, db); err != nil {
+		log.Printf("schema audit: %v", err)
+		return
+	}
+	host, _ := os.Hostname()
+	var id int64
+	if err := db.QueryRow(ctx, `
+INSERT INTO public.schema_deployments (version, hostname, role) VALUES ($1, NULLIF($2,''), $3) RETURNING id`,
+		appVersion(), host, role).Scan(&id); err != nil {
+		log.Printf("schema audit: %v", err)
+		return
+	}
+	currentDeployment.Store(id)
+}
+
+type schemaChange struct {
+	ID           int64     `json:"id"`
+	DeploymentID *int64    `json:"deployment_id"`
+	Kind         string    `json:"kind"`
+	Name         string    `json:"name"`
+	Version      *int      `json:"version"`
+	Checksum     string    `json:"checksum"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error"`
+	DurationMS   int64     `json:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// recordSchemaChange grava o evento no deploy atual; falha só vai para o log.
+func recordSchemaChange(ctx context.Context, db *pgxpool.Pool, c schemaChange) {
+	var dep *int64
+	if id := currentDeployment.Load(); id > 0 {
+		dep = &id
+	}
+	if _, err := db.Exec(ctx, `
+INSERT INTO public.schema_changes (deployment_id, kind, name, version, checksum, status, error, duration_ms)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), $6, NULLIF($7,''), $8)`,
+		dep, c.Kind, c.Name, c.Version, c.Checksum, c.Status, c.Error, c.DurationMS); err != nil {
+		log.Printf("schema audit %s %s: %v", c.Kind, c.Name, err)
+	}
+}
+
+// finishDeploymentSchema anota a versão de schema com que o deploy ficou.
+func finishDeploymentSchema(ctx context.Context, db *pgxpool.Pool, version int) {
+	id := currentDeployment.Load()
+	if id <= 0 {
+		return
+	}
+	if _, err := db.Exec(ctx, `UPDATE public.schema_deployments SET schema_version=$2 WHERE id=$1`, id, version); err != nil {
+		log.Printf("schema audit: %v", err)
+	}
+}
+
+// GET /api/admin/schema/deployments?limit=
+func (a *App) adminSchemaDeployments(w http.ResponseWriter, r *http.Request) {
+	limit := mustAtoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT d.id, d.version, COALESCE(d.hostname,''), d.role, d.schema_version, d.started_at,
+       COUNT(c.id) FILTER (WHERE c.kind='migration' AND c.status='applied'),
+       COUNT(c.id) FILTER (WHERE c.status='failed')
+  FROM public.schema_deployments d
+  LEFT JOIN public.schema_changes c ON c.deployment_id = d.id
+ GROUP BY d.id ORDER BY d.id DESC LIMIT $1`, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	type deployment struct {
+		ID                int64     `json:"id"`
+		Version           string    `json:"version"`
+		Hostname          string    `json:"hostname"`
+		Role              string    `json:"role"`
+		SchemaVersion     *int      `json:"schema_version"`
+		StartedAt         time.Time `json:"started_at"`
+		MigrationsApplied int64     `json:"migrations_applied"`
+		Failures          int64     `json:"failures"`
+	}
+	out := []deployment{}
+	for rows.Next() {
+		var d deployment
+		if err := rows.Scan(&d.ID, &d.Version, &d.Hostname, &d.Role, &d.SchemaVersion, &d.StartedAt,
+			&d.MigrationsApplied, &d.Failures); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, d)
+	}
+	render.OK(w, map[string]any{"items": out, "current": currentDeployment.Load(), "version": appVersion()})
+}
+
+// GET /api/admin/schema/changes?since=&until=&kind=&deployment_id=&format=
+func (a *App) adminSchemaChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since, until time.Time
+	for _, p := range []struct {
+		key string
+		dst *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if v := strings.TrimSpace(q.Get(p.key)); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				render.Error(w, http.StatusBadRequest, "invalid "+p.key+" (RFC3339)")
+				return
+			}
+			*p.dst = t
+		}
+	}
+	kind := strings.TrimSpace(q.Get("kind"))
+	switch kind {
+	case "", schemaChangeMigration:
+	default:
+		render.Error(w, http.StatusBadRequest, "invalid kind (use migration)")
+		return
+	}
+	format := nonEmpty(strings.TrimSpace(q.Get("format")), "json")
+	if format != "json" && format != "ndjson" && format != "csv" {
+		render.Error(w, http.StatusBadRequest, "invalid format (use json, ndjson or csv)")
+		return
+	}
+	limit := mustAtoi(q.Get("limit"))
+	if limit <= 0 || limit > 100000 {
+		limit = 10000
+	}
+
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, deployment_id, kind, name, version, COALESCE(checksum,''), status, COALESCE(error,''), duration_ms, created_at
+  FROM public.schema_changes
+ WHERE ($1::timestamptz IS NULL OR created_at >= $1) AND ($2::timestamptz IS NULL OR created_at < $2)
+   AND ($3 = '' OR kind = $3) AND ($4 = 0 OR deployment_id = $4)
+ ORDER BY id LIMIT $5`, nullTime(since), nullTime(until), kind, int64(mustAtoi(q.Get("deployment_id"))), limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	var (
+		enc *json.Encoder
+		cw  *csv.Writer
+		out = []schemaChange{}
+	)
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc = json.NewEncoder(w)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="schema_changes.csv"`)
+		cw = csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "deployment_id", "kind", "name", "version", "checksum", "status", "error", "duration_ms", "created_at"})
+	}
+	for rows.Next() {
+		var c schemaChange
+		if err := rows.Scan(&c.ID, &c.DeploymentID, &c.Kind, &c.Name, &c.Version, &c.Checksum, &c.Status, &c.Error,
+			&c.DurationMS, &c.CreatedAt); err != nil {
+			if format == "json" {
+				render.Error(w, http.StatusInternalServerError, err.Error())
+			} else {
+				log.Printf("schema changes export: %v", err)
+			}
+			return
+		}
+		switch format {
+		case "ndjson":
+			_ = enc.Encode(c)
+		case "csv":
+			_ = cw.Write([]string{
+				strconv.FormatInt(c.ID, 10), optInt64(c.DeploymentID), c.Kind, c.Name, optInt(c.Version),
+				c.Checksum, c.Status, c.Error, strconv.FormatInt(c.DurationMS, 10), c.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		default:
+			out = append(out, c)
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+	}
+	if format == "json" {
+		render.OK(w, map[string]any{"items": out})
+	}
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func optInt64(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+func optInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Busca global (GET /api/search?q=&types=products,leads,orders,conversations)
+// ================================================================
+//
+// Federa a busca nas entidades do tenant do token e devolve resultados com
+// "type" para a paleta de comandos do dashboard. Cada tipo tem limite
+// próprio (?limit=, padrão 5). Leads com PII cifrada só são encontrados por
+// telefone/e-mail exatos (colunas de hash).
+
+type searchResult struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Subtitle  string    `json:"subtitle,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var searchTypes = []string{"products", "leads", "orders", "conversations"}
+
+func (a *App) mountSearch(r chi.Router) {
+	r.Get("/search", a.globalSearch)
+}
+
+func (a *App) globalSearch(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, _ := tenantOf(r)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(q)) < 2 {
+		render.OK(w, map[string]any{"query": q, "results": []searchResult{}})
+		return
+	}
+	limit := 5
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 25 {
+		limit = n
+	}
+	want := map[string]bool{}
+	if t := strings.TrimSpace(r.URL.Query().Get("types")); t != "" {
+		for _, s := range strings.Split(t, ",") {
+			want[strings.TrimSpace(s)] = true
+		}
+	}
+
+	ctx := r.Context()
+	results := []searchResult{}
+	for _, typ := range searchTypes {
+		if len(want) > 0 && !want[typ] {
+			continue
+		}
+		var (
+			found []searchResult
+			err   error
+		)
+		switch typ {
+		case "products":
+			found, err = a.searchProducts(ctx, orgID, flowID, q, limit)
+		case "leads":
+			found, err = a.searchLeads(ctx, orgID, flowID, q, limit)
+		case "orders":
+			found, err = a.searchOrders(ctx, orgID, flowID, q, limit)
+		case "conversations":
+			found, err = a.searchConversations(ctx, orgID, flowID, q, limit)
+		}
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, typ+": "+err.Error())
+			return
+		}
+		results = append(results, found...)
+	}
+	render.OK(w, map[string]any{"query": q, "results": results})
+}
+
+// collectSearch lê linhas (id, title, subtitle, created_at) de uma consulta.
+func (a *App) collectSearch(ctx context.Context, typ, sql string, args ...any) ([]searchResult, error) {
+	rows, err := a.DB.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []searchResult
+	for rows.Next() {
+		res := searchResult{Type: typ}
+		if err := rows.Scan(&res.ID, &res.Title, &res.Subtitle, &res.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, res)
+	}
+	return out, rows.Err()
+}
+
+func (a *App) searchProducts(ctx context.Context, orgID, flowID int64, q string, limit int) ([]searchResult, error) {
+	return a.collectSearch(ctx, "products", `
+SELECT id::text, title, COALESCE(category,''), created_at
+  FROM products
+ WHERE org_id=$1 AND flow_id=$2 AND `+productListedSQL+`
+   AND (title ILIKE '%' || $3 || '%' OR slug ILIKE '%' || $3 || '%' OR description ILIKE '%' || $3 || '%')
+ ORDER BY (lower(title) LIKE lower($3) || '%') DESC, created_at DESC
+ LIMIT $4`, orgID, flowID, escapeLike(q), limit)
+}
+
+func (a *App) searchLeads(ctx context.Context, orgID, flowID int64, q string, limit int) ([]searchResult, error) {
+	phone := ""
+	if d := onlyDigits(q); len(d) >= 8 {
+		phone = phoneHash(orgID, q)
+	}
+	email := ""
+	if strings.Contains(q, "@") {
+		email = piiHash(orgID, q)
+	}
+	// com PII cifrada, ILIKE no nome não encontra nada útil
+	nameLike := ""
+	if !piiEnabled() {
+		nameLike = escapeLike(q)
+	}
+	out, err := a.collectSearch(ctx, "leads", `
+SELECT id::text, COALESCE(name,''), COALESCE(stage,''), created_at
+  FROM leads
+ WHERE org_id=$1 AND flow_id=$2
+   AND (($3 <> '' AND phone_hash=$3) OR ($4 <> '' AND email_hash=$4)
+        OR ($5 <> '' AND name ILIKE '%' || $5 || '%'))
+ ORDER BY created_at DESC
+ LIMIT $6`, orgID, flowID, phone, email, nameLike, limit)
+	for i := range out {
+		out[i].Title = revealPII(orgID, out[i].Title)
+	}
+	return out, err
+}
+
+func (a *App) searchOrders(ctx context.Context, orgID, flowID int64, q string, limit int) ([]searchResult, error) {
+	id, _ := strconv.ParseInt(strings.TrimPrefix(q, "#"), 10, 64)
+	out, err := a.collectSearch(ctx, "orders", `
+SELECT id::text, 'Pedido #' || id, COALESCE(status,'') || ' · ' || total_cents::text, created_at
+  FROM orders
+ WHERE org_id=$1 AND flow_id=$2
+   AND (id=$3 OR lead_id=$3 OR status ILIKE $4)
+ ORDER BY created_at DESC
+ LIMIT $5`, orgID, flowID, id, escapeLike(q), limit)
+	for i := range out {
+		// subtitle chega como "status · centavos"; formata o valor em reais
+		if st, cents, ok := strings.Cut(out[i].Subtitle, " · "); ok {
+			if c, err := strconv.Atoi(cents); err == nil {
+				out[i].Subtitle = fmt.Sprintf("%s · R$ %.2f", st, float64(c)/100)
+			}
+		}
+	}
+	return out, err
+}
+
+func (a *App) searchConversations(ctx context.Context, orgID, flowID int64, q string, limit int) ([]searchResult, error) {
+	return a.collectSearch(ctx, "conversations", `
+SELECT session_id, session_id, left(content, 140), created_at FROM (
+  SELECT DISTINCT ON (session_id) session_id, content, created_at
+    FROM public.chat_messages
+   WHERE org_id=$1 AND flow_id=$2 AND content ILIKE '%' || $3 || '%'
+   ORDER BY session_id, created_at DESC
+) t
+ORDER BY created_at DESC
+LIMIT $4`, orgID, flowID, escapeLike(q), limit)
+}
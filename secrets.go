@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+// ================================================================
+//  Validação de segredos na inicialização
+// ================================================================
+//
+// Em produção (APP_ENV=production) o servidor não sobe com JWT_SECRET
+// ausente, com valor conhecido ("secret", "changeme"...) ou fraco (menos de
+// 32 caracteres ou ~128 bits de entropia estimada). Os demais segredos
+// (ADMIN_TOKEN, INTERNAL_API_TOKEN, N8N_WEBHOOK_SECRET) são opcionais, mas
+// se definidos passam pela mesma checagem. Fora de produção os problemas só
+// geram aviso.
+//
+// Rotação do JWT: mova o valor atual para JWT_SECRET_PREVIOUS e defina o
+// novo em JWT_SECRET. Tokens novos são assinados só com o atual; os antigos
+// continuam válidos até expirar (24h) ou até JWT_SECRET_PREVIOUS_UNTIL
+// (RFC 3339), o que vier antes.
+
+const (
+	minSecretLen  = 32
+	minSecretBits = 128.0
+)
+
+// valores de exemplo/documentação que nunca devem ir para produção
+var knownWeakSecrets = map[string]bool{
+	"secret": true, "changeme": true, "change-me": true, "password": true,
+	"jwt_secret": true, "jwt-secret": true, "supersecret": true, "default": true,
+	"test": true, "dev": true, "admin": true, "123456": true,
+}
+
+// secretEntropyBits estima a entropia de s (Shannon por caractere × tamanho).
+// É um limite superior grosseiro: pega repetições e alfabetos pequenos, não
+// frases previsíveis.
+func secretEntropyBits(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	freq := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		freq[r]++
+		n++
+	}
+	var h float64
+	for _, c := range freq {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h * float64(n)
+}
+
+// checkSecret devolve o motivo pelo qual o segredo é fraco ("" = ok).
+func checkSecret(v string) string {
+	switch {
+	case knownWeakSecrets[strings.ToLower(strings.TrimSpace(v))]:
+		return "uses a well-known default value"
+	case len(v) < minSecretLen:
+		return fmt.Sprintf("must have at least %d characters", minSecretLen)
+	case secretEntropyBits(v) < minSecretBits:
+		return fmt.Sprintf("has too little entropy (~%.0f bits, need %.0f); generate one with `openssl rand -base64 48`",
+			secretEntropyBits(v), minSecretBits)
+	}
+	return ""
+}
+
+// segredos opcionais gerados por nós: só validados quando definidos (os
+// emitidos por terceiros, como META_APP_SECRET, têm formato próprio)
+var optionalSecrets = []string{"JWT_SECRET_PREVIOUS", "ADMIN_TOKEN", "INTERNAL_API_TOKEN", "N8N_WEBHOOK_SECRET"}
+
+// validateSecrets checa os segredos do ambiente. Em produção devolve um erro
+// com todos os problemas; fora dela registra avisos e devolve nil.
+func validateSecrets() error {
+	var problems []string
+	if v := os.Getenv("JWT_SECRET"); v == "" {
+		problems = append(problems, "JWT_SECRET is not set")
+	} else if why := checkSecret(v); why != "" {
+		problems = append(problems, "JWT_SECRET "+why)
+	}
+	for _, name := range optionalSecrets {
+		if v := os.Getenv(name); v != "" {
+			if why := checkSecret(v); why != "" {
+				problems = append(problems, name+" "+why)
+			}
+		}
+	}
+	if prev := os.Getenv("JWT_SECRET_PREVIOUS"); prev != "" && prev == os.Getenv("JWT_SECRET") {
+		problems = append(problems, "JWT_SECRET_PREVIOUS must differ from JWT_SECRET")
+	}
+	if v := os.Getenv("JWT_SECRET_PREVIOUS_UNTIL"); v != "" {
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			problems = append(problems, "JWT_SECRET_PREVIOUS_UNTIL must be RFC 3339 (e.g. 2025-01-31T00:00:00Z)")
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if isProduction() {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	for _, p := range problems {
+		log.Printf("secrets: warning: %s (refused when APP_ENV=production)", p)
+	}
+	return nil
+}
+
+// previousTokenAuth devolve o verificador do segredo anterior enquanto a
+// janela de rotação estiver aberta (nil fora dela).
+func previousTokenAuth(now time.Time) *jwtauth.JWTAuth {
+	if tokenAuthPrev == nil {
+		return nil
+	}
+	if v := os.Getenv("JWT_SECRET_PREVIOUS_UNTIL"); v != "" {
+		if until, err := time.Parse(time.RFC3339, v); err == nil && now.After(until) {
+			return nil
+		}
+	}
+	return tokenAuthPrev
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// appEnv devolve o ambiente de execução (APP_ENV), em minúsculas.
+// Valores usuais: development (padrão), staging, production.
+func appEnv() string {
+	return strings.ToLower(getenv("APP_ENV", "development"))
+}
+
+func isProduction() bool { return appEnv() == "production" }
+
+// securityHeaders adiciona os headers de proteção em todas as respostas.
+// HSTS só é enviado quando a requisição chegou por HTTPS (direto ou via
+// proxy com X-Forwarded-Proto), para não travar ambientes locais em http.
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadsCSP aplica uma CSP restritiva ao file server de /uploads: arquivos
+// enviados por usuários nunca devem executar script nem ser embutidos como
+// documento ativo (ex.: SVG/HTML maliciosos).
+func uploadsCSP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox")
+		h.Set("Cross-Origin-Resource-Policy", "cross-origin")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowCredentials lê CORS_ALLOW_CREDENTIALS (padrão false).
+func corsAllowCredentials() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("CORS_ALLOW_CREDENTIALS")))
+	return v == "1" || v == "true" || v == "yes"
+}
+
+// validateCORSConfig checa ALLOWED_ORIGINS na inicialização:
+//   - cada origem deve ser scheme://host[:porta], sem caminho;
+//   - "*" não pode ser combinado com credenciais;
+//   - em produção o curinga não é aceito (lista explícita obrigatória).
+func validateCORSConfig(origins []string, allowCredentials bool) error {
+	for _, o := range origins {
+		if o == "*" {
+			if allowCredentials {
+				return fmt.Errorf("ALLOWED_ORIGINS=* cannot be combined with CORS_ALLOW_CREDENTIALS")
+			}
+			if isProduction() {
+				return fmt.Errorf("ALLOWED_ORIGINS must list explicit origins in production")
+			}
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid origin in ALLOWED_ORIGINS: %q", o)
+		}
+		if isProduction() && u.Scheme != "https" {
+			return fmt.Errorf("origin %q must use https in production", o)
+		}
+	}
+	return nil
+}
+
+// secureToken gera um token aleatório (crypto/rand) com n bytes, em hex.
+// Use para segredos expostos em URL ou enviados ao usuário.
+func secureToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
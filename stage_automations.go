@@ -0,0 +1,750 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Automações por etapa do funil
+// ================================================================
+//
+// Quando um lead entra numa etapa (lead.created ou lead.stage_changed, via
+// publishEvent), as automações ativas do flow para essa etapa rodam em ordem
+// de criação (migrations/0017). Ações e config:
+//
+//   send_template   {"template","language","components"} ou {"text"} (uazapi)
+//   create_task     {"title","notes","due_in":"2d","assign_to":7}
+//   notify_user     {"user_id":7} ou {"role":"admin"}, {"subject","message"}
+//   start_sequence  {"steps":[{"after":"1h","text":"..."},{"after":"3d","template":"..."}]}
+//
+// Textos aceitam {{lead_name}}, {{lead_id}} e {{stage}}. Os passos de
+// sequência contam a partir da entrada na etapa e são enviados pelo worker
+// (SEQUENCE_POLL_INTERVAL, padrão 1m) só se o lead continuar nela; senão são
+// cancelados.
+//
+// GET    /api/automations/stages                  ?stage=
+// POST   /api/automations/stages                  {"stage","action","config","active"}
+// PUT    /api/automations/stages/{id}             {"config","active"}
+// DELETE /api/automations/stages/{id}
+// GET    /api/automations/stages/{id}/runs
+// GET    /api/tasks                               ?status=open|done&lead_id=
+// PUT    /api/tasks/{id}                          {"done":true}
+
+const (
+	automationSendTemplate  = "send_template"
+	automationCreateTask    = "create_task"
+	automationNotifyUser    = "notify_user"
+	automationStartSequence = "start_sequence"
+)
+
+var automationActions = []string{automationSendTemplate, automationCreateTask, automationNotifyUser, automationStartSequence}
+
+const maxSequenceSteps = 10
+
+type stageAutomation struct {
+	ID        int64           `json:"id"`
+	OrgID     int64           `json:"org_id"`
+	FlowID    int64           `json:"flow_id"`
+	Stage     string          `json:"stage"`
+	Action    string          `json:"action"`
+	Config    json.RawMessage `json:"config"`
+	Active    bool            `json:"active"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+const stageAutomationColumns = `id, org_id, flow_id, stage, action, config, active, created_at, updated_at`
+
+func scanStageAutomation(row pgx.Row) (stageAutomation, error) {
+	var s stageAutomation
+	err := row.Scan(&s.ID, &s.OrgID, &s.FlowID, &s.Stage, &s.Action, &s.Config, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// automationConfig reúne os campos de todas as ações; cada uma lê os seus.
+type automationConfig struct {
+	Template   string          `json:"template,omitempty"`
+	Language   string          `json:"language,omitempty"`
+	Components json.RawMessage `json:"components,omitempty"`
+	Text       string          `json:"text,omitempty"`
+
+	Title    string `json:"title,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+	DueIn    string `json:"due_in,omitempty"`
+	AssignTo *int64 `json:"assign_to,omitempty"`
+
+	UserID  *int64 `json:"user_id,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Steps []sequenceStep `json:"steps,omitempty"`
+}
+
+type sequenceStep struct {
+	After      string          `json:"after"`
+	Template   string          `json:"template,omitempty"`
+	Language   string          `json:"language,omitempty"`
+	Components json.RawMessage `json:"components,omitempty"`
+	Text       string          `json:"text,omitempty"`
+}
+
+// parseAutomationDelay aceita durações do Go ("30m", "2h") e dias ("3d").
+func parseAutomationDelay(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid delay %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid delay %q", s)
+	}
+	return d, nil
+}
+
+// validateAutomationConfig confere a config da ação e devolve a versão
+// normalizada para gravar.
+func validateAutomationConfig(action string, raw json.RawMessage) (json.RawMessage, error) {
+	var c automationConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	switch action {
+	case automationSendTemplate:
+		c.Template, c.Text = strings.TrimSpace(c.Template), strings.TrimSpace(c.Text)
+		if c.Template == "" && c.Text == "" {
+			return nil, errors.New("config.template or config.text required")
+		}
+	case automationCreateTask:
+		c.Title = strings.TrimSpace(c.Title)
+		if c.Title == "" {
+			return nil, errors.New("config.title required")
+		}
+		if _, err := parseAutomationDelay(c.DueIn); err != nil {
+			return nil, fmt.Errorf("config.due_in: %v", err)
+		}
+	case automationNotifyUser:
+		c.Message = strings.TrimSpace(c.Message)
+		if c.Message == "" {
+			return nil, errors.New("config.message required")
+		}
+		if c.UserID == nil {
+			c.Role = nonEmpty(strings.ToLower(strings.TrimSpace(c.Role)), roleAdmin)
+			if !validRole(c.Role) {
+				return nil, fmt.Errorf("invalid config.role %q", c.Role)
+			}
+		}
+	case automationStartSequence:
+		if len(c.Steps) == 0 || len(c.Steps) > maxSequenceSteps {
+			return nil, fmt.Errorf("config.steps must have 1 to %d steps", maxSequenceSteps)
+		}
+		for i, st := range c.Steps {
+			if _, err := parseAutomationDelay(st.After); err != nil {
+				return nil, fmt.Errorf("config.steps[%d].after: %v", i, err)
+			}
+			if strings.TrimSpace(st.Template) == "" && strings.TrimSpace(st.Text) == "" {
+				return nil, fmt.Errorf("config.steps[%d]: template or text required", i)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q (use %s)", action, strings.Join(automationActions, ", "))
+	}
+	return json.Marshal(c)
+}
+
+func (a *App) mountStageAutomations(r chi.Router) {
+	onEvent(eventLeadStageChanged, a.onLeadStageEvent)
+	onEvent(eventLeadCreated, a.onLeadStageEvent)
+
+	admin := a.requireRole(roleAdmin)
+	r.Route("/automations/stages", func(r chi.Router) {
+		r.Get("/", a.listStageAutomations)
+		r.With(admin).Post("/", a.createStageAutomation)
+		r.With(admin).Put("/{id}", a.updateStageAutomation)
+		r.With(admin).Delete("/{id}", a.deleteStageAutomation)
+		r.Get("/{id}/runs", a.listStageAutomationRuns)
+	})
+	r.Get("/tasks", a.listLeadTasks)
+	r.Put("/tasks/{id}", a.updateLeadTask)
+
+	if every := sequencePollInterval(); every > 0 {
+		go a.sequenceLoop(every)
+	}
+}
+
+func sequencePollInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("SEQUENCE_POLL_INTERVAL", "1m"))
+	if err != nil || d < 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// ---------------- execução ----------------
+
+// onLeadStageEvent recebe lead.created (map de insertLead) e
+// lead.stage_changed (leadStageEvent).
+func (a *App) onLeadStageEvent(ctx context.Context, orgID int64, data any) {
+	var flowID, leadID int64
+	var stage string
+	switch ev := data.(type) {
+	case leadStageEvent:
+		flowID, leadID, stage = ev.FlowID, ev.LeadID, ev.To
+	case map[string]any:
+		leadID, _ = ev["id"].(int64)
+		flowID, _ = ev["flow_id"].(int64)
+		stage, _ = ev["stage"].(string)
+	}
+	stage = normalizeLeadStage(stage)
+	if leadID == 0 || stage == "" {
+		return
+	}
+	// passos de sequência de outras etapas deixam de valer
+	if _, err := a.DB.Exec(ctx, `
+UPDATE public.lead_sequence_steps SET status='canceled'
+ WHERE lead_id=$1 AND org_id=$2 AND status='pending' AND stage <> $3`, leadID, orgID, stage); err != nil {
+		log.Printf("stage automations org=%d lead=%d: cancel steps: %v", orgID, leadID, err)
+	}
+	a.runStageAutomations(ctx, orgID, flowID, leadID, stage)
+}
+
+func (a *App) runStageAutomations(ctx context.Context, orgID, flowID, leadID int64, stage string) {
+	rows, err := a.DB.Query(ctx, `
+SELECT `+stageAutomationColumns+` FROM public.stage_automations
+ WHERE org_id=$1 AND flow_id=$2 AND stage=$3 AND active ORDER BY id`, orgID, flowID, stage)
+	if err != nil {
+		log.Printf("stage automations org=%d lead=%d: %v", orgID, leadID, err)
+		return
+	}
+	var autos []stageAutomation
+	for rows.Next() {
+		s, err := scanStageAutomation(rows)
+		if err != nil {
+			log.Printf("stage automations org=%d lead=%d: %v", orgID, leadID, err)
+			continue
+		}
+		autos = append(autos, s)
+	}
+	rows.Close()
+	if len(autos) == 0 {
+		return
+	}
+
+	lead, err := a.loadLead(ctx, orgID, flowID, leadID)
+	if err != nil {
+		log.Printf("stage automations org=%d lead=%d: %v", orgID, leadID, err)
+		return
+	}
+	for _, au := range autos {
+		status, err := a.runStageAutomation(ctx, au, lead)
+		var msg *string
+		if err != nil {
+			status = "failed"
+			s := err.Error()
+			msg = &s
+			log.Printf("stage automation %d (%s) lead=%d: %v", au.ID, au.Action, leadID, err)
+		}
+		if _, err := a.DB.Exec(ctx, `
+INSERT INTO public.stage_automation_runs (automation_id, org_id, lead_id, status, error)
+VALUES ($1, $2, $3, $4, $5)`, au.ID, orgID, leadID, status, msg); err != nil {
+			log.Printf("stage automation %d run log: %v", au.ID, err)
+		}
+	}
+}
+
+// runStageAutomation executa uma ação e devolve o status da execução.
+func (a *App) runStageAutomation(ctx context.Context, au stageAutomation, lead Lead) (string, error) {
+	var c automationConfig
+	if err := json.Unmarshal(au.Config, &c); err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+	switch au.Action {
+	case automationSendTemplate:
+		return "done", a.sendAutomationMessage(ctx, lead, sequenceStep{
+			Template: c.Template, Language: c.Language, Components: c.Components, Text: c.Text,
+		})
+	case automationCreateTask:
+		due, _ := parseAutomationDelay(c.DueIn)
+		var dueAt *time.Time
+		if due > 0 {
+			t := time.Now().Add(due)
+			dueAt = &t
+		}
+		_, err := a.DB.Exec(ctx, `
+INSERT INTO public.lead_tasks (org_id, flow_id, lead_id, title, notes, assigned_to, due_at, automation_id)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), $6, $7, $8)`,
+			lead.OrgID, lead.FlowID, lead.ID, automationText(c.Title, lead), automationText(c.Notes, lead),
+			c.AssignTo, dueAt, au.ID)
+		return "done", err
+	case automationNotifyUser:
+		return "done", a.notifyAutomationUsers(ctx, c, lead)
+	case automationStartSequence:
+		now := time.Now()
+		for i, st := range c.Steps {
+			after, _ := parseAutomationDelay(st.After)
+			payload, err := json.Marshal(st)
+			if err != nil {
+				return "", err
+			}
+			if _, err := a.DB.Exec(ctx, `
+INSERT INTO public.lead_sequence_steps (automation_id, org_id, flow_id, lead_id, stage, step, run_at, payload)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				au.ID, lead.OrgID, lead.FlowID, lead.ID, au.Stage, i+1, now.Add(after), payload); err != nil {
+				return "", err
+			}
+		}
+		return "scheduled", nil
+	}
+	return "", fmt.Errorf("unknown action %q", au.Action)
+}
+
+func automationText(s string, lead Lead) string {
+	return strings.NewReplacer(
+		"{{lead_name}}", nonEmpty(lead.Name, "cliente"),
+		"{{lead_id}}", strconv.FormatInt(lead.ID, 10),
+		"{{stage}}", lead.Stage,
+	).Replace(s)
+}
+
+// sendAutomationMessage manda um template (API oficial) ou texto ao lead pela
+// última conversa.
+func (a *App) sendAutomationMessage(ctx context.Context, lead Lead, m sequenceStep) error {
+	row, contact, err := a.leadWAContact(ctx, lead.OrgID, lead.FlowID, lead.ID)
+	if err != nil {
+		return err
+	}
+	if m.Template != "" {
+		_, status, err := a.sendWATemplate(ctx, row, row.Token, contact, m.Template, m.Language, m.Components)
+		if err == nil && status >= 300 {
+			err = fmt.Errorf("provider status %d", status)
+		}
+		return err
+	}
+	_, status, err := a.sendWAText(ctx, row, row.Token, contact, automationText(m.Text, lead))
+	if err == nil && status >= 300 {
+		err = fmt.Errorf("provider status %d", status)
+	}
+	return err
+}
+
+func (a *App) notifyAutomationUsers(ctx context.Context, c automationConfig, lead Lead) error {
+	rows, err := a.DB.Query(ctx, `
+SELECT COALESCE(email,''), role FROM public.users
+ WHERE org_id=$1 AND ($2::bigint IS NULL OR id=$2)`, lead.OrgID, c.UserID)
+	if err != nil {
+		return err
+	}
+	var to []string
+	for rows.Next() {
+		var email, role string
+		if err := rows.Scan(&email, &role); err != nil {
+			rows.Close()
+			return err
+		}
+		if email != "" && (c.UserID != nil || roleAtLeast(role, c.Role)) {
+			to = append(to, email)
+		}
+	}
+	rows.Close()
+	if len(to) == 0 {
+		return errors.New("no user to notify")
+	}
+	subject := automationText(nonEmpty(c.Subject, "Lead {{lead_name}} entrou em {{stage}}"), lead)
+	text := automationText(c.Message, lead)
+	sender := emailSenderFromEnv()
+	for _, addr := range to {
+		if err := sender.Send(ctx, emailMessage{To: addr, Subject: subject, Text: text}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------- sequências ----------------
+
+func (a *App) sequenceLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		for a.sendSequenceBatch() {
+			// lote cheio: pode haver mais passos vencidos
+		}
+	}
+}
+
+// sendSequenceBatch envia até 20 passos vencidos. Devolve true se o lote veio
+// cheio.
+func (a *App) sendSequenceBatch() bool {
+	const batch = 20
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	// reserva os passos por 5 minutos (outra réplica não pega os mesmos)
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.lead_sequence_steps
+   SET run_at = NOW() + INTERVAL '5 minutes'
+ WHERE id IN (SELECT id FROM public.lead_sequence_steps
+               WHERE status='pending' AND run_at <= NOW()
+               ORDER BY run_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+RETURNING id, org_id, flow_id, lead_id, stage, payload`, batch)
+	if err != nil {
+		log.Printf("sequence steps: %v", err)
+		return false
+	}
+	type job struct {
+		id, orgID, flowID, leadID int64
+		stage                     string
+		payload                   []byte
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.orgID, &j.flowID, &j.leadID, &j.stage, &j.payload); err != nil {
+			log.Printf("sequence steps: %v", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		status, err := a.sendSequenceStep(ctx, j.orgID, j.flowID, j.leadID, j.stage, j.payload)
+		var msg *string
+		if err != nil {
+			s := err.Error()
+			msg = &s
+			log.Printf("sequence step %d lead=%d: %v", j.id, j.leadID, err)
+		}
+		if _, err := a.DB.Exec(ctx, `
+UPDATE public.lead_sequence_steps
+   SET status=$2, error=$3, sent_at = CASE WHEN $2 = 'sent' THEN NOW() END
+ WHERE id=$1`, j.id, status, msg); err != nil {
+			log.Printf("sequence step %d: %v", j.id, err)
+		}
+	}
+	return len(jobs) == batch
+}
+
+func (a *App) sendSequenceStep(ctx context.Context, orgID, flowID, leadID int64, stage string, payload []byte) (string, error) {
+	lead, err := a.loadLead(ctx, orgID, flowID, leadID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "canceled", nil
+	}
+	if err != nil {
+		return "failed", err
+	}
+	if normalizeLeadStage(lead.Stage) != stage {
+		return "canceled", nil
+	}
+	var st sequenceStep
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return "failed", err
+	}
+	if err := a.sendAutomationMessage(ctx, lead, st); err != nil {
+		return "failed", err
+	}
+	return "sent", nil
+}
+
+// ---------------- API: automações ----------------
+
+func (a *App) stageAutomationForTenant(w http.ResponseWriter, r *http.Request) (stageAutomation, bool) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return stageAutomation{}, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return stageAutomation{}, false
+	}
+	s, err := scanStageAutomation(a.DB.QueryRow(r.Context(), `
+SELECT `+stageAutomationColumns+` FROM public.stage_automations
+ WHERE id=$1 AND org_id=$2 AND flow_id=$3`, id, orgID, flowID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "automation not found")
+		return stageAutomation{}, false
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return stageAutomation{}, false
+	}
+	return s, true
+}
+
+// GET /api/automations/stages?stage=
+func (a *App) listStageAutomations(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stage := normalizeLeadStage(r.URL.Query().Get("stage"))
+	rows, err := a.DB.Query(r.Context(), `
+SELECT `+stageAutomationColumns+` FROM public.stage_automations
+ WHERE org_id=$1 AND flow_id=$2 AND ($3 = '' OR stage=$3)
+ ORDER BY stage, id`, orgID, flowID, stage)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []stageAutomation{}
+	for rows.Next() {
+		s, err := scanStageAutomation(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, s)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// POST /api/automations/stages
+func (a *App) createStageAutomation(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		Stage  string          `json:"stage"`
+		Action string          `json:"action"`
+		Config json.RawMessage `json:"config"`
+		Active *bool           `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Stage = normalizeLeadStage(in.Stage)
+	if _, ok := leadStageTransitions[in.Stage]; !ok {
+		render.Error(w, http.StatusBadRequest, fmt.Sprintf("%v %q", errUnknownStage, in.Stage))
+		return
+	}
+	in.Action = strings.ToLower(strings.TrimSpace(in.Action))
+	cfg, err := validateAutomationConfig(in.Action, in.Config)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	active := in.Active == nil || *in.Active
+	s, err := scanStageAutomation(a.DB.QueryRow(r.Context(), `
+INSERT INTO public.stage_automations (org_id, flow_id, stage, action, config, active)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING `+stageAutomationColumns, orgID, flowID, in.Stage, in.Action, cfg, active))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, s)
+}
+
+// PUT /api/automations/stages/{id}
+func (a *App) updateStageAutomation(w http.ResponseWriter, r *http.Request) {
+	cur, ok := a.stageAutomationForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Config json.RawMessage `json:"config"`
+		Active *bool           `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.Config != nil {
+		cfg, err := validateAutomationConfig(cur.Action, in.Config)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cur.Config = cfg
+	}
+	if in.Active != nil {
+		cur.Active = *in.Active
+	}
+	s, err := scanStageAutomation(a.DB.QueryRow(r.Context(), `
+UPDATE public.stage_automations SET config=$2, active=$3, updated_at=NOW()
+ WHERE id=$1
+RETURNING `+stageAutomationColumns, cur.ID, cur.Config, cur.Active))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !s.Active {
+		// desativar interrompe as sequências ainda não enviadas
+		if _, err := a.DB.Exec(r.Context(), `
+UPDATE public.lead_sequence_steps SET status='canceled'
+ WHERE automation_id=$1 AND status='pending'`, s.ID); err != nil {
+			log.Printf("stage automation %d: cancel steps: %v", s.ID, err)
+		}
+	}
+	render.OK(w, s)
+}
+
+// DELETE /api/automations/stages/{id}
+func (a *App) deleteStageAutomation(w http.ResponseWriter, r *http.Request) {
+	cur, ok := a.stageAutomationForTenant(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.DB.Exec(r.Context(), `DELETE FROM public.stage_automations WHERE id=$1`, cur.ID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.NoContent(w)
+}
+
+type stageAutomationRun struct {
+	ID        int64     `json:"id"`
+	LeadID    int64     `json:"lead_id"`
+	Status    string    `json:"status"`
+	Error     *string   `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET /api/automations/stages/{id}/runs
+func (a *App) listStageAutomationRuns(w http.ResponseWriter, r *http.Request) {
+	cur, ok := a.stageAutomationForTenant(w, r)
+	if !ok {
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, lead_id, status, error, created_at FROM public.stage_automation_runs
+ WHERE automation_id=$1 ORDER BY created_at DESC LIMIT 200`, cur.ID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []stageAutomationRun{}
+	for rows.Next() {
+		var v stageAutomationRun
+		if err := rows.Scan(&v.ID, &v.LeadID, &v.Status, &v.Error, &v.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, v)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// ---------------- API: tarefas ----------------
+
+type leadTask struct {
+	ID           int64      `json:"id"`
+	LeadID       int64      `json:"lead_id"`
+	Title        string     `json:"title"`
+	Notes        string     `json:"notes,omitempty"`
+	AssignedTo   *int64     `json:"assigned_to,omitempty"`
+	DueAt        *time.Time `json:"due_at,omitempty"`
+	DoneAt       *time.Time `json:"done_at,omitempty"`
+	AutomationID *int64     `json:"automation_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+const leadTaskColumns = `id, lead_id, title, COALESCE(notes,''), assigned_to, due_at, done_at, automation_id, created_at`
+
+func scanLeadTask(row pgx.Row) (leadTask, error) {
+	var t leadTask
+	err := row.Scan(&t.ID, &t.LeadID, &t.Title, &t.Notes, &t.AssignedTo, &t.DueAt, &t.DoneAt, &t.AutomationID, &t.CreatedAt)
+	return t, err
+}
+
+// GET /api/tasks?status=open|done&lead_id=
+func (a *App) listLeadTasks(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	status := nonEmpty(q.Get("status"), "open")
+	if status != "open" && status != "done" && status != "all" {
+		render.Error(w, http.StatusBadRequest, "invalid status (use open, done or all)")
+		return
+	}
+	leadID, _ := strconv.ParseInt(q.Get("lead_id"), 10, 64)
+	rows, err := a.DB.Query(r.Context(), `
+SELECT `+leadTaskColumns+` FROM public.lead_tasks
+ WHERE org_id=$1 AND flow_id=$2
+   AND ($3 = 'all' OR ($3 = 'open') = (done_at IS NULL))
+   AND ($4 = 0 OR lead_id=$4)
+ ORDER BY due_at NULLS LAST, id LIMIT 500`, orgID, flowID, status, leadID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []leadTask{}
+	for rows.Next() {
+		t, err := scanLeadTask(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, t)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// PUT /api/tasks/{id}
+func (a *App) updateLeadTask(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var in struct {
+		Done       *bool  `json:"done"`
+		AssignedTo *int64 `json:"assigned_to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	t, err := scanLeadTask(a.DB.QueryRow(r.Context(), `
+UPDATE public.lead_tasks SET
+  done_at     = CASE WHEN $4::boolean IS NULL THEN done_at WHEN $4 THEN COALESCE(done_at, NOW()) END,
+  assigned_to = COALESCE($5, assigned_to)
+ WHERE id=$1 AND org_id=$2 AND flow_id=$3
+RETURNING `+leadTaskColumns, id, orgID, flowID, in.Done, in.AssignedTo))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "task not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, t)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Estoque compartilhado entre flows (stock pools)
+// ================================================================
+//
+// Uma org que vende o mesmo estoque em vários flows agrupa os produtos
+// equivalentes num pool (migrations/0026). O pool guarda a quantidade; o
+// products.stock de cada produto do pool é um espelho atualizado a cada
+// mudança, então agente, vitrine, feeds e sincronização com a Meta continuam
+// lendo products.stock sem saber do pool.
+//
+// GET    /api/stock-pools                     pools da org com os produtos de cada um
+// POST   /api/stock-pools                     {"name","quantity"} (admin)
+// PUT    /api/stock-pools/{id}                {"name","quantity"} ou {"adjust":-2} (admin)
+// DELETE /api/stock-pools/{id}                produtos ficam com o último estoque (admin)
+// PUT    /api/products/{id}/stock-pool        {"pool_id":3|null} (admin)
+//
+// Venda: o pedido baixa a quantidade de cada item de produto com pool do
+// pool ao ser criado (order_stock.go), uma única vez por pedido
+// (orders.stock_committed_at), e o novo saldo aparece para todos os flows.
+// Alterar o estoque de um produto do pool (PUT /api/products/{id}, n8n)
+// altera o pool.
+
+type stockPool struct {
+	ID        int64              `json:"id"`
+	OrgID     int64              `json:"org_id"`
+	Name      string             `json:"name"`
+	Quantity  int                `json:"quantity"`
+	Products  []stockPoolProduct `json:"products"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+type stockPoolProduct struct {
+	ID     int64  `json:"id"`
+	FlowID int64  `json:"flow_id"`
+	Title  string `json:"title"`
+}
+
+func (a *App) mountStockPools(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.Route("/stock-pools", func(r chi.Router) {
+		r.Get("/", a.listStockPools)
+		r.With(admin).Post("/", a.createStockPool)
+		r.With(admin).Put("/{id}", a.updateStockPool)
+		r.With(admin).Delete("/{id}", a.deleteStockPool)
+	})
+	r.With(admin).Put("/products/{id}/stock-pool", a.setProductStockPool)
+
+	onEvent(eventOrderPaid, func(ctx context.Context, orgID int64, data any) {
+		if o, ok := data.(Order); ok {
+			if err := a.commitOrderStock(ctx, o.ID); err != nil {
+				log.Printf("order %d stock pools: %v", o.ID, err)
+			}
+		}
+	})
+}
+
+// syncPoolProducts copia a quantidade dos pools para products.stock.
+func syncPoolProducts(ctx context.Context, tx pgx.Tx, poolIDs []int64) error {
+	_, err := tx.Exec(ctx, `
+UPDATE products p SET stock = sp.quantity
+  FROM stock_pools sp
+ WHERE p.stock_pool_id = sp.id AND sp.id = ANY($1) AND p.stock IS DISTINCT FROM sp.quantity`, poolIDs)
+	return err
+}
+
+func (a *App) loadStockPools(ctx context.Context, orgID, poolID int64) ([]stockPool, error) {
+	rows, err := a.DB.Query(ctx, `
+SELECT sp.id, sp.org_id, sp.name, sp.quantity, sp.created_at, sp.updated_at,
+       COALESCE(json_agg(json_build_object('id', p.id, 'flow_id', p.flow_id, 'title', p.title)
+                ORDER BY p.flow_id, p.id) FILTER (WHERE p.id IS NOT NULL), '[]')
+  FROM stock_pools sp
+  LEFT JOIN products p ON p.stock_pool_id = sp.id
+ WHERE sp.org_id=$1 AND ($2 = 0 OR sp.id=$2)
+ GROUP BY sp.id
+ ORDER BY sp.name`, orgID, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []stockPool{}
+	for rows.Next() {
+		var (
+			sp   stockPool
+			prod []byte
+		)
+		if err := rows.Scan(&sp.ID, &sp.OrgID, &sp.Name, &sp.Quantity, &sp.CreatedAt, &sp.UpdatedAt, &prod); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(prod, &sp.Products); err != nil {
+			return nil, err
+		}
+		out = append(out, sp)
+	}
+	return out, rows.Err()
+}
+
+// GET /api/stock-pools
+func (a *App) listStockPools(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pools, err := a.loadStockPools(r.Context(), orgID, 0)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"items": pools})
+}
+
+type stockPoolReq struct {
+	Name     *string `json:"name,omitempty"`
+	Quantity *int    `json:"quantity,omitempty"`
+	Adjust   *int    `json:"adjust,omitempty"`
+}
+
+// POST /api/stock-pools
+func (a *App) createStockPool(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in stockPoolReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	name := ""
+	if in.Name != nil {
+		name = strings.TrimSpace(*in.Name)
+	}
+	qty := 0
+	if in.Quantity != nil {
+		qty = *in.Quantity
+	}
+	if name == "" || qty < 0 {
+		render.Error(w, http.StatusBadRequest, "name required and quantity must be >= 0")
+		return
+	}
+	var id int64
+	err = a.DB.QueryRow(r.Context(), `
+INSERT INTO stock_pools (org_id, name, quantity) VALUES ($1, $2, $3)
+ON CONFLICT (org_id, name) DO NOTHING RETURNING id`,
+		orgID, limitRunes(name, 120), qty).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusConflict, "a stock pool with this name already exists")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pools, err := a.loadStockPools(r.Context(), orgID, id)
+	if err != nil || len(pools) == 0 {
+		render.Error(w, http.StatusInternalServerError, "reload stock pool failed")
+		return
+	}
+	render.Created(w, pools[0])
+}
+
+// PUT /api/stock-pools/{id}
+func (a *App) updateStockPool(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid stock pool id")
+		return
+	}
+	var in stockPoolReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.Quantity != nil && in.Adjust != nil {
+		render.Error(w, http.StatusBadRequest, "use quantity or adjust, not both")
+		return
+	}
+	if in.Quantity != nil && *in.Quantity < 0 {
+		render.Error(w, http.StatusBadRequest, "quantity must be >= 0")
+		return
+	}
+	var name string
+	if in.Name != nil {
+		if name = limitRunes(strings.TrimSpace(*in.Name), 120); name == "" {
+			render.Error(w, http.StatusBadRequest, "name cannot be empty")
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if name != "" {
+		var taken bool
+		if err := a.DB.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM stock_pools WHERE org_id=$1 AND name=$2 AND id<>$3)`,
+			orgID, name, id).Scan(&taken); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if taken {
+			render.Error(w, http.StatusConflict, "a stock pool with this name already exists")
+			return
+		}
+	}
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	// adjust negativo nunca deixa o saldo abaixo de zero
+	_, err = tx.Exec(ctx, `
+UPDATE stock_pools SET
+  name       = COALESCE(NULLIF($3,''), name),
+  quantity   = GREATEST(COALESCE($4, quantity) + COALESCE($5, 0), 0),
+  updated_at = NOW()
+WHERE id=$1 AND org_id=$2`, id, orgID, name, in.Quantity, in.Adjust)
+	if err == nil {
+		err = syncPoolProducts(ctx, tx, []int64{id})
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pools, err := a.loadStockPools(ctx, orgID, id)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(pools) == 0 {
+		render.Error(w, http.StatusNotFound, "stock pool not found")
+		return
+	}
+	a.touchProductFeed(orgID, 0)
+	render.OK(w, pools[0])
+}
+
+// DELETE /api/stock-pools/{id}
+func (a *App) deleteStockPool(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	tag, err := a.DB.Exec(r.Context(), `DELETE FROM stock_pools WHERE id=$1 AND org_id=$2`, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "stock pool not found")
+		return
+	}
+	render.NoContent(w)
+}
+
+// PUT /api/products/{id}/stock-pool
+func (a *App) setProductStockPool(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || productID <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	var in struct {
+		PoolID *int64 `json:"pool_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback(ctx)
+	var flowID int64
+	var stock int
+	// o pool precisa ser da mesma org do produto; o produto assume o saldo do pool
+	err = tx.QueryRow(ctx, `
+UPDATE products p SET stock_pool_id = sp.id, stock = COALESCE(sp.quantity, p.stock)
+  FROM (SELECT $3::bigint AS id) want
+  LEFT JOIN stock_pools sp ON sp.id = want.id AND sp.org_id = $2
+ WHERE p.id=$1 AND p.org_id=$2 AND (want.id IS NULL OR sp.id IS NOT NULL)
+RETURNING p.flow_id, p.stock`, productID, orgID, in.PoolID).Scan(&flowID, &stock)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "product or stock pool not found")
+		return
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.touchProductFeed(orgID, flowID)
+	render.OK(w, map[string]any{"product_id": productID, "pool_id": in.PoolID, "stock": stock})
+}
+
+// pushProductStockToPool leva ao pool o estoque gravado diretamente no
+// produto (edição manual, n8n) e replica para os outros produtos do pool.
+// Produto sem pool: nada a fazer.
+func (a *App) pushProductStockToPool(ctx context.Context, orgID, productID int64) error {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var poolID int64
+	err = tx.QueryRow(ctx, `
+UPDATE stock_pools sp SET quantity = GREATEST(p.stock, 0), updated_at = NOW()
+  FROM products p
+ WHERE p.id=$1 AND p.org_id=$2 AND sp.id = p.stock_pool_id
+RETURNING sp.id`, productID, orgID).Scan(&poolID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := syncPoolProducts(ctx, tx, []int64{poolID}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	a.touchProductFeed(orgID, 0)
+	return nil
+}
+
+// commitOrderStock baixa o estoque do pedido pago que ainda não baixou na
+// criação (order_stock.go). A marca orders.stock_committed_at garante uma
+// única baixa por pedido.
+func (a *App) commitOrderStock(ctx context.Context, orderID int64) error {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var orgID int64
+	var committed bool
+	err = tx.QueryRow(ctx, `SELECT org_id, stock_committed_at IS NOT NULL FROM orders WHERE id=$1 FOR UPDATE`,
+		orderID).Scan(&orgID, &committed)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && committed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := reserveOrderStockTx(ctx, tx, orgID, orderID, false); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	a.touchProductFeed(orgID, 0)
+	return nil
+}
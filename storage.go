@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================
+//  Armazenamento de uploads (disco local ou bucket S3-compatível)
+// ================================================================
+//
+// Os handlers continuam gravando primeiro em UPLOAD_DIR (antivírus, ffprobe
+// e miniaturas precisam do arquivo local); storeUpload publica o arquivo no
+// driver configurado e devolve a URL final.
+//
+//   STORAGE_DRIVER        local (padrão) | s3
+//   S3_BUCKET             bucket (obrigatório com s3)
+//   S3_REGION             região (padrão us-east-1; GCS: "auto")
+//   S3_ENDPOINT           ex.: https://storage.googleapis.com, https://<id>.r2.cloudflarestorage.com
+//                         (padrão https://s3.<região>.amazonaws.com)
+//   S3_ACCESS_KEY_ID / S3_SECRET_ACCESS_KEY
+//   S3_PREFIX             prefixo das chaves (ex.: "uploads/")
+//   S3_PUBLIC_URL         base pública (CDN / bucket público); sem ela a URL é pré-assinada
+//   S3_PRESIGN_TTL        validade da URL pré-assinada (padrão 168h, máximo do SigV4)
+//
+// Com s3 o arquivo local é removido após o envio, então réplicas e discos
+// efêmeros deixam de ser problema.
+
+type objectStorage interface {
+	// Put publica o arquivo local sob key.
+	Put(ctx context.Context, key, localPath, contentType string) error
+	// URL devolve a URL de acesso ao objeto.
+	URL(r *http.Request, key string) (string, error)
+}
+
+func storageFromEnv() objectStorage {
+	if strings.EqualFold(getenv("STORAGE_DRIVER", "local"), "s3") {
+		return s3StorageFromEnv()
+	}
+	return localStorage{dir: getenv("UPLOAD_DIR", "uploads")}
+}
+
+// storeUpload publica um arquivo já gravado em UPLOAD_DIR e devolve a URL.
+// r == nil gera URL relativa no driver local.
+func storeUpload(ctx context.Context, r *http.Request, localPath, contentType string) (string, error) {
+	st := storageFromEnv()
+	key := filepath.Base(localPath)
+	if err := st.Put(ctx, key, localPath, contentType); err != nil {
+		return "", err
+	}
+	return st.URL(r, key)
+}
+
+// ---------------- local ----------------
+
+type localStorage struct{ dir string }
+
+func (s localStorage) Put(ctx context.Context, key, localPath, contentType string) error {
+	dst := filepath.Join(s.dir, key)
+	if filepath.Clean(localPath) == filepath.Clean(dst) {
+		return nil
+	}
+	return os.Rename(localPath, dst)
+}
+
+func (s localStorage) URL(r *http.Request, key string) (string, error) {
+	if r == nil {
+		return "/uploads/" + key, nil
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/uploads/%s", scheme, r.Host, key), nil
+}
+
+// ---------------- s3 ----------------
+
+type s3Storage struct {
+	bucket, region, endpoint string
+	accessKey, secretKey     string
+	prefix, publicURL        string
+	presignTTL               time.Duration
+}
+
+func s3StorageFromEnv() *s3Storage {
+	region := getenv("S3_REGION", "us-east-1")
+	ttl, err := time.ParseDuration(getenv("S3_PRESIGN_TTL", "168h"))
+	if err != nil || ttl <= 0 || ttl > 168*time.Hour {
+		ttl = 168 * time.Hour
+	}
+	return &s3Storage{
+		bucket:     getenv("S3_BUCKET", ""),
+		region:     region,
+		endpoint:   strings.TrimRight(getenv("S3_ENDPOINT", "https://s3."+region+".amazonaws.com"), "/"),
+		accessKey:  getenv("S3_ACCESS_KEY_ID", ""),
+		secretKey:  getenv("S3_SECRET_ACCESS_KEY", ""),
+		prefix:     strings.TrimLeft(getenv("S3_PREFIX", ""), "/"),
+		publicURL:  strings.TrimRight(getenv("S3_PUBLIC_URL", ""), "/"),
+		presignTTL: ttl,
+	}
+}
+
+// objectURL usa endereçamento por caminho (endpoint/bucket/key), aceito por
+// S3, GCS (interoperabilidade XML), R2 e MinIO.
+func (s *s3Storage) objectURL(key string) *url.URL {
+	u, _ := url.Parse(s.endpoint)
+	u.Path = "/" + s.bucket + "/" + s.prefix + key
+	return u
+}
+
+func (s *s3Storage) Put(ctx context.Context, key, localPath, contentType string) error {
+	if s.bucket == "" || s.accessKey == "" || s.secretKey == "" {
+		return fmt.Errorf("s3 storage: S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, hex.EncodeToString(h.Sum(nil)), time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 put: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 put %s: status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	f.Close()
+	_ = os.Remove(localPath)
+	return nil
+}
+
+func (s *s3Storage) URL(r *http.Request, key string) (string, error) {
+	if s.publicURL != "" {
+		return s.publicURL + "/" + path.Join(s.prefix, key), nil
+	}
+	return s.presign(key, time.Now().UTC()), nil
+}
+
+// ---------------- AWS Signature V4 ----------------
+
+const s3TimeFormat = "20060102T150405Z"
+
+func (s *s3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *s3Storage) signingKey(t time.Time) []byte {
+	k := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format("20060102"))
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+// sign assina a requisição com headers (PUT).
+func (s *s3Storage) sign(req *http.Request, payloadHash string, t time.Time) {
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", t.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		names = append(names, "content-type")
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		v := req.Header.Get(n)
+		if n == "host" {
+			v = req.URL.Host
+		}
+		canonHeaders.WriteString(n + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canon := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	sig := s.signature(canon, t)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, s.scope(t), signed, sig))
+}
+
+// presign gera uma URL GET pré-assinada (assinatura na query).
+func (s *s3Storage) presign(key string, t time.Time) string {
+	u := s.objectURL(key)
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(t))
+	q.Set("X-Amz-Date", t.Format(s3TimeFormat))
+	q.Set("X-Amz-Expires", fmt.Sprint(int(s.presignTTL.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	// url.Values.Encode ordena as chaves, como o SigV4 exige
+	query := strings.ReplaceAll(q.Encode(), "+", "%20")
+	canon := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		query,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	u.RawQuery = query + "&X-Amz-Signature=" + s.signature(canon, t)
+	return u.String()
+}
+
+func (s *s3Storage) signature(canonicalRequest string, t time.Time) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format(s3TimeFormat),
+		s.scope(t),
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(s.signingKey(t), toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Vitrine pública (storefront): sitemap, slugs canônicos e OpenGraph
+// ================================================================
+//
+// Rotas públicas (sem JWT), apenas produtos com status "active":
+//   GET /store/{org}/sitemap.xml
+//   GET /store/{org}/products            -> lista JSON
+//   GET /store/{org}/search?q=           -> busca full-text (product_search.go)
+//   GET /store/{org}/r/{code}            -> link de indicação (referrals.go)
+//   GET /store/{org}/products/{ref}      -> detalhe JSON + metadados OG
+//   GET /store/{org}/p/{ref}             -> HTML com tags OG (unfurl no WhatsApp)
+//
+// {ref} aceita o slug ou o id numérico; a resposta sempre informa a URL
+// canônica (com slug, quando houver). STOREFRONT_BASE_URL define a origem
+// pública dessas rotas; sem ela usamos o host da requisição.
+
+var slugRe = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// accentFolder remove acentos comuns em português/espanhol.
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+// slugify converte um texto em slug URL-safe (minúsculas, sem acentos,
+// palavras separadas por hífen, no máximo 80 caracteres).
+func slugify(s string) string {
+	s = accentFolder.Replace(strings.ToLower(strings.TrimSpace(s)))
+	var b strings.Builder
+	dash := false
+	for _, r := range s {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			dash = false
+		default:
+			if b.Len() > 0 && !dash {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+	}
+	out := strings.Trim(b.String(), "-")
+	if len(out) > 80 {
+		out = strings.TrimRight(out[:80], "-")
+	}
+	return out
+}
+
+// productRef devolve o identificador público do produto: o slug, se for
+// URL-safe, ou o id.
+func productRef(id int64, slug string) string {
+	if slugRe.MatchString(slug) {
+		return slug
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+func storefrontBase(r *http.Request) string {
+	if b := strings.TrimRight(getenv("STOREFRONT_BASE_URL", ""), "/"); b != "" || r == nil {
+		return b
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// productPublicURL monta a URL canônica do produto. Sem STOREFRONT_BASE_URL
+// e sem requisição (ex.: jobs em background) devolve "".
+func productPublicURL(r *http.Request, orgID, id int64, slug string) string {
+	base := storefrontBase(r)
+	if base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/store/%d/p/%s", base, orgID, productRef(id, slug))
+}
+
+// ensureProductSlugUnique garante que o slug não está em uso por outro
+// produto da mesma org (exceptID = produto sendo editado).
+func (a *App) ensureProductSlugUnique(ctx context.Context, orgID int64, slug string, exceptID int64) error {
+	if slug == "" {
+		return nil
+	}
+	var exists bool
+	err := a.DB.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM products WHERE org_id=$1 AND slug=$2 AND id<>$3)`,
+		orgID, slug, exceptID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errSlugTaken
+	}
+	return nil
+}
+
+var errSlugTaken = errors.New("slug already in use")
+
+// índices de slug: migrations/0059_product_slugs.sql
+func (a *App) mountStorefront(r chi.Router) {
+	r.Route("/store/{org}", func(r chi.Router) {
+		r.Get("/sitemap.xml", a.storeSitemap)
+		r.Get("/products", a.storeListProducts)
+		r.Get("/search", a.storeSearch) // product_search.go
+		r.Get("/r/{code}", a.referralRedirect) // indicação (referrals.go)
+		r.Get("/products/{ref}", a.storeProductDetail)
+		r.Get("/p/{ref}", a.storeProductPage)
+	})
+}
+
+type storeProduct struct {
+	ID           int64     `json:"id"`
+	Title        string    `json:"title"`
+	Slug         string    `json:"slug"`
+	Description  string    `json:"description,omitempty"`
+	Category     string    `json:"category,omitempty"`
+	ImageURL     string    `json:"image_url,omitempty"`
+	VideoURL     string    `json:"video_url,omitempty"`
+	PriceCents   int       `json:"price_cents"`
+	InStock      bool      `json:"in_stock"`
+	CanonicalURL string    `json:"canonical_url"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const storeProductCols = `id, title, COALESCE(slug,''), COALESCE(description,''), COALESCE(category,''), COALESCE(image_base64,''),
+       COALESCE(video_url,''), price_cents, stock > 0, created_at`
+
+func scanStoreProduct(row pgx.Row, r *http.Request, orgID int64) (storeProduct, error) {
+	var p storeProduct
+	err := row.Scan(&p.ID, &p.Title, &p.Slug, &p.Description, &p.Category, &p.ImageURL, &p.VideoURL, &p.PriceCents, &p.InStock, &p.CreatedAt)
+	if err != nil {
+		return p, err
+	}
+	// image_base64 legado (base64 puro) não serve como URL pública
+	if !strings.HasPrefix(p.ImageURL, "http://") && !strings.HasPrefix(p.ImageURL, "https://") {
+		p.ImageURL = ""
+	}
+	if !slugRe.MatchString(p.Slug) {
+		p.Slug = ""
+	}
+	p.CanonicalURL = productPublicURL(r, orgID, p.ID, p.Slug)
+	return p, nil
+}
+
+func storeOrgID(r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "org"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// GET /store/{org}/products
+func (a *App) storeListProducts(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := storeOrgID(r)
+	if !ok {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `SELECT `+storeProductCols+`
+  FROM products WHERE org_id=$1 AND status='active' AND `+productAvailableSQL()+`
+ ORDER BY created_at DESC LIMIT 500`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []storeProduct{}
+	for rows.Next() {
+		p, err := scanStoreProduct(rows, r, orgID)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, p)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	render.OK(w, map[string]any{"items": out})
+}
+
+func (a *App) loadStoreProduct(r *http.Request) (storeProduct, int64, error) {
+	orgID, ok := storeOrgID(r)
+	if !ok {
+		return storeProduct{}, 0, pgx.ErrNoRows
+	}
+	ref := chi.URLParam(r, "ref")
+	id, _ := strconv.ParseInt(ref, 10, 64)
+	row := a.DB.QueryRow(r.Context(), `SELECT `+storeProductCols+`
+  FROM products
+ WHERE org_id=$1 AND status='active' AND `+productAvailableSQL()+` AND (slug=$2 OR id=$3)
+ ORDER BY (slug=$2) DESC LIMIT 1`, orgID, ref, id)
+	p, err := scanStoreProduct(row, r, orgID)
+	return p, orgID, err
+}
+
+// openGraph devolve as propriedades OG/Twitter do produto.
+func (p storeProduct) openGraph(orgName string) map[string]string {
+	desc := firstNonEmpty(limitRunes(p.Description, 200), p.Title)
+	if p.Category != "" {
+		desc = p.Category + " · " + desc
+	}
+	desc += fmt.Sprintf(" · R$ %.2f", float64(p.PriceCents)/100)
+	og := map[string]string{
+		"og:type":                "product",
+		"og:title":               p.Title,
+		"og:description":         desc,
+		"og:url":                 p.CanonicalURL,
+		"og:site_name":           orgName,
+		"product:price:amount":   fmt.Sprintf("%.2f", float64(p.PriceCents)/100),
+		"product:price:currency": getenv("CATALOG_CURRENCY", "BRL"),
+		"twitter:card":           "summary",
+	}
+	if p.ImageURL != "" {
+		og["og:image"] = p.ImageURL
+		og["twitter:card"] = "summary_large_image"
+	}
+	if p.VideoURL != "" {
+		og["og:video"] = p.VideoURL
+	}
+	return og
+}
+
+func (a *App) storeOrgName(ctx context.Context, orgID int64) string {
+	var name string
+	_ = a.DB.QueryRow(ctx, `
+SELECT COALESCE(NULLIF(c.nome_fantasia,''), o.name)
+  FROM orgs o LEFT JOIN company c ON c.org_id = o.id
+ WHERE o.id=$1`, orgID).Scan(&name)
+	return name
+}
+
+// GET /store/{org}/products/{ref}
+func (a *App) storeProductDetail(w http.ResponseWriter, r *http.Request) {
+	p, orgID, err := a.loadStoreProduct(r)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	render.OK(w, map[string]any{
+		"product":   p,
+		"canonical": p.CanonicalURL,
+		"og":        p.openGraph(a.storeOrgName(r.Context(), orgID)),
+	})
+}
+
+var storePageTmpl = template.Must(template.New("p").Parse(`<!doctype html>
+<html lang="pt-BR"><head>
+<meta charset="utf-8">
+<title>{{.P.Title}}</title>
+<link rel="canonical" href="{{.P.CanonicalURL}}">
+<meta name="description" content="{{index .OG "og:description"}}">
+{{range $k, $v := .OG}}{{if $v}}<meta property="{{$k}}" content="{{$v}}">
+{{end}}{{end}}</head>
+<body>
+<h1>{{.P.Title}}</h1>
+{{if .P.ImageURL}}<img src="{{.P.ImageURL}}" alt="{{.P.Title}}" style="max-width:100%">{{end}}
+<p>{{index .OG "og:description"}}</p>
+</body></html>`))
+
+// GET /store/{org}/p/{ref} — página mínima com metadados para crawlers
+// (WhatsApp, Instagram, Google). Acessos por id redirecionam para o slug.
+func (a *App) storeProductPage(w http.ResponseWriter, r *http.Request) {
+	p, orgID, err := a.loadStoreProduct(r)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if p.Slug != "" && chi.URLParam(r, "ref") != p.Slug {
+		http.Redirect(w, r, p.CanonicalURL, http.StatusMovedPermanently)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = storePageTmpl.Execute(w, map[string]any{"P": p, "OG": p.openGraph(a.storeOrgName(r.Context(), orgID))})
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// GET /store/{org}/sitemap.xml
+func (a *App) storeSitemap(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := storeOrgID(r)
+	if !ok {
+		render.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `SELECT `+storeProductCols+`
+  FROM products WHERE org_id=$1 AND status='active' AND `+productAvailableSQL()+`
+ ORDER BY id LIMIT 50000`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	set := sitemapURLSet{NS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for rows.Next() {
+		p, err := scanStoreProduct(rows, r, orgID)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		set.URLs = append(set.URLs, sitemapURL{Loc: p.CanonicalURL, LastMod: p.CreatedAt.UTC().Format("2006-01-02")})
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(set)
+}
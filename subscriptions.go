@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Assinaturas: produtos recorrentes e pedidos de renovação
+// ================================================================
+//
+// Produto com recurrence (weekly | monthly, migrations/0023) vira assinatura
+// quando o pedido que o contém é pago (evento order.paid): uma linha por item,
+// com o preço do pedido. O worker (SUBSCRIPTION_INTERVAL, padrão 15m; 0
+// desliga):
+//
+//   - avisa o lead por WhatsApp SUBSCRIPTION_REMINDER_BEFORE (padrão 48h)
+//     antes da renovação;
+//   - na data, gera o pedido de renovação (source 'subscription', status
+//     pending) e manda o resumo ao lead, com o link de pagamento quando a
+//     org tem conta de pagamento (payments.go). Só renova se o pedido
+//     anterior foi pago;
+//   - renovação ainda pendente depois de SUBSCRIPTION_GRACE (padrão 7 dias)
+//     encerra a assinatura como 'churned'.
+//
+// PUT  /api/products/{id}/recurrence     {"recurrence":"monthly"|null}
+// GET  /api/subscriptions                ?status=&lead_id=
+// POST /api/subscriptions                {"lead_id","product_id","qty","start_at"}
+// PUT  /api/subscriptions/{id}           {"status":"active|paused|canceled","next_order_at","qty"}
+// GET  /api/analytics/subscriptions      ?from=&to=&tz=   ativas, MRR, churn
+
+const (
+	subscriptionActive   = "active"
+	subscriptionPaused   = "paused"
+	subscriptionCanceled = "canceled"
+	subscriptionChurned  = "churned"
+)
+
+var validRecurrences = map[string]bool{"weekly": true, "monthly": true}
+
+// recurrenceSQL soma um ciclo a uma expressão de data, conforme s.recurrence.
+const recurrenceSQL = `CASE s.recurrence WHEN 'weekly' THEN INTERVAL '1 week' ELSE INTERVAL '1 month' END`
+
+type subscription struct {
+	ID             int64      `json:"id"`
+	OrgID          int64      `json:"org_id"`
+	FlowID         int64      `json:"flow_id"`
+	LeadID         int64      `json:"lead_id"`
+	ProductID      int64      `json:"product_id"`
+	ProductTitle   string     `json:"product_title"`
+	Qty            int        `json:"qty"`
+	UnitPriceCents int        `json:"unit_price_cents"`
+	Recurrence     string     `json:"recurrence"`
+	Status         string     `json:"status"`
+	NextOrderAt    time.Time  `json:"next_order_at"`
+	LastOrderID    *int64     `json:"last_order_id,omitempty"`
+	SourceOrderID  *int64     `json:"source_order_id,omitempty"`
+	Renewals       int        `json:"renewals"`
+	ReminderSentAt *time.Time `json:"reminder_sent_at,omitempty"`
+	StartedAt      time.Time  `json:"started_at"`
+	EndedAt        *time.Time `json:"ended_at,omitempty"`
+	EndReason      string     `json:"end_reason,omitempty"`
+
+	paymentMethod string
+	address       string
+}
+
+const subscriptionColumns = `s.id, s.org_id, s.flow_id, s.lead_id, s.product_id, COALESCE(p.title,''), s.qty, s.unit_price_cents,
+       s.recurrence, s.status, s.next_order_at, s.last_order_id, s.source_order_id, s.renewals, s.reminder_sent_at,
+       s.started_at, s.ended_at, COALESCE(s.end_reason,''), COALESCE(s.payment_method,''), COALESCE(s.shipping_address,'')`
+
+// subscriptionFrom junta o produto como p; nos UPDATE ... RETURNING, p é
+// uma subconsulta com a coluna title.
+const subscriptionFrom = `
+  FROM public.subscriptions s
+  LEFT JOIN products p ON p.id = s.product_id`
+
+func scanSubscription(row pgx.Row) (subscription, error) {
+	var s subscription
+	err := row.Scan(&s.ID, &s.OrgID, &s.FlowID, &s.LeadID, &s.ProductID, &s.ProductTitle, &s.Qty, &s.UnitPriceCents,
+		&s.Recurrence, &s.Status, &s.NextOrderAt, &s.LastOrderID, &s.SourceOrderID, &s.Renewals, &s.ReminderSentAt,
+		&s.StartedAt, &s.EndedAt, &s.EndReason, &s.paymentMethod, &s.address)
+	return s, err
+}
+
+func (a *App) mountSubscriptions(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.With(admin).Put("/products/{id}/recurrence", a.setProductRecurrence)
+	r.Route("/subscriptions", func(r chi.Router) {
+		r.Get("/", a.listSubscriptions)
+		r.Post("/", a.createSubscription)
+		r.Put("/{id}", a.updateSubscription)
+	})
+	r.With(csvFromJSON("subscriptions")).Get("/analytics/subscriptions", a.analyticsSubscriptions)
+
+	onEvent(eventOrderPaid, func(ctx context.Context, orgID int64, data any) {
+		if o, ok := data.(Order); ok {
+			if err := a.startSubscriptionsFromOrder(ctx, o.ID); err != nil {
+				log.Printf("order %d subscriptions: %v", o.ID, err)
+			}
+		}
+	})
+	if every := subscriptionInterval(); every > 0 {
+		go a.subscriptionLoop(every)
+	}
+}
+
+func subscriptionInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("SUBSCRIPTION_INTERVAL", "15m"))
+	if err != nil || d < 0 {
+		return 15 * time.Minute
+	}
+	return d
+}
+
+func subscriptionReminderBefore() time.Duration {
+	d, err := time.ParseDuration(getenv("SUBSCRIPTION_REMINDER_BEFORE", "48h"))
+	if err != nil || d < 0 {
+		return 48 * time.Hour
+	}
+	return d
+}
+
+func subscriptionGrace() time.Duration {
+	d, err := time.ParseDuration(getenv("SUBSCRIPTION_GRACE", "168h"))
+	if err != nil || d <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// recurrenceLabel é o ciclo como aparece para o lead.
+func recurrenceLabel(r string) string {
+	if r == "weekly" {
+		return "semanal"
+	}
+	return "mensal"
+}
+
+// ---------------- API ----------------
+
+// PUT /api/products/{id}/recurrence
+func (a *App) setProductRecurrence(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var in struct {
+		Recurrence *string `json:"recurrence"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	var rec *string
+	if in.Recurrence != nil && strings.TrimSpace(*in.Recurrence) != "" {
+		v := strings.ToLower(strings.TrimSpace(*in.Recurrence))
+		if !validRecurrences[v] {
+			render.Error(w, http.StatusBadRequest, "invalid recurrence (use weekly, monthly or null)")
+			return
+		}
+		rec = &v
+	}
+	tag, err := a.DB.Exec(r.Context(), `UPDATE products SET recurrence=$1 WHERE id=$2 AND org_id=$3`, rec, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "product not found")
+		return
+	}
+	render.OK(w, map[string]any{"product_id": id, "recurrence": rec})
+}
+
+// GET /api/subscriptions?status=&lead_id=
+func (a *App) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	rows, err := a.DB.Query(r.Context(), `
+SELECT `+subscriptionColumns+subscriptionFrom+`
+ WHERE s.org_id=$1 AND s.flow_id=$2 AND ($3 = '' OR s.status = $3) AND ($4 = 0 OR s.lead_id = $4)
+ ORDER BY s.next_order_at, s.id LIMIT 500`,
+		orgID, flowID, strings.TrimSpace(q.Get("status")), int64(mustAtoi(q.Get("lead_id"))))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []subscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, s)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// POST /api/subscriptions
+//
+// Assinatura manual (venda fora do sistema). O primeiro pedido sai em
+// start_at (padrão: agora), pelo worker.
+func (a *App) createSubscription(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		LeadID    int64      `json:"lead_id"`
+		ProductID int64      `json:"product_id"`
+		Qty       int        `json:"qty"`
+		StartAt   *time.Time `json:"start_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.LeadID <= 0 || in.ProductID <= 0 {
+		render.Error(w, http.StatusBadRequest, "lead_id and product_id required")
+		return
+	}
+	if in.Qty <= 0 {
+		in.Qty = 1
+	}
+	start := time.Now()
+	if in.StartAt != nil {
+		start = *in.StartAt
+	}
+	var id int64
+	err = a.DB.QueryRow(r.Context(), `
+INSERT INTO public.subscriptions (org_id, flow_id, lead_id, product_id, qty, unit_price_cents, recurrence, next_order_at)
+SELECT $1, $2, l.id, p.id, $5, p.price_cents, p.recurrence, $6
+  FROM products p, leads l
+ WHERE p.id=$4 AND p.org_id=$1 AND p.recurrence IS NOT NULL
+   AND l.id=$3 AND l.org_id=$1 AND l.flow_id=$2
+RETURNING id`, orgID, flowID, in.LeadID, in.ProductID, in.Qty, start).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusUnprocessableEntity, "lead not found or product is not recurring")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, err := scanSubscription(a.DB.QueryRow(r.Context(), `SELECT `+subscriptionColumns+subscriptionFrom+` WHERE s.id=$1`, id))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, s)
+}
+
+// PUT /api/subscriptions/{id}
+func (a *App) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var in struct {
+		Status      string     `json:"status"`
+		NextOrderAt *time.Time `json:"next_order_at"`
+		Qty         *int       `json:"qty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Status = strings.ToLower(strings.TrimSpace(in.Status))
+	switch in.Status {
+	case "", subscriptionActive, subscriptionPaused, subscriptionCanceled:
+	default:
+		render.Error(w, http.StatusBadRequest, "invalid status (use active, paused or canceled)")
+		return
+	}
+	if in.Qty != nil && *in.Qty <= 0 {
+		render.Error(w, http.StatusBadRequest, "qty must be positive")
+		return
+	}
+	// cancelada é definitiva; churned pode ser reativada
+	s, err := scanSubscription(a.DB.QueryRow(r.Context(), `
+UPDATE public.subscriptions s SET
+  status           = COALESCE(NULLIF($4,''), s.status),
+  next_order_at    = COALESCE($5, s.next_order_at),
+  qty              = COALESCE($6, s.qty),
+  reminder_sent_at = CASE WHEN $5::timestamptz IS NULL THEN s.reminder_sent_at END,
+  ended_at         = CASE WHEN $4 = 'canceled' THEN NOW() WHEN $4 = 'active' THEN NULL ELSE s.ended_at END,
+  end_reason       = CASE WHEN $4 = 'canceled' THEN 'canceled' WHEN $4 = 'active' THEN NULL ELSE s.end_reason END,
+  updated_at       = NOW()
+  FROM (SELECT sub.id, pr.title FROM public.subscriptions sub LEFT JOIN products pr ON pr.id = sub.product_id
+         WHERE sub.id=$1 AND sub.org_id=$2 AND sub.flow_id=$3 AND sub.status <> 'canceled') p
+ WHERE s.id = p.id
+RETURNING `+subscriptionColumns, id, orgID, flowID, in.Status, in.NextOrderAt, in.Qty))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "subscription not found or canceled")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// startSubscriptionsFromOrder cria uma assinatura por item recorrente do
+// pedido pago. Pedidos de renovação (subscription_id preenchido) não geram
+// assinatura nova.
+func (a *App) startSubscriptionsFromOrder(ctx context.Context, orderID int64) error {
+	_, err := a.DB.Exec(ctx, `
+INSERT INTO public.subscriptions (org_id, flow_id, lead_id, product_id, qty, unit_price_cents, recurrence, next_order_at,
+                                  last_order_id, source_order_id, payment_method, shipping_address)
+SELECT o.org_id, o.flow_id, o.lead_id, oi.product_id, oi.qty, oi.unit_price_cents, p.recurrence,
+       NOW() + CASE p.recurrence WHEN 'weekly' THEN INTERVAL '1 week' ELSE INTERVAL '1 month' END,
+       o.id, o.id, o.payment_method, o.shipping_address
+  FROM orders o
+  JOIN order_items oi ON oi.order_id = o.id
+  JOIN products p ON p.id = oi.product_id AND p.org_id = o.org_id
+ WHERE o.id=$1 AND o.subscription_id IS NULL AND COALESCE(o.lead_id, 0) > 0 AND p.recurrence IS NOT NULL
+ON CONFLICT (source_order_id, product_id) WHERE source_order_id IS NOT NULL DO NOTHING`, orderID)
+	return err
+}
+
+// ---------------- worker ----------------
+
+func (a *App) subscriptionLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		a.churnUnpaidSubscriptions()
+		a.sendSubscriptionReminders()
+		a.renewSubscriptions()
+	}
+}
+
+// churnUnpaidSubscriptions encerra as assinaturas cuja renovação ficou sem
+// pagamento além da carência.
+func (a *App) churnUnpaidSubscriptions() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tag, err := a.DB.Exec(ctx, `
+UPDATE public.subscriptions s
+   SET status='churned', ended_at=NOW(), end_reason='renewal not paid', updated_at=NOW()
+  FROM orders o
+ WHERE o.id = s.last_order_id AND o.subscription_id = s.id
+   AND s.status = 'active' AND o.status IN ('pending','canceled') AND o.created_at < $1`,
+		time.Now().Add(-subscriptionGrace()))
+	if err != nil {
+		log.Printf("subscriptions churn: %v", err)
+		return
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("subscriptions churn: %d churned", n)
+	}
+}
+
+// sendSubscriptionReminders avisa da próxima renovação. Como nos lembretes
+// da agenda, a marcação vem antes do envio.
+func (a *App) sendSubscriptionReminders() {
+	before := subscriptionReminderBefore()
+	if before <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.subscriptions s SET reminder_sent_at = NOW()
+  FROM (SELECT sub.id, pr.title FROM public.subscriptions sub LEFT JOIN products pr ON pr.id = sub.product_id
+         WHERE sub.status='active' AND sub.reminder_sent_at IS NULL
+           AND sub.next_order_at > NOW() AND sub.next_order_at <= $1
+         ORDER BY sub.next_order_at LIMIT 100 FOR UPDATE OF sub SKIP LOCKED) p
+ WHERE s.id = p.id
+RETURNING `+subscriptionColumns, time.Now().Add(before))
+	if err != nil {
+		log.Printf("subscription reminders: %v", err)
+		return
+	}
+	var due []subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			log.Printf("subscription reminders: %v", err)
+			continue
+		}
+		due = append(due, s)
+	}
+	rows.Close()
+
+	loc := appointmentLoc()
+	for _, s := range due {
+		text := fmt.Sprintf("Sua assinatura %s de %s renova em %s: %dx, total R$ %.2f. "+
+			"Se quiser pausar, mudar a quantidade ou cancelar, é só responder esta mensagem.",
+			recurrenceLabel(s.Recurrence), nonEmpty(s.ProductTitle, "produto"), s.NextOrderAt.In(loc).Format("02/01"),
+			s.Qty, float64(s.Qty*s.UnitPriceCents)/100)
+		if err := a.sendSubscriptionText(ctx, s, text); err != nil {
+			log.Printf("subscription %d reminder: %v", s.ID, err)
+		}
+	}
+}
+
+// renewSubscriptions gera os pedidos vencidos, um por transação.
+func (a *App) renewSubscriptions() {
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		s, order, err := a.renewNextSubscription(ctx)
+		if err != nil {
+			log.Printf("subscription renewal: %v", err)
+		}
+		if order != nil {
+			text := fmt.Sprintf("Geramos o pedido #%d da sua assinatura %s de %s: %dx, total R$ %.2f.",
+				order.ID, recurrenceLabel(s.Recurrence), nonEmpty(s.ProductTitle, "produto"), s.Qty,
+				float64(order.TotalCents)/100)
+			if s.paymentMethod != "" {
+				text += " Pagamento via " + s.paymentMethod + "."
+			}
+			// com conta de pagamento configurada a cobrança já vai junto (payments.go)
+			if l, _, err := a.createPaymentLink(ctx, *order, ""); err == nil {
+				text += "\n" + l.customerText()
+			} else if !errors.Is(err, errNoPaymentAccount) {
+				log.Printf("subscription %d payment link: %v", s.ID, err)
+			}
+			if err := a.sendSubscriptionText(ctx, s, text); err != nil {
+				log.Printf("subscription %d renewal notice: %v", s.ID, err)
+			}
+		}
+		cancel()
+		if order == nil {
+			return
+		}
+	}
+}
+
+// renewNextSubscription reserva uma assinatura vencida cujo último pedido foi
+// pago, grava o pedido de renovação e avança o ciclo. Devolve order nil
+// quando não há nada a renovar.
+func (a *App) renewNextSubscription(ctx context.Context) (subscription, *Order, error) {
+	tx, err := a.DB.Begin(ctx)
+	if err != nil {
+		return subscription{}, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	s, err := scanSubscription(tx.QueryRow(ctx, `
+SELECT `+subscriptionColumns+subscriptionFrom+`
+  LEFT JOIN orders lo ON lo.id = s.last_order_id
+ WHERE s.status='active' AND s.next_order_at <= NOW()
+   AND (lo.id IS NULL OR lo.status IN ('paid','shipped','delivered'))
+ ORDER BY s.next_order_at LIMIT 1
+   FOR UPDATE OF s SKIP LOCKED`))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, nil, nil
+	}
+	if err != nil {
+		return s, nil, err
+	}
+
+	o := &Order{OrgID: s.OrgID, FlowID: s.FlowID, LeadID: s.LeadID, TotalCents: s.Qty * s.UnitPriceCents, Status: "pending"}
+	err = tx.QueryRow(ctx, `
+INSERT INTO orders (org_id, flow_id, lead_id, total_cents, status, shipping_address, payment_method, source, subscription_id)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6,''), NULLIF($7,''), 'subscription', $8) RETURNING id, created_at`,
+		s.OrgID, s.FlowID, s.LeadID, o.TotalCents, o.Status, s.address, s.paymentMethod, s.ID).Scan(&o.ID, &o.CreatedAt)
+	if err != nil {
+		return s, nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+INSERT INTO order_items (org_id, flow_id, order_id, product_id, qty, unit_price_cents) VALUES ($1, $2, $3, $4, $5, $6)`,
+		s.OrgID, s.FlowID, o.ID, s.ProductID, s.Qty, s.UnitPriceCents); err != nil {
+		return s, nil, err
+	}
+	// renovação já contratada: baixa sem recusar por falta (order_stock.go)
+	if err := reserveOrderStockTx(ctx, tx, s.OrgID, o.ID, false); err != nil {
+		return s, nil, err
+	}
+	// o próximo ciclo conta da data prevista, não da hora em que o worker passou
+	if _, err := tx.Exec(ctx, `
+UPDATE public.subscriptions s
+   SET last_order_id=$2, renewals=renewals+1, reminder_sent_at=NULL, updated_at=NOW(),
+       next_order_at = GREATEST(s.next_order_at + `+recurrenceSQL+`, NOW())
+ WHERE s.id=$1`, s.ID, o.ID); err != nil {
+		return s, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return s, nil, err
+	}
+	a.publishEvent(ctx, o.OrgID, eventOrderCreated, *o)
+	return s, o, nil
+}
+
+func (a *App) sendSubscriptionText(ctx context.Context, s subscription, text string) error {
+	row, contact, err := a.leadWAContact(ctx, s.OrgID, s.FlowID, s.LeadID)
+	if err != nil {
+		return err
+	}
+	_, _, err = a.sendWAText(ctx, row, row.Token, contact, text)
+	return err
+}
+
+// ---------------- analytics ----------------
+
+// GET /api/analytics/subscriptions?from=&to=&tz=
+//
+// Retrato atual (ativas, pausadas, MRR) e movimento no período: novas,
+// canceladas e churned, com as churned mais recentes. MRR conta a semanal
+// como 52/12 ciclos por mês.
+func (a *App) analyticsSubscriptions(w http.ResponseWriter, r *http.Request) {
+	orgID, flowID, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rg, err := parseAnalyticsRange(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx := r.Context()
+
+	var active, paused, started, canceled, churned, activeAtStart int64
+	var mrr float64
+	err = a.DB.QueryRow(ctx, `
+SELECT COUNT(*) FILTER (WHERE status='active'),
+       COUNT(*) FILTER (WHERE status='paused'),
+       COALESCE(SUM(qty * unit_price_cents * CASE recurrence WHEN 'weekly' THEN 52.0/12 ELSE 1 END)
+                FILTER (WHERE status='active'), 0)::float8,
+       COUNT(*) FILTER (WHERE ($3::timestamptz IS NULL OR started_at >= $3) AND ($4::timestamptz IS NULL OR started_at < $4)),
+       COUNT(*) FILTER (WHERE status='canceled' AND ($3::timestamptz IS NULL OR ended_at >= $3) AND ($4::timestamptz IS NULL OR ended_at < $4)),
+       COUNT(*) FILTER (WHERE status='churned' AND ($3::timestamptz IS NULL OR ended_at >= $3) AND ($4::timestamptz IS NULL OR ended_at < $4)),
+       COUNT(*) FILTER (WHERE $3::timestamptz IS NOT NULL AND started_at < $3 AND (ended_at IS NULL OR ended_at >= $3))
+  FROM public.subscriptions
+ WHERE org_id=$1 AND flow_id=$2`, orgID, flowID, rg.From, rg.To).
+		Scan(&active, &paused, &mrr, &started, &canceled, &churned, &activeAtStart)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// churn do período sobre a base no início (ou, sem início, sobre todas
+	// que já existiram)
+	base := activeAtStart + started
+	var churnRate float64
+	if base > 0 {
+		churnRate = float64(churned+canceled) / float64(base)
+	}
+
+	rows, err := a.DB.Query(ctx, `
+SELECT `+subscriptionColumns+subscriptionFrom+`
+ WHERE s.org_id=$1 AND s.flow_id=$2 AND s.status='churned'
+   AND ($3::timestamptz IS NULL OR s.ended_at >= $3) AND ($4::timestamptz IS NULL OR s.ended_at < $4)
+ ORDER BY s.ended_at DESC LIMIT 50`, orgID, flowID, rg.From, rg.To)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	recent := []subscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		recent = append(recent, s)
+	}
+
+	render.OK(w, map[string]any{
+		"active":         active,
+		"paused":         paused,
+		"mrr_cents":      int64(mrr + 0.5),
+		"started":        started,
+		"canceled":       canceled,
+		"churned":        churned,
+		"churn_rate":     churnRate,
+		"recent_churned": recent,
+		"range":          rg.meta(),
+	})
+}
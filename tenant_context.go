@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Tenant da requisição
+// ================================================================
+//
+// resolveTenant descobre org/flow/usuário uma única vez por requisição e
+// guarda o resultado no contexto; os handlers leem com tenantOf/tenantFrom
+// em vez de olhar headers por conta própria. Ordem de resolução:
+//
+//   1. Authorization: Bearer <JWT>  — se enviado, precisa ser válido; headers
+//      X-Org-ID/X-Flow-ID, quando presentes, têm de bater com o token
+//   2. X-API-Key — chave da org, limitada aos escopos dela (api_keys.go);
+//      vale também no grupo autenticado
+//   3. X-Org-ID/X-Flow-ID (ou ?org_id=/?flow_id=), só se a política da rota
+//      permitir
+//
+// Políticas:
+//   tenantRequireJWT     grupo autenticado (requireAuth)
+//   tenantAllowHeaders   rotas legadas (chat, upload, /wa): JWT ou headers;
+//                        sem nenhum dos dois o tenant fica vazio (0/0)
+//   tenantDefaultOrg     /agent/settings: como acima, mas org/flow 1 por padrão
+
+type tenantPolicy int
+
+const (
+	tenantRequireJWT tenantPolicy = iota
+	tenantAllowHeaders
+	tenantDefaultOrg
+)
+
+const (
+	tenantSourceJWT     = "jwt"
+	tenantSourceAPIKey  = "api_key"
+	tenantSourceHeaders = "headers"
+	tenantSourceDefault = "default"
+)
+
+// tenantCtx é o tenant resolvido. UserID/Role só existem com JWT (Role é
+// preenchido por requireRole, rbac.go); APIKeyID/Scopes só com X-API-Key.
+type tenantCtx struct {
+	OrgID    int64
+	FlowID   int64
+	UserID   int64
+	Role     string
+	Source   string
+	APIKeyID int64
+	Scopes   []string
+}
+
+type tenantCtxKey struct{}
+
+func withTenantCtx(ctx context.Context, t tenantCtx) context.Context {
+	setMetricsOrg(ctx, t.OrgID)
+	return context.WithValue(ctx, tenantCtxKey{}, t)
+}
+
+// tenantFrom devolve o tenant resolvido por resolveTenant, se houver.
+func tenantFrom(ctx context.Context) (tenantCtx, bool) {
+	t, ok := ctx.Value(tenantCtxKey{}).(tenantCtx)
+	return t, ok
+}
+
+var errTenantRequired = errors.New("X-Org-ID and X-Flow-ID required")
+
+// tenantOf devolve org/flow da requisição; erro quando a rota não resolveu
+// um tenant (sem JWT e sem headers).
+func tenantOf(r *http.Request) (int64, int64, error) {
+	t, ok := tenantFrom(r.Context())
+	if !ok || t.OrgID <= 0 || t.FlowID <= 0 {
+		return 0, 0, errTenantRequired
+	}
+	return t.OrgID, t.FlowID, nil
+}
+
+// resolveTenant é o middleware descrito no topo do arquivo. Se um
+// middleware anterior já resolveu o tenant pelo JWT, não repete o trabalho.
+func (a *App) resolveTenant(policy tenantPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := tenantFrom(r.Context()); ok && t.Source == tenantSourceJWT {
+				next.ServeHTTP(w, r)
+				return
+			}
+			t, status, err := a.lookupTenant(r, policy)
+			if err != nil {
+				render.Error(w, status, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withTenantCtx(r.Context(), t)))
+		})
+	}
+}
+
+func (a *App) lookupTenant(r *http.Request, policy tenantPolicy) (tenantCtx, int, error) {
+	jwtRequired := policy == tenantRequireJWT && headerTrim(r, "X-API-Key") == ""
+	if r.Header.Get("Authorization") != "" || jwtRequired {
+		uid, org, flow, err := extractUserFromToken(r)
+		if err != nil {
+			return tenantCtx{}, http.StatusUnauthorized, errors.New("invalid token")
+		}
+		if h := headerTrim(r, "X-Org-ID"); h != "" && h != strconv.FormatInt(org, 10) {
+			return tenantCtx{}, http.StatusForbidden, errors.New("X-Org-ID does not match token")
+		}
+		if h := headerTrim(r, "X-Flow-ID"); h != "" && h != strconv.FormatInt(flow, 10) {
+			return tenantCtx{}, http.StatusForbidden, errors.New("X-Flow-ID does not match token")
+		}
+		return tenantCtx{OrgID: org, FlowID: flow, UserID: uid, Source: tenantSourceJWT}, 0, nil
+	}
+	if t, ok, err := a.apiKeyTenant(r); errors.Is(err, errAPIKeyForbidden) {
+		return tenantCtx{}, http.StatusForbidden, err
+	} else if err != nil {
+		return tenantCtx{}, http.StatusUnauthorized, err
+	} else if ok {
+		return t, 0, nil
+	}
+
+	org, err := tenantParam(r, "X-Org-ID", "org_id")
+	if err != nil {
+		return tenantCtx{}, http.StatusBadRequest, err
+	}
+	flow, err := tenantParam(r, "X-Flow-ID", "flow_id")
+	if err != nil {
+		return tenantCtx{}, http.StatusBadRequest, err
+	}
+	t := tenantCtx{OrgID: org, FlowID: flow}
+	if org > 0 && flow > 0 {
+		t.Source = tenantSourceHeaders
+	}
+	if policy == tenantDefaultOrg {
+		if t.OrgID <= 0 {
+			t.OrgID, t.Source = 1, tenantSourceDefault
+		}
+		if t.FlowID <= 0 {
+			t.FlowID, t.Source = 1, tenantSourceDefault
+		}
+	}
+	return t, 0, nil
+}
+
+// tenantParam lê o header (ou, na falta dele, a querystring — o WebSocket do
+// chat não envia headers). Ausente vale 0; presente e inválido é erro.
+func tenantParam(r *http.Request, header, query string) (int64, error) {
+	v := firstNonEmpty(headerTrim(r, header), strings.TrimSpace(r.URL.Query().Get(query)))
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid " + header)
+	}
+	return n, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Saúde das orgs (risco de churn) para o operador
+// ================================================================
+//
+// GET /api/admin/orgs/health?risk=high|medium|low&days=30&limit=
+// GET /api/admin/orgs/{org_id}/health?days=30
+//
+// Nota de 0 a 100 calculada na hora, comparando a janela atual (days,
+// padrão TENANT_HEALTH_DAYS=30) com a anterior de mesmo tamanho:
+//
+//   atividade  25  dias desde a última mensagem, lead ou pedido
+//   instâncias 20  WhatsApp conectado (10 se existe mas caiu)
+//   mensagens  25  volume (até 15) + tendência contra a janela anterior (10)
+//   vendas     30  pedidos pagos (até 15) + tendência da receita (15)
+//
+// risk: high abaixo de 40, medium abaixo de 70, low no resto. "reasons" traz
+// os sinais que pesaram, para o time de sucesso saber o que abordar. A lista
+// vem do maior risco para o menor.
+
+const (
+	tenantRiskHigh   = "high"
+	tenantRiskMedium = "medium"
+	tenantRiskLow    = "low"
+)
+
+func tenantHealthDays() int {
+	if n, err := strconv.Atoi(getenv("TENANT_HEALTH_DAYS", "30")); err == nil && n > 0 {
+		return n
+	}
+	return 30
+}
+
+type tenantHealthMetrics struct {
+	Instances          int        `json:"instances"`
+	ConnectedInstances int        `json:"connected_instances"`
+	Users              int        `json:"users"`
+	Messages           int64      `json:"messages"`
+	PrevMessages       int64      `json:"prev_messages"`
+	NewLeads           int64      `json:"new_leads"`
+	Orders             int64      `json:"orders"`
+	PrevOrders         int64      `json:"prev_orders"`
+	RevenueCents       int64      `json:"revenue_cents"`
+	PrevRevenueCents   int64      `json:"prev_revenue_cents"`
+	LastActivityAt     *time.Time `json:"last_activity_at,omitempty"`
+}
+
+type tenantHealth struct {
+	OrgID      int64               `json:"org_id"`
+	Name       string              `json:"name"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Score      int                 `json:"score"`
+	Risk       string              `json:"risk"`
+	Components map[string]int      `json:"components"`
+	Reasons    []string            `json:"reasons"`
+	Metrics    tenantHealthMetrics `json:"metrics"`
+}
+
+// trendPoints dá a nota da tendência: cheia se manteve ou cresceu, zero se
+// caiu pela metade ou mais. Sem base anterior, metade da nota se houve algo.
+func trendPoints(cur, prev int64, max float64) float64 {
+	if prev <= 0 {
+		if cur > 0 {
+			return max / 2
+		}
+		return 0
+	}
+	ratio := float64(cur) / float64(prev)
+	return max * math.Min(1, math.Max(0, (ratio-0.5)/0.5))
+}
+
+// volumePoints cresce até max quando n chega em full.
+func volumePoints(n int64, full, max float64) float64 {
+	return max * math.Min(1, float64(n)/full)
+}
+
+// score preenche nota, risco, componentes e motivos a partir das métricas.
+func (h *tenantHealth) score(now time.Time, days int) {
+	m := h.Metrics
+	h.Components = map[string]int{}
+	h.Reasons = []string{}
+
+	idle := float64(days)
+	if m.LastActivityAt != nil {
+		idle = math.Max(0, now.Sub(*m.LastActivityAt).Hours()/24)
+	}
+	activity := 25 * math.Max(0, 1-idle/float64(days))
+	switch {
+	case m.LastActivityAt == nil:
+		h.Reasons = append(h.Reasons, "never_active")
+	case idle >= 7:
+		h.Reasons = append(h.Reasons, "inactive_"+strconv.Itoa(int(idle))+"d")
+	}
+
+	instances := 0.0
+	switch {
+	case m.ConnectedInstances > 0:
+		instances = 20
+	case m.Instances > 0:
+		instances = 10
+		h.Reasons = append(h.Reasons, "whatsapp_disconnected")
+	default:
+		h.Reasons = append(h.Reasons, "no_whatsapp_instance")
+	}
+
+	// 1 mensagem por dia já é uso real
+	messages := volumePoints(m.Messages, float64(days), 15) + trendPoints(m.Messages, m.PrevMessages, 10)
+	if m.Messages == 0 {
+		h.Reasons = append(h.Reasons, "no_messages")
+	} else if m.PrevMessages > 0 && m.Messages*2 <= m.PrevMessages {
+		h.Reasons = append(h.Reasons, "messages_dropping")
+	}
+
+	sales := volumePoints(m.Orders, float64(days)/3, 15) + trendPoints(m.RevenueCents, m.PrevRevenueCents, 15)
+	if m.Orders == 0 {
+		h.Reasons = append(h.Reasons, "no_sales")
+	} else if m.PrevRevenueCents > 0 && m.RevenueCents*2 <= m.PrevRevenueCents {
+		h.Reasons = append(h.Reasons, "revenue_dropping")
+	}
+
+	h.Components["activity"] = int(math.Round(activity))
+	h.Components["instances"] = int(instances)
+	h.Components["messages"] = int(math.Round(messages))
+	h.Components["sales"] = int(math.Round(sales))
+	h.Score = int(math.Round(activity + instances + messages + sales))
+	switch {
+	case h.Score < 40:
+		h.Risk = tenantRiskHigh
+	case h.Score < 70:
+		h.Risk = tenantRiskMedium
+	default:
+		h.Risk = tenantRiskLow
+	}
+}
+
+// loadTenantHealth mede todas as orgs (orgID = 0) ou uma só.
+func (a *App) loadTenantHealth(ctx context.Context, orgID int64, days int, now time.Time) ([]tenantHealth, error) {
+	start := now.AddDate(0, 0, -days)
+	prev := start.AddDate(0, 0, -days)
+	rows, err := a.DB.Query(ctx, `
+SELECT o.id, o.name, o.created_at,
+       (SELECT COUNT(*) FROM public.wa_instances i WHERE i.org_id=o.id AND i.deleted_at IS NULL),
+       (SELECT COUNT(*) FROM public.wa_instances i WHERE i.org_id=o.id AND i.deleted_at IS NULL AND i.state='connected'),
+       (SELECT COUNT(*) FROM public.users u WHERE u.org_id=o.id),
+       m.cur, m.prev, m.last_at,
+       l.cur, l.last_at,
+       s.cur, s.prev, s.cur_cents, s.prev_cents, s.last_at
+  FROM public.orgs o
+  LEFT JOIN LATERAL (
+       SELECT COUNT(*) FILTER (WHERE created_at >= $2) AS cur,
+              COUNT(*) FILTER (WHERE created_at < $2)  AS prev,
+              MAX(created_at) AS last_at
+         FROM public.wa_messages WHERE org_id=o.id AND created_at >= $3) m ON TRUE
+  LEFT JOIN LATERAL (
+       SELECT COUNT(*) FILTER (WHERE created_at >= $2) AS cur, MAX(created_at) AS last_at
+         FROM public.leads WHERE org_id=o.id AND created_at >= $3) l ON TRUE
+  LEFT JOIN LATERAL (
+       SELECT COUNT(*) FILTER (WHERE created_at >= $2 AND `+digestSoldSQL+`) AS cur,
+              COUNT(*) FILTER (WHERE created_at < $2 AND `+digestSoldSQL+`)  AS prev,
+              COALESCE(SUM(total_cents) FILTER (WHERE created_at >= $2 AND `+digestSoldSQL+`), 0) AS cur_cents,
+              COALESCE(SUM(total_cents) FILTER (WHERE created_at < $2 AND `+digestSoldSQL+`), 0)  AS prev_cents,
+              MAX(created_at) AS last_at
+         FROM public.orders WHERE org_id=o.id AND created_at >= $3) s ON TRUE
+ WHERE ($1 = 0 OR o.id = $1)
+ ORDER BY o.id`, orgID, start, prev)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []tenantHealth{}
+	for rows.Next() {
+		var (
+			h                   tenantHealth
+			msgAt, leadAt, orAt *time.Time
+		)
+		m := &h.Metrics
+		if err := rows.Scan(&h.OrgID, &h.Name, &h.CreatedAt, &m.Instances, &m.ConnectedInstances, &m.Users,
+			&m.Messages, &m.PrevMessages, &msgAt, &m.NewLeads, &leadAt,
+			&m.Orders, &m.PrevOrders, &m.RevenueCents, &m.PrevRevenueCents, &orAt); err != nil {
+			return nil, err
+		}
+		for _, t := range []*time.Time{msgAt, leadAt, orAt} {
+			if t != nil && (m.LastActivityAt == nil || t.After(*m.LastActivityAt)) {
+				m.LastActivityAt = t
+			}
+		}
+		h.score(now, days)
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+func healthDaysParam(r *http.Request) int {
+	if n := mustAtoi(r.URL.Query().Get("days")); n > 0 && n <= 365 {
+		return n
+	}
+	return tenantHealthDays()
+}
+
+// GET /api/admin/orgs/health?risk=&days=&limit=
+func (a *App) adminTenantHealth(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	risk := strings.ToLower(strings.TrimSpace(q.Get("risk")))
+	switch risk {
+	case "", tenantRiskHigh, tenantRiskMedium, tenantRiskLow:
+	default:
+		render.Error(w, http.StatusBadRequest, "invalid risk (use high, medium or low)")
+		return
+	}
+	limit := mustAtoi(q.Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	days := healthDaysParam(r)
+	all, err := a.loadTenantHealth(r.Context(), 0, days, time.Now())
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	counts := map[string]int{tenantRiskHigh: 0, tenantRiskMedium: 0, tenantRiskLow: 0}
+	out := []tenantHealth{}
+	for _, h := range all {
+		counts[h.Risk]++
+		if risk == "" || h.Risk == risk {
+			out = append(out, h)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score < out[j].Score })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	render.OK(w, map[string]any{"items": out, "counts": counts, "days": days})
+}
+
+// GET /api/admin/orgs/{org_id}/health?days=
+func (a *App) adminOrgHealth(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "org_id"), 10, 64)
+	if err != nil || orgID <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid org_id")
+		return
+	}
+	days := healthDaysParam(r)
+	found, err := a.loadTenantHealth(r.Context(), orgID, days, time.Now())
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(found) == 0 {
+		render.Error(w, http.StatusNotFound, "org not found")
+		return
+	}
+	render.OK(w, map[string]any{"health": found[0], "days": days})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Estimativa de custos por org/mês (GET /api/usage/costs)
+// ================================================================
+//
+// WhatsApp: conversas cobradas pela Meta (wa_billable_conversations, vindas
+// dos status da API oficial) × preço da categoria. Instâncias uazapi não têm
+// cobrança por conversa e não entram aqui.
+// IA: tokens medidos em ai_usage (ai_usage.go). Meses anteriores à medição
+// usam os turnos do chat (chat_messages) convertidos em tokens aproximados
+// (~4 caracteres por token na resposta + USAGE_PROMPT_TOKENS_PER_TURN de
+// prompt, padrão 1200: system prompt, catálogo e histórico) × ai_cost.go.
+//
+// Valores são estimativas em USD; a fatura real vem da Meta e da OpenAI.
+
+// Preço por conversa (USD, tabela Brasil). Sobrescreva com
+// META_PRICE_MARKETING, META_PRICE_UTILITY, META_PRICE_AUTHENTICATION e
+// META_PRICE_SERVICE quando a Meta reajustar.
+var metaConversationPricing = map[string]float64{
+	"marketing":      0.0625,
+	"utility":        0.0080,
+	"authentication": 0.0315,
+	"service":        0, // gratuitas desde nov/2024
+}
+
+func metaConversationPrice(category string) float64 {
+	category = strings.ToLower(category)
+	if v, err := strconv.ParseFloat(os.Getenv("META_PRICE_"+strings.ToUpper(category)), 64); err == nil && v >= 0 {
+		return v
+	}
+	if p, ok := metaConversationPricing[category]; ok {
+		return p
+	}
+	return metaConversationPricing["marketing"]
+}
+
+// recordBillableConversation grava a conversa na primeira vez que aparece
+// num status (os demais status da mesma conversa são ignorados).
+func (app *App) recordBillableConversation(ctx context.Context, instance string, st waConversationStatus) {
+	_, err := app.DB.Exec(ctx, `
+INSERT INTO public.wa_billable_conversations (conversation_id, org_id, flow_id, instance_id, contact, category, billable, opened_at)
+SELECT $2, org_id, flow_id, instance_id, NULLIF($3,''), $4, $5, $6 FROM public.wa_instances WHERE instance_id=$1
+ON CONFLICT (conversation_id) DO NOTHING`,
+		instance, st.ConversationID, st.Contact, st.Category, st.Billable, st.At)
+	if err != nil {
+		log.Printf("wa billable conversation %s: %v", instance, err)
+	}
+}
+
+func (a *App) mountUsage(r chi.Router) {
+	r.Get("/usage/costs", a.usageCosts)
+}
+
+type usageLine struct {
+	Count   int64   `json:"count"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+type aiUsageLine struct {
+	Turns            int64   `json:"turns"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+type usageMonth struct {
+	Month    string `json:"month"`
+	WhatsApp struct {
+		Conversations map[string]*usageLine `json:"conversations"`
+		NonBillable   int64                 `json:"non_billable"`
+		TotalUSD      float64               `json:"total_usd"`
+	} `json:"whatsapp"`
+	AI struct {
+		Source   string                  `json:"source"` // measured | estimated
+		Models   map[string]*aiUsageLine `json:"models"`
+		TotalUSD float64                 `json:"total_usd"`
+	} `json:"ai"`
+	TotalUSD float64 `json:"total_usd"`
+}
+
+// GET /api/usage/costs?months=3  (mês corrente e os anteriores, máx. 12)
+func (a *App) usageCosts(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	n, _ := strconv.Atoi(r.URL.Query().Get("months"))
+	if n <= 0 {
+		n = 1
+	}
+	if n > 12 {
+		n = 12
+	}
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -n, 0)
+
+	months := map[string]*usageMonth{}
+	var order []string
+	for m := from; m.Before(to); m = m.AddDate(0, 1, 0) {
+		key := m.Format("2006-01")
+		um := &usageMonth{Month: key}
+		um.WhatsApp.Conversations = map[string]*usageLine{}
+		um.AI.Models = map[string]*aiUsageLine{}
+		months[key] = um
+		order = append(order, key)
+	}
+
+	ctx := r.Context()
+	rows, err := a.DB.Query(ctx, `
+SELECT to_char(opened_at AT TIME ZONE 'UTC', 'YYYY-MM'), category, billable, COUNT(*)
+  FROM public.wa_billable_conversations
+ WHERE org_id=$1 AND opened_at >= $2 AND opened_at < $3
+ GROUP BY 1, 2, 3`, orgID, from, to)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for rows.Next() {
+		var month, category string
+		var billable bool
+		var count int64
+		if err := rows.Scan(&month, &category, &billable, &count); err != nil {
+			rows.Close()
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		um, ok := months[month]
+		if !ok {
+			continue
+		}
+		if !billable {
+			um.WhatsApp.NonBillable += count
+			continue
+		}
+		line := um.WhatsApp.Conversations[category]
+		if line == nil {
+			line = &usageLine{}
+			um.WhatsApp.Conversations[category] = line
+		}
+		line.Count += count
+		line.CostUSD += float64(count) * metaConversationPrice(category)
+	}
+	rows.Close()
+
+	rows, err = a.DB.Query(ctx, `
+SELECT to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM'), model, COUNT(*), SUM(prompt_tokens), SUM(completion_tokens)
+  FROM public.ai_usage
+ WHERE org_id=$1 AND created_at >= $2 AND created_at < $3
+ GROUP BY 1, 2`, orgID, from, to)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for rows.Next() {
+		var month, model string
+		var l aiUsageLine
+		if err := rows.Scan(&month, &model, &l.Turns, &l.PromptTokens, &l.CompletionTokens); err != nil {
+			rows.Close()
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if um, ok := months[month]; ok {
+			um.AI.Source = "measured"
+			um.AI.Models[model] = &l
+		}
+	}
+	rows.Close()
+
+	promptPerTurn, err := strconv.ParseInt(getenv("USAGE_PROMPT_TOKENS_PER_TURN", "1200"), 10, 64)
+	if err != nil || promptPerTurn < 0 {
+		promptPerTurn = 1200
+	}
+	defaultModel := getenv("TEXT_MODEL", "gpt-4o-mini")
+	rows, err = a.DB.Query(ctx, `
+SELECT to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM'), COALESCE(model,''), COUNT(*), COALESCE(SUM(length(content)),0)
+  FROM public.chat_messages
+ WHERE org_id=$1 AND role='assistant' AND created_at >= $2 AND created_at < $3
+ GROUP BY 1, 2`, orgID, from, to)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for rows.Next() {
+		var month, model string
+		var turns, chars int64
+		if err := rows.Scan(&month, &model, &turns, &chars); err != nil {
+			rows.Close()
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		um, ok := months[month]
+		if !ok || um.AI.Source == "measured" {
+			continue
+		}
+		um.AI.Source = "estimated"
+		model = chooseFirstNonEmpty(model, defaultModel)
+		line := um.AI.Models[model]
+		if line == nil {
+			line = &aiUsageLine{}
+			um.AI.Models[model] = line
+		}
+		line.Turns += turns
+		line.PromptTokens += turns * promptPerTurn
+		line.CompletionTokens += (chars + 3) / 4
+	}
+	rows.Close()
+
+	out := make([]*usageMonth, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		um := months[order[i]]
+		for _, l := range um.WhatsApp.Conversations {
+			l.CostUSD = roundUSD(l.CostUSD)
+			um.WhatsApp.TotalUSD += l.CostUSD
+		}
+		for model, l := range um.AI.Models {
+			l.CostUSD = roundUSD(estimateCostUSD(model, int(l.PromptTokens), int(l.CompletionTokens)))
+			um.AI.TotalUSD += l.CostUSD
+		}
+		um.WhatsApp.TotalUSD = roundUSD(um.WhatsApp.TotalUSD)
+		um.AI.TotalUSD = roundUSD(um.AI.TotalUSD)
+		um.TotalUSD = roundUSD(um.WhatsApp.TotalUSD + um.AI.TotalUSD)
+		out = append(out, um)
+	}
+	render.OK(w, map[string]any{"org_id": orgID, "currency": "USD", "estimated": true, "months": out})
+}
+
+func roundUSD(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
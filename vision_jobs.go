@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Análise de visão assíncrona (job vision.analyze)
+// ================================================================
+//
+// Imagens grandes somadas à latência do modelo de visão estouram o timeout
+// dos proxies. Com async=true (campo do form ou query), Prefer:
+// respond-async ou VISION_ASYNC=true (padrão para todos), /api/vision/upload
+// grava as fotos, responde 202 com o id e a análise roda no worker (jobs.go).
+// O resultado é o mesmo corpo da resposta síncrona.
+//
+// GET /api/vision/jobs/{id}         status, progress, result/error
+// GET /api/vision/jobs/{id}/events  SSE: "status" a cada mudança, até done
+//                                   ou failed (o EventSource reconecta se a
+//                                   conexão cair antes); fora do timeout de
+//                                   60s (request_timeout.go)
+//
+// status: queued -> analyzing -> done | failed. O worker parte da variante
+// large da foto quando existe (image_resize.go) e a reduz para
+// VISION_IMAGE_MAX_PX antes de mandar ao modelo (vision_limits.go).
+// Tabela em migrations/0049_vision_jobs.sql.
+
+const (
+	jobVisionAnalyze = "vision.analyze"
+	visionJobPrefix  = "vj_"
+
+	visionJobQueued    = "queued"
+	visionJobAnalyzing = "analyzing"
+	visionJobDone      = "done"
+	visionJobFailed    = "failed"
+)
+
+var visionJobProgress = map[string]int{visionJobQueued: 10, visionJobAnalyzing: 50, visionJobDone: 100, visionJobFailed: 100}
+
+// visionAsync diz se o upload deve ser analisado em segundo plano.
+func visionAsync(r *http.Request) bool {
+	if v, err := strconv.ParseBool(r.FormValue("async")); err == nil {
+		return v
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Prefer")), "respond-async") {
+		return true
+	}
+	v, _ := strconv.ParseBool(getenv("VISION_ASYNC", "false"))
+	return v
+}
+
+// visionJobInput é o que o worker precisa para terminar o upload.
+type visionJobInput struct {
+	SessionID string         `json:"session_id"`
+	OrgID     int            `json:"org_id"` // org/flow da pendência
+	FlowID    int            `json:"flow_id"`
+	NameHint  string         `json:"name_hint,omitempty"`
+	Images    []pendingImage `json:"images"`
+	Scans     []scanResult   `json:"scans"`
+}
+
+type visionJob struct {
+	ID        string          `json:"id"`
+	Status    string          `json:"status"`
+	Progress  int             `json:"progress"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type visionJobPayload struct {
+	ID string `json:"id"`
+}
+
+// enqueueVisionJob grava o job e responde 202 com o id.
+func (a *App) enqueueVisionJob(w http.ResponseWriter, r *http.Request, sessionID string, orgID, flowID int, nameHint string, files []visionFile) {
+	t, _ := tenantFrom(r.Context())
+	in := visionJobInput{SessionID: sessionID, OrgID: orgID, FlowID: flowID, NameHint: nameHint}
+	for _, f := range files {
+		in.Images = append(in.Images, f.pendingImage)
+		in.Scans = append(in.Scans, f.scan)
+	}
+	vj := visionJob{ID: visionJobPrefix + secureToken(16), Status: visionJobQueued, Progress: visionJobProgress[visionJobQueued]}
+	err := a.DB.QueryRow(r.Context(), `
+INSERT INTO public.vision_jobs (id, org_id, flow_id, input) VALUES ($1, $2, $3, $4) RETURNING created_at, updated_at`,
+		vj.ID, t.OrgID, t.FlowID, in).Scan(&vj.CreatedAt, &vj.UpdatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := a.enqueueJob(r.Context(), t.OrgID, jobVisionAnalyze, visionJobPayload{ID: vj.ID}, time.Time{}); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/api/vision/jobs/"+vj.ID)
+	render.Accepted(w, map[string]any{
+		"ok":         true,
+		"job":        vj,
+		"status_url": "/api/vision/jobs/" + vj.ID,
+		"events_url": "/api/vision/jobs/" + vj.ID + "/events",
+	})
+}
+
+func (a *App) runVisionAnalyze(ctx context.Context, j job) error {
+	var p visionJobPayload
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	var (
+		orgID, flowID int64
+		status        string
+		in            visionJobInput
+	)
+	err := a.DB.QueryRow(ctx, `SELECT org_id, flow_id, status, input FROM public.vision_jobs WHERE id=$1`, p.ID).
+		Scan(&orgID, &flowID, &status, &in)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil // já limpo
+	}
+	if err != nil {
+		return err
+	}
+	if status == visionJobDone || status == visionJobFailed {
+		return nil
+	}
+	if len(in.Images) == 0 {
+		a.setVisionJob(p.ID, visionJobFailed, nil, "no images")
+		return permanentJobError(errors.New("vision job without images"))
+	}
+	a.setVisionJob(p.ID, visionJobAnalyzing, nil, "")
+	fail := func(err error) error {
+		var perm permanentErr
+		if errors.As(err, &perm) || j.Attempts >= j.MaxAttempts {
+			a.setVisionJob(p.ID, visionJobFailed, nil, err.Error())
+		}
+		return err
+	}
+
+	dataURLs := make([]string, 0, len(in.Images))
+	for i, img := range in.Images {
+		if i == visionPromptImages {
+			break
+		}
+		u, err := visionDataURL(ctx, firstNonEmpty(img.Sizes["large"], img.URL))
+		if err != nil {
+			return fail(err)
+		}
+		dataURLs = append(dataURLs, u)
+	}
+	sug, err := a.visionSuggest(ctx, orgID, flowID, dataURLs, in.NameHint)
+	if err != nil {
+		return fail(err)
+	}
+	out, err := a.finishVision(ctx, in.SessionID, in.OrgID, in.FlowID, in.Images, in.Scans, sug)
+	if err != nil {
+		return fail(err)
+	}
+	a.setVisionJob(p.ID, visionJobDone, out, "")
+	return nil
+}
+
+// visionDataURL baixa (ou lê do disco) a imagem gravada no upload.
+func visionDataURL(ctx context.Context, ref string) (string, error) {
+	src, err := openProductImage(ctx, ref) // image_resize.go
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	raw, err := io.ReadAll(io.LimitReader(src, imageResizeMaxSrc))
+	if err != nil {
+		return "", err
+	}
+	return visionImageDataURL(raw, ""), nil // vision_limits.go
+}
+
+func (a *App) setVisionJob(id, status string, result any, msg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.DB.Exec(ctx, `
+UPDATE public.vision_jobs SET status=$2, result=COALESCE($3, result), error=NULLIF($4,''), updated_at=NOW() WHERE id=$1`,
+		id, status, result, limitRunes(msg, 500)); err != nil {
+		log.Printf("vision job %s: %v", id, err)
+	}
+}
+
+// loadVisionJob lê o job do tenant; ok=false se não existe ou é de outro.
+func (a *App) loadVisionJob(ctx context.Context, id string, orgID, flowID int64) (visionJob, bool, error) {
+	vj := visionJob{ID: id}
+	err := a.DB.QueryRow(ctx, `
+SELECT status, result, COALESCE(error,''), created_at, updated_at
+  FROM public.vision_jobs WHERE id=$1 AND org_id=$2 AND flow_id=$3`, id, orgID, flowID).
+		Scan(&vj.Status, &vj.Result, &vj.Error, &vj.CreatedAt, &vj.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return vj, false, nil
+	}
+	vj.Progress = visionJobProgress[vj.Status]
+	return vj, err == nil, err
+}
+
+// GET /api/vision/jobs/{id}
+func (a *App) getVisionJob(w http.ResponseWriter, r *http.Request) {
+	t, _ := tenantFrom(r.Context())
+	vj, ok, err := a.loadVisionJob(r.Context(), chi.URLParam(r, "id"), t.OrgID, t.FlowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		render.Error(w, http.StatusNotFound, "vision job not found")
+		return
+	}
+	render.OK(w, vj)
+}
+
+// GET /api/vision/jobs/{id}/events
+func (a *App) visionJobEvents(w http.ResponseWriter, r *http.Request) {
+	t, _ := tenantFrom(r.Context())
+	id := chi.URLParam(r, "id")
+	vj, ok, err := a.loadVisionJob(r.Context(), id, t.OrgID, t.FlowID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		render.Error(w, http.StatusNotFound, "vision job not found")
+		return
+	}
+	// o ResponseController atravessa os wrappers dos middlewares (Unwrap)
+	flusher := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 2000\n\n")
+	if err := flusher.Flush(); err != nil {
+		return // writer sem flush: não há como fazer stream
+	}
+
+	// polling no banco: o worker pode estar em outra réplica
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	last := ""
+	for {
+		if vj.Status != last {
+			body, _ := json.Marshal(vj)
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", body)
+			flusher.Flush()
+			last = vj.Status
+		}
+		if vj.Status == visionJobDone || vj.Status == visionJobFailed {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+		if vj, ok, err = a.loadVisionJob(r.Context(), id, t.OrgID, t.FlowID); err != nil || !ok {
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+)
+
+// ================================================================
+//  Limites de upload e redução das imagens enviadas à visão
+// ================================================================
+//
+// Limites por implantação (em MB):
+//   UPLOAD_MAX_MB         corpo de /api/upload (padrão 10)
+//   VISION_MAX_UPLOAD_MB  corpo de /api/vision/upload, todas as fotos (padrão 20)
+//   IMAGE_MAX_FILE_MB     cada foto individual nos dois (padrão 10)
+// Acima do limite a resposta é 413.
+//
+// Antes de virar base64 na requisição de visão, a foto é reduzida para no
+// máximo VISION_IMAGE_MAX_PX (padrão 1024; 0 desliga) pixels no maior lado e
+// recodificada em JPEG com VISION_JPEG_QUALITY (padrão 85). O arquivo gravado
+// e as variantes (image_resize.go) não mudam; só o que vai ao modelo. Imagem
+// já pequena, ou que a biblioteca padrão não decodifica (webp), vai como está.
+
+func envMB(name string, def int64) int64 {
+	mb, err := strconv.ParseInt(getenv(name, strconv.FormatInt(def, 10)), 10, 64)
+	if err != nil || mb <= 0 {
+		mb = def
+	}
+	return mb << 20
+}
+
+func uploadMaxBytes() int64 { return envMB("UPLOAD_MAX_MB", 10) }
+
+func visionUploadMaxBytes() int64 { return envMB("VISION_MAX_UPLOAD_MB", 20) }
+
+func imageMaxFileBytes() int64 { return envMB("IMAGE_MAX_FILE_MB", 10) }
+
+func visionImageMaxPx() int {
+	n, err := strconv.Atoi(getenv("VISION_IMAGE_MAX_PX", "1024"))
+	if err != nil || n < 0 {
+		return 1024
+	}
+	if n > 0 && n < 64 {
+		return 64
+	}
+	return n
+}
+
+func visionJPEGQuality() int {
+	q, err := strconv.Atoi(getenv("VISION_JPEG_QUALITY", "85"))
+	if err != nil || q < 1 || q > 100 {
+		return 85
+	}
+	return q
+}
+
+// visionImageDataURL monta o data URL da foto para o modelo de visão,
+// reduzida quando passa de VISION_IMAGE_MAX_PX.
+func visionImageDataURL(raw []byte, mime string) string {
+	if mime == "" {
+		mime = http.DetectContentType(raw)
+	}
+	if maxPx := visionImageMaxPx(); maxPx > 0 {
+		if img, _, err := image.Decode(bytes.NewReader(raw)); err == nil {
+			b := img.Bounds()
+			if b.Dx() > maxPx || b.Dy() > maxPx {
+				var buf bytes.Buffer
+				if err := jpeg.Encode(&buf, resizeImage(img, maxPx), &jpeg.Options{Quality: visionJPEGQuality()}); err == nil {
+					raw, mime = buf.Bytes(), "image/jpeg"
+				}
+			}
+		}
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(raw)
+}
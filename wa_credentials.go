@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Conta própria da org na uazapi (revendas white-label)
+// ================================================================
+//
+// Por padrão todas as orgs usam a conta da plataforma (UAZAPI_BASE e
+// UAZAPI_TOKEN). Uma org pode cadastrar a própria base e o admin token;
+// uazapiFor resolve o cliente a cada chamada, então criação de instância,
+// status, QR, webhook e envios da org passam a ir para a conta dela.
+//
+// GET    /api/orgs/wa-credentials  conta em uso (só o final do token)
+// PUT    /api/orgs/wa-credentials  (admin) {base_url, admin_token, auth_mode?}
+// DELETE /api/orgs/wa-credentials  (admin) volta para a conta da plataforma
+//
+// O token fica cifrado com a chave da org (pii.go); sem PII_MASTER_KEY o
+// cadastro fica desligado (503). A base precisa responder antes de gravar.
+// As instâncias existem na conta onde foram criadas: trocar a base com
+// instâncias uazapi ativas responde 409, a não ser com ?force=true (as
+// antigas deixam de ser alcançadas e precisam ser recriadas).
+// Instâncias da API oficial (Meta) não são afetadas. Com UAZAPI_MODE=mock
+// tudo continua no provedor simulado.
+
+func (a *App) mountWACredentials(r chi.Router) {
+	r.Get("/orgs/wa-credentials", a.getWACredentials)
+	r.With(a.requireRole(roleAdmin)).Put("/orgs/wa-credentials", a.putWACredentials)
+	r.With(a.requireRole(roleAdmin)).Delete("/orgs/wa-credentials", a.deleteWACredentials)
+}
+
+type waCredentials struct {
+	Configured bool       `json:"configured"`
+	BaseURL    string     `json:"base_url,omitempty"`
+	AuthMode   string     `json:"auth_mode,omitempty"`
+	TokenHint  string     `json:"token_hint,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+func (a *App) loadWACredentials(ctx context.Context, orgID int64) (waCredentials, error) {
+	c := waCredentials{}
+	err := a.DB.QueryRow(ctx, `
+SELECT base_url, COALESCE(auth_mode,''), token_hint, verified_at, updated_at
+  FROM public.org_wa_credentials WHERE org_id=$1`, orgID).Scan(&c.BaseURL, &c.AuthMode, &c.TokenHint, &c.VerifiedAt, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, nil
+	}
+	c.Configured = err == nil
+	return c, err
+}
+
+// GET /api/orgs/wa-credentials
+func (a *App) getWACredentials(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c, err := a.loadWACredentials(r.Context(), orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, c)
+}
+
+// PUT /api/orgs/wa-credentials
+func (a *App) putWACredentials(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !piiEnabled() {
+		render.Error(w, http.StatusServiceUnavailable, "PII_MASTER_KEY required to store provider credentials")
+		return
+	}
+	var in struct {
+		BaseURL    string `json:"base_url"`
+		AdminToken string `json:"admin_token"`
+		AuthMode   string `json:"auth_mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.AdminToken = strings.TrimSpace(in.AdminToken)
+	in.AuthMode = strings.ToLower(strings.TrimSpace(in.AuthMode))
+	base, err := validateWebhookURL(in.BaseURL)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, strings.Replace(err.Error(), "url", "base_url", 1))
+		return
+	}
+	base = strings.TrimRight(base, "/")
+	if in.AdminToken == "" {
+		render.Error(w, http.StatusBadRequest, "admin_token required")
+		return
+	}
+	if in.AuthMode != "" && in.AuthMode != string(waprovider.AuthBearer) && in.AuthMode != string(waprovider.AuthAdminToken) {
+		render.Error(w, http.StatusBadRequest, "auth_mode must be bearer or admintoken")
+		return
+	}
+
+	ctx := r.Context()
+	cur, err := a.loadWACredentials(ctx, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cur.BaseURL != base && r.URL.Query().Get("force") != "true" {
+		var n int
+		if err := a.DB.QueryRow(ctx, `
+SELECT COUNT(*) FROM public.wa_instances
+ WHERE org_id=$1 AND deleted_at IS NULL AND provider <> $2`, orgID, waProviderMetaCloud).Scan(&n); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n > 0 {
+			render.Error(w, http.StatusConflict, "org has active WhatsApp instances on the current provider account; delete them or retry with ?force=true")
+			return
+		}
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := waprovider.New(waprovider.ConfigForAccount(base, in.AdminToken, waprovider.AuthMode(in.AuthMode))).Ping(pingCtx); err != nil {
+		render.Error(w, http.StatusUnprocessableEntity, "provider unreachable: "+err.Error())
+		return
+	}
+	enc, err := encryptPII(orgID, piiKeyVersion(ctx, a.DB, orgID), in.AdminToken)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_, err = a.DB.Exec(ctx, `
+INSERT INTO public.org_wa_credentials (org_id, base_url, admin_token, auth_mode, token_hint, verified_at)
+VALUES ($1, $2, $3, NULLIF($4,''), $5, NOW())
+ON CONFLICT (org_id) DO UPDATE
+   SET base_url=EXCLUDED.base_url, admin_token=EXCLUDED.admin_token, auth_mode=EXCLUDED.auth_mode,
+       token_hint=EXCLUDED.token_hint, verified_at=EXCLUDED.verified_at, updated_at=NOW()`,
+		orgID, base, enc, in.AuthMode, llmKeyHint(in.AdminToken))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c, err := a.loadWACredentials(ctx, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, c)
+}
+
+// DELETE /api/orgs/wa-credentials
+func (a *App) deleteWACredentials(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `DELETE FROM public.org_wa_credentials WHERE org_id=$1`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "credentials not found")
+		return
+	}
+	render.NoContent(w)
+}
+
+// uazapiFor devolve o cliente do provedor para a org: a conta própria, se
+// cadastrada, senão a da plataforma. Falha ao ler ou decifrar é logada e
+// cai na conta da plataforma.
+func (a *App) uazapiFor(ctx context.Context, orgID int64) *waprovider.Client {
+	if orgID <= 0 {
+		return waprovider.FromEnv()
+	}
+	var base, enc, mode string
+	err := a.DB.QueryRow(ctx, `
+SELECT base_url, admin_token, COALESCE(auth_mode,'') FROM public.org_wa_credentials WHERE org_id=$1`, orgID).Scan(&base, &enc, &mode)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("wa credentials org=%d: %v", orgID, err)
+		}
+		return waprovider.FromEnv()
+	}
+	token, err := decryptPII(orgID, enc)
+	if err != nil {
+		log.Printf("wa credentials org=%d: decrypt: %v", orgID, err)
+		return waprovider.FromEnv()
+	}
+	return waprovider.New(waprovider.ConfigForAccount(base, token, waprovider.AuthMode(mode)))
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ================================================================
+//  Mensagens recebidas: leitura dos payloads dos provedores
+// ================================================================
+
+// waInboundMessage é uma mensagem extraída do webhook, já normalizada.
+type waInboundMessage struct {
+	ID     string
+	Chat   string // número do contato (só dígitos)
+	FromMe bool
+	Type   string
+	Text   string
+	At     time.Time
+	Raw    json.RawMessage // mensagem original do provedor
+}
+
+// uazapiMessagesFromWebhook lê eventos "messages" da uazapi
+// ({EventType, message:{id, chatid, sender, fromMe, messageType, text, timestamp}}).
+// Grupos são ignorados.
+func uazapiMessagesFromWebhook(body []byte) []waInboundMessage {
+	var p map[string]any
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil
+	}
+	event := strings.ToLower(pickStr(p, "EventType", "eventType", "event", "type"))
+	if !strings.HasPrefix(event, "message") || isWAStatusEvent(event) {
+		return nil
+	}
+	var raw []map[string]any
+	if m, ok := p["message"].(map[string]any); ok {
+		raw = append(raw, m)
+	}
+	if list, ok := p["messages"].([]any); ok {
+		for _, it := range list {
+			if m, ok := it.(map[string]any); ok {
+				raw = append(raw, m)
+			}
+		}
+	}
+	var out []waInboundMessage
+	for _, m := range raw {
+		chat := pickStr(m, "chatid", "chatId", "sender", "from")
+		if strings.HasSuffix(chat, "@g.us") || m["isGroup"] == true {
+			continue
+		}
+		chat = onlyDigits(strings.SplitN(chat, "@", 2)[0])
+		if chat == "" {
+			continue
+		}
+		fromMe, _ := m["fromMe"].(bool)
+		raw, _ := json.Marshal(m)
+		out = append(out, waInboundMessage{
+			ID:     pickStr(m, "id", "messageid", "messageId"),
+			Chat:   chat,
+			FromMe: fromMe,
+			Type:   chooseFirstNonEmpty(pickStr(m, "messageType", "type"), "text"),
+			Text:   pickStr(m, "text", "content", "caption"),
+			At:     unixAny(pickStr(m, "messageTimestamp", "timestamp")),
+			Raw:    raw,
+		})
+	}
+	return out
+}
+
+// metaCloudMessagesFromWebhook lê o webhook da Cloud API
+// (entry[].changes[].value.{metadata.phone_number_id, messages[]}) e agrupa
+// as mensagens por phone_number_id.
+func metaCloudMessagesFromWebhook(body []byte) map[string][]waInboundMessage {
+	var p struct {
+		Entry []struct {
+			Changes []struct {
+				Field string `json:"field"`
+				Value struct {
+					Metadata struct {
+						PhoneNumberID string `json:"phone_number_id"`
+					} `json:"metadata"`
+					Messages []json.RawMessage `json:"messages"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil
+	}
+	out := map[string][]waInboundMessage{}
+	for _, e := range p.Entry {
+		for _, c := range e.Changes {
+			phoneID := c.Value.Metadata.PhoneNumberID
+			for _, raw := range c.Value.Messages {
+				var m struct {
+					ID        string `json:"id"`
+					From      string `json:"from"`
+					Timestamp string `json:"timestamp"`
+					Type      string `json:"type"`
+					Text      struct {
+						Body string `json:"body"`
+					} `json:"text"`
+				}
+				if json.Unmarshal(raw, &m) != nil {
+					continue
+				}
+				out[phoneID] = append(out[phoneID], waInboundMessage{
+					ID:   m.ID,
+					Chat: onlyDigits(m.From),
+					Type: m.Type,
+					Text: m.Text.Body,
+					At:   unixAny(m.Timestamp),
+					Raw:  raw,
+				})
+			}
+		}
+	}
+	return out
+}
+
+// waConversationStatus é o trecho de cobrança dos status da Cloud API
+// (statuses[].conversation / pricing).
+type waConversationStatus struct {
+	ConversationID string
+	Contact        string
+	Category       string
+	Billable       bool
+	At             time.Time
+}
+
+// metaCloudStatusesFromWebhook extrai as conversas cobradas dos status
+// (sent/delivered/read), agrupadas por phone_number_id.
+func metaCloudStatusesFromWebhook(body []byte) map[string][]waConversationStatus {
+	var p struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Metadata struct {
+						PhoneNumberID string `json:"phone_number_id"`
+					} `json:"metadata"`
+					Statuses []struct {
+						RecipientID  string `json:"recipient_id"`
+						Timestamp    string `json:"timestamp"`
+						Conversation struct {
+							ID     string `json:"id"`
+							Origin struct {
+								Type string `json:"type"`
+							} `json:"origin"`
+						} `json:"conversation"`
+						Pricing *struct {
+							Billable bool   `json:"billable"`
+							Category string `json:"category"`
+						} `json:"pricing"`
+					} `json:"statuses"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil
+	}
+	out := map[string][]waConversationStatus{}
+	for _, e := range p.Entry {
+		for _, c := range e.Changes {
+			for _, st := range c.Value.Statuses {
+				if st.Conversation.ID == "" {
+					continue
+				}
+				cs := waConversationStatus{
+					ConversationID: st.Conversation.ID,
+					Contact:        onlyDigits(st.RecipientID),
+					Category:       strings.ToLower(st.Conversation.Origin.Type),
+					Billable:       true,
+					At:             unixAny(st.Timestamp),
+				}
+				if st.Pricing != nil {
+					cs.Billable = st.Pricing.Billable
+					if st.Pricing.Category != "" {
+						cs.Category = strings.ToLower(st.Pricing.Category)
+					}
+				}
+				if cs.Category == "" {
+					continue
+				}
+				id := c.Value.Metadata.PhoneNumberID
+				out[id] = append(out[id], cs)
+			}
+		}
+	}
+	return out
+}
+
+// unixAny interpreta timestamps em segundos ou milissegundos; inválido → agora.
+func unixAny(s string) time.Time {
+	n, err := strconv.ParseInt(strings.TrimSpace(strings.SplitN(s, ".", 2)[0]), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Now()
+	}
+	if n > 1e12 {
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n, 0)
+}
+
+// ================================================================
+//  Ingestão: lead + conversa + wa_messages
+// ================================================================
+
+// ingestWAMessages grava as mensagens no inbox da plataforma. Recebidas
+// criam o lead pelo telefone (se ainda não existir) e renovam a janela de
+// 24h; ecos dos nossos envios (fromMe) só entram na conversa. Reentregas do
+// provedor são ignoradas pelo message_id; o envio já gravado por
+// recordWAOutbound (sem conversa) é completado pelo eco.
+func (app *App) ingestWAMessages(ctx context.Context, instance string, msgs []waInboundMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	row, err := app.fetchWAInstance(ctx, instance)
+	if err != nil {
+		log.Printf("wa ingest %s: %v", instance, err)
+		return
+	}
+	for _, m := range msgs {
+		if err := app.ingestWAMessage(ctx, row, m); err != nil {
+			log.Printf("wa ingest %s/%s: %v", instance, m.ID, err)
+		}
+	}
+}
+
+func (app *App) ingestWAMessage(ctx context.Context, row waInstanceRow, m waInboundMessage) error {
+	if m.ID != "" {
+		var dup bool
+		if err := app.DB.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM public.wa_messages WHERE instance_id=$1 AND message_id=$2 AND conversation_id IS NOT NULL)`,
+			row.InstanceID, m.ID).Scan(&dup); err != nil {
+			return err
+		}
+		if dup {
+			return nil
+		}
+	}
+	if !m.FromMe {
+		app.recordInbound(ctx, row.InstanceID, m.Chat, m.At)
+	}
+
+	var leadID *int64
+	if id, err := app.leadIDByPhone(ctx, row.OrgID, row.FlowID, m.Chat, !m.FromMe); err != nil {
+		return err
+	} else if id > 0 {
+		leadID = &id
+	}
+
+	preview := m.Text
+	if preview == "" {
+		preview = "[" + m.Type + "]"
+	}
+	unread := 1
+	if m.FromMe {
+		unread = 0
+	}
+	var convID int64
+	err := app.DB.QueryRow(ctx, `
+INSERT INTO public.conversations (org_id, flow_id, lead_id, instance_id, contact, last_message, last_message_at, status, unread)
+VALUES ($1, $2, $3, $4, $5, $6, $7, 'open', $8)
+ON CONFLICT (instance_id, contact) WHERE instance_id IS NOT NULL DO UPDATE SET
+  lead_id         = COALESCE(public.conversations.lead_id, EXCLUDED.lead_id),
+  last_message    = CASE WHEN EXCLUDED.last_message_at >= COALESCE(public.conversations.last_message_at, '-infinity')
+                         THEN EXCLUDED.last_message ELSE public.conversations.last_message END,
+  last_message_at = GREATEST(public.conversations.last_message_at, EXCLUDED.last_message_at),
+  unread          = CASE WHEN $8 = 0 THEN 0 ELSE public.conversations.unread + 1 END,
+  status          = CASE WHEN $8 = 1 THEN 'open' ELSE public.conversations.status END,
+  updated_at      = NOW()
+RETURNING id`,
+		row.OrgID, row.FlowID, leadID, row.InstanceID, m.Chat, preview, m.At, unread).Scan(&convID)
+	if err != nil {
+		return err
+	}
+
+	direction, from, to := "in", m.Chat, ""
+	if m.FromMe {
+		direction, from, to = "out", "", m.Chat
+	}
+	_, err = app.DB.Exec(ctx, `
+INSERT INTO public.wa_messages (org_id, flow_id, instance_id, direction, from_number, to_number, payload,
+                                conversation_id, lead_id, message_id, msg_type, body, created_at)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), NULLIF($6,''), $7, $8, $9, NULLIF($10,''), $11, NULLIF($12,''), $13)
+ON CONFLICT (instance_id, message_id) WHERE message_id IS NOT NULL DO UPDATE
+   SET conversation_id = EXCLUDED.conversation_id,
+       lead_id         = COALESCE(public.wa_messages.lead_id, EXCLUDED.lead_id),
+       payload         = EXCLUDED.payload,
+       body            = COALESCE(public.wa_messages.body, EXCLUDED.body)
+ WHERE public.wa_messages.conversation_id IS NULL`,
+		row.OrgID, row.FlowID, row.InstanceID, direction, from, to, m.Raw,
+		convID, leadID, m.ID, m.Type, m.Text, m.At)
+	if err != nil {
+		return err
+	}
+	if !m.FromMe {
+		app.publishEvent(ctx, row.OrgID, eventWAMessageReceived, map[string]any{
+			"instance_id": row.InstanceID, "flow_id": row.FlowID, "conversation_id": convID, "lead_id": leadID,
+			"contact": m.Chat, "message_id": m.ID, "type": m.Type, "text": m.Text, "at": m.At,
+		})
+	}
+	if !m.FromMe && leadID != nil && isTrackingOptOut(m.Text) {
+		app.trackingOptOut(ctx, row, *leadID, m.Chat)
+	}
+	return nil
+}
+
+// leadIDByPhone procura o lead pelo hash do telefone em E.164 (e, para leads
+// ainda não normalizados, pelo hash do valor recebido); com create=true cria
+// um lead "novo" de origem whatsapp quando não encontra. 0 = sem lead.
+func (app *App) leadIDByPhone(ctx context.Context, orgID, flowID int64, phone string, create bool) (int64, error) {
+	var id int64
+	err := app.DB.QueryRow(ctx,
+		`SELECT id FROM leads WHERE org_id=$1 AND flow_id=$2 AND phone_hash IN ($3, $4) ORDER BY id LIMIT 1`,
+		orgID, flowID, phoneHash(orgID, phone), piiHash(orgID, phone)).Scan(&id)
+	if err == nil || !errors.Is(err, pgx.ErrNoRows) {
+		return id, err
+	}
+	if !create {
+		return 0, nil
+	}
+	id, _, err = app.insertLead(ctx, orgID, flowID, "", phone, "", "novo")
+	if err != nil {
+		return 0, err
+	}
+	_, err = app.DB.Exec(ctx, `UPDATE leads SET source='whatsapp' WHERE id=$1`, id)
+	return id, err
+}
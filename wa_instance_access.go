@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Acesso às rotas /api/wa/instances/{instance}/*
+// ================================================================
+//
+// waInstanceAccess carrega a instância da URL uma vez, confere o dono e
+// injeta a linha (com o token guardado) no contexto; os handlers usam
+// routeWAInstance e chamam o provedor com row.Token, então o cliente não
+// precisa mais mandar o token da instância.
+//
+//   - tenant resolvido (JWT ou X-Org-ID/X-Flow-ID) de outra org: 403, mesmo
+//     com token certo;
+//   - mesma org e flow com tenant autenticado (JWT ou API key): liberado;
+//     X-Org-ID/X-Flow-ID sozinhos não provam nada e caem no caso abaixo;
+//   - sem tenant (integrações antigas): exige o token da instância, de
+//     preferência no header X-Instance-Token. ?token= e o campo "token" do
+//     corpo ainda valem, mas a resposta leva Deprecation: true.
+//
+// Instância inexistente ou apagada: 404.
+
+type waInstanceCtxKey struct{}
+
+// waBodyTokenMax limita a leitura do corpo JSON à procura do campo "token".
+const waBodyTokenMax = 1 << 20
+
+func (app *App) waInstanceAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instance := strings.TrimSpace(chi.URLParam(r, "instance"))
+		if instance == "" {
+			render.Error(w, http.StatusBadRequest, "missing instance")
+			return
+		}
+		row, err := app.fetchWAInstance(r.Context(), instance)
+		if err != nil {
+			render.Error(w, http.StatusNotFound, "instance not found")
+			return
+		}
+		t, hasTenant := tenantFrom(r.Context())
+		hasTenant = hasTenant && t.OrgID > 0
+		switch {
+		case hasTenant && t.OrgID != row.OrgID:
+			render.Error(w, http.StatusForbidden, "instance belongs to another organization")
+			return
+		case hasTenant && (t.Source == tenantSourceJWT || t.Source == tenantSourceAPIKey) && t.FlowID > 0 && t.FlowID == row.FlowID:
+			// dono
+		default:
+			token, legacy := waSuppliedToken(w, r)
+			if !waTokenMatches(token, row.Token) {
+				render.Error(w, http.StatusForbidden, "forbidden")
+				return
+			}
+			if legacy {
+				w.Header().Set("Deprecation", "true")
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), waInstanceCtxKey{}, row)))
+	})
+}
+
+// waSuppliedToken procura o token da instância: header, query ou corpo.
+// legacy=true quando não veio pelo header. O corpo lido volta para r.Body.
+func waSuppliedToken(w http.ResponseWriter, r *http.Request) (token string, legacy bool) {
+	if tok := headerTrim(r, "X-Instance-Token"); tok != "" {
+		return tok, false
+	}
+	if tok := strings.TrimSpace(r.URL.Query().Get("token")); tok != "" {
+		return tok, true
+	}
+	if r.Body == nil || r.Method == http.MethodGet {
+		return "", false
+	}
+	ct := r.Header.Get("Content-Type")
+	if strings.HasPrefix(ct, "multipart/") {
+		// o handler de mídia reaproveita o form já lido
+		r.Body = http.MaxBytesReader(w, r.Body, mediaMaxBytes()+(1<<20))
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			return "", false
+		}
+		return strings.TrimSpace(r.FormValue("token")), true
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, waBodyTokenMax+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), r.Body))
+	if err != nil || len(raw) > waBodyTokenMax {
+		return "", false
+	}
+	var in struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(raw, &in)
+	return strings.TrimSpace(in.Token), true
+}
+
+func waTokenMatches(supplied, stored string) bool {
+	stored = strings.TrimSpace(stored)
+	return supplied != "" && stored != "" && subtle.ConstantTimeCompare([]byte(supplied), []byte(stored)) == 1
+}
+
+// routeWAInstance devolve a instância validada por waInstanceAccess. Fora
+// do middleware, carrega e valida aqui (mesma regra de authorizeInstanceAccess).
+func (app *App) routeWAInstance(w http.ResponseWriter, r *http.Request) (waInstanceRow, bool) {
+	if row, ok := r.Context().Value(waInstanceCtxKey{}).(waInstanceRow); ok {
+		return row, true
+	}
+	row, err := app.fetchWAInstance(r.Context(), strings.TrimSpace(chi.URLParam(r, "instance")))
+	if err != nil {
+		render.Error(w, http.StatusNotFound, "instance not found")
+		return row, false
+	}
+	token, _ := waSuppliedToken(w, r)
+	if !app.authorizeInstanceAccess(r, row, token) {
+		render.Error(w, http.StatusForbidden, "forbidden")
+		return row, false
+	}
+	return row, true
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Eventos de ciclo de vida da instância (connected / disconnected / qr-expired)
+// ================================================================
+//
+// Além de encaminhar mensagens, o backend avisa o destino downstream (n8n /
+// Agente) quando o número conecta, cai ou o QR expira, para que os fluxos
+// pausem o envio. O estado fica em wa_instances.state e só mudanças geram
+// evento. Destino: WA_EVENTS_URL ou, se vazio, a mesma URL do encaminhamento
+// de mensagens (agentForwardURL).
+//
+// Cada mudança também fica no histórico (wa_connection_events) com a origem
+// (webhook, poll, qr, api) e o motivo informado pelo provedor, quando houver:
+//
+// GET /api/wa/instances/{instance}/events?limit=&before=
+//     transições mais recentes primeiro (before = id para paginar) e um
+//     resumo de quedas em 24h/7d, para diagnosticar números instáveis.
+
+const (
+	waStateConnected    = "connected"
+	waStateDisconnected = "disconnected"
+	waStateQRExpired    = "qr-expired"
+	// removida pela plataforma (wa_instance_lifecycle.go); nunca vem do provedor
+	waStateDeleted = "deleted"
+)
+
+// normalizeWAState traduz os status do provedor para os três estados
+// publicados. Estados intermediários (connecting, waiting-qr) devolvem "".
+func normalizeWAState(status string) string {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "open", "connected", "online", "authenticated":
+		return waStateConnected
+	case "close", "closed", "disconnected", "offline", "logout", "loggedout", "logged_out":
+		return waStateDisconnected
+	case "qr-expired", "qr_expired", "qrcode-timeout", "qr_timeout", "timeout", "expired":
+		return waStateQRExpired
+	}
+	return ""
+}
+
+// connectionStateFromWebhook identifica eventos de conexão no payload da
+// Uazapi (EventType/event = connection|qrcode) e devolve o estado normalizado
+// e o motivo, se o provedor mandou.
+func connectionStateFromWebhook(body []byte) (string, string) {
+	var p map[string]any
+	if err := json.Unmarshal(body, &p); err != nil {
+		return "", ""
+	}
+	event := strings.ToLower(pickStr(p, "EventType", "eventType", "event", "type"))
+	if !strings.Contains(event, "connection") && !strings.Contains(event, "qr") && !strings.Contains(event, "status") {
+		return "", ""
+	}
+	candidates := []map[string]any{p}
+	for _, k := range []string{"instance", "data", "connect"} {
+		if m, ok := p[k].(map[string]any); ok {
+			candidates = append(candidates, m)
+		}
+	}
+	reason := ""
+	for _, m := range candidates {
+		if reason = pickStr(m, "reason", "lastDisconnectReason", "disconnectReason", "message"); reason != "" {
+			break
+		}
+	}
+	for _, m := range candidates {
+		if s := normalizeWAState(pickStr(m, "status", "state", "connection")); s != "" {
+			return s, reason
+		}
+	}
+	// QR code que expirou sem leitura costuma vir só com o motivo
+	if r := strings.ToLower(reason); strings.Contains(event, "qr") && (strings.Contains(r, "timeout") || strings.Contains(r, "expired")) {
+		return waStateQRExpired, reason
+	}
+	return "", ""
+}
+
+// waStateCause diz de onde veio a mudança de estado, para o histórico.
+type waStateCause struct {
+	Source string // webhook | poll | qr | api
+	Reason string
+}
+
+// recordWAState grava o estado e informa se houve mudança (e qual era o
+// anterior). A mudança entra no histórico na mesma instrução.
+func (app *App) recordWAState(ctx context.Context, instance, state string, cause waStateCause) (string, bool) {
+	var prev string
+	err := app.DB.QueryRow(ctx, `
+WITH old AS (SELECT COALESCE(state,'') AS state FROM public.wa_instances WHERE instance_id=$1),
+upd AS (
+  UPDATE public.wa_instances SET state=$2, state_at=NOW(), updated_at=NOW()
+   WHERE instance_id=$1 AND state IS DISTINCT FROM $2
+  RETURNING org_id, flow_id
+), ev AS (
+  INSERT INTO public.wa_connection_events (instance_id, org_id, flow_id, state, previous, source, reason)
+  SELECT $1, org_id, flow_id, $2, NULLIF((SELECT state FROM old),''), NULLIF($3,''), NULLIF($4,'') FROM upd
+)
+SELECT (SELECT state FROM old) FROM upd`, instance, state, cause.Source, limitRunes(cause.Reason, 500)).Scan(&prev)
+	if err != nil {
+		return "", false
+	}
+	return prev, true
+}
+
+// handleWAStateChange registra o estado e, se mudou, publica o evento.
+func (app *App) handleWAStateChange(ctx context.Context, instance, state string, cause waStateCause, info instanceInfo) {
+	if state == "" {
+		return
+	}
+	prev, changed := app.recordWAState(ctx, instance, state, cause)
+	if !changed {
+		return
+	}
+	log.Printf("wa instance %s: %s -> %s (%s)", instance, chooseFirstNonEmpty(prev, "unknown"), state, chooseFirstNonEmpty(cause.Reason, cause.Source))
+	go app.pushWAStateEvent(instance, prev, state, info)
+	if state == waStateConnected {
+		// provedores às vezes descartam o webhook em um novo login
+		app.reprovisionWebhookAsync(instance)
+	}
+}
+
+func (app *App) pushWAStateEvent(instance, prev, state string, info instanceInfo) {
+	target := strings.TrimSpace(getenv("WA_EVENTS_URL", ""))
+	if target == "" {
+		target = agentForwardURL(instance)
+	}
+	payload, _ := json.Marshal(map[string]any{
+		"event":    "instance.state",
+		"instance": instance,
+		"state":    state,
+		"previous": prev,
+		"org_id":   info.OrgID,
+		"flow_id":  info.FlowID,
+		"at":       time.Now().UTC().Format(time.RFC3339),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	app.enqueueAgentForward(ctx, info, agentForwardJob{
+		Instance: instance, URL: target, EventType: "instance.state", Body: payload,
+	})
+}
+
+type waConnectionEvent struct {
+	ID        int64     `json:"id"`
+	State     string    `json:"state"`
+	Previous  string    `json:"previous,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type waConnectionSummary struct {
+	Disconnects24h     int        `json:"disconnects_24h"`
+	Disconnects7d      int        `json:"disconnects_7d"`
+	LastConnectedAt    *time.Time `json:"last_connected_at,omitempty"`
+	LastDisconnectedAt *time.Time `json:"last_disconnected_at,omitempty"`
+}
+
+// GET /api/wa/instances/{instance}/events
+func (app *App) waConnectionEvents(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.routeWAInstance(w, r) // wa_instance_access.go
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+
+	rows, err := app.DB.Query(ctx, `
+SELECT id, state, COALESCE(previous,''), COALESCE(source,''), COALESCE(reason,''), created_at
+  FROM public.wa_connection_events
+ WHERE instance_id=$1 AND ($2 = 0 OR id < $2)
+ ORDER BY id DESC
+ LIMIT $3`, row.InstanceID, before, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	items := []waConnectionEvent{}
+	for rows.Next() {
+		var e waConnectionEvent
+		if err := rows.Scan(&e.ID, &e.State, &e.Previous, &e.Source, &e.Reason, &e.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		items = append(items, e)
+	}
+	if err := rows.Err(); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var sum waConnectionSummary
+	err = app.DB.QueryRow(ctx, `
+SELECT COUNT(*) FILTER (WHERE state=$2 AND created_at > NOW() - INTERVAL '24 hours'),
+       COUNT(*) FILTER (WHERE state=$2 AND created_at > NOW() - INTERVAL '7 days'),
+       MAX(created_at) FILTER (WHERE state=$3),
+       MAX(created_at) FILTER (WHERE state=$2)
+  FROM public.wa_connection_events WHERE instance_id=$1`, row.InstanceID, waStateDisconnected, waStateConnected).
+		Scan(&sum.Disconnects24h, &sum.Disconnects7d, &sum.LastConnectedAt, &sum.LastDisconnectedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := map[string]any{"instance": row.InstanceID, "state": row.State, "items": items, "summary": sum}
+	if len(items) == limit {
+		out["next_before"] = items[len(items)-1].ID
+	}
+	render.OK(w, out)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Desconexão e remoção de instâncias
+// ================================================================
+//
+// POST   /api/wa/instances/{instance}/logout  encerra a sessão do número no
+//        provedor (a instância continua e pode ler um novo QR).
+// DELETE /api/wa/instances/{instance}         remove a instância no provedor
+//        e marca wa_instances.deleted_at; webhooks posteriores são descartados
+//        (processWAWebhook) e as demais rotas passam a responder 404.
+//
+// Autorização como nas demais rotas (waInstanceAccess): mesmo tenant ou
+// X-Instance-Token da instância.
+// Instâncias da API oficial não têm sessão no provedor: só o banco muda.
+// DELETE ?force=true marca a remoção mesmo com o provedor fora do ar.
+
+// POST /api/wa/instances/{instance}/logout
+func (app *App) waLogoutInstance(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.routeWAInstance(w, r) // wa_instance_access.go
+	if !ok {
+		return
+	}
+	if row.Provider != waProviderMetaCloud {
+		status, err := app.waLifecycleCall(r.Context(), row, http.MethodPost, "/logout")
+		if err != nil {
+			render.Error(w, http.StatusBadGateway, "provider error: "+err.Error())
+			return
+		}
+		if status >= 300 && status != http.StatusNotFound {
+			render.Error(w, http.StatusBadGateway, "provider error: status "+strconv.Itoa(status))
+			return
+		}
+	}
+	app.handleWAStateChange(r.Context(), row.InstanceID, waStateDisconnected, waStateCause{Source: "api", Reason: "logout"}, waRowInfo(row))
+	render.OK(w, map[string]any{"ok": true, "instance": row.InstanceID, "status": waStateDisconnected})
+}
+
+// DELETE /api/wa/instances/{instance}
+func (app *App) waDeleteInstance(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.routeWAInstance(w, r) // wa_instance_access.go
+	if !ok {
+		return
+	}
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	providerStatus := 0
+	if row.Provider != waProviderMetaCloud {
+		status, err := app.waLifecycleCall(r.Context(), row, http.MethodDelete, "")
+		// 404: o provedor já não conhece a instância; segue com a remoção
+		if !force && (err != nil || (status >= 300 && status != http.StatusNotFound)) {
+			msg := "status " + strconv.Itoa(status)
+			if err != nil {
+				msg = err.Error()
+			}
+			render.Error(w, http.StatusBadGateway, "provider error: "+msg+" (use ?force=true to delete anyway)")
+			return
+		}
+		providerStatus = status
+	}
+	_, err := app.DB.Exec(r.Context(), `
+WITH upd AS (
+  UPDATE public.wa_instances
+     SET deleted_at=NOW(), state=$2, state_at=NOW(), webhook_test_nonce=NULL, updated_at=NOW()
+   WHERE instance_id=$1
+  RETURNING org_id, flow_id
+)
+INSERT INTO public.wa_connection_events (instance_id, org_id, flow_id, state, previous, source, reason)
+SELECT $1, org_id, flow_id, $2, NULLIF($3,''), 'api', 'deleted' FROM upd`, row.InstanceID, waStateDeleted, row.State)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	go app.pushWAStateEvent(row.InstanceID, row.State, waStateDeleted, waRowInfo(row))
+	render.OK(w, map[string]any{"ok": true, "instance": row.InstanceID, "deleted": true, "provider_status": providerStatus})
+}
+
+// waLifecycleCall chama o provedor com o token da instância e devolve o
+// status HTTP.
+func (app *App) waLifecycleCall(ctx context.Context, row waInstanceRow, method, suffix string) (int, error) {
+	// modo bearer: o token da instância vai na query, como em /status
+	q := url.Values{"token": {row.Token}}
+	resp, err := app.uazapiFor(ctx, row.OrgID).DoInstance(ctx, method, waprovider.InstancePath(row.InstanceID, suffix), row.Token, q, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func waRowInfo(row waInstanceRow) instanceInfo {
+	return instanceInfo{
+		Token:  row.Token,
+		OrgID:  strconv.FormatInt(row.OrgID, 10),
+		FlowID: strconv.FormatInt(row.FlowID, 10),
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Transferência de instância entre flows e orgs
+// ================================================================
+//
+// POST /api/wa/instances/{instance}/transfer          (admin/owner da org)
+//      {flow_id, move_history?}                         outro flow da mesma org
+// POST /api/admin/wa-instances/{instance}/transfer    (ADMIN_TOKEN)
+//      {org_id, flow_id, move_history?}                 também entre orgs
+//
+// A instância passa para o destino junto com o que depende dela para
+// funcionar: janelas de 24h (wa_contact_windows) e templates da Meta.
+//
+// move_history=true leva também as conversas, as mensagens e as conversas
+// cobradas. O lead de cada conversa é trocado pelo lead do mesmo telefone no
+// destino (ou nenhum); leads, pedidos e o resto da org de origem não mudam.
+// Sem move_history as conversas ficam na origem, desligadas da instância
+// (somente leitura), e as próximas mensagens abrem conversas no destino.
+//
+// Instância uazapi só muda de org se as duas usam a mesma conta no provedor
+// (wa_credentials.go); senão 409.
+
+type waTransferReq struct {
+	OrgID       int64 `json:"org_id"`
+	FlowID      int64 `json:"flow_id"`
+	MoveHistory bool  `json:"move_history"`
+}
+
+type waTransferResult struct {
+	Instance              string `json:"instance"`
+	FromOrgID             int64  `json:"from_org_id"`
+	FromFlowID            int64  `json:"from_flow_id"`
+	OrgID                 int64  `json:"org_id"`
+	FlowID                int64  `json:"flow_id"`
+	MoveHistory           bool   `json:"move_history"`
+	ConversationsMoved    int64  `json:"conversations_moved"`
+	ConversationsDetached int64  `json:"conversations_detached"`
+	MessagesMoved         int64  `json:"messages_moved"`
+}
+
+var (
+	errTransferFlowNotFound = errors.New("target flow not found in target org")
+	errTransferSameTarget   = errors.New("instance already belongs to this flow")
+	errTransferAccount      = errors.New("source and target orgs use different WhatsApp provider accounts")
+	errTransferConcurrent   = errors.New("instance changed during transfer, retry")
+)
+
+// POST /api/wa/instances/{instance}/transfer
+func (app *App) waTransferInstance(w http.ResponseWriter, r *http.Request) {
+	c, _ := claimsFromContext(r.Context())
+	var in waTransferReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.OrgID != 0 && in.OrgID != c.OrgID {
+		render.Error(w, http.StatusForbidden, "transfers to another organization go through the platform admin API")
+		return
+	}
+	row, err := app.fetchWAInstance(r.Context(), strings.TrimSpace(chi.URLParam(r, "instance")))
+	if err != nil || row.OrgID != c.OrgID {
+		render.Error(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	in.OrgID = c.OrgID
+	app.writeWATransfer(w, r, row, in)
+}
+
+// POST /api/admin/wa-instances/{instance}/transfer
+func (app *App) adminTransferWAInstance(w http.ResponseWriter, r *http.Request) {
+	var in waTransferReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	row, err := app.fetchWAInstance(r.Context(), strings.TrimSpace(chi.URLParam(r, "instance")))
+	if err != nil {
+		render.Error(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	if in.OrgID <= 0 {
+		in.OrgID = row.OrgID
+	}
+	app.writeWATransfer(w, r, row, in)
+}
+
+func (app *App) writeWATransfer(w http.ResponseWriter, r *http.Request, row waInstanceRow, in waTransferReq) {
+	if in.FlowID <= 0 {
+		render.Error(w, http.StatusBadRequest, "flow_id required")
+		return
+	}
+	res, err := app.transferWAInstance(r.Context(), row, in)
+	switch {
+	case errors.Is(err, errTransferFlowNotFound):
+		render.Error(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errTransferSameTarget), errors.Is(err, errTransferAccount), errors.Is(err, errTransferConcurrent):
+		render.Error(w, http.StatusConflict, err.Error())
+	case err != nil:
+		render.Error(w, http.StatusInternalServerError, err.Error())
+	default:
+		log.Printf("wa instance %s transferred org=%d flow=%d -> org=%d flow=%d (history=%v)",
+			res.Instance, res.FromOrgID, res.FromFlowID, res.OrgID, res.FlowID, res.MoveHistory)
+		render.OK(w, res)
+	}
+}
+
+func (app *App) transferWAInstance(ctx context.Context, row waInstanceRow, in waTransferReq) (waTransferResult, error) {
+	res := waTransferResult{Instance: row.InstanceID, FromOrgID: row.OrgID, FromFlowID: row.FlowID,
+		OrgID: in.OrgID, FlowID: in.FlowID, MoveHistory: in.MoveHistory}
+	if in.OrgID == row.OrgID && in.FlowID == row.FlowID {
+		return res, errTransferSameTarget
+	}
+	tx, err := app.DB.Begin(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+
+	var ok bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM flows WHERE id=$1 AND org_id=$2)`, in.FlowID, in.OrgID).Scan(&ok); err != nil {
+		return res, err
+	}
+	if !ok {
+		return res, errTransferFlowNotFound
+	}
+	if in.OrgID != row.OrgID && row.Provider != waProviderMetaCloud {
+		var same bool
+		err := tx.QueryRow(ctx, `
+SELECT COALESCE((SELECT base_url FROM public.org_wa_credentials WHERE org_id=$1), '')
+     = COALESCE((SELECT base_url FROM public.org_wa_credentials WHERE org_id=$2), '')`, row.OrgID, in.OrgID).Scan(&same)
+		if err != nil {
+			return res, err
+		}
+		if !same {
+			return res, errTransferAccount
+		}
+	}
+
+	// a linha da instância trava transferências concorrentes
+	tag, err := tx.Exec(ctx, `
+UPDATE public.wa_instances SET org_id=$2, flow_id=$3, updated_at=NOW()
+ WHERE instance_id=$1 AND org_id=$4 AND flow_id=$5 AND deleted_at IS NULL`,
+		row.InstanceID, in.OrgID, in.FlowID, row.OrgID, row.FlowID)
+	if err != nil {
+		return res, err
+	}
+	if tag.RowsAffected() == 0 {
+		return res, errTransferConcurrent
+	}
+	for _, table := range []string{"wa_contact_windows", "wa_templates"} {
+		if _, err := tx.Exec(ctx, `UPDATE public.`+table+` SET org_id=$2, flow_id=$3 WHERE instance_id=$1`,
+			row.InstanceID, in.OrgID, in.FlowID); err != nil {
+			return res, fmt.Errorf("%s: %w", table, err)
+		}
+	}
+
+	if !in.MoveHistory {
+		tag, err := tx.Exec(ctx, `UPDATE public.conversations SET instance_id=NULL, updated_at=NOW() WHERE instance_id=$1`, row.InstanceID)
+		if err != nil {
+			return res, err
+		}
+		res.ConversationsDetached = tag.RowsAffected()
+		return res, tx.Commit(ctx)
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id, COALESCE(contact,'') FROM public.conversations WHERE instance_id=$1`, row.InstanceID)
+	if err != nil {
+		return res, err
+	}
+	type conv struct {
+		id      int64
+		contact string
+	}
+	var convs []conv
+	for rows.Next() {
+		var c conv
+		if err := rows.Scan(&c.id, &c.contact); err != nil {
+			rows.Close()
+			return res, err
+		}
+		convs = append(convs, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	for _, c := range convs {
+		// hash do telefone depende da org (pii.go)
+		var leadID *int64
+		err := tx.QueryRow(ctx,
+			`SELECT id FROM leads WHERE org_id=$1 AND flow_id=$2 AND phone_hash IN ($3, $4) ORDER BY id LIMIT 1`,
+			in.OrgID, in.FlowID, phoneHash(in.OrgID, c.contact), piiHash(in.OrgID, c.contact)).Scan(&leadID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return res, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE public.conversations SET org_id=$2, flow_id=$3, lead_id=$4, updated_at=NOW() WHERE id=$1`,
+			c.id, in.OrgID, in.FlowID, leadID); err != nil {
+			return res, err
+		}
+	}
+	res.ConversationsMoved = int64(len(convs))
+
+	tag, err = tx.Exec(ctx, `
+UPDATE public.wa_messages m
+   SET org_id=$2, flow_id=$3,
+       lead_id=(SELECT c.lead_id FROM public.conversations c WHERE c.id = m.conversation_id)
+ WHERE m.instance_id=$1`, row.InstanceID, in.OrgID, in.FlowID)
+	if err != nil {
+		return res, err
+	}
+	res.MessagesMoved = tag.RowsAffected()
+	if _, err := tx.Exec(ctx, `UPDATE public.wa_billable_conversations SET org_id=$2, flow_id=$3 WHERE instance_id=$1`,
+		row.InstanceID, in.OrgID, in.FlowID); err != nil {
+		return res, err
+	}
+	return res, tx.Commit(ctx)
+}
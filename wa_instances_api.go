@@ -1,5 +0,0 @@
-package main
-
-// (migrado) A lógica de WhatsApp (instâncias, status, QR, webhook, envio)
-// agora está centralizada em handlers_whatsapp.go para evitar duplicações.
-// Este arquivo é mantido apenas como referência/compatibilidade.
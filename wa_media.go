@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Envio de mídia pelo WhatsApp (imagem, áudio, documento, vídeo)
+// ================================================================
+//
+// POST /api/wa/instances/{instance}/send/media aceita:
+//   - JSON: {token, to, type, url, caption, filename}
+//   - multipart: campos token/to/type/caption + arquivo em "file"
+//
+// Arquivos enviados via multipart são gravados em UPLOAD_DIR, passam pelo
+// antivírus e seguem ao provedor pela URL pública em /uploads. Se "type" não
+// vier, é deduzido do Content-Type. Limite: MEDIA_MAX_MB (padrão 16).
+
+type waSendMediaReq struct {
+	To       string `json:"to"`
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	Caption  string `json:"caption"`
+	Filename string `json:"filename"`
+}
+
+var waMediaTypes = map[string]bool{"image": true, "audio": true, "document": true, "video": true}
+
+func mediaMaxBytes() int64 {
+	mb, err := strconv.ParseInt(getenv("MEDIA_MAX_MB", "16"), 10, 64)
+	if err != nil || mb <= 0 {
+		mb = 16
+	}
+	return mb << 20
+}
+
+// mediaTypeFromMime mapeia o Content-Type para o tipo de mensagem do WhatsApp.
+func mediaTypeFromMime(mime string) string {
+	mime = strings.ToLower(mime)
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		return "image"
+	case strings.HasPrefix(mime, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mime, "video/"):
+		return "video"
+	default:
+		return "document"
+	}
+}
+
+func (app *App) waSendMedia(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
+		return
+	}
+
+	var in waSendMediaReq
+	var localPath string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.Body = http.MaxBytesReader(w, r.Body, mediaMaxBytes()+(1<<20))
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			render.Error(w, http.StatusRequestEntityTooLarge, "multipart parse error: "+err.Error())
+			return
+		}
+		in.To = r.FormValue("to")
+		in.Type = r.FormValue("type")
+		in.Caption = r.FormValue("caption")
+		in.Filename = r.FormValue("filename")
+	} else if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if strings.TrimSpace(in.To) == "" {
+		render.Error(w, http.StatusBadRequest, "missing to")
+		return
+	}
+
+	if r.MultipartForm != nil {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, "file required")
+			return
+		}
+		defer file.Close()
+		if header.Size > mediaMaxBytes() {
+			render.Error(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file too large (max %d MB)", mediaMaxBytes()>>20))
+			return
+		}
+		mime := header.Header.Get("Content-Type")
+		if in.Type == "" {
+			in.Type = mediaTypeFromMime(mime)
+		}
+		if in.Filename == "" {
+			in.Filename = filepath.Base(header.Filename)
+		}
+
+		uploadDir := getenv("UPLOAD_DIR", "uploads")
+		if err := os.MkdirAll(uploadDir, 0o755); err != nil {
+			render.Error(w, http.StatusInternalServerError, "cannot create upload dir: "+err.Error())
+			return
+		}
+		name := strconv.FormatInt(time.Now().UnixNano(), 10) + strings.ToLower(filepath.Ext(header.Filename))
+		localPath = filepath.Join(uploadDir, name)
+		dst, err := os.Create(localPath)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, "cannot save file: "+err.Error())
+			return
+		}
+		_, err = io.Copy(dst, file)
+		dst.Close()
+		if err != nil {
+			_ = os.Remove(localPath)
+			render.Error(w, http.StatusInternalServerError, "write file error: "+err.Error())
+			return
+		}
+		if in.Type == "video" {
+			if err := validateVideo(ctx, localPath, mime, header.Size); err != nil {
+				_ = os.Remove(localPath)
+				render.Error(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+		}
+		scan := app.scanFile(ctx, row.OrgID, "wa_media", localPath)
+		if !scan.Accepted() {
+			render.APIError(w, http.StatusUnprocessableEntity, "file_rejected", "file rejected by antivirus", map[string]any{"scan": scan})
+			return
+		}
+		// o provedor baixa a mídia pela URL: precisa ser pública (ou pré-assinada)
+		if in.URL, err = storeUpload(ctx, r, localPath, mime); err != nil {
+			render.Error(w, http.StatusBadGateway, "storage error: "+err.Error())
+			return
+		}
+	} else if strings.TrimSpace(in.URL) == "" {
+		render.Error(w, http.StatusBadRequest, "missing url or file")
+		return
+	} else if in.Type == "video" {
+		if err := validateRemoteVideo(ctx, in.URL); err != nil {
+			render.Error(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+
+	in.Type = strings.ToLower(strings.TrimSpace(in.Type))
+	if in.Type == "" {
+		in.Type = "image"
+	}
+	if !waMediaTypes[in.Type] {
+		render.Error(w, http.StatusBadRequest, "type must be image, audio, document or video")
+		return
+	}
+
+	body := map[string]any{
+		"to":   in.To,
+		"type": in.Type,
+		"url":  in.URL,
+	}
+	if in.Caption != "" && in.Type != "audio" {
+		body["caption"] = in.Caption
+	}
+	if in.Filename != "" && in.Type == "document" {
+		body["filename"] = in.Filename
+	}
+	out, status, err := app.waProviderSend(ctx, row, row.Token, "/send/media", body)
+	if err != nil {
+		writeWASendError(w, err, status)
+		return
+	}
+	out["media_url"] = in.URL
+	render.OK(w, out)
+}
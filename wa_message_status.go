@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Status de entrega das mensagens enviadas
+// ================================================================
+//
+// Todo envio com id do provedor (waProviderSend) grava a mensagem em
+// wa_messages com status "sent"; o eco do webhook (fromMe) completa a
+// conversa. Os eventos de status atualizam a linha:
+//
+//   uazapi     EventType "messages_update" (ou status/ack), com os ids em
+//              event.MessageIDs / message.id e o status em Type/status/ack
+//   Cloud API  entry[].changes[].value.statuses[] (webhookMeta)
+//
+// O status só avança (sent < delivered < read); failed vale sempre e guarda
+// o erro. Cada mudança carimba o *_at correspondente e publica
+// wa.message.status (webhooks_out.go) para relatórios de campanha.
+// Status de mensagem que não conhecemos (enviada pelo celular) é ignorado.
+//
+// GET /api/wa/messages/{id}  id interno ou message_id do provedor
+
+const (
+	waStatusSent      = "sent"
+	waStatusDelivered = "delivered"
+	waStatusRead      = "read"
+	waStatusFailed    = "failed"
+)
+
+// waMessageStatus é um aviso de status já normalizado.
+type waMessageStatus struct {
+	MessageID string
+	Status    string
+	Error     string
+	At        time.Time
+}
+
+// normalizeWAStatus traduz os nomes dos provedores ("DELIVERY_ACK",
+// "ReadReceipt", ack numérico...). "" = status que não acompanhamos.
+func normalizeWAStatus(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "sent", "server_ack", "serverack", "1":
+		return waStatusSent
+	case "delivered", "delivery", "delivery_ack", "deliveryack", "2":
+		return waStatusDelivered
+	case "read", "readreceipt", "read_ack", "readack", "played", "played_ack", "3", "4":
+		return waStatusRead
+	case "failed", "error", "-1":
+		return waStatusFailed
+	}
+	return ""
+}
+
+// isWAStatusEvent separa os eventos de status dos de mensagem nova, que
+// também começam com "message" na uazapi.
+func isWAStatusEvent(event string) bool {
+	event = strings.ToLower(event)
+	return strings.Contains(event, "update") || strings.Contains(event, "status") || strings.Contains(event, "ack")
+}
+
+// uazapiStatusesFromWebhook lê os eventos de status da uazapi.
+func uazapiStatusesFromWebhook(body []byte) []waMessageStatus {
+	var p map[string]any
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil
+	}
+	if !isWAStatusEvent(pickStr(p, "EventType", "eventType", "event", "type")) {
+		return nil
+	}
+	var out []waMessageStatus
+	for _, key := range []string{"event", "message", "data"} {
+		m, ok := p[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		status := normalizeWAStatus(pickStr(m, "Type", "type", "status", "state", "ack"))
+		if status == "" {
+			continue
+		}
+		at := unixAny(pickStr(m, "Timestamp", "timestamp", "messageTimestamp"))
+		errText := pickStr(m, "error", "reason")
+		ids := []string{}
+		for _, k := range []string{"MessageIDs", "messageIds", "messageids"} {
+			if list, ok := m[k].([]any); ok {
+				for _, v := range list {
+					if s, ok := v.(string); ok && s != "" {
+						ids = append(ids, s)
+					}
+				}
+			}
+		}
+		if len(ids) == 0 {
+			if id := pickStr(m, "messageid", "messageId", "id"); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		for _, id := range ids {
+			out = append(out, waMessageStatus{MessageID: id, Status: status, Error: errText, At: at})
+		}
+	}
+	return out
+}
+
+// metaCloudMessageStatusesFromWebhook lê statuses[] da Cloud API, agrupados
+// por phone_number_id.
+func metaCloudMessageStatusesFromWebhook(body []byte) map[string][]waMessageStatus {
+	var p struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Metadata struct {
+						PhoneNumberID string `json:"phone_number_id"`
+					} `json:"metadata"`
+					Statuses []struct {
+						ID        string `json:"id"`
+						Status    string `json:"status"`
+						Timestamp string `json:"timestamp"`
+						Errors    []struct {
+							Code  int    `json:"code"`
+							Title string `json:"title"`
+						} `json:"errors"`
+					} `json:"statuses"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil
+	}
+	out := map[string][]waMessageStatus{}
+	for _, e := range p.Entry {
+		for _, c := range e.Changes {
+			for _, st := range c.Value.Statuses {
+				status := normalizeWAStatus(st.Status)
+				if st.ID == "" || status == "" {
+					continue
+				}
+				s := waMessageStatus{MessageID: st.ID, Status: status, At: unixAny(st.Timestamp)}
+				if len(st.Errors) > 0 {
+					s.Error = fmt.Sprintf("%d %s", st.Errors[0].Code, st.Errors[0].Title)
+				}
+				id := c.Value.Metadata.PhoneNumberID
+				out[id] = append(out[id], s)
+			}
+		}
+	}
+	return out
+}
+
+// applyWAStatuses atualiza as mensagens da instância e publica as mudanças.
+func (app *App) applyWAStatuses(ctx context.Context, instance string, statuses []waMessageStatus) {
+	for _, st := range statuses {
+		var (
+			id, orgID, flowID int64
+			leadID            *int64
+			to, prev, status  string
+		)
+		err := app.DB.QueryRow(ctx, `
+WITH prev AS (
+  SELECT id, COALESCE(status,'') AS status FROM public.wa_messages
+   WHERE instance_id=$1 AND message_id=$2
+   FOR UPDATE
+)
+UPDATE public.wa_messages m SET
+  status = CASE
+             WHEN $3 = 'failed' THEN $3
+             WHEN COALESCE(array_position(ARRAY['sent','delivered','read'], m.status), 0)
+                  < array_position(ARRAY['sent','delivered','read'], $3) THEN $3
+             ELSE m.status END,
+  status_error = CASE WHEN $3 = 'failed' THEN NULLIF($5,'') ELSE m.status_error END,
+  sent_at      = CASE WHEN $3 <> 'failed' THEN COALESCE(m.sent_at, $4) ELSE m.sent_at END,
+  delivered_at = CASE WHEN $3 IN ('delivered','read') THEN COALESCE(m.delivered_at, $4) ELSE m.delivered_at END,
+  read_at      = CASE WHEN $3 = 'read' THEN COALESCE(m.read_at, $4) ELSE m.read_at END,
+  failed_at    = CASE WHEN $3 = 'failed' THEN COALESCE(m.failed_at, $4) ELSE m.failed_at END,
+  updated_at   = NOW()
+  FROM prev
+ WHERE m.id = prev.id
+RETURNING m.id, m.org_id, m.flow_id, m.lead_id, COALESCE(m.to_number,''), prev.status, COALESCE(m.status,'')`,
+			instance, st.MessageID, st.Status, st.At, st.Error).Scan(&id, &orgID, &flowID, &leadID, &to, &prev, &status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("wa status %s/%s: %v", instance, st.MessageID, err)
+			continue
+		}
+		if status != st.Status || prev == status {
+			continue // repetido ou atrasado (ex.: delivered depois de read)
+		}
+		app.publishEvent(ctx, orgID, eventWAMessageStatus, map[string]any{
+			"instance_id": instance, "flow_id": flowID, "id": id, "message_id": st.MessageID, "lead_id": leadID,
+			"contact": to, "status": st.Status, "error": st.Error, "at": st.At,
+		})
+	}
+}
+
+// recordWAOutbound grava o envio com status "sent" para receber os avisos
+// de entrega. Sem id do provedor não há como casar os status.
+func (app *App) recordWAOutbound(ctx context.Context, row waInstanceRow, suffix string, body map[string]any, messageID string) {
+	if messageID == "" {
+		return
+	}
+	msgType := strings.TrimPrefix(suffix, "/send/")
+	to := onlyDigits(pickStr(body, "to", "number", "phone"))
+	_, err := app.DB.Exec(ctx, `
+INSERT INTO public.wa_messages (org_id, flow_id, instance_id, direction, to_number, message_id, msg_type, body,
+                                status, sent_at, updated_at)
+VALUES ($1, $2, $3, 'out', NULLIF($4,''), $5, $6, NULLIF($7,''), 'sent', NOW(), NOW())
+ON CONFLICT (instance_id, message_id) WHERE message_id IS NOT NULL DO UPDATE
+   SET status  = COALESCE(public.wa_messages.status, 'sent'),
+       sent_at = COALESCE(public.wa_messages.sent_at, NOW())`,
+		row.OrgID, row.FlowID, row.InstanceID, to, messageID, msgType, pickStr(body, "text", "caption"))
+	if err != nil {
+		log.Printf("wa outbound %s/%s: %v", row.InstanceID, messageID, err)
+	}
+}
+
+type waMessageView struct {
+	ID          int64      `json:"id"`
+	InstanceID  string     `json:"instance_id"`
+	MessageID   string     `json:"message_id,omitempty"`
+	Direction   string     `json:"direction"`
+	To          string     `json:"to,omitempty"`
+	From        string     `json:"from,omitempty"`
+	Type        string     `json:"type"`
+	Body        string     `json:"body,omitempty"`
+	LeadID      *int64     `json:"lead_id,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// GET /api/wa/messages/{id}
+func (app *App) getWAMessage(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ref := strings.TrimSpace(chi.URLParam(r, "id"))
+	internalID, _ := strconv.ParseInt(ref, 10, 64)
+	var m waMessageView
+	err = app.DB.QueryRow(r.Context(), `
+SELECT id, COALESCE(instance_id,''), COALESCE(message_id,''), COALESCE(direction,''), COALESCE(to_number,''),
+       COALESCE(from_number,''), COALESCE(msg_type,'text'), COALESCE(body,''), lead_id, COALESCE(status,''),
+       COALESCE(status_error,''), sent_at, delivered_at, read_at, failed_at, created_at
+  FROM public.wa_messages
+ WHERE org_id=$1 AND (id=$2 OR message_id=$3)
+ ORDER BY id=$2 DESC
+ LIMIT 1`, orgID, internalID, ref).Scan(&m.ID, &m.InstanceID, &m.MessageID, &m.Direction, &m.To, &m.From, &m.Type,
+		&m.Body, &m.LeadID, &m.Status, &m.Error, &m.SentAt, &m.DeliveredAt, &m.ReadAt, &m.FailedAt, &m.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "message not found")
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, m)
+}
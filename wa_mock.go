@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Provedor WhatsApp simulado (sandbox)
+// ================================================================
+//
+// Sem UAZAPI_BASE (ou com UAZAPI_MODE=mock) o cliente waprovider fala com
+// waprovider.DefaultMock, em memória. Os eventos que a uazapi mandaria ao
+// webhook (conexão, eco dos envios) entram direto em processWAWebhook, e
+// POST /api/wa/mock/inject simula mensagens recebidas e mudanças de estado:
+//
+//   {"instance":"loja-1","event":"message","from":"5511999999999","text":"oi"}
+//   {"instance":"loja-1","event":"disconnected"}   // connected | qr-expired
+
+func (app *App) mountWAMock(r chi.Router) {
+	if !waprovider.UsingMock() {
+		return
+	}
+	waprovider.DefaultMock.OnEvent = func(instance string, payload []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		app.processWAWebhook(ctx, instance, "uazapi-mock", payload)
+	}
+	r.Post("/mock/inject", app.waMockInject)
+}
+
+// POST /api/wa/mock/inject
+func (app *App) waMockInject(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Instance string `json:"instance"`
+		Token    string `json:"token"`
+		Event    string `json:"event"`
+		From     string `json:"from"`
+		Text     string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	in.Event = strings.TrimSpace(in.Event)
+	if in.Event == "" {
+		in.Event = "message"
+	}
+	if in.Event == "message" && (strings.TrimSpace(in.From) == "" || strings.TrimSpace(in.Text) == "") {
+		render.Error(w, http.StatusBadRequest, "from and text required")
+		return
+	}
+	row, err := app.fetchWAInstance(r.Context(), strings.TrimSpace(in.Instance))
+	if err != nil {
+		render.Error(w, http.StatusNotFound, "instance not found")
+		return
+	}
+	if !app.authorizeInstanceAccess(r, row, in.Token) {
+		render.Error(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	payload, err := waprovider.DefaultMock.Inject(row.InstanceID, in.Event, onlyDigits(in.From), in.Text)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	render.Accepted(w, map[string]any{"ok": true, "payload": json.RawMessage(payload)})
+}
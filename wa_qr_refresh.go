@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Expiração e renovação do QR de conexão
+// ================================================================
+//
+// O QR da uazapi vale poucos segundos e o front mostrava o último lido, já
+// vencido. Agora o servidor guarda o QR (wa_instances.qr_*), com validade
+// WA_QR_TTL (padrão 40s) a partir da primeira leitura, e:
+//
+//   - GET /qr e GET /status devolvem qr_expires_at e qr_expired;
+//   - GET /api/wa/instances/{instance}/qr/stream (SSE) manda "qr" a cada QR
+//     novo, "status" quando conecta e "expired" quando desiste; pede QR novo
+//     (POST /connect no provedor) assim que o atual vence; fica fora do
+//     timeout de 60s das demais rotas (request_timeout.go);
+//   - o worker (WA_QR_REFRESH_INTERVAL, padrão 5s; 0 desliga) consulta o
+//     status das instâncias com a tela de conexão aberta, renova o QR
+//     vencido e publica connected / qr-expired (wa_instance_events.go).
+//
+// "Tela aberta" = alguém leu o QR nos últimos WA_QR_WATCH (padrão 2m). Sem
+// ninguém olhando o QR vencido vira qr-expired e não é renovado; depois de
+// WA_QR_MAX_REFRESHES (padrão 6) renovações seguidas também, até a próxima
+// leitura. Réplicas disputam a renovação pelo próprio qr_expires_at.
+// Instâncias da API oficial não têm QR.
+
+func waQRTTL() time.Duration {
+	if d, err := time.ParseDuration(getenv("WA_QR_TTL", "40s")); err == nil && d > 0 {
+		return d
+	}
+	return 40 * time.Second
+}
+
+func waQRWatch() time.Duration {
+	if d, err := time.ParseDuration(getenv("WA_QR_WATCH", "2m")); err == nil && d > 0 {
+		return d
+	}
+	return 2 * time.Minute
+}
+
+func waQRRefreshInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("WA_QR_REFRESH_INTERVAL", "5s"))
+	if err != nil || d < 0 {
+		return 5 * time.Second
+	}
+	return d
+}
+
+func waQRMaxRefreshes() int {
+	n, err := strconv.Atoi(getenv("WA_QR_MAX_REFRESHES", "6"))
+	if err != nil || n < 0 {
+		return 6
+	}
+	return n
+}
+
+// waQRState é o QR guardado da instância.
+type waQRState struct {
+	Instance  string     `json:"instance"`
+	State     string     `json:"state,omitempty"`
+	QRCode    string     `json:"qrcode,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+	Refreshes int        `json:"refreshes"`
+}
+
+func (s waQRState) exhausted() bool {
+	return s.Expired && s.Refreshes >= waQRMaxRefreshes()
+}
+
+const waQRCols = `instance_id, COALESCE(state,''), COALESCE(qr_code,''), qr_issued_at, qr_expires_at,
+       COALESCE(qr_expires_at <= NOW(), FALSE), qr_refreshes`
+
+func scanWAQR(row interface{ Scan(...any) error }) (waQRState, error) {
+	var s waQRState
+	err := row.Scan(&s.Instance, &s.State, &s.QRCode, &s.IssuedAt, &s.ExpiresAt, &s.Expired, &s.Refreshes)
+	return s, err
+}
+
+func (app *App) loadWAQR(ctx context.Context, instance string) (waQRState, error) {
+	return scanWAQR(app.DB.QueryRow(ctx, `SELECT `+waQRCols+` FROM public.wa_instances WHERE instance_id=$1`, instance))
+}
+
+// qrFromProvider acha o QR na resposta de /qr, /status ou /connect.
+func qrFromProvider(data map[string]any) string {
+	if qr := pickStr(data, "qrcode", "qr", "base64"); qr != "" {
+		return qr
+	}
+	for _, k := range []string{"connect", "instance"} {
+		if m, ok := data[k].(map[string]any); ok {
+			if qr := pickStr(m, "qrcode", "qr", "base64"); qr != "" {
+				return qr
+			}
+		}
+	}
+	return ""
+}
+
+// providerStatusOf lê o status da resposta do provedor (status, state ou
+// connect.status).
+func providerStatusOf(data map[string]any) string {
+	if c, ok := data["connect"].(map[string]any); ok {
+		if s := pickStr(c, "status", "state"); s != "" {
+			return s
+		}
+	}
+	return pickStr(data, "status", "state")
+}
+
+// storeWAQR grava o QR lido do provedor. O mesmo QR mantém a validade da
+// primeira leitura; um QR diferente começa outra. refreshed conta a
+// renovação pedida por nós.
+func (app *App) storeWAQR(ctx context.Context, instance, qr string, refreshed bool) (waQRState, error) {
+	return scanWAQR(app.DB.QueryRow(ctx, `
+UPDATE public.wa_instances SET
+  qr_issued_at  = CASE WHEN qr_code IS DISTINCT FROM $2 THEN NOW() ELSE qr_issued_at END,
+  qr_expires_at = CASE WHEN qr_code IS DISTINCT FROM $2 THEN NOW() + make_interval(secs => $3) ELSE qr_expires_at END,
+  qr_refreshes  = qr_refreshes + CASE WHEN $4 THEN 1 ELSE 0 END,
+  qr_code       = $2
+ WHERE instance_id=$1
+RETURNING `+waQRCols, instance, qr, waQRTTL().Seconds(), refreshed))
+}
+
+// watchWAQR marca a tela de conexão como aberta. Uma leitura depois de a
+// janela fechar zera o contador de renovações.
+func (app *App) watchWAQR(ctx context.Context, instance string) {
+	_, err := app.DB.Exec(ctx, `
+UPDATE public.wa_instances SET
+  qr_refreshes     = CASE WHEN qr_watched_until IS NULL OR qr_watched_until < NOW() THEN 0 ELSE qr_refreshes END,
+  qr_watched_until = NOW() + make_interval(secs => $2)
+ WHERE instance_id=$1`, instance, waQRWatch().Seconds())
+	if err != nil {
+		log.Printf("wa qr %s: watch: %v", instance, err)
+	}
+}
+
+// clearWAQR esquece o QR (conectou ou desistimos).
+func (app *App) clearWAQR(ctx context.Context, instance string) {
+	_, _ = app.DB.Exec(ctx, `
+UPDATE public.wa_instances SET qr_code=NULL, qr_expires_at=NULL, qr_watched_until=NULL WHERE instance_id=$1`, instance)
+}
+
+// waQRCall chama o provedor e decodifica a resposta.
+func (app *App) waQRCall(ctx context.Context, row waInstanceRow, method, suffix string) (map[string]any, error) {
+	q := url.Values{}
+	if row.Token != "" {
+		q.Set("token", row.Token)
+	}
+	resp, err := app.uazapiFor(ctx, row.OrgID).DoInstance(ctx, method, waprovider.InstancePath(row.InstanceID, suffix), row.Token, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var data map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&data)
+	if resp.StatusCode/100 != 2 {
+		return data, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if data == nil {
+		data = map[string]any{}
+	}
+	return data, nil
+}
+
+var errWAQRBusy = errors.New("qr refresh in progress")
+
+var waQRGaveUp = waStateCause{Source: "qr", Reason: "qr not scanned after max refreshes"}
+
+// refreshWAQR pede um QR novo ao provedor se o atual venceu. Só uma réplica
+// ganha a vez: a que empurra qr_expires_at primeiro.
+func (app *App) refreshWAQR(ctx context.Context, row waInstanceRow) (waQRState, error) {
+	tag, err := app.DB.Exec(ctx, `
+UPDATE public.wa_instances SET qr_expires_at = NOW() + make_interval(secs => $2)
+ WHERE instance_id=$1 AND (qr_expires_at IS NULL OR qr_expires_at <= NOW())`, row.InstanceID, waQRTTL().Seconds())
+	if err != nil {
+		return waQRState{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return waQRState{}, errWAQRBusy
+	}
+	data, err := app.waQRCall(ctx, row, http.MethodPost, "/connect")
+	if err != nil {
+		// devolve a vez para a próxima tentativa
+		_, _ = app.DB.Exec(ctx, `UPDATE public.wa_instances SET qr_expires_at=NOW() WHERE instance_id=$1`, row.InstanceID)
+		return waQRState{}, err
+	}
+	if normalizeWAState(providerStatusOf(data)) == waStateConnected {
+		app.handleWAStateChange(ctx, row.InstanceID, waStateConnected, waStateCause{Source: "qr"}, waRowInfo(row))
+		app.clearWAQR(ctx, row.InstanceID)
+		return app.loadWAQR(ctx, row.InstanceID)
+	}
+	qr := qrFromProvider(data)
+	if qr == "" {
+		// algumas versões só devolvem o QR em /qr
+		if data, err = app.waQRCall(ctx, row, http.MethodGet, "/qr"); err == nil {
+			qr = qrFromProvider(data)
+		}
+	}
+	if qr == "" {
+		return app.loadWAQR(ctx, row.InstanceID)
+	}
+	return app.storeWAQR(ctx, row.InstanceID, qr, true)
+}
+
+// noteWAQR guarda o QR de uma resposta de /qr ou /status e devolve a
+// validade para o front. Conectada, esquece o QR.
+func (app *App) noteWAQR(ctx context.Context, row waInstanceRow, data map[string]any) {
+	if normalizeWAState(providerStatusOf(data)) == waStateConnected {
+		app.clearWAQR(ctx, row.InstanceID)
+		return
+	}
+	qr := qrFromProvider(data)
+	if qr == "" {
+		return
+	}
+	s, err := app.storeWAQR(ctx, row.InstanceID, qr, false)
+	if err != nil {
+		log.Printf("wa qr %s: %v", row.InstanceID, err)
+		return
+	}
+	data["qr_expires_at"] = s.ExpiresAt
+	data["qr_expired"] = s.Expired
+}
+
+// GET /api/wa/instances/{instance}/qr/stream
+func (app *App) waInstanceQRStream(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.routeWAInstance(w, r) // wa_instance_access.go
+	if !ok {
+		return
+	}
+	if row.Provider == waProviderMetaCloud {
+		render.Error(w, http.StatusBadRequest, "official API instances have no QR code")
+		return
+	}
+	// o ResponseController atravessa os wrappers dos middlewares (Unwrap)
+	flusher := http.NewResponseController(w)
+	ctx := r.Context()
+	app.watchWAQR(ctx, row.InstanceID)
+	// começa pelo QR atual do provedor, se ainda não temos um válido
+	if s, err := app.loadWAQR(ctx, row.InstanceID); err == nil && (s.QRCode == "" || s.Expired) {
+		if data, err := app.waQRCall(ctx, row, http.MethodGet, "/qr"); err == nil {
+			app.noteWAQR(ctx, row, data)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 2000\n\n")
+	if err := flusher.Flush(); err != nil {
+		return // writer sem flush: não há como fazer stream
+	}
+	send := func(event string, v any) {
+		body, _ := json.Marshal(v)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+		flusher.Flush()
+	}
+
+	// polling no banco: o worker e as outras réplicas também renovam
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	lastWatch := time.Now()
+	lastQR := ""
+	for {
+		s, err := app.loadWAQR(ctx, row.InstanceID)
+		if err != nil {
+			return
+		}
+		switch {
+		case s.State == waStateConnected && s.QRCode == "":
+			send("status", map[string]any{"instance": row.InstanceID, "status": waStateConnected})
+			return
+		case s.exhausted():
+			app.handleWAStateChange(ctx, row.InstanceID, waStateQRExpired, waQRGaveUp, waRowInfo(row))
+			app.clearWAQR(ctx, row.InstanceID)
+			send("expired", s)
+			return
+		case s.QRCode == "" || s.Expired:
+			if s, err = app.refreshWAQR(ctx, row); err != nil && !errors.Is(err, errWAQRBusy) {
+				log.Printf("wa qr %s: refresh: %v", row.InstanceID, err)
+			}
+		}
+		if s.QRCode != "" && s.QRCode != lastQR && !s.Expired {
+			send("qr", s)
+			lastQR = s.QRCode
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if time.Since(lastWatch) > waQRWatch()/2 {
+			app.watchWAQR(ctx, row.InstanceID)
+			lastWatch = time.Now()
+		}
+	}
+}
+
+// waQRRefreshLoop acompanha as instâncias aguardando leitura do QR.
+func (app *App) waQRRefreshLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), every+10*time.Second)
+		app.expireUnwatchedWAQR(ctx)
+		rows, err := app.DB.Query(ctx, `
+SELECT instance_id FROM public.wa_instances
+ WHERE qr_watched_until > NOW() AND deleted_at IS NULL AND provider <> $1
+   AND COALESCE(state,'') <> $2`, waProviderMetaCloud, waStateConnected)
+		if err != nil {
+			cancel()
+			log.Printf("wa qr poll: %v", err)
+			continue
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		for _, id := range ids {
+			app.pollWAQR(ctx, id)
+		}
+		cancel()
+	}
+}
+
+// pollWAQR consulta o status de uma instância com a tela aberta: publica a
+// conexão, detecta o QR vencido e pede outro.
+func (app *App) pollWAQR(ctx context.Context, instance string) {
+	if waprovider.BackoffFor(instance).Active {
+		return
+	}
+	row, err := app.fetchWAInstance(ctx, instance)
+	if err != nil {
+		return
+	}
+	data, err := app.waQRCall(ctx, row, http.MethodGet, "/status")
+	if err != nil {
+		log.Printf("wa qr poll %s: %v", instance, err)
+		return
+	}
+	state := normalizeWAState(providerStatusOf(data))
+	if state == waStateConnected {
+		app.handleWAStateChange(ctx, instance, state, waStateCause{Source: "poll"}, waRowInfo(row))
+		app.clearWAQR(ctx, instance)
+		return
+	}
+	if qr := qrFromProvider(data); qr != "" {
+		if _, err := app.storeWAQR(ctx, instance, qr, false); err != nil {
+			log.Printf("wa qr poll %s: %v", instance, err)
+		}
+	}
+	s, err := app.loadWAQR(ctx, instance)
+	if err != nil {
+		return
+	}
+	if state == waStateQRExpired || s.Expired || s.QRCode == "" {
+		if s.Refreshes >= waQRMaxRefreshes() {
+			app.handleWAStateChange(ctx, instance, waStateQRExpired, waQRGaveUp, waRowInfo(row))
+			app.clearWAQR(ctx, instance)
+			return
+		}
+		if _, err := app.refreshWAQR(ctx, row); err != nil && !errors.Is(err, errWAQRBusy) {
+			log.Printf("wa qr poll %s: refresh: %v", instance, err)
+		}
+	}
+}
+
+// expireUnwatchedWAQR publica qr-expired para os QRs vencidos sem ninguém
+// olhando; eles não são renovados.
+func (app *App) expireUnwatchedWAQR(ctx context.Context) {
+	rows, err := app.DB.Query(ctx, `
+UPDATE public.wa_instances SET qr_code=NULL, qr_watched_until=NULL
+ WHERE qr_code IS NOT NULL AND qr_expires_at <= NOW()
+   AND (qr_watched_until IS NULL OR qr_watched_until <= NOW())
+   AND deleted_at IS NULL AND COALESCE(state,'') <> $1
+RETURNING instance_id, COALESCE(token,''), org_id, flow_id`, waStateConnected)
+	if err != nil {
+		log.Printf("wa qr expire: %v", err)
+		return
+	}
+	var rs []waInstanceRow
+	for rows.Next() {
+		var row waInstanceRow
+		if err := rows.Scan(&row.InstanceID, &row.Token, &row.OrgID, &row.FlowID); err == nil {
+			rs = append(rs, row)
+		}
+	}
+	rows.Close()
+	for _, row := range rs {
+		app.handleWAStateChange(ctx, row.InstanceID, waStateQRExpired, waStateCause{Source: "qr", Reason: "qr not scanned"}, waRowInfo(row))
+	}
+}
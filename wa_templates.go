@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Templates de mensagem (API oficial)
+// ================================================================
+//
+// Fora da janela de 24h a Cloud API só aceita templates aprovados. O fluxo:
+//   POST /api/wa/instances/{instance}/templates        envia para aprovação (PENDING)
+//   POST /api/wa/instances/{instance}/templates/sync   consulta o status na Meta
+//   GET  /api/wa/instances/{instance}/templates?status=APPROVED
+//   POST /api/wa/instances/{instance}/send/template    envia (só APPROVED)
+// Os PENDING também são consultados a cada META_TEMPLATE_POLL_INTERVAL
+// (padrão 10m; 0 desliga).
+
+// Código da Graph API para mensagem livre fora da janela de atendimento.
+const metaErrOutsideWindow = 131047
+
+var waTemplateNameRe = regexp.MustCompile(`^[a-z0-9_]{1,512}$`)
+
+type waTemplate struct {
+	ID             int64           `json:"id"`
+	Name           string          `json:"name"`
+	Language       string          `json:"language"`
+	Category       string          `json:"category"`
+	Components     json.RawMessage `json:"components"`
+	MetaTemplateID string          `json:"meta_template_id,omitempty"`
+	Status         string          `json:"status"`
+	RejectedReason string          `json:"rejected_reason,omitempty"`
+	SubmittedAt    time.Time       `json:"submitted_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// waWindowError: envio livre recusado por estar fora da janela de 24h.
+type waWindowError struct {
+	To            string
+	LastInboundAt *time.Time
+	Templates     []waTemplate
+}
+
+func (e *waWindowError) Error() string {
+	return "outside the 24h customer service window: use an approved template"
+}
+
+// writeWASendError responde erros de envio; fora da janela devolve JSON
+// estruturado com os templates aprovados para o front oferecer a troca.
+func writeWASendError(w http.ResponseWriter, err error, status int) {
+	var we *waWindowError
+	if !errors.As(err, &we) {
+		render.Error(w, status, err.Error())
+		return
+	}
+	templates := we.Templates
+	if templates == nil {
+		templates = []waTemplate{}
+	}
+	render.APIError(w, http.StatusConflict, "outside_24h_window", we.Error(), map[string]any{
+		"to":              we.To,
+		"last_inbound_at": we.LastInboundAt,
+		"suggestion":      "send_template",
+		"templates":       templates,
+	})
+}
+
+func (app *App) outsideWindowError(ctx context.Context, row waInstanceRow, to string, last *time.Time) error {
+	list, err := app.listWATemplates(ctx, row.InstanceID, "APPROVED")
+	if err != nil {
+		log.Printf("wa templates %s: %v", row.InstanceID, err)
+	}
+	return &waWindowError{To: onlyDigits(to), LastInboundAt: last, Templates: list}
+}
+
+func (app *App) listWATemplates(ctx context.Context, instanceID, status string) ([]waTemplate, error) {
+	rows, err := app.DB.Query(ctx, `
+SELECT id, name, language, category, components, COALESCE(meta_template_id,''), status,
+       COALESCE(rejected_reason,''), submitted_at, updated_at
+  FROM public.wa_templates
+ WHERE instance_id=$1 AND ($2='' OR status=$2)
+ ORDER BY name, language`, instanceID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []waTemplate{}
+	for rows.Next() {
+		var t waTemplate
+		if err := rows.Scan(&t.ID, &t.Name, &t.Language, &t.Category, &t.Components, &t.MetaTemplateID,
+			&t.Status, &t.RejectedReason, &t.SubmittedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// metaInstanceFromRequest pega a instância da rota (wa_instance_access.go) e
+// exige que seja da API oficial.
+func (app *App) metaInstanceFromRequest(w http.ResponseWriter, r *http.Request) (waInstanceRow, bool) {
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
+		return row, false
+	}
+	if row.Provider != waProviderMetaCloud {
+		render.Error(w, http.StatusBadRequest, "templates are only available on official API (meta_cloud) instances")
+		return row, false
+	}
+	return row, true
+}
+
+// GET /api/wa/instances/{instance}/templates?status=
+func (app *App) waListTemplates(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.metaInstanceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	list, err := app.listWATemplates(r.Context(), row.InstanceID, strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("status"))))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"items": list})
+}
+
+// POST /api/wa/instances/{instance}/templates  {name, language, category, components}
+func (app *App) waSubmitTemplate(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name       string          `json:"name"`
+		Language   string          `json:"language"`
+		Category   string          `json:"category"`
+		Components json.RawMessage `json:"components"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	row, ok := app.metaInstanceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	in.Language = chooseFirstNonEmpty(strings.TrimSpace(in.Language), "pt_BR")
+	in.Category = strings.ToUpper(strings.TrimSpace(in.Category))
+	if !waTemplateNameRe.MatchString(in.Name) {
+		render.Error(w, http.StatusBadRequest, "name must be lowercase letters, digits and underscores")
+		return
+	}
+	switch in.Category {
+	case "MARKETING", "UTILITY", "AUTHENTICATION":
+	default:
+		render.Error(w, http.StatusBadRequest, "category must be MARKETING, UTILITY or AUTHENTICATION")
+		return
+	}
+	var components []any
+	if err := json.Unmarshal(in.Components, &components); err != nil || len(components) == 0 {
+		render.Error(w, http.StatusBadRequest, "components must be a non-empty array")
+		return
+	}
+
+	var resp struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err := metaGraphDo(r.Context(), http.MethodPost, "/"+row.MetaWABAID+"/message_templates", row.MetaToken, map[string]any{
+		"name":       in.Name,
+		"language":   in.Language,
+		"category":   in.Category,
+		"components": components,
+	}, &resp)
+	if err != nil {
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	var t waTemplate
+	err = app.DB.QueryRow(r.Context(), `
+INSERT INTO public.wa_templates (org_id, flow_id, instance_id, name, language, category, components, meta_template_id, status)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (instance_id, name, language) DO UPDATE SET
+  category=EXCLUDED.category, components=EXCLUDED.components, meta_template_id=EXCLUDED.meta_template_id,
+  status=EXCLUDED.status, rejected_reason=NULL, submitted_at=NOW(), updated_at=NOW()
+RETURNING id, name, language, category, components, COALESCE(meta_template_id,''), status, submitted_at, updated_at`,
+		row.OrgID, row.FlowID, row.InstanceID, in.Name, in.Language, in.Category, []byte(in.Components),
+		resp.ID, chooseFirstNonEmpty(strings.ToUpper(resp.Status), "PENDING")).
+		Scan(&t.ID, &t.Name, &t.Language, &t.Category, &t.Components, &t.MetaTemplateID, &t.Status, &t.SubmittedAt, &t.UpdatedAt)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Created(w, t)
+}
+
+// POST /api/wa/instances/{instance}/templates/sync
+func (app *App) waSyncTemplates(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.metaInstanceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	n, err := app.syncWATemplates(r.Context(), row)
+	if err != nil {
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	list, err := app.listWATemplates(r.Context(), row.InstanceID, "")
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"updated": n, "items": list})
+}
+
+// syncWATemplates lê os templates da WABA e atualiza status/motivo de recusa
+// dos que foram submetidos por aqui. Devolve quantos mudaram.
+func (app *App) syncWATemplates(ctx context.Context, row waInstanceRow) (int, error) {
+	type graphTemplate struct {
+		ID             string `json:"id"`
+		Name           string `json:"name"`
+		Language       string `json:"language"`
+		Status         string `json:"status"`
+		RejectedReason string `json:"rejected_reason"`
+	}
+	var all []graphTemplate
+	after := ""
+	for page := 0; page < 20; page++ {
+		path := "/" + row.MetaWABAID + "/message_templates?fields=id,name,language,status,rejected_reason&limit=250"
+		if after != "" {
+			path += "&after=" + url.QueryEscape(after)
+		}
+		var resp struct {
+			Data   []graphTemplate `json:"data"`
+			Paging struct {
+				Next    string `json:"next"`
+				Cursors struct {
+					After string `json:"after"`
+				} `json:"cursors"`
+			} `json:"paging"`
+		}
+		if err := metaGraphDo(ctx, http.MethodGet, path, row.MetaToken, nil, &resp); err != nil {
+			return 0, err
+		}
+		all = append(all, resp.Data...)
+		if resp.Paging.Next == "" || resp.Paging.Cursors.After == "" {
+			break
+		}
+		after = resp.Paging.Cursors.After
+	}
+
+	changed := 0
+	for _, t := range all {
+		reason := t.RejectedReason
+		if reason == "NONE" {
+			reason = ""
+		}
+		tag, err := app.DB.Exec(ctx, `
+UPDATE public.wa_templates
+   SET status=$4, rejected_reason=NULLIF($5,''), meta_template_id=COALESCE(meta_template_id, NULLIF($6,'')), updated_at=NOW()
+ WHERE instance_id=$1 AND name=$2 AND language=$3
+   AND (status IS DISTINCT FROM $4 OR COALESCE(rejected_reason,'') IS DISTINCT FROM $5)`,
+			row.InstanceID, t.Name, t.Language, strings.ToUpper(t.Status), reason, t.ID)
+		if err != nil {
+			return changed, err
+		}
+		changed += int(tag.RowsAffected())
+	}
+	return changed, nil
+}
+
+// POST /api/wa/instances/{instance}/send/template
+// {to, name, language?, components?}  components = parâmetros do envio
+// (header/body/button), no formato da Cloud API.
+func (app *App) waSendTemplate(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		To         string          `json:"to"`
+		Name       string          `json:"name"`
+		Language   string          `json:"language"`
+		Components json.RawMessage `json:"components"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if strings.TrimSpace(in.To) == "" || strings.TrimSpace(in.Name) == "" {
+		render.Error(w, http.StatusBadRequest, "missing to/name")
+		return
+	}
+	row, ok := app.metaInstanceFromRequest(w, r)
+	if !ok {
+		return
+	}
+	out, code, err := app.sendWATemplate(r.Context(), row, row.Token, in.To, in.Name, in.Language, in.Components)
+	switch {
+	case errors.Is(err, errWATemplateNotFound):
+		render.Error(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errWATemplateNotApproved):
+		render.Error(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeWASendError(w, err, code)
+	default:
+		render.OK(w, out)
+	}
+}
+
+var (
+	errWATemplateNotFound    = errors.New("template not found")
+	errWATemplateNotApproved = errors.New("only APPROVED templates can be sent")
+)
+
+// sendWATemplate envia um template aprovado da instância (API oficial).
+// language vazio usa o idioma aprovado disponível.
+func (app *App) sendWATemplate(ctx context.Context, row waInstanceRow, token, to, name, language string, components json.RawMessage) (map[string]any, int, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	var status string
+	err := app.DB.QueryRow(ctx, `
+SELECT language, status FROM public.wa_templates
+ WHERE instance_id=$1 AND name=$2 AND ($3='' OR language=$3)
+ ORDER BY (status='APPROVED') DESC, language LIMIT 1`,
+		row.InstanceID, name, strings.TrimSpace(language)).Scan(&language, &status)
+	if err != nil {
+		return nil, 0, errWATemplateNotFound
+	}
+	if status != "APPROVED" {
+		return nil, 0, fmt.Errorf("template is %s; %w", status, errWATemplateNotApproved)
+	}
+
+	tpl := map[string]any{
+		"name":     name,
+		"language": map[string]any{"code": language},
+	}
+	if len(components) > 0 && string(components) != "null" {
+		tpl["components"] = components
+	}
+	return app.waProviderSend(ctx, row, token, "/send/template", map[string]any{
+		"to":       to,
+		"template": tpl,
+	})
+}
+
+// waTemplatePollLoop atualiza periodicamente as instâncias com templates PENDING.
+func (app *App) waTemplatePollLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), every)
+		rows, err := app.DB.Query(ctx, `
+SELECT DISTINCT instance_id FROM public.wa_templates WHERE status='PENDING'`)
+		if err != nil {
+			cancel()
+			log.Printf("wa template poll: %v", err)
+			continue
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		for _, id := range ids {
+			row, err := app.fetchWAInstance(ctx, id)
+			if err != nil || row.Provider != waProviderMetaCloud {
+				continue
+			}
+			if _, err := app.syncWATemplates(ctx, row); err != nil {
+				log.Printf("wa template poll %s: %v", id, err)
+			}
+		}
+		cancel()
+	}
+}
+
+func waTemplatePollInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("META_TEMPLATE_POLL_INTERVAL", "10m"))
+	if err != nil || d < 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
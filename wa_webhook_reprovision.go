@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/paclead/backend/render"
+	"github.com/paclead/backend/waprovider"
+)
+
+// ================================================================
+//  Reprovisionamento do webhook após reconexão
+// ================================================================
+//
+// Alguns provedores perdem a configuração de webhook quando o número faz
+// login de novo. Quando a instância volta para "connected" (ou via
+// POST /api/wa/instances/{instance}/webhook/reprovision) o webhook da
+// plataforma é registrado outra vez e verificado com um evento de teste:
+// um POST com nonce para a própria URL, que webhookWa reconhece e marca em
+// wa_instances.webhook_verified_at.
+//
+// URL registrada: wa_instances.webhook_url ou, se vazia,
+// PUBLIC_API_URL + /api/webhooks/wa/{instance}.
+
+const waWebhookTestEvent = "platform.webhook_test"
+
+// platformWebhookURL devolve a URL que o provedor deve chamar para a instância.
+func platformWebhookURL(row waInstanceRow) string {
+	if u := strings.TrimSpace(row.WebhookURL); u != "" {
+		return u
+	}
+	base := strings.TrimRight(getenv("PUBLIC_API_URL", ""), "/")
+	if base == "" {
+		return ""
+	}
+	return base + "/api/webhooks/wa/" + row.InstanceID
+}
+
+// reprovisionWebhook registra de novo o webhook no provedor e o verifica.
+func (app *App) reprovisionWebhook(ctx context.Context, instance string) error {
+	row, err := app.fetchWAInstance(ctx, instance)
+	if err != nil {
+		return err
+	}
+	hookURL := platformWebhookURL(row)
+	if hookURL == "" {
+		return errors.New("no webhook url (set webhook_url or PUBLIC_API_URL)")
+	}
+
+	err = app.registerWebhook(ctx, row, hookURL)
+	if err == nil {
+		err = app.verifyWebhook(ctx, row, hookURL)
+	}
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	_, _ = app.DB.Exec(ctx,
+		`UPDATE public.wa_instances SET webhook_error=NULLIF($2,''), updated_at=NOW() WHERE instance_id=$1`,
+		instance, errText)
+	return err
+}
+
+func (app *App) registerWebhook(ctx context.Context, row waInstanceRow, hookURL string) error {
+	uaz := app.uazapiFor(ctx, row.OrgID)
+	resp, err := uaz.DoInstance(ctx, http.MethodPost, waprovider.InstancePath(row.InstanceID, "/webhook"), row.Token, nil, map[string]any{
+		"url":     hookURL,
+		"enabled": true,
+	})
+	if err != nil {
+		return fmt.Errorf("register webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("register webhook: provider status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// verifyWebhook envia o evento de teste e confirma que ele foi recebido.
+func (app *App) verifyWebhook(ctx context.Context, row waInstanceRow, hookURL string) error {
+	nonce := secureToken(16)
+	if _, err := app.DB.Exec(ctx,
+		`UPDATE public.wa_instances SET webhook_test_nonce=$2 WHERE instance_id=$1`, row.InstanceID, nonce); err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(map[string]any{
+		"EventType": waWebhookTestEvent,
+		"instance":  row.InstanceID,
+		"nonce":     nonce,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook test event: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook test event: status %d", resp.StatusCode)
+	}
+
+	var verified bool
+	err = app.DB.QueryRow(ctx, `
+SELECT webhook_test_nonce IS NULL AND webhook_verified_at > NOW() - INTERVAL '1 minute'
+  FROM public.wa_instances WHERE instance_id=$1`, row.InstanceID).Scan(&verified)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return errors.New("webhook test event not received")
+	}
+	return nil
+}
+
+// consumeWebhookTest trata o evento de teste em webhookWa. Devolve true se
+// o payload era um evento de teste (que não deve ser encaminhado).
+func (app *App) consumeWebhookTest(ctx context.Context, instance string, body []byte) bool {
+	var p struct {
+		EventType string `json:"EventType"`
+		Nonce     string `json:"nonce"`
+	}
+	if json.Unmarshal(body, &p) != nil || p.EventType != waWebhookTestEvent {
+		return false
+	}
+	if p.Nonce != "" {
+		_, _ = app.DB.Exec(ctx, `
+UPDATE public.wa_instances SET webhook_test_nonce=NULL, webhook_verified_at=NOW()
+ WHERE instance_id=$1 AND webhook_test_nonce=$2`, instance, p.Nonce)
+	}
+	return true
+}
+
+// reprovisionWebhookAsync roda após uma reconexão, fora da requisição.
+func (app *App) reprovisionWebhookAsync(instance string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := app.reprovisionWebhook(ctx, instance); err != nil {
+			log.Printf("wa webhook reprovision %s: %v", instance, err)
+			return
+		}
+		log.Printf("wa webhook reprovisioned %s", instance)
+	}()
+}
+
+// POST /api/wa/instances/{instance}/webhook/reprovision
+func (app *App) waReprovisionWebhook(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
+		return
+	}
+	instance := row.InstanceID
+	if err := app.reprovisionWebhook(r.Context(), instance); err != nil {
+		render.Error(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"ok": true, "url": platformWebhookURL(row), "verified": true})
+}
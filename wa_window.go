@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Janela de atendimento de 24h
+// ================================================================
+//
+// Cada mensagem recebida atualiza wa_contact_windows (instância + contato).
+// Nas instâncias da API oficial, texto/mídia livre só sai se o contato
+// escreveu nas últimas 24h; fora disso o envio é recusado com 409 e a lista
+// de templates aprovados (ver writeWASendError). Nas instâncias uazapi a
+// janela é só informativa.
+//
+// Webhook da Cloud API: GET/POST /api/webhooks/meta
+//   META_WEBHOOK_VERIFY_TOKEN  token do desafio hub.verify_token
+//   META_APP_SECRET            valida X-Hub-Signature-256 (recomendado)
+
+const waServiceWindow = 24 * time.Hour
+
+// recordInbound registra a mensagem recebida do contato.
+func (app *App) recordInbound(ctx context.Context, instance, contact string, at time.Time) {
+	if contact == "" {
+		return
+	}
+	_, err := app.DB.Exec(ctx, `
+INSERT INTO public.wa_contact_windows (instance_id, contact, org_id, flow_id, last_inbound_at)
+SELECT instance_id, $2, org_id, flow_id, $3 FROM public.wa_instances WHERE instance_id=$1
+ON CONFLICT (instance_id, contact) DO UPDATE
+  SET last_inbound_at = GREATEST(public.wa_contact_windows.last_inbound_at, EXCLUDED.last_inbound_at)`,
+		instance, contact, at)
+	if err != nil {
+		log.Printf("wa window %s: %v", instance, err)
+	}
+}
+
+// lastInbound devolve a última mensagem recebida do contato (nil se nunca).
+func (app *App) lastInbound(ctx context.Context, instance, contact string) (*time.Time, error) {
+	var at time.Time
+	err := app.DB.QueryRow(ctx,
+		`SELECT last_inbound_at FROM public.wa_contact_windows WHERE instance_id=$1 AND contact=$2`,
+		instance, onlyDigits(contact)).Scan(&at)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &at, nil
+}
+
+func windowOpen(last *time.Time) bool {
+	return last != nil && time.Since(*last) < waServiceWindow
+}
+
+// checkServiceWindow é chamado antes de envios livres na API oficial.
+func (app *App) checkServiceWindow(ctx context.Context, row waInstanceRow, to string) error {
+	last, err := app.lastInbound(ctx, row.InstanceID, to)
+	if err != nil {
+		// sem como saber: deixa a Meta decidir (131047 cai no mesmo erro)
+		log.Printf("wa window %s: %v", row.InstanceID, err)
+		return nil
+	}
+	if windowOpen(last) {
+		return nil
+	}
+	return app.outsideWindowError(ctx, row, to, last)
+}
+
+// GET /api/wa/instances/{instance}/window?to=5511999999999
+func (app *App) waContactWindow(w http.ResponseWriter, r *http.Request) {
+	row, ok := app.routeWAInstance(w, r)
+	if !ok {
+		return
+	}
+	to := onlyDigits(r.URL.Query().Get("to"))
+	if to == "" {
+		render.Error(w, http.StatusBadRequest, "missing to")
+		return
+	}
+	last, err := app.lastInbound(r.Context(), row.InstanceID, to)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := map[string]any{
+		"instance":          row.InstanceID,
+		"to":                to,
+		"last_inbound_at":   last,
+		"open":              windowOpen(last),
+		"enforced":          row.Provider == waProviderMetaCloud,
+		"requires_template": row.Provider == waProviderMetaCloud && !windowOpen(last),
+	}
+	if last != nil {
+		out["expires_at"] = last.Add(waServiceWindow)
+	}
+	render.OK(w, out)
+}
+
+// GET /api/webhooks/meta — verificação da assinatura do webhook na Meta.
+func (app *App) webhookMetaVerify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	want := getenv("META_WEBHOOK_VERIFY_TOKEN", "")
+	if q.Get("hub.mode") != "subscribe" || want == "" || q.Get("hub.verify_token") != want {
+		render.Error(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = io.WriteString(w, q.Get("hub.challenge"))
+}
+
+// POST /api/webhooks/meta — eventos da Cloud API de todas as instâncias
+// oficiais; a instância é identificada pelo phone_number_id.
+func (app *App) webhookMeta(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 5<<20))
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "read error")
+		return
+	}
+	if secret := getenv("META_APP_SECRET", ""); secret != "" {
+		m := hmac.New(sha256.New, []byte(secret))
+		m.Write(body)
+		want := "sha256=" + hex.EncodeToString(m.Sum(nil))
+		if !hmac.Equal([]byte(want), []byte(r.Header.Get("X-Hub-Signature-256"))) {
+			render.Error(w, http.StatusUnauthorized, "invalid signature")
+			return
+		}
+	}
+
+	ctx := r.Context()
+	msgs := metaCloudMessagesFromWebhook(body)
+	statuses := metaCloudStatusesFromWebhook(body)
+	delivery := metaCloudMessageStatusesFromWebhook(body)
+	phoneIDs := map[string]bool{}
+	for id := range msgs {
+		phoneIDs[id] = true
+	}
+	for id := range statuses {
+		phoneIDs[id] = true
+	}
+	for id := range delivery {
+		phoneIDs[id] = true
+	}
+	for phoneID := range phoneIDs {
+		var instance string
+		err := app.DB.QueryRow(ctx,
+			`SELECT instance_id FROM public.wa_instances WHERE provider=$1 AND meta_phone_number_id=$2 AND deleted_at IS NULL LIMIT 1`,
+			waProviderMetaCloud, phoneID).Scan(&instance)
+		if err != nil {
+			log.Printf("meta webhook: unknown phone_number_id %q", phoneID)
+			continue
+		}
+		for _, st := range statuses[phoneID] {
+			app.recordBillableConversation(ctx, instance, st)
+		}
+		app.applyWAStatuses(ctx, instance, delivery[phoneID])
+		if len(msgs[phoneID]) > 0 {
+			app.ingestWAMessages(ctx, instance, msgs[phoneID])
+			app.processWAWebhook(ctx, instance, waProviderMetaCloud, body)
+		}
+	}
+	// a Meta reenvia enquanto não receber 200
+	w.WriteHeader(http.StatusOK)
+}
+
+// isFreeFormSend: tudo exceto template conta como mensagem de sessão.
+func isFreeFormSend(suffix string) bool {
+	return !strings.HasSuffix(suffix, "/template")
+}
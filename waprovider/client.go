@@ -0,0 +1,235 @@
+// Package waprovider concentra o acesso HTTP ao provedor de WhatsApp
+// (uazapi). Os handlers em package main usam apenas este cliente; nenhum
+// outro arquivo deve montar requisições ao provedor diretamente.
+package waprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuthMode define como o cliente se autentica no provedor.
+type AuthMode string
+
+const (
+	// AuthBearer envia UAZAPI_TOKEN em um header configurável
+	// (UAZAPI_AUTH_HEADER / UAZAPI_AUTH_VALUE, padrão "Authorization: Bearer %s")
+	// em todas as chamadas. O token da instância segue na query/corpo.
+	AuthBearer AuthMode = "bearer"
+	// AuthAdminToken segue o esquema nativo da uazapi: chamadas
+	// administrativas levam o header "admintoken" e chamadas de instância o
+	// header "token" com o token da própria instância.
+	AuthAdminToken AuthMode = "admintoken"
+)
+
+// ErrNotConfigured é devolvido quando UAZAPI_BASE não foi definido.
+var ErrNotConfigured = errors.New("uazapi not configured (defina UAZAPI_BASE)")
+
+// Config reúne as opções do cliente.
+type Config struct {
+	BaseURL    string
+	APIKey     string
+	AuthMode   AuthMode
+	AuthHeader string // modo bearer: nome do header (ex.: "Authorization" ou "X-API-KEY")
+	AuthValue  string // modo bearer: formato do valor (ex.: "Bearer %s")
+	Timeout    time.Duration
+	// Transport substitui o transporte HTTP (ex.: o provedor simulado).
+	Transport http.RoundTripper
+}
+
+// ConfigFromEnv lê UAZAPI_BASE, UAZAPI_TOKEN, UAZAPI_AUTH_MODE,
+// UAZAPI_AUTH_HEADER e UAZAPI_AUTH_VALUE. Sem UAZAPI_BASE (ou com
+// UAZAPI_MODE=mock) o cliente usa o provedor simulado DefaultMock.
+func ConfigFromEnv() Config {
+	if mockEnabled() {
+		return Config{BaseURL: MockBaseURL, AuthMode: AuthAdminToken, Timeout: 5 * time.Second, Transport: DefaultMock}
+	}
+	return accountConfig(os.Getenv("UAZAPI_BASE"), os.Getenv("UAZAPI_TOKEN"), AuthMode(os.Getenv("UAZAPI_AUTH_MODE")))
+}
+
+// ConfigForAccount monta a configuração de uma conta própria no provedor
+// (org com credenciais cadastradas): base e token da conta, com o header de
+// autenticação herdado do ambiente. mode "" usa UAZAPI_AUTH_MODE. Com
+// UAZAPI_MODE=mock tudo continua no provedor simulado.
+func ConfigForAccount(baseURL, token string, mode AuthMode) Config {
+	if strings.EqualFold(os.Getenv("UAZAPI_MODE"), "mock") {
+		return ConfigFromEnv()
+	}
+	if mode == "" {
+		mode = AuthMode(os.Getenv("UAZAPI_AUTH_MODE"))
+	}
+	return accountConfig(baseURL, token, mode)
+}
+
+func accountConfig(baseURL, token string, mode AuthMode) Config {
+	cfg := Config{
+		BaseURL:    strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		APIKey:     token,
+		AuthMode:   AuthMode(strings.ToLower(strings.TrimSpace(string(mode)))),
+		AuthHeader: os.Getenv("UAZAPI_AUTH_HEADER"),
+		AuthValue:  os.Getenv("UAZAPI_AUTH_VALUE"),
+		Timeout:    35 * time.Second,
+	}
+	if cfg.AuthMode != AuthAdminToken {
+		cfg.AuthMode = AuthBearer
+	}
+	if cfg.AuthHeader == "" {
+		cfg.AuthHeader = "Authorization"
+	}
+	if cfg.AuthValue == "" {
+		cfg.AuthValue = "Bearer %s"
+	}
+	return cfg
+}
+
+// Client é o cliente HTTP do provedor.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+// New cria um cliente com a configuração informada.
+func New(cfg Config) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 35 * time.Second
+	}
+	return &Client{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout, Transport: cfg.Transport}}
+}
+
+// FromEnv é um atalho para New(ConfigFromEnv()).
+func FromEnv() *Client { return New(ConfigFromEnv()) }
+
+// Configured indica se há um provedor (real ou simulado) configurado.
+func (c *Client) Configured() bool { return c.cfg.BaseURL != "" }
+
+// IsMock indica se o cliente fala com o provedor simulado.
+func (c *Client) IsMock() bool { return c.cfg.BaseURL == MockBaseURL }
+
+// UsingMock indica se a configuração do ambiente ativa o provedor simulado.
+func UsingMock() bool { return mockEnabled() }
+
+// Mode devolve o modo de autenticação em uso.
+func (c *Client) Mode() AuthMode { return c.cfg.AuthMode }
+
+// DoJSON faz uma chamada administrativa (ex.: criar instância). Se body !=
+// nil, é enviado como JSON.
+func (c *Client) DoJSON(ctx context.Context, method, path string, q url.Values, body any) (*http.Response, error) {
+	return c.do(ctx, path, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, method, path, q, body)
+		if err != nil {
+			return nil, err
+		}
+		switch c.cfg.AuthMode {
+		case AuthAdminToken:
+			if c.cfg.APIKey != "" {
+				req.Header.Set("admintoken", c.cfg.APIKey)
+			}
+		default:
+			c.setBearer(req)
+		}
+		return req, nil
+	})
+}
+
+// DoInstance faz uma chamada no escopo de uma instância. No modo admintoken
+// o token da instância vai no header "token"; no modo bearer o chamador
+// continua responsável por enviá-lo na query/corpo, como o provedor espera.
+//
+// Um 429 do provedor coloca a instância em backoff: a chamada espera na
+// fila e é refeita (ver ratelimit.go) em vez de devolver o erro.
+func (c *Client) DoInstance(ctx context.Context, method, path, instanceToken string, q url.Values, body any) (*http.Response, error) {
+	return c.do(ctx, path, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, method, path, q, body)
+		if err != nil {
+			return nil, err
+		}
+		switch c.cfg.AuthMode {
+		case AuthAdminToken:
+			if instanceToken != "" {
+				req.Header.Set("token", instanceToken)
+			}
+		default:
+			c.setBearer(req)
+		}
+		return req, nil
+	})
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, q url.Values, body any) (*http.Request, error) {
+	if !c.Configured() {
+		return nil, ErrNotConfigured
+	}
+	u := c.cfg.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rdr = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rdr)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// setBearer aplica o header de autenticação configurado (modo bearer).
+func (c *Client) setBearer(req *http.Request) {
+	if c.cfg.AuthHeader == "" {
+		return
+	}
+	val := c.cfg.AuthValue
+	if strings.Contains(val, "%s") {
+		val = fmt.Sprintf(val, c.cfg.APIKey)
+	}
+	if val == "" {
+		val = c.cfg.APIKey
+	}
+	if val != "" {
+		req.Header.Set(c.cfg.AuthHeader, val)
+	}
+}
+
+// Ping verifica se o provedor responde (qualquer status HTTP serve; só falha
+// de rede/DNS/timeout conta como fora do ar). O provedor simulado sempre
+// responde.
+func (c *Client) Ping(ctx context.Context) error {
+	if !c.Configured() {
+		return ErrNotConfigured
+	}
+	if c.IsMock() {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.BaseURL+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	return resp.Body.Close()
+}
+
+// InstancePath monta "/instances/{instance}{suffix}" escapando o nome.
+func InstancePath(instance, suffix string) string {
+	return "/instances/" + url.PathEscape(instance) + suffix
+}
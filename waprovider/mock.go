@@ -0,0 +1,319 @@
+package waprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockBaseURL é a BaseURL do cliente quando o provedor simulado está ativo.
+const MockBaseURL = "mock://uazapi"
+
+// Mock é um provedor uazapi em memória, determinístico, usado quando
+// UAZAPI_BASE não está definido (ou UAZAPI_MODE=mock). Ele é plugado como
+// http.RoundTripper do Client, então os handlers seguem exatamente o mesmo
+// caminho do provedor real.
+//
+// Roteiro:
+//   - POST /instances cria a instância em "waiting-qr" (id = nome-N, token mock-token-N);
+//   - após ConnectAfter consultas a /status a instância conecta sozinha
+//     (0 = só via Inject);
+//   - /qr devolve um QR fixo por geração; POST /connect gera um QR novo
+//     (nova geração) se não estiver conectada; /webhook guarda a URL;
+//   - /send/* exige instância conectada e gera um eco "fromMe" pelo OnEvent;
+//   - POST /logout desconecta (novo QR) e DELETE /instances/{id} remove.
+type Mock struct {
+	// ConnectAfter é o número de consultas a /status até a conexão automática.
+	ConnectAfter int
+	// OnEvent recebe os eventos que o provedor real mandaria ao webhook.
+	OnEvent func(instance string, payload []byte)
+
+	mu        sync.Mutex
+	seq       int
+	instances map[string]*mockInstance
+}
+
+type mockInstance struct {
+	ID      string
+	Token   string
+	Status  string
+	QRGen   int
+	Polls   int
+	Webhook string
+	Sent    int
+}
+
+// DefaultMock é o provedor simulado compartilhado pelo processo.
+var DefaultMock = NewMock()
+
+// NewMock cria um provedor simulado vazio. UAZAPI_MOCK_CONNECT_AFTER
+// (padrão 2) define a conexão automática.
+func NewMock() *Mock {
+	n := 2
+	if v, err := strconv.Atoi(os.Getenv("UAZAPI_MOCK_CONNECT_AFTER")); err == nil && v >= 0 {
+		n = v
+	}
+	return &Mock{ConnectAfter: n, instances: map[string]*mockInstance{}}
+}
+
+// mockEnabled indica se o cliente deve usar o provedor simulado.
+func mockEnabled() bool {
+	return strings.EqualFold(os.Getenv("UAZAPI_MODE"), "mock") || strings.TrimSpace(os.Getenv("UAZAPI_BASE")) == ""
+}
+
+// RoundTrip implementa http.RoundTripper.
+func (m *Mock) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		_ = json.Unmarshal(b, &body)
+	}
+	token := firstNonEmpty(req.Header.Get("token"), req.URL.Query().Get("token"), str(body["token"]))
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "instances" && req.Method == http.MethodPost {
+		return m.create(req, str(body["name"]))
+	}
+	if len(parts) < 2 || parts[0] != "instances" {
+		return mockResponse(req, http.StatusNotFound, map[string]any{"error": "not found"})
+	}
+
+	m.mu.Lock()
+	inst := m.instances[parts[1]]
+	m.mu.Unlock()
+	if inst == nil {
+		return mockResponse(req, http.StatusNotFound, map[string]any{"error": "instance not found"})
+	}
+	if token != "" && inst.Token != "" && token != inst.Token {
+		return mockResponse(req, http.StatusUnauthorized, map[string]any{"error": "invalid token"})
+	}
+
+	switch action := strings.Join(parts[2:], "/"); {
+	case action == "" && req.Method == http.MethodDelete:
+		m.mu.Lock()
+		delete(m.instances, inst.ID)
+		m.mu.Unlock()
+		return mockResponse(req, http.StatusOK, map[string]any{"ok": true, "deleted": inst.ID})
+	case action == "logout" && req.Method == http.MethodPost:
+		m.mu.Lock()
+		wasConnected := inst.Status == "connected"
+		inst.Status, inst.Polls = "waiting-qr", 0
+		inst.QRGen++
+		m.mu.Unlock()
+		if wasConnected {
+			m.emit(inst.ID, connectionEvent(inst.ID, "disconnected", "logout"))
+		}
+		return mockResponse(req, http.StatusOK, map[string]any{"ok": true, "status": "disconnected"})
+	case action == "connect" && req.Method == http.MethodPost:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if inst.Status != "connected" {
+			inst.Status = "waiting-qr"
+			inst.QRGen++
+		}
+		return mockResponse(req, http.StatusOK, m.statusOf(inst))
+	case action == "status" && req.Method == http.MethodGet:
+		return mockResponse(req, http.StatusOK, m.poll(inst))
+	case (action == "qr" || action == "qrcode") && req.Method == http.MethodGet:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return mockResponse(req, http.StatusOK, m.statusOf(inst))
+	case action == "webhook" && req.Method == http.MethodPost:
+		m.mu.Lock()
+		inst.Webhook = str(body["url"])
+		m.mu.Unlock()
+		return mockResponse(req, http.StatusOK, map[string]any{"ok": true, "url": inst.Webhook})
+	case strings.HasPrefix(action, "send/") && req.Method == http.MethodPost:
+		return m.send(req, inst, strings.TrimPrefix(action, "send/"), body)
+	}
+	return mockResponse(req, http.StatusNotFound, map[string]any{"error": "not found"})
+}
+
+func (m *Mock) create(req *http.Request, name string) (*http.Response, error) {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "-"))
+	if name == "" {
+		return mockResponse(req, http.StatusBadRequest, map[string]any{"error": "name required"})
+	}
+	m.mu.Lock()
+	m.seq++
+	inst := &mockInstance{
+		ID:     fmt.Sprintf("%s-%d", name, m.seq),
+		Token:  fmt.Sprintf("mock-token-%d", m.seq),
+		Status: "waiting-qr",
+		QRGen:  1,
+	}
+	m.instances[inst.ID] = inst
+	out := m.statusOf(inst)
+	m.mu.Unlock()
+	out["instanceId"] = inst.ID
+	out["token"] = inst.Token
+	out["mock"] = true
+	return mockResponse(req, http.StatusOK, out)
+}
+
+// poll avança o roteiro de conexão a cada consulta de status.
+func (m *Mock) poll(inst *mockInstance) map[string]any {
+	m.mu.Lock()
+	connected := false
+	if inst.Status == "waiting-qr" {
+		inst.Polls++
+		if m.ConnectAfter > 0 && inst.Polls >= m.ConnectAfter {
+			inst.Status = "connected"
+			connected = true
+		}
+	}
+	out := m.statusOf(inst)
+	m.mu.Unlock()
+	if connected {
+		m.emit(inst.ID, connectionEvent(inst.ID, "connected", ""))
+	}
+	return out
+}
+
+// statusOf monta a resposta de status/QR (chamar com mu travado).
+func (m *Mock) statusOf(inst *mockInstance) map[string]any {
+	connect := map[string]any{"status": inst.Status}
+	out := map[string]any{"instance": inst.ID, "status": inst.Status, "connect": connect}
+	if inst.Status == "waiting-qr" {
+		qr := fmt.Sprintf("UAZAPI_MOCK_%s_%d", inst.ID, inst.QRGen)
+		out["qrcode"] = qr
+		connect["qrcode"] = qr
+	}
+	return out
+}
+
+func (m *Mock) send(req *http.Request, inst *mockInstance, kind string, body map[string]any) (*http.Response, error) {
+	m.mu.Lock()
+	if inst.Status != "connected" {
+		m.mu.Unlock()
+		return mockResponse(req, http.StatusConflict, map[string]any{"error": "disconnected"})
+	}
+	inst.Sent++
+	id := fmt.Sprintf("mock-%s-%d", inst.ID, inst.Sent)
+	m.mu.Unlock()
+
+	to := firstNonEmpty(str(body["number"]), str(body["to"]), str(body["phone"]))
+	msg := map[string]any{
+		"id":          id,
+		"fromMe":      true,
+		"chatid":      to,
+		"messageType": kind,
+		"text":        firstNonEmpty(str(body["text"]), str(body["caption"])),
+		"timestamp":   time.Now().Unix(),
+	}
+	if u := firstNonEmpty(str(body["url"]), str(body["file"])); u != "" {
+		msg["url"] = u
+	}
+	m.emit(inst.ID, mustJSON(map[string]any{"EventType": "messages", "instance": inst.ID, "message": msg}))
+	return mockResponse(req, http.StatusOK, map[string]any{"ok": true, "mock": true, "id": id, "status": "sent"})
+}
+
+// Inject simula um evento vindo do WhatsApp: "message" (mensagem recebida
+// de from com text), "connected", "disconnected" ou "qr-expired". Devolve o
+// payload entregue ao OnEvent.
+func (m *Mock) Inject(instance, event, from, text string) ([]byte, error) {
+	m.mu.Lock()
+	inst := m.instances[instance]
+	if inst == nil {
+		// instâncias persistidas antes do restart: recria conectada
+		inst = &mockInstance{ID: instance, Status: "connected", QRGen: 1}
+		m.instances[instance] = inst
+	}
+	var payload []byte
+	switch event {
+	case "message":
+		inst.Sent++
+		payload = mustJSON(map[string]any{"EventType": "messages", "instance": instance, "message": map[string]any{
+			"id":          fmt.Sprintf("mock-in-%s-%d", instance, inst.Sent),
+			"fromMe":      false,
+			"chatid":      from,
+			"sender":      from,
+			"messageType": "text",
+			"text":        text,
+			"timestamp":   time.Now().Unix(),
+		}})
+	case "connected":
+		inst.Status = "connected"
+		payload = connectionEvent(instance, "connected", "")
+	case "disconnected":
+		inst.Status = "disconnected"
+		payload = connectionEvent(instance, "disconnected", "")
+	case "qr-expired":
+		inst.Status = "waiting-qr"
+		inst.QRGen++
+		inst.Polls = 0
+		payload = connectionEvent(instance, "qr-expired", "qrcode timeout")
+	default:
+		m.mu.Unlock()
+		return nil, fmt.Errorf("unknown mock event %q", event)
+	}
+	m.mu.Unlock()
+	m.emit(instance, payload)
+	return payload, nil
+}
+
+// Register garante que uma instância conhecida pelo banco exista no mock
+// (útil depois de um restart, quando a memória foi perdida).
+func (m *Mock) Register(instance, token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.instances[instance]; !ok {
+		m.instances[instance] = &mockInstance{ID: instance, Token: token, Status: "waiting-qr", QRGen: 1}
+	}
+}
+
+func (m *Mock) emit(instance string, payload []byte) {
+	if m.OnEvent != nil {
+		go m.OnEvent(instance, payload)
+	}
+}
+
+func connectionEvent(instance, status, reason string) []byte {
+	ev := map[string]any{"EventType": "connection", "instance": map[string]any{"id": instance, "status": status}}
+	if reason != "" {
+		ev["reason"] = reason
+	}
+	return mustJSON(ev)
+}
+
+func mockResponse(req *http.Request, status int, v any) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(mustJSON(v))),
+		Request:    req,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}, nil
+}
+
+func mustJSON(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func str(v any) string {
+	if s, ok := v.(string); ok {
+		return strings.TrimSpace(s)
+	}
+	return ""
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
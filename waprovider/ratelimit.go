@@ -0,0 +1,243 @@
+package waprovider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Controle de 429 do provedor. O estado é do processo (não do Client, que é
+// criado a cada chamada) e separado por instância; chamadas administrativas
+// compartilham a chave "". Ao receber 429 a chave entra em backoff
+// (Retry-After ou exponencial 1s..60s) e as requisições seguintes esperam na
+// fila, uma de cada vez, até a janela passar. Configuração:
+//   UAZAPI_MAX_RETRIES     novas tentativas após 429 (padrão 3)
+//   UAZAPI_MAX_QUEUE_WAIT  espera máxima na fila antes de desistir (padrão 30s)
+
+// RateLimitedError é devolvido quando a espera excederia UAZAPI_MAX_QUEUE_WAIT.
+type RateLimitedError struct {
+	Instance   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("provider rate limited (retry in %s)", e.RetryAfter.Round(time.Second))
+}
+
+// Backoff é o estado exposto no status da instância.
+type Backoff struct {
+	Active   bool       `json:"active"`
+	Until    *time.Time `json:"until,omitempty"`
+	Strikes  int        `json:"strikes"`
+	Queued   int        `json:"queued"`
+	Total429 int        `json:"total_429"`
+}
+
+type limiter struct {
+	mu      sync.Mutex
+	until   time.Time
+	strikes int
+	queued  int
+	total   int
+	gate    chan struct{} // serializa as chamadas enquanto há strikes
+}
+
+var limiters sync.Map // chave (instância) -> *limiter
+
+func limiterFor(key string) *limiter {
+	if l, ok := limiters.Load(key); ok {
+		return l.(*limiter)
+	}
+	l, _ := limiters.LoadOrStore(key, &limiter{gate: make(chan struct{}, 1)})
+	return l.(*limiter)
+}
+
+// BackoffFor devolve o estado de backoff da instância.
+func BackoffFor(instance string) Backoff {
+	l := limiterFor(instance)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := Backoff{Strikes: l.strikes, Queued: l.queued, Total429: l.total}
+	if time.Now().Before(l.until) {
+		until := l.until
+		b.Active = true
+		b.Until = &until
+	}
+	return b
+}
+
+// instanceFromPath extrai {instance} de "/instances/{instance}/...".
+func instanceFromPath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/instances/")
+	if !ok {
+		return ""
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	if u, err := url.PathUnescape(name); err == nil {
+		return u
+	}
+	return name
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// retryAfter interpreta o header (segundos ou data HTTP); sem header usa
+// backoff exponencial pelo número de strikes.
+func retryAfter(h string, strikes int) time.Duration {
+	if h = strings.TrimSpace(h); h != "" {
+		if s, err := strconv.Atoi(h); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second
+		}
+		if t, err := http.ParseTime(h); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+	d := time.Second << min(strikes, 6)
+	if d > time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+// wait entra na fila da chave até a janela de backoff passar.
+func (l *limiter) wait(ctx context.Context, key string, maxWait time.Duration) (release func(), err error) {
+	l.mu.Lock()
+	l.queued++
+	d := time.Until(l.until)
+	paced := l.strikes > 0
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	if d > maxWait {
+		return nil, &RateLimitedError{Instance: key, RetryAfter: d}
+	}
+	if paced {
+		select {
+		case l.gate <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-l.gate }
+		// a janela pode ter sido estendida enquanto esperávamos a vez
+		l.mu.Lock()
+		d = time.Until(l.until)
+		l.mu.Unlock()
+	} else {
+		release = func() {}
+	}
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+func (l *limiter) penalize(h string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d := retryAfter(h, l.strikes)
+	l.strikes++
+	l.total++
+	if until := time.Now().Add(d); until.After(l.until) {
+		l.until = until
+	}
+}
+
+func (l *limiter) success() {
+	l.mu.Lock()
+	l.strikes = 0
+	l.mu.Unlock()
+}
+
+// Observe, se definido, recebe cada chamada ao provedor depois das
+// retentativas: a operação (path com a instância trocada por "{instance}"),
+// o status (0 em erro de transporte) e a duração total. Usado pelas métricas.
+var Observe func(ctx context.Context, op string, status int, err error, d time.Duration)
+
+// opFromPath: "/instances/loja%201/send/text" -> "/instances/{instance}/send/text".
+func opFromPath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/instances/")
+	if !ok {
+		return path
+	}
+	_, suffix, _ := strings.Cut(rest, "/")
+	if suffix == "" {
+		return "/instances/{instance}"
+	}
+	return "/instances/{instance}/" + suffix
+}
+
+// do executa a requisição respeitando o backoff da chave e refazendo-a
+// após 429. build é chamado a cada tentativa (o corpo não é reaproveitável).
+func (c *Client) do(ctx context.Context, path string, build func() (*http.Request, error)) (resp *http.Response, err error) {
+	if Observe != nil {
+		start := time.Now()
+		defer func() {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			Observe(ctx, opFromPath(path), status, err, time.Since(start))
+		}()
+	}
+	key := instanceFromPath(path)
+	l := limiterFor(key)
+	maxRetries := envInt("UAZAPI_MAX_RETRIES", 3)
+	maxWait := envDuration("UAZAPI_MAX_QUEUE_WAIT", 30*time.Second)
+	for attempt := 0; ; attempt++ {
+		release, err := l.wait(ctx, key, maxWait)
+		if err != nil {
+			return nil, err
+		}
+		req, err := build()
+		if err != nil {
+			release()
+			return nil, err
+		}
+		resp, err := c.http.Do(req)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			l.success()
+			return resp, nil
+		}
+		l.penalize(resp.Header.Get("Retry-After"))
+		if attempt >= maxRetries {
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+	}
+}
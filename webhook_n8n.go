@@ -1,4 +1,320 @@
-
 package main
-import "net/http"
-func (a *App) webhookN8N(w http.ResponseWriter, r *http.Request){ w.WriteHeader(202); w.Write([]byte("queued")) }
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  POST /api/webhooks/n8n
+// ================================================================
+//
+// Contrato (JSON):
+//
+//	{
+//	  "event":   "lead.create" | "order.status" | "product.upsert",
+//	  "org_id":  1, "flow_id": 1,          // ou
+//	  "tax_id":  "12.345.678/0001-90",     // org pelo CNPJ/CPF, primeiro flow
+//	  "data":    { ... }
+//	}
+//
+// lead.create    {"name","phone","email","stage"}  — telefone já cadastrado
+//                devolve o lead existente
+// order.status   {"order_id","status"}             — "paid" dispara order.paid
+// product.upsert {"slug"|"title", "description","price_cents","stock",
+//                 "category","status","image_url"} — casa pelo slug na org
+//
+// Autenticação: header X-N8N-Secret igual a N8N_WEBHOOK_SECRET. Sem o
+// segredo configurado o endpoint só aceita chamadas fora de produção.
+// Todo evento recebido é gravado em webhooks_log (source "n8n").
+
+const (
+	n8nLeadCreate    = "lead.create"
+	n8nOrderStatus   = "order.status"
+	n8nProductUpsert = "product.upsert"
+)
+
+var validOrderStatuses = map[string]bool{
+	"pending": true, "paid": true, "shipped": true, "delivered": true, "canceled": true,
+}
+
+type n8nEvent struct {
+	Event  string          `json:"event"`
+	OrgID  int64           `json:"org_id"`
+	FlowID int64           `json:"flow_id"`
+	TaxID  string          `json:"tax_id"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// n8nError carrega o status HTTP de uma falha de validação do evento.
+type n8nError struct {
+	status int
+	msg    string
+}
+
+func (e *n8nError) Error() string { return e.msg }
+
+func n8nBadRequest(format string, args ...any) error {
+	return &n8nError{http.StatusUnprocessableEntity, fmt.Sprintf(format, args...)}
+}
+
+func (a *App) webhookN8N(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("N8N_WEBHOOK_SECRET")
+	switch {
+	case secret != "":
+		got := headerTrim(r, "X-N8N-Secret")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			render.Error(w, http.StatusUnauthorized, "invalid X-N8N-Secret")
+			return
+		}
+	case isProduction():
+		render.Error(w, http.StatusServiceUnavailable, "n8n webhook not configured (set N8N_WEBHOOK_SECRET)")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, "read error")
+		return
+	}
+	var ev n8nEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	ev.Event = strings.ToLower(strings.TrimSpace(ev.Event))
+	ctx := r.Context()
+
+	orgID, flowID, err := a.n8nTenant(ctx, ev)
+	if err != nil {
+		render.Error(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if _, err := a.DB.Exec(ctx,
+		`INSERT INTO public.webhooks_log (org_id, flow_id, source, event, payload) VALUES ($1, $2, 'n8n', $3, $4)`,
+		orgID, flowID, ev.Event, body); err != nil {
+		log.Printf("n8n webhook log: %v", err)
+	}
+
+	var out any
+	switch ev.Event {
+	case n8nLeadCreate:
+		out, err = a.n8nCreateLead(ctx, orgID, flowID, ev.Data)
+	case n8nOrderStatus:
+		out, err = a.n8nOrderStatus(ctx, orgID, flowID, ev.Data)
+	case n8nProductUpsert:
+		out, err = a.n8nUpsertProduct(ctx, orgID, flowID, ev.Data)
+	default:
+		err = n8nBadRequest("unknown event %q (use %s, %s or %s)", ev.Event, n8nLeadCreate, n8nOrderStatus, n8nProductUpsert)
+	}
+	var ne *n8nError
+	if errors.As(err, &ne) {
+		render.Error(w, ne.status, ne.msg)
+		return
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, map[string]any{"ok": true, "event": ev.Event, "result": out})
+}
+
+// n8nTenant resolve org/flow pelos IDs (validando que o flow é da org) ou
+// pelo tax_id, como GET /api/orgs/resolve/{tax_id}.
+func (a *App) n8nTenant(ctx context.Context, ev n8nEvent) (int64, int64, error) {
+	if ev.OrgID > 0 && ev.FlowID > 0 {
+		ok, err := a.flowInOrg(ctx, ev.OrgID, ev.FlowID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			return 0, 0, errors.New("flow not found")
+		}
+		return ev.OrgID, ev.FlowID, nil
+	}
+	digits := onlyDigits(ev.TaxID)
+	if digits == "" {
+		return 0, 0, errors.New("org_id and flow_id, or tax_id, required")
+	}
+	var orgID, flowID int64
+	err := a.DB.QueryRow(ctx, `
+SELECT o.id, f.id FROM orgs o JOIN flows f ON f.org_id = o.id
+ WHERE o.tax_id=$1 ORDER BY f.id LIMIT 1`, digits).Scan(&orgID, &flowID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, errors.New("org not found")
+	}
+	return orgID, flowID, err
+}
+
+func (a *App) n8nCreateLead(ctx context.Context, orgID, flowID int64, raw json.RawMessage) (any, error) {
+	var in struct {
+		Name  string `json:"name"`
+		Phone string `json:"phone"`
+		Email string `json:"email"`
+		Stage string `json:"stage"`
+	}
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return nil, n8nBadRequest("invalid data: %v", err)
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	in.Phone = onlyDigits(in.Phone)
+	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
+	if in.Name == "" && in.Phone == "" && in.Email == "" {
+		return nil, n8nBadRequest("data.name, data.phone or data.email required")
+	}
+	if in.Phone != "" {
+		id, err := a.leadIDByPhone(ctx, orgID, flowID, in.Phone, false)
+		if err != nil {
+			return nil, err
+		}
+		if id > 0 {
+			return map[string]any{"lead_id": id, "created": false}, nil
+		}
+	}
+	id, _, err := a.insertLead(ctx, orgID, flowID, in.Name, in.Phone, in.Email, in.Stage)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"lead_id": id, "created": true}, nil
+}
+
+func (a *App) n8nOrderStatus(ctx context.Context, orgID, flowID int64, raw json.RawMessage) (any, error) {
+	var in struct {
+		OrderID int64  `json:"order_id"`
+		Status  string `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return nil, n8nBadRequest("invalid data: %v", err)
+	}
+	in.Status = strings.ToLower(strings.TrimSpace(in.Status))
+	if in.OrderID <= 0 {
+		return nil, n8nBadRequest("data.order_id required")
+	}
+	if !validOrderStatuses[in.Status] {
+		return nil, n8nBadRequest("invalid data.status %q (use pending, paid, shipped, delivered or canceled)", in.Status)
+	}
+	o, err := a.setOrderStatus(ctx, orgID, flowID, in.OrderID, in.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &n8nError{http.StatusNotFound, "order not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// setOrderStatus grava o status do pedido do tenant e publica order.paid na
+// transição para "paid"; na transição para "canceled" desfaz os pontos e
+// devolve o estoque. Pedido inexistente devolve pgx.ErrNoRows.
+func (a *App) setOrderStatus(ctx context.Context, orgID, flowID, orderID int64, status string) (Order, error) {
+	var o Order
+	var prev string
+	err := a.DB.QueryRow(ctx, `
+UPDATE orders o SET status=$4
+  FROM (SELECT id, status FROM orders WHERE id=$1 AND org_id=$2 AND flow_id=$3 FOR UPDATE) old
+ WHERE o.id = old.id
+RETURNING o.id, o.org_id, o.flow_id, COALESCE(o.lead_id, 0), o.total_cents, o.status, o.created_at, old.status`,
+		orderID, orgID, flowID, status).
+		Scan(&o.ID, &o.OrgID, &o.FlowID, &o.LeadID, &o.TotalCents, &o.Status, &o.CreatedAt, &prev)
+	if err != nil {
+		return o, err
+	}
+	if o.Status == "paid" && prev != "paid" {
+		a.publishEvent(ctx, orgID, eventOrderPaid, o)
+	}
+	// cancelamento devolve/estorna os pontos de fidelidade (loyalty.go)
+	if o.Status == "canceled" && prev != "canceled" {
+		if err := a.reverseLoyalty(ctx, o.ID); err != nil {
+			log.Printf("order %d loyalty reversal: %v", o.ID, err)
+		}
+		if err := a.releaseOrderStock(ctx, o.OrgID, o.ID); err != nil {
+			log.Printf("order %d stock release: %v", o.ID, err)
+		}
+	}
+	return o, nil
+}
+
+func (a *App) n8nUpsertProduct(ctx context.Context, orgID, flowID int64, raw json.RawMessage) (any, error) {
+	var in struct {
+		Slug        string `json:"slug"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		PriceCents  *int   `json:"price_cents"`
+		Stock       *int   `json:"stock"`
+		Category    string `json:"category"`
+		Status      string `json:"status"`
+		ImageURL    string `json:"image_url"`
+	}
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return nil, n8nBadRequest("invalid data: %v", err)
+	}
+	in.Title = strings.TrimSpace(in.Title)
+	slug := slugify(firstNonEmpty(in.Slug, in.Title))
+	if slug == "" {
+		return nil, n8nBadRequest("data.slug or data.title required")
+	}
+
+	var id int64
+	err := a.DB.QueryRow(ctx, `
+UPDATE products SET
+  title       = COALESCE(NULLIF($3,''), title),
+  description = COALESCE(NULLIF($4,''), description),
+  price_cents = COALESCE($5, price_cents),
+  stock       = COALESCE($6, stock),
+  category    = COALESCE(NULLIF($7,''), category),
+  status      = COALESCE(NULLIF($8,''), status),
+  image_base64 = COALESCE(NULLIF($9,''), image_base64),
+  image_thumb_url = CASE WHEN NULLIF($9,'') IS NULL THEN image_thumb_url END
+WHERE org_id=$1 AND slug=$2
+RETURNING id`, orgID, slug, in.Title, in.Description, in.PriceCents, in.Stock, in.Category, in.Status, in.ImageURL).Scan(&id)
+	if err == nil {
+		a.touchProductFeed(orgID, flowID)
+		if in.ImageURL != "" {
+			a.enqueueProductThumb(ctx, orgID, id, in.ImageURL)
+		}
+		if in.Stock != nil {
+			if err := a.pushProductStockToPool(ctx, orgID, id); err != nil {
+				log.Printf("n8n product %d stock pool: %v", id, err)
+			}
+		}
+		return map[string]any{"product_id": id, "slug": slug, "created": false}, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	if in.Title == "" {
+		return nil, n8nBadRequest("data.title required to create a product")
+	}
+	p := chatProduct{
+		OrgID: orgID, FlowID: flowID, Title: in.Title, Slug: slug,
+		Status: nonEmpty(in.Status, "active"), ImageURL: in.ImageURL, Category: in.Category,
+	}
+	if in.PriceCents != nil {
+		p.PriceCents = *in.PriceCents
+	}
+	if in.Stock != nil {
+		p.Stock = *in.Stock
+	}
+	err = a.DB.QueryRow(ctx, `
+INSERT INTO products (org_id, flow_id, title, slug, description, status, image_base64, price_cents, stock, category)
+VALUES ($1, $2, $3, $4, NULLIF($5,''), $6, $7, $8, $9, $10)
+RETURNING id`, orgID, flowID, p.Title, p.Slug, in.Description, p.Status, p.ImageURL, p.PriceCents, p.Stock, p.Category).Scan(&p.ID)
+	if err != nil {
+		return nil, err
+	}
+	a.touchProductFeed(orgID, flowID)
+	a.publishEvent(ctx, orgID, eventProductCreated, p)
+	return map[string]any{"product_id": p.ID, "slug": slug, "created": true}, nil
+}
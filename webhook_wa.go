@@ -5,66 +5,176 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
 )
 
 // webhook que a Uazapi vai chamar: POST /api/webhooks/wa/{instance}
 func (app *App) webhookWa(w http.ResponseWriter, r *http.Request) {
 	instance := chi.URLParam(r, "instance")
 	if instance == "" {
-		http.Error(w, "missing instance", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "missing instance")
 		return
 	}
 
 	// lê payload bruto
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "read error", http.StatusBadRequest)
+		render.Error(w, http.StatusBadRequest, "read error")
 		return
 	}
 	defer r.Body.Close()
 
+	// evento de teste do reprovisionamento: confirma e não encaminha
+	if app.consumeWebhookTest(r.Context(), instance, body) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	app.processWAWebhook(r.Context(), instance, "uazapi", body)
+
+	// sempre aceitar para que a Uazapi não reenvie o mesmo lote
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// processWAWebhook registra o evento, atualiza o estado da instância e
+// encaminha ao Agente. Usado pelo webhook HTTP e pelo provedor simulado.
+func (app *App) processWAWebhook(ctx context.Context, instance, source string, body []byte) {
 	// loga no banco (opcional)
-	_, _ = app.DB.Exec(r.Context(),
+	_, _ = app.DB.Exec(ctx,
 		`INSERT INTO public.webhooks_log(source, payload) VALUES($1, $2)`,
-		"uazapi", json.RawMessage(body))
+		source, json.RawMessage(body))
 
 	// recupera credenciais/tenant da instância
-	info, err := app.lookupInstanceInfo(r.Context(), instance)
+	info, err := app.lookupInstanceInfo(ctx, instance)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("lookup instance err: %v", err)
 	}
+	// instância removida: o provedor ainda pode mandar eventos; não
+	// ingerimos nem encaminhamos
+	if info.Deleted {
+		log.Printf("wa webhook %s: instance deleted, dropping event", instance)
+		return
+	}
+
+	// mensagens entram no inbox (lead, conversa, wa_messages, janela de 24h)
+	app.ingestWAMessages(ctx, instance, uazapiMessagesFromWebhook(body))
+	// sent/delivered/read/failed dos nossos envios (wa_message_status.go)
+	app.applyWAStatuses(ctx, instance, uazapiStatusesFromWebhook(body))
+
+	// eventos de conexão (connected/disconnected/qr-expired) viram evento próprio
+	if state, reason := connectionStateFromWebhook(body); state != "" {
+		app.handleWAStateChange(ctx, instance, state, waStateCause{Source: "webhook", Reason: reason}, info)
+	}
+
+	// o encaminhamento vai pela fila (jobs.go): se o Agente estiver fora, o
+	// evento é reenviado com backoff em vez de se perder
+	app.enqueueAgentForward(ctx, info, agentForwardJob{Instance: instance, URL: agentForwardURL(instance), Body: body})
+}
+
+const jobAgentForward = "agent.forward"
+
+// agentForwardJob é o payload do job agent.forward: o corpo vai como está
+// para a URL de destino, com os headers da instância.
+type agentForwardJob struct {
+	Instance  string `json:"instance"`
+	URL       string `json:"url"`
+	EventType string `json:"event_type,omitempty"` // header X-Event-Type
+	Body      []byte `json:"body"`
+}
+
+// enqueueAgentForward enfileira o encaminhamento. Se a fila falhar, tenta
+// entregar na hora, como antes da fila.
+func (app *App) enqueueAgentForward(ctx context.Context, info instanceInfo, f agentForwardJob) {
+	orgID, _ := strconv.ParseInt(info.OrgID, 10, 64)
+	// IA fora do ar (ai_fallback.go): a mensagem espera a volta
+	if f.EventType == "" && aiDegraded(orgID) && app.holdAgentForward(ctx, orgID, f) {
+		return
+	}
+	if _, err := app.enqueueJob(ctx, orgID, jobAgentForward, f, time.Time{}); err != nil {
+		log.Printf("forward %s: %v; sending inline", f.Instance, err)
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+		defer cancel()
+		if err := app.postAgentForward(fctx, f); err != nil {
+			log.Printf("forward err: %v", err)
+		}
+	}
+}
+
+func (app *App) runAgentForward(ctx context.Context, j job) error {
+	var f agentForwardJob
+	if err := j.decode(&f); err != nil {
+		return err
+	}
+	err := app.postAgentForward(ctx, f)
+	// só mensagens contam para o modo degradado da IA (ai_fallback.go)
+	if f.EventType == "" && j.OrgID != nil && app.noteAgentForward(ctx, *j.OrgID, f, err) {
+		return nil
+	}
+	return err
+}
+
+// postAgentForward faz o POST. 4xx do destino é definitivo; rede e 5xx são
+// repetidos pela fila.
+func (app *App) postAgentForward(ctx context.Context, f agentForwardJob) error {
+	// credenciais lidas na hora do envio (token pode ter sido renovado)
+	info, err := app.lookupInstanceInfo(ctx, f.Instance)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(f.Body))
+	if err != nil {
+		return permanentJobError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.EventType != "" {
+		req.Header.Set("X-Event-Type", f.EventType)
+	}
+	setInstanceHeaders(req, f.Instance, info)
 
-	// base do backend do Agente IA (podendo vir só o domínio)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("downstream status %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return permanentJobError(fmt.Errorf("downstream status %d", resp.StatusCode))
+	}
+	return nil
+}
+
+// agentForwardURL monta a URL de destino no backend do Agente IA
+// (AGENT_BACKEND_URL, podendo vir só o domínio).
+func agentForwardURL(instance string) string {
 	agentBase := strings.TrimRight(os.Getenv("AGENT_BACKEND_URL"), "/")
 	if agentBase == "" {
 		agentBase = "https://paclead-agente-backend-production.up.railway.app"
 	}
-
-	// monta URL de destino
-	forwardURL := agentBase
 	if strings.Contains(agentBase, "/webhook/") || strings.Contains(agentBase, "/webhooks/") {
 		// já veio com caminho completo — usa como está
-		forwardURL = agentBase
-	} else {
-		// usa slug multi-tenant: /webhooks/{instance}
-		forwardURL = agentBase + "/webhooks/" + url.PathEscape(instance)
+		return agentBase
 	}
+	// usa slug multi-tenant: /webhooks/{instance}
+	return agentBase + "/webhooks/" + url.PathEscape(instance)
+}
 
-	req, err := http.NewRequest("POST", forwardURL, bytes.NewReader(body))
-	if err != nil {
-		log.Printf("forward build err: %v", err)
-		w.WriteHeader(http.StatusAccepted)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
+// setInstanceHeaders repassa instância/tenant para o downstream.
+func setInstanceHeaders(req *http.Request, instance string, info instanceInfo) {
 	req.Header.Set("X-Instance-ID", instance)
 	if info.Token != "" {
 		req.Header.Set("X-Instance-Token", info.Token)
@@ -75,23 +185,13 @@ func (app *App) webhookWa(w http.ResponseWriter, r *http.Request) {
 	if info.FlowID != "" {
 		req.Header.Set("X-Flow-ID", info.FlowID)
 	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Printf("forward err: %v", err)
-		w.WriteHeader(http.StatusAccepted)
-		return
-	}
-	_ = resp.Body.Close()
-
-	// sempre aceitar para que a Uazapi não reenvie o mesmo lote
-	w.WriteHeader(http.StatusAccepted)
 }
 
 type instanceInfo struct {
-	Token  string
-	OrgID  string
-	FlowID string
+	Token   string
+	OrgID   string
+	FlowID  string
+	Deleted bool
 }
 
 // lookupInstanceInfo busca token/org/flow para uma instância armazenada na plataforma
@@ -107,13 +207,14 @@ func (app *App) lookupInstanceInfo(ctx context.Context, instance string) (instan
 		SELECT
 			COALESCE(token, '')                                   AS token,
 			COALESCE(org_id::text, '1')                           AS org_id,
-			COALESCE(flow_id::text, '1')                          AS flow_id
+			COALESCE(flow_id::text, '1')                          AS flow_id,
+			deleted_at IS NOT NULL                                AS deleted
 		FROM public.wa_instances
 		WHERE instance_id = $1
 		LIMIT 1
 	`, instance)
 
-	if err := row.Scan(&out.Token, &out.OrgID, &out.FlowID); err != nil {
+	if err := row.Scan(&out.Token, &out.OrgID, &out.FlowID, &out.Deleted); err != nil {
 		return instanceInfo{}, err
 	}
 	return out, nil
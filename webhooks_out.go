@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+)
+
+// ================================================================
+//  Webhooks de saída (assinaturas por org)
+// ================================================================
+//
+// GET    /api/webhook-subscriptions                          assinaturas da org
+// POST   /api/webhook-subscriptions                          {"url","events":["lead.created"],"description"}
+// PUT    /api/webhook-subscriptions/{id}                     {"url","events","active","description"}
+// DELETE /api/webhook-subscriptions/{id}
+// POST   /api/webhook-subscriptions/{id}/rotate-secret
+// POST   /api/webhook-subscriptions/{id}/ping                entrega um evento "ping"
+// GET    /api/webhook-subscriptions/{id}/deliveries          ?status=dead para o dead-letter
+// POST   /api/webhook-subscriptions/deliveries/{id}/retry    recoloca na fila
+//
+// emitWebhookEvent (chamado por publishEvent, events.go) grava uma entrega
+// por assinatura interessada; o worker (WEBHOOK_DELIVERY_INTERVAL, padrão 5s)
+// envia com POST JSON e os headers
+//
+//   X-PacLead-Event, X-PacLead-Delivery, X-PacLead-Timestamp
+//   X-PacLead-Signature: sha256=hex(HMAC-SHA256(secret, "<timestamp>.<corpo>"))
+//
+// Falha (rede ou status fora de 2xx) reagenda com backoff exponencial (30s,
+// 1min, 2min, ... até 6h); após WEBHOOK_MAX_ATTEMPTS (padrão 8) a entrega
+// vira 'dead'. Destinos em rede privada/loopback são recusados, exceto com
+// WEBHOOK_ALLOW_PRIVATE=true (desenvolvimento).
+
+const (
+	eventLeadCreated       = "lead.created"
+	eventLeadStageChanged  = "lead.stage_changed"
+	eventOrderCreated      = "order.created"
+	eventOrderPaid         = "order.paid"
+	eventProductCreated    = "product.created"
+	eventProductCataloged  = "product.cataloged" // criado pela visão + preço no chat
+	eventWAMessageReceived = "wa.message.received"
+	eventWAMessageStatus   = "wa.message.status" // sent/delivered/read/failed (wa_message_status.go)
+	eventAIDegraded        = "ai.degraded"       // modo degradado da IA (ai_fallback.go)
+	eventAIRecovered       = "ai.recovered"
+	eventPing              = "ping"
+)
+
+var webhookEvents = []string{eventLeadCreated, eventLeadStageChanged, eventOrderCreated, eventOrderPaid, eventProductCreated, eventProductCataloged, eventWAMessageReceived, eventWAMessageStatus, eventAIDegraded, eventAIRecovered}
+
+type webhookSubscription struct {
+	ID          int64     `json:"id"`
+	OrgID       int64     `json:"org_id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	Secret      string    `json:"secret,omitempty"` // só na criação e na rotação
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type webhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	Event          string          `json:"event"`
+	EventID        string          `json:"event_id"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatus     *int            `json:"last_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// webhookWake acorda o worker quando há entrega nova.
+var webhookWake = make(chan struct{}, 1)
+
+func (a *App) mountWebhooksOut(r chi.Router) {
+	admin := a.requireRole(roleAdmin)
+	r.Route("/webhook-subscriptions", func(r chi.Router) {
+		r.Get("/", a.listWebhookSubs)
+		r.With(admin).Post("/", a.createWebhookSub)
+		r.With(admin).Put("/{id}", a.updateWebhookSub)
+		r.With(admin).Delete("/{id}", a.deleteWebhookSub)
+		r.With(admin).Post("/{id}/rotate-secret", a.rotateWebhookSecret)
+		r.With(admin).Post("/{id}/ping", a.pingWebhookSub)
+		r.Get("/{id}/deliveries", a.listWebhookDeliveries)
+		r.With(admin).Post("/deliveries/{id}/retry", a.retryWebhookDelivery)
+	})
+
+	if every := webhookDeliveryInterval(); every > 0 {
+		go a.webhookDeliveryLoop(every)
+	}
+}
+
+func webhookDeliveryInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("WEBHOOK_DELIVERY_INTERVAL", "5s"))
+	if err != nil || d < 0 {
+		return 5 * time.Second
+	}
+	return d
+}
+
+func webhookMaxAttempts() int {
+	n, err := strconv.Atoi(getenv("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || n < 1 {
+		return 8
+	}
+	return n
+}
+
+// webhookBackoff: 30s × 2^(tentativas-1), limitado a 6h.
+func webhookBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, 6*time.Hour)
+}
+
+// emitWebhookEvent enfileira o evento para as assinaturas ativas da org.
+// Nunca falha para quem chama: erros só são registrados no log.
+func (a *App) emitWebhookEvent(ctx context.Context, orgID int64, event string, data any) {
+	eventID := "evt_" + secureToken(12)
+	body, err := json.Marshal(map[string]any{
+		"id":         eventID,
+		"event":      event,
+		"org_id":     orgID,
+		"created_at": time.Now().UTC(),
+		"data":       data,
+	})
+	if err != nil {
+		log.Printf("webhook %s org=%d: %v", event, orgID, err)
+		return
+	}
+	// o contexto da requisição pode ser cancelado logo após a resposta
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	tag, err := a.DB.Exec(ctx, `
+INSERT INTO public.webhook_deliveries (subscription_id, org_id, event, event_id, payload)
+SELECT id, org_id, $2, $3, $4::jsonb FROM public.webhook_subscriptions
+ WHERE org_id=$1 AND active AND ($2 = ANY(events) OR '*' = ANY(events))`,
+		orgID, event, eventID, string(body))
+	if err != nil {
+		log.Printf("webhook %s org=%d: %v", event, orgID, err)
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ---------------- API ----------------
+
+func normalizeWebhookEvents(in []string) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	for _, e := range in {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" || seen[e] {
+			continue
+		}
+		ok := e == "*"
+		for _, known := range webhookEvents {
+			ok = ok || e == known
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown event %q (use %s or *)", e, strings.Join(webhookEvents, ", "))
+		}
+		seen[e] = true
+		out = append(out, e)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("events required")
+	}
+	return out, nil
+}
+
+func validateWebhookURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", errors.New("url must be an absolute http(s) URL")
+	}
+	if isProduction() && u.Scheme != "https" {
+		return "", errors.New("url must use https in production")
+	}
+	if u.User != nil {
+		return "", errors.New("url must not contain credentials")
+	}
+	return u.String(), nil
+}
+
+const webhookSubColumns = `id, org_id, url, events, COALESCE(description,''), active, created_at, updated_at`
+
+func scanWebhookSub(row pgx.Row) (webhookSubscription, error) {
+	var s webhookSubscription
+	err := row.Scan(&s.ID, &s.OrgID, &s.URL, &s.Events, &s.Description, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// webhookSubForTenant lê o {id} da URL e carrega a assinatura da org.
+func (a *App) webhookSubForTenant(w http.ResponseWriter, r *http.Request) (webhookSubscription, bool) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return webhookSubscription{}, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid webhook id")
+		return webhookSubscription{}, false
+	}
+	s, err := scanWebhookSub(a.DB.QueryRow(r.Context(),
+		`SELECT `+webhookSubColumns+` FROM public.webhook_subscriptions WHERE id=$1 AND org_id=$2`, id, orgID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		render.Error(w, http.StatusNotFound, "webhook not found")
+		return s, false
+	}
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return s, false
+	}
+	return s, true
+}
+
+// GET /api/webhook-subscriptions
+func (a *App) listWebhookSubs(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(),
+		`SELECT `+webhookSubColumns+` FROM public.webhook_subscriptions WHERE org_id=$1 ORDER BY id`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []webhookSubscription{}
+	for rows.Next() {
+		s, err := scanWebhookSub(rows)
+		if err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, s)
+	}
+	render.OK(w, map[string]any{"items": out, "events": webhookEvents})
+}
+
+// POST /api/webhook-subscriptions
+func (a *App) createWebhookSub(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var in struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	u, err := validateWebhookURL(in.URL)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	events, err := normalizeWebhookEvents(in.Events)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	secret := "whsec_" + secureToken(24)
+	s, err := scanWebhookSub(a.DB.QueryRow(r.Context(), `
+INSERT INTO public.webhook_subscriptions (org_id, url, events, secret, description)
+VALUES ($1, $2, $3, $4, NULLIF($5,''))
+RETURNING `+webhookSubColumns, orgID, u, events, secret, limitRunes(in.Description, 200)))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.Secret = secret
+	render.Created(w, s)
+}
+
+// PUT /api/webhook-subscriptions/{id}
+func (a *App) updateWebhookSub(w http.ResponseWriter, r *http.Request) {
+	cur, ok := a.webhookSubForTenant(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		URL         *string  `json:"url"`
+		Events      []string `json:"events"`
+		Description *string  `json:"description"`
+		Active      *bool    `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if in.URL != nil {
+		u, err := validateWebhookURL(*in.URL)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cur.URL = u
+	}
+	if in.Events != nil {
+		events, err := normalizeWebhookEvents(in.Events)
+		if err != nil {
+			render.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cur.Events = events
+	}
+	if in.Description != nil {
+		cur.Description = limitRunes(*in.Description, 200)
+	}
+	if in.Active != nil {
+		cur.Active = *in.Active
+	}
+	s, err := scanWebhookSub(a.DB.QueryRow(r.Context(), `
+UPDATE public.webhook_subscriptions
+   SET url=$3, events=$4, description=NULLIF($5,''), active=$6, updated_at=NOW()
+ WHERE id=$1 AND org_id=$2
+RETURNING `+webhookSubColumns, cur.ID, cur.OrgID, cur.URL, cur.Events, cur.Description, cur.Active))
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// DELETE /api/webhook-subscriptions/{id}
+func (a *App) deleteWebhookSub(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.webhookSubForTenant(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.DB.Exec(r.Context(),
+		`DELETE FROM public.webhook_subscriptions WHERE id=$1 AND org_id=$2`, s.ID, s.OrgID); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.NoContent(w)
+}
+
+// POST /api/webhook-subscriptions/{id}/rotate-secret
+func (a *App) rotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.webhookSubForTenant(w, r)
+	if !ok {
+		return
+	}
+	secret := "whsec_" + secureToken(24)
+	if _, err := a.DB.Exec(r.Context(),
+		`UPDATE public.webhook_subscriptions SET secret=$3, updated_at=NOW() WHERE id=$1 AND org_id=$2`,
+		s.ID, s.OrgID, secret); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.Secret = secret
+	render.OK(w, s)
+}
+
+// POST /api/webhook-subscriptions/{id}/ping
+func (a *App) pingWebhookSub(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.webhookSubForTenant(w, r)
+	if !ok {
+		return
+	}
+	eventID := "evt_" + secureToken(12)
+	body, _ := json.Marshal(map[string]any{
+		"id": eventID, "event": eventPing, "org_id": s.OrgID, "created_at": time.Now().UTC(),
+		"data": map[string]any{"subscription_id": s.ID},
+	})
+	var id int64
+	if err := a.DB.QueryRow(r.Context(), `
+INSERT INTO public.webhook_deliveries (subscription_id, org_id, event, event_id, payload)
+VALUES ($1, $2, $3, $4, $5::jsonb) RETURNING id`, s.ID, s.OrgID, eventPing, eventID, string(body)).Scan(&id); err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+	render.Accepted(w, map[string]any{"delivery_id": id, "event_id": eventID})
+}
+
+// GET /api/webhook-subscriptions/{id}/deliveries
+func (a *App) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.webhookSubForTenant(w, r)
+	if !ok {
+		return
+	}
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	limit := mustAtoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, subscription_id, event, event_id, payload, status, attempts, next_attempt_at, last_status,
+       COALESCE(last_error,''), created_at, delivered_at
+  FROM public.webhook_deliveries
+ WHERE subscription_id=$1 AND ($2 = '' OR status = $2)
+ ORDER BY id DESC LIMIT $3`, s.ID, status, limit)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []webhookDelivery{}
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.EventID, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = append(out, d)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+// POST /api/webhook-subscriptions/deliveries/{id}/retry
+func (a *App) retryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		render.Error(w, http.StatusBadRequest, "invalid delivery id")
+		return
+	}
+	tag, err := a.DB.Exec(r.Context(), `
+UPDATE public.webhook_deliveries SET status='pending', attempts=0, next_attempt_at=NOW()
+ WHERE id=$1 AND org_id=$2 AND status <> 'pending'`, id, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		render.Error(w, http.StatusNotFound, "delivery not found or already pending")
+		return
+	}
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ---------------- entrega ----------------
+
+// webhookHTTPClient recusa destinos em redes internas (SSRF), checando o IP
+// já resolvido no momento da conexão.
+var webhookHTTPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: webhookDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func webhookDialControl(_, address string, _ syscall.RawConn) error {
+	if getenv("WEBHOOK_ALLOW_PRIVATE", "false") == "true" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("destination %s is not allowed", host)
+	}
+	return nil
+}
+
+func signWebhook(secret string, ts int64, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(m, "%d.", ts)
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+func (a *App) webhookDeliveryLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-webhookWake:
+		}
+		for a.deliverWebhookBatch() {
+			// lote cheio: pode haver mais na fila
+		}
+	}
+}
+
+// deliverWebhookBatch envia até 20 entregas vencidas. Devolve true se o lote
+// veio cheio.
+func (a *App) deliverWebhookBatch() bool {
+	const batch = 20
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	// reserva as entregas por 2 minutos (outra réplica não pega as mesmas)
+	rows, err := a.DB.Query(ctx, `
+UPDATE public.webhook_deliveries d
+   SET next_attempt_at = NOW() + INTERVAL '2 minutes'
+  FROM public.webhook_subscriptions s
+ WHERE s.id = d.subscription_id
+   AND d.id IN (SELECT id FROM public.webhook_deliveries
+                 WHERE status='pending' AND next_attempt_at <= NOW()
+                 ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+RETURNING d.id, d.event, d.event_id, d.payload::text, d.attempts, s.url, s.secret, s.active`, batch)
+	if err != nil {
+		log.Printf("webhook delivery: %v", err)
+		return false
+	}
+	type job struct {
+		id       int64
+		event    string
+		eventID  string
+		payload  string
+		attempts int
+		url      string
+		secret   string
+		active   bool
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.event, &j.eventID, &j.payload, &j.attempts, &j.url, &j.secret, &j.active); err != nil {
+			log.Printf("webhook delivery: %v", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 5)
+	for _, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if !j.active {
+				a.finishWebhookDelivery(ctx, j.id, j.attempts, 0, errors.New("subscription disabled"), true)
+				return
+			}
+			status, err := postWebhook(ctx, j.url, j.secret, j.event, j.eventID, []byte(j.payload))
+			a.finishWebhookDelivery(ctx, j.id, j.attempts, status, err, false)
+		}(j)
+	}
+	wg.Wait()
+	return len(jobs) == batch
+}
+
+func postWebhook(ctx context.Context, target, secret, event, eventID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PacLead-Webhooks/1.0")
+	req.Header.Set("X-PacLead-Event", event)
+	req.Header.Set("X-PacLead-Delivery", eventID)
+	req.Header.Set("X-PacLead-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-PacLead-Signature", signWebhook(secret, ts, body))
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return resp.StatusCode, nil
+}
+
+// finishWebhookDelivery grava o resultado da tentativa: entregue, reagendada
+// com backoff ou, sem mais tentativas (ou dead=true), no dead-letter.
+func (a *App) finishWebhookDelivery(ctx context.Context, id int64, attempts, status int, sendErr error, dead bool) {
+	attempts++
+	var lastStatus *int
+	if status > 0 {
+		lastStatus = &status
+	}
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = a.DB.Exec(ctx, `
+UPDATE public.webhook_deliveries SET status='delivered', attempts=$2, last_status=$3, last_error=NULL, delivered_at=NOW()
+ WHERE id=$1`, id, attempts, lastStatus)
+	case dead || attempts >= webhookMaxAttempts():
+		_, err = a.DB.Exec(ctx, `
+UPDATE public.webhook_deliveries SET status='dead', attempts=$2, last_status=$3, last_error=$4 WHERE id=$1`,
+			id, attempts, lastStatus, limitRunes(sendErr.Error(), 500))
+	default:
+		_, err = a.DB.Exec(ctx, `
+UPDATE public.webhook_deliveries SET attempts=$2, last_status=$3, last_error=$4, next_attempt_at=$5 WHERE id=$1`,
+			id, attempts, lastStatus, limitRunes(sendErr.Error(), 500), time.Now().Add(webhookBackoff(attempts)))
+	}
+	if err != nil {
+		log.Printf("webhook delivery %d: %v", id, err)
+	}
+}
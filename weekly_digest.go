@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/paclead/backend/render"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ================================================================
+//  Resumo semanal de desempenho (IA)
+// ================================================================
+//
+// GET  /api/digests/settings   canais e horário do resumo da org
+// PUT  /api/digests/settings   (admin) {"active","email","whatsapp_number","weekday","hour"}
+// GET  /api/digests            últimos resumos gerados
+// POST /api/digests/run        (admin) gera e envia agora o da última semana
+//
+// A semana vai de segunda 00:00 a segunda 00:00 no fuso de APPOINTMENT_TZ.
+// O worker (WEEKLY_DIGEST_INTERVAL, padrão 15m; 0 desliga) enfileira um job
+// weekly_digest.send por org depois do dia/hora configurados (padrão segunda,
+// 8h), uma vez por semana. O job junta vendas (contra a semana anterior),
+// produtos mais vendidos, melhores horários, leads novos e leads parados há
+// mais de WEEKLY_DIGEST_STUCK_AFTER (padrão 7 dias) numa etapa aberta, pede
+// ao modelo do tenant um texto curto e envia por e-mail aos owners e/ou por
+// WhatsApp ao número configurado. Sem orçamento de IA, ou se o modelo falhar,
+// vai um resumo montado sem IA. Org sem nenhum movimento na semana não
+// recebe resumo. Sem configuração salva vale o padrão: ativo, só e-mail.
+
+const jobWeeklyDigestSend = "weekly_digest.send"
+
+// pedidos que contam como venda (pago e etapas seguintes)
+const digestSoldSQL = `status IN ('paid','shipped','delivered')`
+
+func (a *App) mountWeeklyDigest(r chi.Router) {
+	registerJob(jobWeeklyDigestSend, jobPolicy{MaxAttempts: 3, Timeout: 3 * time.Minute}, a.runWeeklyDigest)
+	admin := a.requireRole(roleAdmin)
+	r.Route("/digests", func(r chi.Router) {
+		r.Get("/", a.listWeeklyDigests)
+		r.Get("/settings", a.getDigestSettings)
+		r.With(admin).Put("/settings", a.putDigestSettings)
+		r.With(admin).Post("/run", a.runWeeklyDigestNow)
+	})
+	if every := weeklyDigestInterval(); every > 0 {
+		go a.weeklyDigestLoop(every)
+	}
+}
+
+func weeklyDigestInterval() time.Duration {
+	d, err := time.ParseDuration(getenv("WEEKLY_DIGEST_INTERVAL", "15m"))
+	if err != nil || d < 0 {
+		return 15 * time.Minute
+	}
+	return d
+}
+
+func weeklyDigestStuckAfter() time.Duration {
+	d, err := time.ParseDuration(getenv("WEEKLY_DIGEST_STUCK_AFTER", "168h"))
+	if err != nil || d <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// digestWeek devolve a última semana completa (segunda a segunda) antes de now.
+func digestWeek(now time.Time) (start, end time.Time) {
+	now = now.In(appointmentLoc())
+	end = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end = end.AddDate(0, 0, -((int(end.Weekday()) + 6) % 7))
+	return end.AddDate(0, 0, -7), end
+}
+
+type digestSettings struct {
+	Active         bool   `json:"active"`
+	Email          bool   `json:"email"`
+	WhatsAppNumber string `json:"whatsapp_number,omitempty"`
+	Weekday        int    `json:"weekday"` // 0 = domingo
+	Hour           int    `json:"hour"`
+}
+
+func defaultDigestSettings() digestSettings {
+	return digestSettings{Active: true, Email: true, Weekday: 1, Hour: 8}
+}
+
+// dueAt é quando o resumo da semana que começa em weekEnd pode sair.
+func (s digestSettings) dueAt(weekEnd time.Time) time.Time {
+	return weekEnd.AddDate(0, 0, (s.Weekday+6)%7).Add(time.Duration(s.Hour) * time.Hour)
+}
+
+func (a *App) loadDigestSettings(ctx context.Context, orgID int64) (digestSettings, error) {
+	s := defaultDigestSettings()
+	err := a.DB.QueryRow(ctx, `
+SELECT active, email, COALESCE(whatsapp_number,''), weekday, hour
+  FROM weekly_digest_settings WHERE org_id=$1`, orgID).
+		Scan(&s.Active, &s.Email, &s.WhatsAppNumber, &s.Weekday, &s.Hour)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, nil
+	}
+	return s, err
+}
+
+// GET /api/digests/settings
+func (a *App) getDigestSettings(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s, err := a.loadDigestSettings(r.Context(), orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+// PUT /api/digests/settings
+func (a *App) putDigestSettings(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := defaultDigestSettings()
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		render.Error(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	s.WhatsAppNumber = onlyDigits(s.WhatsAppNumber)
+	switch {
+	case s.Weekday < 0 || s.Weekday > 6:
+		render.Error(w, http.StatusBadRequest, "weekday must be between 0 (sunday) and 6 (saturday)")
+		return
+	case s.Hour < 0 || s.Hour > 23:
+		render.Error(w, http.StatusBadRequest, "hour must be between 0 and 23")
+		return
+	case s.Active && !s.Email && s.WhatsAppNumber == "":
+		render.Error(w, http.StatusBadRequest, "enable email or set whatsapp_number")
+		return
+	}
+	_, err = a.DB.Exec(r.Context(), `
+INSERT INTO weekly_digest_settings (org_id, active, email, whatsapp_number, weekday, hour, updated_at)
+VALUES ($1, $2, $3, NULLIF($4,''), $5, $6, NOW())
+ON CONFLICT (org_id) DO UPDATE SET
+  active=EXCLUDED.active, email=EXCLUDED.email, whatsapp_number=EXCLUDED.whatsapp_number,
+  weekday=EXCLUDED.weekday, hour=EXCLUDED.hour, updated_at=NOW()`,
+		orgID, s.Active, s.Email, s.WhatsAppNumber, s.Weekday, s.Hour)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.OK(w, s)
+}
+
+type weeklyDigest struct {
+	ID          int64               `json:"id"`
+	PeriodStart time.Time           `json:"period_start"`
+	PeriodEnd   time.Time           `json:"period_end"`
+	Metrics     weeklyDigestMetrics `json:"metrics"`
+	Summary     string              `json:"summary"`
+	Model       string              `json:"model,omitempty"` // vazio = resumo sem IA
+	DeliveredTo []string            `json:"delivered_to"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+// GET /api/digests
+func (a *App) listWeeklyDigests(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.DB.Query(r.Context(), `
+SELECT id, period_start, period_end, metrics, summary, COALESCE(model,''), delivered_to, created_at
+  FROM weekly_digests WHERE org_id=$1
+ ORDER BY period_start DESC LIMIT 20`, orgID)
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	out := []weeklyDigest{}
+	for rows.Next() {
+		var (
+			d       weeklyDigest
+			metrics []byte
+		)
+		if err := rows.Scan(&d.ID, &d.PeriodStart, &d.PeriodEnd, &metrics, &d.Summary, &d.Model, &d.DeliveredTo, &d.CreatedAt); err != nil {
+			render.Error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		_ = json.Unmarshal(metrics, &d.Metrics)
+		out = append(out, d)
+	}
+	render.OK(w, map[string]any{"items": out})
+}
+
+type weeklyDigestPayload struct {
+	PeriodStart time.Time `json:"period_start"`
+	Manual      bool      `json:"manual,omitempty"`
+}
+
+// POST /api/digests/run
+func (a *App) runWeeklyDigestNow(w http.ResponseWriter, r *http.Request) {
+	orgID, _, err := tenantOf(r)
+	if err != nil {
+		render.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	start, _ := digestWeek(time.Now())
+	id, err := a.enqueueJob(r.Context(), orgID, jobWeeklyDigestSend, weeklyDigestPayload{PeriodStart: start, Manual: true}, time.Time{})
+	if err != nil {
+		render.Error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.Accepted(w, map[string]any{"job_id": id, "period_start": start})
+}
+
+func (a *App) weeklyDigestLoop(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for range t.C {
+		a.enqueueDueDigests()
+	}
+}
+
+// enqueueDueDigests reserva a semana de cada org cujo horário já passou
+// (last_period_start) e enfileira o job; a reserva vale entre réplicas.
+func (a *App) enqueueDueDigests() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	now := time.Now()
+	start, end := digestWeek(now)
+	rows, err := a.DB.Query(ctx, `
+SELECT o.id, COALESCE(s.weekday, 1), COALESCE(s.hour, 8)
+  FROM orgs o
+  LEFT JOIN weekly_digest_settings s ON s.org_id = o.id
+ WHERE COALESCE(s.active, TRUE) AND (s.last_period_start IS NULL OR s.last_period_start < $1)`, start)
+	if err != nil {
+		log.Printf("weekly digest: %v", err)
+		return
+	}
+	var due []int64
+	for rows.Next() {
+		var orgID int64
+		s := defaultDigestSettings()
+		if err := rows.Scan(&orgID, &s.Weekday, &s.Hour); err != nil {
+			log.Printf("weekly digest: %v", err)
+			continue
+		}
+		if !now.Before(s.dueAt(end)) {
+			due = append(due, orgID)
+		}
+	}
+	rows.Close()
+	for _, orgID := range due {
+		tag, err := a.DB.Exec(ctx, `
+INSERT INTO weekly_digest_settings (org_id, last_period_start) VALUES ($1, $2)
+ON CONFLICT (org_id) DO UPDATE SET last_period_start = EXCLUDED.last_period_start
+ WHERE weekly_digest_settings.last_period_start IS NULL OR weekly_digest_settings.last_period_start < EXCLUDED.last_period_start`,
+			orgID, start)
+		if err != nil {
+			log.Printf("weekly digest org=%d: %v", orgID, err)
+			continue
+		}
+		if tag.RowsAffected() == 0 {
+			continue // outra réplica pegou
+		}
+		if _, err := a.enqueueJob(ctx, orgID, jobWeeklyDigestSend, weeklyDigestPayload{PeriodStart: start}, time.Time{}); err != nil {
+			log.Printf("weekly digest org=%d: %v", orgID, err)
+		}
+	}
+}
+
+type digestProduct struct {
+	Title        string `json:"title"`
+	Units        int64  `json:"units"`
+	RevenueCents int64  `json:"revenue_cents"`
+}
+
+type digestHour struct {
+	Hour   int   `json:"hour"`
+	Orders int64 `json:"orders"`
+}
+
+type weeklyDigestMetrics struct {
+	Orders           int64            `json:"orders"`
+	RevenueCents     int64            `json:"revenue_cents"`
+	AvgTicketCents   int64            `json:"avg_ticket_cents"`
+	PrevOrders       int64            `json:"prev_orders"`
+	PrevRevenueCents int64            `json:"prev_revenue_cents"`
+	NewLeads         int64            `json:"new_leads"`
+	TopProducts      []digestProduct  `json:"top_products"`
+	BestHours        []digestHour     `json:"best_hours"`
+	StuckLeads       int64            `json:"stuck_leads"`
+	StuckByStage     map[string]int64 `json:"stuck_by_stage"`
+}
+
+func (m weeklyDigestMetrics) empty() bool {
+	return m.Orders == 0 && m.PrevOrders == 0 && m.NewLeads == 0 && m.StuckLeads == 0
+}
+
+// weeklyDigestMetrics junta os números da org (todos os flows) no período.
+func (a *App) weeklyDigestMetrics(ctx context.Context, orgID int64, start, end time.Time) (weeklyDigestMetrics, error) {
+	m := weeklyDigestMetrics{TopProducts: []digestProduct{}, BestHours: []digestHour{}, StuckByStage: map[string]int64{}}
+	err := a.DB.QueryRow(ctx, `
+SELECT COUNT(*) FILTER (WHERE created_at >= $2),
+       COALESCE(SUM(total_cents) FILTER (WHERE created_at >= $2), 0),
+       COUNT(*) FILTER (WHERE created_at < $2),
+       COALESCE(SUM(total_cents) FILTER (WHERE created_at < $2), 0)
+  FROM orders
+ WHERE org_id=$1 AND `+digestSoldSQL+` AND created_at >= $2 - INTERVAL '7 days' AND created_at < $3`,
+		orgID, start, end).Scan(&m.Orders, &m.RevenueCents, &m.PrevOrders, &m.PrevRevenueCents)
+	if err != nil {
+		return m, err
+	}
+	if m.Orders > 0 {
+		m.AvgTicketCents = m.RevenueCents / m.Orders
+	}
+	if err := a.DB.QueryRow(ctx, `SELECT COUNT(*) FROM leads WHERE org_id=$1 AND created_at >= $2 AND created_at < $3`,
+		orgID, start, end).Scan(&m.NewLeads); err != nil {
+		return m, err
+	}
+
+	rows, err := a.DB.Query(ctx, `
+SELECT p.title, SUM(oi.qty), SUM(oi.qty * oi.unit_price_cents)
+  FROM order_items oi
+  JOIN orders o ON o.id = oi.order_id
+  JOIN products p ON p.id = oi.product_id
+ WHERE o.org_id=$1 AND o.`+digestSoldSQL+` AND o.created_at >= $2 AND o.created_at < $3
+ GROUP BY p.id, p.title ORDER BY 2 DESC LIMIT 5`, orgID, start, end)
+	if err != nil {
+		return m, err
+	}
+	for rows.Next() {
+		var p digestProduct
+		if err := rows.Scan(&p.Title, &p.Units, &p.RevenueCents); err != nil {
+			rows.Close()
+			return m, err
+		}
+		m.TopProducts = append(m.TopProducts, p)
+	}
+	rows.Close()
+
+	rows, err = a.DB.Query(ctx, `
+SELECT EXTRACT(HOUR FROM created_at AT TIME ZONE $4)::int, COUNT(*)
+  FROM orders
+ WHERE org_id=$1 AND `+digestSoldSQL+` AND created_at >= $2 AND created_at < $3
+ GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT 3`, orgID, start, end, appointmentLoc().String())
+	if err != nil {
+		return m, err
+	}
+	for rows.Next() {
+		var h digestHour
+		if err := rows.Scan(&h.Hour, &h.Orders); err != nil {
+			rows.Close()
+			return m, err
+		}
+		m.BestHours = append(m.BestHours, h)
+	}
+	rows.Close()
+
+	rows, err = a.DB.Query(ctx, `
+SELECT stage, COUNT(*) FROM leads
+ WHERE org_id=$1 AND stage IN ('novo','contato','qualificado','proposta','negociacao')
+   AND COALESCE(stage_changed_at, created_at) < $2
+ GROUP BY stage`, orgID, end.Add(-weeklyDigestStuckAfter()))
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			stage string
+			n     int64
+		)
+		if err := rows.Scan(&stage, &n); err != nil {
+			return m, err
+		}
+		m.StuckByStage[stage] = n
+		m.StuckLeads += n
+	}
+	return m, rows.Err()
+}
+
+func (a *App) runWeeklyDigest(ctx context.Context, j job) error {
+	var p weeklyDigestPayload
+	if err := j.decode(&p); err != nil {
+		return err
+	}
+	if j.OrgID == nil {
+		return permanentJobError(errors.New("weekly digest without org"))
+	}
+	orgID := *j.OrgID
+	start := p.PeriodStart.In(appointmentLoc())
+	end := start.AddDate(0, 0, 7)
+
+	s, err := a.loadDigestSettings(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if !s.Active && !p.Manual {
+		return nil
+	}
+	m, err := a.weeklyDigestMetrics(ctx, orgID, start, end)
+	if err != nil {
+		return err
+	}
+	if m.empty() && !p.Manual {
+		return nil
+	}
+	var orgName string
+	_ = a.DB.QueryRow(ctx, `SELECT COALESCE(name,'') FROM orgs WHERE id=$1`, orgID).Scan(&orgName)
+
+	summary, model := a.digestSummary(ctx, orgID, orgName, start, end, m)
+	delivered, err := a.deliverWeeklyDigest(ctx, orgID, s, orgName, start, summary)
+
+	metrics, _ := json.Marshal(m)
+	if _, dbErr := a.DB.Exec(ctx, `
+INSERT INTO weekly_digests (org_id, period_start, period_end, metrics, summary, model, delivered_to)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6,''), $7)
+ON CONFLICT (org_id, period_start) DO UPDATE SET
+  metrics=EXCLUDED.metrics, summary=EXCLUDED.summary, model=EXCLUDED.model,
+  delivered_to=EXCLUDED.delivered_to, created_at=NOW()`,
+		orgID, start, end, metrics, summary, model, delivered); dbErr != nil {
+		log.Printf("weekly digest org=%d: %v", orgID, dbErr)
+	}
+	// nenhum canal entregou: tenta de novo
+	if len(delivered) == 0 {
+		if err == nil {
+			err = errors.New("no delivery channel available")
+		}
+		return err
+	}
+	if err != nil {
+		log.Printf("weekly digest org=%d: %v", orgID, err)
+	}
+	return nil
+}
+
+// digestSummary pede o texto ao modelo do tenant; model vazio indica o
+// resumo montado sem IA.
+func (a *App) digestSummary(ctx context.Context, orgID int64, orgName string, start, end time.Time, m weeklyDigestMetrics) (text, model string) {
+	fallback := digestPlainText(orgName, start, end, m)
+	if exceeded, _, _ := a.aiBudgetExceeded(ctx, orgID); exceeded {
+		return fallback, ""
+	}
+	provider, model, err := a.llmFor(ctx, orgID, 0) // ai_llm.go
+	if err != nil {
+		log.Printf("weekly digest org=%d: %v", orgID, err)
+		return fallback, ""
+	}
+	data, _ := json.Marshal(map[string]any{
+		"empresa":  orgName,
+		"periodo":  start.Format("02/01") + " a " + end.AddDate(0, 0, -1).Format("02/01"),
+		"fuso":     appointmentLoc().String(),
+		"metricas": m,
+	})
+	system := "Você é analista de vendas de um pequeno negócio que vende pelo WhatsApp. " +
+		"Escreva em português do Brasil o resumo semanal para o dono, em até 12 linhas curtas, " +
+		"sem markdown além de *negrito*: vendas e faturamento comparados à semana anterior, " +
+		"produtos de destaque, melhores horários, leads parados e, no fim, duas ou três sugestões práticas. " +
+		"Valores em centavos devem virar reais (R$). Use só os números fornecidos."
+	resp, err := provider.Chat(ctx, openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: system},
+			{Role: openai.ChatMessageRoleUser, Content: string(data)},
+		},
+		Temperature: 0.4,
+	})
+	if err == nil {
+		a.recordAIUsage(orgID, 0, "weekly_digest", resp.Model, resp.Usage)
+	}
+	if err != nil || len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		if err != nil {
+			log.Printf("weekly digest org=%d: llm: %v", orgID, err)
+		}
+		return fallback, ""
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), resp.Model
+}
+
+func digestMoney(cents int64) string {
+	return fmt.Sprintf("R$ %.2f", float64(cents)/100)
+}
+
+// digestPlainText é o resumo sem IA.
+func digestPlainText(orgName string, start, end time.Time, m weeklyDigestMetrics) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Resumo da semana %s a %s*", start.Format("02/01"), end.AddDate(0, 0, -1).Format("02/01"))
+	if orgName != "" {
+		fmt.Fprintf(&b, " — %s", orgName)
+	}
+	fmt.Fprintf(&b, "\nVendas: %d (semana anterior: %d)\n", m.Orders, m.PrevOrders)
+	fmt.Fprintf(&b, "Faturamento: %s (semana anterior: %s)\n", digestMoney(m.RevenueCents), digestMoney(m.PrevRevenueCents))
+	if m.Orders > 0 {
+		fmt.Fprintf(&b, "Ticket médio: %s\n", digestMoney(m.AvgTicketCents))
+	}
+	fmt.Fprintf(&b, "Leads novos: %d\n", m.NewLeads)
+	if len(m.TopProducts) > 0 {
+		b.WriteString("Mais vendidos:\n")
+		for _, p := range m.TopProducts {
+			fmt.Fprintf(&b, "• %s — %d un. (%s)\n", p.Title, p.Units, digestMoney(p.RevenueCents))
+		}
+	}
+	if len(m.BestHours) > 0 {
+		hours := make([]string, 0, len(m.BestHours))
+		for _, h := range m.BestHours {
+			hours = append(hours, fmt.Sprintf("%02dh", h.Hour))
+		}
+		fmt.Fprintf(&b, "Melhores horários: %s\n", strings.Join(hours, ", "))
+	}
+	if m.StuckLeads > 0 {
+		fmt.Fprintf(&b, "Leads parados há mais de uma semana: %d\n", m.StuckLeads)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// deliverWeeklyDigest envia pelos canais configurados e devolve os destinos
+// que receberam.
+func (a *App) deliverWeeklyDigest(ctx context.Context, orgID int64, s digestSettings, orgName string, start time.Time, text string) ([]string, error) {
+	delivered := []string{}
+	var errs []error
+	if s.Email {
+		rows, err := a.DB.Query(ctx, `
+SELECT email FROM public.users WHERE org_id=$1 AND role='owner' AND COALESCE(email,'') <> ''`, orgID)
+		if err != nil {
+			return delivered, err
+		}
+		var to []string
+		for rows.Next() {
+			var email string
+			if err := rows.Scan(&email); err == nil {
+				to = append(to, email)
+			}
+		}
+		rows.Close()
+		subject := "Resumo da semana de " + start.Format("02/01")
+		if orgName != "" {
+			subject += " — " + orgName
+		}
+		sender := emailSenderFromEnv()
+		for _, addr := range to {
+			if err := sender.Send(ctx, emailMessage{To: addr, Subject: subject, Text: strings.ReplaceAll(text, "*", "")}); err != nil {
+				errs = append(errs, fmt.Errorf("email %s: %w", addr, err))
+				continue
+			}
+			delivered = append(delivered, "email:"+addr)
+		}
+	}
+	if s.WhatsAppNumber != "" {
+		if err := a.sendDigestWA(ctx, orgID, s.WhatsAppNumber, text); err != nil {
+			errs = append(errs, fmt.Errorf("whatsapp: %w", err))
+		} else {
+			delivered = append(delivered, "whatsapp:"+s.WhatsAppNumber)
+		}
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// sendDigestWA manda pela instância conectada mais recente da org.
+func (a *App) sendDigestWA(ctx context.Context, orgID int64, number, text string) error {
+	var instance string
+	err := a.DB.QueryRow(ctx, `
+SELECT instance_id FROM public.wa_instances
+ WHERE org_id=$1 AND deleted_at IS NULL
+ ORDER BY (state = 'connected') DESC, updated_at DESC LIMIT 1`, orgID).Scan(&instance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("org has no whatsapp instance")
+	}
+	if err != nil {
+		return err
+	}
+	row, err := a.fetchWAInstance(ctx, instance)
+	if err != nil {
+		return err
+	}
+	_, status, err := a.sendWAText(ctx, row, row.Token, number, text)
+	if err == nil && status >= 300 {
+		err = fmt.Errorf("provider status %d", status)
+	}
+	return err
+}